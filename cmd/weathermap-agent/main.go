@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go-weathermap/internal/agent"
+	"go-weathermap/internal/logging"
+	"go-weathermap/internal/service"
+	"go-weathermap/internal/tracing"
+)
+
+func main() {
+	configDir := "agent"
+	if len(os.Args) > 1 {
+		configDir = os.Args[1]
+	}
+
+	logConfig, err := logging.ConfigFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid logging configuration: %v\n", err)
+		os.Exit(1)
+	}
+	logger := logging.New(logConfig, os.Stderr)
+	slog.SetDefault(logger)
+
+	traceConfig, tracingEnabled, err := tracing.ConfigFromEnv("weathermap-agent")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid tracing configuration: %v\n", err)
+		os.Exit(1)
+	}
+	var tracer *tracing.Tracer
+	if tracingEnabled {
+		tracer = tracing.New(traceConfig)
+		tracer.SetLogger(logger)
+		tracing.SetDefault(tracer)
+		logger.Info("tracing enabled", "endpoint", traceConfig.Endpoint, "sample_ratio", traceConfig.SampleRatio)
+	}
+
+	cfg, err := agent.ConfigFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid agent configuration: %v\n", err)
+		os.Exit(1)
+	}
+	rrdRoot, err := service.RRDRootFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	service.SetRRDRoot(rrdRoot)
+	datasources, err := service.LoadAllDataSources(configDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while load datasource: %v\n", err)
+		os.Exit(1)
+	}
+	limits, err := service.ResourceLimitsFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid resource limits: %v\n", err)
+		os.Exit(1)
+	}
+	dsService := service.NewDataSourceServiceWithLimits(datasources, limits)
+	dsService.SetLogger(logger)
+	dsService.Start()
+	reloadInterval, err := service.ReloadIntervalFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if reloadInterval > 0 {
+		dsService.WatchDataSources(configDir, reloadInterval)
+	}
+
+	a, err := agent.New(cfg, dsService)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create agent: %v\n", err)
+		os.Exit(1)
+	}
+	a.SetLogger(logger)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	logger.Info("agent started", "agent", cfg.Name, "datasources", len(datasources), "server", cfg.Server, "push_interval", cfg.PushInterval)
+	a.Run(ctx)
+
+	stopCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if err := dsService.Stop(stopCtx); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to stop pollers: %v\n", err)
+	}
+	if tracer != nil {
+		if err := tracer.Shutdown(stopCtx); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to export traces: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"go-weathermap/internal/service"
+	"go-weathermap/internal/utils"
+
+	"gopkg.in/yaml.v3"
+)
+
+// runExport writes a map of the maps directory as YAML, as a PHP Weathermap .conf like
+// GET /maps/{name}/export?format=weathermap, or as a zip bundle with the icons of its nodes
+func runExport(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "yaml", "yaml, weathermap or bundle")
+	output := flags.String("o", "-", "output file, - writes to stdout")
+	common := addCommonFlags(flags)
+	flags.Usage = usage(flags, "export <map> [--format yaml|weathermap|bundle] [-o file] [flags]")
+	positional := parseArgs(flags, args)
+	if len(positional) != 1 {
+		flags.Usage()
+		return 2
+	}
+	mapName := positional[0]
+	if *format != "yaml" && *format != service.ExportFormatWeathermap && *format != service.ExportFormatBundle {
+		fmt.Fprintf(os.Stderr, "unsupported format: %s, use yaml, weathermap or bundle\n", *format)
+		return 2
+	}
+
+	if _, err := common.apply(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	logger, err := newLogger()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	mapService, err := common.newMapService(common.mapsDirectory(""), logger)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+
+	content, err := exportMap(mapService, mapName, *format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	if *output == "-" {
+		_, err = os.Stdout.Write(content)
+	} else {
+		err = utils.WriteFileAtomic(*output, content)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func exportMap(mapService *service.MapService, mapName, format string) ([]byte, error) {
+	switch format {
+	case service.ExportFormatWeathermap:
+		return mapService.ExportWeathermapConf(mapName)
+	case service.ExportFormatBundle:
+		return mapService.ExportBundle(mapName)
+	}
+	m, err := mapService.GetMap(mapName)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(m.WithoutDefaults())
+}
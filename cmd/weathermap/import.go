@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/service"
+)
+
+// runImport validates a map file and adds it to the maps directory, like PUT /maps/{name}
+// does, so maps kept elsewhere go through the same checks as maps saved by the API. A zip
+// bundle of `weathermap export --format bundle`, or a tar.gz of GET /maps/{name}/bundle, also
+// adds the icons of the map.
+func runImport(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	name := flags.String("name", "", "name of the map (default the file name without extension)")
+	force := flags.Bool("force", false, "replace the map when it exists")
+	common := addCommonFlags(flags)
+	flags.Usage = usage(flags, "import <map-file|bundle.zip|bundle.tar.gz> [--name name] [--force] [flags]")
+	positional := parseArgs(flags, args)
+	if len(positional) != 1 {
+		flags.Usage()
+		return 2
+	}
+	path := positional[0]
+	mapName := *name
+	if mapName == "" {
+		mapName = strings.TrimSuffix(filepath.Base(path), ".tar.gz")
+		mapName = strings.TrimSuffix(mapName, filepath.Ext(mapName))
+	}
+	if err := service.ValidMapName(mapName); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+
+	if _, err := common.apply(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	logger, err := newLogger()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	configDir := common.mapsDirectory("")
+	mapService, err := common.newMapService(configDir, logger)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	bundle := &service.Bundle{}
+	if service.IsBundle(content) {
+		bundle, err = service.ReadBundle(content)
+	} else {
+		bundle.Map, err = config.NewParser().ParseYAML(bytes.NewReader(content))
+		if err != nil {
+			err = fmt.Errorf("invalid YAML: %w", err)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return 1
+	}
+	if _, err := os.Stat(filepath.Join(configDir, mapName+".yaml")); err == nil && !*force {
+		fmt.Fprintf(os.Stderr, "map %s already exists in %s, use --force to replace it\n", mapName, configDir)
+		return 1
+	}
+	created, err := mapService.ImportBundle(mapName, bundle)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return 1
+	}
+	logger.Info("map imported", "map", mapName, "file", filepath.Join(configDir, mapName+".yaml"), "created", created)
+	return 0
+}
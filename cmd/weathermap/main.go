@@ -1,46 +1,166 @@
 package main
 
 import (
+	"cmp"
+	"flag"
 	"fmt"
+	"log/slog"
 	"os"
 
-	"go-weathermap/internal/api"
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/logging"
 	"go-weathermap/internal/service"
 )
 
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands = []command{
+	{"serve", "run the HTTP server (default)", runServe},
+	{"validate", "check maps and datasources, for CI", runValidate},
+	{"render", "write a map to an SVG, PNG or PDF file", runRender},
+	{"import", "add a map file to the maps directory", runImport},
+	{"export", "write a map as YAML or PHP Weathermap .conf", runExport},
+	{"version", "print the build of the binary", runVersion},
+}
+
 func main() {
-	configDir := "maps"
-	if len(os.Args) > 1 {
-		configDir = os.Args[1]
+	args := os.Args[1:]
+	if len(args) > 0 {
+		if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+			printCommands()
+			return
+		}
+		for _, cmd := range commands {
+			if args[0] == cmd.name {
+				os.Exit(cmd.run(args[1:]))
+			}
+		}
+	}
+	// without a subcommand the flags and directory argument are the server's, as before
+	// subcommands existed
+	os.Exit(runServe(args))
+}
+
+func printCommands() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags] [args]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the flags of a command.\n", os.Args[0])
+}
+
+// usage prints the synopsis of a subcommand followed by its flags
+func usage(flags *flag.FlagSet, synopsis string) func() {
+	return func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s\n", os.Args[0], synopsis)
+		flags.PrintDefaults()
 	}
+}
+
+// parseArgs parses flags placed before, between and after the positional arguments, which
+// it returns
+func parseArgs(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		_ = flags.Parse(args)
+		if flags.NArg() == 0 {
+			return positional
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+// commonFlags are the flags of every subcommand working on the maps directory
+type commonFlags struct {
+	configFile *string
+	mapsDir    *string
+	iconsDir   *string
+}
 
-	datasources, err := service.LoadAllDataSources(configDir)
+func addCommonFlags(flags *flag.FlagSet) commonFlags {
+	return commonFlags{
+		configFile: flags.String("config", "", "server configuration file (default "+config.DefaultServerConfigFile+" when it exists)"),
+		mapsDir:    flags.String("maps-dir", "", "directory of the maps, overrides WEATHERMAP_MAPS_DIR (default maps)"),
+		iconsDir:   flags.String("icons-dir", "", "directory of the node icons, overrides WEATHERMAP_ICONS_DIR"),
+	}
+}
+
+// apply sets the variables of the server configuration file, see applyServerConfig, and the
+// directory of the rrd files, which validating the datasources already needs
+func (c commonFlags) apply() (string, error) {
+	file, err := applyServerConfig(*c.configFile)
+	if err != nil {
+		return "", err
+	}
+	rrdRoot, err := service.RRDRootFromEnv()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error while load datasource: %v\n", err)
-		os.Exit(1)
+		return "", err
 	}
-	dsService := service.NewDataSourceService(datasources)
-	dsService.Start()
+	service.SetRRDRoot(rrdRoot)
+	return file, nil
+}
 
+// mapsDirectory is --maps-dir, then the directory argument, then WEATHERMAP_MAPS_DIR
+func (c commonFlags) mapsDirectory(arg string) string {
+	return cmp.Or(*c.mapsDir, arg, os.Getenv("WEATHERMAP_MAPS_DIR"), "maps")
+}
+
+// newMapService creates the map service of configDir with the settings of the environment
+func (c commonFlags) newMapService(configDir string, logger *slog.Logger) (*service.MapService, error) {
 	mapService := service.NewMapService(configDir)
+	mapService.SetLogger(logger)
+	if dir := cmp.Or(*c.iconsDir, os.Getenv("WEATHERMAP_ICONS_DIR")); dir != "" {
+		mapService.SetIconsDir(dir)
+	}
+	mapDeadline, err := service.MapDeadlineFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	mapService.SetMapDeadline(mapDeadline)
+	linkRefsMode, err := service.LinkRefsModeFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	mapService.SetLinkRefsMode(linkRefsMode)
+	cipher, err := service.VariablesCipherFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	mapService.SetVariablesCipher(cipher)
+	return mapService, nil
+}
+
+// newLogger creates the logger configured by the environment and makes it the default one
+func newLogger() (*slog.Logger, error) {
+	logConfig, err := logging.ConfigFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("invalid logging configuration: %w", err)
+	}
+	logger := logging.New(logConfig, os.Stderr)
+	slog.SetDefault(logger)
+	return logger, nil
+}
 
-	server := api.NewServer(mapService, dsService)
-
-	fmt.Println("Starting weathermap server on :8080")
-	fmt.Println("API endpoints:")
-	fmt.Println("  GET    /health           				- Check service health")
-	fmt.Println("  GET    /maps              				- list maps")
-	fmt.Println("  POST   /maps              				- create map")
-	fmt.Println("  GET    /maps/{mapName}     				- get map with data")
-	fmt.Println("  DELETE /maps/{mapName}      				- delete map")
-	fmt.Println("  PATCH  /maps/{mapName}      				- edit map properties")
-	fmt.Println("  POST   /maps/{mapName}/nodes 			- add node")
-	fmt.Println("  POST   /maps/{mapName}/nodes/bulk 		- add multiple nodes")
-	fmt.Println("  DELETE /maps/{mapName}/nodes/{nodeName} 	- delete node")
-	fmt.Println("  DELETE /maps/{mapName}/nodes/bulk 		- delete multiple nodes")
-	fmt.Println("  PATCH  /maps/{mapName}/nodes/{nodeName} 	- edit node")
-	fmt.Println("  POST   /maps/{mapName}/links 			- add link")
-	fmt.Println("  DELETE /maps/{mapName}/links/{linkName} 	- delete link")
-
-	server.Start(":8080")
+// applyServerConfig reads the server configuration file, or weathermap.yaml when it exists,
+// and sets its variables which aren't in the environment. It returns the file read.
+func applyServerConfig(path string) (string, error) {
+	if path == "" {
+		if _, err := os.Stat(config.DefaultServerConfigFile); err != nil {
+			return "", nil
+		}
+		path = config.DefaultServerConfigFile
+	}
+	serverConfig, err := config.LoadServerConfig(path)
+	if err != nil {
+		return "", err
+	}
+	if _, err := serverConfig.SetEnvDefaults(); err != nil {
+		return "", fmt.Errorf("failed to apply server config: %w", err)
+	}
+	return path, nil
 }
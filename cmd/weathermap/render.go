@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/render"
+	"go-weathermap/internal/utils"
+)
+
+// runRender writes a map to an image file without the HTTP server, for cron jobs generating
+// static pages. The format follows the extension of the output: .svg, .png or .pdf.
+func runRender(args []string) int {
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	output := flags.String("o", "", "output file, .svg, .png or .pdf, - writes SVG to stdout (default <map>.svg)")
+	static := flags.Bool("static", false, "render the configuration only, without polling metrics")
+	wait := flags.Duration("wait", 0, "how long to poll before rendering (default two poll intervals of the slowest datasource)")
+	width := flags.Int("width", 0, "width of PNG output in pixels (default the map width)")
+	common := addCommonFlags(flags)
+	flags.Usage = usage(flags, "render <map> [-o out.svg] [flags]")
+	positional := parseArgs(flags, args)
+	if len(positional) != 1 {
+		flags.Usage()
+		return 2
+	}
+	mapName := positional[0]
+
+	if _, err := common.apply(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	logger, err := newLogger()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	mapService, err := common.newMapService(common.mapsDirectory(""), logger)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var mapWithData *config.MapWithData
+	if *static {
+		mapWithData, err = mapService.GetMapWithData(ctx, mapName, nil)
+	} else {
+		mapWithData, err = mapService.CollectMapData(ctx, mapName, *wait)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	path := cmp.Or(*output, mapName+".svg")
+	var buf bytes.Buffer
+	switch ext := strings.ToLower(filepath.Ext(path)); {
+	case path == "-" || ext == ".svg":
+		err = render.NewSVGRenderer(mapService.GetIconFile).Render(&buf, mapWithData)
+	case ext == ".png":
+		err = render.NewPNGRenderer(mapService.GetIconFile).Render(&buf, mapWithData, *width, 0)
+	case ext == ".pdf":
+		err = render.NewPDFRenderer(mapService.GetIconFile).Render(&buf, mapWithData, render.PDFOptions{})
+	default:
+		fmt.Fprintf(os.Stderr, "unsupported output format: %s, use .svg, .png or .pdf\n", path)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to render map: %v\n", err)
+		return 1
+	}
+
+	if path == "-" {
+		_, err = os.Stdout.Write(buf.Bytes())
+	} else {
+		err = utils.WriteFileAtomic(path, buf.Bytes())
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if path != "-" {
+		logger.Info("map rendered", "map", mapName, "file", path, "bytes", buf.Len(), "partial", mapWithData.Partial)
+	}
+	return 0
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go-weathermap/internal/api"
+	"go-weathermap/internal/auth"
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/service"
+	"go-weathermap/internal/tracing"
+)
+
+// runServe runs the HTTP server on the maps of a directory until SIGINT or SIGTERM
+func runServe(args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	common := addCommonFlags(flags)
+	listen := flags.String("listen", "", "listen address, overrides WEATHERMAP_LISTEN_ADDR (default :8080)")
+	strict := flags.Bool("strict", false, "refuse to start when a map or datasource of the config directory is invalid")
+	flags.Usage = usage(flags, "serve [flags] [config-dir]")
+	positional := parseArgs(flags, args)
+	if len(positional) > 1 {
+		flags.Usage()
+		return 2
+	}
+
+	serverConfigFile, err := common.apply()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	configDir := common.mapsDirectory(cmp.Or(positional...))
+	listenAddr := cmp.Or(*listen, os.Getenv("WEATHERMAP_LISTEN_ADDR"), ":8080")
+
+	logger, err := newLogger()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if serverConfigFile != "" {
+		logger.Info("server config loaded", "file", serverConfigFile)
+	}
+
+	traceConfig, tracingEnabled, err := tracing.ConfigFromEnv("weathermap")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid tracing configuration: %v\n", err)
+		return 1
+	}
+	var tracer *tracing.Tracer
+	if tracingEnabled {
+		tracer = tracing.New(traceConfig)
+		tracer.SetLogger(logger)
+		tracing.SetDefault(tracer)
+		logger.Info("tracing enabled", "endpoint", traceConfig.Endpoint, "sample_ratio", traceConfig.SampleRatio)
+	}
+
+	pollInterval, err := service.PollIntervalFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	service.SetDefaultPollInterval(pollInterval)
+	secretPatterns, err := service.SecretPatternsFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	config.SetSecretPatterns(secretPatterns)
+
+	report, err := service.ValidateConfigDir(configDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read config directory: %v\n", err)
+		return 1
+	}
+	report.Log(logger)
+	if *strict && report.Errors() > 0 {
+		fmt.Fprintf(os.Stderr, "Refusing to start with %d config errors (--strict)\n", report.Errors())
+		return 1
+	}
+	datasources, err := service.LoadAllDataSources(configDir)
+	if err != nil {
+		// reported above, the other datasources are polled
+		datasources = service.ValidDataSources(datasources)
+	}
+	limits, err := service.ResourceLimitsFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid resource limits: %v\n", err)
+		return 1
+	}
+	dsService := service.NewDataSourceServiceWithLimits(datasources, limits)
+	dsService.SetLogger(logger)
+	dsService.LoadLinkBandwidths(configDir)
+	clusterConfig, sharded, err := service.ClusterConfigFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid cluster configuration: %v\n", err)
+		return 1
+	}
+	if sharded {
+		if err := dsService.EnableSharding(clusterConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to enable sharding: %v\n", err)
+			return 1
+		}
+		logger.Info("sharded polling enabled", "self", clusterConfig.Self, "peers", clusterConfig.Peers)
+	}
+	dsService.Start()
+	reloadInterval, err := service.ReloadIntervalFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if reloadInterval > 0 {
+		dsService.WatchDataSources(configDir, reloadInterval)
+	}
+	discoveryInterval, err := service.DiscoveryIntervalFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if discoveryInterval > 0 {
+		dsService.WatchPrometheusTargets(configDir, discoveryInterval)
+	}
+
+	mapService, err := common.newMapService(configDir, logger)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if written := mapService.AssignMissingIDs(); len(written) > 0 {
+		logger.Info("ids assigned to maps", "maps", written)
+	}
+	dnsLabelInterval, err := service.DNSLabelIntervalFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if dnsLabelInterval > 0 {
+		mapService.WatchDNSLabels(dnsLabelInterval)
+	}
+	pingInterval, err := service.PingIntervalFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if pingInterval > 0 {
+		mapService.WatchNodeStatus(pingInterval)
+	}
+	urlCheckInterval, err := service.InfoURLCheckIntervalFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if urlCheckInterval > 0 {
+		mapService.WatchInfoURLs(urlCheckInterval)
+	}
+	alertInterval, err := service.AlertIntervalFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if alertInterval > 0 {
+		mapService.WatchAlerts(dsService, alertInterval)
+	}
+	netBoxConfig, netBoxEnabled, err := service.NetBoxConfigFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if netBoxEnabled {
+		mapService.EnableNetBox(netBoxConfig)
+		if netBoxConfig.SyncInterval > 0 {
+			mapService.WatchNetBox(dsService, netBoxConfig.SyncInterval)
+		}
+	}
+
+	historyRetention, historyEnabled, err := service.HistoryRetentionFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid history retention: %v\n", err)
+		return 1
+	}
+	if historyEnabled {
+		mapService.WatchHistory(dsService, historyRetention)
+	}
+	recentHistoryWindow, err := service.RecentHistoryWindowFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if recentHistoryWindow > 0 {
+		mapService.WatchRecentHistory(dsService, recentHistoryWindow)
+	}
+
+	server := api.NewServer(mapService, dsService)
+	server.SetLogger(logger)
+	sandboxEnabled, err := service.SandboxEnabledFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if sandboxEnabled {
+		mapService.WatchSandboxes()
+		server.EnableSandbox()
+	}
+	faultsEnabled, err := service.FaultsEnabledFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if faultsEnabled {
+		server.EnableFaults()
+	}
+	agentTokens, err := api.AgentTokensFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid agent tokens: %v\n", err)
+		return 1
+	}
+	server.SetAgentTokens(agentTokens)
+	embedOrigins, err := api.EmbedOriginsFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid embed origins: %v\n", err)
+		return 1
+	}
+	server.SetEmbedOrigins(embedOrigins)
+	server.SetMetricsToken(api.MetricsTokenFromEnv())
+	timeouts, err := api.TimeoutsFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	server.SetTimeouts(timeouts)
+	maxBodySize, err := api.MaxBodySizeFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	server.SetMaxBodySize(maxBodySize)
+	maxBulkBodySize, err := api.MaxBulkBodySizeFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	server.SetMaxBulkBodySize(maxBulkBodySize)
+	warmupTimeout, err := service.WarmupTimeoutFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	tlsConfig, tlsEnabled, err := api.TLSConfigFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid TLS configuration: %v\n", err)
+		return 1
+	}
+	if tlsEnabled {
+		if err := server.EnableTLS(tlsConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to enable TLS: %v\n", err)
+			return 1
+		}
+		// renewed certificates are picked up on SIGHUP
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				if err := server.ReloadTLS(); err != nil {
+					logger.Error("tls reload failed, keeping the previous certificate", "error", err)
+				} else {
+					logger.Info("tls certificate reloaded", "cert", tlsConfig.CertFile)
+				}
+			}
+		}()
+	}
+	adminConfig, adminEnabled, err := api.AdminConfigFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid diagnostics listener: %v\n", err)
+		return 1
+	}
+	oidcConfig, oidcEnabled, err := auth.ConfigFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid OIDC configuration: %v\n", err)
+		return 1
+	}
+	if oidcEnabled {
+		server.SetVerifier(auth.NewVerifier(oidcConfig))
+		logger.Info("oidc authentication enabled", "issuer", oidcConfig.Issuer, "audience", oidcConfig.Audience)
+	}
+
+	fmt.Println("API endpoints (also under /api/v1 with enveloped responses):")
+	fmt.Println("  GET    /health           				- Check service health")
+	fmt.Println("  GET    /ready           				- 503 until the maps warmed up")
+	fmt.Println("  GET    /version         				- build version, commit and enabled features")
+	fmt.Println("  GET    /auth/whoami      				- claims of the caller's token")
+	fmt.Println("  GET    /metrics          				- Prometheus metrics of the server")
+	fmt.Println("  GET    /maps              				- list maps")
+	fmt.Println("  POST   /maps              				- create map")
+	fmt.Println("  POST   /maps?template={name}				- create map from template")
+	fmt.Println("  POST   /maps/import?name={name}			- import map YAML (replace, dry_run)")
+	fmt.Println("  GET    /templates 						- map templates and their parameters")
+	fmt.Println("  GET    /maps/{mapName}     				- get map with data")
+	fmt.Println("  GET    /maps/{mapName}/render.svg		- render map as SVG")
+	fmt.Println("  GET    /maps/{mapName}/render.png		- render map as PNG")
+	fmt.Println("  GET    /maps/{mapName}/tiles/{z}/{x}/{y}.png	- map tiles for pan and zoom")
+	fmt.Println("  GET    /maps/{mapName}/links/{linkName}/snapshot.png - map cropped around a link")
+	fmt.Println("  GET    /maps/{mapName}/links/{linkName}/history	- link traffic of the last range for a graph")
+	fmt.Println("  GET    /maps/{mapName}/links/{linkName}/history/export - link history as CSV or Parquet")
+	fmt.Println("  POST   /maps/{mapName}/links/{linkName}/capture - start capturing raw datasource responses of a link")
+	fmt.Println("  GET    /maps/{mapName}/links/{linkName}/capture - captured raw datasource responses of a link")
+	fmt.Println("  DELETE /maps/{mapName}/links/{linkName}/capture - stop capturing a link")
+	fmt.Println("  GET    /maps/{mapName}/urls			- info URL checks")
+	fmt.Println("  GET    /maps/{mapName}/schedules		- schedules active now")
+	fmt.Println("  GET    /maps/{mapName}/alerts			- pending and firing alerts")
+	fmt.Println("  GET    /maps/{mapName}/export		- export map (format=yaml, weathermap, pdf, bundle)")
+	fmt.Println("  GET    /maps/{mapName}/bundle		- map and its icons as tar.gz")
+	fmt.Println("  POST   /maps/{mapName}/bundle		- import map bundle (replace, dry_run)")
+	fmt.Println("  GET    /maps/{mapName}/ws				- websocket with live link data")
+	fmt.Println("  GET    /maps/{mapName}/events			- server-sent events with map updates")
+	fmt.Println("  GET    /maps/{mapName}/embed			- iframe widget posting link data to the parent")
+	fmt.Println("  PUT    /maps/{mapName}      				- create or replace whole map")
+	fmt.Println("  DELETE /maps/{mapName}      				- delete map")
+	fmt.Println("  PATCH  /maps/{mapName}      				- edit map properties")
+	fmt.Println("  POST   /maps/{mapName}/nodes 			- add node")
+	fmt.Println("  POST   /maps/{mapName}/nodes/bulk 		- add multiple nodes")
+	fmt.Println("  DELETE /maps/{mapName}/nodes/{nodeName} 	- delete node")
+	fmt.Println("  DELETE /maps/{mapName}/nodes/bulk 		- delete multiple nodes")
+	fmt.Println("  PATCH  /maps/{mapName}/nodes/{nodeName} 	- edit node")
+	fmt.Println("  PATCH  /maps/{mapName}/nodes/bulk 		- edit multiple nodes")
+	fmt.Println("  POST   /maps/{mapName}/links 			- add link")
+	fmt.Println("  DELETE /maps/{mapName}/links/{linkName} 	- delete link")
+	fmt.Println("  PATCH  /maps/{mapName}/links/bulk 		- edit multiple links")
+	fmt.Println("  POST   /maps/{mapName}/path 			- shortest path between two nodes")
+	fmt.Println("  POST   /maps/{mapName}/simulate 		- what-if link/node failure")
+	fmt.Println("  POST   /maps/{mapName}/discover 		- LLDP/CDP topology discovery")
+	fmt.Println("  POST   /maps/{mapName}/netbox/sync 	- Sync nodes and links from NetBox")
+	fmt.Println("  GET    /maps/{mapName}/demands 			- traffic matrix")
+	fmt.Println("  PUT    /maps/{mapName}/demands 			- replace traffic matrix")
+	fmt.Println("  GET    /maps/{mapName}/planned 			- projected load of the traffic matrix")
+	fmt.Println("  GET    /maps/{mapName}/audit 			- changes of the map")
+	fmt.Println("  GET    /search?ip={address} 				- nodes and links owning an address")
+	fmt.Println("  *      /sandbox/maps/... 				- maps API in the caller's sandbox, maps expire after 24h")
+	fmt.Println("  GET    /audit 							- audit log of map changes")
+	fmt.Println("  GET    /schema/map.json 				- JSON Schema of map documents")
+	fmt.Println("  GET    /datasources/{name} 				- datasource with effective settings")
+	fmt.Println("  POST   /admin/faults 					- simulate link/datasource fault, with WEATHERMAP_FAULTS=true")
+	fmt.Println("  GET    /admin/pollers/slow 				- report of slow polling targets")
+	fmt.Println("  GET    /admin/pollers/status 			- poll state of datasources, degraded first")
+	fmt.Println("  GET    /admin/limits 					- resource limits and current usage")
+	fmt.Println("  GET    /admin/misconfigurations 			- datasource and interface lookups of links failing")
+	fmt.Println("  GET    /admin/lint 					- map validation and links seen above 100% utilization")
+	fmt.Println("  GET    /admin/history 					- history retention and storage usage")
+	fmt.Println("  POST   /admin/reload 					- reload TLS certificate, datasources and maps")
+	fmt.Println("  GET    /cluster/status 					- sharded polling peers and datasource owners")
+	fmt.Println("  GET    /agents 							- remote poller agents")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if warmupTimeout > 0 {
+		// /ready fails until the first polls are in, /health is up at once
+		server.SetReady(false)
+		go func() {
+			started := time.Now()
+			warmCtx, cancel := context.WithTimeout(ctx, warmupTimeout)
+			defer cancel()
+			if err := mapService.Warm(warmCtx, dsService); err != nil {
+				logger.Warn("warm-up incomplete", "error", err)
+			}
+			server.SetReady(true)
+			logger.Info("server ready", "warmup", time.Since(started).Round(time.Millisecond))
+		}()
+	}
+	if adminEnabled {
+		go func() {
+			if err := server.StartAdmin(ctx, adminConfig); err != nil {
+				logger.Error("diagnostics listener failed", "addr", adminConfig.Addr, "error", err)
+			}
+		}()
+	}
+	serveErr := server.Start(ctx, listenAddr)
+	if serveErr != nil {
+		fmt.Fprintf(os.Stderr, "Server error: %v\n", serveErr)
+	}
+
+	stopCtx, cancel := context.WithTimeout(context.Background(), api.ShutdownTimeout)
+	defer cancel()
+	if err := dsService.Stop(stopCtx); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to stop pollers: %v\n", err)
+	}
+	if err := mapService.Stop(stopCtx); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to stop map jobs: %v\n", err)
+	}
+	if tracer != nil {
+		if err := tracer.Shutdown(stopCtx); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to export traces: %v\n", err)
+		}
+	}
+	if serveErr != nil {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go-weathermap/internal/service"
+)
+
+// runValidate checks the maps of a directory or a single map file, for CI pipelines keeping
+// maps in a repository. It exits with 1 on errors, warnings alone pass.
+func runValidate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	common := addCommonFlags(flags)
+	flags.Usage = usage(flags, "validate [--json] [config-dir|map-file]")
+	positional := parseArgs(flags, args)
+	if len(positional) > 1 {
+		flags.Usage()
+		return 2
+	}
+	if _, err := common.apply(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	path := cmp.Or(cmp.Or(positional...), common.mapsDirectory(""))
+
+	info, err := os.Stat(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	var report service.ConfigReport
+	dir := path
+	if info.IsDir() {
+		report, err = service.ValidateConfigDir(path)
+	} else {
+		report, err = service.ValidateConfigFile(path)
+		dir = filepath.Dir(path)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(report)
+	} else {
+		for _, issue := range report.Issues {
+			location := filepath.Join(dir, issue.File)
+			if issue.Line > 0 {
+				location = fmt.Sprintf("%s:%d", location, issue.Line)
+			}
+			fmt.Printf("%s: %s: %s\n", location, issue.Severity, issue.Message)
+		}
+		fmt.Printf("%d maps, %d datasources: %d errors, %d warnings\n",
+			report.Maps, report.Datasources, report.Errors(), len(report.Issues)-report.Errors())
+	}
+	if report.Errors() > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"go-weathermap/internal/version"
+)
+
+// runVersion prints the build of the binary
+func runVersion(args []string) int {
+	flags := flag.NewFlagSet("version", flag.ExitOnError)
+	flags.Usage = usage(flags, "version")
+	if len(parseArgs(flags, args)) > 0 {
+		flags.Usage()
+		return 2
+	}
+	info := version.Get()
+	fmt.Printf("weathermap %s", info.Version)
+	if info.Commit != "" {
+		fmt.Printf(" (%s", info.Commit)
+		if info.Modified {
+			fmt.Print(", modified")
+		}
+		fmt.Print(")")
+	}
+	fmt.Printf(" %s %s\n", info.GoVersion, info.Platform)
+	return 0
+}
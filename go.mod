@@ -3,4 +3,9 @@ module go-weathermap
 go 1.24.2
 
 require gopkg.in/yaml.v3 v3.0.1
+
 require github.com/gosnmp/gosnmp v1.42.0
+
+require golang.org/x/image v0.24.0
+
+require github.com/andybalholm/brotli v1.2.5
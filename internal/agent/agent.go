@@ -0,0 +1,152 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go-weathermap/internal/service"
+	"go-weathermap/internal/tracing"
+)
+
+const (
+	DefaultPushInterval = 10 * time.Second
+	PushPath            = "/agents/push"
+)
+
+// Config of a remote poller agent, polling devices the central server can't reach
+type Config struct {
+	Name         string
+	Server       string // central server base URL, https://weathermap.example.com
+	Token        string
+	PushInterval time.Duration
+	CAFile       string // optional CA bundle to verify the server certificate
+}
+
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		Name:         os.Getenv("WEATHERMAP_AGENT_NAME"),
+		Server:       strings.TrimRight(os.Getenv("WEATHERMAP_AGENT_SERVER"), "/"),
+		Token:        os.Getenv("WEATHERMAP_AGENT_TOKEN"),
+		PushInterval: DefaultPushInterval,
+		CAFile:       os.Getenv("WEATHERMAP_AGENT_CA_FILE"),
+	}
+	if value := os.Getenv("WEATHERMAP_AGENT_PUSH_INTERVAL"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid WEATHERMAP_AGENT_PUSH_INTERVAL: %s", value)
+		}
+		cfg.PushInterval = d
+	}
+	return cfg, cfg.Validate()
+}
+
+func (c Config) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("agent name is required")
+	}
+	if !strings.HasPrefix(c.Server, "https://") && !strings.HasPrefix(c.Server, "http://") {
+		return fmt.Errorf("server must be an http(s) URL, got '%s'", c.Server)
+	}
+	if c.Token == "" {
+		return fmt.Errorf("agent token is required")
+	}
+	if c.PushInterval <= 0 {
+		return fmt.Errorf("push interval must be greater than 0")
+	}
+	return nil
+}
+
+type Agent struct {
+	cfg       Config
+	dsService *service.DataSourceService
+	client    *http.Client
+	logger    *slog.Logger
+}
+
+func New(cfg Config, dsService *service.DataSourceService) (*Agent, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return &Agent{
+		cfg:       cfg,
+		dsService: dsService,
+		client:    &http.Client{Timeout: cfg.PushInterval, Transport: transport},
+		logger:    slog.Default(),
+	}, nil
+}
+
+func (a *Agent) SetLogger(logger *slog.Logger) {
+	a.logger = logger
+}
+
+// Run pushes polled values every push interval until ctx is done
+func (a *Agent) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.cfg.PushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.Push(ctx); err != nil {
+				a.logger.Error("agent push failed", "agent", a.cfg.Name, "server", a.cfg.Server, "error", err)
+			}
+		}
+	}
+}
+
+func (a *Agent) Push(ctx context.Context) (err error) {
+	ctx, span := tracing.StartClient(ctx, "agent push", "agent", a.cfg.Name, "server", a.cfg.Server)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	body, err := json.Marshal(service.AgentPush{
+		Agent:       a.cfg.Name,
+		SentAt:      time.Now(),
+		Datasources: a.dsService.LocalSnapshot(),
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.cfg.Server+PushPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+a.cfg.Token)
+	tracing.Inject(ctx, req.Header)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"go-weathermap/internal/auth"
+	"go-weathermap/internal/service"
+	"go-weathermap/internal/utils"
+)
+
+// mapRole returns the role of the caller on a map, ok is false when access isn't restricted:
+// without authentication or without role assignments
+func (s *Server) mapRole(r *http.Request, mapName string) (role string, ok bool, err error) {
+	claims, authenticated := auth.ClaimsFromContext(r.Context())
+	if !authenticated {
+		return "", false, nil
+	}
+	policy, err := s.mapService.AccessPolicy()
+	if err != nil || policy == nil {
+		return "", false, err
+	}
+	principal := service.Principal{Subject: claims.Subject(), Groups: claims.Strings(policy.GroupsClaim)}
+	return policy.Role(principal, mapName), true, nil
+}
+
+// authorizeMap responds 403 unless the caller holds at least the required role on the map
+func (s *Server) authorizeMap(w http.ResponseWriter, r *http.Request, mapName, required string) bool {
+	role, restricted, err := s.mapRole(r, mapName)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return false
+	}
+	if restricted && !service.RoleAllows(role, required) {
+		utils.RespondWithError(w, http.StatusForbidden, fmt.Sprintf("forbidden: requires %s role on map %s", required, mapName))
+		return false
+	}
+	return true
+}
+
+// authorizeAdmin responds 403 unless the caller is admin of every map. Server-wide operations
+// like fault simulation need it.
+func (s *Server) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	return s.authorizeAllMaps(w, r, service.RoleAdmin)
+}
+
+// authorizeAllMaps responds 403 unless the caller holds at least the required role on every
+// map, granted by a rule for the maps "*"
+func (s *Server) authorizeAllMaps(w http.ResponseWriter, r *http.Request, required string) bool {
+	role, restricted, err := s.mapRole(r, "*")
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return false
+	}
+	if restricted && !service.RoleAllows(role, required) {
+		utils.RespondWithError(w, http.StatusForbidden, fmt.Sprintf("forbidden: requires %s role on every map", required))
+		return false
+	}
+	return true
+}
+
+// canView reports whether the caller may see a map in listings and search results
+func (s *Server) canView(r *http.Request, mapName string) (bool, error) {
+	role, restricted, err := s.mapRole(r, mapName)
+	if err != nil {
+		return false, err
+	}
+	return !restricted || service.RoleAllows(role, service.RoleViewer), nil
+}
+
+// dataSourceViewer returns whether the caller may see a datasource: one defined or used by
+// the links of a map it can view
+func (s *Server) dataSourceViewer(r *http.Request) (func(name string) bool, error) {
+	if _, restricted, err := s.mapRole(r, "*"); err != nil || !restricted {
+		return func(string) bool { return true }, err
+	}
+	usedBy, err := s.mapService.DataSourceMaps()
+	if err != nil {
+		return nil, err
+	}
+	visible := make(map[string]bool, len(usedBy))
+	for name, maps := range usedBy {
+		for _, mapName := range maps {
+			ok, err := s.canView(r, mapName)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				visible[name] = true
+				break
+			}
+		}
+	}
+	return func(name string) bool { return visible[name] }, nil
+}
+
+// requiredRole of a request to /maps/{name}/...: reading needs viewer, path and failure
+// simulations only compute and need viewer too, deleting a whole map needs admin and any
+// other change needs editor
+func requiredRole(method string, parts []string) string {
+	switch {
+	case method == "GET":
+		return service.RoleViewer
+	case method == "POST" && len(parts) == 2 && (parts[1] == "path" || parts[1] == "simulate"):
+		return service.RoleViewer
+	case method == "DELETE" && len(parts) == 1:
+		return service.RoleAdmin
+	}
+	return service.RoleEditor
+}
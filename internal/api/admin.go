@@ -0,0 +1,274 @@
+package api
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/service"
+	"go-weathermap/internal/utils"
+)
+
+type SimulateFaultPayload struct {
+	Map         string   `json:"map"`
+	Link        string   `json:"link"`
+	DataSource  string   `json:"datasource"`
+	State       string   `json:"state"`
+	Utilization *float64 `json:"utilization"`
+	Duration    string   `json:"duration"`
+}
+
+// EnableFaults serves /admin/faults, fault simulation changes what every dashboard shows
+// and is off unless WEATHERMAP_FAULTS is set
+func (s *Server) EnableFaults() {
+	s.faultsEnabled = true
+}
+
+func (s *Server) HandleFaults(w http.ResponseWriter, r *http.Request) {
+	if !s.faultsEnabled {
+		utils.RespondWithError(w, http.StatusNotFound, "fault simulation is not enabled")
+		return
+	}
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+	if s.dataSourceService == nil {
+		utils.RespondWithError(w, http.StatusServiceUnavailable, "datasource service is not running")
+		return
+	}
+	faultID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/faults"), "/")
+
+	switch r.Method {
+	case "GET":
+		utils.RespondWithJSON(w, http.StatusOK, s.dataSourceService.ListFaults())
+	case "POST":
+		if faultID != "" {
+			http.NotFound(w, r)
+			return
+		}
+		s.SimulateFault(w, r)
+	case "DELETE":
+		if err := s.dataSourceService.ClearFault(faultID); err != nil {
+			utils.RespondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]string{"status": "faults cleared"})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) SimulateFault(w http.ResponseWriter, r *http.Request) {
+	var payload SimulateFaultPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		respondWithBodyError(w, err, "Invalid JSON")
+		return
+	}
+	duration, err := time.ParseDuration(payload.Duration)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "invalid fault duration: "+payload.Duration)
+		return
+	}
+
+	fault, err := s.dataSourceService.SimulateFault(service.SimulatedFault{
+		Map:         payload.Map,
+		Link:        payload.Link,
+		DataSource:  payload.DataSource,
+		State:       payload.State,
+		Utilization: payload.Utilization,
+	}, duration)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusCreated, fault)
+}
+
+func (s *Server) HandlePollerStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+	if s.dataSourceService == nil {
+		utils.RespondWithError(w, http.StatusServiceUnavailable, "datasource service is not running")
+		return
+	}
+
+	switch strings.TrimPrefix(r.URL.Path, "/admin/pollers/") {
+	case "stats":
+		utils.RespondWithJSON(w, http.StatusOK, s.dataSourceService.PollStats())
+	case "slow":
+		utils.RespondWithJSON(w, http.StatusOK, s.dataSourceService.SlowTargets())
+	case "status":
+		utils.RespondWithJSON(w, http.StatusOK, s.dataSourceService.PollerStatus())
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) GetResourceLimits(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+	if s.dataSourceService == nil {
+		utils.RespondWithError(w, http.StatusServiceUnavailable, "datasource service is not running")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, s.dataSourceService.ResourceUsage())
+}
+
+// GetMisconfigurations lists the datasources and interfaces referenced by links which don't
+// exist, of the datasources the caller can see
+func (s *Server) GetMisconfigurations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.dataSourceService == nil {
+		utils.RespondWithError(w, http.StatusServiceUnavailable, "datasource service is not running")
+		return
+	}
+	canView, err := s.dataSourceViewer(r)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	misconfigurations := s.dataSourceService.Misconfigurations()
+	visible := misconfigurations[:0]
+	for _, misconfiguration := range misconfigurations {
+		if canView(misconfiguration.Datasource) {
+			visible = append(visible, misconfiguration)
+		}
+	}
+	utils.RespondWithJSON(w, http.StatusOK, visible)
+}
+
+// GetLint validates the maps of the config directory and reports the links seen above 100%
+// utilization, without reloading anything. Issues of maps the caller can't view are left out.
+func (s *Server) GetLint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	report, err := s.mapService.Lint()
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "failed to read config directory: "+err.Error())
+		return
+	}
+	visible := report.Issues[:0]
+	for _, issue := range report.Issues {
+		ok, err := s.canView(r, strings.TrimSuffix(issue.File, ".yaml"))
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if ok {
+			visible = append(visible, issue)
+		}
+	}
+	report.Issues = visible
+	utils.RespondWithJSON(w, http.StatusOK, report)
+}
+
+// GetHistoryUsage reports the storage used by every tier of the history and its retention
+func (s *Server) GetHistoryUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+	usage, err := s.mapService.HistoryUsage()
+	if err != nil {
+		if strings.Contains(err.Error(), "not enabled") {
+			utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		} else {
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, usage)
+}
+
+// ReloadReport is what POST /admin/reload changed
+type ReloadReport struct {
+	Maps        service.ReloadResult  `json:"maps"`
+	Datasources *service.ReloadResult `json:"datasources,omitempty"`
+	TLS         bool                  `json:"tls_reloaded"`
+	Issues      []service.ConfigIssue `json:"issues"`
+}
+
+// ReloadConfig reads the TLS certificate, the datasources and the maps of the config directory
+// again. Unchanged datasources keep polling and their cached values, broken ones keep their
+// current definition and are reported in issues. Everything is read before anything is
+// applied: when one of them fails, the server keeps its whole current configuration.
+func (s *Server) ReloadConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	configDir := s.mapService.ConfigDir()
+	report, err := service.ValidateConfigDir(configDir)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "failed to read config directory: "+err.Error())
+		return
+	}
+	result := ReloadReport{Issues: report.Issues}
+	var tlsConfig *tls.Config
+	if s.tls != nil {
+		if tlsConfig, err = s.tls.read(); err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "tls reload failed: "+err.Error())
+			return
+		}
+	}
+	var datasources []config.DataSourceConfig
+	if s.dataSourceService != nil {
+		if datasources, err = s.dataSourceService.LoadDir(configDir); err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "datasource reload failed: "+err.Error())
+			return
+		}
+	}
+	maps, err := s.mapService.LoadMaps()
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "map reload failed: "+err.Error())
+		return
+	}
+
+	if tlsConfig != nil {
+		s.tls.swap(tlsConfig)
+		result.TLS = true
+	}
+	if s.dataSourceService != nil {
+		// LoadDir validated them, Reload doesn't fail
+		changes, err := s.dataSourceService.Reload(datasources)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "datasource reload failed: "+err.Error())
+			return
+		}
+		result.Datasources = &changes
+		s.dataSourceService.LoadLinkBandwidths(configDir)
+	}
+	result.Maps = s.mapService.ApplyMaps(maps)
+
+	report.Log(s.logger)
+	s.logger.Info("configuration reloaded", "maps_added", result.Maps.Added, "maps_removed", result.Maps.Removed,
+		"maps_changed", result.Maps.Changed, "tls", result.TLS)
+	utils.RespondWithJSON(w, http.StatusOK, result)
+}
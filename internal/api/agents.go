@@ -0,0 +1,89 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"go-weathermap/internal/service"
+	"go-weathermap/internal/utils"
+)
+
+// AgentTokensFromEnv parses WEATHERMAP_AGENT_TOKENS="agent-a:token-a,agent-b:token-b"
+func AgentTokensFromEnv() (map[string]string, error) {
+	tokens := make(map[string]string)
+	for _, pair := range strings.Split(os.Getenv("WEATHERMAP_AGENT_TOKENS"), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, token, ok := strings.Cut(pair, ":")
+		if !ok || name == "" || token == "" {
+			return nil, fmt.Errorf("invalid agent token entry: '%s', must be name:token", pair)
+		}
+		tokens[name] = token
+	}
+	return tokens, nil
+}
+
+// SetAgentTokens enables agent pushes, only listed agents are accepted
+func (s *Server) SetAgentTokens(tokens map[string]string) {
+	s.agentTokens = tokens
+}
+
+func (s *Server) authorizeAgent(r *http.Request, agent string) bool {
+	expected, ok := s.agentTokens[agent]
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || !found {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
+
+func (s *Server) HandleAgentPush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.dataSourceService == nil {
+		utils.RespondWithError(w, http.StatusServiceUnavailable, "datasource service is not running")
+		return
+	}
+	if len(s.agentTokens) == 0 {
+		utils.RespondWithError(w, http.StatusForbidden, "agent push is disabled")
+		return
+	}
+
+	var push service.AgentPush
+	if err := json.NewDecoder(r.Body).Decode(&push); err != nil {
+		respondWithBodyError(w, err, "Invalid JSON")
+		return
+	}
+	if !s.authorizeAgent(r, push.Agent) {
+		utils.RespondWithError(w, http.StatusUnauthorized, "invalid agent credentials")
+		return
+	}
+	if err := s.dataSourceService.PushAgentMetrics(push.Agent, r.RemoteAddr, push.Datasources); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, map[string]string{"status": "metrics accepted"})
+}
+
+func (s *Server) ListAgents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+	if s.dataSourceService == nil {
+		utils.RespondWithError(w, http.StatusServiceUnavailable, "datasource service is not running")
+		return
+	}
+	respondWithList(w, r, s.dataSourceService.ListAgents())
+}
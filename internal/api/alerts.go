@@ -0,0 +1,23 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"go-weathermap/internal/service"
+	"go-weathermap/internal/utils"
+)
+
+// GetAlerts lists the pending and firing alerts of a map
+func (s *Server) GetAlerts(w http.ResponseWriter, r *http.Request, mapName string) {
+	alerts, err := s.mapService.GetAlerts(mapName)
+	if err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		} else {
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	respondWithList(w, r, alerts)
+}
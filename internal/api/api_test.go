@@ -1,17 +1,55 @@
 package api
 
 import (
+	"archive/tar"
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math/big"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"go-weathermap/internal/agent"
+	"go-weathermap/internal/auth"
 	"go-weathermap/internal/config"
+	"go-weathermap/internal/datasource"
 	"go-weathermap/internal/service"
+	"go-weathermap/internal/utils"
+	"go-weathermap/internal/version"
+
+	"github.com/andybalholm/brotli"
 )
 
 func TestHealth(t *testing.T) {
@@ -39,6 +77,19 @@ func TestHealth(t *testing.T) {
 	}
 }
 
+func TestReady(t *testing.T) {
+	server := NewServer(service.NewMapService(t.TempDir()), nil)
+	server.SetReady(false)
+	for _, want := range []int{http.StatusServiceUnavailable, http.StatusOK} {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("GET", "/ready", nil))
+		if recorder.Code != want {
+			t.Errorf("Expected %d, got %d %s", want, recorder.Code, recorder.Body.String())
+		}
+		server.SetReady(true)
+	}
+}
+
 func TestAPI(t *testing.T) {
 	tempDir, err := os.MkdirTemp("", "maps-test")
 	if err != nil {
@@ -56,7 +107,7 @@ func TestAPI(t *testing.T) {
 	mapName := "full-mesh-test"
 
 	t.Run("CreateMap", func(t *testing.T) {
-		mapConfig := fmt.Sprintf(`{"title": "%s", "width": 500, "height": 500}`, mapName)
+		mapConfig := fmt.Sprintf(`{"title": "%s", "width": 500, "height": 500, "scales": {"core": [{"Min": 0, "Max": 100, "Color": {"G": 128}}]}}`, mapName)
 		request := httptest.NewRequest("POST", "/maps", bytes.NewBufferString(mapConfig))
 		rr := httptest.NewRecorder()
 
@@ -87,7 +138,7 @@ func TestAPI(t *testing.T) {
 				server.ServeHTTP(rec, req)
 
 				if rec.Code != tc.expectedStatus {
-					t.Errorf("Expected status %d, got %d", tc.expectedStatus, rec.Code)
+					t.Errorf("Expected status %d, got %d: %s", tc.expectedStatus, rec.Code, rec.Body.String())
 				}
 			})
 		}
@@ -142,6 +193,147 @@ func TestAPI(t *testing.T) {
 		}
 	})
 
+	t.Run("ConditionalGetMap", func(t *testing.T) {
+		get := func(path, etag string) *httptest.ResponseRecorder {
+			request := httptest.NewRequest("GET", path, nil)
+			if etag != "" {
+				request.Header.Set("If-None-Match", etag)
+			}
+			rr := httptest.NewRecorder()
+			server.ServeHTTP(rr, request)
+			return rr
+		}
+		rr := get("/maps/"+mapName, "")
+		etag := rr.Header().Get("ETag")
+		if rr.Code != http.StatusOK || etag == "" {
+			t.Fatalf("Expected the map with an ETag, got status %d and %q", rr.Code, etag)
+		}
+		if rr = get("/maps/"+mapName, etag); rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+			t.Errorf("Expected 304 without body for an unchanged map, got %d with %d bytes", rr.Code, rr.Body.Len())
+		}
+		if rr = get("/maps/"+mapName+"?include=title", etag); rr.Code != http.StatusOK {
+			t.Errorf("Expected the ETag of the full map not to match the filtered one, got %d", rr.Code)
+		}
+
+		request := httptest.NewRequest("PATCH", "/maps/"+mapName, bytes.NewBufferString(`{"title": "Full mesh"}`))
+		server.ServeHTTP(httptest.NewRecorder(), request)
+		defer func() {
+			request := httptest.NewRequest("PATCH", "/maps/"+mapName, bytes.NewBufferString(fmt.Sprintf(`{"title": "%s"}`, mapName)))
+			server.ServeHTTP(httptest.NewRecorder(), request)
+		}()
+		if rr = get("/maps/"+mapName, etag); rr.Code != http.StatusOK || rr.Header().Get("ETag") == etag {
+			t.Errorf("Expected the map sent again once edited, got %d with ETag %s", rr.Code, rr.Header().Get("ETag"))
+		}
+	})
+
+	t.Run("CompressedMapRead", func(t *testing.T) {
+		get := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+			request := httptest.NewRequest("GET", path, nil)
+			request.Header.Set("Accept-Encoding", acceptEncoding)
+			rr := httptest.NewRecorder()
+			server.ServeHTTP(rr, request)
+			return rr
+		}
+		decode := func(rr *httptest.ResponseRecorder, v any) error {
+			var body io.Reader = rr.Body
+			switch rr.Header().Get("Content-Encoding") {
+			case "gzip":
+				zr, err := gzip.NewReader(rr.Body)
+				if err != nil {
+					return err
+				}
+				body = zr
+			case "br":
+				body = brotli.NewReader(rr.Body)
+			}
+			return json.NewDecoder(body).Decode(v)
+		}
+
+		for _, tc := range []struct{ acceptEncoding, want string }{
+			{"gzip, deflate", "gzip"},
+			{"gzip, br", "br"},
+			{"br;q=0, gzip;q=0.5", "gzip"},
+			{"identity", ""},
+		} {
+			rr := get("/maps/"+mapName, tc.acceptEncoding)
+			if got := rr.Header().Get("Content-Encoding"); got != tc.want {
+				t.Errorf("Expected Content-Encoding %q for %q, got %q", tc.want, tc.acceptEncoding, got)
+			}
+			var m config.MapWithData
+			if err := decode(rr, &m); err != nil || len(m.Nodes) != 4 {
+				t.Errorf("Expected the map decoded for %q, got %v", tc.acceptEncoding, err)
+			}
+		}
+
+		rr := get(APIPrefix+"/maps/"+mapName, "gzip")
+		var envelope struct {
+			Data config.MapWithData `json:"data"`
+		}
+		if err := decode(rr, &envelope); err != nil || envelope.Data.Title != mapName {
+			t.Errorf("Expected the enveloped map compressed once, got %v", err)
+		}
+		if rr = get("/maps/"+mapName+"/render.png", "gzip"); rr.Header().Get("Content-Encoding") != "" {
+			t.Errorf("Expected PNG left as it is, got Content-Encoding %s", rr.Header().Get("Content-Encoding"))
+		}
+	})
+
+	t.Run("RenderMapSVG", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "/maps/"+mapName+"/render.svg", nil)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, request)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("RenderMapSVG failed: status %d, body: %s", rr.Code, rr.Body.String())
+		}
+		if contentType := rr.Header().Get("Content-Type"); contentType != "image/svg+xml" {
+			t.Errorf("Expected Content-Type image/svg+xml, got %s", contentType)
+		}
+		body := rr.Body.String()
+		if !strings.HasPrefix(body, "<svg") {
+			t.Errorf("Expected SVG document, got %.50s", body)
+		}
+		if strings.Count(body, `<path id="link-`) != 6 {
+			t.Errorf("Expected 6 link paths in SVG, got %d", strings.Count(body, `<path id="link-`))
+		}
+		if strings.Count(body, `<g class="node"><title>`) != 4 {
+			t.Errorf("Expected a hover title for each of the 4 nodes, got %d", strings.Count(body, `<g class="node"><title>`))
+		}
+
+		notFoundRequest := httptest.NewRequest("GET", "/maps/non-existent/render.svg", nil)
+		notFoundRR := httptest.NewRecorder()
+		server.ServeHTTP(notFoundRR, notFoundRequest)
+		if notFoundRR.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 for unknown map, got %d", notFoundRR.Code)
+		}
+	})
+
+	t.Run("RenderMapPNG", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "/maps/"+mapName+"/render.png?width=250", nil)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, request)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("RenderMapPNG failed: status %d, body: %s", rr.Code, rr.Body.String())
+		}
+		if contentType := rr.Header().Get("Content-Type"); contentType != "image/png" {
+			t.Errorf("Expected Content-Type image/png, got %s", contentType)
+		}
+		img, err := png.Decode(rr.Body)
+		if err != nil {
+			t.Fatalf("Failed to decode PNG: %v", err)
+		}
+		if img.Bounds().Dx() != 250 || img.Bounds().Dy() != 250 {
+			t.Errorf("Expected 250x250 image, got %dx%d", img.Bounds().Dx(), img.Bounds().Dy())
+		}
+
+		invalidRequest := httptest.NewRequest("GET", "/maps/"+mapName+"/render.png?height=-5", nil)
+		invalidRR := httptest.NewRecorder()
+		server.ServeHTTP(invalidRR, invalidRequest)
+		if invalidRR.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for invalid height, got %d", invalidRR.Code)
+		}
+	})
+
 	t.Run("ListMapNodes", func(t *testing.T) {
 		request := httptest.NewRequest("GET", "/maps/"+mapName+"/nodes", nil)
 		rr := httptest.NewRecorder()
@@ -214,6 +406,74 @@ func TestAPI(t *testing.T) {
 		}
 	})
 
+	t.Run("SimulateLinkFault", func(t *testing.T) {
+		faultPayload := fmt.Sprintf(`{"map": "%s", "link": "link-node1-node2", "state": "down", "duration": "1m"}`, mapName)
+		disabledRR := httptest.NewRecorder()
+		server.ServeHTTP(disabledRR, httptest.NewRequest("POST", "/admin/faults", bytes.NewBufferString(faultPayload)))
+		if disabledRR.Code != http.StatusNotFound {
+			t.Fatalf("Expected 404 while fault simulation is disabled, got %d", disabledRR.Code)
+		}
+		server.EnableFaults()
+
+		request := httptest.NewRequest("POST", "/admin/faults", bytes.NewBufferString(faultPayload))
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, request)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("SimulateFault failed: status %d, body: %s", rr.Code, rr.Body.String())
+		}
+
+		linksRequest := httptest.NewRequest("GET", "/maps/"+mapName+"/links?status=down", nil)
+		linksRR := httptest.NewRecorder()
+		server.ServeHTTP(linksRR, linksRequest)
+		var downLinks []config.LinkData
+		if err := json.NewDecoder(linksRR.Body).Decode(&downLinks); err != nil {
+			t.Fatalf("Failed to decode links: %v", err)
+		}
+		if len(downLinks) != 1 || downLinks[0].Name != "link-node1-node2" {
+			t.Errorf("Expected only link-node1-node2 to be down, got %+v", downLinks)
+		}
+
+		clearRequest := httptest.NewRequest("DELETE", "/admin/faults", nil)
+		clearRR := httptest.NewRecorder()
+		server.ServeHTTP(clearRR, clearRequest)
+		if clearRR.Code != http.StatusOK {
+			t.Fatalf("ClearFaults failed: status %d, body: %s", clearRR.Code, clearRR.Body.String())
+		}
+
+		limitsRequest := httptest.NewRequest("GET", "/admin/limits", nil)
+		limitsRR := httptest.NewRecorder()
+		server.ServeHTTP(limitsRR, limitsRequest)
+		var usage service.ResourceUsage
+		if err := json.Unmarshal(limitsRR.Body.Bytes(), &usage); err != nil || limitsRR.Code != http.StatusOK {
+			t.Fatalf("Expected resource usage, got %d %s", limitsRR.Code, limitsRR.Body.String())
+		}
+		if usage.Limits.MaxSNMPSessions != datasource.DefaultMaxSNMPSessions || usage.SNMPSessions.Max != datasource.DefaultMaxSNMPSessions {
+			t.Errorf("Expected default SNMP session limit, got %+v", usage)
+		}
+		if !strings.Contains(limitsRR.Body.String(), `"timeout":"10s"`) {
+			t.Errorf("Expected HTTP pool timeout as duration string, got %s", limitsRR.Body.String())
+		}
+
+		statsRequest := httptest.NewRequest("GET", "/admin/pollers/slow", nil)
+		statsRR := httptest.NewRecorder()
+		server.ServeHTTP(statsRR, statsRequest)
+		if statsRR.Code != http.StatusOK || strings.TrimSpace(statsRR.Body.String()) != "[]" {
+			t.Errorf("Expected empty slow targets report, got %d %s", statsRR.Code, statsRR.Body.String())
+		}
+		statusRR := httptest.NewRecorder()
+		server.ServeHTTP(statusRR, httptest.NewRequest("GET", "/admin/pollers/status", nil))
+		if statusRR.Code != http.StatusOK || strings.TrimSpace(statusRR.Body.String()) != "[]" {
+			t.Errorf("Expected no poller status for mock datasources, got %d %s", statusRR.Code, statusRR.Body.String())
+		}
+
+		invalidRequest := httptest.NewRequest("POST", "/admin/faults", bytes.NewBufferString(`{"link": "link-node1-node2", "state": "down", "duration": "1m"}`))
+		invalidRR := httptest.NewRecorder()
+		server.ServeHTTP(invalidRR, invalidRequest)
+		if invalidRR.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for link fault without map, got %d", invalidRR.Code)
+		}
+	})
+
 	t.Run("VerifyMapFiltering", func(t *testing.T) {
 		request := httptest.NewRequest("GET", "/maps/"+mapName+"?include=title,width,nodes", nil)
 		rr := httptest.NewRecorder()
@@ -405,6 +665,9 @@ func TestAPI(t *testing.T) {
 		}
 
 		for key, value := range variables {
+			if config.IsSecret(key) {
+				value = config.SecretMask
+			}
 			if updatedVariables[key] != value {
 				t.Errorf("Variable %s: expected '%s', got '%s'", key, value, updatedVariables[key])
 			}
@@ -537,6 +800,46 @@ func TestAPI(t *testing.T) {
 		}
 	})
 
+	t.Run("RewireLink", func(t *testing.T) {
+		linkToEdit := "link-node1-node2"
+		patch := func(body string) *httptest.ResponseRecorder {
+			rr := httptest.NewRecorder()
+			server.ServeHTTP(rr, httptest.NewRequest("PATCH", fmt.Sprintf("/maps/%s/links/%s", mapName, linkToEdit), bytes.NewBufferString(body)))
+			return rr
+		}
+		if rr := patch(`{"to": "missing-node"}`); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "unknown node: missing-node") {
+			t.Errorf("Expected 400 for an unknown node, got %d: %s", rr.Code, rr.Body.String())
+		}
+		// a validation error naming something "not found" is still a bad request
+		if rr := patch(`{"to": "node not found"}`); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for an unknown node, got %d: %s", rr.Code, rr.Body.String())
+		}
+		for path, want := range map[string]string{"/maps/" + mapName + "/links/missing": "link not found", "/maps/missing/links/" + linkToEdit: "map not found: missing"} {
+			rr := httptest.NewRecorder()
+			server.ServeHTTP(rr, httptest.NewRequest("PATCH", path, bytes.NewBufferString(`{"width": 2}`)))
+			if rr.Code != http.StatusNotFound || !strings.Contains(rr.Body.String(), want) {
+				t.Errorf("Expected 404 %s for %s, got %d: %s", want, path, rr.Code, rr.Body.String())
+			}
+		}
+		if rr := patch(`{"scale": "cor"}`); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "unknown scale cor") {
+			t.Errorf("Expected 400 for an unknown scale, got %d: %s", rr.Code, rr.Body.String())
+		}
+		rr := patch(`{"from": "node2", "to": "node1", "datasource": "lab", "interface": "eth1", "metrics": ["in", "out"], "width": 6, "scale": "core"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("EditLink failed: status %d, body: %s", rr.Code, rr.Body.String())
+		}
+		m, _ := server.mapService.GetMap(mapName)
+		for _, link := range m.Links {
+			if link.Name != linkToEdit {
+				continue
+			}
+			if link.From != "node2" || link.To != "node1" || link.DataSource != "lab" || link.Interface != "eth1" ||
+				!slices.Equal(link.Metrics, []string{"in", "out"}) || link.Width != 6 || link.Scale != "core" {
+				t.Errorf("Expected the link rewired, got %+v", link)
+			}
+		}
+	})
+
 	t.Run("RemoveViaFromLink", func(t *testing.T) {
 		addViaPayload := map[string]any{
 			"via": []map[string]any{
@@ -627,6 +930,12 @@ func TestAPI(t *testing.T) {
 		}{
 			{"DeleteNonExistentNode", "DELETE", fmt.Sprintf("/maps/%s/nodes/%s", mapName, "non-existent-node"), "", http.StatusNotFound},
 			{"EditNonExistentLink", "PATCH", "/maps/" + mapName + "/links/non-existent-link", `{"bandwidth":"10G"}`, http.StatusNotFound},
+			{"EditNonExistentNode", "PATCH", "/maps/" + mapName + "/nodes/non-existent-node", `{"label":"x"}`, http.StatusNotFound},
+			{"DeleteNonExistentLink", "DELETE", "/maps/" + mapName + "/links/non-existent-link", "", http.StatusNotFound},
+			{"EditNodesBulkNonExistentNode", "PATCH", "/maps/" + mapName + "/nodes/bulk", `[{"name": "non-existent-node", "updates": {"label": "x"}}]`, http.StatusNotFound},
+			{"EditNonExistentMap", "PATCH", "/maps/non-existent-map", `{"title":"x"}`, http.StatusNotFound},
+			{"AddNodeToNonExistentMap", "POST", "/maps/non-existent-map/nodes", `{"name": "n", "position": {"x": 1, "y": 1}}`, http.StatusNotFound},
+			{"DeleteNonExistentMap", "DELETE", "/maps/non-existent-map", "", http.StatusNotFound},
 		}
 
 		for _, tc := range testCases {
@@ -674,6 +983,63 @@ func TestAPI(t *testing.T) {
 		}
 	})
 
+	t.Run("EditNodesBulk", func(t *testing.T) {
+		payload := `[
+			{"name": "bulk-node1", "updates": {"position": {"x": 60, "y": 70}}},
+			{"name": "bulk-node2", "updates": {"position": {"x": 160, "y": 170}, "label": "Bulk 2"}}
+		]`
+		request := httptest.NewRequest("PATCH", "/maps/"+mapName+"/nodes/bulk", bytes.NewBufferString(payload))
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, request)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("EditNodesBulk failed: status %d, body: %s", rr.Code, rr.Body.String())
+		}
+
+		request = httptest.NewRequest("GET", "/maps/"+mapName, nil)
+		rr = httptest.NewRecorder()
+		server.ServeHTTP(rr, request)
+		var currentMap config.MapWithData
+		if err := json.NewDecoder(rr.Body).Decode(&currentMap); err != nil {
+			t.Fatalf("Failed to decode map response: %v", err)
+		}
+		for _, node := range currentMap.Nodes {
+			switch node.Name {
+			case "bulk-node1":
+				if node.Position != (config.Position{X: 60, Y: 70}) {
+					t.Errorf("Expected bulk-node1 moved, got %+v", node.Position)
+				}
+			case "bulk-node2":
+				if node.Position != (config.Position{X: 160, Y: 170}) || node.Label != "Bulk 2" {
+					t.Errorf("Expected bulk-node2 moved and relabelled, got %+v", node)
+				}
+			}
+		}
+
+		// a missing node fails the whole request
+		payload = `[{"name": "bulk-node1", "updates": {"position": {"x": 1, "y": 1}}}, {"name": "missing", "updates": {}}]`
+		request = httptest.NewRequest("PATCH", "/maps/"+mapName+"/nodes/bulk", bytes.NewBufferString(payload))
+		rr = httptest.NewRecorder()
+		server.ServeHTTP(rr, request)
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 for a missing node, got %d", rr.Code)
+		}
+		m, _ := server.mapService.GetMap(mapName)
+		for _, node := range m.Nodes {
+			if node.Name == "bulk-node1" && node.Position.X != 60 {
+				t.Errorf("Expected no node saved when one is missing, got %+v", node.Position)
+			}
+		}
+
+		for _, body := range []string{`[]`, `[{"name": "bulk-node1", "updates": {"position": {"x": 2000, "y": 2000}}}]`} {
+			request = httptest.NewRequest("PATCH", "/maps/"+mapName+"/nodes/bulk", bytes.NewBufferString(body))
+			rr = httptest.NewRecorder()
+			server.ServeHTTP(rr, request)
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400 for %s, got %d: %s", body, rr.Code, rr.Body.String())
+			}
+		}
+	})
+
 	t.Run("TestBulkNodesErrors", func(t *testing.T) {
 		testCases := []struct {
 			name           string
@@ -773,6 +1139,61 @@ func TestAPI(t *testing.T) {
 		}
 	})
 
+	t.Run("EditLinksBulk", func(t *testing.T) {
+		payload := `[
+			{"name": "bulk-link1", "updates": {"bandwidth": "10G", "scale": "core"}},
+			{"name": "bulk-link2", "updates": {"bandwidth": "10G", "via": [{"x": 120, "y": 130}]}}
+		]`
+		request := httptest.NewRequest("PATCH", "/maps/"+mapName+"/links/bulk", bytes.NewBufferString(payload))
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, request)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("EditLinksBulk failed: status %d, body: %s", rr.Code, rr.Body.String())
+		}
+		m, _ := server.mapService.GetMap(mapName)
+		for _, link := range m.Links {
+			switch link.Name {
+			case "bulk-link1":
+				if link.Bandwidth != "10G" || link.Scale != "core" {
+					t.Errorf("Expected bulk-link1 updated, got %+v", link)
+				}
+			case "bulk-link2":
+				if link.Bandwidth != "10G" || len(link.Via) != 1 {
+					t.Errorf("Expected bulk-link2 updated, got %+v", link)
+				}
+			}
+		}
+
+		// nothing is saved when an item fails, every failed item is reported
+		payload = `[
+			{"name": "bulk-link1", "updates": {"bandwidth": "1G"}},
+			{"name": "bulk-link2", "updates": {"bandwidth": "fast"}},
+			{"name": "missing", "updates": {"cost": 5}}
+		]`
+		request = httptest.NewRequest("PATCH", "/maps/"+mapName+"/links/bulk", bytes.NewBufferString(payload))
+		rr = httptest.NewRecorder()
+		server.ServeHTTP(rr, request)
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status 400, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var report struct {
+			Error string                  `json:"error"`
+			Items []service.BulkItemError `json:"items"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Items) != 2 || report.Items[0].Index != 1 || report.Items[0].Name != "bulk-link2" || report.Items[1].Index != 2 || report.Items[1].Error != "link not found" {
+			t.Errorf("Expected the invalid and the missing link reported, got %+v", report)
+		}
+		m, _ = server.mapService.GetMap(mapName)
+		for _, link := range m.Links {
+			if link.Name == "bulk-link1" && link.Bandwidth != "10G" {
+				t.Errorf("Expected no link saved when an item fails, got %+v", link)
+			}
+		}
+	})
+
 	t.Run("TestBulkLinksErrors", func(t *testing.T) {
 		testCases := []struct {
 			name           string
@@ -964,7 +1385,7 @@ func TestAPI(t *testing.T) {
 			method         string
 			expectedStatus int
 		}{
-			{"ListIconsWrongMethod", "/icons", "POST", http.StatusMethodNotAllowed},
+			{"ListIconsWrongMethod", "/icons", "PUT", http.StatusMethodNotAllowed},
 			{"GetIconFileNotFound", "/icons/non-existent-icon.svg", "GET", http.StatusNotFound},
 			{"GetIconFileWrongMethod", "/icons/test.svg", "POST", http.StatusMethodNotAllowed},
 			{"GetIconFileEmptyName", "/icons/", "GET", http.StatusBadRequest},
@@ -983,12 +1404,3510 @@ func TestAPI(t *testing.T) {
 		}
 	})
 
-	/*
-		TODO: large body requests
-		t.Run("CreateTooLargeBodyRequest", func(t *testing.T) {
-			largeTitleBody := fmt.Sprintf(`{"title": "%s"}`, strings.Repeat("a", 1048577))
-			request := httptest.NewRequest("POST", "/maps", bytes.NewBufferString(largeTitleBody))
-			rr := httptest.NewRecorder()
+	t.Run("TooLargeBodyRequest", func(t *testing.T) {
+		largeTitleBody := fmt.Sprintf(`{"title": "%s"}`, strings.Repeat("a", DefaultMaxBodySize+1))
+		request := httptest.NewRequest("PATCH", "/maps/"+mapName, bytes.NewBufferString(largeTitleBody))
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, request)
+		var response struct {
+			Error string `json:"error"`
+			Limit int64  `json:"limit"`
+		}
+		_ = json.NewDecoder(rr.Body).Decode(&response)
+		if rr.Code != http.StatusRequestEntityTooLarge || response.Limit != DefaultMaxBodySize {
+			t.Errorf("Expected 413 with the limit, got %d: %+v", rr.Code, response)
+		}
+
+		// bulk endpoints have their own limit
+		largeBulkBody := fmt.Sprintf(`[{"name": "missing", "updates": {"label": "%s"}}]`, strings.Repeat("a", DefaultMaxBodySize+1))
+		request = httptest.NewRequest("PATCH", "/maps/"+mapName+"/nodes/bulk", bytes.NewBufferString(largeBulkBody))
+		rr = httptest.NewRecorder()
+		server.ServeHTTP(rr, request)
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected a bulk body over the default limit read, got %d", rr.Code)
+		}
+		server.SetMaxBulkBodySize(DefaultMaxBodySize)
+		defer server.SetMaxBulkBodySize(DefaultMaxBulkBodySize)
+		request = httptest.NewRequest("PATCH", "/maps/"+mapName+"/nodes/bulk", bytes.NewBufferString(largeBulkBody))
+		rr = httptest.NewRecorder()
+		server.ServeHTTP(rr, request)
+		if rr.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("Expected 413 over the bulk limit, got %d", rr.Code)
+		}
+	})
+}
+
+func readWebSocketText(t *testing.T, conn net.Conn, reader *bufio.Reader) []byte {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		head := make([]byte, 2)
+		if _, err := io.ReadFull(reader, head); err != nil {
+			t.Fatalf("Failed to read websocket frame: %v", err)
+		}
+		length := int(head[1] & 0x7F)
+		switch length {
+		case 126:
+			ext := make([]byte, 2)
+			_, _ = io.ReadFull(reader, ext)
+			length = int(binary.BigEndian.Uint16(ext))
+		case 127:
+			ext := make([]byte, 8)
+			_, _ = io.ReadFull(reader, ext)
+			length = int(binary.BigEndian.Uint64(ext))
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			t.Fatalf("Failed to read websocket payload: %v", err)
+		}
+		if head[0]&0x0F == 0x1 {
+			return payload
+		}
+	}
+}
+
+func TestMapWebSocket(t *testing.T) {
+	tempDir := t.TempDir()
+	dsService := service.NewDataSourceService(nil)
+	mapService := service.NewMapService(tempDir)
+	server := NewServer(mapService, dsService)
+	mapName := "ws-test"
+
+	testMap := &config.Map{
+		Title: mapName, Width: 500, Height: 500,
+		Nodes: []config.Node{{Name: "a"}, {Name: "b", Position: config.Position{X: 100, Y: 100}}},
+		Links: []config.Link{{Name: "a-b", From: "a", To: "b", Bandwidth: "1G"}},
+	}
+	if err := mapService.CreateMap(testMap, mapName); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(httpServer.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	handshake := "GET /maps/" + mapName + "/ws HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := conn.Write([]byte(handshake)); err != nil {
+		t.Fatalf("Failed to send handshake: %v", err)
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("Failed to read handshake response: %v", err)
+	}
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected status 101, got %d", response.StatusCode)
+	}
+	if accept := response.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Unexpected Sec-WebSocket-Accept: %s", accept)
+	}
+
+	var message map[string]any
+	if err := json.Unmarshal(readWebSocketText(t, conn, reader), &message); err != nil {
+		t.Fatalf("Failed to decode initial message: %v", err)
+	}
+	if message["map"] != mapName || len(message["links_data"].([]any)) != 1 {
+		t.Fatalf("Unexpected initial message: %v", message)
+	}
+
+	if _, err := dsService.SimulateFault(service.SimulatedFault{Map: mapName, Link: "a-b", State: "down"}, time.Minute); err != nil {
+		t.Fatalf("Failed to simulate fault: %v", err)
+	}
+	var update LinksUpdateMessage
+	if err := json.Unmarshal(readWebSocketText(t, conn, reader), &update); err != nil {
+		t.Fatalf("Failed to decode update message: %v", err)
+	}
+	if len(update.LinksData) != 1 || update.LinksData[0].Status != "down" {
+		t.Errorf("Expected pushed link a-b to be down, got %+v", update.LinksData)
+	}
+
+	plainRequest := httptest.NewRequest("GET", "/maps/"+mapName+"/ws", nil)
+	plainRR := httptest.NewRecorder()
+	server.ServeHTTP(plainRR, plainRequest)
+	if plainRR.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for plain GET, got %d", plainRR.Code)
+	}
+}
+
+func readServerSentEvent(t *testing.T, reader *bufio.Reader) (string, []byte) {
+	t.Helper()
+	var event string
+	var data []byte
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read event stream: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "" && event != "":
+			return event, data
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = []byte(strings.TrimPrefix(line, "data: "))
+		}
+	}
+}
+
+func TestMapEvents(t *testing.T) {
+	tempDir := t.TempDir()
+	dsService := service.NewDataSourceService(nil)
+	mapService := service.NewMapService(tempDir)
+	server := NewServer(mapService, dsService)
+	mapName := "sse-test"
+
+	testMap := &config.Map{
+		Title: mapName, Width: 500, Height: 500,
+		Nodes: []config.Node{{Name: "a"}, {Name: "b", Position: config.Position{X: 100, Y: 100}}},
+		Links: []config.Link{{Name: "a-b", From: "a", To: "b", Bandwidth: "1G"}},
+	}
+	if err := mapService.CreateMap(testMap, mapName); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	response, err := http.Get(httpServer.URL + "/maps/" + mapName + "/events")
+	if err != nil {
+		t.Fatalf("Failed to open event stream: %v", err)
+	}
+	defer func() { _ = response.Body.Close() }()
+	if contentType := response.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("Expected text/event-stream, got %s", contentType)
+	}
+	reader := bufio.NewReader(response.Body)
+
+	if event, _ := readServerSentEvent(t, reader); event != "metrics" {
+		t.Fatalf("Expected initial metrics event, got %s", event)
+	}
+
+	if _, err := dsService.SimulateFault(service.SimulatedFault{Map: mapName, Link: "a-b", State: "down"}, time.Minute); err != nil {
+		t.Fatalf("Failed to simulate fault: %v", err)
+	}
+	expected := []string{"link_state", "node_state", "node_state", "metrics"}
+	for _, want := range expected {
+		event, data := readServerSentEvent(t, reader)
+		if event != want {
+			t.Fatalf("Expected %s event, got %s: %s", want, event, data)
+		}
+		if event == "link_state" {
+			var change StateChangeEvent
+			if err := json.Unmarshal(data, &change); err != nil {
+				t.Fatalf("Failed to decode state change: %v", err)
+			}
+			if change.Name != "a-b" || change.Status != "down" || change.PreviousStatus != "unknown" {
+				t.Errorf("Unexpected link state change: %+v", change)
+			}
+		}
+	}
+
+	if err := mapService.AddNode(mapName, &config.Node{Name: "c", Position: config.Position{X: 200, Y: 200}}); err != nil {
+		t.Fatalf("Failed to add node: %v", err)
+	}
+	if event, _ := readServerSentEvent(t, reader); event != "config" {
+		t.Fatalf("Expected config event after edit, got %s", event)
+	}
+
+	if err := mapService.DeleteMap(mapName); err != nil {
+		t.Fatalf("Failed to delete map: %v", err)
+	}
+	var deleted ConfigChangeEvent
+	event, data := readServerSentEvent(t, reader)
+	if err := json.Unmarshal(data, &deleted); err != nil || event != "config" || !deleted.Deleted {
+		t.Errorf("Expected config deleted event, got %s: %s", event, data)
+	}
+
+	missing, err := http.Get(httpServer.URL + "/maps/no-such-map/events")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	_ = missing.Body.Close()
+	if missing.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown map, got %d", missing.StatusCode)
+	}
+}
+
+func TestClusterSharding(t *testing.T) {
+	var datasources []config.DataSourceConfig
+	for i := 1; i <= 8; i++ {
+		datasources = append(datasources, config.DataSourceConfig{
+			Name: fmt.Sprintf("mock-%d", i),
+			Type: "mock",
+			Interfaces: []config.InterfaceConfig{{
+				Name:   "eth0",
+				Params: map[string]interface{}{"metrics": []interface{}{"in", "out"}},
+			}},
+		})
+	}
+
+	var serverA, serverB *Server
+	ready := make(chan struct{}) // peers sync before both servers exist
+	httpA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-ready; serverA.ServeHTTP(w, r) }))
+	defer httpA.Close()
+	httpB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-ready; serverB.ServeHTTP(w, r) }))
+	defer httpB.Close()
+
+	newInstance := func(self string) *service.DataSourceService {
+		dsService := service.NewDataSourceService(datasources)
+		err := dsService.EnableSharding(service.ClusterConfig{
+			Self:          self,
+			Peers:         []string{httpA.URL, httpB.URL},
+			SyncInterval:  100 * time.Millisecond,
+			FailoverAfter: 500 * time.Millisecond,
 		})
-	*/
+		if err != nil {
+			t.Fatalf("Failed to enable sharding: %v", err)
+		}
+		dsService.Start()
+		return dsService
+	}
+	dsA, dsB := newInstance(httpA.URL), newInstance(httpB.URL)
+	serverA = NewServer(service.NewMapService(t.TempDir()), dsA)
+	serverB = NewServer(service.NewMapService(t.TempDir()), dsB)
+	close(ready)
+
+	statusA, err := dsA.ClusterStatus()
+	if err != nil {
+		t.Fatalf("Failed to get cluster status: %v", err)
+	}
+	statusB, _ := dsB.ClusterStatus()
+	var ownedByB string
+	for ds, owner := range statusA.Owners {
+		if statusB.Owners[ds] != owner {
+			t.Errorf("Instances disagree on owner of %s: %s vs %s", ds, owner, statusB.Owners[ds])
+		}
+		if owner == httpB.URL {
+			ownedByB = ds
+		}
+	}
+	if ownedByB == "" || len(dsA.LocalSnapshot()) == len(datasources) {
+		t.Fatalf("Expected datasources to be split between instances, got %v", statusA.Owners)
+	}
+
+	// A serves B's datasource from B's snapshot
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		metrics, err := dsA.GetInterfaceMetrics(context.Background(), ownedByB, "eth0", []string{"in"})
+		if err != nil {
+			t.Fatalf("GetInterfaceMetrics failed: %v", err)
+		}
+		if in, _ := metrics["in"].(int64); in > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("No remote value for %s after 5s", ownedByB)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	statusRR := httptest.NewRecorder()
+	serverA.ServeHTTP(statusRR, httptest.NewRequest("GET", "/cluster/status", nil))
+	if statusRR.Code != http.StatusOK || !strings.Contains(statusRR.Body.String(), ownedByB) {
+		t.Errorf("Unexpected cluster status: %d %s", statusRR.Code, statusRR.Body.String())
+	}
+
+	// B goes away, A takes over all datasources
+	httpB.Close()
+	deadline = time.Now().Add(5 * time.Second)
+	for len(dsA.LocalSnapshot()) != len(datasources) {
+		if time.Now().After(deadline) {
+			t.Fatal("Datasources did not fail over to the remaining instance")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestAgentPush(t *testing.T) {
+	iface := config.InterfaceConfig{
+		Name:   "eth0",
+		Params: map[string]interface{}{"metrics": []interface{}{"in", "out"}},
+	}
+	central := service.NewDataSourceService([]config.DataSourceConfig{{
+		Name:       "dc2-router",
+		Type:       service.AgentPollerType,
+		Interfaces: []config.InterfaceConfig{iface},
+		Params:     map[string]interface{}{"agent": "dc2"},
+	}})
+	server := NewServer(service.NewMapService(t.TempDir()), central)
+	server.SetAgentTokens(map[string]string{"dc2": "secret"})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	if _, err := central.GetInterfaceMetrics(context.Background(), "dc2-router", "eth0", []string{"in"}); err == nil {
+		t.Error("Expected error before the first agent push")
+	}
+
+	remote := service.NewDataSourceService([]config.DataSourceConfig{{
+		Name:       "dc2-router",
+		Type:       "mock",
+		Interfaces: []config.InterfaceConfig{iface},
+	}})
+	remote.Start()
+	time.Sleep(1200 * time.Millisecond) // first mock poll
+
+	wrongAgent, err := agent.New(agent.Config{Name: "dc2", Server: httpServer.URL, Token: "wrong", PushInterval: time.Second}, remote)
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+	if err := wrongAgent.Push(context.Background()); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Expected 401 for wrong token, got %v", err)
+	}
+
+	dcAgent, _ := agent.New(agent.Config{Name: "dc2", Server: httpServer.URL, Token: "secret", PushInterval: time.Second}, remote)
+	if err := dcAgent.Push(context.Background()); err != nil {
+		t.Fatalf("Agent push failed: %v", err)
+	}
+	metrics, err := central.GetInterfaceMetrics(context.Background(), "dc2-router", "eth0", []string{"in", "out"})
+	if err != nil {
+		t.Fatalf("GetInterfaceMetrics failed after push: %v", err)
+	}
+	want, _ := remote.GetInterfaceMetrics(context.Background(), "dc2-router", "eth0", []string{"in"})
+	if metrics["in"] != want["in"] {
+		t.Errorf("Expected pushed value %v, got %v", want["in"], metrics["in"])
+	}
+
+	foreign := bytes.NewBufferString(`{"agent": "dc2", "datasources": {"core-router": {"eth0": {"in": 1}}}}`)
+	request := httptest.NewRequest("POST", "/agents/push", foreign)
+	request.Header.Set("Authorization", "Bearer secret")
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, request)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for datasource of another agent, got %d", rr.Code)
+	}
+
+	listRR := httptest.NewRecorder()
+	server.ServeHTTP(listRR, httptest.NewRequest("GET", "/agents", nil))
+	var agents []service.AgentStatus
+	if err := json.Unmarshal(listRR.Body.Bytes(), &agents); err != nil {
+		t.Fatalf("Failed to decode agents: %v", err)
+	}
+	if len(agents) != 1 || agents[0].Pushes != 1 || agents[0].Stale {
+		t.Errorf("Expected one fresh agent with one push, got %+v", agents)
+	}
+}
+
+func TestDataSourcesAPI(t *testing.T) {
+	dsService := service.NewDataSourceService([]config.DataSourceConfig{{
+		Name:         "satellite-site",
+		Type:         service.SNMPPollerType,
+		PollInterval: 60,
+		Interfaces:   []config.InterfaceConfig{{Name: "Gi0/0/0"}},
+		Params: map[string]interface{}{
+			"host": "sat-router.example.com", "community": "secret", "timeout": "10s", "retries": 3,
+		},
+	}})
+	server := NewServer(service.NewMapService(t.TempDir()), dsService)
+
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, httptest.NewRequest("GET", "/datasources/satellite-site", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if strings.Contains(rr.Body.String(), "secret") {
+		t.Errorf("Community must not be exposed: %s", rr.Body.String())
+	}
+	var info service.DataSourceInfo
+	if err := json.Unmarshal(rr.Body.Bytes(), &info); err != nil {
+		t.Fatalf("Failed to decode datasource: %v", err)
+	}
+	if info.SNMP == nil || info.SNMP.Timeout != "10s" || info.SNMP.Retries != 3 || info.SNMP.MaxRequestDuration != "40s" || info.SNMP.Port != 161 {
+		t.Errorf("Unexpected effective settings: %+v", info.SNMP)
+	}
+
+	listRR := httptest.NewRecorder()
+	server.ServeHTTP(listRR, httptest.NewRequest("GET", "/datasources", nil))
+	var infos []service.DataSourceInfo
+	if err := json.Unmarshal(listRR.Body.Bytes(), &infos); err != nil || len(infos) != 1 {
+		t.Errorf("Expected one datasource, got %s", listRR.Body.String())
+	}
+
+	missingRR := httptest.NewRecorder()
+	server.ServeHTTP(missingRR, httptest.NewRequest("GET", "/datasources/unknown", nil))
+	if missingRR.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", missingRR.Code)
+	}
+
+	_, _ = dsService.GetInterfaceMetrics(context.Background(), "satellite-site", "Gi0/0/9", []string{"in"})
+	misconfiguredRR := httptest.NewRecorder()
+	server.ServeHTTP(misconfiguredRR, httptest.NewRequest("GET", "/admin/misconfigurations", nil))
+	var misconfigurations []service.Misconfiguration
+	if err := json.Unmarshal(misconfiguredRR.Body.Bytes(), &misconfigurations); err != nil || len(misconfigurations) != 1 ||
+		misconfigurations[0].Error != "interface not found: Gi0/0/9" {
+		t.Errorf("Expected the missing interface to be reported, got %s", misconfiguredRR.Body.String())
+	}
+}
+
+func TestServerGracefulShutdown(t *testing.T) {
+	tempDir := t.TempDir()
+	mapService := service.NewMapService(tempDir)
+	dsService := service.NewDataSourceService(nil)
+	server := NewServer(mapService, dsService)
+	if err := mapService.CreateMap(&config.Map{Title: "shutdown", Width: 100, Height: 100}, "shutdown"); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve port: %v", err)
+	}
+	addr := listener.Addr().String()
+	_ = listener.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- server.Start(ctx, addr) }()
+
+	var response *http.Response
+	for i := 0; i < 50; i++ {
+		if response, err = http.Get("http://" + addr + "/maps/shutdown/events"); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Server didn't start: %v", err)
+	}
+	defer func() { _ = response.Body.Close() }()
+	reader := bufio.NewReader(response.Body)
+	if event, _ := readServerSentEvent(t, reader); event != "metrics" {
+		t.Fatalf("Expected initial metrics event, got %s", event)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected clean shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server didn't shut down with an open event stream")
+	}
+	if _, err := io.ReadAll(reader); err != nil {
+		t.Errorf("Expected event stream to end, got %v", err)
+	}
+
+	stopCtx, stopCancel := context.WithTimeout(context.Background(), time.Second)
+	defer stopCancel()
+	if err := dsService.Stop(stopCtx); err != nil {
+		t.Errorf("Failed to stop datasource service: %v", err)
+	}
+}
+
+func TestServerTimeouts(t *testing.T) {
+	t.Setenv("WEATHERMAP_SERVER_WRITE_TIMEOUT", "200ms")
+	t.Setenv("WEATHERMAP_SERVER_READ_HEADER_TIMEOUT", "200ms")
+	timeouts, err := TimeoutsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timeouts.Write != 200*time.Millisecond || timeouts.Idle != DefaultTimeouts.Idle {
+		t.Errorf("Unexpected timeouts: %+v", timeouts)
+	}
+	t.Setenv("WEATHERMAP_SERVER_IDLE_TIMEOUT", "forever")
+	if _, err := TimeoutsFromEnv(); err == nil {
+		t.Error("Expected an invalid timeout to be rejected")
+	}
+
+	tempDir := t.TempDir()
+	mapService := service.NewMapService(tempDir)
+	server := NewServer(mapService, nil)
+	server.SetTimeouts(timeouts)
+	if err := mapService.CreateMap(&config.Map{Title: "timeouts", Width: 100, Height: 100}, "timeouts"); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve port: %v", err)
+	}
+	addr := listener.Addr().String()
+	_ = listener.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- server.Start(ctx, addr) }()
+	defer func() { cancel(); <-done }()
+
+	var conn net.Conn
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Server didn't start: %v", err)
+	}
+	// a client never finishing its headers is disconnected
+	_, _ = conn.Write([]byte("GET /health HTTP/1.1\r\nHost: x\r\n"))
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Errorf("Expected the slow client to be disconnected, got %v", err)
+	}
+	_ = conn.Close()
+
+	// event streams outlive the write timeout
+	response, err := http.Get("http://" + addr + "/maps/timeouts/events")
+	if err != nil {
+		t.Fatalf("Failed to open event stream: %v", err)
+	}
+	defer func() { _ = response.Body.Close() }()
+	reader := bufio.NewReader(response.Body)
+	if event, _ := readServerSentEvent(t, reader); event != "metrics" {
+		t.Fatalf("Expected initial metrics event, got %s", event)
+	}
+	time.Sleep(400 * time.Millisecond)
+	if err := mapService.AddNode("timeouts", &config.Node{Name: "late"}); err != nil {
+		t.Fatalf("Failed to add node: %v", err)
+	}
+	if event, _ := readServerSentEvent(t, reader); event != "config" {
+		t.Errorf("Expected config event after the write timeout, got %s", event)
+	}
+}
+
+func TestAPIv1Envelope(t *testing.T) {
+	tempDir := t.TempDir()
+	mapService := service.NewMapService(tempDir)
+	server := NewServer(mapService, service.NewDataSourceService(nil))
+	for _, name := range []string{"alpha", "beta", "gamma"} {
+		if err := mapService.CreateMap(&config.Map{Title: name, Width: 100, Height: 100}, name); err != nil {
+			t.Fatalf("Failed to create map: %v", err)
+		}
+	}
+
+	serve := func(method, path string, header http.Header) (*httptest.ResponseRecorder, utils.Envelope) {
+		t.Helper()
+		request := httptest.NewRequest(method, path, nil)
+		for key, values := range header {
+			request.Header[key] = values
+		}
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, request)
+		var envelope utils.Envelope
+		if err := json.Unmarshal(recorder.Body.Bytes(), &envelope); err != nil {
+			t.Fatalf("%s %s: invalid envelope %q: %v", method, path, recorder.Body.String(), err)
+		}
+		return recorder, envelope
+	}
+
+	recorder, envelope := serve("GET", "/api/v1/maps?offset=1&limit=1", http.Header{"X-Request-Id": {"trace-42"}})
+	if recorder.Code != http.StatusOK || envelope.Error != nil {
+		t.Fatalf("Expected 200 without error, got %d %+v", recorder.Code, envelope.Error)
+	}
+	if envelope.Meta.RequestID != "trace-42" || recorder.Header().Get("X-Request-ID") != "trace-42" {
+		t.Errorf("Expected client request id to be kept, got %q", envelope.Meta.RequestID)
+	}
+	if p := envelope.Meta.Pagination; p == nil || p.Offset != 1 || p.Limit != 1 || p.Total != 3 {
+		t.Errorf("Unexpected pagination: %+v", p)
+	}
+	if maps := envelope.Data.(map[string]any)["maps"].([]any); len(maps) != 1 || maps[0] != "beta" {
+		t.Errorf("Expected second page [beta], got %v", maps)
+	}
+
+	recorder, envelope = serve("GET", "/api/v1/maps/missing", nil)
+	if recorder.Code != http.StatusNotFound || envelope.Error == nil || envelope.Error.Code != http.StatusNotFound || envelope.Data != nil {
+		t.Errorf("Expected enveloped 404, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if envelope.Meta.RequestID == "" {
+		t.Error("Expected a generated request id")
+	}
+
+	recorder, envelope = serve("PUT", "/api/v1/maps", nil)
+	if recorder.Code != http.StatusMethodNotAllowed || envelope.Error == nil || envelope.Error.Message != "Method not allowed" {
+		t.Errorf("Expected plain text 405 to be enveloped, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder, _ = serve("GET", "/api/v1/maps?limit=-1", nil)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for negative limit, got %d", recorder.Code)
+	}
+
+	request := httptest.NewRequest("GET", "/maps?limit=1", nil)
+	legacy := httptest.NewRecorder()
+	server.ServeHTTP(legacy, request)
+	var listing map[string][]string
+	if err := json.Unmarshal(legacy.Body.Bytes(), &listing); err != nil || len(listing["maps"]) != 3 {
+		t.Errorf("Expected legacy route to return all maps unwrapped, got %s", legacy.Body.String())
+	}
+}
+
+func TestMapSchema(t *testing.T) {
+	server := NewServer(service.NewMapService(t.TempDir()), nil)
+
+	for _, path := range []string{"/schema/map.json", "/api/v1/schema/map.json"} {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != "application/schema+json" {
+			t.Fatalf("%s: expected schema, got %d %s", path, recorder.Code, recorder.Header().Get("Content-Type"))
+		}
+
+		var schema struct {
+			Properties map[string]map[string]any `json:"properties"`
+			Required   []string                  `json:"required"`
+			Defs       map[string]struct {
+				Properties           map[string]map[string]any `json:"properties"`
+				Required             []string                  `json:"required"`
+				AdditionalProperties any                       `json:"additionalProperties"`
+			} `json:"$defs"`
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &schema); err != nil {
+			t.Fatalf("%s: schema is not an unwrapped JSON document: %v", path, err)
+		}
+		for _, key := range []string{"width", "height", "title", "bg_color", "nodes", "links", "datasources", "variables"} {
+			if _, ok := schema.Properties[key]; !ok {
+				t.Errorf("Expected map property %s", key)
+			}
+		}
+		if schema.Properties["width"]["minimum"] != float64(1) {
+			t.Errorf("Expected width minimum 1, got %v", schema.Properties["width"])
+		}
+		if schema.Properties["nodes"]["items"].(map[string]any)["$ref"] != "#/$defs/Node" {
+			t.Errorf("Expected nodes to reference Node, got %v", schema.Properties["nodes"])
+		}
+		link := schema.Defs["Link"]
+		if link.Properties["datasource"]["type"] != "string" || len(link.Required) != 3 {
+			t.Errorf("Unexpected Link schema: %+v", link)
+		}
+		if params, ok := schema.Defs["DataSourceConfig"].AdditionalProperties.(map[string]any); !ok || len(params) != 0 {
+			t.Errorf("Expected datasource params to accept any key, got %v", schema.Defs["DataSourceConfig"].AdditionalProperties)
+		}
+	}
+}
+
+func TestPayloadValidation(t *testing.T) {
+	server := NewServer(service.NewMapService(t.TempDir()), nil)
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return recorder
+	}
+	if recorder := serve("POST", "/maps", `{"title": "lab", "width": 500, "height": 500}`); recorder.Code != http.StatusCreated {
+		t.Fatalf("CreateMap failed: %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder := serve("GET", "/schema/payloads/node.json", "")
+	var schema struct {
+		Properties map[string]any `json:"properties"`
+		Required   []string       `json:"required"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &schema); err != nil || recorder.Code != http.StatusOK {
+		t.Fatalf("Expected the node schema, got %d: %v", recorder.Code, err)
+	}
+	if _, ok := schema.Properties["management_ip"]; !ok || !slices.Equal(schema.Required, []string{"name"}) {
+		t.Errorf("Unexpected node schema: %+v", schema)
+	}
+	if recorder := serve("GET", "/schema/payloads/demand.json", ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a kind without schema, got %d", recorder.Code)
+	}
+
+	for _, tc := range []struct {
+		name, method, path, body string
+		fields                   []string
+	}{
+		{"AddNode", "POST", "/maps/lab/nodes", `{"name": 5, "position": {"x": "a", "y": 1.5}, "colour": "red"}`,
+			[]string{"colour", "name", "position.x", "position.y"}},
+		{"AddLinkMissingEnds", "POST", "/maps/lab/links", `{"name": "a-b", "width": 2}`, []string{"from", "to"}},
+		{"EditMap", "PATCH", "/maps/lab", `{"width": 0, "accessible": "yes"}`, []string{"accessible", "width"}},
+		{"ReplaceMap", "PUT", "/maps/lab", `{"title": "lab", "width": "500", "height": 500, "nodes": [{"name": "a", "position": {"x": true, "y": 1}}]}`,
+			[]string{"nodes[0].position.x", "width"}},
+		{"EditLink", "PATCH", "/maps/lab/links/a-b", `{"via": [{"x": 1, "y": 2}, {"x": "3"}]}`, []string{"via[1].x"}},
+		{"AddNodesBulk", "POST", "/maps/lab/nodes/bulk", `[{"name": "a"}, {"label": "b"}, 3]`, []string{"[1].name", "[2]"}},
+		{"EditLinksBulk", "PATCH", "/maps/lab/links/bulk", `[{"name": "a-b", "updates": {"metrics": "in"}}]`, []string{"[0].updates.metrics"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := serve(tc.method, tc.path, tc.body)
+			var response struct {
+				Error  string              `json:"error"`
+				Fields []config.FieldError `json:"fields"`
+			}
+			_ = json.Unmarshal(recorder.Body.Bytes(), &response)
+			var fields []string
+			for _, field := range response.Fields {
+				fields = append(fields, field.Field)
+			}
+			if recorder.Code != http.StatusBadRequest || !slices.Equal(fields, tc.fields) {
+				t.Errorf("Expected 400 for the fields %v, got %d: %s", tc.fields, recorder.Code, recorder.Body.String())
+			}
+		})
+	}
+
+	// a map read from the API is written back with its live data
+	mapBody := serve("GET", "/maps/lab", "").Body.String()
+	if !strings.Contains(mapBody, `"links_data"`) {
+		t.Fatalf("Expected the live data of the map, got %s", mapBody)
+	}
+	if recorder := serve("PUT", "/maps/lab", mapBody); recorder.Code != http.StatusOK {
+		t.Errorf("Expected the map read from GET replaced, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	// keys are matched without case like encoding/json does
+	if recorder := serve("POST", "/maps/lab/nodes", `{"Name": "a", "Position": {"x": 10, "y": 10}}`); recorder.Code != http.StatusOK {
+		t.Errorf("Expected the node added, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestLinkCommitRate(t *testing.T) {
+	tempDir := t.TempDir()
+	mapService := service.NewMapService(tempDir)
+	dsService := service.NewDataSourceService(nil)
+	server := NewServer(mapService, dsService)
+	mapName := "cir-test"
+
+	testMap := &config.Map{
+		Title: mapName, Width: 500, Height: 500,
+		Nodes: []config.Node{{Name: "pe1"}, {Name: "ce1", Position: config.Position{X: 100, Y: 100}}},
+		Links: []config.Link{{Name: "mpls", From: "pe1", To: "ce1", Bandwidth: "1G", CommitRate: "500M"}},
+	}
+	if err := mapService.CreateMap(testMap, mapName); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+
+	utilization := 40.0
+	fault := service.SimulatedFault{Map: mapName, Link: "mpls", State: service.FaultStateDegraded, Utilization: &utilization}
+	if _, err := dsService.SimulateFault(fault, time.Minute); err != nil {
+		t.Fatalf("Failed to simulate fault: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", "/maps/"+mapName+"/links", nil))
+	var links []config.LinkData
+	if err := json.Unmarshal(recorder.Body.Bytes(), &links); err != nil {
+		t.Fatalf("Failed to decode links: %v", err)
+	}
+	if len(links) != 1 || links[0].Utilization != 40 || links[0].CommitUtilization == nil || *links[0].CommitUtilization != 80 {
+		t.Fatalf("Expected 40%% of bandwidth and 80%% of commit rate, got %+v", links)
+	}
+
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", "/maps/"+mapName+"/render.svg", nil))
+	svg := recorder.Body.String()
+	if !strings.Contains(svg, "40.0% (CIR 80.0%)") || !strings.Contains(svg, `stroke="#ff8000" stroke-width="2"`) {
+		t.Errorf("Expected label with commit utilization and commit scale border, got %s", svg)
+	}
+
+	body := `{"name": "over", "from": "pe1", "to": "ce1", "bandwidth": "100M", "commit_rate": "1G"}`
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("POST", "/maps/"+mapName+"/links", strings.NewReader(body)))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected commit rate above bandwidth to be rejected, got %d %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestMapShortestPath(t *testing.T) {
+	tempDir := t.TempDir()
+	mapService := service.NewMapService(tempDir)
+	dsService := service.NewDataSourceService(nil)
+	server := NewServer(mapService, dsService)
+	mapName := "path-test"
+
+	testMap := &config.Map{
+		Title: mapName, Width: 500, Height: 500,
+		Nodes: []config.Node{
+			{Name: "a"},
+			{Name: "b", Position: config.Position{X: 100, Y: 0}},
+			{Name: "c", Position: config.Position{X: 200, Y: 0}},
+			{Name: "d", Position: config.Position{X: 300, Y: 0}},
+		},
+		Links: []config.Link{
+			{Name: "a-c", From: "a", To: "c", Bandwidth: "1G", Cost: 10},
+			{Name: "a-b", From: "a", To: "b", Bandwidth: "1G", Cost: 2},
+			{Name: "c-b", From: "c", To: "b", Bandwidth: "1G", Cost: 3},
+		},
+	}
+	if err := mapService.CreateMap(testMap, mapName); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+
+	findPath := func(body string) (*httptest.ResponseRecorder, config.Path) {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("POST", "/maps/"+mapName+"/path", strings.NewReader(body)))
+		var path config.Path
+		if recorder.Code == http.StatusOK {
+			if err := json.Unmarshal(recorder.Body.Bytes(), &path); err != nil {
+				t.Fatalf("Failed to decode path: %v", err)
+			}
+		}
+		return recorder, path
+	}
+
+	recorder, path := findPath(`{"from": "a", "to": "c"}`)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status OK, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if path.Cost != 5 || strings.Join(path.Nodes, ",") != "a,b,c" || strings.Join(path.Links, ",") != "a-b,c-b" {
+		t.Errorf("Expected a,b,c over a-b,c-b with cost 5, got %+v", path)
+	}
+
+	if _, err := dsService.SimulateFault(service.SimulatedFault{Map: mapName, Link: "a-b", State: service.FaultStateDown}, time.Minute); err != nil {
+		t.Fatalf("Failed to simulate fault: %v", err)
+	}
+	_, path = findPath(`{"from": "a", "to": "c", "avoid_down": true}`)
+	if path.Cost != 10 || strings.Join(path.Links, ",") != "a-c" {
+		t.Errorf("Expected the direct link when a-b is down, got %+v", path)
+	}
+
+	if recorder, _ = findPath(`{"from": "a", "to": "d"}`); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unreachable node, got %d", recorder.Code)
+	}
+	if recorder, _ = findPath(`{"from": "a", "to": "x"}`); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown node, got %d", recorder.Code)
+	}
+	if recorder, _ = findPath(`{"from": "a"}`); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without destination, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", "/maps/"+mapName+"/render.svg?path=a,c", nil))
+	if recorder.Code != http.StatusOK || strings.Count(recorder.Body.String(), `class="path"`) != 2 {
+		t.Errorf("Expected two highlighted links in the render, got %d %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestExportWeathermapConf(t *testing.T) {
+	tempDir := t.TempDir()
+	mapService := service.NewMapService(tempDir)
+	server := NewServer(mapService, nil)
+	mapName := "export-test"
+
+	testMap := &config.Map{
+		Title: "Export test", Width: 800, Height: 600,
+		Scales: map[string][]config.Scale{"default": {{Min: 0, Max: 100, Color: config.Color{R: 0, G: 255, B: 0}}}},
+		Nodes: []config.Node{
+			{Name: "core 1", Label: "Core", Icon: "router.svg", Position: config.Position{X: 10, Y: 20}},
+			{Name: "edge", Position: config.Position{X: 300, Y: 20}},
+		},
+		Links: []config.Link{
+			{Name: "uplink", From: "core 1", To: "edge", Bandwidth: "10G", DataSource: "core", Interface: "ge1",
+				Metrics: []string{"in", "out"}, Via: []config.Position{{X: 150, Y: 80}}},
+			{Name: "backup", From: "core 1", To: "edge", Bandwidth: "1G", DataSource: "sim", Interface: "eth0", Metrics: []string{"in", "out"}},
+		},
+		Datasources: []config.DataSourceConfig{
+			{Name: "core", Type: "snmp", Params: map[string]interface{}{"host": "10.0.0.1", "community": "public"},
+				Interfaces: []config.InterfaceConfig{{Name: "ge1", Params: map[string]interface{}{
+					"oids": map[string]interface{}{"in": ".1.3.6.1.2.1.31.1.1.1.6.1", "out": ".1.3.6.1.2.1.31.1.1.1.10.1"}}}}},
+			{Name: "sim", Type: "mock", Interfaces: []config.InterfaceConfig{{Name: "eth0"}}},
+		},
+	}
+	if err := mapService.CreateMap(testMap, mapName); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", "/maps/"+mapName+"/export?format=weathermap", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status OK, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	conf := recorder.Body.String()
+	for _, expected := range []string{
+		"WIDTH 800\nHEIGHT 600\nTITLE Export test\n",
+		"SCALE DEFAULT 0 100 0 255 0\n",
+		"NODE core_1\n\tLABEL Core\n\tICON images/router.svg\n\tPOSITION 10 20\n",
+		"LINK uplink\n\tTARGET snmp:public:10.0.0.1:.1.3.6.1.2.1.31.1.1.1.6.1:.1.3.6.1.2.1.31.1.1.1.10.1\n\tNODES core_1 edge\n\tVIA 150 80\n\tBANDWIDTH 10G\n",
+		"LINK backup\n\t# TARGET not exported: datasource sim is of type mock\n",
+	} {
+		if !strings.Contains(conf, expected) {
+			t.Errorf("Expected export to contain %q, got:\n%s", expected, conf)
+		}
+	}
+
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", "/maps/"+mapName+"/export?format=bundle", nil))
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("Expected a zip bundle, got %d %s", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	bundle, err := service.ReadBundle(recorder.Body.Bytes())
+	if err != nil {
+		t.Fatalf("Failed to read bundle: %v", err)
+	}
+	if bundle.Map.Title != "Export test" || bundle.Icons["router.svg"] == nil {
+		t.Errorf("Expected the map and the router icon in the bundle, got %q with %d icons", bundle.Map.Title, len(bundle.Icons))
+	}
+
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", "/maps/"+mapName+"/export?format=visio", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unknown format, got %d", recorder.Code)
+	}
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", "/maps/missing/export?format=weathermap", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for missing map, got %d", recorder.Code)
+	}
+}
+
+func TestImportMapYAML(t *testing.T) {
+	tempDir := t.TempDir()
+	mapService := service.NewMapService(tempDir)
+	server := NewServer(mapService, nil)
+	testMap := &config.Map{
+		Title: "Import test", Width: 400, Height: 300,
+		Nodes:     []config.Node{{Name: "a"}, {Name: "b", Position: config.Position{X: 100, Y: 100}}},
+		Links:     []config.Link{{Name: "a-b", From: "a", To: "b", Bandwidth: "1G"}},
+		Variables: config.Variables{"api_token": "s3cret", "site": "dc1"},
+	}
+	if err := mapService.CreateMap(testMap, "source"); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+	serve := func(method, path, contentType string, body io.Reader) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, body)
+		if contentType != "" {
+			request.Header.Set("Content-Type", contentType)
+		}
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	recorder := serve("GET", "/maps/source/export?format=yaml", "", nil)
+	exported := recorder.Body.String()
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != "application/yaml" {
+		t.Fatalf("Expected the map YAML, got %d %s", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	if strings.Contains(exported, "s3cret") || !strings.Contains(exported, "site: dc1") {
+		t.Errorf("Expected secrets masked in the export, got:\n%s", exported)
+	}
+
+	var result service.ImportResult
+	recorder = serve("POST", "/maps/import?name=copy", "application/yaml", strings.NewReader(exported))
+	if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil || recorder.Code != http.StatusCreated || !result.Created || result.Links != 1 {
+		t.Fatalf("Expected the map imported, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := serve("POST", "/maps/import?name=copy", "application/yaml", strings.NewReader(exported)); recorder.Code != http.StatusConflict {
+		t.Errorf("Expected 409 importing over an existing map, got %d", recorder.Code)
+	}
+
+	// a dry run validates without writing
+	changed := strings.Replace(exported, "title: Import test", "title: Changed", 1)
+	recorder = serve("POST", "/maps/import?name=copy&replace=true&dry_run=true", "application/yaml", strings.NewReader(changed))
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"dry_run":true`) {
+		t.Errorf("Expected the dry run to pass, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if m, _ := mapService.GetMap("copy"); m.Title != "Import test" {
+		t.Errorf("Expected a dry run to leave the map alone, got title %q", m.Title)
+	}
+	broken := strings.Replace(exported, "to: b", "to: c", 1)
+	recorder = serve("POST", "/maps/import?name=broken&dry_run=true", "application/yaml", strings.NewReader(broken))
+	if recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), "unknown node: c") {
+		t.Errorf("Expected the dry run to report the unknown node, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "broken.yaml")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing written by a dry run, got %v", err)
+	}
+
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	part, _ := writer.CreateFormFile("file", "source.yaml")
+	_, _ = part.Write([]byte(changed))
+	_ = writer.Close()
+	if recorder := serve("POST", "/maps/import?name=copy&replace=true", writer.FormDataContentType(), &form); recorder.Code != http.StatusOK {
+		t.Errorf("Expected the multipart import to replace the map, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if m, _ := mapService.GetMap("copy"); m.Title != "Changed" {
+		t.Errorf("Expected the map replaced, got title %q", m.Title)
+	}
+
+	for _, path := range []string{"/maps/import?name=bad", "/maps/import?name=..%2Fescape"} {
+		if recorder := serve("POST", path, "application/yaml", strings.NewReader("title: [")); recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", path, recorder.Code)
+		}
+	}
+}
+
+func TestMapBundleTarGz(t *testing.T) {
+	sourceService := service.NewMapService(t.TempDir())
+	custom := []byte(`<svg xmlns="http://www.w3.org/2000/svg"><circle r="4"/></svg>`)
+	iconsDir := t.TempDir()
+	sourceService.SetIconsDir(iconsDir)
+	if err := os.WriteFile(filepath.Join(iconsDir, "custom.svg"), custom, 0644); err != nil {
+		t.Fatal(err)
+	}
+	testMap := &config.Map{
+		Title: "Bundle test", Width: 400, Height: 300,
+		Nodes:     []config.Node{{Name: "a", Icon: "custom.svg"}, {Name: "b", Position: config.Position{X: 100, Y: 100}}},
+		Links:     []config.Link{{Name: "a-b", From: "a", To: "b", Bandwidth: "1G"}},
+		Variables: config.Variables{"api_token": "s3cret"},
+	}
+	if err := sourceService.CreateMap(testMap, "source"); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+	request := httptest.NewRequest("GET", "/maps/source/bundle", nil)
+	recorder := httptest.NewRecorder()
+	NewServer(sourceService, nil).ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != "application/gzip" {
+		t.Fatalf("Expected the bundle, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	archive := recorder.Body.Bytes()
+	gr, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatalf("Expected a gzip, got %v", err)
+	}
+	var names []string
+	tr := tar.NewReader(gr)
+	for header, err := tr.Next(); err == nil; header, err = tr.Next() {
+		names = append(names, header.Name)
+		if content, _ := io.ReadAll(tr); bytes.Contains(content, []byte("s3cret")) {
+			t.Errorf("Expected secrets masked in %s of the bundle", header.Name)
+		}
+	}
+	if !slices.Equal(names, []string{"source.yaml", "icons/custom.svg"}) {
+		t.Errorf("Expected the map and its icon, got %v", names)
+	}
+
+	targetDir := t.TempDir()
+	targetService := service.NewMapService(targetDir)
+	targetService.SetIconsDir(filepath.Join(t.TempDir(), "icons"))
+	target := NewServer(targetService, nil)
+	importBundle := func(query string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("POST", "/maps/moved/bundle"+query, bytes.NewReader(archive))
+		request.Header.Set("Content-Type", "application/gzip")
+		recorder := httptest.NewRecorder()
+		target.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	recorder = importBundle("?dry_run=true")
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"icons_added":["custom.svg"]`) {
+		t.Errorf("Expected the dry run to report the icon, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if _, _, err := targetService.GetIconFile("custom.svg"); err == nil {
+		t.Error("Expected a dry run to add no icon")
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "moved.yaml")); !os.IsNotExist(err) {
+		t.Errorf("Expected a dry run to write no map, got %v", err)
+	}
+
+	if recorder := importBundle(""); recorder.Code != http.StatusCreated {
+		t.Fatalf("Expected the bundle imported, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if icon, _, err := targetService.GetIconFile("custom.svg"); err != nil || !bytes.Equal(icon, custom) {
+		t.Errorf("Expected the icon added, got %q, %v", icon, err)
+	}
+	if m, err := targetService.GetMap("moved"); err != nil || m.Title != "Bundle test" {
+		t.Errorf("Expected the map imported, got %+v, %v", m, err)
+	}
+	if recorder := importBundle(""); recorder.Code != http.StatusConflict {
+		t.Errorf("Expected 409 importing over an existing map, got %d", recorder.Code)
+	}
+	if recorder := importBundle("?replace=true"); recorder.Code != http.StatusOK {
+		t.Errorf("Expected the map replaced, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	request = httptest.NewRequest("POST", "/maps/other/bundle", strings.NewReader("title: not a bundle"))
+	recorder = httptest.NewRecorder()
+	target.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a body which is no bundle, got %d", recorder.Code)
+	}
+}
+
+func TestSimulateFailure(t *testing.T) {
+	tempDir := t.TempDir()
+	mapService := service.NewMapService(tempDir)
+	dsService := service.NewDataSourceService(nil)
+	server := NewServer(mapService, dsService)
+	mapName := "simulate-test"
+
+	testMap := &config.Map{
+		Title: mapName, Width: 500, Height: 500,
+		Nodes: []config.Node{{Name: "a"}, {Name: "b"}, {Name: "c"}},
+		Links: []config.Link{
+			{Name: "a-b", From: "a", To: "b", Bandwidth: "1G"},
+			{Name: "b-c", From: "b", To: "c", Bandwidth: "1G"},
+			{Name: "a-c", From: "a", To: "c", Bandwidth: "10G", Cost: 10},
+		},
+	}
+	if err := mapService.CreateMap(testMap, mapName); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+	for link, utilization := range map[string]float64{"a-b": 60, "b-c": 30, "a-c": 10} {
+		fault := service.SimulatedFault{Map: mapName, Link: link, State: service.FaultStateDegraded, Utilization: &utilization}
+		if _, err := dsService.SimulateFault(fault, time.Minute); err != nil {
+			t.Fatalf("Failed to simulate fault: %v", err)
+		}
+	}
+
+	simulate := func(body string) (*httptest.ResponseRecorder, service.SimulationResult) {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("POST", "/maps/"+mapName+"/simulate", strings.NewReader(body)))
+		var result service.SimulationResult
+		if recorder.Code == http.StatusOK {
+			if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+				t.Fatalf("Failed to decode simulation: %v", err)
+			}
+		}
+		return recorder, result
+	}
+
+	recorder, result := simulate(`{"links": ["a-b"]}`)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status OK, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	projected := make(map[string]service.SimulatedLink)
+	for _, link := range result.Links {
+		projected[link.Name] = link
+	}
+	// 600M of a-b move to a-c (10G) and b-c (1G)
+	if projected["a-b"].Status != "failed" || projected["a-c"].ProjectedUtilization != 16 || projected["b-c"].ProjectedUtilization != 90 {
+		t.Errorf("Unexpected projection: %+v", result.Links)
+	}
+	if len(result.Overloaded) != 0 {
+		t.Errorf("Expected no overloaded links, got %v", result.Overloaded)
+	}
+
+	_, result = simulate(`{"links": ["a-b"], "threshold": 80}`)
+	if strings.Join(result.Overloaded, ",") != "b-c" {
+		t.Errorf("Expected b-c above 80%%, got %v", result.Overloaded)
+	}
+
+	_, result = simulate(`{"links": ["a-b", "a-c"]}`)
+	if strings.Join(result.Unrouted, ",") != "a-b,a-c" {
+		t.Errorf("Expected traffic of a isolated to be unrouted, got %+v", result)
+	}
+
+	_, result = simulate(`{"nodes": ["c"]}`)
+	if strings.Join(result.Failed, ",") != "b-c,a-c" || len(result.Unrouted) != 0 {
+		t.Errorf("Expected links of c to fail without rerouting, got %+v", result)
+	}
+
+	if recorder, _ = simulate(`{"links": ["x-y"]}`); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown link, got %d", recorder.Code)
+	}
+	if recorder, _ = simulate(`{}`); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for empty scenario, got %d", recorder.Code)
+	}
+}
+
+func TestDiscoverTopologyErrors(t *testing.T) {
+	mapService := service.NewMapService(t.TempDir())
+	server := NewServer(mapService, service.NewDataSourceService(nil))
+	if err := mapService.CreateMap(&config.Map{Title: "discover", Width: 500, Height: 500}, "discover"); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+
+	testCases := []struct {
+		name string
+		path string
+		body string
+		code int
+	}{
+		{"UnknownMap", "/maps/missing/discover", `{"seeds": ["10.0.0.1"], "community": "public"}`, http.StatusNotFound},
+		{"InvalidJSON", "/maps/discover/discover", `{"seeds":`, http.StatusBadRequest},
+		{"NoSeeds", "/maps/discover/discover", `{}`, http.StatusBadRequest},
+		{"NoCommunity", "/maps/discover/discover", `{"seeds": ["10.0.0.1"]}`, http.StatusBadRequest},
+		{"Depth", "/maps/discover/discover?apply=true", `{"seeds": ["10.0.0.1"], "community": "public", "depth": 4}`, http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, httptest.NewRequest("POST", tc.path, strings.NewReader(tc.body)))
+			if recorder.Code != tc.code {
+				t.Errorf("Expected status %d, got %d %s", tc.code, recorder.Code, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestSyncNetBox(t *testing.T) {
+	netbox := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		results := `[]`
+		if r.URL.Path == "/api/dcim/devices/" {
+			results = `[{"id": 1, "name": "core-1", "site": {"id": 1, "name": "DC1", "slug": "dc1"}, "primary_ip": null}]`
+		}
+		_, _ = w.Write([]byte(`{"next": null, "results": ` + results + `}`))
+	}))
+	defer netbox.Close()
+	mapService := service.NewMapService(t.TempDir())
+	server := NewServer(mapService, service.NewDataSourceService(nil))
+	for name, section := range map[string]*config.MapNetBox{"synced": {}, "plain": nil} {
+		if err := mapService.CreateMap(&config.Map{Title: name, Width: 500, Height: 500, NetBox: section}, name); err != nil {
+			t.Fatalf("Failed to create map: %v", err)
+		}
+	}
+	post := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("POST", path, nil))
+		return recorder
+	}
+
+	if recorder := post("/maps/synced/netbox/sync"); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without netbox, got %d", recorder.Code)
+	}
+	mapService.EnableNetBox(service.NetBoxConfig{URL: netbox.URL})
+	for path, code := range map[string]int{"/maps/missing/netbox/sync": http.StatusNotFound, "/maps/plain/netbox/sync": http.StatusBadRequest} {
+		if recorder := post(path); recorder.Code != code {
+			t.Errorf("%s: expected status %d, got %d %s", path, code, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	recorder := post("/maps/synced/netbox/sync?apply=true")
+	var result service.NetBoxSyncResult
+	if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil || recorder.Code != http.StatusOK {
+		t.Fatalf("Expected sync result, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if !result.Applied || len(result.NodesAdded) != 1 {
+		t.Errorf("Expected core-1 added, got %+v", result)
+	}
+}
+
+func TestReplaceMap(t *testing.T) {
+	tempDir := t.TempDir()
+	server := NewServer(service.NewMapService(tempDir), nil)
+
+	put := func(body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("PUT", "/maps/declared", strings.NewReader(body)))
+		return recorder
+	}
+
+	body := `{"title": "Declared", "width": 800, "height": 600, "nodes": [{"name": "r1"}, {"name": "r2"}],
+		"links": [{"name": "r1-r2", "from": "r1", "to": "r2", "bandwidth": "1G"}]}`
+	if recorder := put(body); recorder.Code != http.StatusCreated {
+		t.Fatalf("Expected 201 on create, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	body = `{"title": "Declared", "width": 1024, "height": 768, "nodes": [{"name": "r1"}], "links": []}`
+	if recorder := put(body); recorder.Code != http.StatusOK {
+		t.Fatalf("Expected 200 on replace, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	body = `{"title": "Declared", "width": 1024, "height": 768, "nodes": [{"name": "r1"}],
+		"links": [{"name": "broken", "from": "r1", "to": "missing", "bandwidth": "1G"}]}`
+	if recorder := put(body); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid map, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", "/maps/declared", nil))
+	var m config.Map
+	if err := json.Unmarshal(recorder.Body.Bytes(), &m); err != nil {
+		t.Fatalf("Failed to decode map: %v", err)
+	}
+	if m.Width != 1024 || len(m.Nodes) != 1 || len(m.Links) != 0 {
+		t.Errorf("Expected the replaced map to be kept after the invalid push, got %+v", m)
+	}
+
+	entries, _ := os.ReadDir(tempDir)
+	files := slices.DeleteFunc(entries, func(e os.DirEntry) bool { return e.IsDir() }) // the audit log has its own dir
+	if len(files) != 1 {
+		t.Errorf("Expected only the map file in the config dir, got %v", files)
+	}
+}
+
+func TestTrafficMatrixPlanning(t *testing.T) {
+	tempDir := t.TempDir()
+	mapService := service.NewMapService(tempDir)
+	server := NewServer(mapService, nil)
+	mapName := "plan-test"
+
+	testMap := &config.Map{
+		Title: mapName, Width: 500, Height: 500,
+		Nodes: []config.Node{{Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "d"}},
+		Links: []config.Link{
+			{Name: "a-b", From: "a", To: "b", Bandwidth: "1G"},
+			{Name: "b-c", From: "b", To: "c", Bandwidth: "1G"},
+			{Name: "a-c", From: "a", To: "c", Bandwidth: "1G", Cost: 10},
+		},
+	}
+	if err := mapService.CreateMap(testMap, mapName); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return recorder
+	}
+
+	demands := `[{"from": "a", "to": "c", "rate": "500M"}, {"from": "b", "to": "a", "rate": "250M"}, {"from": "a", "to": "d", "rate": "1G"}]`
+	if recorder := serve("PUT", "/maps/"+mapName+"/demands", demands); recorder.Code != http.StatusOK {
+		t.Fatalf("Expected demands to be stored, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := serve("PUT", "/maps/"+mapName+"/demands", `[{"from": "a", "to": "x", "rate": "1G"}]`); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for demand to unknown node, got %d", recorder.Code)
+	}
+
+	recorder := serve("GET", "/maps/"+mapName+"/planned", "")
+	var plan service.PlanResult
+	if err := json.Unmarshal(recorder.Body.Bytes(), &plan); err != nil {
+		t.Fatalf("Failed to decode plan: %v", err)
+	}
+	expected := map[string]float64{"a-b": 75, "b-c": 50, "a-c": 0}
+	for _, link := range plan.Links {
+		if link.Utilization != expected[link.Name] {
+			t.Errorf("Expected %s planned at %.0f%%, got %+v", link.Name, expected[link.Name], link)
+		}
+	}
+	if len(plan.Unrouted) != 1 || plan.Unrouted[0].To != "d" {
+		t.Errorf("Expected the demand to isolated d unrouted, got %+v", plan.Unrouted)
+	}
+
+	recorder = serve("GET", "/maps/"+mapName, "")
+	var mapWithData config.MapWithData
+	if err := json.Unmarshal(recorder.Body.Bytes(), &mapWithData); err != nil {
+		t.Fatalf("Failed to decode map: %v", err)
+	}
+	if len(mapWithData.PlannedData) != 3 || len(mapWithData.Demands) != 3 {
+		t.Errorf("Expected planned data alongside live data, got %+v", mapWithData.PlannedData)
+	}
+
+	if svg := serve("GET", "/maps/"+mapName+"/render.svg", "").Body.String(); strings.Contains(svg, `class="planned"`) {
+		t.Errorf("Expected no planned overlay by default")
+	}
+	if svg := serve("GET", "/maps/"+mapName+"/render.svg?overlay=planned", "").Body.String(); strings.Count(svg, `class="planned"`) != 3 {
+		t.Errorf("Expected planned overlay on every link, got %s", svg)
+	}
+	if recorder := serve("GET", "/maps/"+mapName+"/render.png?overlay=planned", ""); recorder.Code != http.StatusOK {
+		t.Errorf("Expected PNG with planned overlay, got %d", recorder.Code)
+	}
+	if recorder := serve("GET", "/maps/"+mapName+"/render.svg?overlay=forecast", ""); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unknown overlay, got %d", recorder.Code)
+	}
+}
+
+func TestSearchAddress(t *testing.T) {
+	tempDir := t.TempDir()
+	mapService := service.NewMapService(tempDir)
+	server := NewServer(mapService, nil)
+
+	testMap := &config.Map{
+		Title: "dc1", Width: 500, Height: 500,
+		Nodes: []config.Node{
+			{Name: "core1", ManagementIP: "10.1.0.1", Loopback: "10.255.0.1/32", Subnets: []string{"10.1.2.0/24"}},
+			{Name: "edge1", ManagementIP: "10.1.0.2"},
+		},
+		Links: []config.Link{{Name: "core1-edge1", From: "core1", To: "edge1", Bandwidth: "10G", Subnet: "10.1.2.0/30"}},
+	}
+	if err := mapService.CreateMap(testMap, "dc1"); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+
+	search := func(query string) (*httptest.ResponseRecorder, []service.AddressMatch) {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("GET", "/search?"+query, nil))
+		var matches []service.AddressMatch
+		if recorder.Code == http.StatusOK {
+			if err := json.Unmarshal(recorder.Body.Bytes(), &matches); err != nil {
+				t.Fatalf("Failed to decode matches: %v", err)
+			}
+		}
+		return recorder, matches
+	}
+
+	_, matches := search("ip=10.1.2.1")
+	if len(matches) != 2 || matches[0].Name != "core1" || matches[0].Field != "subnets" || matches[1].Name != "core1-edge1" {
+		t.Errorf("Expected node subnet and link subnet, got %+v", matches)
+	}
+	_, matches = search("ip=10.255.0.1")
+	if len(matches) != 1 || matches[0].Field != "loopback" || matches[0].Map != "dc1" {
+		t.Errorf("Expected loopback of core1, got %+v", matches)
+	}
+	if _, matches = search("ip=192.0.2.1"); len(matches) != 0 {
+		t.Errorf("Expected no match, got %+v", matches)
+	}
+	if recorder, _ := search("ip=10.1.2"); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid ip, got %d", recorder.Code)
+	}
+
+	body := `{"name": "bad", "management_ip": "10.1.0.300"}`
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("POST", "/maps/dc1/nodes", strings.NewReader(body)))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected invalid management_ip to be rejected, got %d", recorder.Code)
+	}
+}
+
+func TestInfoURLs(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer target.Close()
+
+	tempDir := t.TempDir()
+	mapService := service.NewMapService(tempDir)
+	server := NewServer(mapService, nil)
+
+	testMap := &config.Map{
+		Title: "urls", Width: 500, Height: 500,
+		Nodes: []config.Node{
+			{Name: "r1", InfoURL: target.URL + "/device/1"},
+			{Name: "r2", InfoURL: target.URL + "/missing"},
+		},
+		Links: []config.Link{{Name: "r1-r2", From: "r1", To: "r2", Bandwidth: "1G", InfoURL: "https://grafana.example/d/r1-r2?a=1&b=2"}},
+	}
+	if err := mapService.CreateMap(testMap, "urls"); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", "/maps/urls/render.svg", nil))
+	svg := recorder.Body.String()
+	if !strings.Contains(svg, `<a xlink:href="`+target.URL+`/device/1" target="_blank">`) ||
+		!strings.Contains(svg, `<a xlink:href="https://grafana.example/d/r1-r2?a=1&amp;b=2" target="_blank">`) {
+		t.Errorf("Expected clickable node and link, got %s", svg)
+	}
+
+	listChecks := func() map[string]service.InfoURLCheck {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("GET", "/maps/urls/urls", nil))
+		var checks []service.InfoURLCheck
+		if err := json.Unmarshal(recorder.Body.Bytes(), &checks); err != nil {
+			t.Fatalf("Failed to decode checks: %v", err)
+		}
+		byName := make(map[string]service.InfoURLCheck)
+		for _, check := range checks {
+			byName[check.Name] = check
+		}
+		return byName
+	}
+	if checks := listChecks(); len(checks) != 3 || checks["r1"].Status != service.InfoURLUnchecked {
+		t.Errorf("Expected 3 unchecked URLs, got %+v", checks)
+	}
+
+	// the link URL can't resolve, it is checked along with the others and fails
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_ = mapService.CheckInfoURLs(ctx)
+	checks := listChecks()
+	if checks["r1"].Status != service.InfoURLStatusOK || checks["r1"].StatusCode != http.StatusOK {
+		t.Errorf("Expected r1 URL to be healthy, got %+v", checks["r1"])
+	}
+	if checks["r2"].Status != service.InfoURLStatusError || checks["r2"].StatusCode != http.StatusNotFound {
+		t.Errorf("Expected r2 URL to fail with 404, got %+v", checks["r2"])
+	}
+	if checks["r1-r2"].Status != service.InfoURLStatusError || checks["r1-r2"].Error == "" {
+		t.Errorf("Expected unresolvable link URL to fail, got %+v", checks["r1-r2"])
+	}
+
+	body := `{"name": "r3", "info_url": "librenms/device/3"}`
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("POST", "/maps/urls/nodes", strings.NewReader(body)))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected relative info_url to be rejected, got %d", recorder.Code)
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	tempDir := t.TempDir()
+	mapService := service.NewMapService(tempDir)
+	server := NewServer(mapService, nil)
+
+	request := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(SandboxKeyHeader, key)
+		}
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		return recorder
+	}
+	listMaps := func(path, key string) []string {
+		recorder := request("GET", path, key, "")
+		var list struct {
+			Maps []string `json:"maps"`
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &list); err != nil {
+			t.Fatalf("Failed to list %s: %d %s", path, recorder.Code, recorder.Body.String())
+		}
+		return list.Maps
+	}
+
+	if recorder := request("GET", "/sandbox/maps", "ci", ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 while the sandbox is disabled, got %d", recorder.Code)
+	}
+	server.EnableSandbox()
+
+	body := `{"title": "Payload Test", "width": 400, "height": 300, "nodes": [{"name": "a"}, {"name": "b"}]}`
+	if recorder := request("POST", "/sandbox/maps", "ci", body); recorder.Code != http.StatusCreated {
+		t.Fatalf("Expected map created in the sandbox, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := request("POST", "/sandbox/maps/payload-test/links", "ci", `{"name": "a-b", "from": "a", "to": "b", "bandwidth": "1G"}`); recorder.Code != http.StatusOK {
+		t.Errorf("Expected link added in the sandbox, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := request("GET", "/sandbox/maps/payload-test", "ci", ""); recorder.Code != http.StatusOK || recorder.Header().Get("X-Sandbox-TTL") != "24h0m0s" {
+		t.Errorf("Expected sandbox map with its TTL, got %d %v", recorder.Code, recorder.Header())
+	}
+
+	if names := listMaps("/sandbox/maps", "ci"); len(names) != 1 || names[0] != "payload-test" {
+		t.Errorf("Expected the map in the ci sandbox, got %v", names)
+	}
+	if names := listMaps("/sandbox/maps", "other"); len(names) != 0 {
+		t.Errorf("Expected another key not to see the ci sandbox, got %v", names)
+	}
+	if names := listMaps("/maps", ""); len(names) != 0 {
+		t.Errorf("Expected no production map, got %v", names)
+	}
+	if recorder := request("GET", "/api/v1/sandbox/maps/payload-test/links", "ci", ""); recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"data"`) {
+		t.Errorf("Expected enveloped sandbox response under /api/v1, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := request("GET", "/sandbox/maps", "", ""); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without sandbox key, got %d", recorder.Code)
+	}
+	if recorder := request("GET", "/sandbox/admin/limits", "ci", ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected only the maps API in the sandbox, got %d", recorder.Code)
+	}
+
+	if deleted, err := mapService.ExpireSandboxes(time.Now().Add(time.Hour)); err != nil || deleted != 0 {
+		t.Errorf("Expected fresh sandbox map kept, got %d %v", deleted, err)
+	}
+	if deleted, err := mapService.ExpireSandboxes(time.Now().Add(service.SandboxTTL + time.Minute)); err != nil || deleted != 1 {
+		t.Errorf("Expected expired sandbox map deleted, got %d %v", deleted, err)
+	}
+	if namespaces, _ := filepath.Glob(filepath.Join(tempDir, "sandbox", "*")); len(namespaces) != 0 {
+		t.Errorf("Expected empty namespaces removed, got %v", namespaces)
+	}
+	if servers := len(server.sandboxes.servers); servers != 0 {
+		t.Errorf("Expected the servers of expired namespaces dropped, got %d", servers)
+	}
+	if names := listMaps("/sandbox/maps", "ci"); len(names) != 0 {
+		t.Errorf("Expected empty sandbox after expiry, got %v", names)
+	}
+
+	for i := range service.MaxSandboxes {
+		if err := os.MkdirAll(filepath.Join(tempDir, "sandbox", fmt.Sprintf("ns-%d", i)), 0755); err != nil {
+			t.Fatalf("Failed to create namespace: %v", err)
+		}
+	}
+	if recorder := request("GET", "/sandbox/maps", "one-too-many", ""); recorder.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 above %d namespaces, got %d", service.MaxSandboxes, recorder.Code)
+	}
+	if recorder := request("GET", "/sandbox/maps", "ci", ""); recorder.Code != http.StatusOK {
+		t.Errorf("Expected existing namespaces served at the limit, got %d", recorder.Code)
+	}
+}
+
+func TestSandboxIsolation(t *testing.T) {
+	var fetches atomic.Int32
+	calendar := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+	}))
+	defer calendar.Close()
+
+	sourceService := service.NewMapService(t.TempDir())
+	sourceIcons := t.TempDir()
+	sourceService.SetIconsDir(sourceIcons)
+	custom := []byte(`<svg xmlns="http://www.w3.org/2000/svg"><circle r="4"/></svg>`)
+	if err := os.WriteFile(filepath.Join(sourceIcons, "custom.svg"), custom, 0644); err != nil {
+		t.Fatal(err)
+	}
+	bundleMap := &config.Map{
+		Title: "Bundle", Width: 400, Height: 300,
+		Nodes:     []config.Node{{Name: "a", Icon: "custom.svg"}},
+		Schedules: []config.Schedule{{Name: "on-call", ICalURL: calendar.URL + "/noc.ics"}},
+	}
+	if err := sourceService.CreateMap(bundleMap, "bundle"); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+	recorder := httptest.NewRecorder()
+	NewServer(sourceService, nil).ServeHTTP(recorder, httptest.NewRequest("GET", "/maps/bundle/bundle", nil))
+	archive := recorder.Body.Bytes()
+
+	mapService := service.NewMapService(t.TempDir())
+	iconsDir := t.TempDir()
+	mapService.SetIconsDir(iconsDir)
+	if err := os.WriteFile(filepath.Join(iconsDir, "server.svg"), custom, 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(mapService, nil)
+	server.EnableSandbox()
+	request := func(method, path string, body io.Reader) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, body)
+		req.Header.Set(SandboxKeyHeader, "ci")
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	if recorder := request("POST", "/sandbox/maps/bundle/bundle", bytes.NewReader(archive)); recorder.Code != http.StatusCreated {
+		t.Fatalf("Expected the bundle imported in the sandbox, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(iconsDir, "custom.svg")); !os.IsNotExist(err) {
+		t.Errorf("Expected the icon of the sandbox kept out of the server icons, got %v", err)
+	}
+	sandbox, err := mapService.Sandbox("ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, icon := range []string{"custom.svg", "server.svg"} {
+		if _, _, err := sandbox.GetIconFile(icon); err != nil {
+			t.Errorf("Expected %s in the sandbox, got %v", icon, err)
+		}
+	}
+	if _, _, err := mapService.GetIconFile("custom.svg"); err == nil {
+		t.Error("Expected the icon of the sandbox missing from the server")
+	}
+
+	recorder = request("GET", "/sandbox/maps/bundle/schedules", nil)
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "not fetched in a sandbox") {
+		t.Errorf("Expected the calendar not fetched, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if n := fetches.Load(); n != 0 {
+		t.Errorf("Expected no request of the sandbox to the calendar, got %d", n)
+	}
+
+	for i := 1; i < service.MaxSandboxMaps; i++ {
+		body := fmt.Sprintf(`{"title": "map %d", "width": 100, "height": 100}`, i)
+		if recorder := request("POST", "/sandbox/maps", strings.NewReader(body)); recorder.Code != http.StatusCreated {
+			t.Fatalf("Expected map %d created, got %d %s", i, recorder.Code, recorder.Body.String())
+		}
+	}
+	recorder = request("POST", "/sandbox/maps", strings.NewReader(`{"title": "one too many", "width": 100, "height": 100}`))
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 above %d maps, got %d %s", service.MaxSandboxMaps, recorder.Code, recorder.Body.String())
+	}
+	large := &config.Map{Title: "bundle", Width: 100, Height: 100, Nodes: []config.Node{{Name: "a", Label: strings.Repeat("x", service.MaxSandboxBytes)}}}
+	if _, err := sandbox.ReplaceMap("bundle", large); err == nil || !strings.Contains(err.Error(), "exceed limit") {
+		t.Errorf("Expected a map above %d bytes refused, got %v", service.MaxSandboxBytes, err)
+	}
+}
+
+func TestSchedules(t *testing.T) {
+	now := time.Now().UTC()
+	var fetches atomic.Int32
+	calendar := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Content-Type", "text/calendar")
+		fmt.Fprintf(w, "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART:%s\r\nDTEND:%s\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+			now.Add(-time.Hour).Format("20060102T150405Z"), now.Add(time.Hour).Format("20060102T150405Z"))
+	}))
+	defer calendar.Close()
+
+	tempDir := t.TempDir()
+	mapService := service.NewMapService(tempDir)
+	server := NewServer(mapService, nil)
+	testMap := &config.Map{
+		Title: "schedules", Width: 500, Height: 500,
+		Schedules: []config.Schedule{
+			{Name: "always", Windows: []config.ScheduleWindow{{Start: "00:00", End: "24:00"}}},
+			{Name: "other-day", Windows: []config.ScheduleWindow{{Days: []string{config.ScheduleDays[(now.Weekday()+3)%7]}, Start: "12:00", End: "13:00"}}},
+			{Name: "on-call", ICalURL: calendar.URL + "/noc.ics"},
+		},
+	}
+	if err := mapService.CreateMap(testMap, "schedules"); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("GET", "/maps/schedules/schedules", nil))
+		var states []service.ScheduleState
+		if err := json.Unmarshal(recorder.Body.Bytes(), &states); err != nil || recorder.Code != http.StatusOK {
+			t.Fatalf("Failed to get schedules: %d %s", recorder.Code, recorder.Body.String())
+		}
+		if len(states) != 3 || !states[0].Active || states[1].Active || states[2].Error != "" || !states[2].Active {
+			t.Errorf("Expected always and on-call active, got %+v", states)
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("Expected the calendar to be fetched once, got %d", n)
+	}
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", "/maps/missing/schedules", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown map, got %d", recorder.Code)
+	}
+
+	for _, schedule := range []string{
+		`{"name": "night", "windows": [{"start": "22:00", "end": "25:00"}]}`,
+		`{"name": "night", "windows": [{"days": ["monday"], "start": "22:00", "end": "06:00"}]}`,
+		`{"name": "night", "timezone": "Mars/Olympus", "windows": [{"start": "22:00", "end": "06:00"}]}`,
+		`{"name": "on-call", "ical_url": "webcal://pager.example.net/noc.ics"}`,
+		`{"name": "empty"}`,
+	} {
+		body := `{"title": "bad", "width": 100, "height": 100, "schedules": [` + schedule + `]}`
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("PUT", "/maps/bad", strings.NewReader(body)))
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("Expected schedule %s to be rejected, got %d", schedule, recorder.Code)
+		}
+	}
+}
+
+func TestMapTemplates(t *testing.T) {
+	tempDir := t.TempDir()
+	server := NewServer(service.NewMapService(tempDir), nil)
+
+	template := `description: Standard POP site
+parameters:
+  - name: site
+    description: site code
+  - name: uplink_bandwidth
+    default: 10G
+map:
+  title: "POP {{ .site }}"
+  width: 800
+  height: 600
+  nodes:
+    - name: "{{ .site }}-core"
+      position: {x: 100, y: 100}
+    - name: "{{ .site }}-edge"
+      position: {x: 300, y: 100}
+  links:
+    - name: uplink
+      from: "{{ .site }}-core"
+      to: "{{ .site }}-edge"
+      bandwidth: "{{ .uplink_bandwidth }}"
+`
+	if err := os.MkdirAll(filepath.Join(tempDir, "templates"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "templates", "pop-site.yaml"), []byte(template), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return recorder
+	}
+
+	recorder := serve("GET", "/templates", "")
+	var templates []service.MapTemplate
+	if err := json.Unmarshal(recorder.Body.Bytes(), &templates); err != nil {
+		t.Fatalf("Failed to decode templates: %v", err)
+	}
+	if len(templates) != 1 || templates[0].Name != "pop-site" || len(templates[0].Parameters) != 2 {
+		t.Errorf("Expected pop-site template with 2 parameters, got %+v", templates)
+	}
+
+	recorder = serve("POST", "/maps?template=pop-site", `{"parameters": {"site": "ams1"}}`)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("Expected map created from template, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	recorder = serve("GET", "/maps/pop-ams1", "")
+	var m config.Map
+	if err := json.Unmarshal(recorder.Body.Bytes(), &m); err != nil {
+		t.Fatalf("Failed to decode map: %v", err)
+	}
+	if m.Title != "POP ams1" || len(m.Nodes) != 2 || m.Nodes[0].Name != "ams1-core" || m.Links[0].Bandwidth != "10G" {
+		t.Errorf("Unexpected map from template: %+v", m)
+	}
+
+	recorder = serve("POST", "/maps?template=pop-site", `{"name": "fra", "parameters": {"site": "fra1", "uplink_bandwidth": "100G"}}`)
+	if recorder.Code != http.StatusCreated || !strings.Contains(recorder.Body.String(), `"fra"`) {
+		t.Errorf("Expected map fra to be created, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	cases := []struct {
+		name, path, body string
+		status           int
+	}{
+		{"MissingParameter", "/maps?template=pop-site", `{"parameters": {}}`, http.StatusBadRequest},
+		{"UnknownParameter", "/maps?template=pop-site", `{"parameters": {"site": "x", "vlan": "1"}}`, http.StatusBadRequest},
+		{"InvalidValue", "/maps?template=pop-site", `{"name": "bad", "parameters": {"site": "x", "uplink_bandwidth": "fast"}}`, http.StatusBadRequest},
+		{"Exists", "/maps?template=pop-site", `{"parameters": {"site": "ams1"}}`, http.StatusConflict},
+		{"UnknownTemplate", "/maps?template=dc", `{"parameters": {}}`, http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		if recorder := serve("POST", tc.path, tc.body); recorder.Code != tc.status {
+			t.Errorf("%s: expected %d, got %d %s", tc.name, tc.status, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	if recorder := serve("GET", "/maps", ""); strings.Contains(recorder.Body.String(), "templates") {
+		t.Errorf("Expected templates not to be listed as maps, got %s", recorder.Body.String())
+	}
+}
+
+func TestAccessibleRendering(t *testing.T) {
+	tempDir := t.TempDir()
+	mapService := service.NewMapService(tempDir)
+	dsService := service.NewDataSourceService(nil)
+	server := NewServer(mapService, dsService)
+	mapName := "a11y-test"
+
+	testMap := &config.Map{
+		Title: mapName, Width: 500, Height: 500,
+		Nodes: []config.Node{{Name: "a"}, {Name: "b", Position: config.Position{X: 200, Y: 100}}},
+		Links: []config.Link{{Name: "a-b", From: "a", To: "b", Bandwidth: "1G"}},
+	}
+	if err := mapService.CreateMap(testMap, mapName); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+	utilization := 60.0
+	fault := service.SimulatedFault{Map: mapName, Link: "a-b", State: service.FaultStateDegraded, Utilization: &utilization}
+	if _, err := dsService.SimulateFault(fault, time.Minute); err != nil {
+		t.Fatalf("Failed to simulate fault: %v", err)
+	}
+
+	render := func(path string) string {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Expected status OK for %s, got %d %s", path, recorder.Code, recorder.Body.String())
+		}
+		return recorder.Body.String()
+	}
+
+	svg := render("/maps/" + mapName + "/render.svg")
+	if strings.Contains(svg, `class="hatch"`) || !strings.Contains(svg, `stroke="#f0f000"`) {
+		t.Errorf("Expected classic rendering by default")
+	}
+
+	svg = render("/maps/" + mapName + "/render.svg?accessible=true")
+	if !strings.Contains(svg, `<path class="hatch"`) || !strings.Contains(svg, `font-weight="bold" text-anchor="middle"`) {
+		t.Errorf("Expected hatched link and bold labels, got %s", svg)
+	}
+	if strings.Contains(svg, `stroke="#f0f000"`) || !strings.Contains(svg, `stroke="#2c748d"`) {
+		t.Errorf("Expected the 55-70 band from the colorblind-safe palette, got %s", svg)
+	}
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("PATCH", "/maps/"+mapName, strings.NewReader(`{"accessible": true}`)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Failed to enable accessible mode on the map: %d", recorder.Code)
+	}
+	if svg = render("/maps/" + mapName + "/render.svg"); !strings.Contains(svg, `class="hatch"`) {
+		t.Errorf("Expected map setting to enable accessible mode")
+	}
+	if svg = render("/maps/" + mapName + "/render.svg?accessible=false"); strings.Contains(svg, `class="hatch"`) {
+		t.Errorf("Expected request to override the map setting")
+	}
+	render("/maps/" + mapName + "/render.png")
+
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", "/maps/"+mapName+"/render.svg?accessible=maybe", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid accessible value, got %d", recorder.Code)
+	}
+}
+
+func TestExportPDF(t *testing.T) {
+	tempDir := t.TempDir()
+	mapService := service.NewMapService(tempDir)
+	dsService := service.NewDataSourceService(nil)
+	server := NewServer(mapService, dsService)
+	mapName := "pdf-test"
+
+	testMap := &config.Map{
+		Title: "CAB (change 42)", Width: 800, Height: 600,
+		Nodes:  []config.Node{{Name: "a"}, {Name: "b", Position: config.Position{X: 300, Y: 100}}},
+		Links:  []config.Link{{Name: "a-b", From: "a", To: "b", Bandwidth: "1G", Scale: "core"}},
+		Scales: map[string][]config.Scale{"core": {{Min: 0, Max: 100, Color: config.Color{R: 0, G: 128, B: 0}}}},
+	}
+	if err := mapService.CreateMap(testMap, mapName); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+
+	export := func(query string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("GET", "/maps/"+mapName+"/export?format=pdf"+query, nil))
+		return recorder
+	}
+
+	recorder := export("")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status OK, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if ct := recorder.Header().Get("Content-Type"); ct != "application/pdf" {
+		t.Errorf("Expected application/pdf, got %s", ct)
+	}
+	pdf := recorder.Body.String()
+	for _, expected := range []string{
+		"%PDF-1.4",
+		"/Count 2",
+		"/MediaBox [0 0 841.89 595.28]", // wide map on landscape A4
+		`(CAB \(change 42\)) Tj`,
+		"(Legend) Tj",
+		"(Utilization scale core) Tj",
+		"%%EOF",
+	} {
+		if !strings.Contains(pdf, expected) {
+			t.Errorf("Expected PDF to contain %q", expected)
+		}
+	}
+
+	if pdf = export("&paper=A3&orientation=portrait").Body.String(); !strings.Contains(pdf, "/MediaBox [0 0 841.89 1190.55]") {
+		t.Errorf("Expected portrait A3 page")
+	}
+	for _, query := range []string{"&paper=letter", "&orientation=diagonal"} {
+		if recorder := export(query); recorder.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", query, recorder.Code)
+		}
+	}
+}
+
+func TestLinkSnapshot(t *testing.T) {
+	tempDir := t.TempDir()
+	mapService := service.NewMapService(tempDir)
+	server := NewServer(mapService, service.NewDataSourceService(nil))
+	mapName := "snapshot-test"
+
+	testMap := &config.Map{
+		Title: mapName, Width: 800, Height: 600,
+		Nodes: []config.Node{
+			{Name: "a", Position: config.Position{X: 100, Y: 100}},
+			{Name: "b", Position: config.Position{X: 300, Y: 150}},
+			{Name: "c", Position: config.Position{X: 780, Y: 580}},
+		},
+		Links: []config.Link{
+			{Name: "a-b", From: "a", To: "b", Bandwidth: "1G"},
+			{Name: "b-c", From: "b", To: "c", Bandwidth: "1G"},
+		},
+	}
+	if err := mapService.CreateMap(testMap, mapName); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+
+	snapshot := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("GET", "/maps/"+mapName+"/links/"+path, nil))
+		return recorder
+	}
+
+	for path, size := range map[string][2]int{
+		"a-b/snapshot.png?padding=50": {301, 151},
+		"b-c/snapshot.png":            {800 - 220, 600 - 70}, // clipped to the map
+		"a-b/snapshot.png?width=602":  {602, 0},
+	} {
+		recorder := snapshot(path)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Expected status OK for %s, got %d %s", path, recorder.Code, recorder.Body.String())
+		}
+		img, err := png.Decode(recorder.Body)
+		if err != nil {
+			t.Fatalf("Failed to decode snapshot %s: %v", path, err)
+		}
+		if img.Bounds().Dx() != size[0] || (size[1] > 0 && img.Bounds().Dy() != size[1]) {
+			t.Errorf("Expected %s to be %dx%d, got %v", path, size[0], size[1], img.Bounds())
+		}
+	}
+
+	if recorder := snapshot("missing/snapshot.png"); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for missing link, got %d", recorder.Code)
+	}
+	if recorder := snapshot("a-b/snapshot.png?padding=-1"); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid padding, got %d", recorder.Code)
+	}
+}
+
+func TestViewportAndTiles(t *testing.T) {
+	tempDir := t.TempDir()
+	mapService := service.NewMapService(tempDir)
+	server := NewServer(mapService, service.NewDataSourceService(nil))
+	mapName := "tiles-test"
+
+	testMap := &config.Map{
+		Title: mapName, Width: 1000, Height: 500,
+		Nodes: []config.Node{{Name: "a", Position: config.Position{X: 100, Y: 100}}, {Name: "b", Position: config.Position{X: 900, Y: 400}}},
+		Links: []config.Link{{Name: "a-b", From: "a", To: "b", Bandwidth: "1G"}},
+	}
+	if err := mapService.CreateMap(testMap, mapName); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("GET", "/maps/"+mapName+"/"+path, nil))
+		return recorder
+	}
+	decode := func(path string) image.Image {
+		recorder := get(path)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Expected status OK for %s, got %d %s", path, recorder.Code, recorder.Body.String())
+		}
+		img, err := png.Decode(recorder.Body)
+		if err != nil {
+			t.Fatalf("Failed to decode %s: %v", path, err)
+		}
+		return img
+	}
+
+	svg := get("render.svg?x=100&y=50&w=200&h=100&zoom=2").Body.String()
+	if !strings.Contains(svg, `width="400" height="200" viewBox="100 50 200 100"`) {
+		t.Errorf("Expected zoomed viewport in SVG, got %s", svg)
+	}
+	if img := decode("render.png?x=100&y=50&w=200&h=100&zoom=2"); img.Bounds().Dx() != 400 || img.Bounds().Dy() != 200 {
+		t.Errorf("Expected 400x200 PNG viewport, got %v", img.Bounds())
+	}
+
+	tile := decode("tiles/0/0/0.png")
+	if tile.Bounds().Dx() != 256 || tile.Bounds().Dy() != 256 {
+		t.Errorf("Expected 256x256 tile, got %v", tile.Bounds())
+	}
+	if _, _, _, a := tile.At(10, 10).RGBA(); a == 0 {
+		t.Errorf("Expected the map to cover the top of the tile")
+	}
+	if _, _, _, a := tile.At(10, 200).RGBA(); a != 0 {
+		t.Errorf("Expected the tile below the map to be transparent")
+	}
+	decode("tiles/1/1/0.png")
+
+	for path, code := range map[string]int{
+		"render.png?w=100":            http.StatusBadRequest,
+		"render.svg?x=2000&w=10&h=10": http.StatusBadRequest,
+		"render.png?zoom=0":           http.StatusBadRequest,
+		"tiles/1/0/1.png":             http.StatusNotFound,
+		"tiles/11/0/0.png":            http.StatusBadRequest,
+		"tiles/0/0/abc":               http.StatusBadRequest,
+	} {
+		if recorder := get(path); recorder.Code != code {
+			t.Errorf("Expected %d for %s, got %d", code, path, recorder.Code)
+		}
+	}
+}
+
+func TestOIDCAuthentication(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	weakKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+	b64 := base64.RawURLEncoding.EncodeToString
+	var fetches atomic.Int32
+	issuer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			utils.RespondWithJSON(w, http.StatusOK, map[string]string{"jwks_uri": "http://" + r.Host + "/jwks"})
+		case "/jwks":
+			fetches.Add(1)
+			time.Sleep(50 * time.Millisecond) // slow issuer, concurrent requests wait for the same fetch
+			utils.RespondWithJSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{
+				{"kty": "RSA", "kid": "rsa1", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+				{"kty": "RSA", "kid": "weak1", "use": "sig", "n": b64(weakKey.N.Bytes()), "e": b64(big.NewInt(int64(weakKey.E)).Bytes())},
+				{"kty": "EC", "kid": "ec1", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+			}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer issuer.Close()
+
+	sign := func(alg, kid string, claims map[string]any) string {
+		header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+		payload, _ := json.Marshal(claims)
+		signed := b64(header) + "." + b64(payload)
+		digest := sha256.Sum256([]byte(signed))
+		var signature []byte
+		switch alg {
+		case "RS256":
+			key := rsaKey
+			if kid == "weak1" {
+				key = weakKey
+			}
+			signature, _ = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		case "ES256":
+			r, s, _ := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+			signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		case "HS256":
+			mac := hmac.New(sha256.New, []byte("secret"))
+			mac.Write([]byte(signed))
+			signature = mac.Sum(nil)
+		}
+		return signed + "." + b64(signature)
+	}
+	claims := func(overrides map[string]any) map[string]any {
+		c := map[string]any{"iss": issuer.URL, "aud": []string{"weathermap", "other"}, "sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}
+		for key, value := range overrides {
+			c[key] = value
+		}
+		return c
+	}
+
+	server := NewServer(service.NewMapService(t.TempDir()), nil)
+	server.SetVerifier(auth.NewVerifier(auth.Config{Issuer: issuer.URL, Audience: "weathermap"}))
+	request := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	if recorder := request("/health", ""); recorder.Code != http.StatusOK {
+		t.Errorf("Expected health check without token, got %d", recorder.Code)
+	}
+	recorder := request("/maps", "")
+	if recorder.Code != http.StatusUnauthorized || recorder.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("Expected 401 with WWW-Authenticate without token, got %d", recorder.Code)
+	}
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if recorder := request("/maps", sign("RS256", "rsa1", claims(nil))); recorder.Code != http.StatusOK {
+				t.Errorf("Expected concurrent tokens accepted, got %d", recorder.Code)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("Expected the signing keys fetched once for concurrent requests, got %d", n)
+	}
+
+	recorder = request("/api/v1/auth/whoami", sign("RS256", "rsa1", claims(nil)))
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"sub":"alice"`) {
+		t.Errorf("Expected claims of a valid RS256 token, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder = request("/maps", sign("ES256", "ec1", claims(map[string]any{"aud": "weathermap"}))); recorder.Code != http.StatusOK {
+		t.Errorf("Expected valid ES256 token to be accepted, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	queryToken := "?access_token=" + sign("RS256", "rsa1", claims(nil))
+	if recorder = request("/maps/missing/events"+queryToken, ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected token in access_token to be accepted on event streams, got %d", recorder.Code)
+	}
+	for _, path := range []string{"/maps", "/api/v1/maps", "/maps/missing", "/maps/missing/render.svg"} {
+		if recorder = request(path+queryToken, ""); recorder.Code != http.StatusUnauthorized {
+			t.Errorf("Expected access_token to be refused on %s, got %d", path, recorder.Code)
+		}
+	}
+
+	for name, token := range map[string]string{
+		"wrong audience": sign("RS256", "rsa1", claims(map[string]any{"aud": "grafana"})),
+		"wrong issuer":   sign("RS256", "rsa1", claims(map[string]any{"iss": "https://evil.example"})),
+		"expired":        sign("RS256", "rsa1", claims(map[string]any{"exp": time.Now().Add(-time.Hour).Unix()})),
+		"unknown key":    sign("RS256", "rsa2", claims(nil)),
+		"weak RSA key":   sign("RS256", "weak1", claims(nil)),
+		"hmac":           sign("HS256", "rsa1", claims(nil)),
+		"wrong key type": sign("ES256", "rsa1", claims(nil)),
+		"malformed":      "not-a-jwt",
+	} {
+		if recorder := request("/maps", token); recorder.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for %s token, got %d", name, recorder.Code)
+		}
+	}
+}
+
+func TestMapAccessControl(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	b64 := base64.RawURLEncoding.EncodeToString
+	issuer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa1", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		}})
+	}))
+	defer issuer.Close()
+	token := func(sub string, groups ...string) string {
+		header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "rsa1"})
+		payload, _ := json.Marshal(map[string]any{"iss": issuer.URL, "aud": "weathermap", "sub": sub, "groups": groups, "exp": time.Now().Add(time.Hour).Unix()})
+		signed := b64(header) + "." + b64(payload)
+		digest := sha256.Sum256([]byte(signed))
+		signature, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+		return signed + "." + b64(signature)
+	}
+
+	tempDir := t.TempDir()
+	mapService := service.NewMapService(tempDir)
+	for _, name := range []string{"backbone-core", "access-east"} {
+		if err := mapService.CreateMap(&config.Map{Title: name, Width: 100, Height: 100}, name); err != nil {
+			t.Fatalf("Failed to create map: %v", err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(tempDir, "access"), 0755); err != nil {
+		t.Fatalf("Failed to create access dir: %v", err)
+	}
+	policy := `map_groups:
+  backbone: [backbone-*]
+rules:
+  - maps: ["*"]
+    subjects: [group:noc, group:backbone]
+    role: viewer
+  - maps: [group:backbone]
+    subjects: [group:backbone]
+    role: editor
+  - maps: ["*"]
+    subjects: [user:root]
+    role: admin
+  - maps: ["*"]
+    subjects: [user:ed]
+    role: editor
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "access", "roles.yaml"), []byte(policy), 0644); err != nil {
+		t.Fatalf("Failed to write access policy: %v", err)
+	}
+
+	eastSNMP := config.DataSourceConfig{Name: "east-snmp", Type: "mock", Interfaces: []config.InterfaceConfig{{Name: "Gi0/0/1"}}}
+	if _, err := mapService.ReplaceMap("access-east", &config.Map{Title: "access-east", Width: 100, Height: 100, Datasources: []config.DataSourceConfig{eastSNMP}}); err != nil {
+		t.Fatalf("Failed to add datasource: %v", err)
+	}
+	dsService := service.NewDataSourceService([]config.DataSourceConfig{eastSNMP})
+	server := NewServer(mapService, dsService)
+	server.SetVerifier(auth.NewVerifier(auth.Config{Issuer: issuer.URL, Audience: "weathermap", JWKSURL: issuer.URL}))
+	request := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		return recorder
+	}
+	noc, backbone, stranger, root := token("nina", "noc"), token("bob", "backbone"), token("eve"), token("root")
+	node := `{"Name": "r1", "position": {"x": 10, "y": 10}}`
+
+	recorder := request("GET", "/maps", noc, "")
+	if !strings.Contains(recorder.Body.String(), "backbone-core") || !strings.Contains(recorder.Body.String(), "access-east") {
+		t.Errorf("Expected noc to list every map, got %s", recorder.Body.String())
+	}
+	if recorder = request("GET", "/maps", stranger, ""); strings.Contains(recorder.Body.String(), "backbone-core") {
+		t.Errorf("Expected maps without a role to be hidden, got %s", recorder.Body.String())
+	}
+	if recorder = request("GET", "/maps/access-east", stranger, ""); recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a map without a role, got %d", recorder.Code)
+	}
+	if recorder = request("GET", "/maps/backbone-core", noc, ""); recorder.Code != http.StatusOK {
+		t.Errorf("Expected viewer to read the map, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder = request("POST", "/maps/backbone-core/nodes", noc, node); recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected viewer not to edit, got %d", recorder.Code)
+	}
+	if recorder = request("POST", "/maps/backbone-core/nodes", backbone, node); recorder.Code != http.StatusOK {
+		t.Errorf("Expected backbone editor to edit backbone map, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder = request("POST", "/maps/access-east/nodes", backbone, node); recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected backbone team not to edit other maps, got %d", recorder.Code)
+	}
+	if recorder = request("POST", "/maps", backbone, `{"title": "backbone-west", "width": 100, "height": 100}`); recorder.Code != http.StatusCreated {
+		t.Errorf("Expected editor to create a map in its group, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder = request("DELETE", "/maps/backbone-core", backbone, ""); recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected editor not to delete a map, got %d", recorder.Code)
+	}
+	if recorder = request("DELETE", "/maps/backbone-core", root, ""); recorder.Code != http.StatusOK {
+		t.Errorf("Expected admin to delete a map, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	if recorder = request("POST", "/admin/reload", backbone, ""); recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected editor not to reload the configuration, got %d", recorder.Code)
+	}
+	if recorder = request("POST", "/admin/reload", root, ""); recorder.Code != http.StatusOK {
+		t.Errorf("Expected admin of every map to reload the configuration, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	mapService.SetIconsDir(t.TempDir())
+	uploadIcon := func(query, token string) int {
+		var form bytes.Buffer
+		writer := multipart.NewWriter(&form)
+		part, _ := writer.CreateFormFile("file", "ap.svg")
+		_, _ = part.Write([]byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`))
+		_ = writer.Close()
+		req := httptest.NewRequest("POST", "/icons"+query, &form)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+	if code := uploadIcon("", backbone); code != http.StatusForbidden {
+		t.Errorf("Expected an editor of some maps not to upload icons, got %d", code)
+	}
+	if code := uploadIcon("", token("ed")); code != http.StatusCreated {
+		t.Errorf("Expected editor of every map to upload an icon, got %d", code)
+	}
+	if code := uploadIcon("?replace=true", token("ed")); code != http.StatusForbidden {
+		t.Errorf("Expected editor not to replace an icon, got %d", code)
+	}
+	if code := uploadIcon("?replace=true", root); code != http.StatusCreated {
+		t.Errorf("Expected admin to replace an icon, got %d", code)
+	}
+
+	for _, path := range []string{"/admin/pollers/stats", "/admin/limits", "/admin/history", "/cluster/metrics", "/cluster/status", "/agents", "/api/v1/agents"} {
+		if recorder = request("GET", path, noc, ""); recorder.Code != http.StatusForbidden {
+			t.Errorf("Expected a viewer of every map to get 403 for %s, got %d", path, recorder.Code)
+		}
+		if recorder = request("GET", path, root, ""); recorder.Code == http.StatusForbidden {
+			t.Errorf("Expected admin of every map to read %s, got %d", path, recorder.Code)
+		}
+	}
+
+	_, _ = dsService.GetInterfaceMetrics(context.Background(), "east-snmp", "Gi0/0/9", nil)
+	if err := os.WriteFile(filepath.Join(tempDir, "access-west.yaml"), []byte("width: [\n"), 0644); err != nil {
+		t.Fatalf("Failed to write map: %v", err)
+	}
+	for _, check := range []struct {
+		path, hidden string
+	}{
+		{"/datasources", "east-snmp"},
+		{"/admin/misconfigurations", "east-snmp"},
+		{"/admin/lint", "access-west.yaml"},
+	} {
+		if recorder = request("GET", check.path, noc, ""); !strings.Contains(recorder.Body.String(), check.hidden) {
+			t.Errorf("Expected viewer of the map to see %s in %s, got %d %s", check.hidden, check.path, recorder.Code, recorder.Body.String())
+		}
+		recorder = request("GET", check.path, stranger, "")
+		if recorder.Code != http.StatusOK || strings.Contains(recorder.Body.String(), check.hidden) {
+			t.Errorf("Expected %s in %s to be hidden without a role on its map, got %d %s", check.hidden, check.path, recorder.Code, recorder.Body.String())
+		}
+	}
+	if recorder = request("GET", "/datasources/east-snmp", stranger, ""); recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a datasource of a map without a role, got %d", recorder.Code)
+	}
+	if recorder = request("GET", "/datasources/east-snmp", noc, ""); recorder.Code != http.StatusOK {
+		t.Errorf("Expected viewer to read a datasource of its map, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	server.EnableFaults()
+	fault := `{"datasource": "core", "state": "down", "duration": "1m"}`
+	if recorder = request("POST", "/admin/faults", backbone, fault); recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected editor not to simulate faults, got %d", recorder.Code)
+	}
+	if recorder = request("POST", "/admin/faults", root, fault); recorder.Code != http.StatusCreated {
+		t.Errorf("Expected admin of every map to simulate faults, got %d %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	tempDir := t.TempDir()
+	server := NewServer(service.NewMapService(tempDir), service.NewDataSourceService(nil))
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		return recorder
+	}
+	entries := func(path string) []service.AuditEntry {
+		recorder := request("GET", path, "")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Expected audit log, got %d %s", recorder.Code, recorder.Body.String())
+		}
+		var entries []service.AuditEntry
+		if err := json.Unmarshal(recorder.Body.Bytes(), &entries); err != nil {
+			t.Fatalf("Failed to decode audit log: %v", err)
+		}
+		return entries
+	}
+
+	if recorder := request("POST", "/maps", `{"title": "audited", "width": 100, "height": 100}`); recorder.Code != http.StatusCreated {
+		t.Fatalf("Failed to create map: %d %s", recorder.Code, recorder.Body.String())
+	}
+	request("POST", "/maps/audited/nodes", `{"Name": "r1", "position": {"x": 10, "y": 10}}`)
+	request("POST", "/maps/audited/nodes", `{"Name": "r1", "position": {"x": 10, "y": 10}}`) // duplicate, rejected
+	request("PATCH", "/maps/audited/variables", `{"password": "secret"}`)
+	request("DELETE", "/maps/audited/nodes/r1", "")
+	request("GET", "/maps/audited", "")
+
+	log := entries("/maps/audited/audit")
+	if len(log) != 4 {
+		t.Fatalf("Expected 4 audit entries, got %d: %+v", len(log), log)
+	}
+	if log[0].Action != service.AuditCreate || log[0].Actor != "anonymous" || log[0].Request != "POST /maps" {
+		t.Errorf("Expected anonymous map creation first, got %+v", log[0])
+	}
+	if c := log[1].Changes; len(c) != 1 || c[0].Object != "node" || c[0].Name != "r1" || c[0].Op != "added" || c[0].After == nil {
+		t.Errorf("Expected added node r1, got %+v", c)
+	}
+	if c := log[2].Changes; len(c) != 1 || c[0].Object != "variable" || c[0].Name != "password" || c[0].After != nil {
+		t.Errorf("Expected added variable without its value, got %+v", c)
+	}
+	if c := log[3].Changes; len(c) != 1 || c[0].Op != "removed" || c[0].Before == nil {
+		t.Errorf("Expected removed node r1, got %+v", c)
+	}
+
+	request("DELETE", "/maps/audited", "")
+	log = entries("/audit?map=audited")
+	if len(log) != 5 || log[4].Action != service.AuditDelete {
+		t.Errorf("Expected map deletion to be audited, got %+v", log)
+	}
+	if log = entries("/audit?since=" + time.Now().Add(time.Hour).Format(time.RFC3339)); len(log) != 0 {
+		t.Errorf("Expected no entries in the future, got %d", len(log))
+	}
+	if recorder := request("GET", "/audit?since=yesterday", ""); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid since, got %d", recorder.Code)
+	}
+}
+
+func TestUpdatedSinceFilter(t *testing.T) {
+	server := NewServer(service.NewMapService(t.TempDir()), service.NewDataSourceService(nil))
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return recorder
+	}
+	request("POST", "/maps", `{"title": "synced", "width": 100, "height": 100}`)
+	request("POST", "/maps/synced/nodes", `{"Name": "r1", "position": {"x": 10, "y": 10}}`)
+	request("POST", "/maps/synced/links", `{"Name": "r1-r1", "From": "r1", "To": "r1", "Bandwidth": "1G"}`)
+
+	var m config.Map
+	if err := json.Unmarshal(request("GET", "/maps/synced", "").Body.Bytes(), &m); err != nil {
+		t.Fatalf("Failed to decode map: %v", err)
+	}
+	if m.CreatedAt == nil || m.UpdatedAt == nil || len(m.Nodes) != 1 || m.Nodes[0].UpdatedAt == nil {
+		t.Fatalf("Expected created_at and updated_at on the map and its nodes, got %+v", m)
+	}
+
+	past := url.QueryEscape(m.CreatedAt.Add(-time.Minute).Format(time.RFC3339))
+	future := url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339))
+	for path, want := range map[string]string{
+		"/maps?updated_since=" + past:              "synced",
+		"/maps/synced/nodes?updated_since=" + past: "r1",
+		"/maps/synced/links?updated_since=" + past: "r1-r1",
+	} {
+		if recorder := request("GET", path, ""); recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), want) {
+			t.Errorf("Expected %s in %s, got %d %s", want, path, recorder.Code, recorder.Body.String())
+		}
+	}
+	for _, path := range []string{"/maps?updated_since=" + future, "/maps/synced/nodes?updated_since=" + future} {
+		if recorder := request("GET", path, ""); recorder.Code != http.StatusOK || strings.Contains(recorder.Body.String(), "r1") || strings.Contains(recorder.Body.String(), "synced") {
+			t.Errorf("Expected nothing updated in the future from %s, got %d %s", path, recorder.Code, recorder.Body.String())
+		}
+	}
+	if recorder := request("GET", "/maps/synced/nodes?updated_since=yesterday", ""); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid updated_since, got %d", recorder.Code)
+	}
+}
+
+func TestMapEmbed(t *testing.T) {
+	mapService := service.NewMapService(t.TempDir())
+	if err := mapService.CreateMap(&config.Map{Title: "portal", Width: 100, Height: 100}, "portal"); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+	server := NewServer(mapService, nil)
+	request := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		return recorder
+	}
+
+	if recorder := request("/maps/portal/embed"); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected embedding disabled without origins, got %d", recorder.Code)
+	}
+
+	t.Setenv("WEATHERMAP_EMBED_ORIGINS", "https://portal.example.com/, http://noc.example.com:8080")
+	origins, err := EmbedOriginsFromEnv()
+	if err != nil || !slices.Equal(origins, []string{"https://portal.example.com", "http://noc.example.com:8080"}) {
+		t.Fatalf("Expected normalized origins, got %v %v", origins, err)
+	}
+	t.Setenv("WEATHERMAP_EMBED_ORIGINS", "portal.example.com/dashboards")
+	if _, err := EmbedOriginsFromEnv(); err == nil {
+		t.Errorf("Expected invalid origin to be rejected")
+	}
+
+	server.SetEmbedOrigins(origins)
+	recorder := request("/maps/portal/embed")
+	if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("Expected embed page, got %d %s", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	if csp := recorder.Header().Get("Content-Security-Policy"); csp != "frame-ancestors 'self' https://portal.example.com http://noc.example.com:8080" {
+		t.Errorf("Expected framing limited to the portals, got %q", csp)
+	}
+	body := recorder.Body.String()
+	if !strings.Contains(body, `var origins = ["https://portal.example.com","http://noc.example.com:8080"]`) ||
+		!strings.Contains(body, `var mapName = "portal"`) || !strings.Contains(body, "postMessage") {
+		t.Errorf("Expected bridge script with the map and origins, got %s", body)
+	}
+	if recorder := request("/maps/missing/embed"); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown map, got %d", recorder.Code)
+	}
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	mapService := service.NewMapService(t.TempDir())
+	err := mapService.CreateMap(&config.Map{
+		Title: "core", Width: 100, Height: 100,
+		Nodes: []config.Node{{Name: "a"}, {Name: "b"}},
+		Links: []config.Link{{Name: "a-b", From: "a", To: "b", Bandwidth: "1G"}},
+	}, "core")
+	if err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+	server := NewServer(mapService, service.NewDataSourceService(nil))
+	request := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	request("/maps/core", "")
+	request("/api/v1/maps/core", "")
+	request("/maps/missing/nodes", "")
+
+	recorder := request("/metrics", "")
+	if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("Expected metrics, got %d %s", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	body := recorder.Body.String()
+	for _, want := range []string{
+		"# TYPE weathermap_http_requests_total counter",
+		`weathermap_http_requests_total{method="GET",route="/maps/{name}",code="200"} 2`,
+		`weathermap_http_requests_total{method="GET",route="/maps/{name}/nodes",code="404"} 1`,
+		`weathermap_http_request_duration_seconds_bucket{method="GET",route="/maps/{name}",code="200",le="+Inf"} 2`,
+		`weathermap_link_utilization_percent{map="core",link="a-b"} 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %q in metrics, got %s", want, body)
+		}
+	}
+
+	server.SetMetricsToken("scraper")
+	if recorder := request("/metrics", ""); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without the metrics token, got %d", recorder.Code)
+	}
+	if recorder := request("/metrics", "wrong"); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with a wrong token, got %d", recorder.Code)
+	}
+	if recorder := request("/metrics", "scraper"); recorder.Code != http.StatusOK {
+		t.Errorf("Expected metrics with the token, got %d", recorder.Code)
+	}
+}
+
+func TestLinkTraffic(t *testing.T) {
+	mapService := service.NewMapService(t.TempDir())
+	sim := config.DataSourceConfig{Name: "sim", Type: "mock", Interfaces: []config.InterfaceConfig{{Name: "eth0"}}}
+	err := mapService.CreateMap(&config.Map{
+		Title: "core", Width: 100, Height: 100,
+		Nodes: []config.Node{{Name: "a"}, {Name: "b"}},
+		Links: []config.Link{{Name: "a-b", From: "a", To: "b", Bandwidth: "1G", DataSource: "sim", Interface: "eth0", Metrics: []string{"in", "out"}}},
+	}, "core")
+	if err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+	dsService := service.NewDataSourceService([]config.DataSourceConfig{sim})
+	server := NewServer(mapService, dsService)
+	request := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		return recorder
+	}
+
+	if recorder := request("/maps/core/links/a-b/history"); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 while no history is kept, got %d", recorder.Code)
+	}
+
+	mapService.EnableRecentHistory(time.Hour)
+	for range 3 {
+		if err := mapService.RecordHistory(context.Background(), dsService); err != nil {
+			t.Fatalf("Failed to record history: %v", err)
+		}
+	}
+	recorder := request("/maps/core/links/a-b/history?range=30m")
+	var traffic service.LinkTraffic
+	if err := json.Unmarshal(recorder.Body.Bytes(), &traffic); err != nil || recorder.Code != http.StatusOK {
+		t.Fatalf("Expected the traffic of the link, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if traffic.Source != "memory" || len(traffic.Samples) != 3 || traffic.To.Sub(traffic.From) != 30*time.Minute {
+		t.Errorf("Expected 3 samples of the last 30m from memory, got %+v", traffic)
+	}
+	recorder = request("/maps/core/links/a-b/history?range=1d")
+	if err := json.Unmarshal(recorder.Body.Bytes(), &traffic); err != nil || traffic.Source != "memory" || traffic.Resolution != "1m" {
+		t.Errorf("Expected a day served from the minutes kept in memory, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := request("/maps/core/links/a-b/history?range=60d"); recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), "only the last 31d") {
+		t.Errorf("Expected a range past the memory refused without history files, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	mapService.EnableHistory(service.DefaultHistoryRetention())
+	recorder = request("/maps/core/links/a-b/history?range=60d")
+	if err := json.Unmarshal(recorder.Body.Bytes(), &traffic); err != nil || traffic.Source != "disk" || traffic.Resolution != "5m" {
+		t.Errorf("Expected the rollups of the history files, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	for path, code := range map[string]int{
+		"/maps/core/links/a-b/history?range=soon": http.StatusBadRequest,
+		"/maps/core/links/a-b/history?range=-1h":  http.StatusBadRequest,
+		"/maps/core/links/missing/history":        http.StatusNotFound,
+	} {
+		if recorder := request(path); recorder.Code != code {
+			t.Errorf("%s: expected %d, got %d", path, code, recorder.Code)
+		}
+	}
+}
+
+func TestLinkHistoryExport(t *testing.T) {
+	mapService := service.NewMapService(t.TempDir())
+	sim := config.DataSourceConfig{Name: "sim", Type: "mock", Interfaces: []config.InterfaceConfig{{Name: "eth0"}}}
+	err := mapService.CreateMap(&config.Map{
+		Title: "core", Width: 100, Height: 100,
+		Nodes: []config.Node{{Name: "a"}, {Name: "b"}},
+		Links: []config.Link{
+			{Name: "a-b", From: "a", To: "b", Bandwidth: "1G", DataSource: "sim", Interface: "eth0", Metrics: []string{"in", "out"}},
+			{Name: "idle", From: "a", To: "b", Bandwidth: "1G"},
+		},
+	}, "core")
+	if err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+	dsService := service.NewDataSourceService([]config.DataSourceConfig{sim})
+	server := NewServer(mapService, dsService)
+	request := func(query string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("GET", "/maps/core/links/a-b/history/export"+query, nil))
+		return recorder
+	}
+
+	if recorder := request(""); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 while history is disabled, got %d", recorder.Code)
+	}
+
+	mapService.EnableHistory(service.DefaultHistoryRetention())
+	for range 2 {
+		if err := mapService.RecordHistory(context.Background(), dsService); err != nil {
+			t.Fatalf("Failed to record history: %v", err)
+		}
+	}
+
+	recorder := request("")
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Fatalf("Expected CSV export, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if disposition := recorder.Header().Get("Content-Disposition"); disposition != `attachment; filename="core-a-b-history.csv"` {
+		t.Errorf("Expected CSV attachment, got %q", disposition)
+	}
+	lines := strings.Split(strings.TrimSpace(recorder.Body.String()), "\n")
+	if len(lines) != 3 || lines[0] != "time,link,in,out,utilization,max_utilization,samples" || !strings.Contains(lines[1], ",a-b,0,0,0,0,0") {
+		t.Errorf("Expected header and 2 samples, got %q", lines)
+	}
+
+	recorder = request("?format=parquet")
+	if body := recorder.Body.Bytes(); recorder.Code != http.StatusOK || !bytes.HasPrefix(body, []byte("PAR1")) || !bytes.HasSuffix(body, []byte("PAR1")) {
+		t.Errorf("Expected Parquet file, got %d", recorder.Code)
+	}
+
+	if recorder := request("?from=" + url.QueryEscape(time.Now().Add(-time.Hour).Format(time.RFC3339)) + "&to=" + url.QueryEscape(time.Now().Add(-time.Minute*30).Format(time.RFC3339))); strings.Count(recorder.Body.String(), "\n") != 1 {
+		t.Errorf("Expected only the header outside the recorded range, got %q", recorder.Body.String())
+	}
+	for query, code := range map[string]int{
+		"?format=xlsx":    http.StatusBadRequest,
+		"?from=yesterday": http.StatusBadRequest,
+		"?resolution=1d":  http.StatusBadRequest,
+		"?from=2025-01-02T00:00:00Z&to=2025-01-01T00:00:00Z": http.StatusBadRequest,
+	} {
+		if recorder := request(query); recorder.Code != code {
+			t.Errorf("Expected %d for %s, got %d", code, query, recorder.Code)
+		}
+	}
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", "/maps/core/links/missing/history/export", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown link, got %d", recorder.Code)
+	}
+}
+
+func TestNodeClustering(t *testing.T) {
+	tempDir := t.TempDir()
+	mapService := service.NewMapService(tempDir)
+	dsService := service.NewDataSourceService(nil)
+	server := NewServer(mapService, dsService)
+	mapName := "cluster-test"
+
+	testMap := &config.Map{
+		Title: mapName, Width: 1024, Height: 1024,
+		Nodes: []config.Node{
+			{Name: "a", Position: config.Position{X: 10, Y: 10}},
+			{Name: "b", Position: config.Position{X: 20, Y: 20}},
+			{Name: "c", Position: config.Position{X: 30, Y: 14}},
+			{Name: "d", Position: config.Position{X: 500, Y: 500}},
+			{Name: "e", Position: config.Position{X: 510, Y: 504}},
+			{Name: "f", Position: config.Position{X: 900, Y: 100}},
+		},
+		Links: []config.Link{
+			{Name: "a-b", From: "a", To: "b", Bandwidth: "1G"},
+			{Name: "b-d", From: "b", To: "d", Bandwidth: "1G"},
+			{Name: "c-e", From: "c", To: "e", Bandwidth: "1G", Via: []config.Position{{X: 300, Y: 20}}},
+			{Name: "a-f", From: "a", To: "f", Bandwidth: "1G"},
+		},
+	}
+	if err := mapService.CreateMap(testMap, mapName); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+	utilization := 60.0
+	for _, fault := range []service.SimulatedFault{
+		{Map: mapName, Link: "a-b", State: service.FaultStateDown},
+		{Map: mapName, Link: "c-e", State: service.FaultStateDegraded, Utilization: &utilization},
+	} {
+		if _, err := dsService.SimulateFault(fault, time.Minute); err != nil {
+			t.Fatalf("Failed to simulate fault: %v", err)
+		}
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("GET", "/maps/"+mapName+path, nil))
+		return recorder
+	}
+
+	recorder := get("?cluster=64")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status OK, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	var clustered config.MapWithData
+	if err := json.Unmarshal(recorder.Body.Bytes(), &clustered); err != nil {
+		t.Fatalf("Failed to decode map: %v", err)
+	}
+	if len(clustered.Nodes) != 3 || len(clustered.Clusters) != 2 {
+		t.Fatalf("Expected 2 clusters and f, got nodes %+v clusters %+v", clustered.Nodes, clustered.Clusters)
+	}
+	first := clustered.Clusters[0]
+	if first.Name != "cluster-1" || strings.Join(first.Nodes, ",") != "a,b,c" || first.Status != "down" || first.Utilization != 60 {
+		t.Errorf("Unexpected first cluster: %+v", first)
+	}
+	if second := clustered.Clusters[1]; strings.Join(second.Nodes, ",") != "d,e" || second.Status != service.FaultStateDegraded {
+		t.Errorf("Unexpected second cluster: %+v", second)
+	}
+	if clustered.Nodes[0].Label != "3 nodes" || clustered.Nodes[0].Position != (config.Position{X: 20, Y: 14}) {
+		t.Errorf("Expected cluster node at the centroid, got %+v", clustered.Nodes[0])
+	}
+	links := make(map[string]config.Link)
+	for _, link := range clustered.Links {
+		links[link.Name] = link
+	}
+	if len(links) != 2 {
+		t.Fatalf("Expected merged cluster link and a-f, got %+v", clustered.Links)
+	}
+	if merged, ok := links["cluster-1~cluster-2"]; !ok || merged.Via != nil {
+		t.Errorf("Expected parallel links to be merged without via points, got %+v", clustered.Links)
+	}
+	if link := links["a-f"]; link.From != "cluster-1" || link.To != "f" {
+		t.Errorf("Expected a-f to start at the cluster, got %+v", link)
+	}
+
+	if recorder = get("?cluster=auto"); strings.Contains(recorder.Body.String(), "clusters") {
+		t.Errorf("Expected no clustering at full size")
+	}
+	svg := get("/render.svg?cluster=auto&zoom=0.25").Body.String()
+	if strings.Count(svg, `<circle class="cluster"`) != 2 || !strings.Contains(svg, ">3 nodes<") {
+		t.Errorf("Expected cluster markers in SVG, got %s", svg)
+	}
+	for _, path := range []string{"/render.png?cluster=auto&width=256", "/tiles/0/0/0.png?cluster=auto"} {
+		if recorder = get(path); recorder.Code != http.StatusOK {
+			t.Errorf("Expected status OK for %s, got %d", path, recorder.Code)
+		}
+	}
+	if recorder = get("?cluster=1"); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid cluster, got %d", recorder.Code)
+	}
+}
+
+func TestStableObjectIDs(t *testing.T) {
+	tempDir := t.TempDir()
+	mapService := service.NewMapService(tempDir)
+	server := NewServer(mapService, nil)
+	mapName := "ids-test"
+
+	// written by hand, without ids
+	handWritten := "width: 400\nheight: 300\ntitle: ids\nnodes:\n  - name: a\n  - name: b\nlinks:\n  - name: a-b\n    from: a\n    to: b\n    bandwidth: 1G\n"
+	if err := os.WriteFile(filepath.Join(tempDir, mapName+".yaml"), []byte(handWritten), 0644); err != nil {
+		t.Fatalf("Failed to write map: %v", err)
+	}
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return recorder
+	}
+	getMap := func(ref string) config.MapWithData {
+		recorder := do("GET", "/maps/"+ref, "")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Expected status OK for %s, got %d %s", ref, recorder.Code, recorder.Body.String())
+		}
+		var m config.MapWithData
+		if err := json.Unmarshal(recorder.Body.Bytes(), &m); err != nil {
+			t.Fatalf("Failed to decode map: %v", err)
+		}
+		return m
+	}
+
+	// reads leave the file as it is, ids are written at startup or reload
+	unassigned := getMap(mapName)
+	if content, _ := os.ReadFile(filepath.Join(tempDir, mapName+".yaml")); string(content) != handWritten {
+		t.Fatalf("Expected GET to leave the map file unchanged, got %q", content)
+	}
+	if unassigned.ID != "" {
+		t.Errorf("Expected no id before the ids are assigned, got %q", unassigned.ID)
+	}
+	if written := mapService.AssignMissingIDs(); !slices.Equal(written, []string{mapName}) {
+		t.Fatalf("Expected ids to be written to %s, got %v", mapName, written)
+	}
+
+	first := getMap(mapName)
+	if !utils.IsULID(first.ID) || !utils.IsULID(first.Nodes[0].ID) || !utils.IsULID(first.Links[0].ID) {
+		t.Fatalf("Expected generated ids, got map %q nodes %+v links %+v", first.ID, first.Nodes, first.Links)
+	}
+	if again := getMap(mapName); again.ID != first.ID || again.Nodes[1].ID != first.Nodes[1].ID {
+		t.Errorf("Expected ids to be kept across reads")
+	}
+	if byID := getMap(first.ID); byID.Title != "ids" {
+		t.Errorf("Expected map to be addressable by id")
+	}
+
+	nodeID, linkID := first.Nodes[0].ID, first.Links[0].ID
+	if recorder := do("PATCH", "/maps/"+first.ID+"/nodes/"+nodeID, `{"label": "Alpha"}`); recorder.Code != http.StatusOK {
+		t.Errorf("Expected node edit by id, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if getMap(mapName).Nodes[0].Label != "Alpha" {
+		t.Errorf("Expected node a to be edited")
+	}
+
+	// a declarative push without ids keeps the ids of objects with the same name
+	replaced := `{"width": 400, "height": 300, "title": "ids", "nodes": [{"Name": "a"}, {"Name": "b"}, {"Name": "c"}],
+		"links": [{"Name": "a-b", "From": "a", "To": "b", "Bandwidth": "1G"}, {"Name": "b-c", "From": "b", "To": "c", "Bandwidth": "1G"}]}`
+	if recorder := do("PUT", "/maps/"+mapName, replaced); recorder.Code != http.StatusOK {
+		t.Fatalf("Failed to replace map: %d %s", recorder.Code, recorder.Body.String())
+	}
+	after := getMap(mapName)
+	if after.ID != first.ID || after.Nodes[0].ID != nodeID || after.Links[0].ID != linkID || !utils.IsULID(after.Nodes[2].ID) {
+		t.Errorf("Expected ids to survive the push, got map %q nodes %+v links %+v", after.ID, after.Nodes, after.Links)
+	}
+
+	if recorder := do("DELETE", "/maps/"+mapName+"/links/"+linkID, ""); recorder.Code != http.StatusOK {
+		t.Errorf("Expected link delete by id, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if links := getMap(mapName).Links; len(links) != 1 || links[0].Name != "b-c" {
+		t.Errorf("Expected a-b to be deleted, got %+v", links)
+	}
+
+	duplicate := fmt.Sprintf(`{"width": 400, "height": 300, "title": "dup", "nodes": [{"id": "%s", "Name": "a"}, {"id": "%s", "Name": "b"}]}`, nodeID, nodeID)
+	if recorder := do("PUT", "/maps/dup", duplicate); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for duplicate ids, got %d", recorder.Code)
+	}
+}
+
+// writeCert creates a certificate for 127.0.0.1 signed by parent, or self-signed, as PEM files in dir
+func writeCert(t *testing.T, dir, name string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(filepath.Join(dir, name+".crt"), certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := writeCert(t, dir, "ca", true, nil, nil)
+	writeCert(t, dir, "server", false, ca, caKey)
+	writeCert(t, dir, "client", false, ca, caKey)
+
+	if err := (TLSConfig{CertFile: filepath.Join(dir, "server.crt"), ClientAuth: ClientAuthRequire}).Validate(); err == nil {
+		t.Error("Expected an error without a key file")
+	}
+
+	server := NewServer(service.NewMapService(t.TempDir()), nil)
+	cfg := TLSConfig{
+		CertFile:     filepath.Join(dir, "server.crt"),
+		KeyFile:      filepath.Join(dir, "server.key"),
+		ClientCAFile: filepath.Join(dir, "ca.crt"),
+		ClientAuth:   ClientAuthRequire,
+	}
+	if err := server.EnableTLS(cfg); err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewUnstartedServer(server)
+	httpServer.TLS = server.tls.serverConfig()
+	httpServer.StartTLS()
+	defer httpServer.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	clientCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(certs []tls.Certificate) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+		return client.Get(httpServer.URL + "/health")
+	}
+
+	resp, err := get([]tls.Certificate{clientCert})
+	if err != nil {
+		t.Fatalf("Expected the client certificate to be accepted: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200, got %d", resp.StatusCode)
+	}
+	if resp, err := get(nil); err == nil {
+		_ = resp.Body.Close()
+		t.Error("Expected the handshake to fail without a client certificate")
+	}
+
+	// a broken certificate on reload keeps the previous one
+	if err := os.WriteFile(filepath.Join(dir, "server.crt"), []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.ReloadTLS(); err == nil {
+		t.Error("Expected reload to fail with an invalid certificate")
+	}
+	if resp, err := get([]tls.Certificate{clientCert}); err != nil {
+		t.Errorf("Expected the previous certificate to be served: %v", err)
+	} else {
+		_ = resp.Body.Close()
+	}
+
+	// a renewed certificate is served to new connections
+	renewed, _ := writeCert(t, dir, "server", false, ca, caKey)
+	if err := server.ReloadTLS(); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = get([]tls.Certificate{clientCert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if got := resp.TLS.PeerCertificates[0].SerialNumber; got.Cmp(renewed.SerialNumber) != 0 {
+		t.Errorf("Expected the renewed certificate, got serial %v", got)
+	}
+}
+
+func TestAdminReload(t *testing.T) {
+	tempDir := t.TempDir()
+	writeMap := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(tempDir, name+".yaml"), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write map: %v", err)
+		}
+	}
+	base := "width: 400\nheight: 300\nnodes:\n  - name: a\n  - name: b\n"
+	writeMap("core", base+"datasources:\n  - name: core-mock\n    type: mock\n")
+	writeMap("edge", base)
+
+	dsService := service.NewDataSourceService([]config.DataSourceConfig{{Name: "core-mock", Type: "mock"}})
+	server := NewServer(service.NewMapService(tempDir), dsService)
+	reload := func() ReloadReport {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("POST", "/admin/reload", nil))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Expected reload to succeed, got %d %s", recorder.Code, recorder.Body.String())
+		}
+		var report ReloadReport
+		if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+			t.Fatalf("Failed to decode reload report: %v", err)
+		}
+		return report
+	}
+
+	if report := reload(); !report.Maps.Empty() || report.Datasources == nil || !report.Datasources.Empty() {
+		t.Errorf("Expected nothing to change, got %+v", report)
+	}
+
+	writeMap("core", base+"title: core\ndatasources:\n  - name: core-mock\n    type: mock\n  - name: lab\n    type: mock\n")
+	writeMap("dc2", base)
+	if err := os.Remove(filepath.Join(tempDir, "edge.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	report := reload()
+	if !slices.Equal(report.Maps.Added, []string{"dc2"}) || !slices.Equal(report.Maps.Removed, []string{"edge"}) ||
+		!slices.Equal(report.Maps.Changed, []string{"core"}) {
+		t.Errorf("Unexpected map changes: %+v", report.Maps)
+	}
+	if !slices.Equal(report.Datasources.Added, []string{"lab"}) || len(report.Datasources.Changed) != 0 {
+		t.Errorf("Unexpected datasource changes: %+v", report.Datasources)
+	}
+
+	writeMap("dc2", "nodes: [")
+	report = reload()
+	if len(report.Issues) != 1 || report.Issues[0].File != "dc2.yaml" {
+		t.Errorf("Expected the broken map to be reported, got %+v", report.Issues)
+	}
+
+	// a broken access policy refuses the whole reload, the new datasource isn't polled
+	writeMap("dc2", base+"datasources:\n  - name: dc2-mock\n    type: mock\n")
+	if err := os.MkdirAll(filepath.Join(tempDir, "access"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "access", "roles.yaml"), []byte("rules:\n  - role: root\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	failed := httptest.NewRecorder()
+	server.ServeHTTP(failed, httptest.NewRequest("POST", "/admin/reload", nil))
+	if failed.Code != http.StatusInternalServerError {
+		t.Errorf("Expected the reload to fail with a broken access policy, got %d", failed.Code)
+	}
+	if _, err := dsService.GetDataSource("dc2-mock"); err == nil {
+		t.Error("Expected no datasource reloaded when the maps failed to reload")
+	}
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", "/admin/reload", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for GET, got %d", recorder.Code)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	t.Setenv("WEATHERMAP_ADMIN_ADDR", "127.0.0.1:6060")
+	if _, _, err := AdminConfigFromEnv(); err == nil {
+		t.Error("Expected the diagnostics listener to require a token")
+	}
+
+	dsService := service.NewDataSourceService([]config.DataSourceConfig{{Name: "lab", Type: "mock"}})
+	server := NewServer(service.NewMapService(t.TempDir()), dsService)
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", "/admin/runtime", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected runtime info only on the diagnostics listener, got %d", recorder.Code)
+	}
+	if info := server.runtimeInfo(); info.Goroutines == 0 || info.Memory.HeapAlloc == 0 || info.GoVersion == "" {
+		t.Errorf("Unexpected runtime info: %+v", info)
+	}
+
+	admin := httptest.NewServer(server.adminHandler("s3cret"))
+	defer admin.Close()
+	get := func(path, token string) *http.Response {
+		request, _ := http.NewRequest("GET", admin.URL+path, nil)
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, response.Body)
+		_ = response.Body.Close()
+		return response
+	}
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/goroutine?debug=1", "/debug/vars", "/admin/runtime"} {
+		if response := get(path, ""); response.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for %s without token, got %d", path, response.StatusCode)
+		}
+		if response := get(path, "wrong"); response.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for %s with a wrong token, got %d", path, response.StatusCode)
+		}
+		if response := get(path, "s3cret"); response.StatusCode != http.StatusOK {
+			t.Errorf("Expected 200 for %s, got %d", path, response.StatusCode)
+		}
+	}
+
+	request, _ := http.NewRequest("GET", admin.URL+"/debug/vars", nil)
+	request.Header.Set("Authorization", "Bearer s3cret")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = response.Body.Close() }()
+	var vars struct {
+		Weathermap RuntimeInfo `json:"weathermap"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&vars); err != nil || vars.Weathermap.GoVersion == "" {
+		t.Errorf("Expected the weathermap expvar, got %+v %v", vars, err)
+	}
+}
+
+func TestGetAlerts(t *testing.T) {
+	tempDir := t.TempDir()
+	mapService := service.NewMapService(tempDir)
+	server := NewServer(mapService, nil)
+	testMap := &config.Map{
+		Title: "alerts", Width: 500, Height: 500,
+		Nodes:     []config.Node{{Name: "a"}, {Name: "b"}},
+		Links:     []config.Link{{Name: "a-b", From: "a", To: "b", Bandwidth: "1G"}},
+		Alerts:    []config.AlertRule{{Name: "down", Condition: `status == "down"`, Notify: []string{"hook"}}},
+		Receivers: []config.AlertReceiver{{Name: "hook", Type: config.ReceiverWebhook, URL: "https://alerts.example.net/hook"}},
+	}
+	if err := mapService.CreateMap(testMap, "alerts"); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", "/maps/alerts/alerts", nil))
+	if recorder.Code != http.StatusOK || strings.TrimSpace(recorder.Body.String()) != "[]" {
+		t.Errorf("Expected no alerts, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", "/maps/missing/alerts", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown map, got %d", recorder.Code)
+	}
+
+	for _, alerts := range []string{
+		`"alerts": [{"name": "hot", "condition": "utilization >", "notify": ["hook"]}]`,
+		`"alerts": [{"name": "hot", "condition": "utilization > 90", "for": "5 minutes", "notify": ["hook"]}]`,
+		`"alerts": [{"name": "hot", "condition": "utilization > 90", "notify": ["pager"]}]`,
+		`"alerts": [{"name": "hot", "condition": "utilization > 90"}]`,
+		`"receivers": [{"name": "noc", "type": "telegram", "chat_id": "-100"}]`,
+		`"receivers": [{"name": "noc", "type": "email", "url": "mailto:noc@example.net"}]`,
+	} {
+		body := `{"title": "bad", "width": 100, "height": 100, "receivers": [{"name": "hook", "type": "webhook", "url": "https://alerts.example.net/hook"}], ` + alerts + `}`
+		if strings.HasPrefix(alerts, `"receivers"`) {
+			body = `{"title": "bad", "width": 100, "height": 100, ` + alerts + `}`
+		}
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("PUT", "/maps/bad", strings.NewReader(body)))
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("Expected %s to be rejected, got %d", alerts, recorder.Code)
+		}
+	}
+}
+
+func TestVersion(t *testing.T) {
+	server := NewServer(service.NewMapService(t.TempDir()), nil)
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", "/version", nil))
+	var info VersionInfo
+	if err := json.Unmarshal(recorder.Body.Bytes(), &info); err != nil || recorder.Code != http.StatusOK {
+		t.Fatalf("Failed to get version: %d %s", recorder.Code, recorder.Body.String())
+	}
+	if info.Version != version.Version || info.GoVersion != runtime.Version() || info.Platform != runtime.GOOS+"/"+runtime.GOARCH {
+		t.Errorf("Unexpected build info %+v", info)
+	}
+	if !slices.Equal(info.APIVersions, []string{"v1"}) || !info.Features["rendering"] || info.Features["history"] || info.Features["alerting"] {
+		t.Errorf("Expected rendering only, got %+v", info)
+	}
+
+	mapService := service.NewMapService(t.TempDir())
+	dsService := service.NewDataSourceService(nil)
+	mapService.EnableHistory(service.DefaultHistoryRetention())
+	mapService.WatchAlerts(dsService, time.Hour)
+	defer mapService.Stop(context.Background())
+	server = NewServer(mapService, dsService)
+	server.EnableSandbox()
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", "/api/v1/version", nil))
+	if body := recorder.Body.String(); !strings.Contains(body, `"history":true`) || !strings.Contains(body, `"alerting":true`) || !strings.Contains(body, `"sandbox":true`) {
+		t.Errorf("Expected history, alerting and sandbox enabled, got %s", body)
+	}
+}
+
+func TestEmbeddedIcons(t *testing.T) {
+	mapService := service.NewMapService(t.TempDir())
+	iconsDir := t.TempDir()
+	mapService.SetIconsDir(iconsDir)
+	override := `<svg xmlns="http://www.w3.org/2000/svg"/>`
+	if err := os.WriteFile(filepath.Join(iconsDir, "router.svg"), []byte(override), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(mapService, nil)
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", "/icons", nil))
+	var icons []config.IconInfo
+	if err := json.Unmarshal(recorder.Body.Bytes(), &icons); err != nil || len(icons) != 3 {
+		t.Fatalf("Expected the 3 embedded icons listed once, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	for icon, want := range map[string]string{"router.svg": override, "switch.svg": "<svg"} {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("GET", "/icons/"+icon, nil))
+		if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Body.String(), want) {
+			t.Errorf("Expected %s to start with %q, got %d %.40s", icon, want, recorder.Code, recorder.Body.String())
+		}
+	}
+}
+
+func TestRasterIcons(t *testing.T) {
+	mapService := service.NewMapService(t.TempDir())
+	iconsDir := t.TempDir()
+	mapService.SetIconsDir(iconsDir)
+	img := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	var pngIcon, jpegIcon bytes.Buffer
+	if err := png.Encode(&pngIcon, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(&jpegIcon, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{"ap.png": pngIcon.Bytes(), "camera.png": jpegIcon.Bytes(), "notes.txt": []byte("not an icon")} {
+		if err := os.WriteFile(filepath.Join(iconsDir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := NewServer(mapService, nil)
+	get := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		return recorder
+	}
+
+	var icons []config.IconInfo
+	if err := json.Unmarshal(get("/icons").Body.Bytes(), &icons); err != nil || len(icons) != 5 {
+		t.Errorf("Expected the raster icons listed with the embedded ones, got %+v, %v", icons, err)
+	}
+	for path, want := range map[string]string{"/icons/ap.png": "image/png", "/icons/camera.png": "image/jpeg"} {
+		if recorder := get(path); recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != want {
+			t.Errorf("%s: expected %s, got %d %s", path, want, recorder.Code, recorder.Header().Get("Content-Type"))
+		}
+	}
+	if recorder := get("/icons/notes.txt"); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected files other than icons not served, got %d", recorder.Code)
+	}
+
+	recorder := get("/icons/camera.png?size=16")
+	resized, err := png.Decode(recorder.Body)
+	if err != nil || recorder.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("Expected a PNG, got %s, %v", recorder.Header().Get("Content-Type"), err)
+	}
+	if bounds := resized.Bounds(); bounds.Dx() != 16 || bounds.Dy() != 8 {
+		t.Errorf("Expected the icon to fit 16x16 keeping its aspect ratio, got %v", bounds)
+	}
+	if recorder := get("/icons/router.svg?size=16"); !strings.HasPrefix(recorder.Body.String(), "<svg") {
+		t.Errorf("Expected svg icons served as they are, got %.40s", recorder.Body.String())
+	}
+	for _, size := range []string{"0", "513", "big"} {
+		if recorder := get("/icons/ap.png?size=" + size); recorder.Code != http.StatusBadRequest {
+			t.Errorf("size=%s: expected 400, got %d", size, recorder.Code)
+		}
+	}
+}
+
+func TestIconMetadata(t *testing.T) {
+	mapService := service.NewMapService(t.TempDir())
+	iconsDir := t.TempDir()
+	mapService.SetIconsDir(iconsDir)
+	metadata := "router.svg:\n  display_name: Core router\n  tags: [core]\nswitch.svg:\n  category: access\n"
+	if err := os.WriteFile(filepath.Join(iconsDir, service.IconMetadataFile), []byte(metadata), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(mapService, nil)
+	listIcons := func() map[string]config.IconInfo {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest("GET", "/icons", nil))
+		var icons []config.IconInfo
+		if err := json.Unmarshal(recorder.Body.Bytes(), &icons); err != nil {
+			t.Fatalf("Failed to decode icons: %v", err)
+		}
+		byName := make(map[string]config.IconInfo)
+		for _, icon := range icons {
+			byName[icon.Name] = icon
+		}
+		return byName
+	}
+	icons := listIcons()
+	if router := icons["router.svg"]; router.DisplayName != "Core router" || router.Category != "network" || !slices.Equal(router.Tags, []string{"core"}) {
+		t.Errorf("Expected the display name and tags of icons.yaml with the default category, got %+v", router)
+	}
+	if icons["switch.svg"].Category != "access" {
+		t.Errorf("Expected the category of icons.yaml, got %+v", icons["switch.svg"])
+	}
+
+	upload := func(query, fileName string, content []byte, fields map[string]string) *httptest.ResponseRecorder {
+		var form bytes.Buffer
+		writer := multipart.NewWriter(&form)
+		part, _ := writer.CreateFormFile("file", fileName)
+		_, _ = part.Write(content)
+		for key, value := range fields {
+			_ = writer.WriteField(key, value)
+		}
+		_ = writer.Close()
+		request := httptest.NewRequest("POST", "/icons"+query, &form)
+		request.Header.Set("Content-Type", writer.FormDataContentType())
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, request)
+		return recorder
+	}
+	svg := []byte(`<svg xmlns="http://www.w3.org/2000/svg"><rect width="8" height="8"/></svg>`)
+	recorder := upload("", "wifi_ap.svg", svg, map[string]string{"category": "wireless", "tags": "wifi, indoor"})
+	var uploaded config.IconInfo
+	if err := json.Unmarshal(recorder.Body.Bytes(), &uploaded); err != nil || recorder.Code != http.StatusCreated {
+		t.Fatalf("Expected the icon uploaded, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if uploaded.DisplayName != "Wifi Ap" || uploaded.Category != "wireless" || !slices.Equal(uploaded.Tags, []string{"wifi", "indoor"}) {
+		t.Errorf("Expected the category and tags of the form, got %+v", uploaded)
+	}
+	if icons := listIcons(); icons["wifi_ap.svg"].Category != "wireless" || icons["router.svg"].DisplayName != "Core router" {
+		t.Errorf("Expected icons.yaml updated and kept, got %+v", icons)
+	}
+
+	if recorder := upload("", "wifi_ap.svg", svg, nil); recorder.Code != http.StatusConflict {
+		t.Errorf("Expected 409 uploading over an icon, got %d", recorder.Code)
+	}
+	if recorder := upload("?replace=true", "wifi_ap.svg", svg, nil); recorder.Code != http.StatusCreated {
+		t.Errorf("Expected the icon replaced, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	entries, err := mapService.AuditEntries(service.AuditFilter{})
+	if err != nil || len(entries) != 2 || entries[0].Action != service.AuditCreate || entries[1].Action != service.AuditUpdate ||
+		entries[1].Changes[0].Object != "icon" || entries[1].Changes[0].Name != "wifi_ap.svg" {
+		t.Errorf("Expected the upload and the replacement audited, got %+v, %v", entries, err)
+	}
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", "/icons/wifi_ap.svg", nil))
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Security-Policy") != "sandbox" {
+		t.Errorf("Expected the svg served in a sandbox, got %d %v", recorder.Code, recorder.Header())
+	}
+	for name, content := range map[string][]byte{"fake.png": svg, "notes.txt": svg, "empty.svg": []byte("<html/>")} {
+		if recorder := upload("", name, content, nil); recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d %s", name, recorder.Code, recorder.Body.String())
+		}
+	}
+	if recorder := upload("", "icon.svg", svg, map[string]string{"name": "../escape.svg"}); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected a name outside of the icons directory refused, got %d", recorder.Code)
+	}
+}
+
+func TestLinkCaptureRoutes(t *testing.T) {
+	tempDir := t.TempDir()
+	mapService := service.NewMapService(tempDir)
+	datasources := []config.DataSourceConfig{{Name: "lab", Type: "mock", Interfaces: []config.InterfaceConfig{
+		{Name: "eth0", Params: map[string]interface{}{"metrics": []interface{}{"in", "out"}}},
+	}}}
+	server := NewServer(mapService, service.NewDataSourceService(datasources))
+	testMap := &config.Map{
+		Title: "capture", Width: 500, Height: 500,
+		Nodes:       []config.Node{{Name: "a"}, {Name: "b"}},
+		Links:       []config.Link{{Name: "a-b", From: "a", To: "b", Bandwidth: "1G", DataSource: "lab", Interface: "eth0", Metrics: []string{"in", "out"}}},
+		Datasources: datasources,
+	}
+	if err := mapService.CreateMap(testMap, "capture"); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+
+	for _, tc := range []struct {
+		method, path, body string
+		want               int
+	}{
+		{"GET", "/maps/capture/links/a-b/capture", "", http.StatusNotFound},
+		{"POST", "/maps/capture/links/a-b/capture", `{"samples": 1000}`, http.StatusBadRequest},
+		{"POST", "/maps/capture/links/missing/capture", "", http.StatusNotFound},
+		{"POST", "/maps/capture/links/a-b/capture", `{"samples": 5}`, http.StatusOK},
+		{"GET", "/maps/capture/links/a-b/capture", "", http.StatusOK},
+		{"DELETE", "/maps/capture/links/a-b/capture", "", http.StatusOK},
+		{"DELETE", "/maps/capture/links/a-b/capture", "", http.StatusNotFound},
+	} {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body)))
+		if recorder.Code != tc.want {
+			t.Errorf("%s %s: expected %d, got %d %s", tc.method, tc.path, tc.want, recorder.Code, recorder.Body.String())
+		}
+		if tc.method == "GET" && tc.want == http.StatusOK {
+			var capture service.LinkCapture
+			if err := json.NewDecoder(recorder.Body).Decode(&capture); err != nil || capture.Size != 5 || capture.Interface != "eth0" {
+				t.Errorf("Expected the capture of eth0 keeping 5 samples, got %+v (%v)", capture, err)
+			}
+		}
+	}
+}
+
+func TestLint(t *testing.T) {
+	mapService := service.NewMapService(t.TempDir())
+	server := NewServer(mapService, nil)
+	if err := mapService.CreateMap(&config.Map{Title: "lint", Width: 100, Height: 100, OverUtilization: config.OverUtilizationFlag}, "lint"); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", "/admin/lint", nil))
+	var report service.ConfigReport
+	if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil || recorder.Code != http.StatusOK || report.Maps != 1 || len(report.Issues) != 0 {
+		t.Errorf("Expected a clean report of one map, got %d %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestReceiverSecrets(t *testing.T) {
+	mapService := service.NewMapService(t.TempDir())
+	server := NewServer(mapService, nil)
+	testMap := &config.Map{
+		Title: "alerts", Width: 100, Height: 100,
+		Nodes:  []config.Node{{Name: "a"}, {Name: "b"}},
+		Links:  []config.Link{{Name: "a-b", From: "a", To: "b", Bandwidth: "1G"}},
+		Alerts: []config.AlertRule{{Name: "down", Condition: `status == "down"`, Notify: []string{"noc", "chat"}}},
+		Receivers: []config.AlertReceiver{
+			{Name: "noc", Type: config.ReceiverTelegram, BotToken: "123:bot-secret", ChatID: "-100"},
+			{Name: "chat", Type: config.ReceiverSlack, URL: "https://hooks.slack.com/services/T0/B0/hook-secret"},
+		},
+	}
+	if err := mapService.CreateMap(testMap, "alerts"); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", "/maps/alerts", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected the map, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	body := recorder.Body.String()
+	if strings.Contains(body, "bot-secret") || strings.Contains(body, "hook-secret") || !strings.Contains(body, config.SecretMask) {
+		t.Errorf("Expected the bot token and the webhook URL masked, got %s", body)
+	}
+
+	// the map as read from the API is written back with its receivers unchanged
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("PUT", "/maps/alerts", strings.NewReader(body)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected the map replaced, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	saved, err := mapService.GetMap("alerts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Receivers[0].BotToken != "123:bot-secret" || saved.Receivers[1].URL != "https://hooks.slack.com/services/T0/B0/hook-secret" {
+		t.Errorf("Expected the stored receiver credentials kept, got %+v", saved.Receivers)
+	}
+}
+
+func TestDataSourceSecrets(t *testing.T) {
+	mapService := service.NewMapService(t.TempDir())
+	server := NewServer(mapService, nil)
+	testMap := &config.Map{
+		Title: "prom", Width: 100, Height: 100,
+		Datasources: []config.DataSourceConfig{{Name: "prom", Type: "prometheus", Interfaces: []config.InterfaceConfig{},
+			Params: map[string]interface{}{"url": "http://prometheus:9090", "bearer_token": "prom-secret", "password": "basic-secret"}}},
+	}
+	if err := mapService.CreateMap(testMap, "prom"); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", "/maps/prom", nil))
+	body := recorder.Body.String()
+	if recorder.Code != http.StatusOK || strings.Contains(body, "prom-secret") || strings.Contains(body, "basic-secret") ||
+		!strings.Contains(body, "http://prometheus:9090") {
+		t.Fatalf("Expected the credentials of the datasource masked, got %d %s", recorder.Code, body)
+	}
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", "/maps/prom/export?format=yaml", nil))
+	if strings.Contains(recorder.Body.String(), "prom-secret") || !strings.Contains(recorder.Body.String(), config.SecretMask) {
+		t.Errorf("Expected the credentials masked in the YAML export, got %s", recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("PUT", "/maps/prom", strings.NewReader(body)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected the map replaced, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	saved, err := mapService.GetMap("prom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params := saved.Datasources[0].Params; params["bearer_token"] != "prom-secret" || params["password"] != "basic-secret" {
+		t.Errorf("Expected the stored credentials kept, got %v", params)
+	}
 }
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"go-weathermap/internal/auth"
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/service"
+	"go-weathermap/internal/utils"
+)
+
+const anonymousActor = "anonymous"
+
+// audited records the changes made by requests to /maps and /maps/{name}/... in the audit
+// log. The maps are compared before and after the handler, so failed or rejected requests
+// leave no entry. Audited requests are serialized to attribute every change to its caller.
+func (s *Server) audited(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" || r.Method == "HEAD" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if ref, ok := strings.CutPrefix(r.URL.Path, "/maps/"); ok {
+			if r.URL.Path == importPath && r.Method == "POST" {
+				ref = r.URL.Query().Get("name") // the imported map
+			}
+			parts := strings.Split(ref, "/")
+			mapName, err := s.mapService.ResolveMapName(parts[0])
+			if parts[0] == "" || err != nil || requiredRole(r.Method, parts) == service.RoleViewer {
+				next.ServeHTTP(w, r)
+				return
+			}
+			s.auditMu.Lock()
+			defer s.auditMu.Unlock()
+			before, _ := s.mapService.GetMap(mapName)
+			next.ServeHTTP(w, r)
+			s.recordAudit(r, mapName, before)
+			return
+		}
+
+		// a map created by POST /maps is only known after the handler, as the new one in the listing
+		s.auditMu.Lock()
+		defer s.auditMu.Unlock()
+		existing, _ := s.mapService.ListMaps()
+		next.ServeHTTP(w, r)
+		current, _ := s.mapService.ListMaps()
+		for _, name := range current {
+			if !slices.Contains(existing, name) {
+				s.recordAudit(r, name, nil)
+			}
+		}
+	})
+}
+
+// recordAudit compares the stored map with before and logs the difference
+func (s *Server) recordAudit(r *http.Request, mapName string, before *config.Map) {
+	after, _ := s.mapService.GetMap(mapName)
+	changes := service.DiffMaps(before, after)
+	if len(changes) == 0 {
+		return
+	}
+	entry := service.AuditEntry{
+		Time:    time.Now().UTC(),
+		Actor:   auditActor(r),
+		Remote:  r.RemoteAddr,
+		Request: r.Method + " " + r.URL.Path,
+		Map:     mapName,
+		Action:  service.AuditUpdate,
+		Changes: changes,
+	}
+	switch {
+	case before == nil:
+		entry.Action = service.AuditCreate
+	case after == nil:
+		entry.Action = service.AuditDelete
+	}
+	if err := s.mapService.RecordAudit(entry); err != nil {
+		s.logger.Error("audit log write failed", "map", entry.Map, "error", err)
+	}
+}
+
+// recordIconAudit logs an uploaded icon. Icons belong to no map, the entry has none.
+func (s *Server) recordIconAudit(r *http.Request, name string, replaced bool) {
+	entry := service.AuditEntry{
+		Time:    time.Now().UTC(),
+		Actor:   auditActor(r),
+		Remote:  r.RemoteAddr,
+		Request: r.Method + " " + r.URL.Path,
+		Action:  service.AuditCreate,
+		Changes: []service.AuditChange{{Object: "icon", Name: name, Op: "added"}},
+	}
+	if replaced {
+		entry.Action, entry.Changes[0].Op = service.AuditUpdate, "changed"
+	}
+	if err := s.mapService.RecordAudit(entry); err != nil {
+		s.logger.Error("audit log write failed", "icon", name, "error", err)
+	}
+}
+
+// auditActor is the sub claim of the caller, anonymousActor without authentication
+func auditActor(r *http.Request) string {
+	if claims, ok := auth.ClaimsFromContext(r.Context()); ok && claims.Subject() != "" {
+		return claims.Subject()
+	}
+	return anonymousActor
+}
+
+// GetAuditLog handles GET /audit with optional map, actor and since filters, entries of maps
+// the caller can't view are left out
+func (s *Server) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.Map = r.URL.Query().Get("map")
+	entries, err := s.mapService.AuditEntries(filter)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	visible := entries[:0]
+	for _, entry := range entries {
+		ok, err := s.canView(r, entry.Map)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if ok {
+			visible = append(visible, entry)
+		}
+	}
+	respondWithList(w, r, visible)
+}
+
+// GetMapAuditLog handles GET /maps/{name}/audit, entries stay available after the map is deleted
+func (s *Server) GetMapAuditLog(w http.ResponseWriter, r *http.Request, mapName string) {
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.Map = mapName
+	entries, err := s.mapService.AuditEntries(filter)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithList(w, r, entries)
+}
+
+func parseAuditFilter(r *http.Request) (service.AuditFilter, error) {
+	filter := service.AuditFilter{Actor: r.URL.Query().Get("actor")}
+	if value := r.URL.Query().Get("since"); value != "" {
+		since, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid since: must be an RFC 3339 time")
+		}
+		filter.Since = since
+	}
+	return filter, nil
+}
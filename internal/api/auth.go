@@ -0,0 +1,71 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"go-weathermap/internal/auth"
+	"go-weathermap/internal/utils"
+)
+
+// SetVerifier requires a valid OIDC bearer token on the API, nil disables authentication
+func (s *Server) SetVerifier(verifier *auth.Verifier) {
+	s.verifier = verifier
+}
+
+// publicPath lists routes served without a token: the health and readiness checks for load balancers
+// and agent pushes, which are authenticated by their own agent tokens
+func publicPath(path string) bool {
+	path = strings.TrimPrefix(path, APIPrefix)
+	return path == "/health" || path == "/ready" || path == "/agents/push"
+}
+
+// queryTokenPath lists the routes browsers open without being able to set headers: the event
+// stream and websocket of a map, and the embed page reading them
+func queryTokenPath(path string) bool {
+	ref, ok := strings.CutPrefix(strings.TrimPrefix(path, APIPrefix), "/maps/")
+	parts := strings.Split(ref, "/")
+	return ok && len(parts) == 2 && (parts[1] == "events" || parts[1] == "ws" || parts[1] == "embed")
+}
+
+// authenticate verifies the bearer token and passes its claims to the handlers in the
+// request context. Browsers can't set headers on websockets and event streams, so on those
+// the token is also accepted as the access_token query parameter. Anywhere else it would end
+// up in access logs and Referer headers, and is refused.
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok && r.URL.Query().Has("access_token") {
+		if !queryTokenPath(r.URL.Path) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_request"`)
+			utils.RespondWithError(w, http.StatusUnauthorized, "access_token is only accepted on event streams, use the Authorization header")
+			return nil, false
+		}
+		token = r.URL.Query().Get("access_token")
+	}
+	if token == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer`)
+		utils.RespondWithError(w, http.StatusUnauthorized, "bearer token is required")
+		return nil, false
+	}
+	claims, err := s.verifier.Verify(r.Context(), token)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		utils.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return nil, false
+	}
+	return r.WithContext(auth.WithClaims(r.Context(), claims)), true
+}
+
+// WhoAmI returns the claims of the caller's token
+func (s *Server) WhoAmI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims, ok := auth.ClaimsFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusNotFound, "authentication is not enabled")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, claims)
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"go-weathermap/internal/utils"
+)
+
+// StartCapturePayload sets how many raw responses a capture keeps per metric
+type StartCapturePayload struct {
+	Samples int `json:"samples"`
+}
+
+// StartLinkCapture starts keeping the raw datasource responses of a link, an empty body
+// keeps the default number of samples
+func (s *Server) StartLinkCapture(w http.ResponseWriter, r *http.Request, mapName, linkName string) {
+	var payload StartCapturePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil && !errors.Is(err, io.EOF) {
+		respondWithBodyError(w, err, "Invalid request payload")
+		return
+	}
+	capture, err := s.mapService.StartLinkCapture(mapName, linkName, payload.Samples, s.dataSourceService)
+	if err != nil {
+		respondWithCaptureError(w, err)
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, capture)
+}
+
+// GetLinkCapture returns the raw datasource responses captured for a link
+func (s *Server) GetLinkCapture(w http.ResponseWriter, r *http.Request, mapName, linkName string) {
+	capture, err := s.mapService.GetLinkCapture(mapName, linkName, s.dataSourceService)
+	if err != nil {
+		respondWithCaptureError(w, err)
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, capture)
+}
+
+// StopLinkCapture drops the capture of a link with its samples
+func (s *Server) StopLinkCapture(w http.ResponseWriter, r *http.Request, mapName, linkName string) {
+	if err := s.mapService.StopLinkCapture(mapName, linkName, s.dataSourceService); err != nil {
+		respondWithCaptureError(w, err)
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, map[string]string{"status": "capture stopped"})
+}
+
+func respondWithCaptureError(w http.ResponseWriter, err error) {
+	switch {
+	case strings.Contains(err.Error(), "not found"):
+		utils.RespondWithError(w, http.StatusNotFound, err.Error())
+	case strings.Contains(err.Error(), "invalid"):
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+	case strings.Contains(err.Error(), "not supported"), strings.Contains(err.Error(), "another instance"):
+		utils.RespondWithError(w, http.StatusConflict, err.Error())
+	default:
+		utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+	}
+}
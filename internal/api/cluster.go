@@ -0,0 +1,44 @@
+package api
+
+import (
+	"net/http"
+
+	"go-weathermap/internal/utils"
+)
+
+// ClusterMetrics is pulled by peer instances when sharded polling is enabled, the metrics of
+// every map are only served to admins
+func (s *Server) ClusterMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+	if s.dataSourceService == nil {
+		utils.RespondWithError(w, http.StatusServiceUnavailable, "datasource service is not running")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, s.dataSourceService.LocalSnapshot())
+}
+
+func (s *Server) ClusterStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+	if s.dataSourceService == nil {
+		utils.RespondWithError(w, http.StatusServiceUnavailable, "datasource service is not running")
+		return
+	}
+	status, err := s.dataSourceService.ClusterStatus()
+	if err != nil {
+		utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, status)
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/service"
+)
+
+// applyClustering groups nearby nodes when ?cluster is set: the grid cell size in map pixels,
+// or auto to derive it from the scale the map is shown at. Auto leaves maps shown at full
+// size or larger alone.
+func applyClustering(r *http.Request, m *config.MapWithData, scale float64) (*config.MapWithData, error) {
+	value := r.URL.Query().Get("cluster")
+	switch value {
+	case "":
+		return m, nil
+	case "auto":
+		if scale >= 1 {
+			return m, nil
+		}
+		return service.ClusterNodes(m, int(math.Ceil(service.ClusterCellPixels/scale))), nil
+	}
+	cell, err := strconv.Atoi(value)
+	if err != nil || cell < 2 {
+		return nil, fmt.Errorf("invalid cluster: must be auto or a cell size of at least 2 pixels")
+	}
+	return service.ClusterNodes(m, cell), nil
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// brotliLevel trades ratio for speed, map data is compressed on every read
+const brotliLevel = 4
+
+// compressed encodes the responses of next with br or gzip, the first the request accepts.
+// Only text like JSON and SVG is encoded, PNG, PDF and zip are already compressed. Websocket
+// upgrades and event streams are left as they are.
+func compressed(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "" || alreadyCompressed(w) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !slices.Contains(w.Header().Values("Vary"), "Accept-Encoding") {
+			w.Header().Add("Vary", "Accept-Encoding")
+		}
+		encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// alreadyCompressed reports whether w writes to a compressWriter, like the routes served again
+// under /api/v1 through the envelope writer
+func alreadyCompressed(w http.ResponseWriter) bool {
+	for {
+		if _, ok := w.(*compressWriter); ok {
+			return true
+		}
+		unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return false
+		}
+		w = unwrapper.Unwrap()
+	}
+}
+
+// acceptedEncoding picks br over gzip among the codings of an Accept-Encoding header with a
+// non-zero quality
+func acceptedEncoding(header string) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		accepted[strings.ToLower(coding)] = q > 0
+	}
+	for _, coding := range []string{"br", "gzip"} {
+		if accepted[coding] {
+			return coding
+		}
+	}
+	return ""
+}
+
+func compressible(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "text/event-stream":
+		return false
+	case strings.HasPrefix(mediaType, "text/"), strings.HasSuffix(mediaType, "+json"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/yaml", "application/xml", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// compressWriter decides on the first write whether the response is encoded, from its status
+// and content type
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	encoder  io.WriteCloser // nil until decided, and for responses left as they are
+	decided  bool
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if !w.decided {
+		w.decide(code)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *compressWriter) decide(code int) {
+	w.decided = true
+	h := w.Header()
+	if code < http.StatusOK || code == http.StatusNoContent || code == http.StatusNotModified ||
+		h.Get("Content-Encoding") != "" || !compressible(h.Get("Content-Type")) {
+		return
+	}
+	h.Del("Content-Length")
+	h.Set("Content-Encoding", w.encoding)
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		// the encoded bytes differ from the ones the tag was computed on
+		h.Set("ETag", "W/"+etag)
+	}
+	if w.encoding == "br" {
+		w.encoder = brotli.NewWriterLevel(w.ResponseWriter, brotliLevel)
+	} else {
+		w.encoder = gzip.NewWriter(w.ResponseWriter)
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.encoder == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.encoder.Write(b)
+}
+
+func (w *compressWriter) close() {
+	if w.encoder != nil {
+		_ = w.encoder.Close()
+	}
+}
+
+func (w *compressWriter) Flush() {
+	if flusher, ok := w.encoder.(interface{ Flush() error }); ok {
+		_ = flusher.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection can't be hijacked")
+	}
+	return hijacker.Hijack()
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
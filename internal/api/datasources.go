@@ -0,0 +1,48 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go-weathermap/internal/utils"
+)
+
+func (s *Server) HandleDataSources(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.dataSourceService == nil {
+		utils.RespondWithError(w, http.StatusServiceUnavailable, "datasource service is not running")
+		return
+	}
+
+	canView, err := s.dataSourceViewer(r)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/datasources"), "/")
+	if name == "" {
+		infos := s.dataSourceService.ListDataSources()
+		visible := infos[:0]
+		for _, info := range infos {
+			if canView(info.Name) {
+				visible = append(visible, info)
+			}
+		}
+		respondWithList(w, r, visible)
+		return
+	}
+	if !canView(name) {
+		utils.RespondWithError(w, http.StatusForbidden, fmt.Sprintf("forbidden: requires viewer role on a map using datasource %s", name))
+		return
+	}
+	info, err := s.dataSourceService.GetDataSource(name)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, info)
+}
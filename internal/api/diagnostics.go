@@ -0,0 +1,176 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-weathermap/internal/utils"
+)
+
+// AdminConfig of the diagnostics listener serving pprof, expvar and /admin/runtime
+type AdminConfig struct {
+	Addr  string
+	Token string // bearer token required on every request
+}
+
+// AdminConfigFromEnv reads WEATHERMAP_ADMIN_ADDR, the listener is off when unset, and
+// WEATHERMAP_ADMIN_TOKEN, which it requires
+func AdminConfigFromEnv() (AdminConfig, bool, error) {
+	cfg := AdminConfig{
+		Addr:  strings.TrimSpace(os.Getenv("WEATHERMAP_ADMIN_ADDR")),
+		Token: strings.TrimSpace(os.Getenv("WEATHERMAP_ADMIN_TOKEN")),
+	}
+	if cfg.Addr == "" {
+		return cfg, false, nil
+	}
+	return cfg, true, cfg.Validate()
+}
+
+func (c AdminConfig) Validate() error {
+	if c.Token == "" {
+		return fmt.Errorf("WEATHERMAP_ADMIN_TOKEN is required to serve diagnostics on %s", c.Addr)
+	}
+	return nil
+}
+
+// MemoryStats is the part of runtime.MemStats telling where memory goes
+type MemoryStats struct {
+	HeapAlloc    uint64 `json:"heap_alloc_bytes"`
+	HeapInuse    uint64 `json:"heap_inuse_bytes"`
+	HeapObjects  uint64 `json:"heap_objects"`
+	StackInuse   uint64 `json:"stack_inuse_bytes"`
+	Sys          uint64 `json:"sys_bytes"`
+	NumGC        uint32 `json:"num_gc"`
+	LastGCPause  string `json:"last_gc_pause"`
+	GCCPUPercent string `json:"gc_cpu_percent"`
+}
+
+// RuntimeInfo of GET /admin/runtime
+type RuntimeInfo struct {
+	GoVersion  string         `json:"go_version"`
+	Uptime     string         `json:"uptime"`
+	Goroutines int            `json:"goroutines"`
+	Memory     MemoryStats    `json:"memory"`
+	CacheSizes map[string]int `json:"cache_sizes"` // cached metric values by poller type
+	PollTasks  map[string]int `json:"poll_tasks"`  // tasks by poller type
+}
+
+var processStarted = time.Now()
+
+func (s *Server) runtimeInfo() RuntimeInfo {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	info := RuntimeInfo{
+		GoVersion:  runtime.Version(),
+		Uptime:     time.Since(processStarted).Round(time.Second).String(),
+		Goroutines: runtime.NumGoroutine(),
+		Memory: MemoryStats{
+			HeapAlloc:    mem.HeapAlloc,
+			HeapInuse:    mem.HeapInuse,
+			HeapObjects:  mem.HeapObjects,
+			StackInuse:   mem.StackInuse,
+			Sys:          mem.Sys,
+			NumGC:        mem.NumGC,
+			LastGCPause:  time.Duration(mem.PauseNs[(mem.NumGC+255)%256]).String(),
+			GCCPUPercent: fmt.Sprintf("%.3f", mem.GCCPUFraction*100),
+		},
+		CacheSizes: map[string]int{},
+		PollTasks:  map[string]int{},
+	}
+	if s.dataSourceService != nil {
+		info.CacheSizes = s.dataSourceService.CacheSizes()
+		for _, poller := range s.dataSourceService.ResourceUsage().Pollers {
+			info.PollTasks[poller.Type] = poller.Tasks
+		}
+	}
+	return info
+}
+
+// GetRuntime reports goroutines, memory, cache sizes and poll tasks, to follow memory growth
+func (s *Server) GetRuntime(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, s.runtimeInfo())
+}
+
+// the runtime of the server serving diagnostics last is published as the weathermap expvar,
+// expvar names can only be published once per process
+var (
+	expvarServer  atomic.Pointer[Server]
+	expvarPublish sync.Once
+)
+
+// adminHandler serves pprof, expvar and /admin/runtime to callers with the admin token
+func (s *Server) adminHandler(token string) http.Handler {
+	expvarServer.Store(s)
+	expvarPublish.Do(func() {
+		expvar.Publish("weathermap", expvar.Func(func() any { return expvarServer.Load().runtimeInfo() }))
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/admin/runtime", s.GetRuntime)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer`)
+			utils.RespondWithError(w, http.StatusUnauthorized, "admin token is required")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// StartAdmin serves diagnostics on their own listener until ctx is done. It has no write
+// timeout, CPU profiles and traces take as long as requested.
+func (s *Server) StartAdmin(ctx context.Context, cfg AdminConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           s.adminHandler(cfg.Token),
+		ReadHeaderTimeout: s.timeouts.ReadHeader,
+		IdleTimeout:       s.timeouts.Idle,
+		ErrorLog:          slog.NewLogLogger(s.logger.Handler(), slog.LevelWarn),
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		s.logger.Info("starting diagnostics listener", "addr", cfg.Addr)
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("diagnostics shutdown: %w", err)
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"go-weathermap/internal/service"
+	"go-weathermap/internal/utils"
+)
+
+// DiscoverTopology handles POST /maps/{name}/discover: walks the LLDP and CDP neighbors of the
+// seeds and returns the nodes and links it would add to the map, ?apply=true saves them
+func (s *Server) DiscoverTopology(w http.ResponseWriter, r *http.Request, mapName string) {
+	var req service.DiscoveryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithBodyError(w, err, "Invalid JSON for discovery")
+		return
+	}
+	apply := r.URL.Query().Get("apply") == "true"
+	result, err := s.mapService.DiscoverTopology(r.Context(), mapName, req, s.dataSourceService, apply)
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), "not found"):
+			utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		case strings.Contains(err.Error(), "invalid"), strings.Contains(err.Error(), "validation failed"):
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		default:
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"go-weathermap/internal/service"
+	"go-weathermap/internal/utils"
+)
+
+// EmbedOriginsFromEnv parses WEATHERMAP_EMBED_ORIGINS="https://portal.example.com,https://noc.example.com",
+// the origins allowed to frame the embed widget and receive its messages. * allows any origin.
+func EmbedOriginsFromEnv() ([]string, error) {
+	var origins []string
+	for _, origin := range strings.Split(os.Getenv("WEATHERMAP_EMBED_ORIGINS"), ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "" {
+			continue
+		}
+		if origin != "*" {
+			u, err := url.Parse(origin)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+				return nil, fmt.Errorf("invalid embed origin: '%s', must be scheme://host[:port] or *", origin)
+			}
+			origin = u.Scheme + "://" + u.Host
+		}
+		origins = append(origins, origin)
+	}
+	return origins, nil
+}
+
+// SetEmbedOrigins enables the embed widget for portals on the given origins, none disables it
+func (s *Server) SetEmbedOrigins(origins []string) {
+	s.embedOrigins = origins
+}
+
+// MapEmbed serves a page meant for an iframe: the rendered map, refreshed with the event
+// stream of the map, which forwards the link data to the parent window with postMessage.
+// Legacy portals that can neither proxy the API nor call it cross-origin read live values
+// from these messages.
+func (s *Server) MapEmbed(w http.ResponseWriter, r *http.Request, mapName string) {
+	if len(s.embedOrigins) == 0 {
+		utils.RespondWithError(w, http.StatusNotFound, "embedding is not enabled")
+		return
+	}
+	if _, err := s.mapService.GetMap(mapName); err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		} else {
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	// only the configured portals may frame the widget
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'self' "+strings.Join(s.embedOrigins, " "))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	err := embedPage.Execute(w, struct {
+		Map     string
+		Origins []string
+	}{mapName, s.embedOrigins})
+	if err != nil {
+		s.logger.Warn("embed page write failed", "map", mapName, "error", err)
+	}
+}
+
+// messages to the parent carry source "go-weathermap": ready once loaded, links with the
+// data of every link after each refresh, link_state and node_state on status changes and
+// link_anomaly when a link becomes anomalous or normal again.
+// The parent can ask for the latest links message by posting {"type": "get"}.
+var embedPage = template.Must(template.New("embed").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Map}}</title>
+<style>html, body { margin: 0; height: 100%; } img { display: block; width: 100%; height: 100%; object-fit: contain; }</style>
+</head>
+<body>
+<img id="map" alt="{{.Map}}">
+<script>
+(function () {
+  var mapName = {{.Map}};
+  var origins = {{.Origins}};
+  var params = new URLSearchParams(location.search);
+  var token = params.get("access_token");
+  params.delete("access_token"); // render.svg takes the token in the Authorization header
+  var img = document.getElementById("map");
+  var latest = null;
+
+  function post(message, target) {
+    message.source = "go-weathermap";
+    if (window.parent === window) {
+      return;
+    }
+    if (target) {
+      window.parent.postMessage(message, target);
+      return;
+    }
+    origins.forEach(function (origin) { window.parent.postMessage(message, origin); });
+  }
+
+  function refreshImage() {
+    params.set("_", Date.now());
+    var src = "render.svg?" + params.toString();
+    if (!token) {
+      img.src = src;
+      return;
+    }
+    fetch(src, {headers: {Authorization: "Bearer " + token}})
+      .then(function (response) { return response.ok ? response.blob() : null; })
+      .then(function (blob) {
+        if (!blob) {
+          return;
+        }
+        if (img.src.indexOf("blob:") === 0) {
+          URL.revokeObjectURL(img.src);
+        }
+        img.src = URL.createObjectURL(blob);
+      });
+  }
+
+  window.addEventListener("message", function (event) {
+    if (event.source !== window.parent || (origins.indexOf("*") < 0 && origins.indexOf(event.origin) < 0)) {
+      return;
+    }
+    if (event.data && event.data.type === "get" && latest) {
+      post(latest, event.origin);
+    }
+  });
+
+  var events = new EventSource("events" + (token ? "?access_token=" + encodeURIComponent(token) : ""));
+  events.addEventListener("metrics", function (event) {
+    var data = JSON.parse(event.data);
+    latest = {type: "links", map: mapName, processed_at: data.processed_at, links_data: data.links_data};
+    post(latest);
+    refreshImage();
+  });
+  ["link_state", "node_state", "link_anomaly"].forEach(function (type) {
+    events.addEventListener(type, function (event) { post(JSON.parse(event.data)); });
+  });
+  events.addEventListener("config", refreshImage);
+
+  refreshImage();
+  post({type: "ready", map: mapName});
+})();
+</script>
+</body>
+</html>
+`))
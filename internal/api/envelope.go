@@ -0,0 +1,89 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"go-weathermap/internal/utils"
+)
+
+const (
+	APIPrefix       = "/api/v1"
+	requestIDHeader = "X-Request-ID"
+	maxPageLimit    = 1000
+)
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// apiV1 serves legacy routes under /api/v1 with responses wrapped in utils.Envelope.
+// A client supplied X-Request-ID is kept so requests can be traced across services.
+func apiV1(legacy http.Handler) http.Handler {
+	return http.StripPrefix(APIPrefix, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+
+		ew := utils.NewEnvelopeWriter(w, requestID)
+		legacy.ServeHTTP(ew, r)
+		ew.Finish()
+	}))
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// paginate applies offset and limit query params to a list served under /api/v1
+// and reports them in the envelope meta. Legacy routes always get the whole list.
+func paginate[T any](w http.ResponseWriter, r *http.Request, items []T) ([]T, error) {
+	ew, ok := w.(*utils.EnvelopeWriter)
+	if !ok {
+		return items, nil
+	}
+	offset, err := pageParam(r, "offset", 0)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := pageParam(r, "limit", len(items))
+	if err != nil {
+		return nil, err
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+	ew.SetPagination(utils.Pagination{Offset: offset, Limit: limit, Total: len(items)})
+
+	if offset >= len(items) {
+		return []T{}, nil
+	}
+	return items[offset:min(offset+limit, len(items))], nil
+}
+
+func respondWithList[T any](w http.ResponseWriter, r *http.Request, items []T) {
+	page, err := paginate(w, r, items)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, page)
+}
+
+func pageParam(r *http.Request, name string, def int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s: must be a non-negative integer", name)
+	}
+	return n, nil
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-weathermap/internal/config"
+)
+
+// mapETag hashes the data of a map as it is sent, without its processed_at which changes on
+// every read: the tag changes with the config of the map and the metrics of its links and nodes
+func mapETag(payload any) string {
+	if m, ok := payload.(*config.MapWithData); ok {
+		unstamped := *m
+		unstamped.ProcessedAt = time.Time{}
+		payload = &unstamped
+	}
+	h := sha256.New()
+	_ = json.NewEncoder(h).Encode(payload)
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// notModified sets the ETag of the response and answers 304 when the If-None-Match of the
+// request has it, the caller writes nothing more then
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,245 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/service"
+	"go-weathermap/internal/utils"
+)
+
+const (
+	sseHeartbeatInterval = 30 * time.Second
+	sseRetry             = 5 * time.Second
+)
+
+// StateChangeEvent is sent when a link or a node changes status between refreshes
+type StateChangeEvent struct {
+	Type           string    `json:"type"`
+	Map            string    `json:"map"`
+	Name           string    `json:"name"`
+	Status         string    `json:"status"`
+	PreviousStatus string    `json:"previous_status"`
+	Time           time.Time `json:"time"`
+}
+
+// ConfigChangeEvent is sent after the map config was saved or deleted
+type ConfigChangeEvent struct {
+	Type    string    `json:"type"`
+	Map     string    `json:"map"`
+	Deleted bool      `json:"deleted,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+type eventStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func (e *eventStream) send(event string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(e.w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	e.flusher.Flush()
+	return nil
+}
+
+func (e *eventStream) heartbeat() error {
+	if _, err := fmt.Fprint(e.w, ": ping\n\n"); err != nil {
+		return err
+	}
+	e.flusher.Flush()
+	return nil
+}
+
+func (s *Server) MapEvents(w http.ResponseWriter, r *http.Request, mapName string) {
+	if _, err := s.mapService.GetMap(mapName); err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		} else {
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		utils.RespondWithError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	// nil channel when no datasource service: only config events are sent
+	var updates <-chan struct{}
+	if s.dataSourceService != nil {
+		var unsubscribe func()
+		updates, unsubscribe = s.dataSourceService.SubscribeUpdates()
+		defer unsubscribe()
+	}
+	changes, unsubscribeChanges := s.mapService.SubscribeChanges(mapName)
+	defer unsubscribeChanges()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	// the stream outlives the write timeout of the server
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds()); err != nil {
+		return
+	}
+	stream := &eventStream{w: w, flusher: flusher}
+
+	var last *config.MapWithData
+	refresh := func() error {
+		mapWithData, err := s.mapService.GetMapWithData(r.Context(), mapName, s.dataSourceService)
+		if err != nil {
+			return err
+		}
+		if last != nil {
+			for _, change := range stateChanges(mapName, last, mapWithData) {
+				if err := stream.send(change.Type, change); err != nil {
+					return err
+				}
+			}
+		}
+		if last == nil || !reflect.DeepEqual(last.LinksData, mapWithData.LinksData) {
+			err := stream.send("metrics", LinksUpdateMessage{
+				Type:        "metrics",
+				Map:         mapName,
+				ProcessedAt: mapWithData.ProcessedAt,
+				LinksData:   mapWithData.LinksData,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		last = mapWithData
+		return nil
+	}
+
+	if err := refresh(); err != nil {
+		s.logger.Warn("event stream closed", "map", mapName, "error", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+	lastPush := time.Now()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.closing:
+			return
+		case <-heartbeat.C:
+			if err := stream.heartbeat(); err != nil {
+				return
+			}
+		case <-changes:
+			if _, err := s.mapService.GetMap(mapName); err != nil {
+				_ = stream.send("config", ConfigChangeEvent{Type: "config", Map: mapName, Deleted: true, Time: time.Now()})
+				return
+			}
+			if err := stream.send("config", ConfigChangeEvent{Type: "config", Map: mapName, Time: time.Now()}); err != nil {
+				return
+			}
+			if err := refresh(); err != nil {
+				s.logger.Warn("event stream closed", "map", mapName, "error", err)
+				return
+			}
+		case <-updates:
+			if wait := minPushPeriod - time.Since(lastPush); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-r.Context().Done():
+					return
+				}
+			}
+			lastPush = time.Now()
+			if err := refresh(); err != nil {
+				s.logger.Warn("event stream closed", "map", mapName, "error", err)
+				return
+			}
+		}
+	}
+}
+
+// stateChanges lists link and node status transitions between two refreshes of a map,
+// and links becoming anomalous or normal again. Links and nodes which were added or removed by a config edit are not reported.
+func stateChanges(mapName string, prev, cur *config.MapWithData) []StateChangeEvent {
+	now := time.Now()
+	var events []StateChangeEvent
+
+	prevLinks := make(map[string]string, len(prev.LinksData))
+	prevAnomalies := make(map[string]bool, len(prev.LinksData))
+	for _, ld := range prev.LinksData {
+		prevLinks[ld.Name] = ld.Status
+		prevAnomalies[ld.Name] = ld.Anomalous
+	}
+	for _, ld := range cur.LinksData {
+		if before, ok := prevLinks[ld.Name]; ok && before != ld.Status {
+			events = append(events, StateChangeEvent{
+				Type: "link_state", Map: mapName, Name: ld.Name,
+				Status: ld.Status, PreviousStatus: before, Time: now,
+			})
+		}
+		if before, ok := prevAnomalies[ld.Name]; ok && before != ld.Anomalous {
+			events = append(events, StateChangeEvent{
+				Type: "link_anomaly", Map: mapName, Name: ld.Name,
+				Status: anomalyStatus(ld.Anomalous), PreviousStatus: anomalyStatus(before), Time: now,
+			})
+		}
+	}
+
+	prevNodes, curNodes := nodeStatuses(prev), nodeStatuses(cur)
+	for _, node := range cur.Nodes {
+		status := curNodes[node.Name]
+		if before, ok := prevNodes[node.Name]; ok && before != status {
+			events = append(events, StateChangeEvent{
+				Type: "node_state", Map: mapName, Name: node.Name,
+				Status: status, PreviousStatus: before, Time: now,
+			})
+		}
+	}
+	return events
+}
+
+func anomalyStatus(anomalous bool) string {
+	if anomalous {
+		return "anomalous"
+	}
+	return "normal"
+}
+
+// nodeStatuses derives node status from attached links: up when any link is up,
+// down when every polled link is down, unknown otherwise.
+func nodeStatuses(m *config.MapWithData) map[string]string {
+	statuses := make(map[string]string, len(m.Nodes))
+	for _, node := range m.Nodes {
+		statuses[node.Name] = "unknown"
+	}
+	for i, link := range m.Links {
+		if i >= len(m.LinksData) {
+			break
+		}
+		status := m.LinksData[i].Status
+		for _, name := range []string{link.From, link.To} {
+			switch {
+			case status == "up" || status == "degraded" || status == "misconfigured":
+				statuses[name] = "up"
+			case status == "down" && statuses[name] == "unknown":
+				statuses[name] = "down"
+			}
+		}
+	}
+	return statuses
+}
@@ -0,0 +1,239 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"go-weathermap/internal/render"
+	"go-weathermap/internal/service"
+	"go-weathermap/internal/utils"
+)
+
+const (
+	exportFormatPDF = "pdf"
+	importPath      = "/maps/import"
+)
+
+// ExportMap serializes a map for other tools, ?format selects the syntax
+func (s *Server) ExportMap(w http.ResponseWriter, r *http.Request, mapName string) {
+	format := r.URL.Query().Get("format")
+	switch format {
+	case service.ExportFormatWeathermap:
+	case service.ExportFormatYAML:
+		s.ExportMapYAML(w, r, mapName)
+		return
+	case exportFormatPDF:
+		s.ExportMapPDF(w, r, mapName)
+		return
+	case service.ExportFormatBundle:
+		s.ExportMapBundle(w, r, mapName)
+		return
+	default:
+		utils.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("unsupported export format: '%s', must be '%s', '%s', '%s' or '%s'",
+			format, service.ExportFormatYAML, service.ExportFormatWeathermap, exportFormatPDF, service.ExportFormatBundle))
+		return
+	}
+
+	data, err := s.mapService.ExportWeathermapConf(mapName)
+	if err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		} else {
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.conf"`, mapName))
+	_, _ = w.Write(data)
+}
+
+// ExportMapYAML writes the map file, which POST /maps/import adds to another server
+func (s *Server) ExportMapYAML(w http.ResponseWriter, r *http.Request, mapName string) {
+	data, err := s.mapService.ExportYAML(mapName)
+	if err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		} else {
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.yaml"`, mapName))
+	_, _ = w.Write(data)
+}
+
+// ImportMap handles POST /maps/import?name=, adding the map of a YAML body, raw or as the
+// file part of a multipart form. ?replace=true overwrites an existing map, ?dry_run=true
+// only validates it. Other methods reach the map named import.
+func (s *Server) ImportMap(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		s.HandleMapOperations(w, r)
+		return
+	}
+	mapName := r.URL.Query().Get("name")
+	replace := r.URL.Query().Get("replace") == "true"
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+	if !s.authorizeMap(w, r, mapName, service.RoleEditor) {
+		return
+	}
+
+	content, err := importContent(r)
+	if err != nil {
+		respondWithBodyError(w, err, err.Error())
+		return
+	}
+	result, err := s.mapService.ImportMap(mapName, content, replace, dryRun)
+	respondWithImport(w, result, err)
+}
+
+// ImportMapBundle handles POST /maps/{name}/bundle, adding the map of a bundle with the icons
+// the server lacks. The bundle is the body or the file part of a multipart form, ?replace and
+// ?dry_run work as for POST /maps/import.
+func (s *Server) ImportMapBundle(w http.ResponseWriter, r *http.Request, mapName string) {
+	replace := r.URL.Query().Get("replace") == "true"
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+	content, err := importContent(r)
+	if err != nil {
+		respondWithBodyError(w, err, err.Error())
+		return
+	}
+	result, err := s.mapService.ImportMapBundle(mapName, content, replace, dryRun)
+	respondWithImport(w, result, err)
+}
+
+// respondWithImport answers 201 for a map created by an import, 200 for a replaced map or a
+// dry run
+func respondWithImport(w http.ResponseWriter, result *service.ImportResult, err error) {
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), "already exists"):
+			utils.RespondWithError(w, http.StatusConflict, err.Error())
+		case strings.Contains(err.Error(), "exceed limit"):
+			utils.RespondWithError(w, http.StatusTooManyRequests, err.Error())
+		case strings.Contains(err.Error(), "invalid"), strings.Contains(err.Error(), "validation failed"), strings.Contains(err.Error(), "required"), strings.Contains(err.Error(), "must be"):
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		default:
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	code := http.StatusOK
+	if result.Created && !result.DryRun {
+		code = http.StatusCreated
+	}
+	utils.RespondWithJSON(w, code, result)
+}
+
+// importContent reads the map file of an import, the body itself or the file part of a
+// multipart form
+func importContent(r *http.Request) ([]byte, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return io.ReadAll(r.Body)
+	}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, fmt.Errorf("invalid multipart form: %w", err)
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("invalid multipart form: no file part")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid multipart form: %w", err)
+		}
+		if part.FileName() != "" || part.FormName() == "file" {
+			return io.ReadAll(part)
+		}
+	}
+}
+
+// ExportMapBundle writes a zip of the map file and the icons of its nodes, which
+// `weathermap import` adds to another server
+func (s *Server) ExportMapBundle(w http.ResponseWriter, r *http.Request, mapName string) {
+	data, err := s.mapService.ExportBundle(mapName)
+	if err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		} else {
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, mapName))
+	_, _ = w.Write(data)
+}
+
+// ExportMapBundleTarGz handles GET /maps/{name}/bundle, the files of the zip bundle as a
+// tar.gz, which POST /maps/{name}/bundle adds to another server
+func (s *Server) ExportMapBundleTarGz(w http.ResponseWriter, r *http.Request, mapName string) {
+	data, err := s.mapService.ExportBundleTarGz(mapName)
+	if err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		} else {
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.tar.gz"`, mapName))
+	_, _ = w.Write(data)
+}
+
+// ExportMapPDF renders a printable document, ?paper and ?orientation select the page layout
+func (s *Server) ExportMapPDF(w http.ResponseWriter, r *http.Request, mapName string) {
+	opts := render.PDFOptions{
+		Paper:       strings.ToLower(r.URL.Query().Get("paper")),
+		Orientation: strings.ToLower(r.URL.Query().Get("orientation")),
+	}
+
+	mapWithData, err := s.mapService.GetMapWithData(r.Context(), mapName, s.dataSourceService)
+	if err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		} else {
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	if err := highlightPath(r, mapWithData); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := applyOverlay(r, mapWithData); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := applyAccessible(r, mapWithData); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := s.pdfRenderer.Render(&buf, mapWithData, opts); err != nil {
+		if strings.Contains(err.Error(), "unsupported") || strings.Contains(err.Error(), "exceeds limit") {
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		} else {
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, mapName))
+	_, _ = w.Write(buf.Bytes())
+}
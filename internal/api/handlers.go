@@ -1,11 +1,21 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"go-weathermap/internal/config"
+	"go-weathermap/internal/render"
+	"go-weathermap/internal/service"
 	"go-weathermap/internal/utils"
 )
 
@@ -14,6 +24,10 @@ func (s *Server) HandleMaps(w http.ResponseWriter, r *http.Request) {
 	case "GET":
 		s.ListMaps(w, r)
 	case "POST":
+		if templateName := r.URL.Query().Get("template"); templateName != "" {
+			s.CreateMapFromTemplate(w, r, templateName)
+			return
+		}
 		s.CreateMap(w, r)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -26,7 +40,14 @@ func (s *Server) HandleMapOperations(w http.ResponseWriter, r *http.Request) {
 		utils.RespondWithError(w, http.StatusBadRequest, "Map name is required")
 		return
 	}
+	if err := s.resolveRefs(r, parts); err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 	mapName := parts[0]
+	if !s.authorizeMap(w, r, mapName, requiredRole(r.Method, parts)) {
+		return
+	}
 
 	switch r.Method {
 	case "GET":
@@ -42,12 +63,92 @@ func (s *Server) HandleMapOperations(w http.ResponseWriter, r *http.Request) {
 			s.GetMapVariables(w, r, mapName)
 			return
 		}
+		if len(parts) == 2 && parts[1] == "render.svg" {
+			s.RenderMapSVG(w, r, mapName)
+			return
+		}
+		if len(parts) == 2 && parts[1] == "render.png" {
+			s.RenderMapPNG(w, r, mapName)
+			return
+		}
+		if len(parts) == 5 && parts[1] == "tiles" {
+			s.RenderMapTile(w, r, mapName, parts[2], parts[3], parts[4])
+			return
+		}
+		if len(parts) == 4 && parts[1] == "links" && parts[3] == "snapshot.png" {
+			s.LinkSnapshot(w, r, mapName, parts[2])
+			return
+		}
+		if len(parts) == 4 && parts[1] == "links" && parts[3] == "capture" {
+			s.GetLinkCapture(w, r, mapName, parts[2])
+			return
+		}
+		if len(parts) == 4 && parts[1] == "links" && parts[3] == "history" {
+			s.GetLinkTraffic(w, r, mapName, parts[2])
+			return
+		}
+		if len(parts) == 5 && parts[1] == "links" && parts[3] == "history" && parts[4] == "export" {
+			s.ExportLinkHistory(w, r, mapName, parts[2])
+			return
+		}
+		if len(parts) == 2 && parts[1] == "demands" {
+			s.GetDemands(w, r, mapName)
+			return
+		}
+		if len(parts) == 2 && parts[1] == "planned" {
+			s.GetPlannedLoad(w, r, mapName)
+			return
+		}
+		if len(parts) == 2 && parts[1] == "urls" {
+			s.InfoURLStatus(w, r, mapName)
+			return
+		}
+		if len(parts) == 2 && parts[1] == "schedules" {
+			s.GetSchedules(w, r, mapName)
+			return
+		}
+		if len(parts) == 2 && parts[1] == "alerts" {
+			s.GetAlerts(w, r, mapName)
+			return
+		}
+		if len(parts) == 2 && parts[1] == "export" {
+			s.ExportMap(w, r, mapName)
+			return
+		}
+		if len(parts) == 2 && parts[1] == "bundle" {
+			s.ExportMapBundleTarGz(w, r, mapName)
+			return
+		}
+		if len(parts) == 2 && parts[1] == "ws" {
+			s.MapWebSocket(w, r, mapName)
+			return
+		}
+		if len(parts) == 2 && parts[1] == "embed" {
+			s.MapEmbed(w, r, mapName)
+			return
+		}
+		if len(parts) == 2 && parts[1] == "audit" {
+			s.GetMapAuditLog(w, r, mapName)
+			return
+		}
+		if len(parts) == 2 && parts[1] == "events" {
+			s.MapEvents(w, r, mapName)
+			return
+		}
 		s.GetMap(w, r)
 	case "PATCH":
+		if len(parts) == 3 && parts[1] == "nodes" && parts[2] == "bulk" {
+			s.EditNodesBulk(w, r)
+			return
+		}
 		if len(parts) == 3 && parts[1] == "nodes" {
 			s.EditNode(w, r)
 			return
 		}
+		if len(parts) == 3 && parts[1] == "links" && parts[2] == "bulk" {
+			s.EditLinksBulk(w, r)
+			return
+		}
 		if len(parts) == 3 && parts[1] == "links" {
 			s.EditLink(w, r)
 			return
@@ -62,6 +163,10 @@ func (s *Server) HandleMapOperations(w http.ResponseWriter, r *http.Request) {
 		}
 		http.NotFound(w, r)
 	case "POST":
+		if len(parts) == 2 && parts[1] == "bundle" {
+			s.ImportMapBundle(w, r, mapName)
+			return
+		}
 		if len(parts) == 2 && parts[1] == "nodes" {
 			s.AddNode(w, r)
 			return
@@ -78,6 +183,26 @@ func (s *Server) HandleMapOperations(w http.ResponseWriter, r *http.Request) {
 			s.AddLinksBulk(w, r)
 			return
 		}
+		if len(parts) == 4 && parts[1] == "links" && parts[3] == "capture" {
+			s.StartLinkCapture(w, r, mapName, parts[2])
+			return
+		}
+		if len(parts) == 2 && parts[1] == "path" {
+			s.MapPath(w, r, mapName)
+			return
+		}
+		if len(parts) == 2 && parts[1] == "simulate" {
+			s.SimulateFailure(w, r, mapName)
+			return
+		}
+		if len(parts) == 2 && parts[1] == "discover" {
+			s.DiscoverTopology(w, r, mapName)
+			return
+		}
+		if len(parts) == 3 && parts[1] == "netbox" && parts[2] == "sync" {
+			s.SyncNetBox(w, r, mapName)
+			return
+		}
 		http.NotFound(w, r)
 	case "DELETE":
 		if len(parts) == 3 && parts[1] == "nodes" && parts[2] == "bulk" {
@@ -88,6 +213,10 @@ func (s *Server) HandleMapOperations(w http.ResponseWriter, r *http.Request) {
 			s.DeleteLinksBulk(w, r)
 			return
 		}
+		if len(parts) == 4 && parts[1] == "links" && parts[3] == "capture" {
+			s.StopLinkCapture(w, r, mapName, parts[2])
+			return
+		}
 		if len(parts) == 3 && parts[1] == "nodes" {
 			s.DeleteNode(w, r)
 			return
@@ -101,6 +230,16 @@ func (s *Server) HandleMapOperations(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		http.NotFound(w, r)
+	case "PUT":
+		if len(parts) == 1 {
+			s.ReplaceMap(w, r, mapName)
+			return
+		}
+		if len(parts) == 2 && parts[1] == "demands" {
+			s.UpdateDemands(w, r, mapName)
+			return
+		}
+		http.NotFound(w, r)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
@@ -111,28 +250,33 @@ func (s *Server) ListMapNodes(w http.ResponseWriter, r *http.Request, mapName st
 		utils.RespondWithError(w, http.StatusBadRequest, "Map name is required")
 		return
 	}
-	mapWithData, err := s.mapService.GetMapWithData(mapName, s.dataSourceService)
+	mapWithData, err := s.mapService.GetMapWithData(r.Context(), mapName, s.dataSourceService)
 	if err != nil {
-		if strings.Contains(err.Error(), "map not found") {
+		if errors.Is(err, service.ErrNotFound) {
 			utils.RespondWithError(w, http.StatusNotFound, err.Error())
 		} else {
 			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		}
 		return
 	}
+	since, err := parseUpdatedSince(r)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	searchQuery := r.URL.Query().Get("search")
-	if searchQuery == "" {
-		utils.RespondWithJSON(w, http.StatusOK, mapWithData.Nodes)
+	if searchQuery == "" && since.IsZero() {
+		respondWithList(w, r, mapWithData.Nodes)
 		return
 	}
 
 	var filteredNodes []config.Node
 	for _, node := range mapWithData.Nodes {
-		if strings.Contains(strings.ToLower(node.Name), strings.ToLower(searchQuery)) {
+		if strings.Contains(strings.ToLower(node.Name), strings.ToLower(searchQuery)) && updatedSince(node.UpdatedAt, since) {
 			filteredNodes = append(filteredNodes, node)
 		}
 	}
-	utils.RespondWithJSON(w, http.StatusOK, filteredNodes)
+	respondWithList(w, r, filteredNodes)
 }
 
 func (s *Server) ListMapLinks(w http.ResponseWriter, r *http.Request, mapName string) {
@@ -140,19 +284,24 @@ func (s *Server) ListMapLinks(w http.ResponseWriter, r *http.Request, mapName st
 		utils.RespondWithError(w, http.StatusBadRequest, "Map name is required")
 		return
 	}
-	mapWithData, err := s.mapService.GetMapWithData(mapName, s.dataSourceService)
+	mapWithData, err := s.mapService.GetMapWithData(r.Context(), mapName, s.dataSourceService)
 	if err != nil {
-		if strings.Contains(err.Error(), "map not found") {
+		if errors.Is(err, service.ErrNotFound) {
 			utils.RespondWithError(w, http.StatusNotFound, err.Error())
 		} else {
 			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		}
 		return
 	}
+	since, err := parseUpdatedSince(r)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	statusQuery := r.URL.Query().Get("status")
 	nodeQuery := r.URL.Query().Get("node")
-	if statusQuery == "" && nodeQuery == "" {
-		utils.RespondWithJSON(w, http.StatusOK, mapWithData.LinksData)
+	if statusQuery == "" && nodeQuery == "" && since.IsZero() {
+		respondWithList(w, r, mapWithData.LinksData)
 		return
 	}
 
@@ -169,11 +318,14 @@ func (s *Server) ListMapLinks(w http.ResponseWriter, r *http.Request, mapName st
 				match = false
 			}
 		}
+		if !updatedSince(mapWithData.Links[i].UpdatedAt, since) {
+			match = false
+		}
 		if match {
 			filteredLinks = append(filteredLinks, link)
 		}
 	}
-	utils.RespondWithJSON(w, http.StatusOK, filteredLinks)
+	respondWithList(w, r, filteredLinks)
 }
 
 func (s *Server) ListMaps(w http.ResponseWriter, r *http.Request) {
@@ -182,13 +334,37 @@ func (s *Server) ListMaps(w http.ResponseWriter, r *http.Request) {
 		utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	utils.RespondWithJSON(w, http.StatusOK, map[string][]string{"maps": maps})
+	since, err := parseUpdatedSince(r)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	visible := make([]string, 0, len(maps))
+	for _, name := range maps {
+		ok, err := s.canView(r, name)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if ok && !since.IsZero() {
+			m, err := s.mapService.GetMap(name)
+			ok = err == nil && updatedSince(m.UpdatedAt, since)
+		}
+		if ok {
+			visible = append(visible, name)
+		}
+	}
+	page, err := paginate(w, r, visible)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, map[string][]string{"maps": page})
 }
 
 func (s *Server) CreateMap(w http.ResponseWriter, r *http.Request) {
 	var newMap config.Map
-	if err := json.NewDecoder(r.Body).Decode(&newMap); err != nil {
-		utils.RespondWithError(w, http.StatusBadRequest, "Invalid JSON")
+	if !decodePayload(w, r, &newMap, payloadOf(config.PayloadMap, false)) {
 		return
 	}
 
@@ -197,10 +373,13 @@ func (s *Server) CreateMap(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	mapName := strings.ToLower(strings.ReplaceAll(newMap.Title, " ", "-"))
+	mapName := service.MapNameFromTitle(newMap.Title)
+	if !s.authorizeMap(w, r, mapName, service.RoleEditor) {
+		return
+	}
 
 	if err := s.mapService.CreateMap(&newMap, mapName); err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithMapError(w, err)
 		return
 	}
 
@@ -210,22 +389,83 @@ func (s *Server) CreateMap(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// ReplaceMap stores the whole map config under the given name, creating it when missing
+func (s *Server) ReplaceMap(w http.ResponseWriter, r *http.Request, mapName string) {
+	var newMap config.Map
+	if !decodePayload(w, r, &newMap, replacedMap) {
+		return
+	}
+
+	created, err := s.mapService.ReplaceMap(mapName, &newMap)
+	if err != nil {
+		respondWithMapError(w, err)
+		return
+	}
+
+	if created {
+		utils.RespondWithJSON(w, http.StatusCreated, map[string]string{
+			"status": "map created",
+			"name":   mapName,
+		})
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, map[string]string{
+		"status": "map replaced",
+		"name":   mapName,
+	})
+}
+
+// resolveRefs replaces map, node and link ids in the path by their names, the handlers
+// and the service address objects by name
+func (s *Server) resolveRefs(r *http.Request, parts []string) error {
+	name, err := s.mapService.ResolveMapName(parts[0])
+	if err != nil {
+		return err
+	}
+	changed := name != parts[0]
+	parts[0] = name
+	if len(parts) >= 3 && (parts[1] == "nodes" || parts[1] == "links") && utils.IsULID(parts[2]) {
+		kind := service.ObjectNode
+		if parts[1] == "links" {
+			kind = service.ObjectLink
+		}
+		if name, err := s.mapService.ResolveObjectName(parts[0], kind, parts[2]); err == nil && name != parts[2] {
+			parts[2], changed = name, true
+		}
+	}
+	if changed {
+		r.URL.Path = "/maps/" + strings.Join(parts, "/")
+		r.URL.RawPath = ""
+	}
+	return nil
+}
+
 func (s *Server) GetMap(w http.ResponseWriter, r *http.Request) {
 	mapName := strings.TrimPrefix(r.URL.Path, "/maps/")
-	mapWithData, err := s.mapService.GetMapWithData(mapName, s.dataSourceService)
+	mapWithData, err := s.mapService.GetMapWithData(r.Context(), mapName, s.dataSourceService)
 	if err != nil {
-		if strings.Contains(err.Error(), "map not found") {
+		if errors.Is(err, service.ErrNotFound) {
 			utils.RespondWithError(w, http.StatusNotFound, err.Error())
-		} else if strings.Contains(err.Error(), "validation failed") {
-			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		} else {
 			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		}
 		return
 	}
+	zoom, err := parseZoom(r)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if mapWithData, err = applyClustering(r, mapWithData, zoom); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	include := r.URL.Query().Get("include")
 	if include == "" {
+		if notModified(w, r, mapETag(mapWithData)) {
+			return
+		}
 		utils.RespondWithJSON(w, http.StatusOK, mapWithData)
 		return
 	}
@@ -250,29 +490,245 @@ func (s *Server) GetMap(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if notModified(w, r, mapETag(filteredData)) {
+		return
+	}
 	utils.RespondWithJSON(w, http.StatusOK, filteredData)
 }
 
-func (s *Server) AddNode(w http.ResponseWriter, r *http.Request) {
-	parts := strings.Split(r.URL.Path, "/")
-	mapName := parts[2]
-	var node config.Node
-	if err := json.NewDecoder(r.Body).Decode(&node); err != nil {
-		utils.RespondWithError(w, http.StatusBadRequest, "Invalid JSON")
+func (s *Server) RenderMapSVG(w http.ResponseWriter, r *http.Request, mapName string) {
+	mapWithData, err := s.mapService.GetMapWithData(r.Context(), mapName, s.dataSourceService)
+	if err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		} else {
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
 		return
 	}
-	if err := s.mapService.AddNode(mapName, &node); err != nil {
-		if strings.Contains(err.Error(), "already exists") {
-			utils.RespondWithError(w, http.StatusConflict, err.Error())
-		} else if strings.Contains(err.Error(), "out of map bounds") {
+	if err := highlightPath(r, mapWithData); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := applyOverlay(r, mapWithData); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := applyAccessible(r, mapWithData); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	region, zoom, err := parseViewport(r, mapWithData.Map)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if mapWithData, err = applyClustering(r, mapWithData, zoom); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := s.svgRenderer.RenderRegion(&buf, mapWithData, region, zoom); err != nil {
+		if strings.Contains(err.Error(), "outside of the map") {
 			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
-		} else if strings.Contains(err.Error(), "validation failed") {
+		} else {
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write(buf.Bytes())
+}
+
+func (s *Server) RenderMapPNG(w http.ResponseWriter, r *http.Request, mapName string) {
+	width, err := parseImageDimension(r.URL.Query().Get("width"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "invalid width: "+err.Error())
+		return
+	}
+	height, err := parseImageDimension(r.URL.Query().Get("height"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "invalid height: "+err.Error())
+		return
+	}
+
+	mapWithData, err := s.mapService.GetMapWithData(r.Context(), mapName, s.dataSourceService)
+	if err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		} else {
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	if err := highlightPath(r, mapWithData); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := applyOverlay(r, mapWithData); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := applyAccessible(r, mapWithData); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	region, zoom, err := parseViewport(r, mapWithData.Map)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if width == 0 && height == 0 && zoom != 1 {
+		width = int(math.Round(float64(region.Dx()) * zoom))
+		height = int(math.Round(float64(region.Dy()) * zoom))
+	}
+	scale := 1.0
+	if width > 0 {
+		scale = float64(width) / float64(region.Dx())
+	} else if height > 0 {
+		scale = float64(height) / float64(region.Dy())
+	}
+	if mapWithData, err = applyClustering(r, mapWithData, scale); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := s.pngRenderer.RenderRegion(&buf, mapWithData, region, width, height); err != nil {
+		if strings.Contains(err.Error(), "exceeds limit") || strings.Contains(err.Error(), "outside of the map") {
 			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
 		} else {
 			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		}
 		return
 	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write(buf.Bytes())
+}
+
+// applyAccessible lets ?accessible=true|false override the accessible setting of the map
+func applyAccessible(r *http.Request, m *config.MapWithData) error {
+	value := r.URL.Query().Get("accessible")
+	if value == "" {
+		return nil
+	}
+	accessible, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("invalid accessible: must be true or false")
+	}
+	m.Accessible = accessible
+	return nil
+}
+
+const maxZoom = 8
+
+// parseViewport reads the x, y, w, h crop and the zoom factor of a render request,
+// without w and h the whole map is rendered
+func parseViewport(r *http.Request, m *config.Map) (image.Rectangle, float64, error) {
+	query := r.URL.Query()
+	zoom, err := parseZoom(r)
+	if err != nil {
+		return image.Rectangle{}, 0, err
+	}
+
+	region := image.Rect(0, 0, m.Width, m.Height)
+	if query.Get("x") == "" && query.Get("y") == "" && query.Get("w") == "" && query.Get("h") == "" {
+		return region, zoom, nil
+	}
+	var viewport [4]int
+	for i, name := range []string{"x", "y", "w", "h"} {
+		value := query.Get(name)
+		if value == "" && i < 2 {
+			continue
+		}
+		v, err := strconv.Atoi(value)
+		if err != nil || v < 0 || (i >= 2 && v == 0) {
+			return image.Rectangle{}, 0, fmt.Errorf("invalid viewport: x and y must be non-negative, w and h positive integers")
+		}
+		viewport[i] = v
+	}
+	region = image.Rect(viewport[0], viewport[1], viewport[0]+viewport[2], viewport[1]+viewport[3])
+	return region, zoom, nil
+}
+
+// parseUpdatedSince reads ?updated_since, zero when missing
+func parseUpdatedSince(r *http.Request) (time.Time, error) {
+	value := r.URL.Query().Get("updated_since")
+	if value == "" {
+		return time.Time{}, nil
+	}
+	since, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid updated_since: must be an RFC 3339 time")
+	}
+	return since, nil
+}
+
+// updatedSince reports whether an object was updated at or after since, objects saved before
+// timestamps were tracked only match without a filter
+func updatedSince(updatedAt *time.Time, since time.Time) bool {
+	return since.IsZero() || (updatedAt != nil && !updatedAt.Before(since))
+}
+
+func parseZoom(r *http.Request) (float64, error) {
+	value := r.URL.Query().Get("zoom")
+	if value == "" {
+		return 1, nil
+	}
+	zoom, err := strconv.ParseFloat(value, 64)
+	if err != nil || zoom <= 0 || zoom > maxZoom {
+		return 0, fmt.Errorf("invalid zoom: must be above 0 and at most %d", maxZoom)
+	}
+	return zoom, nil
+}
+
+func parseImageDimension(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size <= 0 || size > render.MaxRasterSize {
+		return 0, fmt.Errorf("must be between 1 and %d", render.MaxRasterSize)
+	}
+	return size, nil
+}
+
+// respondWithMapError answers a failed change of a map, its nodes or links by the kind of
+// error of the service: 404 for what doesn't exist, 409 for a name already taken, 400 for an
+// invalid change and 429 for a limit
+func respondWithMapError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrNotFound):
+		utils.RespondWithError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, service.ErrExists):
+		utils.RespondWithError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, service.ErrInvalid):
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, service.ErrLimit):
+		utils.RespondWithError(w, http.StatusTooManyRequests, err.Error())
+	default:
+		utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func (s *Server) AddNode(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(r.URL.Path, "/")
+	mapName := parts[2]
+	var node config.Node
+	if !decodePayload(w, r, &node, payloadOf(config.PayloadNode, false)) {
+		return
+	}
+	if err := s.mapService.AddNode(mapName, &node); err != nil {
+		respondWithMapError(w, err)
+		return
+	}
 	utils.RespondWithJSON(w, http.StatusOK, map[string]string{"status": "node added"})
 }
 
@@ -280,18 +736,11 @@ func (s *Server) AddLink(w http.ResponseWriter, r *http.Request) {
 	parts := strings.Split(r.URL.Path, "/")
 	mapName := parts[2]
 	var link config.Link
-	if err := json.NewDecoder(r.Body).Decode(&link); err != nil {
-		utils.RespondWithError(w, http.StatusBadRequest, "Invalid JSON")
+	if !decodePayload(w, r, &link, payloadOf(config.PayloadLink, false)) {
 		return
 	}
 	if err := s.mapService.AddLink(mapName, &link); err != nil {
-		if strings.Contains(err.Error(), "already exists") {
-			utils.RespondWithError(w, http.StatusConflict, err.Error())
-		} else if strings.Contains(err.Error(), "validation failed") {
-			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
-		} else {
-			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
-		}
+		respondWithMapError(w, err)
 		return
 	}
 	utils.RespondWithJSON(w, http.StatusOK, map[string]string{"status": "link added"})
@@ -302,19 +751,12 @@ func (s *Server) EditMap(w http.ResponseWriter, r *http.Request) {
 	mapName := parts[0]
 
 	var mapUpdates map[string]any
-	if err := json.NewDecoder(r.Body).Decode(&mapUpdates); err != nil {
-		utils.RespondWithError(w, http.StatusBadRequest, "Invalid JSON for map edit")
+	if !decodePayload(w, r, &mapUpdates, payloadOf(config.PayloadMap, true)) {
 		return
 	}
 
 	if err := s.mapService.EditMap(mapName, mapUpdates); err != nil {
-		if strings.Contains(err.Error(), "map not found") {
-			utils.RespondWithError(w, http.StatusNotFound, err.Error())
-		} else if strings.Contains(err.Error(), "must be greater than 0") {
-			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
-		} else {
-			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
-		}
+		respondWithMapError(w, err)
 		return
 	}
 
@@ -327,13 +769,12 @@ func (s *Server) EditNode(w http.ResponseWriter, r *http.Request) {
 	nodeName := parts[2]
 
 	var nodeUpdates map[string]any
-	if err := json.NewDecoder(r.Body).Decode(&nodeUpdates); err != nil {
-		utils.RespondWithError(w, http.StatusBadRequest, "Invalid JSON for node edit")
+	if !decodePayload(w, r, &nodeUpdates, payloadOf(config.PayloadNode, true)) {
 		return
 	}
 
 	if err := s.mapService.EditNode(mapName, nodeName, nodeUpdates); err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithMapError(w, err)
 		return
 	}
 
@@ -346,17 +787,12 @@ func (s *Server) EditLink(w http.ResponseWriter, r *http.Request) {
 	linkName := parts[2]
 
 	var linkUpdates map[string]any
-	if err := json.NewDecoder(r.Body).Decode(&linkUpdates); err != nil {
-		utils.RespondWithError(w, http.StatusBadRequest, "Invalid JSON for link edit")
+	if !decodePayload(w, r, &linkUpdates, payloadOf(config.PayloadLink, true)) {
 		return
 	}
 
 	if err := s.mapService.EditLink(mapName, linkName, linkUpdates); err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			utils.RespondWithError(w, http.StatusNotFound, err.Error())
-		} else {
-			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
-		}
+		respondWithMapError(w, err)
 		return
 	}
 
@@ -371,11 +807,7 @@ func (s *Server) DeleteNode(w http.ResponseWriter, r *http.Request) {
 	mapName := parts[2]
 	nodeName := parts[4]
 	if err := s.mapService.DeleteNode(mapName, nodeName); err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			utils.RespondWithError(w, http.StatusNotFound, err.Error())
-		} else {
-			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
-		}
+		respondWithMapError(w, err)
 		return
 	}
 	utils.RespondWithJSON(w, http.StatusOK, map[string]string{"status": "node deleted"})
@@ -386,7 +818,7 @@ func (s *Server) DeleteLink(w http.ResponseWriter, r *http.Request) {
 	mapName := parts[2]
 	linkName := parts[4]
 	if err := s.mapService.DeleteLink(mapName, linkName); err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithMapError(w, err)
 		return
 	}
 	utils.RespondWithJSON(w, http.StatusOK, map[string]string{"status": "link deleted"})
@@ -395,7 +827,7 @@ func (s *Server) DeleteLink(w http.ResponseWriter, r *http.Request) {
 func (s *Server) DeleteMap(w http.ResponseWriter, r *http.Request) {
 	mapName := strings.TrimPrefix(r.URL.Path, "/maps/")
 	if err := s.mapService.DeleteMap(mapName); err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithMapError(w, err)
 		return
 	}
 	utils.RespondWithJSON(w, http.StatusOK, map[string]string{"status": "map deleted"})
@@ -404,23 +836,50 @@ func (s *Server) DeleteMap(w http.ResponseWriter, r *http.Request) {
 func (s *Server) AddNodesBulk(w http.ResponseWriter, r *http.Request) {
 	mapName := strings.Split(r.URL.Path, "/")[2]
 	var nodes []config.Node
-	if err := json.NewDecoder(r.Body).Decode(&nodes); err != nil {
-		utils.RespondWithError(w, http.StatusBadRequest, "Invalid JSON")
+	if !decodePayload(w, r, &nodes, payloadListOf(config.PayloadNode)) {
 		return
 	}
 	if err := s.mapService.AddNodesBulk(mapName, nodes); err != nil {
-		if strings.Contains(err.Error(), "already exists") {
-			utils.RespondWithError(w, http.StatusConflict, err.Error())
-		} else if strings.Contains(err.Error(), "out of map bounds") {
-			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
-		} else if strings.Contains(err.Error(), "validation failed") {
-			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		respondWithMapError(w, err)
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, map[string]string{"status": "nodes added in bulk"})
+}
+
+// EditNodesBulk applies a list of {name, updates} objects, with the fields of EditNode, in
+// one save of the map
+func (s *Server) EditNodesBulk(w http.ResponseWriter, r *http.Request) {
+	mapName := strings.Split(r.URL.Path, "/")[2]
+	var updates []service.NodeUpdate
+	if !decodePayload(w, r, &updates, bulkEditOf(config.PayloadNode)) {
+		return
+	}
+	if err := s.mapService.EditNodesBulk(mapName, updates); err != nil {
+		respondWithMapError(w, err)
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, map[string]string{"status": "nodes updated in bulk"})
+}
+
+// EditLinksBulk applies a list of {name, updates} objects, with the fields of EditLink, in
+// one save of the map. Nothing is saved when an item fails, the response lists every failed
+// item with its index in the request.
+func (s *Server) EditLinksBulk(w http.ResponseWriter, r *http.Request) {
+	mapName := strings.Split(r.URL.Path, "/")[2]
+	var updates []service.LinkUpdate
+	if !decodePayload(w, r, &updates, bulkEditOf(config.PayloadLink)) {
+		return
+	}
+	if err := s.mapService.EditLinksBulk(mapName, updates); err != nil {
+		var bulkErr *service.BulkEditError
+		if errors.As(err, &bulkErr) {
+			utils.RespondWithJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error(), "items": bulkErr.Items})
 		} else {
-			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			respondWithMapError(w, err)
 		}
 		return
 	}
-	utils.RespondWithJSON(w, http.StatusOK, map[string]string{"status": "nodes added in bulk"})
+	utils.RespondWithJSON(w, http.StatusOK, map[string]string{"status": "links updated in bulk"})
 }
 
 type DeleteNodesBulkPayload struct {
@@ -431,15 +890,11 @@ func (s *Server) DeleteNodesBulk(w http.ResponseWriter, r *http.Request) {
 	mapName := strings.Split(r.URL.Path, "/")[2]
 	var payload DeleteNodesBulkPayload
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		utils.RespondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		respondWithBodyError(w, err, "Invalid JSON")
 		return
 	}
 	if err := s.mapService.DeleteNodesBulk(mapName, payload.Nodes); err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			utils.RespondWithError(w, http.StatusNotFound, err.Error())
-		} else {
-			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
-		}
+		respondWithMapError(w, err)
 		return
 	}
 	utils.RespondWithJSON(w, http.StatusOK, map[string]string{"status": "nodes deleted in bulk"})
@@ -453,7 +908,7 @@ func (s *Server) GetMapVariables(w http.ResponseWriter, r *http.Request, mapName
 
 	variables, err := s.mapService.GetMapVariables(mapName)
 	if err != nil {
-		if strings.Contains(err.Error(), "map not found") {
+		if errors.Is(err, service.ErrNotFound) {
 			utils.RespondWithError(w, http.StatusNotFound, err.Error())
 		} else {
 			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
@@ -472,16 +927,12 @@ func (s *Server) UpdateMapVariables(w http.ResponseWriter, r *http.Request, mapN
 
 	var variables map[string]string
 	if err := json.NewDecoder(r.Body).Decode(&variables); err != nil {
-		utils.RespondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		respondWithBodyError(w, err, "Invalid JSON")
 		return
 	}
 
 	if err := s.mapService.UpdateMapVariables(mapName, variables); err != nil {
-		if strings.Contains(err.Error(), "map not found") {
-			utils.RespondWithError(w, http.StatusNotFound, err.Error())
-		} else {
-			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
-		}
+		respondWithMapError(w, err)
 		return
 	}
 
@@ -491,18 +942,11 @@ func (s *Server) UpdateMapVariables(w http.ResponseWriter, r *http.Request, mapN
 func (s *Server) AddLinksBulk(w http.ResponseWriter, r *http.Request) {
 	mapName := strings.Split(r.URL.Path, "/")[2]
 	var links []config.Link
-	if err := json.NewDecoder(r.Body).Decode(&links); err != nil {
-		utils.RespondWithError(w, http.StatusBadRequest, "Invalid JSON")
+	if !decodePayload(w, r, &links, payloadListOf(config.PayloadLink)) {
 		return
 	}
 	if err := s.mapService.AddLinksBulk(mapName, links); err != nil {
-		if strings.Contains(err.Error(), "already exists") {
-			utils.RespondWithError(w, http.StatusConflict, err.Error())
-		} else if strings.Contains(err.Error(), "validation failed") {
-			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
-		} else {
-			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
-		}
+		respondWithMapError(w, err)
 		return
 	}
 	utils.RespondWithJSON(w, http.StatusOK, map[string]any{"status": "links added in bulk", "links_count": len(links)})
@@ -512,15 +956,11 @@ func (s *Server) DeleteLinksBulk(w http.ResponseWriter, r *http.Request) {
 	mapName := strings.Split(r.URL.Path, "/")[2]
 	var linkNames []string
 	if err := json.NewDecoder(r.Body).Decode(&linkNames); err != nil {
-		utils.RespondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		respondWithBodyError(w, err, "Invalid JSON")
 		return
 	}
 	if err := s.mapService.DeleteLinksBulk(mapName, linkNames); err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			utils.RespondWithError(w, http.StatusNotFound, err.Error())
-		} else {
-			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
-		}
+		respondWithMapError(w, err)
 		return
 	}
 	utils.RespondWithJSON(w, http.StatusOK, map[string]any{"status": "links deleted in bulk", "deleted_count": len(linkNames)})
@@ -530,6 +970,8 @@ func (s *Server) HandleIcons(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
 		s.ListIcons(w, r)
+	case "POST":
+		s.UploadIcon(w, r)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
@@ -550,9 +992,84 @@ func (s *Server) ListIcons(w http.ResponseWriter, r *http.Request) {
 		utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	utils.RespondWithJSON(w, http.StatusOK, icons)
+	respondWithList(w, r, icons)
 }
 
+// UploadIcon adds the file part of a multipart form to the icons, named after the file unless
+// the form has a name. The display_name, category and tags (comma separated) fields describe
+// it in the icon list, ?replace=true overwrites an icon of the same name. Icons are shared by
+// every map: uploading needs the editor role on all of them, replacing the admin role.
+func (s *Server) UploadIcon(w http.ResponseWriter, r *http.Request) {
+	replace := r.URL.Query().Get("replace") == "true"
+	required := service.RoleEditor
+	if replace {
+		required = service.RoleAdmin
+	}
+	if !s.authorizeAllMaps(w, r, required) {
+		return
+	}
+	if err := r.ParseMultipartForm(s.maxBodySize); err != nil {
+		respondWithBodyError(w, err, "invalid multipart form: "+err.Error())
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "invalid multipart form: no file part")
+		return
+	}
+	defer func() { _ = file.Close() }()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		respondWithBodyError(w, err, err.Error())
+		return
+	}
+
+	name := r.FormValue("name")
+	if name == "" {
+		name = header.Filename
+	}
+	metadata := service.IconMetadata{
+		DisplayName: strings.TrimSpace(r.FormValue("display_name")),
+		Category:    strings.TrimSpace(r.FormValue("category")),
+	}
+	for _, tag := range strings.Split(r.FormValue("tags"), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			metadata.Tags = append(metadata.Tags, tag)
+		}
+	}
+	_, _, err = s.mapService.GetIconFile(name)
+	replaced := err == nil
+	if err := s.mapService.UploadIcon(name, data, metadata, replace); err != nil {
+		switch {
+		case strings.Contains(err.Error(), "already exists"):
+			utils.RespondWithError(w, http.StatusConflict, err.Error())
+		case strings.Contains(err.Error(), "invalid"):
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		default:
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	s.recordIconAudit(r, name, replaced)
+
+	icons, err := s.mapService.ListIcons()
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for _, icon := range icons {
+		if icon.Name == name {
+			utils.RespondWithJSON(w, http.StatusCreated, icon)
+			return
+		}
+	}
+	utils.RespondWithJSON(w, http.StatusCreated, config.IconInfo{Name: name})
+}
+
+// maxIconSize bounds the ?size of GET /icons/{name}, in pixels
+const maxIconSize = 512
+
+// GetIconFile serves an icon, ?size scales a raster icon to fit a square of that many pixels
 func (s *Server) GetIconFile(w http.ResponseWriter, r *http.Request) {
 	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/icons/"), "/")
 	if len(parts) == 0 || parts[0] == "" {
@@ -561,6 +1078,15 @@ func (s *Server) GetIconFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	iconName := parts[0]
+	size := 0
+	if value := r.URL.Query().Get("size"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxIconSize {
+			utils.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("invalid size: must be between 1 and %d", maxIconSize))
+			return
+		}
+		size = parsed
+	}
 	iconData, contentType, err := s.mapService.GetIconFile(iconName)
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
@@ -570,8 +1096,20 @@ func (s *Server) GetIconFile(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	// svg icons scale without loss, they are served as they are
+	if size > 0 && contentType != "image/svg+xml" {
+		if iconData, err = render.ResizeIcon(iconData, size); err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		contentType = "image/png"
+	}
 
 	w.Header().Set("Content-Type", contentType)
 	w.Header().Set("Cache-Control", "public, max-age=2592000") // http browser cache
+	if contentType == "image/svg+xml" {
+		// uploaded svg may carry scripts, opened directly they run in a sandbox without our origin
+		w.Header().Set("Content-Security-Policy", "sandbox")
+	}
 	_, _ = w.Write(iconData)
 }
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-weathermap/internal/parquet"
+	"go-weathermap/internal/service"
+	"go-weathermap/internal/utils"
+)
+
+const (
+	historyFormatCSV     = "csv"
+	historyFormatParquet = "parquet"
+	// defaultHistoryRange is exported when the request has no from
+	defaultHistoryRange = 24 * time.Hour
+	// defaultTrafficRange is graphed when the request has no range
+	defaultTrafficRange = time.Hour
+)
+
+// GetLinkTraffic serves the traffic of a link over the last ?range (default 1h) for a graph,
+// from the recent history in memory when it covers the range
+func (s *Server) GetLinkTraffic(w http.ResponseWriter, r *http.Request, mapName, linkName string) {
+	period := defaultTrafficRange
+	if value := r.URL.Query().Get("range"); value != "" {
+		var err error
+		if period, err = service.ParseHistoryRange(value); err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	traffic, err := s.mapService.RecentLinkTraffic(mapName, linkName, period)
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), "invalid"):
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		case strings.Contains(err.Error(), "not found"), strings.Contains(err.Error(), "not enabled"):
+			utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		default:
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, traffic)
+}
+
+// parseHistoryRange reads ?from and ?to (RFC 3339), to defaults to now and from to a day before to
+func parseHistoryRange(r *http.Request) (from, to time.Time, err error) {
+	to = time.Now()
+	if value := r.URL.Query().Get("to"); value != "" {
+		if to, err = time.Parse(time.RFC3339, value); err != nil {
+			return from, to, fmt.Errorf("invalid to: must be an RFC 3339 time")
+		}
+	}
+	from = to.Add(-defaultHistoryRange)
+	if value := r.URL.Query().Get("from"); value != "" {
+		if from, err = time.Parse(time.RFC3339, value); err != nil {
+			return from, to, fmt.Errorf("invalid from: must be an RFC 3339 time")
+		}
+	}
+	if !from.Before(to) {
+		return from, to, fmt.Errorf("invalid range: from must be before to")
+	}
+	return from, to, nil
+}
+
+// ExportLinkHistory serves the recorded traffic of a link as a file for spreadsheets and
+// data frames, ?format is csv (default) or parquet
+func (s *Server) ExportLinkHistory(w http.ResponseWriter, r *http.Request, mapName, linkName string) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = historyFormatCSV
+	}
+	if format != historyFormatCSV && format != historyFormatParquet {
+		utils.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("unsupported export format: '%s', must be '%s' or '%s'", format, historyFormatCSV, historyFormatParquet))
+		return
+	}
+	from, to, err := parseHistoryRange(r)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	samples, err := s.mapService.LinkHistory(mapName, linkName, from, to, r.URL.Query().Get("resolution"))
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), "not found"), strings.Contains(err.Error(), "not enabled"):
+			utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		case strings.Contains(err.Error(), "invalid"):
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		default:
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s-history.%s"`, mapName, linkName, format))
+	if format == historyFormatParquet {
+		w.Header().Set("Content-Type", "application/vnd.apache.parquet")
+		if _, err := historyTable(samples).WriteTo(w); err != nil {
+			s.logger.Warn("history export write failed", "map", mapName, "link", linkName, "error", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	out := csv.NewWriter(w)
+	_ = out.Write([]string{"time", "link", "in", "out", "utilization", "max_utilization", "samples"})
+	for _, sample := range samples {
+		_ = out.Write([]string{
+			sample.Time.UTC().Format(time.RFC3339),
+			sample.Link,
+			strconv.FormatFloat(sample.In, 'f', -1, 64),
+			strconv.FormatFloat(sample.Out, 'f', -1, 64),
+			strconv.FormatFloat(sample.Utilization, 'f', -1, 64),
+			strconv.FormatFloat(sample.MaxUtilization, 'f', -1, 64),
+			strconv.Itoa(sample.Samples),
+		})
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		s.logger.Warn("history export write failed", "map", mapName, "link", linkName, "error", err)
+	}
+}
+
+// historyTable has the columns of the CSV export
+func historyTable(samples []service.HistorySample) *parquet.Table {
+	times := make([]time.Time, len(samples))
+	links := make([]string, len(samples))
+	in := make([]float64, len(samples))
+	out := make([]float64, len(samples))
+	utilization := make([]float64, len(samples))
+	maxUtilization := make([]float64, len(samples))
+	counts := make([]int64, len(samples))
+	for i, sample := range samples {
+		times[i], links[i] = sample.Time, sample.Link
+		in[i], out[i] = sample.In, sample.Out
+		utilization[i], maxUtilization[i] = sample.Utilization, sample.MaxUtilization
+		counts[i] = int64(sample.Samples)
+	}
+
+	table := &parquet.Table{}
+	table.Timestamp("time", times)
+	table.String("link", links)
+	table.Double("in", in)
+	table.Double("out", out)
+	table.Double("utilization", utilization)
+	table.Double("max_utilization", maxUtilization)
+	table.Int64("samples", counts)
+	return table
+}
@@ -0,0 +1,23 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"go-weathermap/internal/service"
+	"go-weathermap/internal/utils"
+)
+
+// InfoURLStatus reports the last check of the info URLs of a map
+func (s *Server) InfoURLStatus(w http.ResponseWriter, r *http.Request, mapName string) {
+	checks, err := s.mapService.InfoURLStatus(mapName)
+	if err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		} else {
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	respondWithList(w, r, checks)
+}
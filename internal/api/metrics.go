@@ -0,0 +1,319 @@
+package api
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// upper bounds of the request duration histogram in seconds
+var requestDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// static path segments of the routes, any other segment is a name or an id and is
+// replaced in the route label to keep its cardinality bounded
+var routeSegments = map[string]bool{
+	"health": true, "ready": true, "version": true, "auth": true, "whoami": true, "maps": true, "nodes": true, "links": true,
+	"bulk": true, "variables": true, "render.svg": true, "render.png": true, "tiles": true,
+	"snapshot.png": true, "capture": true, "demands": true, "planned": true, "urls": true, "schedules": true, "alerts": true, "export": true,
+	"ws": true, "events": true, "embed": true, "audit": true, "path": true, "simulate": true,
+	"history": true, "icons": true, "schema": true, "map.json": true, "search": true, "templates": true,
+	"datasources": true, "admin": true, "faults": true, "pollers": true, "slow": true,
+	"limits": true, "misconfigurations": true, "lint": true, "reload": true, "runtime": true, "sandbox": true, "cluster": true, "metrics": true, "status": true, "agents": true, "push": true,
+}
+
+// MetricsTokenFromEnv reads WEATHERMAP_METRICS_TOKEN, the static bearer token scrapers
+// present on /metrics instead of an OIDC token
+func MetricsTokenFromEnv() string {
+	return strings.TrimSpace(os.Getenv("WEATHERMAP_METRICS_TOKEN"))
+}
+
+// SetMetricsToken requires the token on /metrics, empty leaves /metrics to the API authentication
+func (s *Server) SetMetricsToken(token string) {
+	s.metricsToken = token
+}
+
+// metricsTokenValid reports whether the request carries the metrics token
+func (s *Server) metricsTokenValid(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && s.metricsToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.metricsToken)) == 1
+}
+
+type requestKey struct {
+	method string
+	route  string
+	code   int
+}
+
+type requestStats struct {
+	count   int64
+	sum     float64
+	buckets []int64 // cumulative, like pollDurationBuckets
+}
+
+// httpMetrics counts the requests served by route and status code
+type httpMetrics struct {
+	mu       sync.Mutex
+	requests map[requestKey]*requestStats
+}
+
+func newHTTPMetrics() *httpMetrics {
+	return &httpMetrics{requests: make(map[requestKey]*requestStats)}
+}
+
+func (m *httpMetrics) observe(method, path string, code int, took time.Duration) {
+	key := requestKey{method: method, route: routeLabel(path), code: code}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats, ok := m.requests[key]
+	if !ok {
+		stats = &requestStats{buckets: make([]int64, len(requestDurationBuckets))}
+		m.requests[key] = stats
+	}
+	stats.count++
+	stats.sum += took.Seconds()
+	for i, bound := range requestDurationBuckets {
+		if took.Seconds() <= bound {
+			stats.buckets[i]++
+		}
+	}
+}
+
+// routeLabel turns a request path into its route, /api/v1/maps/dc1/nodes/core1 is /maps/{name}/nodes/{name}
+func routeLabel(path string) string {
+	path = strings.TrimPrefix(path, APIPrefix)
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i, part := range parts {
+		if part != "" && !routeSegments[part] {
+			parts[i] = "{name}"
+		}
+	}
+	return "/" + strings.Join(parts, "/")
+}
+
+// statusRecorder keeps the status code written by a handler. Streaming handlers need
+// Flush and Hijack of the underlying writer, so they are passed through.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusRecorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection can't be hijacked")
+	}
+	if w.code == 0 {
+		w.code = http.StatusSwitchingProtocols
+	}
+	return hijacker.Hijack()
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Metrics serves the server metrics in the Prometheus text exposition format
+func (s *Server) Metrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	out := &exposition{w: bufio.NewWriter(w)}
+	s.writeHTTPMetrics(out)
+	if s.dataSourceService != nil {
+		s.writePollerMetrics(out)
+	}
+	s.writeLinkMetrics(r.Context(), out)
+	if usage, err := s.mapService.HistoryUsage(); err == nil {
+		out.family("weathermap_history_storage_bytes", "gauge", "Disk space used by a tier of the history.")
+		for _, tier := range usage.Tiers {
+			out.sample("weathermap_history_storage_bytes", float64(tier.Bytes), "tier", tier.Tier)
+		}
+	}
+	_ = out.w.Flush()
+}
+
+func (s *Server) writeHTTPMetrics(out *exposition) {
+	s.httpMetrics.mu.Lock()
+	defer s.httpMetrics.mu.Unlock()
+	keys := slices.SortedFunc(maps.Keys(s.httpMetrics.requests), func(a, b requestKey) int {
+		return cmp.Or(strings.Compare(a.route, b.route), strings.Compare(a.method, b.method), a.code-b.code)
+	})
+
+	out.family("weathermap_http_requests_total", "counter", "HTTP requests served by route and status code.")
+	for _, key := range keys {
+		out.sample("weathermap_http_requests_total", float64(s.httpMetrics.requests[key].count),
+			"method", key.method, "route", key.route, "code", strconv.Itoa(key.code))
+	}
+	out.family("weathermap_http_request_duration_seconds", "histogram", "Time to serve HTTP requests, streams last until the client leaves.")
+	for _, key := range keys {
+		stats := s.httpMetrics.requests[key]
+		labels := []string{"method", key.method, "route", key.route, "code", strconv.Itoa(key.code)}
+		for i, bound := range requestDurationBuckets {
+			out.sample("weathermap_http_request_duration_seconds_bucket", float64(stats.buckets[i]), append(labels, "le", formatFloat(bound))...)
+		}
+		out.sample("weathermap_http_request_duration_seconds_bucket", float64(stats.count), append(labels, "le", "+Inf")...)
+		out.sample("weathermap_http_request_duration_seconds_sum", stats.sum, labels...)
+		out.sample("weathermap_http_request_duration_seconds_count", float64(stats.count), labels...)
+	}
+}
+
+func (s *Server) writePollerMetrics(out *exposition) {
+	polls := s.dataSourceService.DataSourcePolls()
+	out.family("weathermap_datasource_polls_total", "counter", "Polls of a datasource by result.")
+	for _, ds := range polls {
+		out.sample("weathermap_datasource_polls_total", float64(ds.Successes), "datasource", ds.Name, "poller", ds.Poller, "result", "success")
+		out.sample("weathermap_datasource_polls_total", float64(ds.Failures), "datasource", ds.Name, "poller", ds.Poller, "result", "failure")
+	}
+	out.family("weathermap_datasource_last_poll_timestamp_seconds", "gauge", "Time of the last poll of a datasource.")
+	for _, ds := range polls {
+		out.sample("weathermap_datasource_last_poll_timestamp_seconds", unixSeconds(ds.LastPoll), "datasource", ds.Name, "poller", ds.Poller)
+	}
+	out.family("weathermap_datasource_last_success_timestamp_seconds", "gauge", "Time of the last successful poll of a datasource.")
+	for _, ds := range polls {
+		out.sample("weathermap_datasource_last_success_timestamp_seconds", unixSeconds(ds.LastSuccess), "datasource", ds.Name, "poller", ds.Poller)
+	}
+
+	targets := s.dataSourceService.PollStats()
+	out.family("weathermap_poll_duration_seconds", "histogram", "Time to poll a target.")
+	for _, target := range targets {
+		labels := []string{"target", target.Target, "poller", target.Poller}
+		for _, bucket := range target.Histogram {
+			out.sample("weathermap_poll_duration_seconds_bucket", float64(bucket.Count), append(labels, "le", formatFloat(bucket.UpperBoundMs/1000))...)
+		}
+		out.sample("weathermap_poll_duration_seconds_bucket", float64(target.Polls), append(labels, "le", "+Inf")...)
+		out.sample("weathermap_poll_duration_seconds_sum", target.SumSeconds, labels...)
+		out.sample("weathermap_poll_duration_seconds_count", float64(target.Polls), labels...)
+	}
+	out.family("weathermap_poll_interval_seconds", "gauge", "Current poll interval of a target, longer than configured for slow targets.")
+	for _, target := range targets {
+		out.sample("weathermap_poll_interval_seconds", target.CurrentInterval, "target", target.Target, "poller", target.Poller)
+	}
+	out.family("weathermap_poll_consecutive_failures", "gauge", "Failed polls of a target in a row.")
+	for _, target := range targets {
+		out.sample("weathermap_poll_consecutive_failures", float64(target.ConsecutiveFailures), "target", target.Target, "poller", target.Poller)
+	}
+
+	sizes := s.dataSourceService.CacheSizes()
+	out.family("weathermap_metric_cache_entries", "gauge", "Metric values cached by a poller.")
+	for _, pollerType := range slices.Sorted(maps.Keys(sizes)) {
+		out.sample("weathermap_metric_cache_entries", float64(sizes[pollerType]), "poller", pollerType)
+	}
+}
+
+// writeLinkMetrics exports the current utilization of every link of every map
+func (s *Server) writeLinkMetrics(ctx context.Context, out *exposition) {
+	names, err := s.mapService.ListMaps()
+	if err != nil {
+		return
+	}
+	type linkSample struct {
+		mapName, link, status string
+		utilization           float64
+	}
+	var samples []linkSample
+	for _, name := range names {
+		m, err := s.mapService.GetMapWithData(ctx, name, s.dataSourceService)
+		if err != nil {
+			continue
+		}
+		for _, link := range m.LinksData {
+			samples = append(samples, linkSample{name, link.Name, link.Status, link.Utilization})
+		}
+	}
+
+	out.family("weathermap_link_utilization_percent", "gauge", "Utilization of a link in percent of its bandwidth.")
+	for _, sample := range samples {
+		out.sample("weathermap_link_utilization_percent", sample.utilization, "map", sample.mapName, "link", sample.link)
+	}
+	out.family("weathermap_link_up", "gauge", "1 when the link is up, 0 when down, missing while its status is unknown.")
+	for _, sample := range samples {
+		switch sample.status {
+		case "up", "misconfigured":
+			out.sample("weathermap_link_up", 1, "map", sample.mapName, "link", sample.link)
+		case "down":
+			out.sample("weathermap_link_up", 0, "map", sample.mapName, "link", sample.link)
+		}
+	}
+}
+
+// exposition writes metric families in the Prometheus text format
+type exposition struct {
+	w *bufio.Writer
+}
+
+func (e *exposition) family(name, metricType, help string) {
+	fmt.Fprintf(e.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// sample writes one value, labels are name and value pairs
+func (e *exposition) sample(name string, value float64, labels ...string) {
+	io.WriteString(e.w, name)
+	for i := 0; i+1 < len(labels); i += 2 {
+		sep := ","
+		if i == 0 {
+			sep = "{"
+		}
+		fmt.Fprintf(e.w, `%s%s="%s"`, sep, labels[i], labelEscaper.Replace(labels[i+1]))
+	}
+	if len(labels) > 0 {
+		io.WriteString(e.w, "}")
+	}
+	fmt.Fprintf(e.w, " %s\n", formatFloat(value))
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func unixSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.UnixNano()) / 1e9
+}
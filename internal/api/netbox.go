@@ -0,0 +1,27 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"go-weathermap/internal/utils"
+)
+
+// SyncNetBox handles POST /maps/{name}/netbox/sync: returns what syncing the map from NetBox
+// would change, ?apply=true saves it
+func (s *Server) SyncNetBox(w http.ResponseWriter, r *http.Request, mapName string) {
+	apply := r.URL.Query().Get("apply") == "true"
+	result, err := s.mapService.SyncNetBox(r.Context(), mapName, s.dataSourceService, apply)
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), "not found"), strings.Contains(err.Error(), "not enabled"):
+			utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		case strings.Contains(err.Error(), "invalid"), strings.Contains(err.Error(), "validation failed"):
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		default:
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/service"
+	"go-weathermap/internal/utils"
+)
+
+type pathRequest struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	AvoidDown bool   `json:"avoid_down"`
+}
+
+// MapPath returns the cheapest path between two nodes by link cost
+func (s *Server) MapPath(w http.ResponseWriter, r *http.Request, mapName string) {
+	var req pathRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithBodyError(w, err, "Invalid JSON for path")
+		return
+	}
+	if req.From == "" || req.To == "" {
+		utils.RespondWithError(w, http.StatusBadRequest, "from and to are required")
+		return
+	}
+
+	mapWithData, err := s.mapService.GetMapWithData(r.Context(), mapName, s.dataSourceService)
+	if err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		} else {
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	path, err := service.ShortestPath(mapWithData, req.From, req.To, req.AvoidDown)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, path)
+}
+
+// highlightPath sets the path requested by ?path=from,to on a map about to be rendered
+func highlightPath(r *http.Request, m *config.MapWithData) error {
+	value := r.URL.Query().Get("path")
+	if value == "" {
+		return nil
+	}
+	from, to, ok := strings.Cut(value, ",")
+	if !ok || from == "" || to == "" {
+		return fmt.Errorf("invalid path: must be from,to")
+	}
+	avoidDown := r.URL.Query().Get("avoid_down") == "true"
+	path, err := service.ShortestPath(m, from, to, avoidDown)
+	if err != nil {
+		return err
+	}
+	m.Path = path
+	return nil
+}
+
+// SimulateFailure projects link utilization with the given links and nodes failed
+func (s *Server) SimulateFailure(w http.ResponseWriter, r *http.Request, mapName string) {
+	var scenario service.FailureScenario
+	if err := json.NewDecoder(r.Body).Decode(&scenario); err != nil {
+		respondWithBodyError(w, err, "Invalid JSON for simulation")
+		return
+	}
+	if len(scenario.Links) == 0 && len(scenario.Nodes) == 0 {
+		utils.RespondWithError(w, http.StatusBadRequest, "links or nodes to fail are required")
+		return
+	}
+
+	mapWithData, err := s.mapService.GetMapWithData(r.Context(), mapName, s.dataSourceService)
+	if err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		} else {
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	result, err := service.Simulate(mapWithData, scenario)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, result)
+}
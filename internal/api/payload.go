@@ -0,0 +1,138 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/utils"
+)
+
+// decodePayload reads a JSON body, checks it with validate before decoding it into v, so
+// fields of the wrong type or unknown to the server are reported instead of dropped. When it
+// returns false the request was answered: 413, or 400 with the fields in error.
+func decodePayload(w http.ResponseWriter, r *http.Request, v any, validate func(any) error) bool {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondWithBodyError(w, err, "Invalid JSON")
+		return false
+	}
+	var payload any
+	if err := json.Unmarshal(body, &payload); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return false
+	}
+	if err := validate(payload); err != nil {
+		var payloadErr *config.PayloadError
+		if errors.As(err, &payloadErr) {
+			utils.RespondWithJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error(), "fields": payloadErr.Fields})
+		} else {
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		}
+		return false
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return false
+	}
+	return true
+}
+
+// payloadOf validates a map, node or link, partial for the fields of a PATCH
+func payloadOf(kind string, partial bool) func(any) error {
+	return func(payload any) error {
+		return config.ValidatePayload(kind, payload, partial)
+	}
+}
+
+// replacedMap validates the body of PUT /maps/{name}. A map read from GET /maps/{name} is
+// written back with its live data, the fields config.MapWithData adds are ignored.
+func replacedMap(payload any) error {
+	if object, ok := payload.(map[string]any); ok {
+		object = maps.Clone(object)
+		for key := range object {
+			if mapDataFields[strings.ToLower(key)] {
+				delete(object, key)
+			}
+		}
+		payload = object
+	}
+	return config.ValidatePayload(config.PayloadMap, payload, false)
+}
+
+// mapDataFields are the JSON keys of config.MapWithData besides the map
+var mapDataFields = func() map[string]bool {
+	fields := make(map[string]bool)
+	t := reflect.TypeFor[config.MapWithData]()
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if name, _, _ := strings.Cut(field.Tag.Get("json"), ","); !field.Anonymous && name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}()
+
+// payloadListOf validates the list of maps, nodes or links of a bulk add
+func payloadListOf(kind string) func(any) error {
+	return func(payload any) error {
+		return validateItems(payload, func(item any) (string, error) {
+			return "", config.ValidatePayload(kind, item, false)
+		})
+	}
+}
+
+// bulkEditOf validates the {name, updates} objects of a bulk edit, updates are the fields of
+// a PATCH of the kind
+func bulkEditOf(kind string) func(any) error {
+	return func(payload any) error {
+		return validateItems(payload, func(item any) (string, error) {
+			object, ok := item.(map[string]any)
+			if !ok {
+				return "", &config.PayloadError{Fields: []config.FieldError{{Field: "payload", Error: "must be object"}}}
+			}
+			if _, ok := object["name"].(string); !ok {
+				return "", &config.PayloadError{Fields: []config.FieldError{{Field: "name", Error: "must be string"}}}
+			}
+			return "updates.", config.ValidatePayload(kind, object["updates"], true)
+		})
+	}
+}
+
+// validateItems checks every item of a list, the fields in error are prefixed with the index
+// of their item and the prefix returned by validate
+func validateItems(payload any, validate func(any) (string, error)) error {
+	items, ok := payload.([]any)
+	if !ok {
+		return &config.PayloadError{Fields: []config.FieldError{{Field: "payload", Error: "must be array"}}}
+	}
+	var fields []config.FieldError
+	for i, item := range items {
+		prefix, err := validate(item)
+		var payloadErr *config.PayloadError
+		if !errors.As(err, &payloadErr) {
+			continue
+		}
+		for _, field := range payloadErr.Fields {
+			name := prefix + field.Field
+			if field.Field == "payload" {
+				name = strings.TrimSuffix(prefix, ".")
+			}
+			field.Field = fmt.Sprintf("[%d]", i)
+			if name != "" {
+				field.Field += "." + name
+			}
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) > 0 {
+		return &config.PayloadError{Fields: fields}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/service"
+	"go-weathermap/internal/utils"
+)
+
+const plannedOverlay = "planned"
+
+func (s *Server) GetDemands(w http.ResponseWriter, r *http.Request, mapName string) {
+	demands, err := s.mapService.GetDemands(mapName)
+	if err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		} else {
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, demands)
+}
+
+// UpdateDemands replaces the traffic matrix of the map
+func (s *Server) UpdateDemands(w http.ResponseWriter, r *http.Request, mapName string) {
+	var demands []config.Demand
+	if err := json.NewDecoder(r.Body).Decode(&demands); err != nil {
+		respondWithBodyError(w, err, "Invalid JSON for demands")
+		return
+	}
+
+	if err := s.mapService.UpdateDemands(mapName, demands); err != nil {
+		switch {
+		case errors.Is(err, service.ErrNotFound):
+			utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		case strings.Contains(err.Error(), "validation failed"):
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		default:
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, map[string]any{"status": "demands updated", "count": len(demands)})
+}
+
+// GetPlannedLoad returns the per link load of the map traffic matrix
+func (s *Server) GetPlannedLoad(w http.ResponseWriter, r *http.Request, mapName string) {
+	plan, err := s.mapService.GetPlannedLoad(mapName)
+	if err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		} else {
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, plan)
+}
+
+// applyOverlay keeps the planned load on a map about to be rendered only with ?overlay=planned
+func applyOverlay(r *http.Request, m *config.MapWithData) error {
+	switch overlay := r.URL.Query().Get("overlay"); overlay {
+	case plannedOverlay:
+		return nil
+	case "":
+		m.PlannedData = nil
+		return nil
+	default:
+		return fmt.Errorf("invalid overlay: '%s', must be '%s'", overlay, plannedOverlay)
+	}
+}
@@ -1,11 +1,45 @@
 package api
 
-import "net/http"
+import (
+	"net/http"
+
+	"go-weathermap/internal/config"
+)
 
 func (s *Server) routes() {
 	s.router.HandleFunc("/health", s.Health)
-	s.router.Handle("/maps", limitRequestBody(http.HandlerFunc(s.HandleMaps)))
-	s.router.Handle("/maps/", limitRequestBody(http.HandlerFunc(s.HandleMapOperations)))
-	s.router.HandleFunc("/icons", s.HandleIcons)
-	s.router.HandleFunc("/icons/", s.HandleIconFile)
+	s.router.HandleFunc("/ready", s.Ready)
+	s.router.HandleFunc("/version", s.GetVersion)
+	s.router.HandleFunc("/metrics", s.Metrics)
+	s.router.HandleFunc("/auth/whoami", s.WhoAmI)
+	s.router.Handle("/maps", compressed(s.limitRequestBody(s.audited(http.HandlerFunc(s.HandleMaps)))))
+	s.router.Handle("/maps/", compressed(s.limitRequestBody(s.audited(http.HandlerFunc(s.HandleMapOperations)))))
+	s.router.Handle(importPath, compressed(s.limitRequestBody(s.audited(http.HandlerFunc(s.ImportMap)))))
+	s.router.Handle("/sandbox/", compressed(http.HandlerFunc(s.HandleSandbox)))
+	s.router.Handle("/audit", compressed(http.HandlerFunc(s.GetAuditLog)))
+	s.router.Handle("/icons", s.limitRequestBody(http.HandlerFunc(s.HandleIcons)))
+	s.router.Handle("/icons/", compressed(http.HandlerFunc(s.HandleIconFile)))
+	s.router.Handle(config.MapSchemaURL, compressed(http.HandlerFunc(s.MapSchema)))
+	s.router.Handle(config.PayloadSchemaPrefix, compressed(http.HandlerFunc(s.PayloadSchema)))
+	s.router.Handle("/search", compressed(http.HandlerFunc(s.Search)))
+	s.router.HandleFunc("/templates", s.HandleTemplates)
+	s.router.HandleFunc("/templates/", s.HandleTemplates)
+	s.router.HandleFunc("/datasources", s.HandleDataSources)
+	s.router.HandleFunc("/datasources/", s.HandleDataSources)
+	s.router.Handle("/admin/faults", s.limitRequestBody(http.HandlerFunc(s.HandleFaults)))
+	s.router.Handle("/admin/faults/", s.limitRequestBody(http.HandlerFunc(s.HandleFaults)))
+	s.router.HandleFunc("/admin/pollers/", s.HandlePollerStats)
+	s.router.HandleFunc("/admin/limits", s.GetResourceLimits)
+	s.router.HandleFunc("/admin/misconfigurations", s.GetMisconfigurations)
+	s.router.HandleFunc("/admin/lint", s.GetLint)
+	s.router.HandleFunc("/admin/history", s.GetHistoryUsage)
+	s.router.HandleFunc("/admin/reload", s.ReloadConfig)
+	s.router.HandleFunc("/cluster/metrics", s.ClusterMetrics)
+	s.router.HandleFunc("/cluster/status", s.ClusterStatus)
+	s.router.HandleFunc("/agents", s.ListAgents)
+	s.router.Handle("/agents/push", s.limitRequestBody(http.HandlerFunc(s.HandleAgentPush)))
+
+	// every route above is also served under /api/v1 with enveloped responses,
+	// unprefixed paths are kept as aliases for existing clients
+	s.router.Handle(APIPrefix+"/", compressed(apiV1(s.router)))
 }
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"go-weathermap/internal/auth"
+	"go-weathermap/internal/service"
+	"go-weathermap/internal/utils"
+)
+
+// SandboxKeyHeader names the sandbox namespace of callers without a token
+const SandboxKeyHeader = "X-Sandbox-Key"
+
+// sandboxServers serves the maps API of every sandbox namespace, created on first use
+type sandboxServers struct {
+	mu      sync.Mutex
+	servers map[string]*Server
+}
+
+// EnableSandbox serves /sandbox/maps, a copy of the maps API for testing payloads. Every
+// API client gets its own namespace, where any write is allowed and maps expire after a day.
+func (s *Server) EnableSandbox() {
+	s.sandboxes = &sandboxServers{servers: make(map[string]*Server)}
+	s.mapService.OnSandboxExpired(s.sandboxes.forget)
+}
+
+// forget drops the servers of an expired namespace
+func (sb *sandboxServers) forget(mapService *service.MapService) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	for key, sandbox := range sb.servers {
+		if sandbox.mapService == mapService {
+			delete(sb.servers, key)
+		}
+	}
+}
+
+// HandleSandbox passes /sandbox/maps/... to the server of the caller's namespace: the
+// subject of the token, or the X-Sandbox-Key header without authentication
+func (s *Server) HandleSandbox(w http.ResponseWriter, r *http.Request) {
+	if s.sandboxes == nil {
+		utils.RespondWithError(w, http.StatusNotFound, "sandbox is not enabled")
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/sandbox")
+	if path != "/maps" && !strings.HasPrefix(path, "/maps/") {
+		http.NotFound(w, r)
+		return
+	}
+	key := strings.TrimSpace(r.Header.Get(SandboxKeyHeader))
+	if claims, ok := auth.ClaimsFromContext(r.Context()); ok {
+		key = "sub:" + claims.Subject()
+	}
+	if key == "" {
+		utils.RespondWithError(w, http.StatusBadRequest, "sandbox key is required: set the "+SandboxKeyHeader+" header")
+		return
+	}
+
+	sandbox, err := s.sandboxServer(key)
+	if err != nil {
+		if errors.Is(err, service.ErrLimit) {
+			utils.RespondWithError(w, http.StatusTooManyRequests, err.Error())
+		} else {
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	w.Header().Set("X-Sandbox-TTL", service.SandboxTTL.String())
+	r = r.Clone(r.Context())
+	r.URL.Path = path
+	r.URL.RawPath = ""
+	sandbox.router.ServeHTTP(w, r)
+}
+
+func (s *Server) sandboxServer(key string) (*Server, error) {
+	mapService, err := s.mapService.Sandbox(key)
+	if err != nil {
+		return nil, err
+	}
+	s.sandboxes.mu.Lock()
+	defer s.sandboxes.mu.Unlock()
+	if sandbox, ok := s.sandboxes.servers[key]; ok && sandbox.mapService == mapService {
+		return sandbox, nil
+	}
+	// sandbox maps have no datasources to poll, their links are unknown
+	sandbox := NewServer(mapService, nil)
+	sandbox.logger = s.logger
+	sandbox.closing = s.closing
+	sandbox.maxBodySize = s.maxBodySize
+	sandbox.maxBulkBodySize = s.maxBulkBodySize
+	s.sandboxes.servers[key] = sandbox
+	return sandbox, nil
+}
@@ -0,0 +1,23 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"go-weathermap/internal/service"
+	"go-weathermap/internal/utils"
+)
+
+// GetSchedules reports whether the schedules of a map are active now
+func (s *Server) GetSchedules(w http.ResponseWriter, r *http.Request, mapName string) {
+	states, err := s.mapService.ScheduleStatus(r.Context(), mapName)
+	if err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		} else {
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	respondWithList(w, r, states)
+}
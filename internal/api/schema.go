@@ -0,0 +1,46 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/utils"
+)
+
+// MapSchema serves the JSON Schema of map documents. It is written as is, without
+// the /api/v1 envelope, so editors can use the URL directly.
+func (s *Server) MapSchema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeSchema(w, config.MapSchema())
+}
+
+// PayloadSchema serves /schema/payloads/{map,node,link}.json, the JSON Schema of the bodies
+// creating or editing maps, nodes and links, unwrapped like MapSchema
+func (s *Server) PayloadSchema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	kind, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, config.PayloadSchemaPrefix), ".json")
+	schema := config.PayloadSchema(kind)
+	if !ok || schema == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeSchema(w, schema)
+}
+
+func writeSchema(w http.ResponseWriter, schema map[string]any) {
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"net/http"
+	"net/netip"
+
+	"go-weathermap/internal/utils"
+)
+
+// Search resolves which nodes and links of all maps own the address given by ?ip
+func (s *Server) Search(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	value := r.URL.Query().Get("ip")
+	if value == "" {
+		utils.RespondWithError(w, http.StatusBadRequest, "ip is required")
+		return
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "invalid ip: "+err.Error())
+		return
+	}
+
+	matches, err := s.mapService.SearchAddress(addr)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	visible := matches[:0]
+	for _, match := range matches {
+		ok, err := s.canView(r, match.Map)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if ok {
+			visible = append(visible, match)
+		}
+	}
+	respondWithList(w, r, visible)
+}
@@ -1,48 +1,295 @@
 package api
 
 import (
+	"cmp"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"go-weathermap/internal/auth"
+	"go-weathermap/internal/render"
 	"go-weathermap/internal/service"
+	"go-weathermap/internal/tracing"
 	"go-weathermap/internal/utils"
 )
 
-const maxRequestBodySize = 1048576
+const (
+	// DefaultMaxBodySize bounds request bodies of map edits, fault simulations and agent pushes
+	DefaultMaxBodySize = 1048576
+	// DefaultMaxBulkBodySize bounds request bodies of bulk edits and whole maps, which grow
+	// with the number of nodes and links
+	DefaultMaxBulkBodySize = 16 << 20
+	// ShutdownTimeout bounds how long in-flight requests and polls may take after SIGTERM
+	ShutdownTimeout = 15 * time.Second
+)
+
+// Timeouts of the HTTP server, 0 disables one. Event streams and websockets aren't bound
+// by Write, they are ended on shutdown.
+type Timeouts struct {
+	ReadHeader time.Duration
+	Read       time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+}
+
+var DefaultTimeouts = Timeouts{
+	ReadHeader: 10 * time.Second,
+	Read:       30 * time.Second,
+	Write:      60 * time.Second,
+	Idle:       120 * time.Second,
+}
+
+// TimeoutsFromEnv reads WEATHERMAP_SERVER_READ_HEADER_TIMEOUT, WEATHERMAP_SERVER_READ_TIMEOUT,
+// WEATHERMAP_SERVER_WRITE_TIMEOUT and WEATHERMAP_SERVER_IDLE_TIMEOUT over DefaultTimeouts
+func TimeoutsFromEnv() (Timeouts, error) {
+	timeouts := DefaultTimeouts
+	for variable, timeout := range map[string]*time.Duration{
+		"WEATHERMAP_SERVER_READ_HEADER_TIMEOUT": &timeouts.ReadHeader,
+		"WEATHERMAP_SERVER_READ_TIMEOUT":        &timeouts.Read,
+		"WEATHERMAP_SERVER_WRITE_TIMEOUT":       &timeouts.Write,
+		"WEATHERMAP_SERVER_IDLE_TIMEOUT":        &timeouts.Idle,
+	} {
+		value := strings.TrimSpace(os.Getenv(variable))
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return timeouts, fmt.Errorf("invalid %s: %s", variable, value)
+		}
+		*timeout = d
+	}
+	return timeouts, nil
+}
 
 type Server struct {
 	mapService        *service.MapService
 	dataSourceService *service.DataSourceService
+	svgRenderer       *render.SVGRenderer
+	pngRenderer       *render.PNGRenderer
+	pdfRenderer       *render.PDFRenderer
+	agentTokens       map[string]string // agent name -> push token
+	verifier          *auth.Verifier    // bearer token checks, nil without OIDC
+	embedOrigins      []string          // portals allowed to frame the embed widget
+	metricsToken      string            // static bearer token of Prometheus scrapers
+	sandboxes         *sandboxServers   // nil until EnableSandbox
+	faultsEnabled     bool              // /admin/faults is served, see EnableFaults
+	tls               *tlsReloader      // nil serves plain HTTP
+	reloadMu          sync.Mutex        // serializes POST /admin/reload
+	timeouts          Timeouts
+	maxBodySize       int64
+	maxBulkBodySize   int64
+	httpMetrics       *httpMetrics
+	logger            *slog.Logger
 	router            *http.ServeMux
+	closing           chan struct{} // closed on shutdown, ends websocket and event streams
+	closeOnce         sync.Once
+	auditMu           sync.Mutex  // serializes audited map changes
+	ready             atomic.Bool // false while the maps warm up after start
 }
 
 func NewServer(mapService *service.MapService, dsService *service.DataSourceService) *Server {
 	s := &Server{
 		mapService:        mapService,
 		dataSourceService: dsService,
+		svgRenderer:       render.NewSVGRenderer(mapService.GetIconFile),
+		pngRenderer:       render.NewPNGRenderer(mapService.GetIconFile),
+		pdfRenderer:       render.NewPDFRenderer(mapService.GetIconFile),
 		router:            http.NewServeMux(),
+		closing:           make(chan struct{}),
+		httpMetrics:       newHTTPMetrics(),
+		timeouts:          DefaultTimeouts,
+		maxBodySize:       DefaultMaxBodySize,
+		maxBulkBodySize:   DefaultMaxBulkBodySize,
+		logger:            slog.Default(),
 	}
+	s.ready.Store(true)
 	s.routes()
 	return s
 }
 
+// SetLogger replaces the logger of the server, including errors of the HTTP server itself
+func (s *Server) SetLogger(logger *slog.Logger) {
+	s.logger = logger
+}
+
+// MaxBodySizeFromEnv reads WEATHERMAP_MAX_BODY_SIZE in bytes, DefaultMaxBodySize when unset
+func MaxBodySizeFromEnv() (int64, error) {
+	return bodySizeFromEnv("WEATHERMAP_MAX_BODY_SIZE", DefaultMaxBodySize)
+}
+
+// MaxBulkBodySizeFromEnv reads WEATHERMAP_MAX_BULK_BODY_SIZE in bytes, DefaultMaxBulkBodySize
+// when unset
+func MaxBulkBodySizeFromEnv() (int64, error) {
+	return bodySizeFromEnv("WEATHERMAP_MAX_BULK_BODY_SIZE", DefaultMaxBulkBodySize)
+}
+
+func bodySizeFromEnv(variable string, def int64) (int64, error) {
+	value := strings.TrimSpace(os.Getenv(variable))
+	if value == "" {
+		return def, nil
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("invalid %s: %s", variable, value)
+	}
+	return size, nil
+}
+
+// SetMaxBodySize replaces DefaultMaxBodySize
+func (s *Server) SetMaxBodySize(size int64) {
+	s.maxBodySize = size
+}
+
+// SetMaxBulkBodySize replaces DefaultMaxBulkBodySize
+func (s *Server) SetMaxBulkBodySize(size int64) {
+	s.maxBulkBodySize = size
+}
+
+// SetTimeouts replaces DefaultTimeouts, it applies from the next Start
+func (s *Server) SetTimeouts(timeouts Timeouts) {
+	s.timeouts = timeouts
+}
+
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	s.router.ServeHTTP(w, r)
+	started, method, path := time.Now(), r.Method, r.URL.Path
+	recorder := &statusRecorder{ResponseWriter: w}
+	ctx, span := tracing.StartServer(r, method+" "+routeLabel(path), "http.request.method", method, "url.path", path)
+	r = r.WithContext(ctx)
+	defer func() {
+		code := cmp.Or(recorder.code, http.StatusOK)
+		s.httpMetrics.observe(method, path, code, time.Since(started))
+		span.SetAttributes("http.response.status_code", code)
+		if code >= http.StatusInternalServerError {
+			span.RecordError(errors.New(http.StatusText(code)))
+		}
+		span.End()
+	}()
+
+	if strings.TrimPrefix(r.URL.Path, APIPrefix) == "/metrics" && s.metricsToken != "" {
+		// scrapers use the static token, OIDC tokens expire
+		if !s.metricsTokenValid(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer`)
+			utils.RespondWithError(recorder, http.StatusUnauthorized, "metrics token is required")
+			return
+		}
+	} else if s.verifier != nil && !publicPath(r.URL.Path) {
+		var ok bool
+		if r, ok = s.authenticate(recorder, r); !ok {
+			return
+		}
+	}
+	s.router.ServeHTTP(recorder, r)
 }
 
 func (s *Server) Health(w http.ResponseWriter, r *http.Request) {
 	utils.RespondWithJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
-func (s *Server) Start(addr string) {
-	fmt.Printf("Starting weathermap server on %s\n", addr)
-	log.Fatal(http.ListenAndServe(addr, s))
+// SetReady marks whether the server is ready for traffic, NewServer starts ready
+func (s *Server) SetReady(ready bool) {
+	s.ready.Store(ready)
+}
+
+// Ready answers 503 until the maps warmed up, for readiness probes of load balancers
+func (s *Server) Ready(w http.ResponseWriter, r *http.Request) {
+	if !s.ready.Load() {
+		utils.RespondWithJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "warming up"})
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, map[string]string{"status": "ready"})
 }
 
-func limitRequestBody(next http.Handler) http.Handler {
+// Start serves until ctx is done, then stops accepting connections and waits
+// up to ShutdownTimeout for in-flight requests. Long-lived streams are closed.
+func (s *Server) Start(ctx context.Context, addr string) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           s,
+		ReadHeaderTimeout: s.timeouts.ReadHeader,
+		ReadTimeout:       s.timeouts.Read,
+		WriteTimeout:      s.timeouts.Write,
+		IdleTimeout:       s.timeouts.Idle,
+		ErrorLog:          slog.NewLogLogger(s.logger.Handler(), slog.LevelWarn),
+	}
+	srv.RegisterOnShutdown(s.closeStreams)
+
+	errCh := make(chan error, 1)
+	go func() {
+		s.logger.Info("starting weathermap server", "addr", addr, "tls", s.tls != nil)
+		if s.tls != nil {
+			srv.TLSConfig = s.tls.serverConfig()
+			errCh <- srv.ListenAndServeTLS("", "")
+			return
+		}
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	s.logger.Info("shutting down weathermap server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutdown: %w", err)
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// closeStreams ends websocket and event stream handlers, the http server doesn't wait for them
+func (s *Server) closeStreams() {
+	s.closeOnce.Do(func() { close(s.closing) })
+}
+
+// limitRequestBody bounds the body of requests by the limit of their route, see bodyLimit.
+// Handlers report bodies over it with respondWithBodyError.
+func (s *Server) limitRequestBody(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+		r.Body = http.MaxBytesReader(w, r.Body, s.bodyLimit(r))
 		next.ServeHTTP(w, r)
 	})
 }
+
+// bodyLimit is maxBulkBodySize for the bulk endpoints and the requests writing a whole map,
+// maxBodySize for the others
+func (s *Server) bodyLimit(r *http.Request) int64 {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch {
+	case strings.HasSuffix(path, "/bulk"):
+		return s.maxBulkBodySize
+	case r.Method == "POST" && (path == "/maps" || path == importPath || strings.HasSuffix(path, "/bundle")):
+		return s.maxBulkBodySize
+	case r.Method == "PUT" && strings.Count(path, "/") == 2 && strings.HasPrefix(path, "/maps/"):
+		return s.maxBulkBodySize
+	}
+	return s.maxBodySize
+}
+
+// respondWithBodyError answers 413 with the limit when the request body went over it, else
+// 400 with message, for bodies that failed to decode
+func respondWithBodyError(w http.ResponseWriter, err error, message string) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		utils.RespondWithJSON(w, http.StatusRequestEntityTooLarge, map[string]any{
+			"error": fmt.Sprintf("request body too large: limit is %d bytes", tooLarge.Limit),
+			"limit": tooLarge.Limit,
+		})
+		return
+	}
+	utils.RespondWithError(w, http.StatusBadRequest, message)
+}
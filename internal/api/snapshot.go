@@ -0,0 +1,73 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go-weathermap/internal/render"
+	"go-weathermap/internal/service"
+	"go-weathermap/internal/utils"
+)
+
+const maxSnapshotPadding = 1000
+
+// LinkSnapshot renders the map cropped around one link, small enough to be attached
+// to notifications and still show the neighbourhood of the link
+func (s *Server) LinkSnapshot(w http.ResponseWriter, r *http.Request, mapName, linkName string) {
+	padding := render.DefaultSnapshotPadding
+	if value := r.URL.Query().Get("padding"); value != "" {
+		p, err := strconv.Atoi(value)
+		if err != nil || p < 0 || p > maxSnapshotPadding {
+			utils.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("invalid padding: must be between 0 and %d", maxSnapshotPadding))
+			return
+		}
+		padding = p
+	}
+	width, err := parseImageDimension(r.URL.Query().Get("width"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "invalid width: "+err.Error())
+		return
+	}
+	height, err := parseImageDimension(r.URL.Query().Get("height"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "invalid height: "+err.Error())
+		return
+	}
+
+	mapWithData, err := s.mapService.GetMapWithData(r.Context(), mapName, s.dataSourceService)
+	if err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		} else {
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	if err := applyAccessible(r, mapWithData); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	region, err := render.LinkRegion(mapWithData.Map, linkName, padding)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := s.pngRenderer.RenderRegion(&buf, mapWithData, region, width, height); err != nil {
+		if strings.Contains(err.Error(), "exceeds limit") || strings.Contains(err.Error(), "outside of the map") {
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		} else {
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write(buf.Bytes())
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"time"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/service"
+	"go-weathermap/internal/utils"
+)
+
+// pollers refresh key by key, pushes are delayed so one carries the whole cycle
+const minPushPeriod = time.Second
+
+type LinksUpdateMessage struct {
+	Type        string            `json:"type"`
+	Map         string            `json:"map"`
+	ProcessedAt time.Time         `json:"processed_at"`
+	LinksData   []config.LinkData `json:"links_data"`
+}
+
+func (s *Server) MapWebSocket(w http.ResponseWriter, r *http.Request, mapName string) {
+	if s.dataSourceService == nil {
+		utils.RespondWithError(w, http.StatusServiceUnavailable, "datasource service is not running")
+		return
+	}
+	if _, err := s.mapService.GetMap(mapName); err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		} else {
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	updates, unsubscribe := s.dataSourceService.SubscribeUpdates()
+	defer unsubscribe()
+
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer ws.Close(wsCloseNormal)
+
+	var lastSent []config.LinkData
+	push := func() error {
+		mapWithData, err := s.mapService.GetMapWithData(r.Context(), mapName, s.dataSourceService)
+		if err != nil {
+			return err
+		}
+		if lastSent != nil && reflect.DeepEqual(lastSent, mapWithData.LinksData) {
+			return nil
+		}
+		message, err := json.Marshal(LinksUpdateMessage{
+			Type:        "links",
+			Map:         mapName,
+			ProcessedAt: mapWithData.ProcessedAt,
+			LinksData:   mapWithData.LinksData,
+		})
+		if err != nil {
+			return err
+		}
+		lastSent = mapWithData.LinksData
+		return ws.WriteText(message)
+	}
+
+	if err := push(); err != nil {
+		s.logger.Warn("websocket closed", "map", mapName, "error", err)
+		return
+	}
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	lastPush := time.Now()
+	for {
+		select {
+		case <-ws.Closed():
+			return
+		case <-s.closing:
+			ws.Close(wsCloseGoingAway)
+			return
+		case <-ping.C:
+			if err := ws.Ping(); err != nil {
+				return
+			}
+		case <-updates:
+			if wait := minPushPeriod - time.Since(lastPush); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ws.Closed():
+					return
+				}
+			}
+			lastPush = time.Now()
+			if err := push(); err != nil {
+				s.logger.Warn("websocket closed", "map", mapName, "error", err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"go-weathermap/internal/service"
+	"go-weathermap/internal/utils"
+)
+
+type templateMapRequest struct {
+	Name       string            `json:"name"`
+	Parameters map[string]string `json:"parameters"`
+}
+
+func (s *Server) HandleTemplates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/templates"), "/")
+	if name == "" {
+		templates, err := s.mapService.ListTemplates()
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondWithList(w, r, templates)
+		return
+	}
+	tmpl, err := s.mapService.GetTemplate(name)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		} else {
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, tmpl)
+}
+
+// CreateMapFromTemplate handles POST /maps?template=name
+func (s *Server) CreateMapFromTemplate(w http.ResponseWriter, r *http.Request, templateName string) {
+	var req templateMapRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithBodyError(w, err, "Invalid JSON")
+		return
+	}
+
+	// maps created without a name are named after the rendered title, the role is checked on that name
+	name := req.Name
+	if name == "" {
+		if tmpl, err := s.mapService.RenderTemplate(templateName, req.Parameters); err == nil {
+			name = service.MapNameFromTitle(tmpl.Title)
+		}
+	}
+	if name != "" && !s.authorizeMap(w, r, name, service.RoleEditor) {
+		return
+	}
+
+	mapName, err := s.mapService.CreateMapFromTemplate(templateName, req.Name, req.Parameters)
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), "template not found"):
+			utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		case strings.Contains(err.Error(), "already exists"):
+			utils.RespondWithError(w, http.StatusConflict, err.Error())
+		case strings.Contains(err.Error(), "invalid"), strings.Contains(err.Error(), "validation failed"),
+			strings.Contains(err.Error(), "required"), strings.Contains(err.Error(), "greater than 0"):
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		default:
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusCreated, map[string]string{
+		"status":   "map created",
+		"name":     mapName,
+		"template": templateName,
+	})
+}
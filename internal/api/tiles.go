@@ -0,0 +1,64 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go-weathermap/internal/render"
+	"go-weathermap/internal/service"
+	"go-weathermap/internal/utils"
+)
+
+// RenderMapTile serves /maps/{name}/tiles/{z}/{x}/{y}.png for slippy map frontends
+func (s *Server) RenderMapTile(w http.ResponseWriter, r *http.Request, mapName, zPart, xPart, yPart string) {
+	yPart, ok := strings.CutSuffix(yPart, ".png")
+	z, errZ := strconv.Atoi(zPart)
+	x, errX := strconv.Atoi(xPart)
+	y, errY := strconv.Atoi(yPart)
+	if !ok || errZ != nil || errX != nil || errY != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "invalid tile: must be /tiles/{z}/{x}/{y}.png")
+		return
+	}
+
+	mapWithData, err := s.mapService.GetMapWithData(r.Context(), mapName, s.dataSourceService)
+	if err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		} else {
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	if err := applyOverlay(r, mapWithData); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := applyAccessible(r, mapWithData); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if mapWithData, err = applyClustering(r, mapWithData, render.TileScale(mapWithData.Map, z)); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := s.pngRenderer.RenderTile(&buf, mapWithData, z, x, y); err != nil {
+		switch {
+		case strings.Contains(err.Error(), "outside of the map"):
+			utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		case strings.Contains(err.Error(), "invalid zoom"), strings.Contains(err.Error(), "exceeds limit"):
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		default:
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write(buf.Bytes())
+}
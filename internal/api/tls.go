@@ -0,0 +1,144 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	ClientAuthRequire  = "require"
+	ClientAuthOptional = "optional"
+)
+
+// TLSConfig of the HTTPS listener, ClientCAFile enables client certificate (mTLS) verification
+type TLSConfig struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	ClientAuth   string // require (default) or optional, with a client CA
+}
+
+// TLSConfigFromEnv reads WEATHERMAP_TLS_CERT_FILE and WEATHERMAP_TLS_KEY_FILE, TLS is enabled
+// when both are set. WEATHERMAP_TLS_CLIENT_CA_FILE verifies client certificates, which are
+// required unless WEATHERMAP_TLS_CLIENT_AUTH is optional.
+func TLSConfigFromEnv() (TLSConfig, bool, error) {
+	cfg := TLSConfig{
+		CertFile:     strings.TrimSpace(os.Getenv("WEATHERMAP_TLS_CERT_FILE")),
+		KeyFile:      strings.TrimSpace(os.Getenv("WEATHERMAP_TLS_KEY_FILE")),
+		ClientCAFile: strings.TrimSpace(os.Getenv("WEATHERMAP_TLS_CLIENT_CA_FILE")),
+		ClientAuth:   strings.ToLower(strings.TrimSpace(os.Getenv("WEATHERMAP_TLS_CLIENT_AUTH"))),
+	}
+	if cfg.CertFile == "" && cfg.KeyFile == "" && cfg.ClientCAFile == "" {
+		return cfg, false, nil
+	}
+	if cfg.ClientAuth == "" {
+		cfg.ClientAuth = ClientAuthRequire
+	}
+	return cfg, true, cfg.Validate()
+}
+
+func (c TLSConfig) Validate() error {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return fmt.Errorf("both a certificate and a key file are required for TLS")
+	}
+	if c.ClientAuth != ClientAuthRequire && c.ClientAuth != ClientAuthOptional {
+		return fmt.Errorf("invalid client auth: '%s', must be %s or %s", c.ClientAuth, ClientAuthRequire, ClientAuthOptional)
+	}
+	return nil
+}
+
+// tlsReloader serves the certificate and client CAs loaded last, so they can be replaced
+// without restarting
+type tlsReloader struct {
+	cfg     TLSConfig
+	mu      sync.RWMutex
+	current *tls.Config
+}
+
+func (t *tlsReloader) load() error {
+	config, err := t.read()
+	if err != nil {
+		return err
+	}
+	t.swap(config)
+	return nil
+}
+
+// read loads the certificate, key and client CAs without serving them yet
+func (t *tlsReloader) read() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(t.cfg.CertFile, t.cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if t.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(t.cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", t.cfg.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		if t.cfg.ClientAuth == ClientAuthOptional {
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	return config, nil
+}
+
+func (t *tlsReloader) swap(config *tls.Config) {
+	t.mu.Lock()
+	t.current = config
+	t.mu.Unlock()
+}
+
+// serverConfig hands every handshake the config loaded last
+func (t *tlsReloader) serverConfig() *tls.Config {
+	current := func() *tls.Config {
+		t.mu.RLock()
+		defer t.mu.RUnlock()
+		return t.current
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &current().Certificates[0], nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return current(), nil
+		},
+	}
+}
+
+// EnableTLS makes Start serve HTTPS with the certificate of cfg
+func (s *Server) EnableTLS(cfg TLSConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	reloader := &tlsReloader{cfg: cfg}
+	if err := reloader.load(); err != nil {
+		return err
+	}
+	s.tls = reloader
+	return nil
+}
+
+// ReloadTLS reads the certificate, key and client CAs again, new connections use them.
+// On error the previous ones are kept.
+func (s *Server) ReloadTLS() error {
+	if s.tls == nil {
+		return fmt.Errorf("tls is not enabled")
+	}
+	return s.tls.load()
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"net/http"
+
+	"go-weathermap/internal/utils"
+	"go-weathermap/internal/version"
+)
+
+// VersionInfo is the build of the server and the features it runs with, so clients can hide
+// what the server doesn't offer
+type VersionInfo struct {
+	version.Info
+	APIVersions []string        `json:"api_versions"` // prefixes of enveloped routes, next to the bare ones
+	Features    map[string]bool `json:"features"`
+}
+
+// GetVersion reports the build and the enabled features of the server
+func (s *Server) GetVersion(w http.ResponseWriter, r *http.Request) {
+	utils.RespondWithJSON(w, http.StatusOK, VersionInfo{
+		Info:        version.Get(),
+		APIVersions: []string{"v1"},
+		Features:    s.features(),
+	})
+}
+
+func (s *Server) features() map[string]bool {
+	return map[string]bool{
+		"rendering":      true, // SVG, PNG, PDF and tiles need nothing outside the binary
+		"history":        s.mapService.HistoryEnabled(),
+		"alerting":       s.mapService.AlertsEnabled(),
+		"node_status":    s.mapService.NodeStatusEnabled(),
+		"sandbox":        s.sandboxes != nil,
+		"authentication": s.verifier != nil,
+		"tls":            s.tls != nil,
+		"sharding":       s.dataSourceService != nil && s.dataSourceService.ClusterEnabled(),
+	}
+}
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Minimal RFC 6455 server side: enough to push JSON text frames to browsers
+// and answer ping/close. Incoming data frames are read and ignored.
+
+const (
+	wsGUID           = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsOpText         = 0x1
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+	wsMaxFrameSize   = 64 * 1024
+	wsWriteTimeout   = 10 * time.Second
+	wsPingInterval   = 30 * time.Second
+	wsCloseNormal    = 1000
+	wsCloseGoingAway = 1001
+	wsCloseTooBig    = 1009
+	wsCloseProtoErr  = 1002
+	wsSupportVersion = "13"
+)
+
+type wsConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	mu     sync.Mutex // serializes writes
+	closed chan struct{}
+	once   sync.Once
+}
+
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		return nil, fmt.Errorf("not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != wsSupportVersion {
+		w.Header().Set("Sec-WebSocket-Version", wsSupportVersion)
+		return nil, fmt.Errorf("unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, fmt.Errorf("missing Sec-WebSocket-Key")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, fmt.Errorf("websocket is not supported by this server")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// the deadlines of the server timeouts stay on a hijacked connection
+	_ = conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(response)); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	ws := &wsConn{conn: conn, reader: rw.Reader, closed: make(chan struct{})}
+	go ws.readLoop()
+	return ws, nil
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *wsConn) Closed() <-chan struct{} {
+	return c.closed
+}
+
+func (c *wsConn) WriteText(data []byte) error {
+	return c.writeFrame(wsOpText, data)
+}
+
+func (c *wsConn) Ping() error {
+	return c.writeFrame(wsOpPing, nil)
+}
+
+func (c *wsConn) Close(code uint16) {
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, code)
+	_ = c.writeFrame(wsOpClose, payload)
+	c.shutdown()
+}
+
+func (c *wsConn) shutdown() {
+	c.once.Do(func() {
+		close(c.closed)
+		_ = c.conn.Close()
+	})
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126, byte(n>>8), byte(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	_ = c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		c.shutdown()
+		return err
+	}
+	return nil
+}
+
+func (c *wsConn) readLoop() {
+	defer c.shutdown()
+	for {
+		opcode, payload, err := c.readFrame()
+		if err != nil {
+			if errors.Is(err, errFrameTooBig) {
+				c.Close(wsCloseTooBig)
+			}
+			return
+		}
+		switch opcode {
+		case wsOpPing:
+			_ = c.writeFrame(wsOpPong, payload)
+		case wsOpClose:
+			c.Close(wsCloseNormal)
+			return
+		}
+	}
+}
+
+var errFrameTooBig = errors.New("websocket frame too big")
+
+func (c *wsConn) readFrame() (byte, []byte, error) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(c.reader, head); err != nil {
+		return 0, nil, err
+	}
+	opcode := head[0] & 0x0F
+	masked := head[1]&0x80 != 0
+	length := uint64(head[1] & 0x7F)
+
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(c.reader, ext); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(c.reader, ext); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext)
+	}
+	if length > wsMaxFrameSize {
+		return 0, nil, errFrameTooBig
+	}
+	if !masked {
+		c.Close(wsCloseProtoErr) // clients must mask their frames
+		return 0, nil, fmt.Errorf("unmasked client frame")
+	}
+
+	mask := make([]byte, 4)
+	if _, err := io.ReadFull(c.reader, mask); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
@@ -0,0 +1,10 @@
+// Package assets embeds the files shipped with the binary, so it runs without the source tree
+package assets
+
+import "embed"
+
+// Icons are the default node icons, under icons/. Files of the icons directory of the map
+// service override them.
+//
+//go:embed icons/*.svg
+var Icons embed.FS
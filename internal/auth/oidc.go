@@ -0,0 +1,400 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// JWKSRefreshInterval is how long fetched signing keys are used before they are fetched again
+	JWKSRefreshInterval = time.Hour
+	// an unknown key id triggers a fetch at most this often, so forged kids can't flood the issuer
+	minJWKSRefetch = 30 * time.Second
+	clockLeeway    = time.Minute
+	// minRSAKeyBits refuses RSA signing keys short enough to be factored
+	minRSAKeyBits = 2048
+)
+
+// Config of bearer token validation against an OIDC issuer
+type Config struct {
+	Issuer   string
+	Audience string
+	JWKSURL  string // discovered from the issuer when empty
+}
+
+// ConfigFromEnv reads WEATHERMAP_OIDC_* variables, ok is false when authentication is not configured
+func ConfigFromEnv() (cfg Config, ok bool, err error) {
+	cfg.Issuer = strings.TrimSpace(os.Getenv("WEATHERMAP_OIDC_ISSUER"))
+	cfg.Audience = strings.TrimSpace(os.Getenv("WEATHERMAP_OIDC_AUDIENCE"))
+	cfg.JWKSURL = strings.TrimSpace(os.Getenv("WEATHERMAP_OIDC_JWKS_URL"))
+	if cfg.Issuer == "" && cfg.Audience == "" && cfg.JWKSURL == "" {
+		return cfg, false, nil
+	}
+	return cfg, true, cfg.Validate()
+}
+
+func (c *Config) Validate() error {
+	if c.Issuer == "" {
+		return fmt.Errorf("OIDC issuer is required")
+	}
+	if c.Audience == "" {
+		return fmt.Errorf("OIDC audience is required")
+	}
+	return nil
+}
+
+// Claims of a verified token
+type Claims map[string]any
+
+// Subject returns the sub claim, the user or client the token was issued to
+func (c Claims) Subject() string {
+	sub, _ := c["sub"].(string)
+	return sub
+}
+
+// Strings returns a claim holding a string or a list of strings, like aud or groups
+func (c Claims) Strings(name string) []string {
+	switch value := c[name].(type) {
+	case string:
+		return []string{value}
+	case []any:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+type claimsKey struct{}
+
+// WithClaims stores the claims of the authenticated caller for downstream handlers
+func WithClaims(ctx context.Context, claims Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// ClaimsFromContext returns the claims of the authenticated caller, ok is false without authentication
+func ClaimsFromContext(ctx context.Context) (Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(Claims)
+	return claims, ok
+}
+
+// Verifier checks signed JWTs against the keys published by the issuer
+type Verifier struct {
+	cfg    Config
+	client *http.Client
+	now    func() time.Time
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey // kid -> key
+	fetchedAt time.Time
+	fetching  *jwksFetch // in flight, waited for by the other callers needing keys
+}
+
+// jwksFetch is a fetch of the signing keys, err is set when done is closed
+type jwksFetch struct {
+	done chan struct{}
+	err  error
+}
+
+func NewVerifier(cfg Config) *Verifier {
+	return &Verifier{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+		now:    time.Now,
+	}
+}
+
+// Verify checks signature, issuer, audience and validity period of a token and returns its claims
+func (v *Verifier) Verify(ctx context.Context, token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid token: malformed JWT")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid token header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid token signature encoding")
+	}
+
+	key, err := v.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid token claims: %w", err)
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *Verifier) checkClaims(claims Claims) error {
+	if iss, _ := claims["iss"].(string); iss != v.cfg.Issuer {
+		return fmt.Errorf("invalid token: issuer %q is not trusted", iss)
+	}
+	if !slices.Contains(claims.Strings("aud"), v.cfg.Audience) {
+		return fmt.Errorf("invalid token: audience %s is missing", v.cfg.Audience)
+	}
+	now := v.now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("invalid token: exp claim is missing")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(clockLeeway)) {
+		return fmt.Errorf("invalid token: expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(clockLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return fmt.Errorf("invalid token: not valid yet")
+	}
+	return nil
+}
+
+// key returns the signing key for kid, fetching the key set when it is stale or the kid is unknown
+func (v *Verifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	now := v.now()
+	key, ok := v.keys[kid]
+	stale := now.Sub(v.fetchedAt) > JWKSRefreshInterval
+	if ok && !stale {
+		v.mu.Unlock()
+		return key, nil
+	}
+	if stale || now.Sub(v.fetchedAt) > minJWKSRefetch {
+		fetch := v.fetching
+		if fetch == nil {
+			fetch = &jwksFetch{done: make(chan struct{})}
+			v.fetching = fetch
+			go v.fetch(context.WithoutCancel(ctx), fetch)
+		}
+		v.mu.Unlock()
+		select {
+		case <-fetch.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if fetch.err != nil {
+			if ok {
+				return key, nil // keep using known keys while the issuer is unreachable
+			}
+			return nil, fmt.Errorf("fetching signing keys: %w", fetch.err)
+		}
+		v.mu.Lock()
+	}
+	defer v.mu.Unlock()
+	if key, ok = v.keys[kid]; !ok {
+		return nil, fmt.Errorf("invalid token: unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// fetch replaces the signing keys, without holding mu so verifying tokens with known keys
+// doesn't wait for the issuer
+func (v *Verifier) fetch(ctx context.Context, fetch *jwksFetch) {
+	keys, err := v.fetchKeys(ctx)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err == nil {
+		v.keys, v.fetchedAt = keys, v.now()
+	}
+	fetch.err = err
+	v.fetching = nil
+	close(fetch.done)
+}
+
+func (v *Verifier) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	jwksURL := v.cfg.JWKSURL
+	if jwksURL == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := v.getJSON(ctx, strings.TrimRight(v.cfg.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, err
+		}
+		if discovery.JWKSURI == "" {
+			return nil, fmt.Errorf("issuer %s publishes no jwks_uri", v.cfg.Issuer)
+		}
+		jwksURL = discovery.JWKSURI
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := v.getJSON(ctx, jwksURL, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue // keys of unsupported types don't prevent using the others
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no usable signing keys at %s", jwksURL)
+	}
+	return keys, nil
+}
+
+func (v *Verifier) getJSON(ctx context.Context, url string, target any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned HTTP %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, errN := decodeInt(k.N)
+		e, errE := decodeInt(k.E)
+		if errN != nil || errE != nil || !e.IsInt64() {
+			return nil, fmt.Errorf("invalid RSA key %s", k.Kid)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, errX := decodeInt(k.X)
+		y, errY := decodeInt(k.Y)
+		if errX != nil || errY != nil {
+			return nil, fmt.Errorf("invalid EC key %s", k.Kid)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+// verifySignature accepts asymmetric algorithms only, so a public key can't be used as an HMAC secret
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	var hash crypto.Hash
+	switch alg[min(2, len(alg)):] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("invalid token: unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch {
+	case strings.HasPrefix(alg, "RS"), strings.HasPrefix(alg, "PS"):
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("invalid token: algorithm %s doesn't match the signing key", alg)
+		}
+		if pub.N.BitLen() < minRSAKeyBits {
+			return fmt.Errorf("invalid token: RSA signing key of %d bits, at least %d required", pub.N.BitLen(), minRSAKeyBits)
+		}
+		var err error
+		if alg[0] == 'R' {
+			err = rsa.VerifyPKCS1v15(pub, hash, digest, signature)
+		} else {
+			err = rsa.VerifyPSS(pub, hash, digest, signature, nil)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid token: bad signature")
+		}
+	case strings.HasPrefix(alg, "ES"):
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("invalid token: algorithm %s doesn't match the signing key", alg)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("invalid token: bad signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return fmt.Errorf("invalid token: bad signature")
+		}
+	default:
+		return fmt.Errorf("invalid token: unsupported algorithm %q", alg)
+	}
+	return nil
+}
+
+func decodeSegment(segment string, target any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
+
+func decodeInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, fmt.Errorf("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
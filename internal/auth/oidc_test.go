@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var b64 = base64.RawURLEncoding.EncodeToString
+
+func rsaJWK(kid string, key *rsa.PrivateKey) map[string]string {
+	return map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes())}
+}
+
+// testIssuer serves the keys returned by keys as JWKS and counts the fetches
+type testIssuer struct {
+	*httptest.Server
+	fetches atomic.Int32
+}
+
+func newTestIssuer(t *testing.T, keys func() []map[string]string) *testIssuer {
+	issuer := &testIssuer{}
+	issuer.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		issuer.fetches.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": keys()})
+	}))
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+func (i *testIssuer) verifier() *Verifier {
+	return NewVerifier(Config{Issuer: i.URL, Audience: "weathermap", JWKSURL: i.URL})
+}
+
+func (i *testIssuer) claims() map[string]any {
+	return map[string]any{"iss": i.URL, "aud": "weathermap", "sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}
+}
+
+// sign returns a JWT of claims, signed with key by alg
+func sign(t *testing.T, alg, kid string, key any, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+	var signature []byte
+	var err error
+	switch alg {
+	case "RS256":
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest[:])
+	case "PS256":
+		signature, err = rsa.SignPSS(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest[:], nil)
+	case "ES256":
+		r, s, signErr := ecdsa.Sign(rand.Reader, key.(*ecdsa.PrivateKey), digest[:])
+		signature, err = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...), signErr
+	case "HS256":
+		mac := hmac.New(sha256.New, key.([]byte))
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	}
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return signed + "." + b64(signature)
+}
+
+func TestVerifierFetchesKeysOutsideLock(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rotatedIn atomic.Bool
+	fetching := make(chan struct{}, 1)
+	release := make(chan struct{})
+	issuer := newTestIssuer(t, func() []map[string]string {
+		if !rotatedIn.Load() {
+			return []map[string]string{rsaJWK("k1", key)}
+		}
+		select {
+		case fetching <- struct{}{}:
+		default:
+		}
+		<-release
+		return []map[string]string{rsaJWK("k1", key), rsaJWK("k2", rotated)}
+	})
+	verifier := issuer.verifier()
+	if _, err := verifier.Verify(context.Background(), sign(t, "RS256", "k1", key, issuer.claims())); err != nil {
+		t.Fatalf("Expected a valid token, got %v", err)
+	}
+
+	// a token of a new key fetches the keys again, once for every caller waiting for them
+	rotatedIn.Store(true)
+	now := time.Now().Add(2 * minJWKSRefetch)
+	verifier.mu.Lock()
+	verifier.now = func() time.Time { return now }
+	verifier.mu.Unlock()
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := verifier.Verify(context.Background(), sign(t, "RS256", "k2", rotated, issuer.claims()))
+			errs <- err
+		}()
+	}
+	<-fetching
+
+	// known keys are verified while the fetch is in flight
+	done := make(chan error, 1)
+	go func() {
+		_, err := verifier.Verify(context.Background(), sign(t, "RS256", "k1", key, issuer.claims()))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected the token of a known key to be valid, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the token of a known key to be verified without waiting for the fetch")
+	}
+
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Expected the token of the new key to be valid, got %v", err)
+		}
+	}
+	if n := issuer.fetches.Load(); n != 2 {
+		t.Errorf("Expected one fetch at startup and one for the new key, got %d", n)
+	}
+}
+
+func TestVerifierRejectsWeakRSAKeys(t *testing.T) {
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := newTestIssuer(t, func() []map[string]string { return []map[string]string{rsaJWK("weak", weak)} })
+	_, err = issuer.verifier().Verify(context.Background(), sign(t, "RS256", "weak", weak, issuer.claims()))
+	if err == nil || !strings.Contains(err.Error(), "at least 2048") {
+		t.Errorf("Expected a 1024 bit RSA key to be refused, got %v", err)
+	}
+}
+
+func TestVerifierAlgorithms(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := newTestIssuer(t, func() []map[string]string {
+		return []map[string]string{
+			rsaJWK("rsa", rsaKey),
+			{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+		}
+	})
+	verifier := issuer.verifier()
+
+	for _, token := range []struct{ alg, kid string }{{"RS256", "rsa"}, {"PS256", "rsa"}, {"ES256", "ec"}} {
+		key := any(rsaKey)
+		if token.kid == "ec" {
+			key = ecKey
+		}
+		if _, err := verifier.Verify(context.Background(), sign(t, token.alg, token.kid, key, issuer.claims())); err != nil {
+			t.Errorf("Expected %s to be accepted, got %v", token.alg, err)
+		}
+	}
+
+	// HMAC with the public key as secret, the classic algorithm confusion
+	hs256 := sign(t, "HS256", "rsa", rsaKey.N.Bytes(), issuer.claims())
+	parts := strings.Split(sign(t, "RS256", "rsa", rsaKey, issuer.claims()), ".")
+	for name, token := range map[string]string{
+		"HS256":               hs256,
+		"none":                strings.Replace(hs256, strings.Split(hs256, ".")[0], b64([]byte(`{"alg":"none","kid":"rsa"}`)), 1),
+		"EdDSA":               b64([]byte(`{"alg":"EdDSA","kid":"rsa"}`)) + "." + parts[1] + "." + parts[2],
+		"ES256 of an RSA key": sign(t, "ES256", "rsa", ecKey, issuer.claims()),
+	} {
+		if _, err := verifier.Verify(context.Background(), token); err == nil || !strings.HasPrefix(err.Error(), "invalid token") {
+			t.Errorf("Expected %s to be refused, got %v", name, err)
+		}
+	}
+}
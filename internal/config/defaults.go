@@ -0,0 +1,78 @@
+package config
+
+// ApplyDefaults sets the defaults of the map on its nodes and links leaving them unset
+func (m *Map) ApplyDefaults() {
+	if m.Defaults == nil {
+		return
+	}
+	if d := m.Defaults.Node; d != nil {
+		for i := range m.Nodes {
+			node := &m.Nodes[i]
+			if node.Icon == "" {
+				node.Icon = d.Icon
+			}
+			if node.MaxValue == 0 {
+				node.MaxValue = d.MaxValue
+			}
+			if node.Monitoring == nil && d.Monitoring != nil {
+				monitoring := *d.Monitoring
+				node.Monitoring = &monitoring
+			}
+		}
+	}
+	if d := m.Defaults.Link; d != nil {
+		for i := range m.Links {
+			link := &m.Links[i]
+			if link.Bandwidth == "" {
+				link.Bandwidth = d.Bandwidth
+			}
+			if link.Width == 0 {
+				link.Width = d.Width
+			}
+			if link.Scale == "" {
+				link.Scale = d.Scale
+			}
+		}
+	}
+}
+
+// WithoutDefaults returns a copy of the map whose nodes and links leave the values equal to
+// the defaults unset, as the map is written to its file. Changing a default later changes
+// those objects too.
+func (m *Map) WithoutDefaults() *Map {
+	if m.Defaults == nil {
+		return m
+	}
+	stripped := *m
+	if d := m.Defaults.Node; d != nil {
+		stripped.Nodes = make([]Node, len(m.Nodes))
+		for i, node := range m.Nodes {
+			if node.Icon == d.Icon {
+				node.Icon = ""
+			}
+			if node.MaxValue == d.MaxValue {
+				node.MaxValue = 0
+			}
+			if node.Monitoring != nil && d.Monitoring != nil && *node.Monitoring == *d.Monitoring {
+				node.Monitoring = nil
+			}
+			stripped.Nodes[i] = node
+		}
+	}
+	if d := m.Defaults.Link; d != nil {
+		stripped.Links = make([]Link, len(m.Links))
+		for i, link := range m.Links {
+			if link.Bandwidth == d.Bandwidth {
+				link.Bandwidth = ""
+			}
+			if link.Width == d.Width {
+				link.Width = 0
+			}
+			if link.Scale == d.Scale {
+				link.Scale = ""
+			}
+			stripped.Links[i] = link
+		}
+	}
+	return &stripped
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// encryptedPrefix marks the values of variables encrypted at rest, followed by the base64 of
+// the nonce and the sealed value
+const encryptedPrefix = "enc:v1:"
+
+// VariableCipher encrypts the secret variables of maps with AES-256-GCM. The name of a
+// variable is authenticated with its value, so an encrypted value can't be moved to another
+// variable.
+type VariableCipher struct {
+	aead cipher.AEAD
+}
+
+// NewVariableCipher returns a cipher for a 32 byte key
+func NewVariableCipher(key []byte) (*VariableCipher, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid variables key: must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &VariableCipher{aead: aead}, nil
+}
+
+// IsEncrypted reports whether a variable value is encrypted
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// Encrypt seals the value of a variable
+func (c *VariableCipher) Encrypt(name, value string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(value), []byte(name))
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value sealed by Encrypt for the same variable
+func (c *VariableCipher) Decrypt(name, value string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil || !IsEncrypted(value) || len(sealed) < c.aead.NonceSize() {
+		return "", fmt.Errorf("variable %s: invalid encrypted value", name)
+	}
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	plain, err := c.aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return "", fmt.Errorf("variable %s: decryption failed, wrong key?", name)
+	}
+	return string(plain), nil
+}
+
+// Encrypted returns a copy of the variables with the values of secrets encrypted. Values
+// already encrypted, like ones a wrong key failed to decrypt, are kept as they are.
+func (v Variables) Encrypted(c *VariableCipher) (Variables, error) {
+	if v == nil {
+		return nil, nil
+	}
+	encrypted := make(Variables, len(v))
+	for name, value := range v {
+		if IsSecret(name) && value != "" && !IsEncrypted(value) {
+			var err error
+			if value, err = c.Encrypt(name, value); err != nil {
+				return nil, err
+			}
+		}
+		encrypted[name] = value
+	}
+	return encrypted, nil
+}
+
+// Decrypt replaces the encrypted values of the variables by their plain value. Values that
+// fail to decrypt are left encrypted and reported together.
+func (v Variables) Decrypt(c *VariableCipher) error {
+	var errs []error
+	for name, value := range v {
+		if !IsEncrypted(value) {
+			continue
+		}
+		plain, err := c.Decrypt(name, value)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		v[name] = plain
+	}
+	return errors.Join(errs...)
+}
@@ -8,20 +8,124 @@ import (
 )
 
 type Map struct {
+	ID      string             `yaml:"id,omitempty" json:"id,omitempty"` // stable ULID, names may change
 	Width   int                `yaml:"width" json:"width"`
 	Height  int                `yaml:"height" json:"height"`
 	Title   string             `yaml:"title" json:"title"`
 	BGColor *Color             `yaml:"bg_color,omitempty,flow" json:"bgcolor,omitempty"`
 	Scales  map[string][]Scale `yaml:"scales,omitempty" json:"scales,omitempty"`
+	// colorblind-safe palette, hatching by band and high-contrast labels
+	Accessible bool `yaml:"accessible,omitempty" json:"accessible,omitempty"`
 
 	Nodes       []Node             `yaml:"nodes" json:"nodes"`
 	Links       []Link             `yaml:"links" json:"links"`
 	Datasources []DataSourceConfig `yaml:"datasources" json:"datasources"`
+	// values of the nodes and links leaving them unset, applied when the map is read
+	Defaults *Defaults `yaml:"defaults,omitempty" json:"defaults,omitempty"`
+	// what links above 100% utilization show: allow (default), clamp or flag
+	OverUtilization string `yaml:"over_utilization,omitempty" json:"over_utilization,omitempty"`
+	// links may reference datasources defined outside the config directory, unknown
+	// references are only warnings even when they are enforced
+	ExternalDatasources bool `yaml:"external_datasources,omitempty" json:"external_datasources,omitempty"`
 
-	// Global variables (like zabbix creds)
-	Variables map[string]string `yaml:"variables,omitempty" json:"variables,omitempty"`
+	// Global variables (like zabbix creds), secrets are masked in API responses
+	Variables Variables `yaml:"variables,omitempty" json:"variables,omitempty"`
+
+	// Traffic matrix for planning, projected on links by shortest path
+	Demands []Demand `yaml:"demands,omitempty" json:"demands,omitempty"`
+
+	// flags links far from their usual utilization, needs the history
+	AnomalyDetection *AnomalyDetection `yaml:"anomaly_detection,omitempty" json:"anomaly_detection,omitempty"`
+
+	// named time windows like business hours or an on-call calendar
+	Schedules []Schedule `yaml:"schedules,omitempty" json:"schedules,omitempty"`
+
+	// expressions styling links from their data, for rules a scale can't express
+	LinkStyle *LinkStyleRules `yaml:"link_style,omitempty" json:"link_style,omitempty"`
+
+	// threshold rules on the links, notifying the receivers when they fire and recover
+	Alerts    []AlertRule     `yaml:"alerts,omitempty" json:"alerts,omitempty"`
+	Receivers []AlertReceiver `yaml:"receivers,omitempty" json:"receivers,omitempty"`
+
+	// NetBox devices or sites and their connections synced into the map
+	NetBox *MapNetBox `yaml:"netbox,omitempty" json:"netbox,omitempty"`
+
+	// set on save, UpdatedAt changes with any object of the map
+	CreatedAt *time.Time `yaml:"created_at,omitempty" json:"created_at,omitempty"`
+	UpdatedAt *time.Time `yaml:"updated_at,omitempty" json:"updated_at,omitempty"`
+}
+
+// BandwidthAuto is the bandwidth of links reading it from the speed of their interface,
+// ifHighSpeed over SNMP
+const BandwidthAuto = "auto"
+
+// NetBox node kinds of MapNetBox
+const (
+	NetBoxDevices = "devices" // a node by device, a link by cable or circuit
+	NetBoxSites   = "sites"   // a node by site, a link by cable or circuit between two sites
+)
+
+// SourceNetBox is the Source of nodes and links synced from NetBox
+const SourceNetBox = "netbox"
+
+// MapNetBox selects the NetBox objects of a map, filters are slugs and empty ones match all
+type MapNetBox struct {
+	Nodes string   `yaml:"nodes,omitempty" json:"nodes,omitempty"` // NetBoxDevices (default) or NetBoxSites
+	Sites []string `yaml:"sites,omitempty" json:"sites,omitempty"`
+	Roles []string `yaml:"roles,omitempty" json:"roles,omitempty"` // device roles
+	Tags  []string `yaml:"tags,omitempty" json:"tags,omitempty"`
+}
+
+// Policies for links above 100% utilization, usually a wrong bandwidth or interfaces
+// counted twice
+const (
+	OverUtilizationAllow = "allow" // shown as computed
+	OverUtilizationClamp = "clamp" // shown at 100%
+	OverUtilizationFlag  = "flag"  // shown as misconfigured
+)
+
+// Demand is the planned traffic from one node to another, Rate uses the bandwidth format
+type Demand struct {
+	From string `yaml:"from" json:"from"`
+	To   string `yaml:"to" json:"to"`
+	Rate string `yaml:"rate" json:"rate"`
 }
 
+// AnomalyDetection compares the utilization of links with the same time of the previous
+// weeks. A link is anomalous when it is both Threshold standard deviations and MinDeviation
+// percentage points away from that baseline.
+type AnomalyDetection struct {
+	Threshold    float64 `yaml:"threshold,omitempty" json:"threshold,omitempty"`         // z-score, default 3
+	Weeks        int     `yaml:"weeks,omitempty" json:"weeks,omitempty"`                 // weeks of baseline, default 4
+	MinDeviation float64 `yaml:"min_deviation,omitempty" json:"min_deviation,omitempty"` // percentage points, default 10
+}
+
+const (
+	DefaultAnomalyThreshold    = 3
+	DefaultAnomalyWeeks        = 4
+	DefaultAnomalyMinDeviation = 10
+	MaxAnomalyWeeks            = 52
+)
+
+// Schedule is active during any of its weekly windows, or during the events of the
+// iCal calendar at ICalURL, e.g. the on-call rota exported by a paging service
+type Schedule struct {
+	Name     string           `yaml:"name" json:"name"`
+	Timezone string           `yaml:"timezone,omitempty" json:"timezone,omitempty"` // IANA name of the windows, default UTC
+	Windows  []ScheduleWindow `yaml:"windows,omitempty" json:"windows,omitempty"`
+	ICalURL  string           `yaml:"ical_url,omitempty" json:"ical_url,omitempty"`
+}
+
+// ScheduleWindow is a daily time range, End before Start crosses midnight (22:00-06:00)
+type ScheduleWindow struct {
+	Days  []string `yaml:"days,omitempty,flow" json:"days,omitempty"` // mon to sun, empty is every day
+	Start string   `yaml:"start" json:"start"`                        // HH:MM
+	End   string   `yaml:"end" json:"end"`                            // HH:MM, 24:00 is the end of the day
+}
+
+// ScheduleDays are the day names of windows, indexed by time.Weekday
+var ScheduleDays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
 type Color struct {
 	R int `yaml:"r"`
 	G int `yaml:"g"`
@@ -37,6 +141,60 @@ type Legend struct {
 	HideZero     bool     `yaml:"hide_zero,omitempty"`
 }
 
+// AlertRule fires for every link matching Condition for at least For
+type AlertRule struct {
+	Name      string   `yaml:"name" json:"name"`
+	Condition string   `yaml:"condition" json:"condition"`                   // expression of the link_style variables, like utilization > 90
+	For       string   `yaml:"for,omitempty" json:"for,omitempty"`           // duration, fires on the first match when empty
+	Links     []string `yaml:"links,omitempty,flow" json:"links,omitempty"`  // every link of the map when empty
+	Schedule  string   `yaml:"schedule,omitempty" json:"schedule,omitempty"` // evaluated only while it is active
+	Notify    []string `yaml:"notify,flow" json:"notify"`                    // receiver names
+}
+
+// Types of alert receivers
+const (
+	ReceiverWebhook  = "webhook"  // the alert as JSON
+	ReceiverSlack    = "slack"    // incoming webhook
+	ReceiverTelegram = "telegram" // sendMessage of a bot
+)
+
+// AlertReceiver is where the notifications of alert rules are sent
+type AlertReceiver struct {
+	Name     string `yaml:"name" json:"name"`
+	Type     string `yaml:"type" json:"type"`
+	URL      string `yaml:"url,omitempty" json:"url,omitempty"` // of the webhook, the Telegram Bot API by default
+	BotToken string `yaml:"bot_token,omitempty" json:"bot_token,omitempty"`
+	ChatID   string `yaml:"chat_id,omitempty" json:"chat_id,omitempty"`
+}
+
+// LinkStyleRules are expressions of the language of package expr computing the style of
+// every link, an empty or null result keeps the style of the scale
+type LinkStyleRules struct {
+	Color string `yaml:"color,omitempty" json:"color,omitempty"` // to a "#rrggbb" color
+	Width string `yaml:"width,omitempty" json:"width,omitempty"` // to pixels
+	Dash  string `yaml:"dash,omitempty" json:"dash,omitempty"`   // to an SVG dash array like "6,4"
+}
+
+// LinkStyle is the style computed by the LinkStyleRules of the map for one link
+type LinkStyle struct {
+	Color string `json:"color,omitempty"`
+	Width int    `json:"width,omitempty"`
+	Dash  string `json:"dash,omitempty"`
+	Error string `json:"error,omitempty"` // of the rules failing for this link
+}
+
+// ParseHexColor parses a "#rrggbb" color
+func ParseHexColor(value string) (Color, error) {
+	var c Color
+	if len(value) != 7 || value[0] != '#' {
+		return c, fmt.Errorf("invalid color %q, must be #rrggbb", value)
+	}
+	if _, err := fmt.Sscanf(value[1:], "%02x%02x%02x", &c.R, &c.G, &c.B); err != nil {
+		return c, fmt.Errorf("invalid color %q, must be #rrggbb", value)
+	}
+	return c, nil
+}
+
 type Scale struct {
 	Name  string  `yaml:"name"`
 	Min   float64 `yaml:"min"`
@@ -49,35 +207,71 @@ type Position struct {
 	Y int `yaml:"y" json:"y"`
 }
 
+// Defaults are the values of the nodes and links of a map which leave them unset, see
+// Map.ApplyDefaults
 type Defaults struct {
-	Node *NodeDefaults `yaml:"node,omitempty"`
-	Link *LinkDefaults `yaml:"link,omitempty"`
+	Node *NodeDefaults `yaml:"node,omitempty" json:"node,omitempty"`
+	Link *LinkDefaults `yaml:"link,omitempty" json:"link,omitempty"`
 }
 
 type NodeDefaults struct {
-	MaxValue   int    `yaml:"max_value,omitempty"`
-	Icon       string `yaml:"icon,omitempty"`
-	Monitoring bool   `yaml:"monitoring"`
+	MaxValue   int    `yaml:"max_value,omitempty" json:"max_value,omitempty"`
+	Icon       string `yaml:"icon,omitempty" json:"icon,omitempty"`
+	Monitoring *bool  `yaml:"monitoring,omitempty" json:"monitoring,omitempty"`
 }
 
 type LinkDefaults struct {
-	Width      int      `yaml:"width,omitempty"`
-	ArrowStyle string   `yaml:"arrow_style,omitempty"`
-	BWLabel    string   `yaml:"bw_label,omitempty"`
-	BWLabelPos Position `yaml:"bw_label_pos,omitempty"`
-	Bandwidth  string   `yaml:"bandwidth,omitempty"`
+	Width     int    `yaml:"width,omitempty" json:"width,omitempty"`
+	Bandwidth string `yaml:"bandwidth,omitempty" json:"bandwidth,omitempty"`
+	Scale     string `yaml:"scale,omitempty" json:"scale,omitempty"`
 }
 
 type Node struct {
+	ID         string   `yaml:"id,omitempty" json:"id,omitempty"`
 	Name       string   `yaml:"name"`
 	Label      string   `yaml:"label,omitempty"`
 	Position   Position `yaml:"position,flow"`
 	Icon       string   `yaml:"icon,omitempty"`
-	Monitoring bool     `yaml:"monitoring"`
+	Monitoring *bool    `yaml:"monitoring,omitempty"`
 	MaxValue   int      `yaml:"max_value,omitempty"`
+
+	ManagementIP string      `yaml:"management_ip,omitempty" json:"management_ip,omitempty"`
+	Address      string      `yaml:"address,omitempty" json:"address,omitempty"`       // IP or host name pinged for the node status
+	Zabbix       *NodeZabbix `yaml:"zabbix,omitempty" json:"zabbix,omitempty"`         // host whose maintenance and availability are shown
+	DataSource   string      `yaml:"datasource,omitempty" json:"datasource,omitempty"` // of the device, the polls of an snmp one give the node status
+	Interface    string      `yaml:"interface,omitempty" json:"interface,omitempty"`   // of DataSource, holding device metrics like cpu
+	Metrics      []string    `yaml:"metrics,omitempty" json:"metrics,omitempty"`
+	States       NodeStates  `yaml:"states,omitempty" json:"states,omitempty"` // labels of the values of status metrics, like a PSU state
+	Loopback     string      `yaml:"loopback,omitempty" json:"loopback,omitempty"`
+	Subnets      []string    `yaml:"subnets,omitempty" json:"subnets,omitempty"`     // CIDRs attached to the node
+	DNSLabel     string      `yaml:"dns_label,omitempty" json:"dns_label,omitempty"` // fqdn or short: label synced from the PTR of ManagementIP
+	InfoURL      string      `yaml:"info_url,omitempty" json:"info_url,omitempty"`   // opened when the node is clicked
+
+	// system the node is synced from, like netbox, which replaces or removes it on the next sync
+	Source   string `yaml:"source,omitempty" json:"source,omitempty"`
+	SourceID string `yaml:"source_id,omitempty" json:"source_id,omitempty"` // of the object in Source
+
+	CreatedAt *time.Time `yaml:"created_at,omitempty" json:"created_at,omitempty"`
+	UpdatedAt *time.Time `yaml:"updated_at,omitempty" json:"updated_at,omitempty"`
+}
+
+// NodeStates labels the values of metrics by metric, for example 1: normal, 3: critical for
+// ciscoEnvMonSupplyState. Values without a label are reported as read.
+type NodeStates map[string]map[int64]string
+
+// NodeZabbix is the host of a node in a zabbix datasource, by its technical name
+type NodeZabbix struct {
+	Datasource string `yaml:"datasource" json:"datasource"`
+	Host       string `yaml:"host" json:"host"`
 }
 
+const (
+	DNSLabelFQDN  = "fqdn"
+	DNSLabelShort = "short"
+)
+
 type Link struct {
+	ID           string         `yaml:"id,omitempty" json:"id,omitempty"`
 	Name         string         `yaml:"name"`
 	From         string         `yaml:"from"`
 	To           string         `yaml:"to"`
@@ -85,11 +279,22 @@ type Link struct {
 	Interface    string         `yaml:"interface,omitempty" json:"interface,omitempty"`
 	Metrics      []string       `yaml:"metrics,omitempty" json:"metrics,omitempty"`
 	OverlibGraph *DataSourceRef `yaml:"overlib_graph,omitempty"`
-	Bandwidth    string         `yaml:"bandwidth,omitempty"`
+	Bandwidth    string         `yaml:"bandwidth,omitempty"`                                  // like 10G, or auto for the speed of the interface
+	CommitRate   string         `yaml:"commit_rate,omitempty" json:"commit_rate,omitempty"`   // CIR of policed circuits, below Bandwidth
+	CommitScale  string         `yaml:"commit_scale,omitempty" json:"commit_scale,omitempty"` // scale for utilization of CommitRate
+	Cost         int            `yaml:"cost,omitempty" json:"cost,omitempty"`                 // path metric, 0 counts as 1
+	Subnet       string         `yaml:"subnet,omitempty" json:"subnet,omitempty"`             // circuit CIDR
+	InfoURL      string         `yaml:"info_url,omitempty" json:"info_url,omitempty"`         // opened when the link is clicked
 	Width        int            `yaml:"width,omitempty"`
 	BWLabelPos   *Position      `yaml:"bw_label_pos,omitempty"`
 	Via          []Position     `yaml:"via,omitempty,flow"`
 	Scale        string         `yaml:"scale,omitempty"`
+
+	Source   string `yaml:"source,omitempty" json:"source,omitempty"` // like for nodes
+	SourceID string `yaml:"source_id,omitempty" json:"source_id,omitempty"`
+
+	CreatedAt *time.Time `yaml:"created_at,omitempty" json:"created_at,omitempty"`
+	UpdatedAt *time.Time `yaml:"updated_at,omitempty" json:"updated_at,omitempty"`
 }
 
 type DataSourceRef struct {
@@ -109,13 +314,72 @@ type MapWithData struct {
 	*Map
 	ProcessedAt time.Time  `json:"processed_at"`
 	LinksData   []LinkData `json:"links_data"`
+	NodesData   []NodeData `json:"nodes_data,omitempty"` // reachability of nodes with an address
+	Path        *Path      `json:"path,omitempty"`       // highlighted by renderers
+
+	// Partial is set when the map deadline passed before every link had its metrics, the
+	// PendingLinks are unknown
+	Partial      bool     `json:"partial,omitempty"`
+	PendingLinks []string `json:"pending_links,omitempty"`
+
+	PlannedData []PlannedLinkData `json:"planned_data,omitempty"` // load of the map demands
+	Clusters    []NodeCluster     `json:"clusters,omitempty"`     // nodes grouped at low zoom levels
+}
+
+// NodeData is the result of the last pings of the address of a node
+type NodeData struct {
+	Name      string     `json:"name"`
+	Status    string     `json:"status"`               // up, down, maintenance or unknown before the first check
+	LatencyMs *float64   `json:"latency_ms,omitempty"` // round trip time of the echo reply
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+	Error     string     `json:"error,omitempty"`
+
+	UptimeSeconds *int64     `json:"uptime_seconds,omitempty"` // sysUpTime of nodes with an snmp datasource
+	RebootedAt    *time.Time `json:"rebooted_at,omitempty"`    // last reboot seen since the server started
+
+	Metrics map[string]interface{} `json:"metrics,omitempty"` // values of the metrics of the node interface
+}
+
+// NodeCluster is a group of nearby nodes replaced by one marker node of the same name
+type NodeCluster struct {
+	Name        string   `json:"name"`
+	Nodes       []string `json:"nodes"`
+	Status      string   `json:"status"`      // worst state of the links of the members: down, degraded, up, unknown
+	Utilization float64  `json:"utilization"` // highest utilization of the links of the members
+}
+
+type PlannedLinkData struct {
+	Name        string  `json:"name"`
+	Utilization float64 `json:"utilization"`
+	Demands     int     `json:"demands"` // demands routed over the link
+}
+
+// Path is a route between two nodes, Links[i] joins Nodes[i] and Nodes[i+1]
+type Path struct {
+	From  string   `json:"from"`
+	To    string   `json:"to"`
+	Cost  int      `json:"cost"`
+	Nodes []string `json:"nodes"`
+	Links []string `json:"links"`
 }
 
 type LinkData struct {
-	Name        string                 `json:"name"`
-	Utilization float64                `json:"utilization"`
-	Status      string                 `json:"status"`
-	Metrics     map[string]interface{} `json:"metrics,omitempty"`
+	Name              string                 `json:"name"`
+	Utilization       float64                `json:"utilization"`
+	CommitUtilization *float64               `json:"commit_utilization,omitempty"` // only for links with commit_rate
+	Status            string                 `json:"status"`
+	Metrics           map[string]interface{} `json:"metrics,omitempty"`
+
+	// with anomaly_detection once the history has a baseline for the current time
+	Anomalous    bool     `json:"anomalous,omitempty"`
+	AnomalyScore *float64 `json:"anomaly_score,omitempty"` // standard deviations from the baseline
+	Baseline     *float64 `json:"baseline,omitempty"`      // usual utilization at this time of the week
+
+	Style *LinkStyle `json:"style,omitempty"` // with link_style rules
+	Color string     `json:"color"`           // #rrggbb the link is drawn with, see render.LinkColor
+
+	// bandwidth of links with bandwidth auto, from the speed their interface reports
+	DetectedBandwidth string `json:"detected_bandwidth,omitempty"`
 }
 
 func (p Position) MarshalYAML() (interface{}, error) {
@@ -133,9 +397,10 @@ func (p Position) MarshalYAML() (interface{}, error) {
 }
 
 type IconInfo struct {
-	Name        string `json:"name"`
-	DisplayName string `json:"display_name"`
-	Category    string `json:"category"`
+	Name        string   `json:"name"`
+	DisplayName string   `json:"display_name"`
+	Category    string   `json:"category"`
+	Tags        []string `json:"tags,omitempty"`
 }
 
 type NodeStatus struct {
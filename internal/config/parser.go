@@ -1,9 +1,18 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"net/netip"
+	"net/url"
 	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"go-weathermap/internal/expr"
+	"go-weathermap/internal/utils"
 
 	"gopkg.in/yaml.v3"
 )
@@ -20,42 +29,394 @@ func (p *Parser) ParseYAML(r io.Reader) (*Map, error) {
 	if err := decoder.Decode(&m); err != nil {
 		return nil, err
 	}
+	m.ApplyDefaults()
 	return &m, nil
 }
 
+// Validate checks the map, the error joins the problems of every node, link, schedule, alert
+// and demand, one per object
 func (p *Parser) Validate(m *Map) error {
+	var errs []error
 	if m.Width <= 0 || m.Height <= 0 {
-		return fmt.Errorf("width and height of map %s must be positive", m.Title)
+		errs = append(errs, fmt.Errorf("width and height of map %s must be positive", m.Title))
+	}
+
+	ids := make(map[string]bool)
+	uniqueID := func(id string) error {
+		if id == "" {
+			return nil
+		}
+		if ids[id] {
+			return fmt.Errorf("duplicate id %s", id)
+		}
+		ids[id] = true
+		return nil
+	}
+	if err := uniqueID(m.ID); err != nil {
+		errs = append(errs, err)
+	}
+
+	if d := m.Defaults; d != nil && d.Link != nil && d.Link.Bandwidth != "" && d.Link.Bandwidth != BandwidthAuto {
+		if err := validateBandwidth(d.Link.Bandwidth); err != nil {
+			errs = append(errs, fmt.Errorf("defaults.link: %w", err))
+		}
+	}
+
+	switch m.OverUtilization {
+	case "", OverUtilizationAllow, OverUtilizationClamp, OverUtilizationFlag:
+	default:
+		errs = append(errs, fmt.Errorf("over_utilization: unknown policy %s, must be %s, %s or %s",
+			m.OverUtilization, OverUtilizationAllow, OverUtilizationClamp, OverUtilizationFlag))
+	}
+
+	if m.NetBox != nil {
+		switch m.NetBox.Nodes {
+		case "", NetBoxDevices, NetBoxSites:
+		default:
+			errs = append(errs, fmt.Errorf("netbox: unknown nodes %s, must be %s or %s", m.NetBox.Nodes, NetBoxDevices, NetBoxSites))
+		}
 	}
 
 	nodeMap := make(map[string]bool)
 	for _, node := range m.Nodes {
 		if node.Name == "" {
-			return fmt.Errorf("node name cannot be empty")
+			errs = append(errs, fmt.Errorf("node name cannot be empty"))
+			continue
+		}
+		if err := uniqueID(node.ID); err != nil {
+			errs = append(errs, fmt.Errorf("node '%s': %w", node.Name, err))
+		} else if err := validateNodeAddresses(node); err != nil {
+			errs = append(errs, fmt.Errorf("node '%s': %w", node.Name, err))
+		} else if err := validateNodeStates(node); err != nil {
+			errs = append(errs, fmt.Errorf("node '%s': %w", node.Name, err))
 		}
 		nodeMap[node.Name] = true
 	}
 
 	for _, link := range m.Links {
-		if link.Name == "" {
-			return fmt.Errorf("link name cannot be empty")
+		if err := validateLink(link, nodeMap, m.Scales, uniqueID); err != nil {
+			errs = append(errs, err)
 		}
-		if !nodeMap[link.From] {
-			return fmt.Errorf("link %s references unknown node: %s", link.Name, link.From)
+	}
+
+	if a := m.AnomalyDetection; a != nil {
+		if a.Threshold < 0 || a.MinDeviation < 0 {
+			errs = append(errs, fmt.Errorf("anomaly_detection: threshold and min_deviation must not be negative"))
 		}
-		if !nodeMap[link.To] {
-			return fmt.Errorf("link %s references unknown node: %s", link.Name, link.To)
+		if a.Weeks < 0 || a.Weeks > MaxAnomalyWeeks {
+			errs = append(errs, fmt.Errorf("anomaly_detection: weeks must be between 1 and %d", MaxAnomalyWeeks))
 		}
-		if err := validateBandwidth(link.Bandwidth); err != nil {
-			return fmt.Errorf("link '%s': %w", link.Name, err)
+	}
+
+	if rules := m.LinkStyle; rules != nil {
+		for _, rule := range []struct{ name, source string }{{"color", rules.Color}, {"width", rules.Width}, {"dash", rules.Dash}} {
+			if rule.source == "" {
+				continue
+			}
+			if _, err := expr.Compile(rule.source); err != nil {
+				errs = append(errs, fmt.Errorf("link_style.%s: %w", rule.name, err))
+			}
+		}
+	}
+
+	scheduleNames := make(map[string]bool)
+	for _, schedule := range m.Schedules {
+		if schedule.Name == "" {
+			errs = append(errs, fmt.Errorf("schedule name cannot be empty"))
+			continue
+		}
+		if scheduleNames[schedule.Name] {
+			errs = append(errs, fmt.Errorf("duplicate schedule %s", schedule.Name))
+			continue
+		}
+		scheduleNames[schedule.Name] = true
+		if err := validateSchedule(schedule); err != nil {
+			errs = append(errs, fmt.Errorf("schedule '%s': %w", schedule.Name, err))
+		}
+	}
+
+	receivers := make(map[string]bool)
+	for _, receiver := range m.Receivers {
+		if receiver.Name == "" {
+			errs = append(errs, fmt.Errorf("receiver name cannot be empty"))
+			continue
+		}
+		if receivers[receiver.Name] {
+			errs = append(errs, fmt.Errorf("duplicate receiver %s", receiver.Name))
+			continue
+		}
+		receivers[receiver.Name] = true
+		if err := validateReceiver(receiver); err != nil {
+			errs = append(errs, fmt.Errorf("receiver '%s': %w", receiver.Name, err))
 		}
 	}
 
+	linkNames := make(map[string]bool, len(m.Links))
+	for _, link := range m.Links {
+		linkNames[link.Name] = true
+	}
+	rules := make(map[string]bool)
+	for _, rule := range m.Alerts {
+		if rule.Name == "" {
+			errs = append(errs, fmt.Errorf("alert name cannot be empty"))
+			continue
+		}
+		if rules[rule.Name] {
+			errs = append(errs, fmt.Errorf("duplicate alert %s", rule.Name))
+			continue
+		}
+		rules[rule.Name] = true
+		if err := validateAlertRule(rule, linkNames, scheduleNames, receivers); err != nil {
+			errs = append(errs, fmt.Errorf("alert '%s': %w", rule.Name, err))
+		}
+	}
+
+	for i, demand := range m.Demands {
+		if !nodeMap[demand.From] || !nodeMap[demand.To] {
+			errs = append(errs, fmt.Errorf("demand %d references unknown node: %s -> %s", i, demand.From, demand.To))
+		} else if err := validateBandwidth(demand.Rate); err != nil {
+			errs = append(errs, fmt.Errorf("demand %s -> %s rate: %w", demand.From, demand.To, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ValidateLink checks one link against the nodes of a map like Validate does, without the
+// uniqueness of its id
+func (p *Parser) ValidateLink(m *Map, link Link) error {
+	nodeMap := make(map[string]bool, len(m.Nodes))
+	for _, node := range m.Nodes {
+		nodeMap[node.Name] = true
+	}
+	return validateLink(link, nodeMap, m.Scales, func(string) error { return nil })
+}
+
+// builtinScales are the names of the scales rendered with built-in bands when the map doesn't
+// define them
+var builtinScales = []string{"default", "commit"}
+
+func validateLink(link Link, nodeMap map[string]bool, scales map[string][]Scale, uniqueID func(string) error) error {
+	if link.Name == "" {
+		return fmt.Errorf("link name cannot be empty")
+	}
+	if err := uniqueID(link.ID); err != nil {
+		return fmt.Errorf("link '%s': %w", link.Name, err)
+	}
+	if !nodeMap[link.From] {
+		return fmt.Errorf("link %s references unknown node: %s", link.Name, link.From)
+	}
+	if !nodeMap[link.To] {
+		return fmt.Errorf("link %s references unknown node: %s", link.Name, link.To)
+	}
+	if link.Bandwidth == BandwidthAuto {
+		if link.DataSource == "" || link.Interface == "" {
+			return fmt.Errorf("link '%s': bandwidth auto needs a datasource and an interface", link.Name)
+		}
+	} else if err := validateBandwidth(link.Bandwidth); err != nil {
+		return fmt.Errorf("link '%s': %w", link.Name, err)
+	}
+	if err := validateInfoURL(link.InfoURL); err != nil {
+		return fmt.Errorf("link '%s': %w", link.Name, err)
+	}
+	if link.Subnet != "" {
+		if _, err := netip.ParsePrefix(link.Subnet); err != nil {
+			return fmt.Errorf("link '%s': invalid subnet: %w", link.Name, err)
+		}
+	}
+	for field, name := range map[string]string{"scale": link.Scale, "commit_scale": link.CommitScale} {
+		if _, ok := scales[name]; !ok && name != "" && !slices.Contains(builtinScales, name) {
+			return fmt.Errorf("link '%s': unknown %s %s", link.Name, field, name)
+		}
+	}
+	if link.Cost < 0 {
+		return fmt.Errorf("link '%s': cost must not be negative", link.Name)
+	}
+	if link.Width < 0 {
+		return fmt.Errorf("link '%s': width must not be negative", link.Name)
+	}
+	if link.CommitRate != "" {
+		if err := validateBandwidth(link.CommitRate); err != nil {
+			return fmt.Errorf("link '%s' commit_rate: %w", link.Name, err)
+		}
+		// the speed of auto links is only known once polled
+		if link.Bandwidth != BandwidthAuto && utils.ParseBandwidth(link.CommitRate) > utils.ParseBandwidth(link.Bandwidth) {
+			return fmt.Errorf("link '%s': commit_rate %s exceeds bandwidth %s", link.Name, link.CommitRate, link.Bandwidth)
+		}
+	}
+	return nil
+}
+
+func validateSchedule(schedule Schedule) error {
+	if len(schedule.Windows) == 0 && schedule.ICalURL == "" {
+		return fmt.Errorf("windows or ical_url is required")
+	}
+	if _, err := time.LoadLocation(schedule.Timezone); err != nil {
+		return fmt.Errorf("invalid timezone: %s", schedule.Timezone)
+	}
+	if schedule.ICalURL != "" {
+		if u, err := url.Parse(schedule.ICalURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid ical_url: must be an http or https URL")
+		}
+	}
+	for _, window := range schedule.Windows {
+		for _, day := range window.Days {
+			if !slices.Contains(ScheduleDays, strings.ToLower(day)) {
+				return fmt.Errorf("invalid day: '%s', must be one of %s", day, strings.Join(ScheduleDays, ", "))
+			}
+		}
+		start, err := ParseClock(window.Start)
+		if err != nil {
+			return err
+		}
+		end, err := ParseClock(window.End)
+		if err != nil {
+			return err
+		}
+		if start == end {
+			return fmt.Errorf("window %s-%s is empty", window.Start, window.End)
+		}
+	}
+	return nil
+}
+
+func validateReceiver(receiver AlertReceiver) error {
+	switch receiver.Type {
+	case ReceiverWebhook, ReceiverSlack:
+		if receiver.URL == "" {
+			return fmt.Errorf("url is required")
+		}
+	case ReceiverTelegram:
+		if receiver.BotToken == "" || receiver.ChatID == "" {
+			return fmt.Errorf("bot_token and chat_id are required")
+		}
+	default:
+		return fmt.Errorf("invalid type: '%s', must be %s, %s or %s", receiver.Type, ReceiverWebhook, ReceiverSlack, ReceiverTelegram)
+	}
+	if receiver.URL != "" {
+		if u, err := url.Parse(receiver.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid url: must be an http or https URL")
+		}
+	}
+	return nil
+}
+
+func validateAlertRule(rule AlertRule, links, schedules, receivers map[string]bool) error {
+	if rule.Condition == "" {
+		return fmt.Errorf("condition is required")
+	}
+	if _, err := expr.Compile(rule.Condition); err != nil {
+		return fmt.Errorf("condition: %w", err)
+	}
+	if rule.For != "" {
+		if d, err := time.ParseDuration(rule.For); err != nil || d < 0 {
+			return fmt.Errorf("invalid for: '%s', must be a duration like 5m", rule.For)
+		}
+	}
+	for _, link := range rule.Links {
+		if !links[link] {
+			return fmt.Errorf("unknown link: %s", link)
+		}
+	}
+	if rule.Schedule != "" && !schedules[rule.Schedule] {
+		return fmt.Errorf("unknown schedule: %s", rule.Schedule)
+	}
+	if len(rule.Notify) == 0 {
+		return fmt.Errorf("notify requires at least one receiver")
+	}
+	for _, name := range rule.Notify {
+		if !receivers[name] {
+			return fmt.Errorf("unknown receiver: %s", name)
+		}
+	}
+	return nil
+}
+
+// ParseClock parses an HH:MM time of day into the time since midnight, 24:00 included
+func ParseClock(value string) (time.Duration, error) {
+	if value == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time: '%s', must be HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// ParseAddress accepts an address with or without prefix length, like 10.0.0.1 or 10.0.0.1/32
+func ParseAddress(value string) (netip.Addr, error) {
+	if prefix, err := netip.ParsePrefix(value); err == nil {
+		return prefix.Addr(), nil
+	}
+	return netip.ParseAddr(value)
+}
+
+// validateNodeStates checks that the states of a node label metrics it reads
+func validateNodeStates(node Node) error {
+	for metric := range node.States {
+		if !slices.Contains(node.Metrics, metric) {
+			return fmt.Errorf("states: metric %s is not in the metrics of the node", metric)
+		}
+	}
+	return nil
+}
+
+func validateNodeAddresses(node Node) error {
+	if node.ManagementIP != "" {
+		if _, err := ParseAddress(node.ManagementIP); err != nil {
+			return fmt.Errorf("invalid management_ip: %w", err)
+		}
+	}
+	if node.Loopback != "" {
+		if _, err := ParseAddress(node.Loopback); err != nil {
+			return fmt.Errorf("invalid loopback: %w", err)
+		}
+	}
+	if node.Address != "" {
+		// dotted numbers like 10.0.0.300 are broken addresses, not host names
+		if _, err := netip.ParseAddr(node.Address); err != nil && (!hostnameRegex.MatchString(node.Address) || strings.Trim(node.Address, "0123456789.") == "") {
+			return fmt.Errorf("invalid address: '%s', must be an IP address or a host name", node.Address)
+		}
+	}
+	if node.Zabbix != nil && (node.Zabbix.Datasource == "" || node.Zabbix.Host == "") {
+		return fmt.Errorf("zabbix requires datasource and host")
+	}
+	for _, subnet := range node.Subnets {
+		if _, err := netip.ParsePrefix(subnet); err != nil {
+			return fmt.Errorf("invalid subnet: %w", err)
+		}
+	}
+	if err := validateInfoURL(node.InfoURL); err != nil {
+		return err
+	}
+	switch node.DNSLabel {
+	case "":
+	case DNSLabelFQDN, DNSLabelShort:
+		if node.ManagementIP == "" {
+			return fmt.Errorf("dns_label requires management_ip")
+		}
+	default:
+		return fmt.Errorf("invalid dns_label: '%s', must be '%s' or '%s'", node.DNSLabel, DNSLabelFQDN, DNSLabelShort)
+	}
+	return nil
+}
+
+func validateInfoURL(value string) error {
+	if value == "" {
+		return nil
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid info_url: '%s', must be an absolute http(s) URL", value)
+	}
 	return nil
 }
 
 var bandwidthParserRegex = regexp.MustCompile(`^(\d+)(M|G|T)$`)
 
+var hostnameRegex = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)*\.?$`)
+
 func validateBandwidth(bandwidth string) error {
 	if !bandwidthParserRegex.MatchString(bandwidth) {
 		return fmt.Errorf("invalid bandwidth format: '%s', must be like '100M', '1G' or '1T'", bandwidth)
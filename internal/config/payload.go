@@ -0,0 +1,219 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of API payloads with a schema, see PayloadSchema
+const (
+	PayloadMap  = "map"
+	PayloadNode = "node"
+	PayloadLink = "link"
+)
+
+var payloadTypes = map[string]reflect.Type{
+	PayloadMap:  reflect.TypeOf(Map{}),
+	PayloadNode: reflect.TypeOf(Node{}),
+	PayloadLink: reflect.TypeOf(Link{}),
+}
+
+var payloadSchemas = sync.OnceValue(func() map[string]map[string]any {
+	schemas := make(map[string]map[string]any, len(payloadTypes))
+	for kind, t := range payloadTypes {
+		g := &schemaGenerator{defs: make(map[string]any), tag: "json"}
+		root := g.structSchema(t)
+		root["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+		root["$id"] = PayloadSchemaURL(kind)
+		root["title"] = "go-weathermap " + kind + " payload"
+		root["$defs"] = g.defs
+		schemas[kind] = root
+	}
+	return schemas
+})
+
+// PayloadSchemaPrefix is where the schemas of payloads are served, see PayloadSchemaURL
+const PayloadSchemaPrefix = "/schema/payloads/"
+
+// PayloadSchemaURL is where the schema of a kind of payload is served
+func PayloadSchemaURL(kind string) string {
+	return PayloadSchemaPrefix + kind + ".json"
+}
+
+// PayloadSchema returns the JSON Schema (draft 2020-12) of the JSON bodies of the API
+// creating a map, a node or a link, nil for other kinds. Unlike MapSchema it follows the
+// json tags, the keys of API requests.
+func PayloadSchema(kind string) map[string]any {
+	return payloadSchemas()[kind]
+}
+
+// FieldError is a problem with one field of a payload, Field is its path like
+// links[2].bandwidth
+type FieldError struct {
+	Field string `json:"field"`
+	Error string `json:"error"`
+}
+
+// PayloadError lists every field of a payload not matching its schema
+type PayloadError struct {
+	Fields []FieldError
+}
+
+func (e *PayloadError) Error() string {
+	first := e.Fields[0]
+	if len(e.Fields) == 1 {
+		return fmt.Sprintf("invalid payload: %s %s", first.Field, first.Error)
+	}
+	return fmt.Sprintf("invalid payload: %s %s, and %d more", first.Field, first.Error, len(e.Fields)-1)
+}
+
+// ValidatePayload checks a decoded JSON body against the schema of its kind, before it is
+// decoded into the map structs. A partial payload, the fields of a PATCH, may leave required
+// fields out. The error is a *PayloadError.
+func ValidatePayload(kind string, payload any, partial bool) error {
+	schema := PayloadSchema(kind)
+	if schema == nil {
+		return fmt.Errorf("invalid payload: unknown kind %s", kind)
+	}
+	v := &payloadValidator{defs: schema["$defs"].(map[string]any)}
+	if partial {
+		schema = maps.Clone(schema)
+		delete(schema, "required")
+	}
+	v.validate("", schema, payload)
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return &PayloadError{Fields: v.errs}
+}
+
+type payloadValidator struct {
+	defs map[string]any
+	errs []FieldError
+}
+
+func (v *payloadValidator) fail(field, format string, args ...any) {
+	field = strings.TrimPrefix(field, ".")
+	if field == "" {
+		field = "payload"
+	}
+	v.errs = append(v.errs, FieldError{Field: field, Error: fmt.Sprintf(format, args...)})
+}
+
+func (v *payloadValidator) validate(field string, schema map[string]any, value any) {
+	if ref, ok := schema["$ref"].(string); ok {
+		def, _ := v.defs[strings.TrimPrefix(ref, "#/$defs/")].(map[string]any)
+		schema = def
+	}
+	if value == nil || schema == nil {
+		return // null leaves the field unset, like encoding/json
+	}
+	if types := schemaTypes(schema["type"]); len(types) > 0 && !slices.ContainsFunc(types, func(t string) bool { return matchesType(t, value) }) {
+		v.fail(field, "must be %s", strings.Join(types, " or "))
+		return
+	}
+	if min, ok := schema["minimum"].(int); ok {
+		if n, ok := value.(float64); ok && n < float64(min) {
+			v.fail(field, "must be at least %d", min)
+		}
+	}
+	if schema["format"] == "date-time" {
+		if s, ok := value.(string); ok {
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				v.fail(field, "must be a RFC 3339 date-time")
+			}
+		}
+	}
+
+	switch value := value.(type) {
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range value {
+				v.validate(fmt.Sprintf("%s[%d]", field, i), items, item)
+			}
+		}
+	case map[string]any:
+		v.validateObject(field, schema, value)
+	}
+}
+
+func (v *payloadValidator) validateObject(field string, schema map[string]any, value map[string]any) {
+	properties, _ := schema["properties"].(map[string]any)
+	seen := make(map[string]bool, len(value))
+	for _, key := range slices.Sorted(maps.Keys(value)) {
+		name := propertyName(properties, key)
+		if property, ok := properties[name].(map[string]any); ok {
+			seen[name] = true
+			v.validate(field+"."+name, property, value[key])
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				v.fail(field+"."+key, "is not a known field")
+			}
+		case map[string]any:
+			v.validate(field+"."+key, additional, value[key])
+		}
+	}
+	required, _ := schema["required"].([]string)
+	for _, name := range required {
+		if !seen[name] {
+			v.fail(field+"."+name, "is required")
+		}
+	}
+}
+
+// propertyName matches a key with a property of the schema like encoding/json matches it
+// with a struct field: exactly, else without case
+func propertyName(properties map[string]any, key string) string {
+	if _, ok := properties[key]; ok {
+		return key
+	}
+	for name := range properties {
+		if strings.EqualFold(name, key) {
+			return name
+		}
+	}
+	return key
+}
+
+func schemaTypes(t any) []string {
+	switch t := t.(type) {
+	case string:
+		return []string{t}
+	case []string:
+		return t
+	}
+	return nil
+}
+
+func matchesType(t string, value any) bool {
+	switch t {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+const MapSchemaURL = "/schema/map.json"
+
+// required fields and minimums checked by Parser.Validate, the rest of the schema
+// is derived from the yaml tags so it follows changes of the structs
+var (
+	schemaRequired = map[string][]string{
+		"Map":              {"width", "height"},
+		"Node":             {"name"},
+		"Link":             {"name", "from", "to"},
+		"DataSourceConfig": {"name", "type"},
+		"InterfaceConfig":  {"name"},
+		"Demand":           {"from", "to", "rate"},
+	}
+	schemaMinimum = map[string]map[string]int{
+		"Map": {"width": 1, "height": 1},
+	}
+)
+
+// MapSchema returns the JSON Schema (draft 2020-12) of a map YAML document
+func MapSchema() map[string]any {
+	g := &schemaGenerator{defs: make(map[string]any), tag: "yaml"}
+	root := g.structSchema(reflect.TypeOf(Map{}))
+	root["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	root["$id"] = MapSchemaURL
+	root["title"] = "go-weathermap map"
+	root["$defs"] = g.defs
+	return root
+}
+
+type schemaGenerator struct {
+	defs map[string]any
+	tag  string // yaml for map documents, json for API payloads
+}
+
+func (g *schemaGenerator) typeSchema(t reflect.Type) map[string]any {
+	if t == reflect.TypeOf(time.Duration(0)) {
+		if g.tag == "json" {
+			return map[string]any{"type": "integer", "description": "duration in nanoseconds"}
+		}
+		return map[string]any{"type": []string{"string", "integer"}, "description": "duration like 30s or nanoseconds"}
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.typeSchema(t.Elem())
+	case reflect.Struct:
+		if _, ok := g.defs[t.Name()]; !ok {
+			g.defs[t.Name()] = nil // recursion guard
+			g.defs[t.Name()] = g.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/$defs/" + t.Name()}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.typeSchema(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	default:
+		return map[string]any{} // interface{}: anything
+	}
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	schema := map[string]any{"type": "object", "properties": properties, "additionalProperties": false}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get(g.tag), ",")
+		if name == "-" {
+			continue
+		}
+		if strings.Contains(opts, "inline") && g.tag == "yaml" {
+			// inline params maps (datasource and interface params) accept any extra key
+			schema["additionalProperties"] = g.typeSchema(field.Type.Elem())
+			continue
+		}
+		if name == "" {
+			// yaml.v3 default, encoding/json matches the field name without case
+			name = strings.ToLower(field.Name)
+		}
+		property := g.typeSchema(field.Type)
+		if min, ok := schemaMinimum[t.Name()][name]; ok {
+			property["minimum"] = min
+		}
+		properties[name] = property
+	}
+	if required, ok := schemaRequired[t.Name()]; ok {
+		schema["required"] = required
+	}
+	return schema
+}
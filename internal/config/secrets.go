@@ -0,0 +1,153 @@
+package config
+
+import (
+	"encoding/json"
+	"path"
+	"strings"
+	"sync"
+)
+
+// SecretMask replaces the values of secret variables in API responses. Writing it back as
+// the value of a secret keeps the stored one.
+const SecretMask = "********"
+
+// DefaultSecretPatterns match the names of variables holding credentials
+var DefaultSecretPatterns = []string{"*password*", "*passwd*", "*secret*", "*token*", "*community*", "*api_key*", "*apikey*"}
+
+var (
+	secretMu       sync.RWMutex
+	secretPatterns = DefaultSecretPatterns
+)
+
+// SetSecretPatterns replaces the glob patterns of secret variable names, matched without case
+func SetSecretPatterns(patterns []string) {
+	secretMu.Lock()
+	defer secretMu.Unlock()
+	secretPatterns = patterns
+}
+
+// IsSecret reports whether a variable holds a secret
+func IsSecret(name string) bool {
+	secretMu.RLock()
+	defer secretMu.RUnlock()
+	name = strings.ToLower(name)
+	for _, pattern := range secretPatterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Variables are the variables of a map by name. Secrets are stored as they are, or encrypted
+// with a VariableCipher, and masked in JSON, the encoding of API responses.
+type Variables map[string]string
+
+func (v Variables) MarshalJSON() ([]byte, error) {
+	if v == nil {
+		return []byte("null"), nil
+	}
+	return json.Marshal(v.Masked())
+}
+
+// Masked returns a copy of the variables with the values of secrets replaced by SecretMask
+func (v Variables) Masked() map[string]string {
+	masked := make(map[string]string, len(v))
+	for name, value := range v {
+		if IsSecret(name) && value != "" {
+			value = SecretMask
+		}
+		masked[name] = value
+	}
+	return masked
+}
+
+// KeepSecrets sets the secrets given as SecretMask back to their value in previous, so a map
+// read from the API can be written back as it is
+func (v Variables) KeepSecrets(previous Variables) {
+	for name, value := range v {
+		if value != SecretMask || !IsSecret(name) {
+			continue
+		}
+		if old, ok := previous[name]; ok {
+			v[name] = old
+		}
+	}
+}
+
+// Masked returns a copy of the receiver with its bot token and URL replaced by SecretMask,
+// webhook and Slack URLs carry their own credentials
+func (r AlertReceiver) Masked() AlertReceiver {
+	if r.BotToken != "" {
+		r.BotToken = SecretMask
+	}
+	if r.URL != "" {
+		r.URL = SecretMask
+	}
+	return r
+}
+
+func (r AlertReceiver) MarshalJSON() ([]byte, error) {
+	type receiver AlertReceiver // without this method
+	return json.Marshal(receiver(r.Masked()))
+}
+
+// KeepReceiverSecrets sets the bot tokens and URLs given as SecretMask back to the ones of
+// the receiver of the same name in previous
+func KeepReceiverSecrets(receivers, previous []AlertReceiver) {
+	for i, r := range receivers {
+		for _, old := range previous {
+			if old.Name != r.Name {
+				continue
+			}
+			if r.BotToken == SecretMask {
+				receivers[i].BotToken = old.BotToken
+			}
+			if r.URL == SecretMask {
+				receivers[i].URL = old.URL
+			}
+		}
+	}
+}
+
+// Masked returns a copy of the datasource with the values of its secret params, like the
+// Prometheus bearer_token or the SNMP community, replaced by SecretMask
+func (ds DataSourceConfig) Masked() DataSourceConfig {
+	if ds.Params == nil {
+		return ds
+	}
+	params := make(map[string]interface{}, len(ds.Params))
+	for name, value := range ds.Params {
+		if s, ok := value.(string); ok && s != "" && IsSecret(name) {
+			value = SecretMask
+		}
+		params[name] = value
+	}
+	ds.Params = params
+	return ds
+}
+
+func (ds DataSourceConfig) MarshalJSON() ([]byte, error) {
+	type dataSource DataSourceConfig // without this method
+	return json.Marshal(dataSource(ds.Masked()))
+}
+
+// KeepDataSourceSecrets sets the secret params given as SecretMask back to the ones of the
+// datasource of the same name in previous
+func KeepDataSourceSecrets(datasources, previous []DataSourceConfig) {
+	for _, ds := range datasources {
+		for _, old := range previous {
+			if old.Name != ds.Name {
+				continue
+			}
+			for name, value := range ds.Params {
+				if value != SecretMask || !IsSecret(name) {
+					continue
+				}
+				if stored, ok := old.Params[name]; ok {
+					ds.Params[name] = stored
+				}
+			}
+		}
+	}
+}
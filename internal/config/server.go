@@ -0,0 +1,183 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultServerConfigFile is read at startup when it exists and no other file is given
+const DefaultServerConfigFile = "weathermap.yaml"
+
+// ServerConfig is the server configuration file. Every setting has an environment variable,
+// which wins over the file, so the file only fills in the variables left unset.
+type ServerConfig struct {
+	Listen          string             `yaml:"listen"`
+	MapsDir         string             `yaml:"maps_dir"`
+	IconsDir        string             `yaml:"icons_dir"`
+	RRDDir          string             `yaml:"rrd_dir"`            // the only directory rrd datasources read from
+	MaxBodySize     int64              `yaml:"max_body_size"`      // bytes
+	MaxBulkBodySize int64              `yaml:"max_bulk_body_size"` // bytes, bulk edits and whole maps
+	Log             ServerLogConfig    `yaml:"log"`
+	Auth            ServerAuthConfig   `yaml:"auth"`
+	TLS             ServerTLSConfig    `yaml:"tls"`
+	Poll            ServerPollConfig   `yaml:"poll"`
+	NetBox          ServerNetBoxConfig `yaml:"netbox"`
+	Env             map[string]string  `yaml:"env"` // any other WEATHERMAP_ variable
+}
+
+type ServerLogConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+type ServerAuthConfig struct {
+	OIDC struct {
+		Issuer   string `yaml:"issuer"`
+		Audience string `yaml:"audience"`
+		JWKSURL  string `yaml:"jwks_url"`
+	} `yaml:"oidc"`
+	MetricsToken string            `yaml:"metrics_token"`
+	AgentTokens  map[string]string `yaml:"agent_tokens"` // agent name -> push token
+}
+
+type ServerTLSConfig struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
+	ClientAuth   string `yaml:"client_auth"`
+}
+
+type ServerPollConfig struct {
+	Interval        string `yaml:"interval"`        // of datasources without poll_interval
+	ReloadInterval  string `yaml:"reload_interval"` // of the datasource rescan
+	MaxSNMPSessions int    `yaml:"max_snmp_sessions"`
+	MaxWorkers      int    `yaml:"max_workers"`
+}
+
+type ServerNetBoxConfig struct {
+	URL          string `yaml:"url"`
+	Token        string `yaml:"token"`
+	SyncInterval string `yaml:"sync_interval"` // of the maps with a netbox section, 0 syncs on request only
+}
+
+// LoadServerConfig reads and validates a server configuration file, unknown keys are errors
+func LoadServerConfig(path string) (*ServerConfig, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	var cfg ServerConfig
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("invalid server config %s: %w", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid server config %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+func (c *ServerConfig) Validate() error {
+	if c.MaxBodySize < 0 {
+		return fmt.Errorf("max_body_size must not be negative")
+	}
+	if c.MaxBulkBodySize < 0 {
+		return fmt.Errorf("max_bulk_body_size must not be negative")
+	}
+	for key, value := range map[string]string{"poll.interval": c.Poll.Interval, "poll.reload_interval": c.Poll.ReloadInterval, "netbox.sync_interval": c.NetBox.SyncInterval} {
+		if value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(value); err != nil || d < 0 {
+			return fmt.Errorf("invalid %s: %s", key, value)
+		}
+	}
+	for name, token := range c.Auth.AgentTokens {
+		if name == "" || token == "" || strings.ContainsAny(name+token, ":,") {
+			return fmt.Errorf("invalid agent token of '%s'", name)
+		}
+	}
+	for variable := range c.Env {
+		if !strings.HasPrefix(variable, "WEATHERMAP_") {
+			return fmt.Errorf("env: %s is not a WEATHERMAP_ variable", variable)
+		}
+	}
+	return nil
+}
+
+// Variables returns the environment variables set by the file
+func (c *ServerConfig) Variables() map[string]string {
+	vars := make(map[string]string, len(c.Env))
+	for variable, value := range c.Env {
+		vars[variable] = value
+	}
+	set := func(variable, value string) {
+		if value != "" {
+			vars[variable] = value
+		}
+	}
+	count := func(value int64) string {
+		if value == 0 {
+			return ""
+		}
+		return strconv.FormatInt(value, 10)
+	}
+	set("WEATHERMAP_LISTEN_ADDR", c.Listen)
+	set("WEATHERMAP_MAPS_DIR", c.MapsDir)
+	set("WEATHERMAP_ICONS_DIR", c.IconsDir)
+	set("WEATHERMAP_RRD_DIR", c.RRDDir)
+	set("WEATHERMAP_MAX_BODY_SIZE", count(c.MaxBodySize))
+	set("WEATHERMAP_MAX_BULK_BODY_SIZE", count(c.MaxBulkBodySize))
+	set("WEATHERMAP_LOG_LEVEL", c.Log.Level)
+	set("WEATHERMAP_LOG_FORMAT", c.Log.Format)
+	set("WEATHERMAP_OIDC_ISSUER", c.Auth.OIDC.Issuer)
+	set("WEATHERMAP_OIDC_AUDIENCE", c.Auth.OIDC.Audience)
+	set("WEATHERMAP_OIDC_JWKS_URL", c.Auth.OIDC.JWKSURL)
+	set("WEATHERMAP_METRICS_TOKEN", c.Auth.MetricsToken)
+	set("WEATHERMAP_TLS_CERT_FILE", c.TLS.CertFile)
+	set("WEATHERMAP_TLS_KEY_FILE", c.TLS.KeyFile)
+	set("WEATHERMAP_TLS_CLIENT_CA_FILE", c.TLS.ClientCAFile)
+	set("WEATHERMAP_TLS_CLIENT_AUTH", c.TLS.ClientAuth)
+	set("WEATHERMAP_POLL_INTERVAL", c.Poll.Interval)
+	set("WEATHERMAP_RELOAD_INTERVAL", c.Poll.ReloadInterval)
+	set("WEATHERMAP_MAX_SNMP_SESSIONS", count(int64(c.Poll.MaxSNMPSessions)))
+	set("WEATHERMAP_MAX_POLLER_WORKERS", count(int64(c.Poll.MaxWorkers)))
+	set("WEATHERMAP_NETBOX_URL", c.NetBox.URL)
+	set("WEATHERMAP_NETBOX_TOKEN", c.NetBox.Token)
+	set("WEATHERMAP_NETBOX_SYNC_INTERVAL", c.NetBox.SyncInterval)
+	if len(c.Auth.AgentTokens) > 0 {
+		pairs := make([]string, 0, len(c.Auth.AgentTokens))
+		for name, token := range c.Auth.AgentTokens {
+			pairs = append(pairs, name+":"+token)
+		}
+		sort.Strings(pairs)
+		set("WEATHERMAP_AGENT_TOKENS", strings.Join(pairs, ","))
+	}
+	return vars
+}
+
+// SetEnvDefaults sets the variables of the file which aren't set in the environment, and
+// returns their names
+func (c *ServerConfig) SetEnvDefaults() ([]string, error) {
+	var applied []string
+	for variable, value := range c.Variables() {
+		if _, ok := os.LookupEnv(variable); ok {
+			continue
+		}
+		if err := os.Setenv(variable, value); err != nil {
+			return applied, err
+		}
+		applied = append(applied, variable)
+	}
+	sort.Strings(applied)
+	return applied, nil
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestServerConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "weathermap.yaml")
+	content := `listen: ":9090"
+maps_dir: /etc/weathermap/maps
+max_body_size: 4194304
+max_bulk_body_size: 33554432
+log:
+  level: debug
+auth:
+  oidc:
+    issuer: https://sso.example.com
+    audience: weathermap
+  agent_tokens:
+    dc2: secret2
+    dc1: secret1
+poll:
+  interval: 10s
+netbox:
+  url: https://netbox.example.com
+  sync_interval: 1h
+env:
+  WEATHERMAP_SANDBOX: "true"
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadServerConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load server config: %v", err)
+	}
+	vars := cfg.Variables()
+	expected := map[string]string{
+		"WEATHERMAP_LISTEN_ADDR":          ":9090",
+		"WEATHERMAP_MAPS_DIR":             "/etc/weathermap/maps",
+		"WEATHERMAP_MAX_BODY_SIZE":        "4194304",
+		"WEATHERMAP_MAX_BULK_BODY_SIZE":   "33554432",
+		"WEATHERMAP_LOG_LEVEL":            "debug",
+		"WEATHERMAP_OIDC_ISSUER":          "https://sso.example.com",
+		"WEATHERMAP_OIDC_AUDIENCE":        "weathermap",
+		"WEATHERMAP_AGENT_TOKENS":         "dc1:secret1,dc2:secret2",
+		"WEATHERMAP_POLL_INTERVAL":        "10s",
+		"WEATHERMAP_NETBOX_URL":           "https://netbox.example.com",
+		"WEATHERMAP_NETBOX_SYNC_INTERVAL": "1h",
+		"WEATHERMAP_SANDBOX":              "true",
+	}
+	for variable, value := range expected {
+		if vars[variable] != value {
+			t.Errorf("Expected %s=%s, got %q", variable, value, vars[variable])
+		}
+	}
+	if _, ok := vars["WEATHERMAP_LOG_FORMAT"]; ok {
+		t.Error("Expected unset settings to leave their variable alone")
+	}
+
+	// the environment wins over the file
+	t.Setenv("WEATHERMAP_LISTEN_ADDR", ":8443")
+	for variable := range expected {
+		if variable != "WEATHERMAP_LISTEN_ADDR" {
+			t.Setenv(variable, "") // restored after the test
+			_ = os.Unsetenv(variable)
+		}
+	}
+	applied, err := cfg.SetEnvDefaults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slices.Contains(applied, "WEATHERMAP_LISTEN_ADDR") || os.Getenv("WEATHERMAP_LISTEN_ADDR") != ":8443" {
+		t.Errorf("Expected the environment to override the file, got %s", os.Getenv("WEATHERMAP_LISTEN_ADDR"))
+	}
+	if os.Getenv("WEATHERMAP_LOG_LEVEL") != "debug" || len(applied) != len(expected)-1 {
+		t.Errorf("Expected the other variables to be set from the file, got %v", applied)
+	}
+
+	for _, invalid := range []string{"listen: :80\nlisten_port: 80\n", "poll:\n  interval: often\n", "netbox:\n  sync_interval: daily\n", "env:\n  PATH: /tmp\n"} {
+		if err := os.WriteFile(path, []byte(invalid), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadServerConfig(path); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
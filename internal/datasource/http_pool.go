@@ -0,0 +1,92 @@
+package datasource
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// HTTPPoolConfig sizes the connection pool shared by HTTP based clients (Zabbix, Prometheus)
+type HTTPPoolConfig struct {
+	MaxIdleConns        int           `json:"max_idle_conns"`
+	MaxIdleConnsPerHost int           `json:"max_idle_conns_per_host"`
+	MaxConnsPerHost     int           `json:"max_conns_per_host"`
+	IdleConnTimeout     time.Duration `json:"idle_conn_timeout"`
+	Timeout             time.Duration `json:"timeout"`
+}
+
+func DefaultHTTPPoolConfig() HTTPPoolConfig {
+	return HTTPPoolConfig{
+		MaxIdleConns:        64,
+		MaxIdleConnsPerHost: 8,
+		MaxConnsPerHost:     16,
+		IdleConnTimeout:     90 * time.Second,
+		Timeout:             10 * time.Second,
+	}
+}
+
+func (c HTTPPoolConfig) Validate() error {
+	if c.MaxIdleConns < 0 || c.MaxIdleConnsPerHost < 0 || c.MaxConnsPerHost < 0 {
+		return fmt.Errorf("http pool sizes must not be negative")
+	}
+	if c.MaxConnsPerHost > 0 && c.MaxIdleConnsPerHost > c.MaxConnsPerHost {
+		return fmt.Errorf("max_idle_conns_per_host (%d) exceeds max_conns_per_host (%d)", c.MaxIdleConnsPerHost, c.MaxConnsPerHost)
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("http timeout must be greater than 0")
+	}
+	return nil
+}
+
+// MarshalJSON prints timeouts as duration strings ("90s") instead of nanoseconds
+func (c HTTPPoolConfig) MarshalJSON() ([]byte, error) {
+	type pool HTTPPoolConfig
+	return json.Marshal(struct {
+		pool
+		IdleConnTimeout string `json:"idle_conn_timeout"`
+		Timeout         string `json:"timeout"`
+	}{pool(c), c.IdleConnTimeout.String(), c.Timeout.String()})
+}
+
+func (c *HTTPPoolConfig) UnmarshalJSON(data []byte) error {
+	type pool HTTPPoolConfig
+	aux := struct {
+		*pool
+		IdleConnTimeout string `json:"idle_conn_timeout"`
+		Timeout         string `json:"timeout"`
+	}{pool: (*pool)(c)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	for _, d := range []struct {
+		value  string
+		target *time.Duration
+	}{{aux.IdleConnTimeout, &c.IdleConnTimeout}, {aux.Timeout, &c.Timeout}} {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil {
+			return fmt.Errorf("invalid duration: %s", d.value)
+		}
+		*d.target = parsed
+	}
+	return nil
+}
+
+func NewHTTPClient(cfg HTTPPoolConfig) *http.Client {
+	return &http.Client{
+		Timeout: cfg.Timeout,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			DialContext:         (&net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+			MaxIdleConns:        cfg.MaxIdleConns,
+			MaxIdleConnsPerHost: cfg.MaxIdleConnsPerHost,
+			MaxConnsPerHost:     cfg.MaxConnsPerHost,
+			IdleConnTimeout:     cfg.IdleConnTimeout,
+			TLSHandshakeTimeout: 5 * time.Second,
+		},
+	}
+}
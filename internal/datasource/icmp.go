@@ -0,0 +1,121 @@
+package datasource
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	icmpEchoRequest   = 8
+	icmpEchoReply     = 0
+	icmpv6EchoRequest = 128
+	icmpv6EchoReply   = 129
+	icmpPayloadSize   = 16
+)
+
+// icmpSeq numbers echo requests of the process, replies are matched on the identifier and
+// the sequence since every raw socket receives all the ICMP packets of the host
+var icmpSeq atomic.Uint32
+
+// Ping sends an ICMP echo request to host, an IP address or a DNS name, and returns the round
+// trip time of the reply. It needs raw sockets: root or the CAP_NET_RAW capability.
+func Ping(ctx context.Context, host string, timeout time.Duration) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ip, err := resolvePingHost(ctx, host)
+	if err != nil {
+		return 0, err
+	}
+
+	network, request, reply := "ip4:icmp", byte(icmpEchoRequest), byte(icmpEchoReply)
+	if ip.To4() == nil {
+		network, request, reply = "ip6:ipv6-icmp", icmpv6EchoRequest, icmpv6EchoReply
+	}
+	conn, err := net.ListenPacket(network, "")
+	if err != nil {
+		return 0, fmt.Errorf("icmp socket: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return 0, err
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	id, seq := uint16(os.Getpid()), uint16(icmpSeq.Add(1))
+	sent := time.Now()
+	if _, err := conn.WriteTo(echoMessage(request, id, seq), &net.IPAddr{IP: ip}); err != nil {
+		return 0, fmt.Errorf("icmp echo to %s: %w", ip, err)
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return 0, fmt.Errorf("no echo reply from %s within %s", ip, timeout)
+			}
+			return 0, err
+		}
+		addr, ok := peer.(*net.IPAddr)
+		if !ok || !addr.IP.Equal(ip) || n < 8 {
+			continue
+		}
+		if buf[0] == reply && binary.BigEndian.Uint16(buf[4:]) == id && binary.BigEndian.Uint16(buf[6:]) == seq {
+			return time.Since(sent), nil
+		}
+	}
+}
+
+func resolvePingHost(ctx context.Context, host string) (net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip, nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	// IPv4 first, like most of the management networks
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			return addr.IP, nil
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no address for %s", host)
+	}
+	return addrs[0].IP, nil
+}
+
+// echoMessage builds an echo request, the kernel computes the checksum of ICMPv6 itself
+func echoMessage(typ byte, id, seq uint16) []byte {
+	msg := make([]byte, 8+icmpPayloadSize)
+	msg[0] = typ
+	binary.BigEndian.PutUint16(msg[4:], id)
+	binary.BigEndian.PutUint16(msg[6:], seq)
+	binary.BigEndian.PutUint64(msg[8:], uint64(time.Now().UnixNano()))
+	if typ == icmpEchoRequest {
+		binary.BigEndian.PutUint16(msg[2:], icmpChecksum(msg))
+	}
+	return msg
+}
+
+func icmpChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
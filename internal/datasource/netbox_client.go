@@ -0,0 +1,199 @@
+package datasource
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go-weathermap/internal/tracing"
+)
+
+const (
+	maxNetBoxResponseSize = 16 << 20
+	netBoxPageSize        = 1000
+	maxNetBoxPages        = 100
+)
+
+// NetBoxClient reads devices, sites and cabled interfaces from the REST API of NetBox 3.x or 4.x
+type NetBoxClient struct {
+	httpClient *http.Client
+	baseURL    string
+	token      string
+}
+
+func NewNetBoxClient(httpClient *http.Client, baseURL, token string) *NetBoxClient {
+	return &NetBoxClient{httpClient: httpClient, baseURL: strings.TrimRight(baseURL, "/"), token: token}
+}
+
+// NetBoxRef is a nested object of NetBox, like the site of a device
+type NetBoxRef struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Slug string `json:"slug,omitempty"`
+}
+
+type NetBoxDevice struct {
+	ID      int       `json:"id"`
+	Name    string    `json:"name"`
+	Site    NetBoxRef `json:"site"`
+	Address string    `json:"-"` // primary IP, without the prefix length
+}
+
+// NetBoxInterface is a cabled interface. Connected holds the interfaces at the far end of the
+// cable path, through patch panels and circuits.
+type NetBoxInterface struct {
+	ID        int               `json:"id"`
+	Name      string            `json:"name"`
+	Device    NetBoxRef         `json:"device"`
+	Speed     int64             `json:"speed"` // kbps, 0 when unknown
+	Cable     *NetBoxRef        `json:"cable"`
+	Circuit   *NetBoxRef        `json:"-"` // of a circuit termination peer, Name is the circuit id
+	Connected []NetBoxInterface `json:"-"`
+}
+
+type netBoxPage struct {
+	Next    string            `json:"next"`
+	Results []json.RawMessage `json:"results"`
+}
+
+// NetBoxFilter selects objects by slug, empty fields match all
+type NetBoxFilter struct {
+	Sites []string
+	Roles []string
+	Tags  []string
+}
+
+func (f NetBoxFilter) query() url.Values {
+	query := url.Values{}
+	for _, site := range f.Sites {
+		query.Add("site", site)
+	}
+	for _, role := range f.Roles {
+		query.Add("role", role)
+	}
+	for _, tag := range f.Tags {
+		query.Add("tag", tag)
+	}
+	return query
+}
+
+// Devices returns the devices matching filter
+func (c *NetBoxClient) Devices(ctx context.Context, filter NetBoxFilter) ([]NetBoxDevice, error) {
+	var devices []NetBoxDevice
+	err := c.list(ctx, "/api/dcim/devices/", filter.query(), func(raw json.RawMessage) error {
+		var device NetBoxDevice
+		if err := json.Unmarshal(raw, &device); err != nil {
+			return err
+		}
+		var primary struct {
+			PrimaryIP *struct {
+				Address string `json:"address"`
+			} `json:"primary_ip"`
+		}
+		if err := json.Unmarshal(raw, &primary); err == nil && primary.PrimaryIP != nil {
+			device.Address, _, _ = strings.Cut(primary.PrimaryIP.Address, "/")
+		}
+		devices = append(devices, device)
+		return nil
+	})
+	return devices, err
+}
+
+// CabledInterfaces returns the cabled interfaces of the devices in sites, all sites when empty
+func (c *NetBoxClient) CabledInterfaces(ctx context.Context, sites []string) ([]NetBoxInterface, error) {
+	query := NetBoxFilter{Sites: sites}.query()
+	query.Set("cabled", "true")
+	var interfaces []NetBoxInterface
+	err := c.list(ctx, "/api/dcim/interfaces/", query, func(raw json.RawMessage) error {
+		var iface NetBoxInterface
+		if err := json.Unmarshal(raw, &iface); err != nil {
+			return err
+		}
+		var peers struct {
+			LinkPeers []struct {
+				Circuit *struct {
+					ID  int    `json:"id"`
+					CID string `json:"cid"`
+				} `json:"circuit"`
+			} `json:"link_peers"`
+			ConnectedType string            `json:"connected_endpoints_type"`
+			Connected     []NetBoxInterface `json:"connected_endpoints"`
+		}
+		if err := json.Unmarshal(raw, &peers); err != nil {
+			return err
+		}
+		if len(peers.LinkPeers) > 0 && peers.LinkPeers[0].Circuit != nil {
+			iface.Circuit = &NetBoxRef{ID: peers.LinkPeers[0].Circuit.ID, Name: peers.LinkPeers[0].Circuit.CID}
+		}
+		if peers.ConnectedType == "dcim.interface" {
+			iface.Connected = peers.Connected
+		}
+		interfaces = append(interfaces, iface)
+		return nil
+	})
+	return interfaces, err
+}
+
+// list reads every page of a list endpoint, following the next links of NetBox
+func (c *NetBoxClient) list(ctx context.Context, path string, query url.Values, each func(json.RawMessage) error) (err error) {
+	ctx, span := tracing.StartClient(ctx, "netbox GET "+path)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	query.Set("limit", fmt.Sprint(netBoxPageSize))
+	next := c.baseURL + path + "?" + query.Encode()
+	for pages := 0; next != ""; pages++ {
+		if pages == maxNetBoxPages {
+			return fmt.Errorf("netbox %s: more than %d pages", path, maxNetBoxPages)
+		}
+		var page netBoxPage
+		if err := c.get(ctx, next, &page); err != nil {
+			return err
+		}
+		for _, raw := range page.Results {
+			if err := each(raw); err != nil {
+				return fmt.Errorf("netbox %s decode error: %w", path, err)
+			}
+		}
+		next = page.Next
+	}
+	return nil
+}
+
+func (c *NetBoxClient) get(ctx context.Context, endpoint string, result any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("netbox request error: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Token "+c.token)
+	}
+	tracing.Inject(ctx, req.Header)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("netbox request error: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }() // the body is read in full, nothing is lost
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxNetBoxResponseSize))
+	if err != nil {
+		return fmt.Errorf("netbox read error: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var detail struct {
+			Detail string `json:"detail"`
+		}
+		_ = json.Unmarshal(data, &detail)
+		return fmt.Errorf("netbox returned %s %s", resp.Status, detail.Detail)
+	}
+	if err := json.Unmarshal(data, result); err != nil {
+		return fmt.Errorf("netbox returned %s: invalid response", resp.Status)
+	}
+	return nil
+}
@@ -1,7 +1,185 @@
 package datasource
 
-// PrometheusClient - mock
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
-type PrometheusClient struct{}
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/tracing"
+)
 
-func NewPrometheusClient() *PrometheusClient { return &PrometheusClient{} }
+const maxPrometheusResponseSize = 4 << 20
+
+type PrometheusClient struct {
+	httpClient *http.Client
+}
+
+func NewPrometheusClient(httpClient *http.Client) *PrometheusClient {
+	return &PrometheusClient{httpClient: httpClient}
+}
+
+type prometheusResponse struct {
+	ResultType string          `json:"resultType"`
+	Result     json.RawMessage `json:"result"`
+}
+
+type prometheusSample struct {
+	Metric map[string]string `json:"metric"`
+	Value  [2]any            `json:"value"`
+}
+
+// PrometheusQueryError is a query the server answered without a usable value: no series,
+// several, or a query it refused. Other errors of Query are the server failing.
+type PrometheusQueryError struct {
+	Err string
+}
+
+func (e *PrometheusQueryError) Error() string {
+	return e.Err
+}
+
+// Query runs an instant PromQL query against the datasource "url" and returns a single value.
+// Queries must resolve to a scalar or a vector with exactly one series, aggregate with sum() otherwise.
+func (c *PrometheusClient) Query(ctx context.Context, ds config.DataSourceConfig, query string) (value float64, err error) {
+	ctx, span := tracing.StartClient(ctx, "prometheus query", "datasource", ds.Name, "db.query.text", query)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	var result prometheusResponse
+	if err := c.get(ctx, ds, "/api/v1/query", url.Values{"query": {query}}, &result); err != nil {
+		return 0, err
+	}
+
+	switch result.ResultType {
+	case "scalar":
+		var value [2]any
+		if err := json.Unmarshal(result.Result, &value); err != nil {
+			return 0, fmt.Errorf("prometheus scalar decode error: %w", err)
+		}
+		return parsePrometheusValue(value)
+	case "vector":
+		var samples []prometheusSample
+		if err := json.Unmarshal(result.Result, &samples); err != nil {
+			return 0, fmt.Errorf("prometheus vector decode error: %w", err)
+		}
+		if len(samples) == 0 {
+			return 0, &PrometheusQueryError{Err: fmt.Sprintf("no prometheus data for query %s", query)}
+		}
+		if len(samples) > 1 {
+			return 0, &PrometheusQueryError{Err: fmt.Sprintf("prometheus query %s returned %d series, expected 1", query, len(samples))}
+		}
+		return parsePrometheusValue(samples[0].Value)
+	default:
+		return 0, fmt.Errorf("unsupported prometheus result type: %s", result.ResultType)
+	}
+}
+
+// parsePrometheusValue decodes a [timestamp, "value"] pair
+func parsePrometheusValue(value [2]any) (float64, error) {
+	raw, ok := value[1].(string)
+	if !ok {
+		return 0, fmt.Errorf("invalid prometheus sample value: %v", value[1])
+	}
+	f, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("invalid prometheus sample value: %s", raw)
+	}
+	return f, nil
+}
+
+// PrometheusTarget is an active scrape target of a Prometheus server
+type PrometheusTarget struct {
+	Labels map[string]string `json:"labels"`
+	Health string            `json:"health"` // up, down or unknown
+}
+
+// Targets lists the active targets of the Prometheus server of the datasource
+func (c *PrometheusClient) Targets(ctx context.Context, ds config.DataSourceConfig) (targets []PrometheusTarget, err error) {
+	ctx, span := tracing.StartClient(ctx, "prometheus targets", "datasource", ds.Name)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	var data struct {
+		ActiveTargets []PrometheusTarget `json:"activeTargets"`
+	}
+	if err := c.get(ctx, ds, "/api/v1/targets", url.Values{"state": {"active"}}, &data); err != nil {
+		return nil, err
+	}
+	return data.ActiveTargets, nil
+}
+
+// Series returns the label sets of the series matching any of the selectors
+func (c *PrometheusClient) Series(ctx context.Context, ds config.DataSourceConfig, selectors ...string) (series []map[string]string, err error) {
+	ctx, span := tracing.StartClient(ctx, "prometheus series", "datasource", ds.Name, "selectors", len(selectors))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	if err := c.get(ctx, ds, "/api/v1/series", url.Values{"match[]": selectors}, &series); err != nil {
+		return nil, err
+	}
+	return series, nil
+}
+
+// get calls an endpoint of the HTTP API with the credentials of the datasource and decodes
+// the data of the response into data
+func (c *PrometheusClient) get(ctx context.Context, ds config.DataSourceConfig, path string, params url.Values, data any) error {
+	baseURL, _ := ds.Params["url"].(string)
+	if baseURL == "" {
+		return fmt.Errorf("prometheus datasource %s: url is required", ds.Name)
+	}
+	endpoint := strings.TrimRight(baseURL, "/") + path + "?" + params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("prometheus request error: %w", err)
+	}
+	tracing.Inject(ctx, req.Header)
+	if token, _ := ds.Params["bearer_token"].(string); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if username, _ := ds.Params["username"].(string); username != "" {
+		password, _ := ds.Params["password"].(string)
+		req.SetBasicAuth(username, password)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("prometheus query error: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }() // the body is read in full, nothing is lost
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPrometheusResponseSize))
+	if err != nil {
+		return fmt.Errorf("prometheus read error: %w", err)
+	}
+	var result struct {
+		Status    string          `json:"status"`
+		ErrorType string          `json:"errorType"`
+		Error     string          `json:"error"`
+		Data      json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("prometheus returned %s: invalid response", resp.Status)
+	}
+	if result.Status != "success" {
+		err := fmt.Errorf("prometheus query failed (%s): %s %s", resp.Status, result.ErrorType, result.Error)
+		// bad_data and execution errors are about the query, the server works
+		if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnprocessableEntity {
+			return &PrometheusQueryError{Err: err.Error()}
+		}
+		return err
+	}
+	if err := json.Unmarshal(result.Data, data); err != nil {
+		return fmt.Errorf("prometheus %s decode error: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package datasource
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Layout of the files written by rrdtool on 64-bit hosts, where every long and double of its
+// structs takes 8 bytes
+const (
+	rrdFloatCookie  = 8.642135e130 // written at rrdFloatOffset to detect the byte order
+	rrdFloatOffset  = 16
+	rrdHeaderSize   = 128 // stat_head
+	rrdDSDefSize    = 120 // ds_def
+	rrdRRADefSize   = 120 // rra_def
+	rrdPDPPrepSize  = 112 // pdp_prep, one by data source
+	rrdCDPPrepSize  = 80  // cdp_prep, one by archive and data source
+	rrdNameSize     = 20
+	maxRRDFileSize  = 64 << 20
+	maxRRDDataCount = 1 << 10
+)
+
+// RRDFile is an RRD file of rrdtool, as written for Cacti graphs: its data sources and the
+// archives consolidating them
+type RRDFile struct {
+	Step        time.Duration
+	LastUpdate  time.Time
+	DataSources []string
+
+	order    binary.ByteOrder
+	archives []rrdArchive
+	raw      []byte
+}
+
+type rrdArchive struct {
+	cf       string // AVERAGE, MIN, MAX or LAST
+	pdpCount int    // primary data points by row
+	rows     int
+	curRow   int // the row of the last update
+	offset   int // of the first row in raw
+}
+
+// ReadRRD reads and parses an RRD file
+func ReadRRD(path string) (*RRDFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxRRDFileSize {
+		return nil, fmt.Errorf("rrd file %s is larger than %d bytes", path, maxRRDFileSize)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := ParseRRD(data)
+	if err != nil {
+		return nil, fmt.Errorf("rrd file %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// ParseRRD parses the content of an RRD file of format version 1 to 4. Files written on 32-bit
+// hosts, or exported by rrdtool dump, are refused.
+func ParseRRD(data []byte) (*RRDFile, error) {
+	if len(data) < rrdHeaderSize || !bytes.HasPrefix(data, []byte("RRD\x00")) {
+		return nil, errors.New("not an rrd file")
+	}
+	version := string(bytes.TrimRight(data[4:9], "\x00"))
+	if !slices.Contains([]string{"0001", "0002", "0003", "0004"}, version) {
+		return nil, fmt.Errorf("unsupported rrd version %q", version)
+	}
+	file := &RRDFile{raw: data}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		if math.Float64frombits(order.Uint64(data[rrdFloatOffset:])) == rrdFloatCookie {
+			file.order = order
+		}
+	}
+	if file.order == nil {
+		return nil, errors.New("unsupported rrd layout, only files written on 64-bit hosts can be read")
+	}
+
+	dsCount, rraCount := file.uint(24), file.uint(32)
+	step := file.uint(40)
+	if dsCount == 0 || dsCount > maxRRDDataCount || rraCount == 0 || rraCount > maxRRDDataCount || step == 0 {
+		return nil, errors.New("invalid rrd header")
+	}
+	file.Step = time.Duration(step) * time.Second
+
+	offset := rrdHeaderSize
+	if len(data) < offset+dsCount*rrdDSDefSize+rraCount*rrdRRADefSize {
+		return nil, errors.New("truncated rrd file")
+	}
+	for range dsCount {
+		file.DataSources = append(file.DataSources, cString(data[offset:offset+rrdNameSize]))
+		offset += rrdDSDefSize
+	}
+	for range rraCount {
+		file.archives = append(file.archives, rrdArchive{
+			cf:       cString(data[offset : offset+rrdNameSize]),
+			rows:     file.uint(offset + 24),
+			pdpCount: file.uint(offset + 32),
+		})
+		offset += rrdRRADefSize
+	}
+
+	liveHead := 8
+	if version >= "0003" {
+		liveHead = 16 // last_up and last_up_usec
+	}
+	if len(data) < offset+liveHead {
+		return nil, errors.New("truncated rrd file")
+	}
+	file.LastUpdate = time.Unix(int64(file.uint(offset)), 0).UTC()
+	offset += liveHead + dsCount*rrdPDPPrepSize + rraCount*dsCount*rrdCDPPrepSize
+
+	if len(data) < offset+rraCount*8 {
+		return nil, errors.New("truncated rrd file")
+	}
+	for i := range file.archives {
+		file.archives[i].curRow = file.uint(offset)
+		offset += 8
+	}
+	for i := range file.archives {
+		archive := &file.archives[i]
+		if archive.rows == 0 || archive.pdpCount == 0 || archive.curRow >= archive.rows {
+			return nil, fmt.Errorf("invalid rrd archive %d", i)
+		}
+		archive.offset = offset
+		offset += archive.rows * dsCount * 8
+		if offset > len(data) {
+			return nil, errors.New("truncated rrd file")
+		}
+	}
+	return file, nil
+}
+
+// Last returns the latest known value of a data source from the finest archive consolidating
+// it with cf, rows not filled yet (NaN) are skipped. Its time is the end of the row.
+func (f *RRDFile) Last(dsName, cf string) (float64, time.Time, error) {
+	ds := slices.Index(f.DataSources, dsName)
+	if ds < 0 {
+		return 0, time.Time{}, fmt.Errorf("data source %s not found, the file has %s", dsName, strings.Join(f.DataSources, ", "))
+	}
+	var archive *rrdArchive
+	for i := range f.archives {
+		a := &f.archives[i]
+		if strings.EqualFold(a.cf, cf) && (archive == nil || a.pdpCount < archive.pdpCount) {
+			archive = a
+		}
+	}
+	if archive == nil {
+		return 0, time.Time{}, fmt.Errorf("no %s archive", strings.ToUpper(cf))
+	}
+
+	rowStep := int64(f.Step/time.Second) * int64(archive.pdpCount)
+	rowTime := f.LastUpdate.Unix() - f.LastUpdate.Unix()%rowStep
+	for i := range archive.rows {
+		row := (archive.curRow - i + archive.rows) % archive.rows
+		at := archive.offset + (row*len(f.DataSources)+ds)*8
+		if value := math.Float64frombits(f.order.Uint64(f.raw[at:])); !math.IsNaN(value) {
+			return value, time.Unix(rowTime-int64(i)*rowStep, 0).UTC(), nil
+		}
+	}
+	return 0, time.Time{}, fmt.Errorf("no value for data source %s", dsName)
+}
+
+func (f *RRDFile) uint(offset int) int {
+	return int(min(f.order.Uint64(f.raw[offset:]), math.MaxInt32))
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
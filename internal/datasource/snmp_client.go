@@ -3,10 +3,14 @@ package datasource
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"go-weathermap/internal/config"
+	"go-weathermap/internal/utils"
 
 	"math/big"
 
@@ -18,17 +22,47 @@ type snmpCacheEntry struct {
 	Timestamp time.Time
 }
 
+// DefaultMaxSNMPSessions caps concurrently open SNMP sockets, each Get holds one
+const DefaultMaxSNMPSessions = 128
+
 type SNMPClient struct {
-	cache map[string]snmpCacheEntry // key: host:oid:interface
-	mu    sync.Mutex
+	cache    map[string]snmpCacheEntry // key: host:oid:interface
+	mu       sync.Mutex
+	sessions *utils.Semaphore
+	resolver *Resolver
+	logger   *slog.Logger
 }
 
 func NewSNMPClient() *SNMPClient {
 	return &SNMPClient{
-		cache: make(map[string]snmpCacheEntry),
+		cache:    make(map[string]snmpCacheEntry),
+		sessions: utils.NewSemaphore(DefaultMaxSNMPSessions),
+		resolver: NewResolver(),
+		logger:   slog.Default(),
 	}
 }
 
+// SetLogger replaces the logger of the client and its resolver
+func (c *SNMPClient) SetLogger(logger *slog.Logger) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.logger = logger
+	c.resolver.setLogger(logger)
+}
+
+// SetMaxSessions resizes the session limit, Gets in flight finish against the previous one
+func (c *SNMPClient) SetMaxSessions(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sessions = utils.NewSemaphore(n)
+}
+
+func (c *SNMPClient) SessionUsage() utils.SemaphoreUsage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sessions.Usage()
+}
+
 var globalSNMPClient *SNMPClient
 var once sync.Once
 
@@ -40,45 +74,148 @@ func GetGlobalSNMPClient() *SNMPClient {
 }
 
 func (c *SNMPClient) Get(ctx context.Context, ds config.DataSourceConfig, metricIdentifier string) (interface{}, error) {
-	host, _ := ds.Params["host"].(string)
-	port, _ := ds.Params["port"].(int)
+	values, err := c.GetMany(ctx, ds, []string{metricIdentifier})
+	if err != nil {
+		return nil, err
+	}
+	val, ok := values[metricIdentifier]
+	if !ok {
+		return nil, fmt.Errorf("no SNMP data for OID %s", metricIdentifier)
+	}
+	return val.Value, nil
+}
+
+// SNMPValue is a value read by GetMany. Width is the bit width of Counter32 and Counter64
+// values, 0 for other types. Counter64 values above math.MaxInt64 are negative.
+type SNMPValue struct {
+	Value int64
+	Width uint
+}
+
+// GetMany fetches all oids of one device over a single session, packing up to
+// gosnmp.MaxOids OIDs into each request. OIDs missing on the device are left out of the result.
+func (c *SNMPClient) GetMany(ctx context.Context, ds config.DataSourceConfig, oids []string) (map[string]SNMPValue, error) {
+	g, target, logger, closeSession, err := c.connect(ctx, ds)
+	if err != nil {
+		return nil, err
+	}
+	defer closeSession()
+	logger.Debug("snmp get", "target", target.String(), "address", g.Target, "oids", len(oids))
+
+	requested := make(map[string]string, len(oids)) // response names always have the leading dot
+	for _, oid := range oids {
+		requested[normalizeOID(oid)] = oid
+	}
+	values := make(map[string]SNMPValue, len(oids))
+	now := time.Now()
+	for batch := range slices.Chunk(oids, g.MaxOids) {
+		result, err := g.Get(batch)
+		if err != nil {
+			return nil, fmt.Errorf("snmp get error: %w", err)
+		}
+		for _, variable := range result.Variables {
+			oid := normalizeOID(variable.Name)
+			switch variable.Type {
+			case gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView, gosnmp.Null:
+				logger.Debug("snmp oid not available", "target", target.String(), "oid", oid, "type", variable.Type.String())
+				continue
+			}
+			val := gosnmp.ToBigInt(variable.Value)
+			if name, ok := requested[oid]; ok {
+				switch variable.Type {
+				case gosnmp.Counter64:
+					values[name] = SNMPValue{Value: int64(val.Uint64()), Width: 64}
+				case gosnmp.Counter32:
+					values[name] = SNMPValue{Value: val.Int64(), Width: 32}
+				default:
+					values[name] = SNMPValue{Value: val.Int64()}
+				}
+			}
+
+			c.mu.Lock()
+			c.cache[fmt.Sprintf("%s:%s", target, oid)] = snmpCacheEntry{Value: val, Timestamp: now}
+			c.mu.Unlock()
+		}
+	}
+	return values, nil
+}
+
+// Walk reads the subtree of root with GETBULK and returns its variables by their OID relative
+// to root, like "1.5" for root.1.5. Octet strings are returned as []byte.
+func (c *SNMPClient) Walk(ctx context.Context, ds config.DataSourceConfig, root string) (map[string]interface{}, error) {
+	g, target, logger, closeSession, err := c.connect(ctx, ds)
+	if err != nil {
+		return nil, err
+	}
+	defer closeSession()
+	logger.Debug("snmp walk", "target", target.String(), "address", g.Target, "oid", root)
+
+	prefix := normalizeOID(root) + "."
+	values := make(map[string]interface{})
+	err = g.BulkWalk(root, func(variable gosnmp.SnmpPDU) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		switch variable.Type {
+		case gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView, gosnmp.Null:
+			return nil
+		}
+		if index, ok := strings.CutPrefix(normalizeOID(variable.Name), prefix); ok {
+			values[index] = variable.Value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("snmp walk error: %w", err)
+	}
+	return values, nil
+}
+
+// connect opens a session to the device of ds, holding one of the sessions until closed
+func (c *SNMPClient) connect(ctx context.Context, ds config.DataSourceConfig) (*gosnmp.GoSNMP, SNMPTarget, *slog.Logger, func(), error) {
+	target, err := ParseSNMPTarget(ds.Params)
+	if err != nil {
+		return nil, target, nil, nil, fmt.Errorf("snmp datasource %s: %w", ds.Name, err)
+	}
 	community, _ := ds.Params["community"].(string)
 
-	fmt.Printf("[SNMP DEBUG] Target=%s Port=%d Community=%s OID=%s\n", host, port, community, metricIdentifier)
+	c.mu.Lock()
+	sessions, logger := c.sessions, c.logger
+	c.mu.Unlock()
+	if err := sessions.Acquire(ctx); err != nil {
+		return nil, target, nil, nil, fmt.Errorf("snmp session limit: %w", err)
+	}
+
+	host, err := c.resolver.Resolve(ctx, target)
+	if err != nil {
+		sessions.Release()
+		return nil, target, nil, nil, fmt.Errorf("snmp target error: %w", err)
+	}
 	g := &gosnmp.GoSNMP{
 		Target:    host,
-		Port:      uint16(port),
+		Port:      target.Port,
 		Community: community,
 		Version:   gosnmp.Version2c,
-		Timeout:   time.Duration(2) * time.Second,
-		Retries:   0,
+		Timeout:   target.Timeout,
+		Retries:   target.Retries,
+		MaxOids:   gosnmp.MaxOids,
 	}
 	if err := g.Connect(); err != nil {
-		fmt.Printf("[SNMP DEBUG] Connect error: %v\n", err)
-		return nil, fmt.Errorf("snmp connect error: %w", err)
+		sessions.Release()
+		return nil, target, nil, nil, fmt.Errorf("snmp connect error: %w", err)
 	}
-	defer func() {
+	closeSession := func() {
 		if err := g.Conn.Close(); err != nil {
-			fmt.Printf("[SNMP DEBUG] Close connection error: %v\n", err)
+			logger.Debug("snmp close connection", "target", target.String(), "error", err)
 		}
-	}()
-
-	result, err := g.Get([]string{metricIdentifier})
-	if err != nil {
-		fmt.Printf("[SNMP DEBUG] Get error: %v\n", err)
-		return nil, fmt.Errorf("snmp get error: %w", err)
+		sessions.Release()
 	}
-	if len(result.Variables) == 0 {
-		fmt.Printf("[SNMP DEBUG] No SNMP data for OID %s\n", metricIdentifier)
-		return nil, fmt.Errorf("no SNMP data for OID %s", metricIdentifier)
-	}
-	val := gosnmp.ToBigInt(result.Variables[0].Value)
-	fmt.Printf("[SNMP DEBUG] SNMP value for OID %s: %v\n", metricIdentifier, val)
-
-	cacheKey := fmt.Sprintf("%s:%d:%s", host, port, metricIdentifier)
-	c.mu.Lock()
-	c.cache[cacheKey] = snmpCacheEntry{Value: val, Timestamp: time.Now()}
-	c.mu.Unlock()
+	return g, target, logger, closeSession, nil
+}
 
-	return val.Int64(), nil
+func normalizeOID(oid string) string {
+	if strings.HasPrefix(oid, ".") {
+		return oid
+	}
+	return "." + oid
 }
@@ -0,0 +1,265 @@
+package datasource
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultSNMPPort    = 161
+	DefaultDNSTimeout  = 2 * time.Second
+	DefaultDNSRefresh  = 5 * time.Minute
+	DefaultSNMPTimeout = 2 * time.Second
+	MaxSNMPTimeout     = time.Minute
+	MaxSNMPRetries     = 10
+)
+
+// SNMPTarget is a validated SNMP agent address. Host is an IPv4/IPv6 literal
+// (without brackets) or a DNS name resolved with DNSTimeout and cached for DNSRefresh.
+// Each request waits Timeout for an answer and is resent up to Retries times.
+type SNMPTarget struct {
+	Host       string
+	Port       uint16
+	DNSTimeout time.Duration
+	DNSRefresh time.Duration
+	Timeout    time.Duration
+	Retries    int
+}
+
+// MaxDuration is the worst case of a single request, every try timing out
+func (t SNMPTarget) MaxDuration() time.Duration {
+	return t.Timeout * time.Duration(t.Retries+1)
+}
+
+// String returns host:port, IPv6 literals in brackets
+func (t SNMPTarget) String() string {
+	return net.JoinHostPort(t.Host, strconv.Itoa(int(t.Port)))
+}
+
+func (t SNMPTarget) IsIP() bool {
+	_, err := netip.ParseAddr(t.Host)
+	return err == nil
+}
+
+// ParseSNMPTarget validates host, port, timeout, retries, dns_timeout and dns_refresh datasource params.
+// The port may also be part of host ("router1:1161", "[2001:db8::1]:161") when no port param is set.
+func ParseSNMPTarget(params map[string]interface{}) (SNMPTarget, error) {
+	target := SNMPTarget{
+		Port:       DefaultSNMPPort,
+		DNSTimeout: DefaultDNSTimeout,
+		DNSRefresh: DefaultDNSRefresh,
+		Timeout:    DefaultSNMPTimeout,
+	}
+
+	host, _ := params["host"].(string)
+	host = strings.TrimSpace(host)
+	if host == "" {
+		return target, fmt.Errorf("host is required")
+	}
+
+	portParam, hasPort := params["port"]
+	if !hasPort {
+		if h, p, err := net.SplitHostPort(host); err == nil {
+			host, portParam, hasPort = h, p, true
+		}
+	}
+	if hasPort {
+		port, err := parsePort(portParam)
+		if err != nil {
+			return target, err
+		}
+		target.Port = port
+	}
+
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if addr, err := netip.ParseAddr(host); err == nil {
+		target.Host = addr.String()
+	} else if err := validateHostname(host); err != nil {
+		return target, err
+	} else {
+		target.Host = strings.ToLower(strings.TrimSuffix(host, "."))
+	}
+
+	for name, dst := range map[string]*time.Duration{"dns_timeout": &target.DNSTimeout, "dns_refresh": &target.DNSRefresh} {
+		value, ok := params[name].(string)
+		if !ok {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return target, fmt.Errorf("invalid %s '%s'", name, value)
+		}
+		*dst = d
+	}
+
+	if value, ok := params["timeout"]; ok {
+		timeout, err := parseTimeout(value)
+		if err != nil {
+			return target, err
+		}
+		target.Timeout = timeout
+	}
+	if value, ok := params["retries"]; ok {
+		retries, err := parseRetries(value)
+		if err != nil {
+			return target, err
+		}
+		target.Retries = retries
+	}
+	return target, nil
+}
+
+func parseRetries(value interface{}) (int, error) {
+	retries := -1
+	switch v := value.(type) {
+	case int:
+		retries = v
+	case float64: // JSON numbers
+		if v == float64(int(v)) {
+			retries = int(v)
+		}
+	}
+	if retries < 0 || retries > MaxSNMPRetries {
+		return 0, fmt.Errorf("invalid retries %v, must be 0-%d", value, MaxSNMPRetries)
+	}
+	return retries, nil
+}
+
+// parseTimeout accepts a duration string ("5s", "1500ms") or a number of seconds
+func parseTimeout(value interface{}) (time.Duration, error) {
+	var timeout time.Duration
+	switch v := value.(type) {
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return 0, fmt.Errorf("invalid timeout '%s'", v)
+		}
+		timeout = d
+	case int:
+		timeout = time.Duration(v) * time.Second
+	case float64:
+		timeout = time.Duration(v * float64(time.Second))
+	default:
+		return 0, fmt.Errorf("invalid timeout %v", value)
+	}
+	if timeout < 100*time.Millisecond || timeout > MaxSNMPTimeout {
+		return 0, fmt.Errorf("timeout %s out of range 100ms-%s", timeout, MaxSNMPTimeout)
+	}
+	return timeout, nil
+}
+
+func parsePort(value interface{}) (uint16, error) {
+	var port int
+	switch v := value.(type) {
+	case int:
+		port = v
+	case int64:
+		port = int(v)
+	case float64: // JSON numbers
+		if v != float64(int(v)) {
+			return 0, fmt.Errorf("invalid port %v", v)
+		}
+		port = int(v)
+	case string:
+		p, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return 0, fmt.Errorf("invalid port '%s'", v)
+		}
+		port = p
+	default:
+		return 0, fmt.Errorf("invalid port %v", value)
+	}
+	if port < 1 || port > 65535 {
+		return 0, fmt.Errorf("port %d out of range 1-65535", port)
+	}
+	return uint16(port), nil
+}
+
+func validateHostname(host string) error {
+	name := strings.TrimSuffix(host, ".")
+	if len(name) == 0 || len(name) > 253 {
+		return fmt.Errorf("invalid host '%s'", host)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("invalid host '%s'", host)
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+				return fmt.Errorf("invalid host '%s'", host)
+			}
+		}
+	}
+	return nil
+}
+
+type dnsEntry struct {
+	addr     string
+	resolved time.Time
+}
+
+// Resolver caches DNS lookups of SNMP targets. When a refresh fails the last
+// known address keeps being used, a flapping DNS server shouldn't take links down.
+type Resolver struct {
+	mu      sync.Mutex
+	entries map[string]dnsEntry
+	lookup  func(ctx context.Context, host string) ([]net.IPAddr, error)
+	logger  *slog.Logger
+}
+
+func NewResolver() *Resolver {
+	return &Resolver{entries: make(map[string]dnsEntry), lookup: net.DefaultResolver.LookupIPAddr, logger: slog.Default()}
+}
+
+func (r *Resolver) setLogger(logger *slog.Logger) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.logger = logger
+}
+
+// Resolve returns the IP address to poll, IPv4 is preferred when a name has both
+func (r *Resolver) Resolve(ctx context.Context, target SNMPTarget) (string, error) {
+	if target.IsIP() {
+		return target.Host, nil
+	}
+	r.mu.Lock()
+	entry, ok := r.entries[target.Host]
+	logger := r.logger
+	r.mu.Unlock()
+	if ok && time.Since(entry.resolved) < target.DNSRefresh {
+		return entry.addr, nil
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, target.DNSTimeout)
+	defer cancel()
+	addrs, err := r.lookup(lookupCtx, target.Host)
+	if err == nil && len(addrs) == 0 {
+		err = fmt.Errorf("no addresses")
+	}
+	if err != nil {
+		if ok {
+			logger.Warn("dns refresh failed, using last address", "host", target.Host, "address", entry.addr, "error", err)
+			return entry.addr, nil
+		}
+		return "", fmt.Errorf("resolve %s: %w", target.Host, err)
+	}
+
+	addr := addrs[0].String()
+	for _, a := range addrs {
+		if a.IP.To4() != nil {
+			addr = a.IP.String()
+			break
+		}
+	}
+	r.mu.Lock()
+	r.entries[target.Host] = dnsEntry{addr: addr, resolved: time.Now()}
+	r.mu.Unlock()
+	return addr, nil
+}
@@ -0,0 +1,88 @@
+package datasource
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseSNMPTarget(t *testing.T) {
+	testCases := []struct {
+		name    string
+		params  map[string]interface{}
+		want    string
+		wantErr bool
+	}{
+		{"IPv4", map[string]interface{}{"host": "10.0.0.1", "port": 161}, "10.0.0.1:161", false},
+		{"DefaultPort", map[string]interface{}{"host": "10.0.0.1"}, "10.0.0.1:161", false},
+		{"IPv6", map[string]interface{}{"host": "2001:DB8::1", "port": 1161}, "[2001:db8::1]:1161", false},
+		{"IPv6Brackets", map[string]interface{}{"host": "[2001:db8::1]"}, "[2001:db8::1]:161", false},
+		{"IPv6WithPort", map[string]interface{}{"host": "[2001:db8::1]:1161"}, "[2001:db8::1]:1161", false},
+		{"Hostname", map[string]interface{}{"host": "Core-1.Example.com.", "port": "161"}, "core-1.example.com:161", false},
+		{"HostnameWithPort", map[string]interface{}{"host": "core-1:1161"}, "core-1:1161", false},
+		{"MissingHost", map[string]interface{}{"port": 161}, "", true},
+		{"InvalidHost", map[string]interface{}{"host": "core 1"}, "", true},
+		{"InvalidLabel", map[string]interface{}{"host": "-core.example.com"}, "", true},
+		{"PortOutOfRange", map[string]interface{}{"host": "10.0.0.1", "port": 70000}, "", true},
+		{"PortNotNumber", map[string]interface{}{"host": "10.0.0.1", "port": "snmp"}, "", true},
+		{"InvalidDNSTimeout", map[string]interface{}{"host": "core-1", "dns_timeout": "soon"}, "", true},
+		{"Timeout", map[string]interface{}{"host": "10.0.0.1", "timeout": "5s", "retries": 2}, "10.0.0.1:161", false},
+		{"TimeoutSeconds", map[string]interface{}{"host": "10.0.0.1", "timeout": 5}, "10.0.0.1:161", false},
+		{"TimeoutTooLong", map[string]interface{}{"host": "10.0.0.1", "timeout": "2m"}, "", true},
+		{"TimeoutTooShort", map[string]interface{}{"host": "10.0.0.1", "timeout": "10ms"}, "", true},
+		{"NegativeRetries", map[string]interface{}{"host": "10.0.0.1", "retries": -1}, "", true},
+		{"TooManyRetries", map[string]interface{}{"host": "10.0.0.1", "retries": 11}, "", true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			target, err := ParseSNMPTarget(tc.params)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("Expected error, got target %s", target)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if target.String() != tc.want {
+				t.Errorf("Expected %s, got %s", tc.want, target)
+			}
+		})
+	}
+}
+
+func TestResolverRefresh(t *testing.T) {
+	lookups := 0
+	var failing bool
+	resolver := NewResolver()
+	resolver.lookup = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		lookups++
+		if failing {
+			return nil, errors.New("server misbehaving")
+		}
+		return []net.IPAddr{{IP: net.ParseIP("2001:db8::1")}, {IP: net.ParseIP("192.0.2.1")}}, nil
+	}
+	target := SNMPTarget{Host: "core-1", Port: 161, DNSTimeout: time.Second, DNSRefresh: time.Hour}
+
+	for i := 0; i < 3; i++ {
+		addr, err := resolver.Resolve(context.Background(), target)
+		if err != nil || addr != "192.0.2.1" {
+			t.Fatalf("Expected preferred IPv4 address, got %s %v", addr, err)
+		}
+	}
+	if lookups != 1 {
+		t.Errorf("Expected a single lookup within the refresh period, got %d", lookups)
+	}
+
+	failing = true
+	target.DNSRefresh = time.Nanosecond
+	if addr, err := resolver.Resolve(context.Background(), target); err != nil || addr != "192.0.2.1" {
+		t.Errorf("Expected last known address on failed refresh, got %s %v", addr, err)
+	}
+	if _, err := resolver.Resolve(context.Background(), SNMPTarget{Host: "core-2", DNSTimeout: time.Second}); err == nil {
+		t.Error("Expected error for name never resolved")
+	}
+}
@@ -1,7 +1,191 @@
 package datasource
 
-// ZabbixClient - mock
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
 
-type ZabbixClient struct{}
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/tracing"
+)
 
-func NewZabbixClient() *ZabbixClient { return &ZabbixClient{} }
+const maxZabbixResponseSize = 4 << 20
+
+// Availability of a Zabbix host, as reported by its agent interfaces
+const (
+	ZabbixUnknown     = 0
+	ZabbixAvailable   = 1
+	ZabbixUnavailable = 2
+)
+
+// ZabbixClient talks to the JSON-RPC API of Zabbix 6.4 or later, which takes the api token or
+// session in the Authorization header. Item polling is still a stub, host states are read for
+// the node status.
+type ZabbixClient struct {
+	httpClient *http.Client
+
+	mu       sync.Mutex
+	sessions map[string]string // datasource -> session of user.login, when no api_token is set
+}
+
+func NewZabbixClient(httpClient *http.Client) *ZabbixClient {
+	return &ZabbixClient{httpClient: httpClient, sessions: make(map[string]string)}
+}
+
+// ZabbixHost is the state of a host in Zabbix
+type ZabbixHost struct {
+	Host         string
+	Monitored    bool
+	Maintenance  bool
+	Availability int // ZabbixUnknown, ZabbixAvailable or ZabbixUnavailable
+}
+
+type zabbixError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    string `json:"data"`
+}
+
+func (e *zabbixError) Error() string {
+	return fmt.Sprintf("zabbix error %d: %s %s", e.Code, e.Message, e.Data)
+}
+
+type zabbixHostResult struct {
+	Host              string `json:"host"`
+	Status            string `json:"status"` // 0 monitored, 1 disabled
+	MaintenanceStatus string `json:"maintenance_status"`
+	Interfaces        []struct {
+		Available string `json:"available"`
+	} `json:"interfaces"`
+}
+
+// Hosts reads the maintenance and availability of hosts by their technical name, hosts unknown
+// to Zabbix are left out
+func (c *ZabbixClient) Hosts(ctx context.Context, ds config.DataSourceConfig, names []string) (hosts map[string]ZabbixHost, err error) {
+	ctx, span := tracing.StartClient(ctx, "zabbix host.get", "datasource", ds.Name, "hosts", len(names))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	params := map[string]any{
+		"output":           []string{"host", "status", "maintenance_status"},
+		"selectInterfaces": []string{"available"},
+		"filter":           map[string]any{"host": names},
+	}
+	var results []zabbixHostResult
+	if err := c.callAuthenticated(ctx, ds, "host.get", params, &results); err != nil {
+		return nil, err
+	}
+	hosts = make(map[string]ZabbixHost, len(results))
+	for _, r := range results {
+		host := ZabbixHost{Host: r.Host, Monitored: r.Status == "0", Maintenance: r.MaintenanceStatus == "1"}
+		// available when any interface is, unavailable when one is and none is available
+		for _, iface := range r.Interfaces {
+			switch {
+			case iface.Available == "1":
+				host.Availability = ZabbixAvailable
+			case iface.Available == "2" && host.Availability == ZabbixUnknown:
+				host.Availability = ZabbixUnavailable
+			}
+		}
+		hosts[r.Host] = host
+	}
+	return hosts, nil
+}
+
+// callAuthenticated calls method with the api_token of the datasource, or a session opened
+// with its username and password, which is opened again once when it expired
+func (c *ZabbixClient) callAuthenticated(ctx context.Context, ds config.DataSourceConfig, method string, params, result any) error {
+	if token, _ := ds.Params["api_token"].(string); token != "" {
+		return c.call(ctx, ds, method, params, token, result)
+	}
+	for attempt := 0; ; attempt++ {
+		session, err := c.session(ctx, ds)
+		if err != nil {
+			return err
+		}
+		err = c.call(ctx, ds, method, params, session, result)
+		var zerr *zabbixError
+		if attempt > 0 || !errors.As(err, &zerr) || !strings.Contains(zerr.Data, "re-login") && !strings.Contains(zerr.Data, "Not authori") {
+			return err
+		}
+		c.mu.Lock()
+		delete(c.sessions, ds.Name)
+		c.mu.Unlock()
+	}
+}
+
+func (c *ZabbixClient) session(ctx context.Context, ds config.DataSourceConfig) (string, error) {
+	c.mu.Lock()
+	session, ok := c.sessions[ds.Name]
+	c.mu.Unlock()
+	if ok {
+		return session, nil
+	}
+	username, _ := ds.Params["username"].(string)
+	password, _ := ds.Params["password"].(string)
+	if username == "" {
+		return "", fmt.Errorf("zabbix datasource %s: api_token or username is required", ds.Name)
+	}
+	if err := c.call(ctx, ds, "user.login", map[string]string{"username": username, "password": password}, "", &session); err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	c.sessions[ds.Name] = session
+	c.mu.Unlock()
+	return session, nil
+}
+
+func (c *ZabbixClient) call(ctx context.Context, ds config.DataSourceConfig, method string, params any, auth string, result any) error {
+	baseURL, _ := ds.Params["url"].(string)
+	if baseURL == "" {
+		return fmt.Errorf("zabbix datasource %s: url is required", ds.Name)
+	}
+	endpoint := baseURL
+	if !strings.HasSuffix(endpoint, ".php") {
+		endpoint = strings.TrimRight(endpoint, "/") + "/api_jsonrpc.php"
+	}
+	body, err := json.Marshal(map[string]any{"jsonrpc": "2.0", "method": method, "params": params, "id": 1})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("zabbix request error: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json-rpc")
+	if auth != "" {
+		req.Header.Set("Authorization", "Bearer "+auth)
+	}
+	tracing.Inject(ctx, req.Header)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("zabbix request error: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }() // the body is read in full, nothing is lost
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxZabbixResponseSize))
+	if err != nil {
+		return fmt.Errorf("zabbix read error: %w", err)
+	}
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Error  *zabbixError    `json:"error"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return fmt.Errorf("zabbix returned %s: invalid response", resp.Status)
+	}
+	if response.Error != nil {
+		return response.Error
+	}
+	if err := json.Unmarshal(response.Result, result); err != nil {
+		return fmt.Errorf("zabbix %s decode error: %w", method, err)
+	}
+	return nil
+}
@@ -0,0 +1,622 @@
+// Package expr evaluates the small expression language of map style rules, a subset of CEL:
+// number, string, bool and null literals, variables with field access (link.name) and
+// indexing (metrics["in"]), the operators ! - * / % + - < <= > >= == != && || and ?:, and the
+// functions min, max, abs and rgb. Numbers are float64, missing fields are null.
+package expr
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Program is a compiled expression, safe for concurrent use
+type Program struct {
+	source string
+	root   node
+}
+
+const (
+	// maxSourceLength and maxDepth bound the parser, whose recursion would otherwise overflow
+	// the stack on a long chain of parentheses or operators
+	maxSourceLength = 4096
+	maxDepth        = 64
+)
+
+// Compile parses an expression
+func Compile(source string) (*Program, error) {
+	if len(source) > maxSourceLength {
+		return nil, fmt.Errorf("expression longer than %d characters", maxSourceLength)
+	}
+	p := &parser{source: source}
+	if err := p.tokenize(); err != nil {
+		return nil, err
+	}
+	root, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %s at %d", tok, tok.pos)
+	}
+	return &Program{source: source, root: root}, nil
+}
+
+func (p *Program) String() string {
+	return p.source
+}
+
+// Eval evaluates the program with vars, values are float64, string, bool, nil or
+// map[string]any; integers of vars are converted to float64
+func (p *Program) Eval(vars map[string]any) (any, error) {
+	return p.root.eval(vars)
+}
+
+// Normalize converts the numbers of v to float64, the only number type of expressions
+func Normalize(v any) any {
+	switch v := v.(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for key, value := range v {
+			m[key] = Normalize(value)
+		}
+		return m
+	}
+	return v
+}
+
+// TOKENS
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	num  float64
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+// operators, longest first
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "!", "<", ">", "+", "-", "*", "/", "%", "?", ":", "(", ")", "[", "]", ".", ","}
+
+type parser struct {
+	source string
+	tokens []token
+	pos    int
+	depth  int // of nested expressions and unary operators
+}
+
+func (p *parser) tokenize() error {
+	s := p.source
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9':
+			j := i
+			for j < len(s) && (s[j] >= '0' && s[j] <= '9' || s[j] == '.' || s[j] == '_' ||
+				s[j] == 'e' || s[j] == 'E' || (s[j] == '+' || s[j] == '-') && (s[j-1] == 'e' || s[j-1] == 'E')) {
+				j++
+			}
+			num, err := strconv.ParseFloat(strings.ReplaceAll(s[i:j], "_", ""), 64)
+			if err != nil {
+				return fmt.Errorf("invalid number %q at %d", s[i:j], i)
+			}
+			p.tokens = append(p.tokens, token{kind: tokNumber, text: s[i:j], num: num, pos: i})
+			i = j
+		case c == '"' || c == '\'':
+			j := i + 1
+			var b strings.Builder
+			for ; j < len(s) && rune(s[j]) != c; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				b.WriteByte(s[j])
+			}
+			if j >= len(s) {
+				return fmt.Errorf("unterminated string at %d", i)
+			}
+			p.tokens = append(p.tokens, token{kind: tokString, text: b.String(), pos: i})
+			i = j + 1
+		case c == '_' || unicode.IsLetter(c):
+			j := i
+			for j < len(s) && (s[j] == '_' || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			p.tokens = append(p.tokens, token{kind: tokIdent, text: s[i:j], pos: i})
+			i = j
+		default:
+			op := ""
+			for _, candidate := range operators {
+				if strings.HasPrefix(s[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return fmt.Errorf("unexpected character %q at %d", c, i)
+			}
+			p.tokens = append(p.tokens, token{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	p.tokens = append(p.tokens, token{kind: tokEOF, pos: len(s)})
+	return nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) accept(op string) bool {
+	if tok := p.peek(); tok.kind == tokOp && tok.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.accept(op) {
+		tok := p.peek()
+		return fmt.Errorf("expected %q, got %s at %d", op, tok, tok.pos)
+	}
+	return nil
+}
+
+// PARSER, by precedence: ?: then || && comparison additive multiplicative unary member
+
+// enter counts one more level of nesting, the caller calls p.leave when done
+func (p *parser) enter() error {
+	p.depth++
+	if p.depth > maxDepth {
+		return fmt.Errorf("expression nested deeper than %d at %d", maxDepth, p.peek().pos)
+	}
+	return nil
+}
+
+func (p *parser) leave() {
+	p.depth--
+}
+
+func (p *parser) expression() (node, error) {
+	defer p.leave()
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	cond, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept("?") {
+		return cond, nil
+	}
+	then, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	return conditional{cond, then, otherwise}, nil
+}
+
+var precedence = [][]string{{"||"}, {"&&"}, {"==", "!=", "<", "<=", ">", ">="}, {"+", "-"}, {"*", "/", "%"}}
+
+func (p *parser) binary(level int) (node, error) {
+	if level == len(precedence) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		if tok.kind != tokOp || !slices.Contains(precedence[level], tok.text) {
+			return left, nil
+		}
+		p.next()
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = binary{tok.text, left, right}
+	}
+}
+
+func (p *parser) unary() (node, error) {
+	if tok := p.peek(); tok.kind == tokOp && (tok.text == "!" || tok.text == "-") {
+		p.next()
+		defer p.leave()
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return unary{tok.text, operand}, nil
+	}
+	return p.member()
+}
+
+func (p *parser) member() (node, error) {
+	n, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept("."):
+			tok := p.next()
+			if tok.kind != tokIdent {
+				return nil, fmt.Errorf("expected a field name, got %s at %d", tok, tok.pos)
+			}
+			n = index{n, literal{tok.text}}
+		case p.accept("["):
+			key, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			n = index{n, key}
+		default:
+			return n, nil
+		}
+	}
+}
+
+func (p *parser) primary() (node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokNumber:
+		return literal{tok.num}, nil
+	case tokString:
+		return literal{tok.text}, nil
+	case tokIdent:
+		switch tok.text {
+		case "true":
+			return literal{true}, nil
+		case "false":
+			return literal{false}, nil
+		case "null":
+			return literal{nil}, nil
+		}
+		if !p.accept("(") {
+			return variable(tok.text), nil
+		}
+		fn, ok := functions[tok.text]
+		if !ok {
+			return nil, fmt.Errorf("unknown function %s at %d", tok.text, tok.pos)
+		}
+		var args []node
+		for !p.accept(")") {
+			if len(args) > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			arg, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+		return call{tok.text, fn, args}, nil
+	case tokOp:
+		if tok.text == "(" {
+			n, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		}
+	}
+	return nil, fmt.Errorf("unexpected %s at %d", tok, tok.pos)
+}
+
+// EVALUATION
+
+type node interface {
+	eval(vars map[string]any) (any, error)
+}
+
+type literal struct{ value any }
+
+func (n literal) eval(map[string]any) (any, error) { return n.value, nil }
+
+type variable string
+
+func (n variable) eval(vars map[string]any) (any, error) {
+	value, ok := vars[string(n)]
+	if !ok {
+		return nil, fmt.Errorf("undefined variable %s", string(n))
+	}
+	return Normalize(value), nil
+}
+
+type index struct{ target, key node }
+
+func (n index) eval(vars map[string]any) (any, error) {
+	target, err := n.target.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	key, err := n.key.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := target.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("cannot index %s", typeName(target))
+	}
+	name, ok := key.(string)
+	if !ok {
+		return nil, fmt.Errorf("map keys are strings, got %s", typeName(key))
+	}
+	return Normalize(m[name]), nil
+}
+
+type conditional struct{ cond, then, otherwise node }
+
+func (n conditional) eval(vars map[string]any) (any, error) {
+	cond, err := n.cond.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := cond.(bool)
+	if !ok {
+		return nil, fmt.Errorf("condition is %s, not bool", typeName(cond))
+	}
+	if b {
+		return n.then.eval(vars)
+	}
+	return n.otherwise.eval(vars)
+}
+
+type unary struct {
+	op      string
+	operand node
+}
+
+func (n unary) eval(vars map[string]any) (any, error) {
+	v, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch v := v.(type) {
+	case bool:
+		if n.op == "!" {
+			return !v, nil
+		}
+	case float64:
+		if n.op == "-" {
+			return -v, nil
+		}
+	}
+	return nil, fmt.Errorf("operator %s not defined on %s", n.op, typeName(v))
+}
+
+type binary struct {
+	op          string
+	left, right node
+}
+
+func (n binary) eval(vars map[string]any) (any, error) {
+	left, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	// && and || short-circuit like in CEL
+	if n.op == "&&" || n.op == "||" {
+		l, ok := left.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operator %s not defined on %s", n.op, typeName(left))
+		}
+		if l == (n.op == "||") {
+			return l, nil
+		}
+		right, err := n.right.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		r, ok := right.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operator %s not defined on %s", n.op, typeName(right))
+		}
+		return r, nil
+	}
+	right, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==", "!=":
+		_, lmap := left.(map[string]any)
+		_, rmap := right.(map[string]any)
+		if lmap || rmap {
+			return nil, fmt.Errorf("operator %s not defined on maps", n.op)
+		}
+		return (left == right) == (n.op == "=="), nil
+	}
+	if l, ok := left.(string); ok {
+		if r, ok := right.(string); ok {
+			switch n.op {
+			case "+":
+				return l + r, nil
+			case "<":
+				return l < r, nil
+			case "<=":
+				return l <= r, nil
+			case ">":
+				return l > r, nil
+			case ">=":
+				return l >= r, nil
+			}
+		}
+	}
+	l, lok := left.(float64)
+	r, rok := right.(float64)
+	if !lok || !rok {
+		return nil, fmt.Errorf("operator %s not defined on %s and %s", n.op, typeName(left), typeName(right))
+	}
+	switch n.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return l / r, nil
+	case "%":
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return math.Mod(l, r), nil
+	case "<":
+		return l < r, nil
+	case "<=":
+		return l <= r, nil
+	case ">":
+		return l > r, nil
+	case ">=":
+		return l >= r, nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", n.op)
+}
+
+type call struct {
+	name string
+	fn   func(args []any) (any, error)
+	args []node
+}
+
+func (n call) eval(vars map[string]any) (any, error) {
+	args := make([]any, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	v, err := n.fn(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", n.name, err)
+	}
+	return v, nil
+}
+
+var functions = map[string]func(args []any) (any, error){
+	"min": func(args []any) (any, error) { return fold(args, math.Min) },
+	"max": func(args []any) (any, error) { return fold(args, math.Max) },
+	"abs": func(args []any) (any, error) {
+		nums, err := numbers(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return math.Abs(nums[0]), nil
+	},
+	// rgb returns the hex color of red, green and blue from 0 to 255
+	"rgb": func(args []any) (any, error) {
+		nums, err := numbers(args, 3)
+		if err != nil {
+			return nil, err
+		}
+		for i, n := range nums {
+			nums[i] = math.Max(0, math.Min(255, math.Round(n)))
+		}
+		return fmt.Sprintf("#%02x%02x%02x", int(nums[0]), int(nums[1]), int(nums[2])), nil
+	},
+}
+
+func fold(args []any, fn func(a, b float64) float64) (any, error) {
+	nums, err := numbers(args, -1)
+	if err != nil {
+		return nil, err
+	}
+	if len(nums) == 0 {
+		return nil, fmt.Errorf("needs at least one argument")
+	}
+	result := nums[0]
+	for _, n := range nums[1:] {
+		result = fn(result, n)
+	}
+	return result, nil
+}
+
+// numbers checks that args are n numbers, any count when n is negative
+func numbers(args []any, n int) ([]float64, error) {
+	if n >= 0 && len(args) != n {
+		return nil, fmt.Errorf("needs %d arguments, got %d", n, len(args))
+	}
+	nums := make([]float64, len(args))
+	for i, arg := range args {
+		num, ok := arg.(float64)
+		if !ok {
+			return nil, fmt.Errorf("argument %d is %s, not a number", i+1, typeName(arg))
+		}
+		nums[i] = num
+	}
+	return nums, nil
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case map[string]any:
+		return "map"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,113 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	vars := map[string]any{
+		"utilization": 87.5,
+		"status":      "up",
+		"in":          int64(1_250_000),
+		"metrics":     map[string]any{"errors": int64(3), "in": int64(1_250_000)},
+		"link":        map[string]any{"name": "core-edge", "width": 4},
+	}
+	tests := []struct {
+		source string
+		want   any
+	}{
+		{`1 + 2 * 3`, 7.0},
+		{`(1 + 2) * 3`, 9.0},
+		{`-utilization + 100`, 12.5},
+		{`10e9 / 1_000`, 1e7},
+		{`7 % 4`, 3.0},
+		{`utilization > 85 ? "#ff0000" : "#00ff00"`, "#ff0000"},
+		{`utilization > 90 ? "red" : utilization > 80 ? "orange" : "green"`, "orange"},
+		{`status == "up" && metrics.errors > 0`, true},
+		{`status != "up" || metrics["errors"] > 5`, false},
+		{`!(in > 1000)`, false},
+		{`metrics.missing == null`, true},
+		{`link.name + "/" + status`, "core-edge/up"},
+		{`link.width * 2`, 8.0},
+		{`min(utilization, 50, 70)`, 50.0},
+		{`max(abs(-3), 2)`, 3.0},
+		{`rgb(255, utilization * 2, 300)`, "#ffafff"},
+		{`'single' + "double"`, "singledouble"},
+		{`status == "down" && metrics.missing > 1`, false}, // short-circuit
+	}
+	for _, tt := range tests {
+		program, err := Compile(tt.source)
+		if err != nil {
+			t.Errorf("Compile(%s) failed: %v", tt.source, err)
+			continue
+		}
+		got, err := program.Eval(vars)
+		if err != nil {
+			t.Errorf("Eval(%s) failed: %v", tt.source, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Eval(%s) = %v, want %v", tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for source, want := range map[string]string{
+		`1 +`:              "unexpected end of expression",
+		`utilization >> 1`: `unexpected ">"`,
+		`"open`:            "unterminated string",
+		`foo(1)`:           "unknown function foo",
+		`a ? b`:            `expected ":"`,
+		`1 2`:              `unexpected "2"`,
+		`a.(b)`:            "expected a field name",
+		`#`:                "unexpected character",
+	} {
+		_, err := Compile(source)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Compile(%s) = %v, want an error containing %q", source, err, want)
+		}
+	}
+}
+
+func TestCompileNesting(t *testing.T) {
+	nested := func(n int) string { return strings.Repeat("(", n) + "1" + strings.Repeat(")", n) }
+	if _, err := Compile(nested(30) + " + " + strings.Repeat("!", 30) + "true"); err != nil {
+		t.Errorf("Expected 30 levels to compile, got %v", err)
+	}
+	for name, tc := range map[string]struct{ source, want string }{
+		"parentheses":       {nested(100), "nested deeper than 64"},
+		"negations":         {strings.Repeat("!", 1000) + "true", "nested deeper than 64"},
+		"conditionals":      {strings.Repeat("a ? 1 : ", 100) + "0", "nested deeper than 64"},
+		"large parentheses": {nested(8 << 20), "longer than 4096"},
+	} {
+		if _, err := Compile(tc.source); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Compile of deep %s = %v, want an error containing %q", name, err, tc.want)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	vars := map[string]any{"status": "up", "metrics": map[string]any{}}
+	for source, want := range map[string]string{
+		`unknown > 1`:        "undefined variable unknown",
+		`metrics.in * 8`:     "not defined on null and number",
+		`status ? 1 : 2`:     "condition is string",
+		`1 / 0`:              "division by zero",
+		`status.name`:        "cannot index string",
+		`metrics == metrics`: "not defined on maps",
+		`rgb(1, 2)`:          "rgb: needs 3 arguments",
+		`max(status)`:        "not a number",
+	} {
+		program, err := Compile(source)
+		if err != nil {
+			t.Errorf("Compile(%s) failed: %v", source, err)
+			continue
+		}
+		_, err = program.Eval(vars)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Eval(%s) = %v, want an error containing %q", source, err, want)
+		}
+	}
+}
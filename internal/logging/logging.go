@@ -0,0 +1,52 @@
+// Package logging builds the structured logger shared by the services
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Config of the logger
+type Config struct {
+	Level  slog.Level
+	Format string // text or json
+}
+
+// ConfigFromEnv reads WEATHERMAP_LOG_LEVEL (debug, info, warn, error) and
+// WEATHERMAP_LOG_FORMAT (text, json), defaults are info and text
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{Level: slog.LevelInfo, Format: FormatText}
+	if value := strings.TrimSpace(os.Getenv("WEATHERMAP_LOG_LEVEL")); value != "" {
+		if err := cfg.Level.UnmarshalText([]byte(value)); err != nil {
+			return cfg, fmt.Errorf("invalid WEATHERMAP_LOG_LEVEL: %s", value)
+		}
+	}
+	if value := strings.TrimSpace(os.Getenv("WEATHERMAP_LOG_FORMAT")); value != "" {
+		cfg.Format = strings.ToLower(value)
+	}
+	return cfg, cfg.Validate()
+}
+
+func (c Config) Validate() error {
+	if c.Format != FormatText && c.Format != FormatJSON {
+		return fmt.Errorf("invalid log format: '%s', must be text or json", c.Format)
+	}
+	return nil
+}
+
+// New returns a logger writing records of cfg.Level and above to w
+func New(cfg Config, w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: cfg.Level}
+	if cfg.Format == FormatJSON {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
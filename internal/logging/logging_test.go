@@ -0,0 +1,49 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("WEATHERMAP_LOG_LEVEL", "")
+	t.Setenv("WEATHERMAP_LOG_FORMAT", "")
+	cfg, err := ConfigFromEnv()
+	if err != nil || cfg.Level != slog.LevelInfo || cfg.Format != FormatText {
+		t.Fatalf("Expected info text logs by default, got %+v %v", cfg, err)
+	}
+
+	t.Setenv("WEATHERMAP_LOG_LEVEL", "debug")
+	t.Setenv("WEATHERMAP_LOG_FORMAT", "JSON")
+	cfg, err = ConfigFromEnv()
+	if err != nil || cfg.Level != slog.LevelDebug || cfg.Format != FormatJSON {
+		t.Errorf("Expected debug json logs, got %+v %v", cfg, err)
+	}
+
+	t.Setenv("WEATHERMAP_LOG_LEVEL", "verbose")
+	if _, err := ConfigFromEnv(); err == nil {
+		t.Errorf("Expected unknown level to be rejected")
+	}
+	t.Setenv("WEATHERMAP_LOG_LEVEL", "warn")
+	t.Setenv("WEATHERMAP_LOG_FORMAT", "logfmt")
+	if _, err := ConfigFromEnv(); err == nil {
+		t.Errorf("Expected unknown format to be rejected")
+	}
+}
+
+func TestNewJSONLogger(t *testing.T) {
+	var out bytes.Buffer
+	logger := New(Config{Level: slog.LevelInfo, Format: FormatJSON}, &out)
+	logger.Debug("interface metrics", "datasource", "core1")
+	logger.Warn("poll interval changed", "target", "10.0.0.1:161")
+
+	var record map[string]any
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatalf("Expected a single JSON record without the debug one, got %q: %v", out.String(), err)
+	}
+	if record["level"] != "WARN" || record["msg"] != "poll interval changed" || record["target"] != "10.0.0.1:161" {
+		t.Errorf("Expected structured warning, got %v", record)
+	}
+}
@@ -0,0 +1,251 @@
+// Package parquet writes flat tables as Apache Parquet files: one row group, required
+// columns, PLAIN encoding and no compression, which every Parquet reader understands.
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"time"
+)
+
+// physical types, converted types and enums of the Parquet format
+const (
+	typeInt64     = 2
+	typeDouble    = 5
+	typeByteArray = 6
+
+	convertedUTF8            = 0
+	convertedTimestampMillis = 9
+
+	pageData           = 0
+	encodingPlain      = 0
+	encodingRLE        = 3
+	repetitionRequired = 0
+	codecUncompressed  = 0
+)
+
+var magic = []byte("PAR1")
+
+type column struct {
+	name      string
+	kind      int32
+	converted int32 // -1 without a converted type
+	data      []byte
+	count     int
+}
+
+// Table is a set of columns of the same length, written with WriteTo
+type Table struct {
+	columns []column
+}
+
+func (t *Table) Int64(name string, values []int64) {
+	data := make([]byte, 0, 8*len(values))
+	for _, v := range values {
+		data = binary.LittleEndian.AppendUint64(data, uint64(v))
+	}
+	t.columns = append(t.columns, column{name, typeInt64, -1, data, len(values)})
+}
+
+// Timestamp stores the times as milliseconds since the epoch in UTC
+func (t *Table) Timestamp(name string, values []time.Time) {
+	data := make([]byte, 0, 8*len(values))
+	for _, v := range values {
+		data = binary.LittleEndian.AppendUint64(data, uint64(v.UnixMilli()))
+	}
+	t.columns = append(t.columns, column{name, typeInt64, convertedTimestampMillis, data, len(values)})
+}
+
+func (t *Table) Double(name string, values []float64) {
+	data := make([]byte, 0, 8*len(values))
+	for _, v := range values {
+		data = binary.LittleEndian.AppendUint64(data, math.Float64bits(v))
+	}
+	t.columns = append(t.columns, column{name, typeDouble, -1, data, len(values)})
+}
+
+func (t *Table) String(name string, values []string) {
+	var data []byte
+	for _, v := range values {
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(v)))
+		data = append(data, v...)
+	}
+	t.columns = append(t.columns, column{name, typeByteArray, convertedUTF8, data, len(values)})
+}
+
+func (t *Table) rows() int {
+	if len(t.columns) == 0 {
+		return 0
+	}
+	return t.columns[0].count
+}
+
+// WriteTo writes the table as a Parquet file, a table without rows has no row group
+func (t *Table) WriteTo(w io.Writer) (int64, error) {
+	var file bytes.Buffer
+	file.Write(magic)
+
+	type chunk struct {
+		offset, size int64
+	}
+	chunks := make([]chunk, len(t.columns))
+	if t.rows() > 0 {
+		for i, c := range t.columns {
+			header := &thriftWriter{}
+			header.push()
+			header.i32(1, pageData)
+			header.i32(2, int32(len(c.data)))
+			header.i32(3, int32(len(c.data)))
+			header.begin(5)
+			header.i32(1, int32(c.count))
+			header.i32(2, encodingPlain)
+			header.i32(3, encodingRLE)
+			header.i32(4, encodingRLE)
+			header.end()
+			header.end()
+
+			chunks[i].offset = int64(file.Len())
+			file.Write(header.buf.Bytes())
+			file.Write(c.data)
+			chunks[i].size = int64(file.Len()) - chunks[i].offset
+		}
+	}
+
+	meta := &thriftWriter{}
+	meta.push()
+	meta.i32(1, 1)
+	meta.list(2, ctStruct, len(t.columns)+1)
+	meta.push()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(t.columns)))
+	meta.end()
+	for _, c := range t.columns {
+		meta.push()
+		meta.i32(1, c.kind)
+		meta.i32(3, repetitionRequired)
+		meta.binary(4, c.name)
+		if c.converted >= 0 {
+			meta.i32(6, c.converted)
+		}
+		meta.end()
+	}
+	meta.i64(3, int64(t.rows()))
+	if t.rows() > 0 {
+		meta.list(4, ctStruct, 1)
+		meta.push()
+		meta.list(1, ctStruct, len(t.columns))
+		var total int64
+		for i, c := range t.columns {
+			meta.push()
+			meta.i64(2, chunks[i].offset)
+			meta.begin(3)
+			meta.i32(1, c.kind)
+			meta.list(2, ctI32, 1)
+			meta.varint(zigzag(encodingPlain))
+			meta.list(3, ctBinary, 1)
+			meta.rawBinary(c.name)
+			meta.i32(4, codecUncompressed)
+			meta.i64(5, int64(c.count))
+			meta.i64(6, chunks[i].size)
+			meta.i64(7, chunks[i].size)
+			meta.i64(9, chunks[i].offset)
+			meta.end()
+			meta.end()
+			total += chunks[i].size
+		}
+		meta.i64(2, total)
+		meta.i64(3, int64(t.rows()))
+		meta.end()
+	} else {
+		meta.list(4, ctStruct, 0)
+	}
+	meta.binary(6, "go-weathermap")
+	meta.end()
+
+	file.Write(meta.buf.Bytes())
+	file.Write(binary.LittleEndian.AppendUint32(nil, uint32(meta.buf.Len())))
+	file.Write(magic)
+	return file.WriteTo(w)
+}
+
+// types of the Thrift compact protocol the metadata is encoded with
+const (
+	ctStop   = 0
+	ctI32    = 5
+	ctI64    = 6
+	ctBinary = 8
+	ctList   = 9
+	ctStruct = 12
+)
+
+type thriftWriter struct {
+	buf  bytes.Buffer
+	last []int16 // id of the last field of every open struct
+}
+
+func (t *thriftWriter) push() {
+	t.last = append(t.last, 0)
+}
+
+func (t *thriftWriter) end() {
+	t.buf.WriteByte(ctStop)
+	t.last = t.last[:len(t.last)-1]
+}
+
+func (t *thriftWriter) field(id int16, kind byte) {
+	last := &t.last[len(t.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | kind)
+	} else {
+		t.buf.WriteByte(kind)
+		t.varint(zigzag(int64(id)))
+	}
+	*last = id
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	t.buf.Write(binary.AppendUvarint(nil, v))
+}
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, ctI32)
+	t.varint(zigzag(int64(v)))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, ctI64)
+	t.varint(zigzag(v))
+}
+
+func (t *thriftWriter) binary(id int16, v string) {
+	t.field(id, ctBinary)
+	t.rawBinary(v)
+}
+
+func (t *thriftWriter) rawBinary(v string) {
+	t.varint(uint64(len(v)))
+	t.buf.WriteString(v)
+}
+
+// begin opens a struct field, closed with end
+func (t *thriftWriter) begin(id int16) {
+	t.field(id, ctStruct)
+	t.push()
+}
+
+// list starts a list field, its elements follow without field headers
+func (t *thriftWriter) list(id int16, elem byte, size int) {
+	t.field(id, ctList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | elem)
+		return
+	}
+	t.buf.WriteByte(0xf0 | elem)
+	t.varint(uint64(size))
+}
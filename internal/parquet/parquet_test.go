@@ -0,0 +1,136 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+// thriftReader decodes the compact protocol into field id -> value maps, lists as []any
+type thriftReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *thriftReader) varint() uint64 {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(kind byte) any {
+	switch kind {
+	case 1:
+		return true
+	case 2:
+		return false
+	case ctI32, ctI64:
+		return r.zigzag()
+	case ctBinary:
+		n := int(r.varint())
+		r.pos += n
+		return string(r.data[r.pos-n : r.pos])
+	case ctList:
+		header := r.data[r.pos]
+		r.pos++
+		size, elem := int(header>>4), header&0x0f
+		if size == 15 {
+			size = int(r.varint())
+		}
+		list := make([]any, size)
+		for i := range list {
+			list[i] = r.value(elem)
+		}
+		return list
+	case ctStruct:
+		fields := make(map[int64]any)
+		var last int64
+		for {
+			header := r.data[r.pos]
+			r.pos++
+			if header == ctStop {
+				return fields
+			}
+			kind := header & 0x0f
+			if delta := int64(header >> 4); delta != 0 {
+				last += delta
+			} else {
+				last = r.zigzag()
+			}
+			fields[last] = r.value(kind)
+		}
+	}
+	panic("unexpected thrift type")
+}
+
+func TestTableWriteTo(t *testing.T) {
+	times := []time.Time{time.Date(2025, 3, 5, 1, 0, 0, 0, time.UTC), time.Date(2025, 3, 5, 1, 5, 0, 0, time.UTC)}
+	table := &Table{}
+	table.Timestamp("time", times)
+	table.String("link", []string{"a-b", "core-uplink"})
+	table.Double("utilization", []float64{12.5, 99.9})
+	table.Int64("samples", []int64{3, 30})
+
+	var out bytes.Buffer
+	if _, err := table.WriteTo(&out); err != nil {
+		t.Fatalf("Failed to write table: %v", err)
+	}
+	file := out.Bytes()
+	if !bytes.HasPrefix(file, magic) || !bytes.HasSuffix(file, magic) {
+		t.Fatalf("Expected PAR1 magic around the file")
+	}
+	footerLen := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	footer := &thriftReader{data: file[len(file)-8-footerLen : len(file)-8]}
+	meta := footer.value(ctStruct).(map[int64]any)
+	if footer.pos != footerLen {
+		t.Errorf("Expected footer to be read in full, read %d of %d bytes", footer.pos, footerLen)
+	}
+
+	if meta[3] != int64(2) {
+		t.Errorf("Expected 2 rows, got %v", meta[3])
+	}
+	schema := meta[2].([]any)
+	if len(schema) != 5 || schema[0].(map[int64]any)[5] != int64(4) {
+		t.Fatalf("Expected root with 4 columns, got %v", schema)
+	}
+	if col := schema[1].(map[int64]any); col[4] != "time" || col[1] != int64(typeInt64) || col[6] != int64(convertedTimestampMillis) {
+		t.Errorf("Expected timestamp column, got %v", col)
+	}
+
+	chunks := meta[4].([]any)[0].(map[int64]any)[1].([]any)
+	values := func(i int) []byte {
+		chunk := chunks[i].(map[int64]any)[3].(map[int64]any)
+		page := &thriftReader{data: file, pos: int(chunk[9].(int64))}
+		header := page.value(ctStruct).(map[int64]any)
+		if n := header[5].(map[int64]any)[1]; n != int64(2) {
+			t.Errorf("Expected 2 values in page of column %d, got %v", i, n)
+		}
+		if end := int64(page.pos) + header[3].(int64); end != chunk[9].(int64)+chunk[7].(int64) {
+			t.Errorf("Expected page to fill the chunk of column %d", i)
+		}
+		return file[page.pos : page.pos+int(header[3].(int64))]
+	}
+	if ms := int64(binary.LittleEndian.Uint64(values(0)[8:])); ms != times[1].UnixMilli() {
+		t.Errorf("Expected second time %d, got %d", times[1].UnixMilli(), ms)
+	}
+	if links := values(1); string(links[4:7]) != "a-b" || binary.LittleEndian.Uint32(links[7:]) != 11 {
+		t.Errorf("Expected length prefixed strings, got %q", links)
+	}
+	if u := math.Float64frombits(binary.LittleEndian.Uint64(values(2)[8:])); u != 99.9 {
+		t.Errorf("Expected utilization 99.9, got %v", u)
+	}
+
+	var empty bytes.Buffer
+	emptyTable := &Table{}
+	emptyTable.String("link", nil)
+	if _, err := emptyTable.WriteTo(&empty); err != nil || !bytes.HasSuffix(empty.Bytes(), magic) {
+		t.Errorf("Expected file without rows, got %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package render
+
+import (
+	"math"
+
+	"go-weathermap/internal/config"
+)
+
+// viridis from light to dark: perceptually uniform and readable with every kind of
+// color blindness, lightness alone tells the bands apart
+var accessibleRamp = []config.Color{
+	{R: 253, G: 231, B: 37},
+	{R: 181, G: 222, B: 43},
+	{R: 110, G: 206, B: 88},
+	{R: 53, G: 183, B: 121},
+	{R: 31, G: 158, B: 137},
+	{R: 38, G: 130, B: 142},
+	{R: 62, G: 73, B: 137},
+	{R: 68, G: 1, B: 84},
+}
+
+const (
+	hatchTickWidth  = 1.5
+	maxHatchSpacing = 16.0
+	minHatchSpacing = 3.0
+)
+
+// accessibleBands recolors a scale along the ramp by band order, so custom scales work too
+func accessibleBands(bands []config.Scale) []config.Scale {
+	recolored := make([]config.Scale, len(bands))
+	for i, band := range bands {
+		band.Color = accessibleColor(i, len(bands))
+		recolored[i] = band
+	}
+	return recolored
+}
+
+func accessibleColor(i, n int) config.Color {
+	if n <= 1 {
+		return accessibleRamp[0]
+	}
+	pos := float64(i) / float64(n-1) * float64(len(accessibleRamp)-1)
+	lo := int(math.Floor(pos))
+	if lo >= len(accessibleRamp)-1 {
+		return accessibleRamp[len(accessibleRamp)-1]
+	}
+	t := pos - float64(lo)
+	a, b := accessibleRamp[lo], accessibleRamp[lo+1]
+	mix := func(x, y int) int { return int(math.Round(float64(x) + t*float64(y-x))) }
+	return config.Color{R: mix(a.R, b.R), G: mix(a.G, b.G), B: mix(a.B, b.B)}
+}
+
+// bandIndex is the index of the band ColorForUtilization picks, -1 when none matches
+func bandIndex(bands []config.Scale, utilization float64) int {
+	index := -1
+	for i, band := range bands {
+		if utilization >= band.Min && utilization <= band.Max {
+			index = i
+		}
+	}
+	if index < 0 && len(bands) > 0 && utilization > bands[len(bands)-1].Max {
+		index = len(bands) - 1
+	}
+	return index
+}
+
+// hatchSpacing is the distance between hatch ticks drawn across a link in accessible mode,
+// denser for higher bands, 0 for the first band and links without utilization
+func hatchSpacing(bands []config.Scale, utilization float64) float64 {
+	return bandHatch(bandIndex(bands, utilization))
+}
+
+func bandHatch(index int) float64 {
+	if index <= 0 {
+		return 0
+	}
+	return math.Max(minHatchSpacing, maxHatchSpacing/float64(index))
+}
+
+func linkHatch(m *config.Map, link config.Link, data config.LinkData) float64 {
+	if m == nil || !m.Accessible {
+		return 0
+	}
+	switch data.Status {
+	case "down", "unknown", "":
+		return 0
+	}
+	return hatchSpacing(ScaleFor(m, link), data.Utilization)
+}
+
+// contrastColor is black or white, whichever reads better on c
+func contrastColor(c config.Color) config.Color {
+	luminance := 0.2126*float64(c.R) + 0.7152*float64(c.G) + 0.0722*float64(c.B)
+	if luminance > 128 {
+		return textColor
+	}
+	return labelBoxColor
+}
+
+// labelStrength is the border width of labels and whether their text is bold, heavier in accessible mode
+func labelStrength(m *config.Map) (borderWidth int, bold bool) {
+	if m != nil && m.Accessible {
+		return 2, true
+	}
+	return 1, false
+}
+
+// svgWeight is the font-weight attribute of bold SVG text
+func svgWeight(bold bool) string {
+	if bold {
+		return ` font-weight="bold"`
+	}
+	return ""
+}
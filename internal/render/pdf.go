@@ -0,0 +1,292 @@
+package render
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"math"
+	"slices"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+
+	"go-weathermap/internal/config"
+)
+
+const (
+	PaperA4 = "a4"
+	PaperA3 = "a3"
+
+	OrientationPortrait  = "portrait"
+	OrientationLandscape = "landscape"
+
+	pdfMargin = 36  // half an inch, in points
+	pdfDPI    = 150 // resolution of the map picture on paper
+)
+
+// paper sizes in points, portrait
+var paperSizes = map[string][2]float64{
+	PaperA4: {595.28, 841.89},
+	PaperA3: {841.89, 1190.55},
+}
+
+// PDFOptions selects the page layout, empty fields mean A4 in the orientation
+// that fits the map best
+type PDFOptions struct {
+	Paper       string
+	Orientation string
+}
+
+// PDFRenderer lays out the raster map on a printable page followed by a legend page
+type PDFRenderer struct {
+	png *PNGRenderer
+}
+
+func NewPDFRenderer(loadIcon IconLoader) *PDFRenderer {
+	return &PDFRenderer{png: NewPNGRenderer(loadIcon)}
+}
+
+func (r *PDFRenderer) Render(out io.Writer, m *config.MapWithData, opts PDFOptions) error {
+	if m == nil || m.Map == nil {
+		return fmt.Errorf("nothing to render")
+	}
+	if err := checkMapSize(m.Map); err != nil {
+		return err
+	}
+	if opts.Paper == "" {
+		opts.Paper = PaperA4
+	}
+	size, ok := paperSizes[opts.Paper]
+	if !ok {
+		return fmt.Errorf("unsupported paper size: '%s', must be '%s' or '%s'", opts.Paper, PaperA4, PaperA3)
+	}
+	pageW, pageH := size[0], size[1]
+	switch opts.Orientation {
+	case "":
+		if m.Width > m.Height {
+			pageW, pageH = pageH, pageW
+		}
+	case OrientationLandscape:
+		pageW, pageH = pageH, pageW
+	case OrientationPortrait:
+	default:
+		return fmt.Errorf("unsupported orientation: '%s', must be '%s' or '%s'", opts.Orientation, OrientationPortrait, OrientationLandscape)
+	}
+
+	// fit the map between the header and the footer keeping its aspect ratio
+	areaW, areaH := pageW-2*pdfMargin, pageH-2*pdfMargin-40
+	scale := math.Min(areaW/float64(m.Width), areaH/float64(m.Height))
+	imgW, imgH := float64(m.Width)*scale, float64(m.Height)*scale
+	imgX, imgY := (pageW-imgW)/2, pdfMargin+20+(areaH-imgH)/2
+
+	img := r.png.draw(m)
+	pxW := min(MaxRasterSize, max(1, int(imgW*pdfDPI/72)))
+	pxH := min(MaxRasterSize, max(1, int(imgH*pdfDPI/72)))
+	if pxW != m.Width || pxH != m.Height {
+		scaled := image.NewRGBA(image.Rect(0, 0, pxW, pxH))
+		xdraw.CatmullRom.Scale(scaled, scaled.Bounds(), img, img.Bounds(), draw.Src, nil)
+		img = scaled
+	}
+
+	title := m.Title
+	if title == "" {
+		title = "Weathermap"
+	}
+	footer := "Generated " + m.ProcessedAt.Format("2006-01-02 15:04:05 MST")
+
+	var mapPage pdfContent
+	mapPage.text(pdfMargin, pageH-pdfMargin-14, 14, true, title)
+	mapPage.printf("q %.2f 0 0 %.2f %.2f %.2f cm /Map Do Q\n", imgW, imgH, imgX, imgY)
+	mapPage.text(pdfMargin, pdfMargin, 8, false, footer+" - page 1 of 2")
+
+	legendPage := legendContent(m, pageH)
+	legendPage.text(pdfMargin, pdfMargin, 8, false, footer+" - page 2 of 2")
+
+	pixels, err := pdfImageData(img)
+	if err != nil {
+		return err
+	}
+	return writePDF(out, pageW, pageH, pixels, pxW, pxH, [][]byte{mapPage.Bytes(), legendPage.Bytes()})
+}
+
+// legendContent lists every scale used by the map with its bands, then the status colors
+func legendContent(m *config.MapWithData, pageH float64) *pdfContent {
+	type scale struct {
+		name  string
+		bands []config.Scale
+	}
+	scales := []scale{{DefaultScaleName, ScaleFor(m.Map, config.Link{})}}
+	seen := map[string]bool{DefaultScaleName: true}
+	commit := false
+	for _, link := range m.Links {
+		if link.Scale != "" && !seen[link.Scale] {
+			seen[link.Scale] = true
+			scales = append(scales, scale{link.Scale, ScaleFor(m.Map, link)})
+		}
+		commit = commit || link.CommitRate != ""
+	}
+	if commit {
+		scales = append(scales, scale{CommitScaleName + " (label border)", CommitScaleFor(m.Map, config.Link{})})
+	}
+	slices.SortStableFunc(scales[1:], func(a, b scale) int { return strings.Compare(a.name, b.name) })
+
+	c := &pdfContent{}
+	c.text(pdfMargin, pageH-pdfMargin-14, 14, true, "Legend")
+	x, y := float64(pdfMargin), pageH-pdfMargin-44
+	column := 0
+	row := func() {
+		y -= 16
+		if y < pdfMargin+30 {
+			// wrap into the next column when a page side is full
+			column++
+			x, y = pdfMargin+float64(column)*180, pageH-pdfMargin-60
+		}
+	}
+	swatch := func(col config.Color, hatch float64, label string) {
+		c.printf("%.3f %.3f %.3f rg %.2f %.2f 24 10 re f\n", float64(col.R)/255, float64(col.G)/255, float64(col.B)/255, x, y)
+		if hatch > 0 {
+			contrast := contrastColor(col)
+			c.printf("%.3f %.3f %.3f RG %.2f w\n", float64(contrast.R)/255, float64(contrast.G)/255, float64(contrast.B)/255, hatchTickWidth)
+			for t := hatch / 2; t < 24; t += hatch {
+				c.printf("%.2f %.2f m %.2f %.2f l S\n", x+t, y, x+t, y+10)
+			}
+		}
+		c.printf("0 0 0 RG 0.5 w %.2f %.2f 24 10 re S\n", x, y)
+		c.text(x+32, y+2, 9, false, label)
+		row()
+	}
+
+	for _, s := range scales {
+		c.text(x, y, 10, true, "Utilization scale "+s.name)
+		row()
+		for i, band := range s.bands {
+			hatch := 0.0
+			if m.Accessible {
+				hatch = bandHatch(i)
+			}
+			swatch(band.Color, hatch, fmt.Sprintf("%g - %g %%", band.Min, band.Max))
+		}
+		row()
+	}
+
+	c.text(x, y, 10, true, "Link status")
+	row()
+	swatch(downColor, 0, "down")
+	swatch(unknownColor, 0, "unknown, no data")
+	if m.OverUtilization == config.OverUtilizationFlag {
+		swatch(misconfigColor, 0, "above 100%, check the bandwidth")
+	}
+	if m.Path != nil {
+		swatch(pathColor, 0, fmt.Sprintf("path %s to %s", m.Path.From, m.Path.To))
+	}
+	if len(m.PlannedData) > 0 {
+		c.text(x, y, 9, false, "Narrow stripes show the planned utilization of a link.")
+		row()
+	}
+	return c
+}
+
+// pdfContent is a page content stream
+type pdfContent struct {
+	bytes.Buffer
+}
+
+func (c *pdfContent) printf(format string, args ...any) {
+	fmt.Fprintf(c, format, args...)
+}
+
+func (c *pdfContent) text(x, y, size float64, bold bool, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	c.printf("0 0 0 rg BT /%s %g Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, pdfString(s))
+}
+
+// pdfString escapes a literal string for the standard fonts, which only cover Latin-1
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 32 || r > 255:
+			b.WriteByte('?')
+		case r > 126:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// pdfImageData packs the picture as deflated 8 bit RGB
+func pdfImageData(img *image.RGBA) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	bounds := img.Bounds()
+	row := make([]byte, 0, bounds.Dx()*3)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row = row[:0]
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			i := img.PixOffset(x, y)
+			row = append(row, img.Pix[i], img.Pix[i+1], img.Pix[i+2])
+		}
+		if _, err := zw.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writePDF writes a PDF 1.4 document whose first page shows the map picture
+func writePDF(out io.Writer, pageW, pageH float64, pixels []byte, pxW, pxH int, pages [][]byte) error {
+	var buf bytes.Buffer
+	var offsets []int
+	object := func(body string, stream []byte) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s", len(offsets), body)
+		if stream != nil {
+			fmt.Fprintf(&buf, "\nstream\n")
+			buf.Write(stream)
+			fmt.Fprintf(&buf, "\nendstream")
+		}
+		fmt.Fprintf(&buf, "\nendobj\n")
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	// catalog and page tree are objects 1 and 2, pages start at 6
+	object("<< /Type /Catalog /Pages 2 0 R >>", nil)
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 6+2*i)
+	}
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)), nil)
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>", nil)
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>", nil)
+	object(fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode /Length %d >>",
+		pxW, pxH, len(pixels)), pixels)
+	for i, content := range pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> /XObject << /Map 5 0 R >> >> /Contents %d 0 R >>",
+			pageW, pageH, 7+2*i), nil)
+		object(fmt.Sprintf("<< /Length %d >>", len(content)), content)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := out.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,422 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"slices"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+	_ "golang.org/x/image/webp"
+
+	"go-weathermap/internal/config"
+)
+
+const (
+	MaxRasterSize   = 8192
+	curveResolution = 16
+	nodeRadius      = 10
+
+	// DefaultSnapshotPadding is the margin kept around a link by LinkRegion callers
+	DefaultSnapshotPadding = 80
+)
+
+type PNGRenderer struct {
+	loadIcon IconLoader
+}
+
+func NewPNGRenderer(loadIcon IconLoader) *PNGRenderer {
+	return &PNGRenderer{loadIcon: loadIcon}
+}
+
+// Render draws the map at its native size and scales it to width x height.
+// Zero width or height keeps the aspect ratio of the map.
+func (r *PNGRenderer) Render(out io.Writer, m *config.MapWithData, width, height int) error {
+	if m == nil || m.Map == nil {
+		return fmt.Errorf("nothing to render")
+	}
+	return r.RenderRegion(out, m, image.Rect(0, 0, m.Width, m.Height), width, height)
+}
+
+// RenderRegion draws the map, crops it to region and scales the crop to width x height.
+// Zero width or height keeps the aspect ratio of the region.
+func (r *PNGRenderer) RenderRegion(out io.Writer, m *config.MapWithData, region image.Rectangle, width, height int) error {
+	if m == nil || m.Map == nil {
+		return fmt.Errorf("nothing to render")
+	}
+	if err := checkMapSize(m.Map); err != nil {
+		return err
+	}
+	region = region.Intersect(image.Rect(0, 0, m.Width, m.Height))
+	if region.Empty() {
+		return fmt.Errorf("region is outside of the map")
+	}
+	width, height = outputSize(region.Dx(), region.Dy(), width, height)
+	if width > MaxRasterSize || height > MaxRasterSize {
+		return fmt.Errorf("image size %dx%d exceeds limit of %d pixels", width, height, MaxRasterSize)
+	}
+
+	var img image.Image = r.draw(m)
+	if region != img.Bounds() {
+		img = img.(*image.RGBA).SubImage(region)
+	}
+	if width != region.Dx() || height != region.Dy() {
+		scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+		xdraw.CatmullRom.Scale(scaled, scaled.Bounds(), img, img.Bounds(), draw.Src, nil)
+		img = scaled
+	}
+	return png.Encode(out, img)
+}
+
+// LinkRegion returns the part of the map around a link: its nodes, via points and
+// label with padding on every side, clipped to the map
+func LinkRegion(m *config.Map, linkName string, padding int) (image.Rectangle, error) {
+	i := slices.IndexFunc(m.Links, func(link config.Link) bool { return link.Name == linkName })
+	if i < 0 {
+		return image.Rectangle{}, fmt.Errorf("link not found: %s", linkName)
+	}
+	link := m.Links[i]
+	nodes := make(map[string]config.Node, len(m.Nodes))
+	for _, node := range m.Nodes {
+		nodes[node.Name] = node
+	}
+	points := linkPoints(nodes, link)
+	if points == nil {
+		return image.Rectangle{}, fmt.Errorf("nodes of link %s not found", linkName)
+	}
+	points = flatten(points)
+	if link.BWLabelPos != nil {
+		points = append(points, point{float64(link.BWLabelPos.X), float64(link.BWLabelPos.Y)})
+	}
+
+	var region image.Rectangle
+	for _, p := range points {
+		pt := image.Pt(int(math.Round(p.X)), int(math.Round(p.Y)))
+		region = region.Union(image.Rectangle{Min: pt, Max: pt.Add(image.Pt(1, 1))})
+	}
+	return region.Inset(-padding).Intersect(image.Rect(0, 0, m.Width, m.Height)), nil
+}
+
+// checkMapSize fails for maps that can't be rasterized: the whole map is drawn at its native
+// size before it is cropped and scaled, whatever the size of the output
+func checkMapSize(m *config.Map) error {
+	if m.Width <= 0 || m.Height <= 0 {
+		return fmt.Errorf("width and height of map %s must be positive", m.Title)
+	}
+	if m.Width > MaxRasterSize || m.Height > MaxRasterSize {
+		return fmt.Errorf("map size %dx%d exceeds limit of %d pixels", m.Width, m.Height, MaxRasterSize)
+	}
+	return nil
+}
+
+func outputSize(mapWidth, mapHeight, width, height int) (int, int) {
+	switch {
+	case width <= 0 && height <= 0:
+		return mapWidth, mapHeight
+	case width <= 0:
+		return max(1, mapWidth*height/mapHeight), height
+	case height <= 0:
+		return width, max(1, mapHeight*width/mapWidth)
+	}
+	return width, height
+}
+
+func (r *PNGRenderer) draw(m *config.MapWithData) *image.RGBA {
+	bg := defaultBGColor
+	if m.BGColor != nil {
+		bg = *m.BGColor
+	}
+	c := newCanvas(m.Width, m.Height, bg)
+
+	nodes := make(map[string]config.Node, len(m.Nodes))
+	for _, node := range m.Nodes {
+		nodes[node.Name] = node
+	}
+	linksData := make(map[string]config.LinkData, len(m.LinksData))
+	for _, data := range m.LinksData {
+		linksData[data.Name] = data
+	}
+
+	planned := plannedByLink(m)
+	for _, link := range m.Links {
+		points := linkPoints(nodes, link)
+		if points == nil {
+			continue
+		}
+		data := linksData[link.Name]
+		width := linkWidth(link, data)
+		if onPathLink(m, link.Name) {
+			c.strokePolyline(flatten(points), float64(width+pathHalo), pathColor)
+		}
+		color := LinkColor(m.Map, link, data)
+		c.strokePolyline(flatten(points), float64(width), color)
+		if spacing := linkHatch(m.Map, link, data); spacing > 0 {
+			c.hatchPolyline(flatten(points), float64(width), spacing, contrastColor(color))
+		}
+		if plan, ok := planned[link.Name]; ok {
+			c.strokePolyline(flatten(points), float64(plannedWidth(link)), ColorForUtilization(ScaleFor(m.Map, link), plan.Utilization))
+		}
+
+		label := midpoint(points)
+		if link.BWLabelPos != nil {
+			label = point{float64(link.BWLabelPos.X), float64(link.BWLabelPos.Y)}
+		}
+		c.labelBox(label, linkLabel(data), labelBorderColor(m.Map, link, data), m.Accessible)
+	}
+
+	for _, node := range m.Nodes {
+		r.drawNode(c, m, node)
+	}
+
+	bands := ScaleFor(m.Map, config.Link{})
+	x, y := float64(m.Width-110), 10.0
+	c.fillRect(x, y, 100, float64(len(bands)*14+22), labelBoxColor)
+	c.strokeRect(x, y, 100, float64(len(bands)*14+22), textColor)
+	c.text(x+6, y+14, "Utilization", textColor)
+	for i, band := range bands {
+		rowY := y + 20 + float64(i*14)
+		c.fillRect(x+6, rowY, 20, 10, band.Color)
+		if spacing := bandHatch(i); m.Accessible && spacing > 0 {
+			c.hatchPolyline([]point{{x + 6, rowY + 5}, {x + 26, rowY + 5}}, 10, spacing, contrastColor(band.Color))
+		}
+		c.strokeRect(x+6, rowY, 20, 10, textColor)
+		c.text(x+32, rowY+10, fmt.Sprintf("%g-%g%%", band.Min, band.Max), textColor)
+	}
+
+	if m.Title != "" {
+		c.text(10, 20, m.Title, textColor)
+	}
+	c.text(10, float64(m.Height-8), m.ProcessedAt.Format("2006-01-02 15:04:05"), textColor)
+
+	return c.img
+}
+
+// drawNode uses raster icons as is, vector icons are replaced by a marker
+func (r *PNGRenderer) drawNode(c *canvas, m *config.MapWithData, node config.Node) {
+	center := point{float64(node.Position.X), float64(node.Position.Y)}
+	labelY := center.Y
+
+	if radius, fill, ok := clusterMarker(m, node.Name); ok {
+		c.fillCircle(center, radius+2, textColor)
+		c.fillCircle(center, radius, fill)
+		labelY += radius + labelFontSize/2
+	} else if icon := r.rasterIcon(node.Icon); icon != nil {
+		if color, ok := nodeStatusColor(m, node.Name); ok {
+			c.fillCircle(center, iconSize/2+4, color)
+		}
+		rect := image.Rect(0, 0, iconSize, iconSize).Add(image.Pt(node.Position.X-iconSize/2, node.Position.Y-iconSize/2))
+		xdraw.CatmullRom.Scale(c.img, rect, icon, icon.Bounds(), draw.Over, nil)
+		labelY += iconSize/2 + labelFontSize/2
+	} else if node.Icon != "" {
+		if color, ok := nodeStatusColor(m, node.Name); ok {
+			c.fillCircle(center, nodeRadius+3, color)
+		}
+		c.fillCircle(center, nodeRadius, config.Color{R: 4, G: 104, B: 151})
+		labelY += nodeRadius + labelFontSize/2
+	}
+	border := textColor
+	if onPathNode(m, node.Name) {
+		border = pathColor
+	}
+	c.labelBox(point{center.X, labelY}, nodeLabel(node), border, m.Accessible)
+}
+
+func (r *PNGRenderer) rasterIcon(name string) image.Image {
+	if name == "" || r.loadIcon == nil {
+		return nil
+	}
+	data, contentType, err := r.loadIcon(name)
+	if err != nil || strings.Contains(contentType, "svg") {
+		return nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	return img
+}
+
+// ResizeIcon scales a raster icon to fit a size by size square, keeping its aspect ratio, and
+// encodes it as PNG
+func ResizeIcon(data []byte, size int) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid icon: %w", err)
+	}
+	bounds := img.Bounds()
+	width, height := size, size
+	if bounds.Dx() > bounds.Dy() {
+		height = max(1, size*bounds.Dy()/bounds.Dx())
+	} else if bounds.Dy() > bounds.Dx() {
+		width = max(1, size*bounds.Dx()/bounds.Dy())
+	}
+	resized := image.NewRGBA(image.Rect(0, 0, width, height))
+	xdraw.CatmullRom.Scale(resized, resized.Bounds(), img, bounds, draw.Src, nil)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, resized); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// flatten converts via point curves into a polyline with the same shape as in SVG output
+func flatten(points []point) []point {
+	if len(points) <= 2 {
+		return points
+	}
+	flat := []point{points[0]}
+	start := points[0]
+	for _, seg := range bezierSegments(points) {
+		for i := 1; i <= curveResolution; i++ {
+			t := float64(i) / curveResolution
+			mt := 1 - t
+			flat = append(flat, point{
+				X: mt*mt*mt*start.X + 3*mt*mt*t*seg[0].X + 3*mt*t*t*seg[1].X + t*t*t*seg[2].X,
+				Y: mt*mt*mt*start.Y + 3*mt*mt*t*seg[0].Y + 3*mt*t*t*seg[1].Y + t*t*t*seg[2].Y,
+			})
+		}
+		start = seg[2]
+	}
+	return flat
+}
+
+type canvas struct {
+	img *image.RGBA
+}
+
+func newCanvas(width, height int, bg config.Color) *canvas {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(rgba(bg)), image.Point{}, draw.Src)
+	return &canvas{img: img}
+}
+
+func rgba(c config.Color) color.RGBA {
+	return color.RGBA{R: uint8(clampByte(c.R)), G: uint8(clampByte(c.G)), B: uint8(clampByte(c.B)), A: 255}
+}
+
+func (c *canvas) fillPolygon(points []point, col config.Color) {
+	if len(points) < 3 {
+		return
+	}
+	// rasterize only the bounding box of the polygon, full canvas per shape is too slow
+	minX, minY, maxX, maxY := points[0].X, points[0].Y, points[0].X, points[0].Y
+	for _, p := range points[1:] {
+		minX, minY = math.Min(minX, p.X), math.Min(minY, p.Y)
+		maxX, maxY = math.Max(maxX, p.X), math.Max(maxY, p.Y)
+	}
+	box := image.Rect(int(math.Floor(minX)), int(math.Floor(minY)), int(math.Ceil(maxX))+1, int(math.Ceil(maxY))+1)
+	target := box.Intersect(c.img.Bounds())
+	if target.Empty() {
+		return
+	}
+
+	z := vector.NewRasterizer(box.Dx(), box.Dy())
+	z.MoveTo(float32(points[0].X)-float32(box.Min.X), float32(points[0].Y)-float32(box.Min.Y))
+	for _, p := range points[1:] {
+		z.LineTo(float32(p.X)-float32(box.Min.X), float32(p.Y)-float32(box.Min.Y))
+	}
+	z.ClosePath()
+
+	mask := image.NewAlpha(image.Rect(0, 0, box.Dx(), box.Dy()))
+	z.Draw(mask, mask.Bounds(), image.Opaque, image.Point{})
+	draw.DrawMask(c.img, target, image.NewUniform(rgba(col)), image.Point{}, mask, target.Min.Sub(box.Min), draw.Over)
+}
+
+func (c *canvas) fillCircle(center point, radius float64, col config.Color) {
+	const steps = 24
+	points := make([]point, 0, steps)
+	for i := 0; i < steps; i++ {
+		a := 2 * math.Pi * float64(i) / steps
+		points = append(points, point{center.X + radius*math.Cos(a), center.Y + radius*math.Sin(a)})
+	}
+	c.fillPolygon(points, col)
+}
+
+// strokePolyline draws each segment as a quad with round joins
+func (c *canvas) strokePolyline(points []point, width float64, col config.Color) {
+	half := width / 2
+	for i := 1; i < len(points); i++ {
+		a, b := points[i-1], points[i]
+		length := distance(a, b)
+		if length == 0 {
+			continue
+		}
+		nx, ny := -(b.Y-a.Y)/length*half, (b.X-a.X)/length*half
+		c.fillPolygon([]point{
+			{a.X + nx, a.Y + ny}, {b.X + nx, b.Y + ny},
+			{b.X - nx, b.Y - ny}, {a.X - nx, a.Y - ny},
+		}, col)
+	}
+	for _, p := range points {
+		c.fillCircle(p, half, col)
+	}
+}
+
+func (c *canvas) fillRect(x, y, w, h float64, col config.Color) {
+	c.fillPolygon([]point{{x, y}, {x + w, y}, {x + w, y + h}, {x, y + h}}, col)
+}
+
+func (c *canvas) strokeRect(x, y, w, h float64, col config.Color) {
+	c.strokePolyline([]point{{x, y}, {x + w, y}, {x + w, y + h}, {x, y + h}, {x, y}}, 1, col)
+}
+
+// labelBox draws text centered at p on a bordered background, strong labels get
+// a thicker border and bold text for high contrast
+func (c *canvas) labelBox(p point, text string, border config.Color, strong bool) {
+	face := basicfont.Face7x13
+	w := float64(font.MeasureString(face, text).Ceil() + 6)
+	h := float64(face.Metrics().Height.Ceil() + 2)
+	c.fillRect(p.X-w/2, p.Y-h/2, w, h, labelBoxColor)
+	c.strokeRect(p.X-w/2, p.Y-h/2, w, h, border)
+	c.text(p.X-w/2+3, p.Y+h/2-4, text, textColor)
+	if strong {
+		c.strokeRect(p.X-w/2-1, p.Y-h/2-1, w+2, h+2, border)
+		c.text(p.X-w/2+4, p.Y+h/2-4, text, textColor) // the basic font has no bold face
+	}
+}
+
+// hatchPolyline draws ticks across a line of the given width every spacing pixels
+func (c *canvas) hatchPolyline(points []point, width, spacing float64, col config.Color) {
+	half := width / 2
+	offset := spacing / 2
+	for i := 1; i < len(points); i++ {
+		a, b := points[i-1], points[i]
+		length := distance(a, b)
+		if length == 0 {
+			continue
+		}
+		dx, dy := (b.X-a.X)/length, (b.Y-a.Y)/length
+		for ; offset < length; offset += spacing {
+			p := point{a.X + dx*offset, a.Y + dy*offset}
+			tx, ty := dx*hatchTickWidth/2, dy*hatchTickWidth/2
+			nx, ny := -dy*half, dx*half
+			c.fillPolygon([]point{
+				{p.X - tx + nx, p.Y - ty + ny}, {p.X + tx + nx, p.Y + ty + ny},
+				{p.X + tx - nx, p.Y + ty - ny}, {p.X - tx - nx, p.Y - ty - ny},
+			}, col)
+		}
+		offset -= length
+	}
+}
+
+func (c *canvas) text(x, y float64, text string, col config.Color) {
+	d := &font.Drawer{
+		Dst:  c.img,
+		Src:  image.NewUniform(rgba(col)),
+		Face: basicfont.Face7x13,
+		Dot:  fixed.P(int(x), int(y)),
+	}
+	d.DrawString(text)
+}
@@ -0,0 +1,103 @@
+package render
+
+import (
+	"bytes"
+	"image"
+	"strings"
+	"testing"
+
+	"go-weathermap/internal/config"
+)
+
+func TestOutputSize(t *testing.T) {
+	testCases := []struct {
+		name                  string
+		width, height         int
+		wantWidth, wantHeight int
+	}{
+		{"Native", 0, 0, 800, 600},
+		{"Width", 400, 0, 400, 300},
+		{"Height", 0, 300, 400, 300},
+		{"Both", 100, 100, 100, 100},
+		{"Tiny", 1, 0, 1, 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if w, h := outputSize(800, 600, tc.width, tc.height); w != tc.wantWidth || h != tc.wantHeight {
+				t.Errorf("Expected %dx%d, got %dx%d", tc.wantWidth, tc.wantHeight, w, h)
+			}
+		})
+	}
+}
+
+func TestScaleFor(t *testing.T) {
+	custom := []config.Scale{{Name: "all", Min: 0, Max: 100, Color: config.Color{R: 1}}}
+	fallback := []config.Scale{{Name: "all", Min: 0, Max: 100, Color: config.Color{R: 2}}}
+	testCases := []struct {
+		name  string
+		m     *config.Map
+		link  config.Link
+		wantR int
+	}{
+		{"NoMap", nil, config.Link{}, defaultScale[0].Color.R},
+		{"BuiltIn", &config.Map{}, config.Link{}, defaultScale[0].Color.R},
+		{"LinkScale", &config.Map{Scales: map[string][]config.Scale{"wan": custom, DefaultScaleName: fallback}}, config.Link{Scale: "wan"}, 1},
+		{"MapDefault", &config.Map{Scales: map[string][]config.Scale{DefaultScaleName: fallback}}, config.Link{Scale: "missing"}, 2},
+		{"EmptyScale", &config.Map{Scales: map[string][]config.Scale{"wan": {}}}, config.Link{Scale: "wan"}, defaultScale[0].Color.R},
+		{"Accessible", &config.Map{Accessible: true, Scales: map[string][]config.Scale{DefaultScaleName: fallback}}, config.Link{}, accessibleRamp[0].R},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if bands := ScaleFor(tc.m, tc.link); len(bands) == 0 || bands[0].Color.R != tc.wantR {
+				t.Errorf("Expected bands starting with red %d, got %+v", tc.wantR, bands)
+			}
+		})
+	}
+}
+
+func TestColorForUtilization(t *testing.T) {
+	testCases := []struct {
+		name        string
+		utilization float64
+		want        config.Color
+	}{
+		{"Idle", 0, defaultScale[1].Color}, // the last matching band wins on a boundary
+		{"Low", 5, defaultScale[2].Color},
+		{"Boundary", 10, defaultScale[3].Color},
+		{"Full", 100, defaultScale[8].Color},
+		{"Overloaded", 150, defaultScale[8].Color},
+		{"Negative", -1, unknownColor},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ColorForUtilization(defaultScale, tc.utilization); got != tc.want {
+				t.Errorf("Expected %+v, got %+v", tc.want, got)
+			}
+		})
+	}
+	if got := ColorForUtilization(nil, 50); got != unknownColor {
+		t.Errorf("Expected unknown color without bands, got %+v", got)
+	}
+}
+
+func TestRenderMapSizeLimit(t *testing.T) {
+	renderer := NewPNGRenderer(nil)
+	huge := &config.MapWithData{Map: &config.Map{Title: "huge", Width: 100000, Height: 100000}}
+	// the map is drawn at its native size, a small output doesn't help
+	if err := renderer.Render(&bytes.Buffer{}, huge, 800, 0); err == nil || !strings.Contains(err.Error(), "exceeds limit") {
+		t.Errorf("Expected the map size refused, got %v", err)
+	}
+	if err := renderer.RenderTile(&bytes.Buffer{}, huge, 0, 0, 0); err == nil || !strings.Contains(err.Error(), "exceeds limit") {
+		t.Errorf("Expected the tile of the map refused, got %v", err)
+	}
+
+	small := &config.MapWithData{Map: &config.Map{Title: "small", Width: 200, Height: 100}}
+	var buf bytes.Buffer
+	if err := renderer.RenderRegion(&buf, small, image.Rect(0, 0, 100, 100), 50, 0); err != nil {
+		t.Fatal(err)
+	}
+	img, _, err := image.Decode(&buf)
+	if err != nil || img.Bounds().Dx() != 50 || img.Bounds().Dy() != 50 {
+		t.Errorf("Expected a 50x50 png, got %v %v", img, err)
+	}
+}
@@ -0,0 +1,296 @@
+package render
+
+import (
+	"fmt"
+	"math"
+	"slices"
+
+	"go-weathermap/internal/config"
+)
+
+const (
+	DefaultScaleName = "default"
+	CommitScaleName  = "commit"
+	defaultLinkWidth = 4
+	iconSize         = 32
+	labelFontSize    = 12
+	titleFontSize    = 16
+)
+
+// same bands as classic PHP weathermap
+var defaultScale = []config.Scale{
+	{Name: "0", Min: 0, Max: 0, Color: config.Color{R: 192, G: 192, B: 192}},
+	{Name: "0-1", Min: 0, Max: 1, Color: config.Color{R: 255, G: 255, B: 255}},
+	{Name: "1-10", Min: 1, Max: 10, Color: config.Color{R: 140, G: 0, B: 255}},
+	{Name: "10-25", Min: 10, Max: 25, Color: config.Color{R: 32, G: 32, B: 255}},
+	{Name: "25-40", Min: 25, Max: 40, Color: config.Color{R: 0, G: 192, B: 255}},
+	{Name: "40-55", Min: 40, Max: 55, Color: config.Color{R: 0, G: 240, B: 0}},
+	{Name: "55-70", Min: 55, Max: 70, Color: config.Color{R: 240, G: 240, B: 0}},
+	{Name: "70-85", Min: 70, Max: 85, Color: config.Color{R: 255, G: 192, B: 0}},
+	{Name: "85-100", Min: 85, Max: 100, Color: config.Color{R: 255, G: 0, B: 0}},
+}
+
+// commit rate bands, above 100% traffic is policed by the provider
+var defaultCommitScale = []config.Scale{
+	{Name: "0-50", Min: 0, Max: 50, Color: config.Color{R: 0, G: 192, B: 0}},
+	{Name: "50-80", Min: 50, Max: 80, Color: config.Color{R: 240, G: 240, B: 0}},
+	{Name: "80-100", Min: 80, Max: 100, Color: config.Color{R: 255, G: 128, B: 0}},
+	{Name: "100+", Min: 100, Max: 100, Color: config.Color{R: 255, G: 0, B: 0}},
+}
+
+var (
+	unknownColor     = config.Color{R: 192, G: 192, B: 192}
+	downColor        = config.Color{R: 64, G: 64, B: 64}
+	misconfigColor   = config.Color{R: 255, G: 0, B: 255} // above 100% on maps flagging it
+	defaultBGColor   = config.Color{R: 255, G: 255, B: 255}
+	textColor        = config.Color{R: 0, G: 0, B: 0}
+	labelBoxColor    = config.Color{R: 255, G: 255, B: 255}
+	pathColor        = config.Color{R: 0, G: 160, B: 255}
+	nodeUpColor      = config.Color{R: 0, G: 192, B: 0}
+	nodeDownColor    = config.Color{R: 255, G: 0, B: 0}
+	maintenanceColor = config.Color{R: 255, G: 160, B: 0}
+)
+
+// pathHalo is added to the link width for the outline of links on the highlighted path
+const pathHalo = 8
+
+type point struct {
+	X, Y float64
+}
+
+// ScaleFor returns the bands used for a link, falling back to the "default"
+// scale of the map and then to the built-in one.
+func ScaleFor(m *config.Map, link config.Link) []config.Scale {
+	bands := defaultScale
+	if m != nil && m.Scales != nil {
+		if custom, ok := m.Scales[link.Scale]; ok && link.Scale != "" && len(custom) > 0 {
+			bands = custom
+		} else if custom, ok := m.Scales[DefaultScaleName]; ok && len(custom) > 0 {
+			bands = custom
+		}
+	}
+	if m != nil && m.Accessible {
+		return accessibleBands(bands)
+	}
+	return bands
+}
+
+// CommitScaleFor returns the bands for the commit rate utilization of a link:
+// its commit_scale, the "commit" scale of the map, then the built-in one.
+func CommitScaleFor(m *config.Map, link config.Link) []config.Scale {
+	bands := defaultCommitScale
+	if m != nil && m.Scales != nil {
+		if custom, ok := m.Scales[link.CommitScale]; ok && link.CommitScale != "" && len(custom) > 0 {
+			bands = custom
+		} else if custom, ok := m.Scales[CommitScaleName]; ok && len(custom) > 0 {
+			bands = custom
+		}
+	}
+	if m != nil && m.Accessible {
+		return accessibleBands(bands)
+	}
+	return bands
+}
+
+// ColorForUtilization picks the last band that contains the value, so an
+// exact 0 on the default scale resolves to the "0-1" band rather than grey.
+func ColorForUtilization(bands []config.Scale, utilization float64) config.Color {
+	color := unknownColor
+	found := false
+	for _, band := range bands {
+		if utilization >= band.Min && utilization <= band.Max {
+			color = band.Color
+			found = true
+		}
+	}
+	if !found && len(bands) > 0 && utilization > bands[len(bands)-1].Max {
+		color = bands[len(bands)-1].Color
+	}
+	return color
+}
+
+// LinkColor is the color a link is drawn with: of the link_style rules, then of its status,
+// then of its scale
+func LinkColor(m *config.Map, link config.Link, data config.LinkData) config.Color {
+	if data.Style != nil && data.Style.Color != "" {
+		if c, err := config.ParseHexColor(data.Style.Color); err == nil {
+			return c
+		}
+	}
+	switch data.Status {
+	case "down":
+		return downColor
+	case "unknown", "":
+		return unknownColor
+	case "misconfigured":
+		return misconfigColor
+	}
+	return ColorForUtilization(ScaleFor(m, link), data.Utilization)
+}
+
+// linkWidth is the width of the link_style rules, then of the link, then the default one
+func linkWidth(link config.Link, data config.LinkData) int {
+	if data.Style != nil && data.Style.Width > 0 {
+		return data.Style.Width
+	}
+	if link.Width > 0 {
+		return link.Width
+	}
+	return defaultLinkWidth
+}
+
+// labelBorderColor shows the commit rate utilization of policed links on the label border
+func labelBorderColor(m *config.Map, link config.Link, data config.LinkData) config.Color {
+	if data.CommitUtilization == nil || data.Status == "down" || data.Status == "unknown" || data.Status == "" {
+		return textColor
+	}
+	return ColorForUtilization(CommitScaleFor(m, link), *data.CommitUtilization)
+}
+
+func plannedByLink(m *config.MapWithData) map[string]config.PlannedLinkData {
+	planned := make(map[string]config.PlannedLinkData, len(m.PlannedData))
+	for _, data := range m.PlannedData {
+		planned[data.Name] = data
+	}
+	return planned
+}
+
+// plannedWidth is the width of the planned load stripe drawn over the link
+func plannedWidth(link config.Link) int {
+	width := link.Width
+	if width <= 0 {
+		width = defaultLinkWidth
+	}
+	return max(1, width/3)
+}
+
+func onPathLink(m *config.MapWithData, link string) bool {
+	return m.Path != nil && slices.Contains(m.Path.Links, link)
+}
+
+func onPathNode(m *config.MapWithData, node string) bool {
+	return m.Path != nil && slices.Contains(m.Path.Nodes, node)
+}
+
+// clusterMarker returns the radius and fill of the marker of a cluster node, growing with
+// the number of members and colored by their worst link. ok is false for plain nodes.
+func clusterMarker(m *config.MapWithData, node string) (radius float64, fill config.Color, ok bool) {
+	i := slices.IndexFunc(m.Clusters, func(c config.NodeCluster) bool { return c.Name == node })
+	if i < 0 {
+		return 0, config.Color{}, false
+	}
+	cluster := m.Clusters[i]
+	radius = nodeRadius + 3*math.Log2(float64(len(cluster.Nodes)))
+	switch cluster.Status {
+	case "down":
+		return radius, downColor, true
+	case "unknown", "":
+		return radius, unknownColor, true
+	}
+	return radius, ColorForUtilization(ScaleFor(m.Map, config.Link{}), cluster.Utilization), true
+}
+
+// nodeStatusColor returns the color of the reachability ring of a node, ok is false for nodes
+// without status or not checked yet
+func nodeStatusColor(m *config.MapWithData, node string) (color config.Color, ok bool) {
+	i := slices.IndexFunc(m.NodesData, func(d config.NodeData) bool { return d.Name == node })
+	if i < 0 {
+		return config.Color{}, false
+	}
+	switch m.NodesData[i].Status {
+	case "up":
+		return nodeUpColor, true
+	case "down":
+		return nodeDownColor, true
+	case "maintenance":
+		return maintenanceColor, true
+	}
+	return config.Color{}, false
+}
+
+// HexColor formats a color as #rrggbb, components out of range are clamped
+func HexColor(c config.Color) string {
+	return fmt.Sprintf("#%02x%02x%02x", clampByte(c.R), clampByte(c.G), clampByte(c.B))
+}
+
+func clampByte(v int) int {
+	return max(0, min(255, v))
+}
+
+// linkPoints returns the polyline of a link including via points, or nil
+// when one of the endpoints doesn't exist.
+func linkPoints(nodes map[string]config.Node, link config.Link) []point {
+	from, okFrom := nodes[link.From]
+	to, okTo := nodes[link.To]
+	if !okFrom || !okTo {
+		return nil
+	}
+	points := make([]point, 0, len(link.Via)+2)
+	points = append(points, point{float64(from.Position.X), float64(from.Position.Y)})
+	for _, via := range link.Via {
+		points = append(points, point{float64(via.X), float64(via.Y)})
+	}
+	points = append(points, point{float64(to.Position.X), float64(to.Position.Y)})
+	return points
+}
+
+// midpoint walks the polyline and returns the point at half of its length.
+func midpoint(points []point) point {
+	if len(points) == 0 {
+		return point{}
+	}
+	total := 0.0
+	for i := 1; i < len(points); i++ {
+		total += distance(points[i-1], points[i])
+	}
+	half := total / 2
+	for i := 1; i < len(points); i++ {
+		seg := distance(points[i-1], points[i])
+		if seg >= half && seg > 0 {
+			t := half / seg
+			return point{
+				X: points[i-1].X + (points[i].X-points[i-1].X)*t,
+				Y: points[i-1].Y + (points[i].Y-points[i-1].Y)*t,
+			}
+		}
+		half -= seg
+	}
+	return points[len(points)-1]
+}
+
+func distance(a, b point) float64 {
+	return math.Hypot(b.X-a.X, b.Y-a.Y)
+}
+
+// bezierSegments converts a polyline to cubic bezier control points using
+// Catmull-Rom splines, so via points produce a smooth curve through them.
+func bezierSegments(points []point) [][3]point {
+	segments := make([][3]point, 0, len(points)-1)
+	for i := 0; i < len(points)-1; i++ {
+		p0 := points[max(i-1, 0)]
+		p1 := points[i]
+		p2 := points[i+1]
+		p3 := points[min(i+2, len(points)-1)]
+		c1 := point{p1.X + (p2.X-p0.X)/6, p1.Y + (p2.Y-p0.Y)/6}
+		c2 := point{p2.X - (p3.X-p1.X)/6, p2.Y - (p3.Y-p1.Y)/6}
+		segments = append(segments, [3]point{c1, c2, p2})
+	}
+	return segments
+}
+
+func nodeLabel(node config.Node) string {
+	if node.Label != "" {
+		return node.Label
+	}
+	return node.Name
+}
+
+func linkLabel(data config.LinkData) string {
+	if data.Status == "unknown" || data.Status == "" {
+		return "n/a"
+	}
+	if data.CommitUtilization != nil {
+		return fmt.Sprintf("%.1f%% (CIR %.1f%%)", data.Utilization, *data.CommitUtilization)
+	}
+	return fmt.Sprintf("%.1f%%", data.Utilization)
+}
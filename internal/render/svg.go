@@ -0,0 +1,281 @@
+package render
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"image"
+	"io"
+	"math"
+	"slices"
+	"strings"
+
+	"go-weathermap/internal/config"
+)
+
+// IconLoader returns icon content and its content type, MapService.GetIconFile fits it
+type IconLoader func(name string) ([]byte, string, error)
+
+type SVGRenderer struct {
+	loadIcon IconLoader
+}
+
+func NewSVGRenderer(loadIcon IconLoader) *SVGRenderer {
+	return &SVGRenderer{loadIcon: loadIcon}
+}
+
+func (r *SVGRenderer) Render(out io.Writer, m *config.MapWithData) error {
+	if m == nil || m.Map == nil {
+		return fmt.Errorf("nothing to render")
+	}
+	return r.RenderRegion(out, m, image.Rect(0, 0, m.Width, m.Height), 1)
+}
+
+// RenderRegion draws the whole map but sets the viewBox to region, the picture is
+// region scaled by zoom
+func (r *SVGRenderer) RenderRegion(out io.Writer, m *config.MapWithData, region image.Rectangle, zoom float64) error {
+	if m == nil || m.Map == nil {
+		return fmt.Errorf("nothing to render")
+	}
+	region = region.Intersect(image.Rect(0, 0, m.Width, m.Height))
+	if region.Empty() {
+		return fmt.Errorf("region is outside of the map")
+	}
+	w := bufio.NewWriter(out)
+
+	bg := defaultBGColor
+	if m.BGColor != nil {
+		bg = *m.BGColor
+	}
+
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="%g" height="%g" viewBox="%d %d %d %d" font-family="sans-serif">`+"\n",
+		math.Round(float64(region.Dx())*zoom), math.Round(float64(region.Dy())*zoom), region.Min.X, region.Min.Y, region.Dx(), region.Dy())
+	fmt.Fprintf(w, `<rect width="%d" height="%d" fill="%s"/>`+"\n", m.Width, m.Height, HexColor(bg))
+
+	nodes := make(map[string]config.Node, len(m.Nodes))
+	for _, node := range m.Nodes {
+		nodes[node.Name] = node
+	}
+	linksData := make(map[string]config.LinkData, len(m.LinksData))
+	for _, data := range m.LinksData {
+		linksData[data.Name] = data
+	}
+
+	planned := plannedByLink(m)
+
+	fmt.Fprintln(w, `<g class="links">`)
+	for _, link := range m.Links {
+		withInfoURL(w, link.InfoURL, func() {
+			r.writeLink(w, m.Map, nodes, link, linksData[link.Name], onPathLink(m, link.Name))
+			if data, ok := planned[link.Name]; ok {
+				writePlanned(w, m.Map, nodes, link, data)
+			}
+		})
+	}
+	fmt.Fprintln(w, `</g>`)
+
+	fmt.Fprintln(w, `<g class="nodes">`)
+	icons := make(map[string]string)
+	for _, node := range m.Nodes {
+		withInfoURL(w, node.InfoURL, func() {
+			r.writeNode(w, m, node, icons)
+		})
+	}
+	fmt.Fprintln(w, `</g>`)
+
+	r.writeLegend(w, m.Map)
+
+	if m.Title != "" {
+		fmt.Fprintf(w, `<text x="10" y="%d" font-size="%d" font-weight="bold" fill="%s">%s</text>`+"\n",
+			titleFontSize+6, titleFontSize, HexColor(textColor), html.EscapeString(m.Title))
+	}
+	fmt.Fprintf(w, `<text x="10" y="%d" font-size="10" fill="%s">%s</text>`+"\n",
+		m.Height-8, HexColor(textColor), m.ProcessedAt.Format("2006-01-02 15:04:05"))
+	fmt.Fprintln(w, `</svg>`)
+
+	return w.Flush()
+}
+
+func (r *SVGRenderer) writeLink(w io.Writer, m *config.Map, nodes map[string]config.Node, link config.Link, data config.LinkData, onPath bool) {
+	points := linkPoints(nodes, link)
+	if points == nil {
+		return
+	}
+
+	width := linkWidth(link, data)
+	color := LinkColor(m, link, data)
+
+	if onPath {
+		fmt.Fprintf(w, `<path class="path" d="%s" fill="none" stroke="%s" stroke-width="%d" stroke-linecap="round" stroke-opacity="0.6"/>`+"\n",
+			svgPath(points), HexColor(pathColor), width+pathHalo)
+	}
+	fmt.Fprintf(w, `<path id="link-%s" d="%s" fill="none" stroke="%s" stroke-width="%d" stroke-linecap="round"`,
+		html.EscapeString(link.Name), svgPath(points), HexColor(color), width)
+	if data.Style != nil && data.Style.Dash != "" {
+		fmt.Fprintf(w, ` stroke-dasharray="%s"`, data.Style.Dash)
+	} else if data.Status == "down" {
+		fmt.Fprintf(w, ` stroke-dasharray="%d,%d"`, width*2, width*2)
+	}
+	fmt.Fprintf(w, `><title>%s</title></path>`+"\n", html.EscapeString(link.Name))
+	if spacing := linkHatch(m, link, data); spacing > 0 {
+		fmt.Fprintf(w, `<path class="hatch" d="%s" fill="none" stroke="%s" stroke-width="%d" stroke-dasharray="%g,%g"/>`+"\n",
+			svgPath(points), HexColor(contrastColor(color)), width, hatchTickWidth, spacing-hatchTickWidth)
+	}
+
+	label := midpoint(points)
+	if link.BWLabelPos != nil {
+		label = point{float64(link.BWLabelPos.X), float64(link.BWLabelPos.Y)}
+	}
+	text := linkLabel(data)
+	boxWidth := len(text)*7 + 6
+	borderWidth, bold := labelStrength(m)
+	border := labelBorderColor(m, link, data)
+	if data.CommitUtilization != nil {
+		borderWidth = 2
+	}
+	fmt.Fprintf(w, `<rect x="%.1f" y="%.1f" width="%d" height="16" fill="%s" stroke="%s" stroke-width="%d"/>`+"\n",
+		label.X-float64(boxWidth)/2, label.Y-8, boxWidth, HexColor(labelBoxColor), HexColor(border), borderWidth)
+	fmt.Fprintf(w, `<text x="%.1f" y="%.1f" font-size="11"%s text-anchor="middle" dominant-baseline="central" fill="%s">%s</text>`+"\n",
+		label.X, label.Y, svgWeight(bold), HexColor(textColor), html.EscapeString(text))
+}
+
+// withInfoURL makes what draw writes a clickable area opening url in a new tab
+func withInfoURL(w io.Writer, url string, draw func()) {
+	if url == "" {
+		draw()
+		return
+	}
+	fmt.Fprintf(w, `<a xlink:href="%s" target="_blank">`+"\n", html.EscapeString(url))
+	draw()
+	fmt.Fprintln(w, `</a>`)
+}
+
+// writePlanned draws the planned load as a dashed stripe over the link
+func writePlanned(w io.Writer, m *config.Map, nodes map[string]config.Node, link config.Link, data config.PlannedLinkData) {
+	points := linkPoints(nodes, link)
+	if points == nil {
+		return
+	}
+	color := ColorForUtilization(ScaleFor(m, link), data.Utilization)
+	fmt.Fprintf(w, `<path class="planned" d="%s" fill="none" stroke="%s" stroke-width="%d" stroke-dasharray="6,4"><title>%s planned %.1f%%</title></path>`+"\n",
+		svgPath(points), HexColor(color), plannedWidth(link), html.EscapeString(link.Name), data.Utilization)
+}
+
+func (r *SVGRenderer) writeNode(w io.Writer, m *config.MapWithData, node config.Node, icons map[string]string) {
+	x, y := node.Position.X, node.Position.Y
+	labelY := y + labelFontSize/2
+	fmt.Fprintf(w, `<g class="node"><title>%s</title>`+"\n", html.EscapeString(nodeTitle(m, node)))
+
+	if radius, fill, ok := clusterMarker(m, node.Name); ok {
+		fmt.Fprintf(w, `<circle class="cluster" cx="%d" cy="%d" r="%.1f" fill="%s" stroke="%s" stroke-width="2"/>`+"\n",
+			x, y, radius, HexColor(fill), HexColor(textColor))
+		labelY = y + int(radius) + labelFontSize
+	} else if href := r.iconHref(node.Icon, icons); href != "" {
+		if color, ok := nodeStatusColor(m, node.Name); ok {
+			fmt.Fprintf(w, `<circle class="node-status" cx="%d" cy="%d" r="%d" fill="none" stroke="%s" stroke-width="3"/>`+"\n",
+				x, y, iconSize/2+3, HexColor(color))
+		}
+		fmt.Fprintf(w, `<image x="%d" y="%d" width="%d" height="%d" xlink:href="%s"/>`+"\n",
+			x-iconSize/2, y-iconSize/2, iconSize, iconSize, href)
+		labelY = y + iconSize/2 + labelFontSize
+	}
+
+	label := html.EscapeString(nodeLabel(node))
+	boxWidth := len(nodeLabel(node))*7 + 8
+	borderWidth, bold := labelStrength(m.Map)
+	border := textColor
+	if onPathNode(m, node.Name) {
+		border, borderWidth = pathColor, 2
+	}
+	fmt.Fprintf(w, `<rect x="%d" y="%d" width="%d" height="%d" fill="%s" stroke="%s" stroke-width="%d"/>`+"\n",
+		x-boxWidth/2, labelY-labelFontSize+2, boxWidth, labelFontSize+4, HexColor(labelBoxColor), HexColor(border), borderWidth)
+	fmt.Fprintf(w, `<text x="%d" y="%d" font-size="%d"%s text-anchor="middle" fill="%s">%s</text>`+"\n",
+		x, labelY+1, labelFontSize, svgWeight(bold), HexColor(textColor), label)
+	fmt.Fprintln(w, `</g>`)
+}
+
+// nodeTitle is the hover text of a node: its label, then its status, uptime and metrics in
+// the order the node lists them
+func nodeTitle(m *config.MapWithData, node config.Node) string {
+	lines := []string{nodeLabel(node)}
+	i := slices.IndexFunc(m.NodesData, func(d config.NodeData) bool { return d.Name == node.Name })
+	if i < 0 {
+		return lines[0]
+	}
+	data := m.NodesData[i]
+	lines = append(lines, "status: "+data.Status)
+	if data.UptimeSeconds != nil {
+		lines = append(lines, "uptime: "+formatUptime(*data.UptimeSeconds))
+	}
+	for _, metric := range node.Metrics {
+		if value, ok := data.Metrics[metric]; ok && value != nil {
+			lines = append(lines, fmt.Sprintf("%s: %v", metric, value))
+		}
+	}
+	if data.Error != "" {
+		lines = append(lines, "error: "+data.Error)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatUptime writes an uptime in days and hours, or hours and minutes under a day
+func formatUptime(seconds int64) string {
+	if days := seconds / 86400; days > 0 {
+		return fmt.Sprintf("%dd %dh", days, seconds%86400/3600)
+	}
+	return fmt.Sprintf("%dh %dm", seconds/3600, seconds%3600/60)
+}
+
+func (r *SVGRenderer) writeLegend(w io.Writer, m *config.Map) {
+	bands := ScaleFor(m, config.Link{})
+	x := m.Width - 110
+	y := 10
+	fmt.Fprintf(w, `<g class="legend"><rect x="%d" y="%d" width="100" height="%d" fill="%s" stroke="%s" stroke-width="1"/>`+"\n",
+		x, y, len(bands)*14+22, HexColor(labelBoxColor), HexColor(textColor))
+	fmt.Fprintf(w, `<text x="%d" y="%d" font-size="11" font-weight="bold" fill="%s">Utilization</text>`+"\n",
+		x+6, y+14, HexColor(textColor))
+	for i, band := range bands {
+		rowY := y + 20 + i*14
+		fmt.Fprintf(w, `<rect x="%d" y="%d" width="20" height="10" fill="%s" stroke="%s" stroke-width="0.5"/>`+"\n",
+			x+6, rowY, HexColor(band.Color), HexColor(textColor))
+		if m.Accessible {
+			if spacing := bandHatch(i); spacing > 0 {
+				fmt.Fprintf(w, `<line class="hatch" x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s" stroke-width="10" stroke-dasharray="%g,%g"/>`+"\n",
+					x+6, rowY+5, x+26, rowY+5, HexColor(contrastColor(band.Color)), hatchTickWidth, spacing-hatchTickWidth)
+			}
+		}
+		fmt.Fprintf(w, `<text x="%d" y="%d" font-size="10" fill="%s">%g-%g%%</text>`+"\n",
+			x+32, rowY+9, HexColor(textColor), band.Min, band.Max)
+	}
+	fmt.Fprintln(w, `</g>`)
+}
+
+// iconHref embeds icons as data URIs so the output doesn't depend on the API being reachable
+func (r *SVGRenderer) iconHref(name string, cache map[string]string) string {
+	if name == "" || r.loadIcon == nil {
+		return ""
+	}
+	if href, ok := cache[name]; ok {
+		return href
+	}
+	data, contentType, err := r.loadIcon(name)
+	href := ""
+	if err == nil {
+		href = "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data)
+	}
+	cache[name] = href
+	return href
+}
+
+func svgPath(points []point) string {
+	path := fmt.Sprintf("M%.1f,%.1f", points[0].X, points[0].Y)
+	if len(points) == 2 {
+		return path + fmt.Sprintf(" L%.1f,%.1f", points[1].X, points[1].Y)
+	}
+	for _, seg := range bezierSegments(points) {
+		path += fmt.Sprintf(" C%.1f,%.1f %.1f,%.1f %.1f,%.1f",
+			seg[0].X, seg[0].Y, seg[1].X, seg[1].Y, seg[2].X, seg[2].Y)
+	}
+	return path
+}
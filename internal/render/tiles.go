@@ -0,0 +1,67 @@
+package render
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+
+	xdraw "golang.org/x/image/draw"
+
+	"go-weathermap/internal/config"
+)
+
+const (
+	TileSize    = 256
+	MaxTileZoom = 10
+)
+
+// TileRegion returns the part of the map covered by tile x, y of zoom level z. Level 0
+// fits the whole map in one tile and every level halves the side of the tiles.
+func TileRegion(m *config.Map, z, x, y int) (image.Rectangle, error) {
+	if z < 0 || z > MaxTileZoom {
+		return image.Rectangle{}, fmt.Errorf("invalid zoom level %d: must be between 0 and %d", z, MaxTileZoom)
+	}
+	side := tileSide(m, z)
+	if x < 0 || y < 0 || x*side >= m.Width || y*side >= m.Height {
+		return image.Rectangle{}, fmt.Errorf("tile %d/%d/%d is outside of the map", z, x, y)
+	}
+	return image.Rect(x*side, y*side, (x+1)*side, (y+1)*side), nil
+}
+
+// TileScale returns how many tile pixels show one map pixel at zoom level z
+func TileScale(m *config.Map, z int) float64 {
+	return float64(TileSize) / float64(tileSide(m, z))
+}
+
+func tileSide(m *config.Map, z int) int {
+	return max(1, int(math.Ceil(float64(max(m.Width, m.Height))/float64(int(1)<<z))))
+}
+
+// RenderTile draws one TileSize square tile, the part beyond the map edges stays transparent.
+// Tiles above the native resolution of the map are upscaled.
+func (r *PNGRenderer) RenderTile(out io.Writer, m *config.MapWithData, z, x, y int) error {
+	if m == nil || m.Map == nil {
+		return fmt.Errorf("nothing to render")
+	}
+	if err := checkMapSize(m.Map); err != nil {
+		return err
+	}
+	region, err := TileRegion(m.Map, z, x, y)
+	if err != nil {
+		return err
+	}
+
+	img := r.draw(m)
+	visible := region.Intersect(img.Bounds())
+	scale := float64(TileSize) / float64(region.Dx())
+	target := image.Rect(
+		int(math.Round(float64(visible.Min.X-region.Min.X)*scale)), int(math.Round(float64(visible.Min.Y-region.Min.Y)*scale)),
+		int(math.Round(float64(visible.Max.X-region.Min.X)*scale)), int(math.Round(float64(visible.Max.Y-region.Min.Y)*scale)),
+	)
+	tile := image.NewRGBA(image.Rect(0, 0, TileSize, TileSize))
+	xdraw.CatmullRom.Scale(tile, target, img, visible, draw.Src, nil)
+	return png.Encode(out, tile)
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+
+	// role assignments live next to the maps in their own directory, so the file isn't listed as a map
+	accessDirName  = "access"
+	accessFileName = "roles.yaml"
+
+	defaultGroupsClaim = "groups"
+)
+
+var roleRanks = map[string]int{RoleViewer: 1, RoleEditor: 2, RoleAdmin: 3}
+
+// AccessPolicy assigns roles on maps to users and identity provider groups
+type AccessPolicy struct {
+	GroupsClaim string              `yaml:"groups_claim"` // token claim listing the groups of the caller, groups by default
+	MapGroups   map[string][]string `yaml:"map_groups"`   // group name -> map names or patterns
+	Rules       []AccessRule        `yaml:"rules"`
+}
+
+// AccessRule grants a role on maps matching Maps to callers matching Subjects. Maps are
+// names, path.Match patterns like backbone-* or group:<map group>. Subjects are
+// user:<sub claim>, group:<token group> or * for every authenticated caller.
+type AccessRule struct {
+	Maps     []string `yaml:"maps"`
+	Subjects []string `yaml:"subjects"`
+	Role     string   `yaml:"role"`
+}
+
+// Principal is the authenticated caller a role is looked up for
+type Principal struct {
+	Subject string
+	Groups  []string
+}
+
+type accessCache struct {
+	mu      sync.Mutex
+	modTime time.Time
+	policy  *AccessPolicy
+}
+
+func (s *MapService) accessFile() string {
+	return filepath.Join(s.configDir, accessDirName, accessFileName)
+}
+
+// AccessPolicy returns the role assignments of the maps, nil when access/roles.yaml doesn't
+// exist and every authenticated caller may do anything. The file is read again when it changes.
+func (s *MapService) AccessPolicy() (*AccessPolicy, error) {
+	s.access.mu.Lock()
+	defer s.access.mu.Unlock()
+
+	info, err := os.Stat(s.accessFile())
+	if os.IsNotExist(err) {
+		s.access.policy, s.access.modTime = nil, time.Time{}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.access.policy != nil && info.ModTime().Equal(s.access.modTime) {
+		return s.access.policy, nil
+	}
+
+	policy, modTime, err := readAccessPolicy(s.accessFile())
+	if err != nil {
+		return nil, err
+	}
+	s.access.policy, s.access.modTime = policy, modTime
+	return policy, nil
+}
+
+// readAccessPolicy reads and validates the policy of file, nil when it doesn't exist
+func readAccessPolicy(file string) (*AccessPolicy, time.Time, error) {
+	info, err := os.Stat(file)
+	if os.IsNotExist(err) {
+		return nil, time.Time{}, nil
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var policy AccessPolicy
+	if err := yaml.Unmarshal(content, &policy); err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid access policy: %w", err)
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, time.Time{}, err
+	}
+	return &policy, info.ModTime(), nil
+}
+
+func (p *AccessPolicy) Validate() error {
+	if p.GroupsClaim == "" {
+		p.GroupsClaim = defaultGroupsClaim
+	}
+	for group, patterns := range p.MapGroups {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid access policy: map group %s: bad pattern %q", group, pattern)
+			}
+		}
+	}
+	for i, rule := range p.Rules {
+		if _, ok := roleRanks[rule.Role]; !ok {
+			return fmt.Errorf("invalid access policy: rule %d: role must be %s, %s or %s", i+1, RoleViewer, RoleEditor, RoleAdmin)
+		}
+		if len(rule.Maps) == 0 || len(rule.Subjects) == 0 {
+			return fmt.Errorf("invalid access policy: rule %d: maps and subjects are required", i+1)
+		}
+		for _, pattern := range rule.Maps {
+			if group, ok := strings.CutPrefix(pattern, "group:"); ok {
+				if _, ok := p.MapGroups[group]; !ok {
+					return fmt.Errorf("invalid access policy: rule %d: unknown map group %s", i+1, group)
+				}
+			} else if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid access policy: rule %d: bad pattern %q", i+1, pattern)
+			}
+		}
+		for _, subject := range rule.Subjects {
+			if subject != "*" && !strings.HasPrefix(subject, "user:") && !strings.HasPrefix(subject, "group:") {
+				return fmt.Errorf("invalid access policy: rule %d: subject %q must be user:<name>, group:<name> or *", i+1, subject)
+			}
+		}
+	}
+	return nil
+}
+
+// Role returns the highest role any rule grants principal on the map, empty without access
+func (p *AccessPolicy) Role(principal Principal, mapName string) string {
+	role := ""
+	for _, rule := range p.Rules {
+		if roleRanks[rule.Role] <= roleRanks[role] {
+			continue
+		}
+		if p.matchesSubject(rule, principal) && p.matchesMap(rule, mapName) {
+			role = rule.Role
+		}
+	}
+	return role
+}
+
+func (p *AccessPolicy) matchesSubject(rule AccessRule, principal Principal) bool {
+	for _, subject := range rule.Subjects {
+		if subject == "*" {
+			return true
+		}
+		if user, ok := strings.CutPrefix(subject, "user:"); ok && user == principal.Subject {
+			return true
+		}
+		if group, ok := strings.CutPrefix(subject, "group:"); ok && slices.Contains(principal.Groups, group) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *AccessPolicy) matchesMap(rule AccessRule, mapName string) bool {
+	for _, pattern := range rule.Maps {
+		patterns := []string{pattern}
+		if group, ok := strings.CutPrefix(pattern, "group:"); ok {
+			patterns = p.MapGroups[group]
+		}
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, mapName); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RoleAllows reports whether role includes the permissions of required, admins can edit
+// and editors can view
+func RoleAllows(role, required string) bool {
+	return role != "" && roleRanks[role] >= roleRanks[required]
+}
+
+// MapNameFromTitle derives the name a map created without one is stored under
+func MapNameFromTitle(title string) string {
+	return strings.ToLower(strings.ReplaceAll(title, " ", "-"))
+}
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go-weathermap/internal/config"
+)
+
+const (
+	AgentPollerType        = "agent"
+	DefaultAgentStaleAfter = time.Minute
+)
+
+// AgentPoller doesn't poll anything: remote agents inside isolated network segments
+// push values of datasources declared with type agent and the agent name in params.
+type AgentPoller struct {
+	EmbeddedPoller
+
+	agentsMu   sync.RWMutex
+	dsAgents   map[string]string        // datasource -> agent allowed to push it
+	staleAfter map[string]time.Duration // datasource -> max age of pushed values
+	lastPush   map[string]time.Time     // datasource -> last push
+	agents     map[string]*agentState
+}
+
+type agentState struct {
+	lastPush   time.Time
+	remoteAddr string
+	pushes     int64
+}
+
+type AgentStatus struct {
+	Name        string    `json:"name"`
+	Datasources []string  `json:"datasources"`
+	LastPush    time.Time `json:"last_push,omitempty"`
+	RemoteAddr  string    `json:"remote_addr,omitempty"`
+	Pushes      int64     `json:"pushes"`
+	Stale       bool      `json:"stale"`
+}
+
+func NewAgentPoller() *AgentPoller {
+	return &AgentPoller{
+		EmbeddedPoller: EmbeddedPoller{cache: make(map[string]int64)},
+		dsAgents:       make(map[string]string),
+		staleAfter:     make(map[string]time.Duration),
+		lastPush:       make(map[string]time.Time),
+		agents:         make(map[string]*agentState),
+	}
+}
+
+func agentKey(dsName, ifaceName, metricName string) string {
+	return fmt.Sprintf("%s:%s:%s", dsName, ifaceName, metricName)
+}
+
+func (p *AgentPoller) AddTask(ds config.DataSourceConfig, iface config.InterfaceConfig, metricName string, interval time.Duration) {
+	agent, _ := ds.Params["agent"].(string)
+	if agent == "" {
+		p.log().Warn("datasource has type agent but no agent param", "datasource", ds.Name)
+		return
+	}
+	staleAfter := DefaultAgentStaleAfter
+	if value, ok := ds.Params["stale_after"].(string); ok {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			staleAfter = d
+		}
+	}
+
+	p.agentsMu.Lock()
+	p.dsAgents[ds.Name] = agent
+	p.staleAfter[ds.Name] = staleAfter
+	if _, ok := p.agents[agent]; !ok {
+		p.agents[agent] = &agentState{}
+	}
+	p.agentsMu.Unlock()
+
+	p.EmbeddedPoller.AddTask(dataPollTask{
+		Host:             agent,
+		MetricIdentifier: metricName,
+		Key:              agentKey(ds.Name, iface.Name, metricName),
+		DS:               ds,
+		Interval:         interval,
+	})
+}
+
+func (p *AgentPoller) Start() {}
+
+func (p *AgentPoller) RemoveTasks(dsName string) {
+	p.EmbeddedPoller.RemoveTasks(dsName)
+
+	p.agentsMu.Lock()
+	defer p.agentsMu.Unlock()
+	agent := p.dsAgents[dsName]
+	delete(p.dsAgents, dsName)
+	delete(p.staleAfter, dsName)
+	delete(p.lastPush, dsName)
+	for _, other := range p.dsAgents {
+		if other == agent {
+			return
+		}
+	}
+	delete(p.agents, agent)
+}
+
+func (p *AgentPoller) GetMetric(ds config.DataSourceConfig, iface config.InterfaceConfig, metricName string) interface{} {
+	val, _ := p.GetCache(agentKey(ds.Name, iface.Name, metricName))
+	return val
+}
+
+// Push stores values sent by an agent, every datasource must be assigned to that agent
+func (p *AgentPoller) Push(agent, remoteAddr string, snapshot MetricsSnapshot) error {
+	p.agentsMu.Lock()
+	state, ok := p.agents[agent]
+	if !ok {
+		p.agentsMu.Unlock()
+		return fmt.Errorf("unknown agent: %s", agent)
+	}
+	for dsName := range snapshot {
+		if owner, ok := p.dsAgents[dsName]; !ok || owner != agent {
+			p.agentsMu.Unlock()
+			return fmt.Errorf("datasource %s is not assigned to agent %s", dsName, agent)
+		}
+	}
+	now := time.Now()
+	for dsName := range snapshot {
+		p.lastPush[dsName] = now
+	}
+	state.lastPush = now
+	state.remoteAddr = remoteAddr
+	state.pushes++
+	p.agentsMu.Unlock()
+
+	for dsName, ifaces := range snapshot {
+		for ifaceName, metrics := range ifaces {
+			for metric, val := range metrics {
+				key := agentKey(dsName, ifaceName, metric)
+				p.SetCache(key, val)
+				p.capture(dataPollTask{Key: key, MetricIdentifier: agent}, val, true, nil)
+			}
+		}
+	}
+	return nil
+}
+
+// checkAvailable fails when the agent never pushed the datasource or stopped pushing it
+func (p *AgentPoller) checkAvailable(ds config.DataSourceConfig, _ config.InterfaceConfig) error {
+	p.agentsMu.RLock()
+	defer p.agentsMu.RUnlock()
+	agent := p.dsAgents[ds.Name]
+	last, ok := p.lastPush[ds.Name]
+	if !ok {
+		return fmt.Errorf("no data from agent %s yet", agent)
+	}
+	if age := time.Since(last); age > p.staleAfter[ds.Name] {
+		return fmt.Errorf("agent %s last pushed %s ago", agent, age.Round(time.Second))
+	}
+	return nil
+}
+
+func (p *AgentPoller) Agents() []AgentStatus {
+	p.agentsMu.RLock()
+	defer p.agentsMu.RUnlock()
+
+	result := make([]AgentStatus, 0, len(p.agents))
+	for name, state := range p.agents {
+		status := AgentStatus{
+			Name:       name,
+			LastPush:   state.lastPush,
+			RemoteAddr: state.remoteAddr,
+			Pushes:     state.pushes,
+		}
+		for dsName, agent := range p.dsAgents {
+			if agent != name {
+				continue
+			}
+			status.Datasources = append(status.Datasources, dsName)
+			if last := p.lastPush[dsName]; last.IsZero() || time.Since(last) > p.staleAfter[dsName] {
+				status.Stale = true
+			}
+		}
+		sort.Strings(status.Datasources)
+		result = append(result, status)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// availabilityChecker is implemented by pollers which know when the values of an interface
+// can't be trusted
+type availabilityChecker interface {
+	checkAvailable(ds config.DataSourceConfig, iface config.InterfaceConfig) error
+}
+
+func (s *DataSourceService) agentPoller() (*AgentPoller, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if p, ok := s.pollers[AgentPollerType].(*AgentPoller); ok {
+		return p, nil
+	}
+	return nil, fmt.Errorf("no datasources of type %s configured", AgentPollerType)
+}
+
+func (s *DataSourceService) PushAgentMetrics(agent, remoteAddr string, snapshot MetricsSnapshot) error {
+	p, err := s.agentPoller()
+	if err != nil {
+		return err
+	}
+	return p.Push(agent, remoteAddr, snapshot)
+}
+
+func (s *DataSourceService) ListAgents() []AgentStatus {
+	p, err := s.agentPoller()
+	if err != nil {
+		return []AgentStatus{}
+	}
+	return p.Agents()
+}
+
+// AgentPush is the body an agent posts to the central server
+type AgentPush struct {
+	Agent       string          `json:"agent"`
+	SentAt      time.Time       `json:"sent_at"`
+	Datasources MetricsSnapshot `json:"datasources"`
+}
@@ -0,0 +1,335 @@
+package service
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/expr"
+)
+
+const (
+	DefaultAlertInterval = 30 * time.Second
+	alertTimeout         = 10 * time.Second
+	defaultTelegramURL   = "https://api.telegram.org"
+)
+
+// States of an alert
+const (
+	AlertPending  = "pending" // the condition holds, not for long enough yet
+	AlertFiring   = "firing"
+	AlertResolved = "resolved" // only in notifications
+)
+
+// AlertIntervalFromEnv reads WEATHERMAP_ALERT_INTERVAL, 0 disables the evaluation of alert rules
+func AlertIntervalFromEnv() (time.Duration, error) {
+	value := os.Getenv("WEATHERMAP_ALERT_INTERVAL")
+	if value == "" {
+		return DefaultAlertInterval, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < 0 {
+		return 0, fmt.Errorf("invalid WEATHERMAP_ALERT_INTERVAL: %s", value)
+	}
+	return interval, nil
+}
+
+// Alert is a rule whose condition holds for a link
+type Alert struct {
+	Rule        string     `json:"rule"`
+	Link        string     `json:"link"`
+	State       string     `json:"state"`
+	Condition   string     `json:"condition"`
+	Utilization float64    `json:"utilization"`
+	LinkStatus  string     `json:"link_status"`
+	Since       time.Time  `json:"since"`              // first evaluation the condition held
+	FiredAt     *time.Time `json:"fired_at,omitempty"` // once firing
+}
+
+// AlertNotification is the JSON body sent to webhook receivers
+type AlertNotification struct {
+	Status      string     `json:"status"` // firing or resolved
+	Map         string     `json:"map"`
+	Rule        string     `json:"rule"`
+	Link        string     `json:"link"`
+	Condition   string     `json:"condition"`
+	Utilization float64    `json:"utilization"`
+	LinkStatus  string     `json:"link_status"`
+	StartedAt   time.Time  `json:"started_at"`
+	ResolvedAt  *time.Time `json:"resolved_at,omitempty"`
+	Message     string     `json:"message"`
+}
+
+type alertKey struct {
+	mapName, rule, link string
+}
+
+// alerting keeps the pending and firing alerts of every map, notifications are only sent when
+// an alert fires or resolves so a condition holding over many evaluations notifies once
+type alerting struct {
+	mu          sync.Mutex
+	enabled     bool // set by WatchAlerts
+	alerts      map[alertKey]*Alert
+	client      *http.Client
+	telegramURL string
+}
+
+func newAlerting() *alerting {
+	return &alerting{
+		alerts:      make(map[alertKey]*Alert),
+		client:      &http.Client{Timeout: alertTimeout},
+		telegramURL: defaultTelegramURL,
+	}
+}
+
+type alertEvent struct {
+	notification AlertNotification
+	receivers    []config.AlertReceiver
+}
+
+// GetAlerts returns the pending and firing alerts of a map, by rule and link
+func (s *MapService) GetAlerts(name string) ([]Alert, error) {
+	if _, err := s.loadMapConfig(name); err != nil {
+		return nil, err
+	}
+	s.alerts.mu.Lock()
+	defer s.alerts.mu.Unlock()
+	alerts := []Alert{}
+	for key, alert := range s.alerts.alerts {
+		if key.mapName == name {
+			alerts = append(alerts, *alert)
+		}
+	}
+	slices.SortFunc(alerts, func(a, b Alert) int {
+		return cmp.Or(cmp.Compare(a.Rule, b.Rule), cmp.Compare(a.Link, b.Link))
+	})
+	return alerts, nil
+}
+
+// EvaluateAlerts evaluates the alert rules of every map against its current data and notifies
+// the receivers of the alerts which fired or resolved. Alerts of rules, links or maps which no
+// longer exist are dropped without notification.
+func (s *MapService) EvaluateAlerts(ctx context.Context, dsService *DataSourceService) error {
+	names, err := s.ListMaps()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	seen := make(map[alertKey]bool)
+	var events []alertEvent
+	for _, name := range names {
+		m, err := s.loadMapConfig(name)
+		if err != nil || len(m.Alerts) == 0 {
+			continue
+		}
+		data, err := s.GetMapWithData(ctx, name, dsService)
+		if err != nil {
+			s.logger.Error("alert evaluation failed", "map", name, "error", err)
+			continue
+		}
+		events = append(events, s.evaluateMapAlerts(ctx, name, data, now, seen)...)
+	}
+
+	s.alerts.mu.Lock()
+	for key := range s.alerts.alerts {
+		if !seen[key] {
+			delete(s.alerts.alerts, key)
+		}
+	}
+	s.alerts.mu.Unlock()
+
+	if s.offline {
+		return nil // receivers of sandbox maps are never notified
+	}
+	for _, event := range events {
+		for _, receiver := range event.receivers {
+			if err := s.alerts.notify(ctx, receiver, event.notification); err != nil && ctx.Err() == nil {
+				s.logger.Error("alert notification failed", "map", event.notification.Map, "rule", event.notification.Rule, "receiver", receiver.Name, "error", err)
+			}
+		}
+	}
+	return nil
+}
+
+// evaluateMapAlerts updates the alerts of a map and returns the events to notify, the keys of
+// the alerts kept are added to seen. A condition failing to evaluate keeps the alert as it is.
+func (s *MapService) evaluateMapAlerts(ctx context.Context, name string, data *config.MapWithData, now time.Time, seen map[alertKey]bool) []alertEvent {
+	receivers := make(map[string]config.AlertReceiver, len(data.Receivers))
+	for _, receiver := range data.Receivers {
+		receivers[receiver.Name] = receiver
+	}
+
+	s.alerts.mu.Lock()
+	defer s.alerts.mu.Unlock()
+	var events []alertEvent
+	for _, rule := range data.Alerts {
+		program, err := expr.Compile(rule.Condition)
+		if err != nil {
+			continue // the map was validated when loaded
+		}
+		hold, _ := time.ParseDuration(rule.For)
+		active := true
+		if rule.Schedule != "" {
+			active = false
+			for _, schedule := range data.Schedules {
+				if schedule.Name == rule.Schedule {
+					active, err = s.ScheduleActive(ctx, schedule, now)
+					if err != nil {
+						s.logger.Warn("alert schedule calendar failed", "map", name, "rule", rule.Name, "error", err)
+					}
+				}
+			}
+		}
+		var notify []config.AlertReceiver
+		for _, receiver := range rule.Notify {
+			notify = append(notify, receivers[receiver])
+		}
+
+		for i, link := range data.Links {
+			if len(rule.Links) > 0 && !slices.Contains(rule.Links, link.Name) {
+				continue
+			}
+			key := alertKey{name, rule.Name, link.Name}
+			alert := s.alerts.alerts[key]
+			linkData := data.LinksData[i]
+
+			matches := false
+			if active {
+				value, err := program.Eval(linkStyleVars(link, linkData))
+				if err == nil {
+					matches, _ = value.(bool)
+					if _, ok := value.(bool); !ok {
+						err = fmt.Errorf("got %v, want a bool", value)
+					}
+				}
+				if err != nil {
+					s.logger.Debug("alert condition failed", "map", name, "rule", rule.Name, "link", link.Name, "error", err)
+					if alert != nil {
+						seen[key] = true
+					}
+					continue
+				}
+			}
+
+			if !matches {
+				if alert != nil && alert.State == AlertFiring {
+					resolved := now
+					events = append(events, alertEvent{alertNotification(name, *alert, AlertResolved, linkData, &resolved), notify})
+				}
+				continue
+			}
+			seen[key] = true
+			if alert == nil {
+				alert = &Alert{Rule: rule.Name, Link: link.Name, State: AlertPending, Condition: rule.Condition, Since: now}
+				s.alerts.alerts[key] = alert
+			}
+			alert.Utilization = linkData.Utilization
+			alert.LinkStatus = linkData.Status
+			if alert.State == AlertPending && now.Sub(alert.Since) >= hold {
+				fired := now
+				alert.State = AlertFiring
+				alert.FiredAt = &fired
+				events = append(events, alertEvent{alertNotification(name, *alert, AlertFiring, linkData, nil), notify})
+			}
+		}
+	}
+	return events
+}
+
+func alertNotification(mapName string, alert Alert, status string, data config.LinkData, resolvedAt *time.Time) AlertNotification {
+	n := AlertNotification{
+		Status:      status,
+		Map:         mapName,
+		Rule:        alert.Rule,
+		Link:        alert.Link,
+		Condition:   alert.Condition,
+		Utilization: data.Utilization,
+		LinkStatus:  data.Status,
+		StartedAt:   alert.Since,
+		ResolvedAt:  resolvedAt,
+	}
+	n.Message = fmt.Sprintf("[%s] %s: %s on link %s (%s), utilization %.1f%%, status %s",
+		strings.ToUpper(status), mapName, alert.Rule, alert.Link, alert.Condition, data.Utilization, data.Status)
+	if resolvedAt != nil {
+		n.Message += fmt.Sprintf(", after %s", resolvedAt.Sub(alert.Since).Round(time.Second))
+	}
+	return n
+}
+
+// notify sends a notification to a receiver, as JSON for webhooks and as a text message for
+// Slack and Telegram
+func (a *alerting) notify(ctx context.Context, receiver config.AlertReceiver, n AlertNotification) error {
+	var target string
+	var body any
+	switch receiver.Type {
+	case config.ReceiverWebhook:
+		target, body = receiver.URL, n
+	case config.ReceiverSlack:
+		target, body = receiver.URL, map[string]string{"text": n.Message}
+	case config.ReceiverTelegram:
+		base := cmp.Or(receiver.URL, a.telegramURL)
+		target = strings.TrimSuffix(base, "/") + "/bot" + receiver.BotToken + "/sendMessage"
+		body = map[string]string{"chat_id": receiver.ChatID, "text": n.Message}
+	default:
+		return fmt.Errorf("unsupported receiver type: %s", receiver.Type)
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		// the URL of Telegram holds the bot token
+		if receiver.Type == config.ReceiverTelegram {
+			return fmt.Errorf("telegram request failed")
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// AlertsEnabled reports whether the alert rules of the maps are evaluated
+func (s *MapService) AlertsEnabled() bool {
+	s.alerts.mu.Lock()
+	defer s.alerts.mu.Unlock()
+	return s.alerts.enabled
+}
+
+// WatchAlerts evaluates the alert rules of the maps every interval until Stop
+func (s *MapService) WatchAlerts(dsService *DataSourceService, interval time.Duration) {
+	s.alerts.mu.Lock()
+	s.alerts.enabled = true
+	s.alerts.mu.Unlock()
+	s.loops.run(func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := s.EvaluateAlerts(ctx, dsService); err != nil && ctx.Err() == nil {
+				s.logger.Error("alert evaluation failed", "error", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"go-weathermap/internal/config"
+)
+
+const (
+	// anomalyWindow is the part of every previous week compared with now, on both sides
+	anomalyWindow = 30 * time.Minute
+	// baselines are kept for a while, reading weeks of history on every request isn't free
+	anomalyBaselineTTL = 5 * time.Minute
+	// minAnomalyWeeks of history are needed before a link gets a baseline
+	minAnomalyWeeks = 2
+	// minAnomalyStdDev keeps flat links from being anomalous by a fraction of a percent
+	minAnomalyStdDev = 1.0
+)
+
+type baseline struct {
+	mean, stddev float64
+}
+
+type baselineEntry struct {
+	computed time.Time
+	weeks    int
+	links    map[string]baseline
+}
+
+// baselineCache keeps the baselines of every map with anomaly detection
+type baselineCache struct {
+	mu      sync.Mutex
+	entries map[string]baselineEntry
+}
+
+// baselines returns the usual utilization of the links of a map around the time of the week
+// of now, from the rollups of the previous weeks
+func (h *historyStore) baselines(mapName string, weeks int, now time.Time) map[string]baseline {
+	h.cache.mu.Lock()
+	defer h.cache.mu.Unlock()
+	if entry, ok := h.cache.entries[mapName]; ok && entry.weeks == weeks && now.Sub(entry.computed).Abs() < anomalyBaselineTTL {
+		return entry.links
+	}
+
+	values := make(map[string][]float64)
+	weeksSeen := make(map[string]int)
+	h.mu.Lock()
+	for week := 1; week <= weeks; week++ {
+		at := now.Add(-time.Duration(week) * 7 * 24 * time.Hour)
+		from, to := at.Add(-anomalyWindow), at.Add(anomalyWindow)
+		tier := "5m"
+		if from.Before(now.Add(-h.retention.FiveMinute)) {
+			tier = "1h"
+		}
+		seen := make(map[string]bool)
+		for day := from.UTC().Truncate(24 * time.Hour); day.Before(to); day = day.Add(24 * time.Hour) {
+			samples, err := readSamples(h.file(tier, mapName, day))
+			if err != nil {
+				continue
+			}
+			for _, sample := range samples {
+				if !sample.Time.Before(from) && sample.Time.Before(to) {
+					values[sample.Link] = append(values[sample.Link], sample.Utilization)
+					seen[sample.Link] = true
+				}
+			}
+		}
+		for link := range seen {
+			weeksSeen[link]++
+		}
+	}
+	h.mu.Unlock()
+
+	links := make(map[string]baseline, len(values))
+	for link, vs := range values {
+		if weeksSeen[link] < minAnomalyWeeks {
+			continue
+		}
+		var sum, squares float64
+		for _, v := range vs {
+			sum += v
+		}
+		mean := sum / float64(len(vs))
+		for _, v := range vs {
+			squares += (v - mean) * (v - mean)
+		}
+		links[link] = baseline{mean: mean, stddev: math.Sqrt(squares / float64(len(vs)))}
+	}
+	if h.cache.entries == nil {
+		h.cache.entries = make(map[string]baselineEntry)
+	}
+	h.cache.entries[mapName] = baselineEntry{computed: now, weeks: weeks, links: links}
+	return links
+}
+
+// flagAnomalies sets the baseline, score and anomalous badge of the links with traffic
+func (h *historyStore) flagAnomalies(mapName string, settings config.AnomalyDetection, linksData []config.LinkData, now time.Time) {
+	threshold := cmpOr(settings.Threshold, config.DefaultAnomalyThreshold)
+	minDeviation := cmpOr(settings.MinDeviation, config.DefaultAnomalyMinDeviation)
+	weeks := settings.Weeks
+	if weeks == 0 {
+		weeks = config.DefaultAnomalyWeeks
+	}
+
+	baselines := h.baselines(mapName, weeks, now)
+	for i := range linksData {
+		ld := &linksData[i]
+		b, ok := baselines[ld.Name]
+		if !ok || ld.Status == "down" || ld.Status == "unknown" {
+			continue
+		}
+		deviation := ld.Utilization - b.mean
+		score := math.Round(deviation/max(b.stddev, minAnomalyStdDev)*10) / 10
+		mean := math.Round(b.mean*10) / 10
+		ld.Baseline, ld.AnomalyScore = &mean, &score
+		ld.Anomalous = math.Abs(score) >= threshold && math.Abs(deviation) >= minDeviation
+	}
+}
+
+func cmpOr(value, fallback float64) float64 {
+	if value == 0 {
+		return fallback
+	}
+	return value
+}
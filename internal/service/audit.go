@@ -0,0 +1,228 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"time"
+
+	"go-weathermap/internal/config"
+)
+
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+
+	auditDirName  = "audit"
+	auditFileName = "audit.log"
+
+	// actors of the changes the server makes on its own
+	ActorDNSLabels = "system:dns-labels"
+	ActorNetBox    = "system:netbox"
+)
+
+// AuditEntry records one change of a map made through the API or by the server itself
+type AuditEntry struct {
+	Time    time.Time     `json:"time"`
+	Actor   string        `json:"actor"` // sub claim of the caller, anonymous without authentication, system:... for the server
+	Remote  string        `json:"remote"`
+	Request string        `json:"request"` // method and path, empty for the server
+	Map     string        `json:"map"`     // empty for icons, shared by every map
+	Action  string        `json:"action"`  // create, update or delete of the map or icon
+	Changes []AuditChange `json:"changes"`
+}
+
+// AuditChange is one added, removed or changed object of a map. Variables and datasources
+// often hold credentials, so only their names are recorded.
+type AuditChange struct {
+	Object string `json:"object"` // map, node, link, variable, datasource, demands or icon
+	Name   string `json:"name,omitempty"`
+	Op     string `json:"op"` // added, removed or changed
+	Before any    `json:"before,omitempty"`
+	After  any    `json:"after,omitempty"`
+}
+
+// AuditFilter selects entries of AuditEntries, zero fields match everything
+type AuditFilter struct {
+	Map   string
+	Actor string
+	Since time.Time
+}
+
+func (s *MapService) auditFile() string {
+	return filepath.Join(s.configDir, auditDirName, auditFileName)
+}
+
+// RecordAudit appends an entry to the audit log, entries are never rewritten
+func (s *MapService) RecordAudit(entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(s.auditFile()), 0750); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.auditFile(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// saveMapAs saves a map changed by the server itself, like a scheduled sync, and records the
+// changes in the audit log as made by actor. Changes made through the API are recorded by
+// the API, which knows the caller.
+func (s *MapService) saveMapAs(actor, mapName string, mapConfig *config.Map) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	before, _ := s.loadMapConfig(mapName)
+	if err := s.writeMap(mapName, mapConfig); err != nil {
+		return err
+	}
+	after, _ := s.loadMapConfig(mapName)
+	changes := DiffMaps(before, after)
+	if len(changes) == 0 {
+		return nil
+	}
+	entry := AuditEntry{Time: time.Now().UTC(), Actor: actor, Map: mapName, Action: AuditUpdate, Changes: changes}
+	if before == nil {
+		entry.Action = AuditCreate
+	}
+	if err := s.RecordAudit(entry); err != nil {
+		s.logger.Error("audit log write failed", "map", mapName, "actor", actor, "error", err)
+	}
+	return nil
+}
+
+// AuditEntries returns the entries matching filter, oldest first
+func (s *MapService) AuditEntries(filter AuditFilter) ([]AuditEntry, error) {
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+
+	entries := []AuditEntry{}
+	f, err := os.Open(s.auditFile())
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024) // whole maps are recorded on create and delete
+	for line := 1; scanner.Scan(); line++ {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("audit log line %d: %w", line, err)
+		}
+		if (filter.Map == "" || entry.Map == filter.Map) && (filter.Actor == "" || entry.Actor == filter.Actor) &&
+			!entry.Time.Before(filter.Since) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// DiffMaps lists the changes turning before into after, either may be nil for a created
+// or deleted map. Nodes and links are matched by name.
+func DiffMaps(before, after *config.Map) []AuditChange {
+	if before == nil {
+		before = &config.Map{}
+	}
+	if after == nil {
+		after = &config.Map{}
+	}
+	changes := []AuditChange{}
+
+	settings := func(m *config.Map) config.Map {
+		c := *m
+		c.Nodes, c.Links, c.Datasources, c.Variables, c.Demands = nil, nil, nil, nil, nil
+		c.UpdatedAt = nil // moves with any change of its objects, which are listed on their own
+		return c
+	}
+	if b, a := settings(before), settings(after); !reflect.DeepEqual(b, a) {
+		changes = append(changes, change("map", "", b, a, !reflect.DeepEqual(b, config.Map{}), !reflect.DeepEqual(a, config.Map{})))
+	}
+
+	nodeName := func(n config.Node) string { return n.Name }
+	changes = append(changes, diffByName("node", before.Nodes, after.Nodes, nodeName)...)
+	linkName := func(l config.Link) string { return l.Name }
+	changes = append(changes, diffByName("link", before.Links, after.Links, linkName)...)
+
+	datasourceName := func(d config.DataSourceConfig) string { return d.Name }
+	for _, c := range diffByName("datasource", before.Datasources, after.Datasources, datasourceName) {
+		c.Before, c.After = nil, nil
+		changes = append(changes, c)
+	}
+
+	names := make([]string, 0, len(before.Variables)+len(after.Variables))
+	for name := range before.Variables {
+		names = append(names, name)
+	}
+	for name := range after.Variables {
+		if _, ok := before.Variables[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		b, inBefore := before.Variables[name]
+		a, inAfter := after.Variables[name]
+		if inBefore && inAfter && a == b {
+			continue
+		}
+		changes = append(changes, change("variable", name, nil, nil, inBefore, inAfter))
+	}
+
+	if !reflect.DeepEqual(before.Demands, after.Demands) {
+		changes = append(changes, change("demands", "", before.Demands, after.Demands, len(before.Demands) > 0, len(after.Demands) > 0))
+	}
+	return changes
+}
+
+func diffByName[T any](object string, before, after []T, name func(T) string) []AuditChange {
+	var changes []AuditChange
+	old := make(map[string]T, len(before))
+	for _, item := range before {
+		old[name(item)] = item
+	}
+	current := make(map[string]bool, len(after))
+	for _, item := range after {
+		current[name(item)] = true
+		if b, ok := old[name(item)]; !ok {
+			changes = append(changes, change(object, name(item), nil, item, false, true))
+		} else if !reflect.DeepEqual(b, item) {
+			changes = append(changes, change(object, name(item), b, item, true, true))
+		}
+	}
+	for _, item := range before {
+		if !current[name(item)] {
+			changes = append(changes, change(object, name(item), item, nil, true, false))
+		}
+	}
+	return changes
+}
+
+func change(object, name string, before, after any, existed, exists bool) AuditChange {
+	c := AuditChange{Object: object, Name: name, Op: "changed", Before: before, After: after}
+	switch {
+	case !existed:
+		c.Op, c.Before = "added", nil
+	case !exists:
+		c.Op, c.After = "removed", nil
+	}
+	return c
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"go-weathermap/internal/config"
+)
+
+func TestDiffMapsIgnoresUpdatedAt(t *testing.T) {
+	created := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	before := &config.Map{
+		Title: "diff", Width: 100, Height: 100,
+		Nodes: []config.Node{{Name: "a"}, {Name: "b"}},
+		Links: []config.Link{{Name: "a-b", From: "a", To: "b", Bandwidth: "1G"}},
+	}
+	assignIDs(before)
+	stampTimes(before, nil, created)
+
+	after := *before
+	after.Nodes = slices.Clone(before.Nodes)
+	after.Links = slices.Clone(before.Links)
+	after.Nodes[1].Label = "B"
+	stampTimes(&after, before, created.Add(time.Hour))
+	if after.UpdatedAt.Equal(*before.UpdatedAt) {
+		t.Fatalf("Expected the map updated with its node, got %v", after.UpdatedAt)
+	}
+
+	changes := DiffMaps(before, &after)
+	if len(changes) != 1 || changes[0].Object != "node" || changes[0].Name != "b" || changes[0].Op != "changed" {
+		t.Errorf("Expected only node b changed, got %+v", changes)
+	}
+	for _, c := range changes {
+		if c.Object == "map" {
+			t.Errorf("Expected no map change when only UpdatedAt moved, got %+v", c)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"go-weathermap/internal/config"
+)
+
+const (
+	// ExportFormatBundle is a zip of the map file and the icons of its nodes
+	ExportFormatBundle = "bundle"
+
+	bundleIconsDir = "icons/"
+	maxBundleMap   = 10 << 20 // bytes of the map file of a bundle
+	maxBundleIcon  = 1 << 20  // bytes of an icon of a bundle
+	maxBundleIcons = 256
+)
+
+// Bundle is a map with the icons it needs to render the same on another server
+type Bundle struct {
+	Map   *config.Map
+	Icons map[string][]byte // by file name
+}
+
+// ExportBundle writes a zip with the map file as ExportYAML writes it, secrets masked, and the
+// icons of its nodes under icons/. Icons found neither in the icons directory nor in the
+// binary are left out, their nodes render without icon anyway.
+func (s *MapService) ExportBundle(mapName string) ([]byte, error) {
+	files, err := s.bundleFiles(mapName)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, file := range files {
+		if err := writeZipFile(zw, file.name, file.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ExportBundleTarGz writes the files of ExportBundle as a tar.gz
+func (s *MapService) ExportBundleTarGz(mapName string) ([]byte, error) {
+	files, err := s.bundleFiles(mapName)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, file := range files {
+		header := &tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.data)), ModTime: file.modTime}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(file.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type bundleFile struct {
+	name    string
+	data    []byte
+	modTime time.Time
+}
+
+// bundleFiles returns the map file followed by the icons of its nodes. Bundles are served to
+// viewers like the YAML export, so the map file is the export with its secrets masked.
+func (s *MapService) bundleFiles(mapName string) ([]bundleFile, error) {
+	m, err := s.loadMapConfig(mapName)
+	if err != nil {
+		return nil, err
+	}
+	content, err := s.ExportYAML(mapName)
+	if err != nil {
+		return nil, err
+	}
+	mapPath := filepath.Join(s.configDir, mapName+".yaml")
+	modTime := time.Now()
+	if info, err := os.Stat(mapPath); err == nil {
+		modTime = info.ModTime()
+	}
+	files := []bundleFile{{name: mapName + ".yaml", data: content, modTime: modTime}}
+	for _, icon := range bundleIcons(m) {
+		data, _, err := s.GetIconFile(icon)
+		if err != nil {
+			continue
+		}
+		files = append(files, bundleFile{name: bundleIconsDir + icon, data: data, modTime: modTime})
+	}
+	return files, nil
+}
+
+// bundleIcons returns the icons of the nodes of a map by name, icons outside of the icons
+// directory are skipped
+func bundleIcons(m *config.Map) []string {
+	var icons []string
+	for _, node := range m.Nodes {
+		if node.Icon == "" || node.Icon != path.Base(node.Icon) || slices.Contains(icons, node.Icon) {
+			continue
+		}
+		icons = append(icons, node.Icon)
+	}
+	slices.Sort(icons)
+	return icons
+}
+
+func writeZipFile(zw *zip.Writer, name string, data []byte) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// IsBundle reports whether content is a zip or a gzip, like the bundles written by
+// ExportBundle and ExportBundleTarGz
+func IsBundle(content []byte) bool {
+	return bytes.HasPrefix(content, []byte("PK\x03\x04")) || bytes.HasPrefix(content, []byte("\x1f\x8b"))
+}
+
+// ReadBundle reads a bundle written by ExportBundle or ExportBundleTarGz: one map file at its
+// root and icons under icons/
+func ReadBundle(content []byte) (*Bundle, error) {
+	bundle := &Bundle{Icons: make(map[string][]byte)}
+	var err error
+	if bytes.HasPrefix(content, []byte("\x1f\x8b")) {
+		err = bundle.readTarGz(content)
+	} else {
+		err = bundle.readZip(content)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if bundle.Map == nil {
+		return nil, fmt.Errorf("invalid bundle: no map file")
+	}
+	return bundle, nil
+}
+
+func (b *Bundle) readZip(content []byte) error {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return fmt.Errorf("invalid bundle: %w", err)
+	}
+	for _, file := range zr.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		r, err := file.Open()
+		if err != nil {
+			return fmt.Errorf("invalid bundle: %s: %w", file.Name, err)
+		}
+		err = b.add(file.Name, r)
+		_ = r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Bundle) readTarGz(content []byte) error {
+	gr, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return fmt.Errorf("invalid bundle: %w", err)
+	}
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid bundle: %w", err)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			continue
+		case tar.TypeReg:
+		default:
+			return fmt.Errorf("invalid bundle: %s is not a regular file", header.Name)
+		}
+		if err := b.add(strings.TrimPrefix(header.Name, "./"), tr); err != nil {
+			return err
+		}
+	}
+}
+
+// add reads a file of a bundle archive, the map file or an icon
+func (b *Bundle) add(name string, r io.Reader) error {
+	switch {
+	case path.Ext(name) == ".yaml" && !strings.Contains(name, "/"):
+		if b.Map != nil {
+			return fmt.Errorf("invalid bundle: more than one map file")
+		}
+		data, err := readBundleFile(name, r, maxBundleMap)
+		if err != nil {
+			return err
+		}
+		if b.Map, err = config.NewParser().ParseYAML(bytes.NewReader(data)); err != nil {
+			return fmt.Errorf("invalid bundle: %s: %w", name, err)
+		}
+	case strings.HasPrefix(name, bundleIconsDir):
+		icon := strings.TrimPrefix(name, bundleIconsDir)
+		if icon != path.Base(icon) || !IsIconFile(icon) {
+			return fmt.Errorf("invalid bundle: icon %s must be an svg, png, jpeg or webp file of icons/", name)
+		}
+		if len(b.Icons) == maxBundleIcons {
+			return fmt.Errorf("invalid bundle: more than %d icons", maxBundleIcons)
+		}
+		data, err := readBundleFile(name, r, maxBundleIcon)
+		if err != nil {
+			return err
+		}
+		b.Icons[icon] = data
+	default:
+		return fmt.Errorf("invalid bundle: unexpected file %s", name)
+	}
+	return nil
+}
+
+func readBundleFile(name string, r io.Reader, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid bundle: %s: %w", name, err)
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("invalid bundle: %s is larger than %d bytes", name, limit)
+	}
+	return data, nil
+}
+
+// ImportBundle adds the icons of a bundle to the icons directory and replaces the map like
+// ReplaceMap. A sandbox adds them to its own directory. An icon the server has with other
+// content is a conflict, replacing it would change the other maps using it. Nothing is
+// written then.
+func (s *MapService) ImportBundle(mapName string, bundle *Bundle) (created bool, err error) {
+	missing, err := s.missingIcons(bundle)
+	if err != nil {
+		return false, err
+	}
+	if len(missing) > 0 {
+		if err := os.MkdirAll(s.iconsDir, 0755); err != nil {
+			return false, fmt.Errorf("failed to create icons directory: %w", err)
+		}
+	}
+	for _, icon := range missing {
+		if err := s.quota.write(filepath.Join(s.iconsDir, icon), bundle.Icons[icon]); err != nil {
+			return false, fmt.Errorf("failed to write icon %s: %w", icon, err)
+		}
+	}
+	return s.ReplaceMap(mapName, bundle.Map)
+}
+
+// missingIcons returns the icons of a bundle the server lacks, or the conflict of an icon it
+// has with other content
+func (s *MapService) missingIcons(bundle *Bundle) ([]string, error) {
+	var missing []string
+	for _, icon := range slices.Sorted(maps.Keys(bundle.Icons)) {
+		existing, _, err := s.GetIconFile(icon)
+		if err != nil {
+			missing = append(missing, icon)
+			continue
+		}
+		if !bytes.Equal(existing, bundle.Icons[icon]) {
+			return nil, fmt.Errorf("icon %s already exists with other content, another icon name avoids the conflict", icon)
+		}
+	}
+	return missing, nil
+}
+
+// ImportMapBundle is ImportMap for a bundle, zip or tar.gz: the icons the server lacks are
+// added with the map. A dry run checks the map and the icons and writes nothing.
+func (s *MapService) ImportMapBundle(mapName string, content []byte, replace, dryRun bool) (*ImportResult, error) {
+	if err := ValidMapName(mapName); err != nil {
+		return nil, err
+	}
+	if !IsBundle(content) {
+		return nil, fmt.Errorf("invalid bundle: neither a zip nor a tar.gz")
+	}
+	bundle, err := ReadBundle(content)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(s.configDir, mapName+".yaml")); err == nil && !replace {
+		return nil, fmt.Errorf("map %s already exists, import it with replace to overwrite it", mapName)
+	}
+	missing, err := s.missingIcons(bundle)
+	if err != nil {
+		return nil, err
+	}
+	// the map is checked before any icon is written, ImportBundle checks it only after
+	created, err := s.replaceMap(mapName, bundle.Map, true)
+	if err == nil && !dryRun {
+		created, err = s.ImportBundle(mapName, bundle)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ImportResult{Map: mapName, Created: created, DryRun: dryRun, Nodes: len(bundle.Map.Nodes), Links: len(bundle.Map.Links), Icons: missing}, nil
+}
@@ -0,0 +1,278 @@
+package service
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"time"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/datasource"
+)
+
+const (
+	DefaultCaptureSamples = 20
+	MaxCaptureSamples     = 500
+)
+
+// CapturedSample is one raw response of a datasource for a metric, next to the value the
+// poller cached from it. Value is unset while a counter has no previous sample to compute
+// a rate from.
+type CapturedSample struct {
+	Time   time.Time `json:"time"`
+	Metric string    `json:"metric"`
+	Source string    `json:"source"`        // the OID, query or agent the value was read from
+	Raw    any       `json:"raw,omitempty"` // as read: a counter, a gauge or a query result
+	Value  *int64    `json:"value,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// LinkCapture is the capture of the raw datasource responses a link reads its metrics from
+type LinkCapture struct {
+	Map        string           `json:"map"`
+	Link       string           `json:"link"`
+	DataSource string           `json:"datasource"`
+	Interface  string           `json:"interface"`
+	Size       int              `json:"size"`
+	Samples    []CapturedSample `json:"samples"`
+}
+
+// rawCapture keeps the last samples of a task key, oldest first
+type rawCapture struct {
+	metric  string
+	size    int
+	samples []CapturedSample
+}
+
+// rawCapturer is implemented by pollers keeping the raw responses of their tasks on demand
+type rawCapturer interface {
+	captureKey(ds config.DataSourceConfig, iface config.InterfaceConfig, metricName string) (string, bool)
+	startCapture(key, metricName string, size int)
+	stopCapture(key string) bool
+	captured(key string) ([]CapturedSample, int, bool)
+}
+
+// startCapture keeps the last size samples of a task, restarting a running capture keeps
+// its samples
+func (p *EmbeddedPoller) startCapture(key, metricName string, size int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.captures == nil {
+		p.captures = make(map[string]*rawCapture)
+	}
+	c, ok := p.captures[key]
+	if !ok {
+		c = &rawCapture{metric: metricName}
+		p.captures[key] = c
+	}
+	c.size = size
+	if len(c.samples) > size {
+		c.samples = slices.Clone(c.samples[len(c.samples)-size:])
+	}
+}
+
+func (p *EmbeddedPoller) stopCapture(key string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.captures[key]
+	delete(p.captures, key)
+	return ok
+}
+
+func (p *EmbeddedPoller) captured(key string) ([]CapturedSample, int, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	c, ok := p.captures[key]
+	if !ok {
+		return nil, 0, false
+	}
+	return slices.Clone(c.samples), c.size, true
+}
+
+// capture records a raw response of a task when it is captured, with the value cached
+// from it if the poll cached one
+func (p *EmbeddedPoller) capture(task dataPollTask, raw any, cached bool, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c, ok := p.captures[task.Key]
+	if !ok {
+		return
+	}
+	sample := CapturedSample{Time: time.Now(), Metric: c.metric, Source: task.MetricIdentifier, Raw: raw}
+	if cached {
+		value := p.cache[task.Key]
+		sample.Value = &value
+	}
+	if err != nil {
+		sample.Error = err.Error()
+	}
+	c.samples = append(c.samples, sample)
+	if len(c.samples) > c.size {
+		c.samples = slices.Delete(c.samples, 0, len(c.samples)-c.size)
+	}
+}
+
+func (p *SNMPPoller) captureKey(ds config.DataSourceConfig, iface config.InterfaceConfig, metricName string) (string, bool) {
+	oid, ok := snmpOID(iface, metricName)
+	if !ok {
+		return "", false
+	}
+	target, err := datasource.ParseSNMPTarget(ds.Params)
+	if err != nil {
+		return "", false
+	}
+	return snmpTaskKey(target, oid), true
+}
+
+func (p *PrometheusPoller) captureKey(ds config.DataSourceConfig, iface config.InterfaceConfig, metricName string) (string, bool) {
+	return prometheusKey(ds, iface, metricName), true
+}
+
+func (p *RRDPoller) captureKey(ds config.DataSourceConfig, iface config.InterfaceConfig, metricName string) (string, bool) {
+	return rrdKey(ds, iface, metricName), true
+}
+
+func (p *MockPoller) captureKey(ds config.DataSourceConfig, iface config.InterfaceConfig, metricName string) (string, bool) {
+	return mockKey(ds, iface, metricName), true
+}
+
+func (p *AgentPoller) captureKey(ds config.DataSourceConfig, iface config.InterfaceConfig, metricName string) (string, bool) {
+	return agentKey(ds.Name, iface.Name, metricName), true
+}
+
+// captureTarget returns the capturing poller of an interface and the task keys of its metrics
+func (s *DataSourceService) captureTarget(dsName, ifaceName string) (rawCapturer, map[string]string, error) {
+	ds, iface, poller, lookupErr := s.resolve(dsName, ifaceName)
+	if lookupErr != nil {
+		return nil, nil, lookupErr
+	}
+	if s.cluster != nil && !s.cluster.owns(dsName) {
+		return nil, nil, fmt.Errorf("datasource %s is polled by another instance, capture there", dsName)
+	}
+	capturer, ok := poller.(rawCapturer)
+	if !ok {
+		return nil, nil, fmt.Errorf("datasource %s: capture not supported by the %s poller", dsName, cmp.Or(ds.Type, SNMPPollerType))
+	}
+	keys := make(map[string]string)
+	for _, metric := range getMetricNames(ds, iface) {
+		if key, ok := capturer.captureKey(ds, iface, metric); ok {
+			keys[metric] = key
+		}
+	}
+	return capturer, keys, nil
+}
+
+// StartCapture keeps the last size raw responses of every metric of an interface
+func (s *DataSourceService) StartCapture(dsName, ifaceName string, size int) error {
+	capturer, keys, err := s.captureTarget(dsName, ifaceName)
+	if err != nil {
+		return err
+	}
+	for metric, key := range keys {
+		capturer.startCapture(key, metric, size)
+	}
+	return nil
+}
+
+// StopCapture drops the capture of an interface, stopped reports whether one was running
+func (s *DataSourceService) StopCapture(dsName, ifaceName string) (stopped bool, err error) {
+	capturer, keys, err := s.captureTarget(dsName, ifaceName)
+	if err != nil {
+		return false, err
+	}
+	for _, key := range keys {
+		stopped = capturer.stopCapture(key) || stopped
+	}
+	return stopped, nil
+}
+
+// CapturedSamples returns the samples captured for an interface by time, ok is false when
+// the interface isn't captured
+func (s *DataSourceService) CapturedSamples(dsName, ifaceName string) (samples []CapturedSample, size int, ok bool, err error) {
+	capturer, keys, err := s.captureTarget(dsName, ifaceName)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	samples = []CapturedSample{}
+	for _, key := range keys {
+		captured, n, found := capturer.captured(key)
+		if found {
+			samples, size, ok = append(samples, captured...), max(size, n), true
+		}
+	}
+	slices.SortStableFunc(samples, func(a, b CapturedSample) int {
+		return cmp.Or(a.Time.Compare(b.Time), cmp.Compare(a.Metric, b.Metric))
+	})
+	return samples, size, ok, nil
+}
+
+// captureLink returns the link of a map by name
+func (s *MapService) captureLink(mapName, linkName string) (config.Link, error) {
+	m, err := s.loadMapConfig(mapName)
+	if err != nil {
+		return config.Link{}, err
+	}
+	i := slices.IndexFunc(m.Links, func(link config.Link) bool { return link.Name == linkName })
+	if i < 0 {
+		return config.Link{}, fmt.Errorf("link not found: %s", linkName)
+	}
+	return m.Links[i], nil
+}
+
+// StartLinkCapture keeps the last size raw datasource responses the link reads its
+// metrics from, 0 keeps DefaultCaptureSamples
+func (s *MapService) StartLinkCapture(mapName, linkName string, size int, dsService *DataSourceService) (*LinkCapture, error) {
+	if size == 0 {
+		size = DefaultCaptureSamples
+	}
+	if size < 0 || size > MaxCaptureSamples {
+		return nil, fmt.Errorf("invalid samples: must be between 1 and %d", MaxCaptureSamples)
+	}
+	link, err := s.captureLink(mapName, linkName)
+	if err != nil {
+		return nil, err
+	}
+	if err := dsService.StartCapture(link.DataSource, link.Interface, size); err != nil {
+		return nil, err
+	}
+	return s.GetLinkCapture(mapName, linkName, dsService)
+}
+
+// GetLinkCapture returns the raw datasource responses captured for a link
+func (s *MapService) GetLinkCapture(mapName, linkName string, dsService *DataSourceService) (*LinkCapture, error) {
+	link, err := s.captureLink(mapName, linkName)
+	if err != nil {
+		return nil, err
+	}
+	samples, size, ok, err := dsService.CapturedSamples(link.DataSource, link.Interface)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("capture not found for link: %s", linkName)
+	}
+	return &LinkCapture{
+		Map:        mapName,
+		Link:       linkName,
+		DataSource: link.DataSource,
+		Interface:  link.Interface,
+		Size:       size,
+		Samples:    samples,
+	}, nil
+}
+
+// StopLinkCapture drops the capture of a link
+func (s *MapService) StopLinkCapture(mapName, linkName string, dsService *DataSourceService) error {
+	link, err := s.captureLink(mapName, linkName)
+	if err != nil {
+		return err
+	}
+	stopped, err := dsService.StopCapture(link.DataSource, link.Interface)
+	if err != nil {
+		return err
+	}
+	if !stopped {
+		return fmt.Errorf("capture not found for link: %s", linkName)
+	}
+	return nil
+}
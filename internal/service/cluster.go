@@ -0,0 +1,346 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultClusterSyncInterval = 5 * time.Second
+	clusterMetricsPath         = "/cluster/metrics"
+)
+
+// ClusterConfig enables sharded polling: every datasource is polled by exactly one live
+// instance (rendezvous hashing of the datasource name over the peers), the other
+// instances pull its values from the owner.
+type ClusterConfig struct {
+	Self         string        `json:"self"`
+	Peers        []string      `json:"peers"`
+	SyncInterval time.Duration `json:"-"`
+	// a peer not answering for FailoverAfter is considered dead and its datasources move
+	FailoverAfter time.Duration `json:"-"`
+}
+
+// ClusterConfigFromEnv reads WEATHERMAP_CLUSTER_* variables, ok is false when sharding is not configured
+func ClusterConfigFromEnv() (cfg ClusterConfig, ok bool, err error) {
+	cfg.Self = strings.TrimRight(os.Getenv("WEATHERMAP_CLUSTER_SELF"), "/")
+	peers := os.Getenv("WEATHERMAP_CLUSTER_PEERS")
+	if cfg.Self == "" && peers == "" {
+		return cfg, false, nil
+	}
+	for _, peer := range strings.Split(peers, ",") {
+		if peer = strings.TrimRight(strings.TrimSpace(peer), "/"); peer != "" {
+			cfg.Peers = append(cfg.Peers, peer)
+		}
+	}
+	cfg.SyncInterval = DefaultClusterSyncInterval
+	if value := os.Getenv("WEATHERMAP_CLUSTER_SYNC_INTERVAL"); value != "" {
+		if cfg.SyncInterval, err = time.ParseDuration(value); err != nil {
+			return cfg, false, fmt.Errorf("invalid WEATHERMAP_CLUSTER_SYNC_INTERVAL: %s", value)
+		}
+	}
+	return cfg, true, cfg.Validate()
+}
+
+func (c *ClusterConfig) Validate() error {
+	if c.Self == "" {
+		return fmt.Errorf("cluster self address is required")
+	}
+	if !slices.Contains(c.Peers, c.Self) {
+		c.Peers = append(c.Peers, c.Self)
+	}
+	if len(c.Peers) < 2 {
+		return fmt.Errorf("cluster needs at least one peer besides self")
+	}
+	if c.SyncInterval <= 0 {
+		c.SyncInterval = DefaultClusterSyncInterval
+	}
+	if c.FailoverAfter <= 0 {
+		c.FailoverAfter = 3 * c.SyncInterval
+	}
+	return nil
+}
+
+// MetricsSnapshot holds polled values: datasource -> interface -> metric -> value
+type MetricsSnapshot map[string]map[string]map[string]int64
+
+type PeerStatus struct {
+	Address  string    `json:"address"`
+	Self     bool      `json:"self"`
+	Alive    bool      `json:"alive"`
+	LastSeen time.Time `json:"last_seen,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+type ClusterStatus struct {
+	Self   string            `json:"self"`
+	Peers  []PeerStatus      `json:"peers"`
+	Owners map[string]string `json:"owners"` // datasource -> peer
+}
+
+type peerState struct {
+	lastSeen time.Time
+	lastErr  error
+	snapshot MetricsSnapshot
+}
+
+type cluster struct {
+	cfg        ClusterConfig
+	httpClient *http.Client
+	started    time.Time
+
+	mu     sync.RWMutex
+	peers  map[string]*peerState
+	logger *slog.Logger
+}
+
+func (c *cluster) setLogger(logger *slog.Logger) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.logger = logger
+}
+
+func newCluster(cfg ClusterConfig, httpClient *http.Client) *cluster {
+	peers := make(map[string]*peerState, len(cfg.Peers))
+	for _, peer := range cfg.Peers {
+		if peer != cfg.Self {
+			peers[peer] = &peerState{}
+		}
+	}
+	return &cluster{cfg: cfg, httpClient: httpClient, started: time.Now(), peers: peers}
+}
+
+// aliveLocked treats peers never seen as alive during the first FailoverAfter,
+// so instances starting together don't all poll everything
+func (c *cluster) aliveLocked(peer string, now time.Time) bool {
+	if peer == c.cfg.Self {
+		return true
+	}
+	state := c.peers[peer]
+	if state.lastSeen.IsZero() {
+		return now.Sub(c.started) < c.cfg.FailoverAfter
+	}
+	return now.Sub(state.lastSeen) < c.cfg.FailoverAfter
+}
+
+func (c *cluster) owner(dsName string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ownerLocked(dsName, time.Now())
+}
+
+func (c *cluster) ownerLocked(dsName string, now time.Time) string {
+	var best string
+	var bestScore uint64
+	for _, peer := range c.cfg.Peers {
+		if !c.aliveLocked(peer, now) {
+			continue
+		}
+		if score := rendezvousScore(peer, dsName); best == "" || score > bestScore {
+			best, bestScore = peer, score
+		}
+	}
+	return best
+}
+
+// rendezvousScore finalizes FNV with splitmix64, peer addresses differing only
+// in the last bytes otherwise get correlated scores
+func rendezvousScore(peer, dsName string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(peer + "|" + dsName))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func (c *cluster) owns(dsName string) bool {
+	return c.owner(dsName) == c.cfg.Self
+}
+
+// remoteMetric returns the value polled by the datasource owner
+func (c *cluster) remoteMetric(dsName, ifaceName, metric string) (int64, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	state, ok := c.peers[c.ownerLocked(dsName, time.Now())]
+	if !ok {
+		return 0, false
+	}
+	val, ok := state.snapshot[dsName][ifaceName][metric]
+	return val, ok
+}
+
+func (c *cluster) run(ctx context.Context) {
+	ticker := time.NewTicker(c.cfg.SyncInterval)
+	defer ticker.Stop()
+	for {
+		c.sync()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *cluster) sync() {
+	var wg sync.WaitGroup
+	for peer := range c.peers {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			snapshot, err := c.fetch(peer)
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			state := c.peers[peer]
+			state.lastErr = err
+			if err != nil {
+				c.logger.Warn("cluster peer unreachable", "peer", peer, "error", err)
+				return
+			}
+			state.lastSeen = time.Now()
+			state.snapshot = snapshot
+		}(peer)
+	}
+	wg.Wait()
+}
+
+func (c *cluster) fetch(peer string) (MetricsSnapshot, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.cfg.SyncInterval)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, peer+clusterMetricsPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var snapshot MetricsSnapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("invalid snapshot: %w", err)
+	}
+	return snapshot, nil
+}
+
+func (c *cluster) status(datasources []string) ClusterStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	now := time.Now()
+
+	peers := make([]PeerStatus, 0, len(c.cfg.Peers))
+	for _, peer := range c.cfg.Peers {
+		ps := PeerStatus{Address: peer, Self: peer == c.cfg.Self, Alive: c.aliveLocked(peer, now)}
+		if state, ok := c.peers[peer]; ok {
+			ps.LastSeen = state.lastSeen
+			if state.lastErr != nil {
+				ps.Error = state.lastErr.Error()
+			}
+		}
+		peers = append(peers, ps)
+	}
+	owners := make(map[string]string, len(datasources))
+	for _, ds := range datasources {
+		owners[ds] = c.ownerLocked(ds, now)
+	}
+	return ClusterStatus{Self: c.cfg.Self, Peers: peers, Owners: owners}
+}
+
+// shardAware is implemented by pollers able to skip tasks owned by other instances
+type shardAware interface {
+	setShardFilter(owns func(dsName string) bool)
+}
+
+func (p *EmbeddedPoller) setShardFilter(owns func(dsName string) bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.owns = owns
+}
+
+// ownsTask reports whether this instance should poll the task
+func (p *EmbeddedPoller) ownsTask(task dataPollTask) bool {
+	p.mu.RLock()
+	owns := p.owns
+	p.mu.RUnlock()
+	return owns == nil || owns(task.DS.Name)
+}
+
+// EnableSharding must be called before Start
+func (s *DataSourceService) EnableSharding(cfg ClusterConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cluster = newCluster(cfg, &http.Client{Timeout: cfg.SyncInterval})
+	s.cluster.logger = s.logger
+	for _, p := range s.pollers {
+		if sa, ok := p.(shardAware); ok {
+			sa.setShardFilter(s.cluster.owns)
+		}
+	}
+	return nil
+}
+
+func (s *DataSourceService) ClusterEnabled() bool {
+	return s.cluster != nil
+}
+
+// LocalSnapshot returns values of datasources polled by this instance
+func (s *DataSourceService) LocalSnapshot() MetricsSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snapshot := make(MetricsSnapshot)
+	for name, ds := range s.datasources {
+		if s.cluster != nil && !s.cluster.owns(name) {
+			continue
+		}
+		poller, ok := s.pollers[ds.Type]
+		if !ok {
+			continue
+		}
+		ifaces := make(map[string]map[string]int64, len(ds.Interfaces))
+		for _, iface := range ds.Interfaces {
+			values := make(map[string]int64)
+			for _, metric := range getMetricNames(ds, iface) {
+				if val, ok := poller.GetMetric(ds, iface, metric).(int64); ok {
+					values[metric] = val
+				}
+			}
+			ifaces[iface.Name] = values
+		}
+		snapshot[name] = ifaces
+	}
+	return snapshot
+}
+
+func (s *DataSourceService) ClusterStatus() (ClusterStatus, error) {
+	if s.cluster == nil {
+		return ClusterStatus{}, fmt.Errorf("sharding is not enabled")
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.datasources))
+	for name := range s.datasources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return s.cluster.status(names), nil
+}
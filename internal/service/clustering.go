@@ -0,0 +1,129 @@
+package service
+
+import (
+	"fmt"
+	"math"
+
+	"go-weathermap/internal/config"
+)
+
+// ClusterCellPixels is the size of the cluster grid on screen when it is derived from the zoom
+const ClusterCellPixels = 64
+
+// ClusterNodes groups the nodes sharing a cell of a cell x cell pixel grid into one cluster
+// node at their centroid. Links between members disappear into the cluster status, parallel
+// links between the same clusters are merged keeping the worst status and the highest
+// utilization. The map is returned unchanged when no cell holds more than one node.
+func ClusterNodes(m *config.MapWithData, cell int) *config.MapWithData {
+	if cell <= 1 {
+		return m
+	}
+	type gridCell struct{ x, y int }
+	cells := make(map[gridCell][]config.Node)
+	var order []gridCell
+	for _, node := range m.Nodes {
+		c := gridCell{int(math.Floor(float64(node.Position.X) / float64(cell))), int(math.Floor(float64(node.Position.Y) / float64(cell)))}
+		if _, ok := cells[c]; !ok {
+			order = append(order, c)
+		}
+		cells[c] = append(cells[c], node)
+	}
+	if len(order) == len(m.Nodes) {
+		return m
+	}
+
+	clustered := *m.Map
+	clustered.Nodes = make([]config.Node, 0, len(order))
+	owner := make(map[string]string, len(m.Nodes)) // node -> cluster node
+	clusters := make(map[string]*config.NodeCluster)
+	var clusterOrder []string
+	for _, c := range order {
+		members := cells[c]
+		if len(members) == 1 {
+			clustered.Nodes = append(clustered.Nodes, members[0])
+			owner[members[0].Name] = members[0].Name
+			continue
+		}
+		name := fmt.Sprintf("cluster-%d", len(clusterOrder)+1)
+		cluster := &config.NodeCluster{Name: name, Status: "unknown"}
+		var x, y int
+		for _, member := range members {
+			x += member.Position.X
+			y += member.Position.Y
+			cluster.Nodes = append(cluster.Nodes, member.Name)
+			owner[member.Name] = name
+		}
+		clustered.Nodes = append(clustered.Nodes, config.Node{
+			Name:     name,
+			Label:    fmt.Sprintf("%d nodes", len(members)),
+			Position: config.Position{X: x / len(members), Y: y / len(members)},
+		})
+		clusters[name] = cluster
+		clusterOrder = append(clusterOrder, name)
+	}
+
+	data := make(map[string]config.LinkData, len(m.LinksData))
+	for _, linkData := range m.LinksData {
+		data[linkData.Name] = linkData
+	}
+	clustered.Links = make([]config.Link, 0, len(m.Links))
+	var linksData []config.LinkData
+	merged := make(map[[2]string]int) // cluster pair -> index in clustered.Links
+	for _, link := range m.Links {
+		linkData, ok := data[link.Name]
+		if !ok {
+			linkData = config.LinkData{Name: link.Name, Status: "unknown"}
+		}
+		from, to := owner[link.From], owner[link.To]
+		for _, name := range []string{from, to} {
+			if cluster, ok := clusters[name]; ok {
+				cluster.Status = worseStatus(cluster.Status, linkData.Status)
+				cluster.Utilization = math.Max(cluster.Utilization, linkData.Utilization)
+			}
+		}
+		if from == "" || to == "" || from == to {
+			continue
+		}
+		if from == link.From && to == link.To {
+			clustered.Links = append(clustered.Links, link)
+			linksData = append(linksData, linkData)
+			continue
+		}
+
+		pair := [2]string{min(from, to), max(from, to)}
+		if i, ok := merged[pair]; ok {
+			linksData[i].Status = worseStatus(linksData[i].Status, linkData.Status)
+			linksData[i].Utilization = math.Max(linksData[i].Utilization, linkData.Utilization)
+			clustered.Links[i].Name = fmt.Sprintf("%s~%s", from, to)
+			linksData[i].Name = clustered.Links[i].Name
+			continue
+		}
+		// the route and label position were drawn for the original nodes
+		link.From, link.To, link.Via, link.BWLabelPos = from, to, nil, nil
+		merged[pair] = len(clustered.Links)
+		clustered.Links = append(clustered.Links, link)
+		linksData = append(linksData, linkData)
+	}
+
+	// merged links take the worst status and utilization of their members
+	applyLinkColors(&clustered, linksData)
+
+	result := *m
+	result.Map = &clustered
+	result.LinksData = linksData
+	result.Clusters = make([]config.NodeCluster, 0, len(clusterOrder))
+	for _, name := range clusterOrder {
+		result.Clusters = append(result.Clusters, *clusters[name])
+	}
+	return &result
+}
+
+// worseStatus orders link states down, degraded, misconfigured, up, unknown
+func worseStatus(a, b string) string {
+	for _, status := range []string{"down", FaultStateDegraded, LinkMisconfigured, "up"} {
+		if a == status || b == status {
+			return status
+		}
+	}
+	return "unknown"
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"go-weathermap/internal/config"
+)
+
+// CollectMapData polls the datasources of the links of a map for one round, without a running
+// server, and returns the map with the data gathered. A zero wait polls for two intervals of
+// the slowest datasource, rates need two samples.
+func (s *MapService) CollectMapData(ctx context.Context, name string, wait time.Duration) (*config.MapWithData, error) {
+	mapConfig, err := s.loadMapConfig(name)
+	if err != nil {
+		return nil, err
+	}
+	// links can use the datasources of any map of the directory
+	all, err := LoadAllDataSources(s.configDir)
+	if err != nil {
+		all = ValidDataSources(all)
+	}
+	used := make([]config.DataSourceConfig, 0, len(all))
+	slowest := time.Duration(0)
+	for _, ds := range all {
+		if slices.ContainsFunc(mapConfig.Links, func(link config.Link) bool { return link.DataSource == ds.Name }) &&
+			!slices.ContainsFunc(used, func(u config.DataSourceConfig) bool { return u.Name == ds.Name }) {
+			used = append(used, ds)
+			slowest = max(slowest, pollInterval(ds))
+		}
+	}
+	if len(used) == 0 {
+		return s.GetMapWithData(ctx, name, nil)
+	}
+	if wait <= 0 {
+		wait = 2*slowest + time.Second
+	}
+
+	dsService := NewDataSourceService(used)
+	dsService.SetLogger(s.logger)
+	dsService.Start()
+	defer func() {
+		stopCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = dsService.Stop(stopCtx)
+	}()
+	s.logger.Info("polling datasources", "map", name, "datasources", len(used), "wait", wait)
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(wait):
+	}
+	return s.GetMapWithData(ctx, name, dsService)
+}
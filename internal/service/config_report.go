@@ -0,0 +1,237 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go-weathermap/internal/config"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	IssueError   = "error"
+	IssueWarning = "warning"
+)
+
+// poller types a datasource can use, an empty type is snmp
+var pollerTypes = []string{SNMPPollerType, PrometheusPollerType, AgentPollerType, RRDPollerType, "zabbix", "mock"}
+
+// ConfigIssue is a problem found in a map file of the config directory
+type ConfigIssue struct {
+	File     string `json:"file"`
+	Line     int    `json:"line,omitempty"` // of the object the issue is about, when known
+	Severity string `json:"severity"`       // error or warning
+	Message  string `json:"message"`
+}
+
+// ConfigReport is the result of checking every map and datasource of the config directory
+type ConfigReport struct {
+	Maps        int           `json:"maps"`
+	Datasources int           `json:"datasources"`
+	Issues      []ConfigIssue `json:"issues"`
+}
+
+// Errors counts the issues which break a map or a datasource
+func (r ConfigReport) Errors() int {
+	n := 0
+	for _, issue := range r.Issues {
+		if issue.Severity == IssueError {
+			n++
+		}
+	}
+	return n
+}
+
+// Log writes every issue and a summary
+func (r ConfigReport) Log(logger *slog.Logger) {
+	for _, issue := range r.Issues {
+		level := slog.LevelError
+		if issue.Severity == IssueWarning {
+			level = slog.LevelWarn
+		}
+		logger.Log(context.Background(), level, "config issue", "file", issue.File, "line", issue.Line, "error", issue.Message)
+	}
+	logger.Info("config validated", "maps", r.Maps, "datasources", r.Datasources,
+		"errors", r.Errors(), "warnings", len(r.Issues)-r.Errors())
+}
+
+// ValidateConfigDir parses and validates every map of configDir and its datasources,
+// the error is only set when the directory can't be read
+func ValidateConfigDir(configDir string) (ConfigReport, error) {
+	if _, err := os.Stat(configDir); err != nil {
+		return ConfigReport{Issues: []ConfigIssue{}}, err
+	}
+	files, err := filepath.Glob(filepath.Join(configDir, "*.yaml"))
+	if err != nil {
+		return ConfigReport{Issues: []ConfigIssue{}}, err
+	}
+	return validateMapFiles(files), nil
+}
+
+// ValidateConfigFile validates a single map file like ValidateConfigDir
+func ValidateConfigFile(path string) (ConfigReport, error) {
+	if _, err := os.Stat(path); err != nil {
+		return ConfigReport{Issues: []ConfigIssue{}}, err
+	}
+	return validateMapFiles([]string{path}), nil
+}
+
+func validateMapFiles(files []string) ConfigReport {
+	report := ConfigReport{Issues: []ConfigIssue{}}
+	parser := config.NewParser()
+	datasources := make(map[string]config.DataSourceConfig)
+	definedIn := make(map[string]string)
+	type parsedMap struct {
+		file  string
+		lines map[string]int
+		m     *config.Map
+	}
+	var parsed []parsedMap
+	for _, path := range files {
+		file := filepath.Base(path)
+		var lines map[string]int
+		issue := func(severity, format string, args ...any) {
+			message := fmt.Sprintf(format, args...)
+			report.Issues = append(report.Issues, ConfigIssue{File: file, Line: issueLine(lines, message), Severity: severity, Message: message})
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			issue(IssueError, "%v", err)
+			continue
+		}
+		m, err := parser.ParseYAML(bytes.NewReader(content))
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			// one issue per field of the wrong type
+			for _, message := range typeErr.Errors {
+				issue(IssueError, "invalid YAML: %s", message)
+			}
+			continue
+		}
+		if err != nil {
+			issue(IssueError, "invalid YAML: %v", err)
+			continue
+		}
+		lines = objectLines(content)
+		report.Maps++
+		for _, err := range joined(parser.Validate(m)) {
+			issue(IssueError, "%v", err)
+		}
+		for _, err := range joined(ValidateDataSources(m.Datasources)) {
+			issue(IssueError, "%v", err)
+		}
+
+		for _, ds := range m.Datasources {
+			report.Datasources++
+			if ds.Name == "" {
+				issue(IssueError, "datasource name cannot be empty")
+				continue
+			}
+			if ds.Type != "" && !slices.Contains(pollerTypes, ds.Type) {
+				issue(IssueError, "datasource %s: unknown type %s", ds.Name, ds.Type)
+			}
+			if previous, ok := datasources[ds.Name]; ok && !reflect.DeepEqual(previous, ds) {
+				issue(IssueWarning, "datasource %s is also defined differently in %s, the last definition is used", ds.Name, definedIn[ds.Name])
+			}
+			datasources[ds.Name] = ds
+			definedIn[ds.Name] = file
+		}
+		parsed = append(parsed, parsedMap{file, lines, m})
+	}
+	// links can use the datasources of every map, so they are checked once all are known
+	for _, p := range parsed {
+		for _, err := range linkRefErrors(p.m, datasources) {
+			message := err.Error()
+			report.Issues = append(report.Issues, ConfigIssue{File: p.file, Line: issueLine(p.lines, message), Severity: IssueWarning, Message: message})
+		}
+	}
+	return report
+}
+
+// objectLines indexes the lines of a map document: the top level keys by name, and the
+// named nodes, links, schedules and datasources as "node a", "link a-b"...
+func objectLines(content []byte) map[string]int {
+	lines := make(map[string]int)
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return lines
+	}
+	root := doc.Content[0]
+	kinds := map[string]string{"nodes": "node", "links": "link", "schedules": "schedule", "datasources": "datasource", "demands": "demand"}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		lines[key.Value] = key.Line
+		kind, ok := kinds[key.Value]
+		if !ok || value.Kind != yaml.SequenceNode {
+			continue
+		}
+		for index, item := range value.Content {
+			lines[fmt.Sprintf("%s %d", kind, index)] = item.Line
+			for j := 0; j+1 < len(item.Content); j += 2 {
+				if item.Content[j].Value == "name" {
+					lines[kind+" "+item.Content[j+1].Value] = item.Line
+				}
+			}
+		}
+	}
+	return lines
+}
+
+var (
+	issueObject  = regexp.MustCompile(`^(node|link|schedule|datasource|demand) '?([^' :]+)'?`)
+	yamlLine     = regexp.MustCompile(`line (\d+):`)
+	issueSection = map[string]string{"width": "width", "anomaly_detection": "anomaly_detection", "duplicate schedule": "schedules", "over_utilization": "over_utilization"}
+)
+
+// issueLine finds the line of the object an issue names, 0 when it isn't known
+func issueLine(lines map[string]int, message string) int {
+	if match := yamlLine.FindStringSubmatch(message); match != nil {
+		line, _ := strconv.Atoi(match[1])
+		return line
+	}
+	if match := issueObject.FindStringSubmatch(message); match != nil {
+		if line, ok := lines[match[1]+" "+match[2]]; ok {
+			return line
+		}
+		return lines[match[1]+"s"]
+	}
+	for prefix, key := range issueSection {
+		if strings.HasPrefix(message, prefix) {
+			return lines[key]
+		}
+	}
+	return 0
+}
+
+// ValidDataSources drops the datasources failing validation
+func ValidDataSources(datasources []config.DataSourceConfig) []config.DataSourceConfig {
+	valid := make([]config.DataSourceConfig, 0, len(datasources))
+	for _, ds := range datasources {
+		if ValidateDataSources([]config.DataSourceConfig{ds}) == nil {
+			valid = append(valid, ds)
+		}
+	}
+	return valid
+}
+
+func joined(err error) []error {
+	if err == nil {
+		return nil
+	}
+	if j, ok := err.(interface{ Unwrap() []error }); ok {
+		return j.Unwrap()
+	}
+	return []error{err}
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestValidateConfigDir(t *testing.T) {
+	configDir := t.TempDir()
+	files := map[string]string{
+		"core.yaml": `width: 100
+height: 100
+title: core
+nodes: [{name: a}, {name: b}]
+links: [{name: a-b, from: a, to: b, bandwidth: 1G}]
+datasources: [{name: prom, type: prometheus, params: {url: "http://prometheus:9090"}}]
+`,
+		"edge.yaml": `width: 100
+height: 100
+title: edge
+nodes: [{name: a}]
+links: [{name: a-c, from: a, to: c, bandwidth: 1G}]
+datasources:
+  - {name: prom, type: prometheus, params: {url: "http://prometheus-2:9090"}}
+  - {name: zbx, type: zabix}
+`,
+		"broken.yaml": "width: [\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(configDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := ValidateConfigDir(configDir)
+	if err != nil {
+		t.Fatalf("Failed to validate config dir: %v", err)
+	}
+	if report.Maps != 2 || report.Datasources != 3 {
+		t.Errorf("Expected 2 maps with 3 datasources, got %+v", report)
+	}
+	bySeverity := make(map[string][]ConfigIssue)
+	for _, issue := range report.Issues {
+		bySeverity[issue.Severity] = append(bySeverity[issue.Severity], issue)
+	}
+	if report.Errors() != 3 || len(bySeverity[IssueError]) != 3 {
+		t.Errorf("Expected broken YAML, unknown node and unknown type errors, got %+v", report.Issues)
+	}
+	if warnings := bySeverity[IssueWarning]; len(warnings) != 1 || warnings[0].File != "edge.yaml" {
+		t.Errorf("Expected a warning for prom defined twice, got %+v", warnings)
+	}
+
+	if _, err := ValidateConfigDir(filepath.Join(configDir, "missing")); err == nil {
+		t.Errorf("Expected an error for a missing config dir")
+	}
+}
+
+func TestValidateConfigFileLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "core.yaml")
+	content := `width: 100
+height: 100
+nodes:
+  - name: a
+    management_ip: 10.0.0.300
+  - name: b
+links:
+  - name: a-c
+    from: a
+    to: c
+    bandwidth: 1G
+  - name: b-a
+    from: b
+    to: a
+    bandwidth: fast
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, err := ValidateConfigFile(path)
+	if err != nil {
+		t.Fatalf("Failed to validate file: %v", err)
+	}
+	lines := make([]int, 0, len(report.Issues))
+	for _, issue := range report.Issues {
+		lines = append(lines, issue.Line)
+	}
+	// every broken object is reported, not only the first one
+	if !slices.Equal(lines, []int{4, 8, 12}) {
+		t.Errorf("Expected issues on lines 4, 8 and 12, got %+v", report.Issues)
+	}
+
+	if err := os.WriteFile(path, []byte("width: 100\nheight: 100\nnodes:\n  - name: [a]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, _ = ValidateConfigFile(path)
+	if len(report.Issues) != 1 || report.Issues[0].Line != 4 {
+		t.Errorf("Expected the YAML error on line 4, got %+v", report.Issues)
+	}
+}
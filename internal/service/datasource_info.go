@@ -0,0 +1,112 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/datasource"
+)
+
+// DataSourceInfo describes a datasource with its effective settings, params holding
+// credentials are not included
+type DataSourceInfo struct {
+	Name                string        `json:"name"`
+	Type                string        `json:"type"`
+	PollIntervalSeconds float64       `json:"poll_interval_seconds"`
+	Interfaces          []string      `json:"interfaces"`
+	SNMP                *SNMPSettings `json:"snmp,omitempty"`
+	DiscoveredBy        string        `json:"discovered_by,omitempty"` // prometheus datasource the target was discovered by
+	Error               string        `json:"error,omitempty"`
+}
+
+type SNMPSettings struct {
+	Host               string `json:"host"`
+	Port               uint16 `json:"port"`
+	Timeout            string `json:"timeout"`
+	Retries            int    `json:"retries"`
+	MaxRequestDuration string `json:"max_request_duration"`
+	DNSTimeout         string `json:"dns_timeout,omitempty"`
+	DNSRefresh         string `json:"dns_refresh,omitempty"`
+}
+
+func newDataSourceInfo(ds config.DataSourceConfig) DataSourceInfo {
+	info := DataSourceInfo{
+		Name:                ds.Name,
+		Type:                ds.Type,
+		PollIntervalSeconds: pollInterval(ds).Seconds(),
+		Interfaces:          make([]string, 0, len(ds.Interfaces)),
+	}
+	info.DiscoveredBy, _ = ds.Params[discoveredByParam].(string)
+	for _, iface := range ds.Interfaces {
+		info.Interfaces = append(info.Interfaces, iface.Name)
+	}
+	if ds.Type == SNMPPollerType {
+		target, err := datasource.ParseSNMPTarget(ds.Params)
+		if err != nil {
+			info.Error = err.Error()
+			return info
+		}
+		info.SNMP = &SNMPSettings{
+			Host:               target.Host,
+			Port:               target.Port,
+			Timeout:            target.Timeout.String(),
+			Retries:            target.Retries,
+			MaxRequestDuration: target.MaxDuration().String(),
+		}
+		if !target.IsIP() {
+			info.SNMP.DNSTimeout = target.DNSTimeout.String()
+			info.SNMP.DNSRefresh = target.DNSRefresh.String()
+		}
+	}
+	return info
+}
+
+func (s *DataSourceService) ListDataSources() []DataSourceInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	infos := make([]DataSourceInfo, 0, len(s.datasources))
+	for _, ds := range s.datasources {
+		infos = append(infos, newDataSourceInfo(ds))
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+func (s *DataSourceService) GetDataSource(name string) (DataSourceInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ds, ok := s.datasources[name]
+	if !ok {
+		return DataSourceInfo{}, fmt.Errorf("datasource not found: %s", name)
+	}
+	return newDataSourceInfo(ds), nil
+}
+
+// DataSourceMaps lists for every datasource the maps defining it or using it in their links
+func (s *MapService) DataSourceMaps() (map[string][]string, error) {
+	names, err := s.ListMaps()
+	if err != nil {
+		return nil, err
+	}
+	usedBy := make(map[string][]string)
+	add := func(dsName, mapName string) {
+		if dsName != "" && !slices.Contains(usedBy[dsName], mapName) {
+			usedBy[dsName] = append(usedBy[dsName], mapName)
+		}
+	}
+	for _, name := range names {
+		m, err := s.loadMapConfig(name)
+		if err != nil {
+			continue // invalid maps are reported by the config validation
+		}
+		for _, ds := range m.Datasources {
+			add(ds.Name, name)
+		}
+		for _, link := range m.Links {
+			add(link.DataSource, name)
+		}
+	}
+	return usedBy, nil
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+// DataSourcePollStats counts the polls of one datasource by outcome, a poll fetching
+// several metrics of the datasource at once counts once
+type DataSourcePollStats struct {
+	Name        string    `json:"name"`
+	Poller      string    `json:"poller"`
+	Successes   int64     `json:"successes"`
+	Failures    int64     `json:"failures"`
+	LastPoll    time.Time `json:"last_poll"`
+	LastSuccess time.Time `json:"last_success"`
+}
+
+// recordPolls counts one poll of every datasource of tasks
+func (p *EmbeddedPoller) recordPolls(tasks []dataPollTask, err error) {
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.polls == nil {
+		p.polls = make(map[string]*DataSourcePollStats)
+	}
+	if p.polled == nil {
+		p.polled = make(map[string]bool)
+	}
+	seen := make(map[string]bool, 1)
+	for _, task := range tasks {
+		p.polled[task.Key] = true
+		if seen[task.DS.Name] {
+			continue
+		}
+		seen[task.DS.Name] = true
+		stats, ok := p.polls[task.DS.Name]
+		if !ok {
+			stats = &DataSourcePollStats{Name: task.DS.Name, Poller: task.DS.Type}
+			if stats.Poller == "" {
+				stats.Poller = SNMPPollerType
+			}
+			p.polls[task.DS.Name] = stats
+		}
+		stats.LastPoll = now
+		if err != nil {
+			stats.Failures++
+			continue
+		}
+		stats.Successes++
+		stats.LastSuccess = now
+	}
+}
+
+func (p *EmbeddedPoller) DataSourcePolls() []DataSourcePollStats {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	result := make([]DataSourcePollStats, 0, len(p.polls))
+	for _, stats := range p.polls {
+		result = append(result, *stats)
+	}
+	return result
+}
+
+// UnpolledTasks counts the tasks owned by this instance which weren't polled yet, tasks of
+// pollers without poll loops, like agents pushing their values, never count
+func (p *EmbeddedPoller) UnpolledTasks() int {
+	p.mu.RLock()
+	if p.loopFn == nil {
+		p.mu.RUnlock()
+		return 0
+	}
+	var pending []dataPollTask
+	for _, task := range p.tasks {
+		if !p.polled[task.Key] {
+			pending = append(pending, task)
+		}
+	}
+	owns := p.owns
+	p.mu.RUnlock()
+	if owns != nil {
+		pending = slices.DeleteFunc(pending, func(task dataPollTask) bool { return !owns(task.DS.Name) })
+	}
+	return len(pending)
+}
+
+// CacheSize is the number of cached metric values
+func (p *EmbeddedPoller) CacheSize() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.cache)
+}
+
+// pollsReporter and cacheReporter are implemented by the pollers built on EmbeddedPoller
+type pollsReporter interface {
+	DataSourcePolls() []DataSourcePollStats
+}
+
+type cacheReporter interface {
+	CacheSize() int
+}
+
+type unpolledReporter interface {
+	UnpolledTasks() int
+}
+
+// DataSourcePolls returns the poll outcomes of every polled datasource, by name
+func (s *DataSourceService) DataSourcePolls() []DataSourcePollStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var stats []DataSourcePollStats
+	for _, p := range s.pollers {
+		if reporter, ok := p.(pollsReporter); ok {
+			stats = append(stats, reporter.DataSourcePolls()...)
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// CacheSizes returns the number of cached metric values by poller type
+func (s *DataSourceService) CacheSizes() map[string]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sizes := make(map[string]int, len(s.pollers))
+	for pollerType, p := range s.pollers {
+		if reporter, ok := p.(cacheReporter); ok {
+			sizes[pollerType] = reporter.CacheSize()
+		}
+	}
+	return sizes
+}
+
+// WaitFirstPolls returns once every task of the pollers was polled, successfully or not, or
+// with an error when ctx is done first
+func (s *DataSourceService) WaitFirstPolls(ctx context.Context) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		s.mu.RLock()
+		pending := 0
+		for _, p := range s.pollers {
+			if reporter, ok := p.(unpolledReporter); ok {
+				pending += reporter.UnpolledTasks()
+			}
+		}
+		s.mu.RUnlock()
+		if pending == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d poll tasks not polled yet: %w", pending, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
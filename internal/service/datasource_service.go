@@ -1,13 +1,25 @@
 package service
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go-weathermap/internal/config"
 	"go-weathermap/internal/datasource"
+	"go-weathermap/internal/tracing"
+	"go-weathermap/internal/utils"
 	"os"
 )
 
@@ -18,9 +30,20 @@ const (
 )
 
 type EmbeddedPoller struct {
-	mu    sync.RWMutex
-	cache map[string]int64
-	tasks []dataPollTask
+	mu       sync.RWMutex
+	cache    map[string]int64
+	tasks    []dataPollTask
+	onUpdate func()
+	owns     func(dsName string) bool        // nil unless sharding is enabled
+	polls    map[string]*DataSourcePollStats // datasource -> poll outcomes
+	polled   map[string]bool                 // keys of the tasks polled at least once
+	captures map[string]*rawCapture          // task key -> raw responses kept for debugging
+	logger   *slog.Logger
+	pollLoops
+
+	loopKey func(task dataPollTask) string // set by startLoops
+	loopFn  func(ctx context.Context, key string)
+	running map[string]bool // loop keys with a running loop
 }
 
 func (p *EmbeddedPoller) AddTask(task dataPollTask) {
@@ -32,12 +55,95 @@ func (p *EmbeddedPoller) AddTask(task dataPollTask) {
 		}
 	}
 	p.tasks = append(p.tasks, task)
+	p.spawnLocked(task)
 }
 
-func (p *EmbeddedPoller) SetCache(key string, val int64) {
+// startLoops runs loop once for every distinct loopKey of the tasks. Tasks added
+// later get a loop too, a loop returns once loopTasks has nothing left for its key.
+func (p *EmbeddedPoller) startLoops(loopKey func(task dataPollTask) string, loop func(ctx context.Context, key string)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.loopKey, p.loopFn = loopKey, loop
+	p.running = make(map[string]bool)
+	for _, task := range p.tasks {
+		p.spawnLocked(task)
+	}
+}
+
+func (p *EmbeddedPoller) spawnLocked(task dataPollTask) {
+	if p.loopFn == nil {
+		return
+	}
+	key := p.loopKey(task)
+	if p.running[key] {
+		return
+	}
+	p.running[key] = true
+	loop := p.loopFn
+	p.run(func(ctx context.Context) { loop(ctx, key) })
+}
+
+// loopTasks returns the current tasks of a loop, an empty result marks the loop as finished
+func (p *EmbeddedPoller) loopTasks(key string) []dataPollTask {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var tasks []dataPollTask
+	for _, task := range p.tasks {
+		if p.loopKey(task) == key {
+			tasks = append(tasks, task)
+		}
+	}
+	if len(tasks) == 0 {
+		delete(p.running, key)
+	}
+	return tasks
+}
+
+// RemoveTasks drops the tasks and cached values of a datasource
+func (p *EmbeddedPoller) RemoveTasks(dsName string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	p.tasks = slices.DeleteFunc(p.tasks, func(task dataPollTask) bool {
+		if task.DS.Name != dsName {
+			return false
+		}
+		delete(p.cache, task.Key)
+		delete(p.polled, task.Key)
+		return true
+	})
+	delete(p.polls, dsName)
+}
+
+func (p *EmbeddedPoller) SetCache(key string, val int64) {
+	p.mu.Lock()
 	p.cache[key] = val
+	onUpdate := p.onUpdate
+	p.mu.Unlock()
+	if onUpdate != nil {
+		onUpdate()
+	}
+}
+
+func (p *EmbeddedPoller) setUpdateHook(fn func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onUpdate = fn
+}
+
+func (p *EmbeddedPoller) setLogger(logger *slog.Logger) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.logger = logger
+}
+
+// log returns the logger of the poller, the default one until the service sets it
+func (p *EmbeddedPoller) log() *slog.Logger {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.logger == nil {
+		return slog.Default()
+	}
+	return p.logger
 }
 
 func (p *EmbeddedPoller) GetCache(key string) (int64, bool) {
@@ -50,19 +156,31 @@ func (p *EmbeddedPoller) GetCache(key string) (int64, bool) {
 type Poller interface {
 	AddTask(ds config.DataSourceConfig, iface config.InterfaceConfig, metricName string, interval time.Duration)
 	Start()
+	// Stop ends polling, waiting for in-flight polls until ctx expires
+	Stop(ctx context.Context) error
+	// RemoveTasks stops polling a datasource, AddTask works after Start too
+	RemoveTasks(dsName string)
 	GetMetric(ds config.DataSourceConfig, iface config.InterfaceConfig, metricName string) interface{}
 }
 
-func CreatePoller(pollerType string) Poller { // Poller fabric
+func CreatePoller(pollerType string, limits ResourceLimits) Poller { // Poller fabric
 	switch pollerType {
 	case SNMPPollerType:
-		return NewSNMPPoller()
+		p := NewSNMPPoller()
+		p.workers = utils.NewSemaphore(limits.MaxPollerWorkers)
+		return p
 	case "mock":
 		return NewMockPoller()
+	case AgentPollerType:
+		return NewAgentPoller()
 	case "zabbix":
-		return NewZabbixPoller()
-	case "prometheus":
-		return NewPrometheusPoller()
+		return NewZabbixPoller(datasource.NewHTTPClient(limits.HTTP))
+	case PrometheusPollerType:
+		p := NewPrometheusPoller(datasource.NewHTTPClient(limits.HTTP))
+		p.workers = utils.NewSemaphore(limits.MaxPollerWorkers)
+		return p
+	case RRDPollerType:
+		return NewRRDPoller()
 	default:
 		return nil
 	}
@@ -71,131 +189,259 @@ func CreatePoller(pollerType string) Poller { // Poller fabric
 // SNMP POLLER
 type SNMPPoller struct {
 	EmbeddedPoller
+	stats     *pollStats
+	workers   *utils.Semaphore       // bounds concurrent polls, one goroutine by poll in flight
+	devices   map[string]*snmpDevice // datasource -> reachability and sysUpTime, guarded by mu
+	lineRates map[string]int64       // task key -> bandwidth of the link in bytes/s, guarded by mu
+	wake      chan struct{}          // new tasks for the scheduler
+	queued    atomic.Int64           // groups due and waiting for a worker or their device
 }
 
 func NewSNMPPoller() *SNMPPoller {
-	return &SNMPPoller{EmbeddedPoller{cache: make(map[string]int64)}}
+	return &SNMPPoller{
+		EmbeddedPoller: EmbeddedPoller{cache: make(map[string]int64)},
+		stats:          newPollStats(SNMPPollerType),
+		workers:        utils.NewSemaphore(DefaultMaxPollerWorkers),
+		wake:           make(chan struct{}, 1),
+	}
 }
 
-func (p *SNMPPoller) AddTask(ds config.DataSourceConfig, iface config.InterfaceConfig, metricName string, interval time.Duration) {
-	oids, ok := iface.Params["oids"].(map[string]interface{})
+func (p *SNMPPoller) addTask(ds config.DataSourceConfig, iface config.InterfaceConfig, metricName string, interval time.Duration) {
+	oid, ok := snmpOID(iface, metricName)
 	if !ok {
 		return
 	}
-	oid, ok := oids[metricName].(string)
-	if !ok {
+
+	target, err := datasource.ParseSNMPTarget(ds.Params)
+	if err != nil {
+		p.log().Warn("skipping snmp datasource", "datasource", ds.Name, "error", err)
 		return
 	}
-
-	host, _ := ds.Params["host"].(string)
-	port, _ := ds.Params["port"].(int)
 	community, _ := ds.Params["community"].(string)
 
-	key := fmt.Sprintf("%s:%d:%s", host, port, oid)
+	key := snmpTaskKey(target, oid)
+	gauges, _ := iface.Params["gauges"].([]interface{})
 	p.EmbeddedPoller.AddTask(dataPollTask{
-		Host:             host,
-		Port:             port,
+		Host:             target.Host,
+		Port:             int(target.Port),
 		Community:        community,
 		MetricIdentifier: oid,
 		Key:              key,
 		DS:               ds,
 		Interval:         interval,
+		Gauge:            metricName == SpeedMetric || slices.Contains(gauges, interface{}(metricName)),
 	})
 }
 
+type counterSample struct {
+	oid   string // polled for the task, see snmpGroup.counters
+	value int64
+	at    time.Time
+}
+
+// snmpTarget is the device a task is polled from, its poll stats are kept by device
+func snmpTarget(task dataPollTask) string {
+	return net.JoinHostPort(task.Host, strconv.Itoa(task.Port))
+}
+
+// snmpGroupKey groups tasks polled from one device with the same community, timeout and
+// retries, all OIDs of a group are fetched with one multi-OID Get per cycle
+func snmpGroupKey(task dataPollTask) string {
+	key := snmpTarget(task) + "|" + task.Community
+	if target, err := datasource.ParseSNMPTarget(task.DS.Params); err == nil {
+		key += fmt.Sprintf("|%s|%d", target.Timeout, target.Retries)
+	}
+	return key
+}
+
+func minInterval(tasks []dataPollTask) time.Duration {
+	interval := tasks[0].Interval
+	for _, task := range tasks[1:] {
+		interval = min(interval, task.Interval)
+	}
+	return interval
+}
+
 func (p *SNMPPoller) Start() {
-	p.mu.RLock()
-	defer p.mu.RUnlock()
+	// one scheduler for all the tasks, see schedule
+	p.startLoops(func(dataPollTask) string { return "" }, p.schedule)
+}
 
-	for _, task := range p.tasks {
-		go p.pollTask(task)
+// AddTask also wakes the scheduler, a new device is polled at once
+func (p *SNMPPoller) AddTask(ds config.DataSourceConfig, iface config.InterfaceConfig, metricName string, interval time.Duration) {
+	p.addTask(ds, iface, metricName, interval)
+	select {
+	case p.wake <- struct{}{}:
+	default:
 	}
 }
 
-func (p *SNMPPoller) pollTask(task dataPollTask) {
+// pollGroup polls the tasks of a group once and returns the interval until its next poll
+func (p *SNMPPoller) pollGroup(ctx context.Context, g *snmpGroup, tasks []dataPollTask) time.Duration {
 	snmpClient := datasource.GetGlobalSNMPClient()
-	ticker := time.NewTicker(task.Interval)
-	defer ticker.Stop()
-
-	var prevValue int64
-	var prevTime time.Time
+	// tasks are read every cycle, a reload may have changed them
+	if base := minInterval(tasks); base != g.base {
+		g.base, g.interval = base, base
+	}
+	target := g.device
+
+	owned := make([]dataPollTask, 0, len(tasks))
+	oids := make([]string, 0, len(tasks))
+	for _, task := range tasks {
+		// tasks owned by another instance drop out of prev, no rate across that time
+		if p.ownsTask(task) {
+			owned = append(owned, task)
+			for _, oid := range g.pollOIDs(task) {
+				if !slices.Contains(oids, oid) {
+					oids = append(oids, oid)
+				}
+			}
+		}
+	}
+	if len(owned) == 0 {
+		clear(g.prev)
+		return g.interval
+	}
+	if !slices.Contains(oids, sysUpTimeOID) {
+		oids = append(oids, sysUpTimeOID)
+	}
 
-	for range ticker.C {
-		valRaw, err := snmpClient.Get(context.Background(), task.DS, task.MetricIdentifier)
-		if err != nil {
-			fmt.Printf("[ERROR] SNMP Get failed for %s: %v\n", task.Key, err)
-			continue
+	started := time.Now()
+	pollCtx, span := tracing.StartClient(ctx, "snmp poll", "target", target, "oids", len(oids))
+	values, err := snmpClient.GetMany(pollCtx, owned[0].DS, oids)
+	span.RecordError(err)
+	span.End()
+	p.recordPolls(owned, err)
+	rebooted := p.recordDevice(owned, values, err, time.Now())
+	verdict := p.stats.record(target, g.base, time.Since(started), err)
+	logPoll(p.log(), SNMPPollerType, target, g.interval, verdict)
+	g.interval = verdict.next
+	if err != nil {
+		p.log().Log(ctx, pollErrorLevel(verdict), "snmp get failed", "target", target, "oids", len(oids), "failures", verdict.failures, "error", err)
+		for _, task := range owned {
+			p.capture(task, nil, false, err)
 		}
+		return g.interval
+	}
 
-		val, ok := valRaw.(int64)
+	now := time.Now()
+	// every counter of a rebooted device restarted from 0, the wrap correction would turn
+	// that into a spike: the samples of this poll only start new rates
+	if rebooted {
+		p.log().Info("snmp counters reset by a reboot, samples discarded", "target", target)
+		clear(g.prev)
+	}
+	samples := make(map[string]counterSample, len(owned))
+	for _, task := range owned {
+		val, oid, ok := g.counterValue(p.log(), task, values)
 		if !ok {
-			fmt.Printf("[ERROR] SNMP value is not int64 for %s\n", task.Key)
+			p.log().Error("no snmp data", "target", target, "oid", task.MetricIdentifier, "datasource", task.DS.Name)
+			p.capture(task, nil, false, fmt.Errorf("no value for oid %s", task.MetricIdentifier))
 			continue
 		}
-
-		if !prevTime.IsZero() {
-			elapsed := time.Since(prevTime).Seconds()
-			if elapsed > 0 {
-				delta := val - prevValue
-				if delta < 0 {
-					delta += (1 << 32) // Counter wrap around for snmp 32 bit counter
+		if task.Gauge {
+			p.SetCache(task.Key, val.Value)
+			p.capture(task, val.Value, true, nil)
+			continue
+		}
+		cached := false
+		var discarded error
+		// no rate across a switch between the HC and the 32-bit counter
+		if last, ok := g.prev[task.Key]; ok && last.oid == oid {
+			if elapsed := now.Sub(last.at).Seconds(); elapsed > 0 {
+				rate, err := counterRate(last, val, elapsed, p.lineRate(task, oid, values))
+				if err != nil {
+					p.log().Warn("snmp counter reset, sample discarded", "target", target, "oid", oid, "datasource", task.DS.Name, "error", err)
+					discarded = err
+				} else {
+					p.SetCache(task.Key, rate)
+					cached = true
 				}
-				bps := int64(float64(delta) / elapsed)
-				p.SetCache(task.Key, bps)
 			}
 		}
+		p.capture(task, val.Value, cached, discarded)
+		samples[task.Key] = counterSample{oid: oid, value: val.Value, at: now}
+	}
+	g.prev = samples
+	return g.interval
+}
+
+// RemoveTasks also forgets the device of the datasource, the line rates of its counters and
+// the poll stats of its target
+func (p *SNMPPoller) RemoveTasks(dsName string) {
+	removed := p.removeTasks(dsName)
+	p.forgetTargets(p.stats, removed, snmpTarget)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.devices, dsName)
+	for _, task := range removed {
+		delete(p.lineRates, task.Key)
+	}
+}
+
+func (p *SNMPPoller) PollStats() []TargetPollStats {
+	return p.stats.snapshot()
+}
+
+func (p *SNMPPoller) WorkerUsage() PollerUsage {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return PollerUsage{Type: SNMPPollerType, Tasks: len(p.tasks), Workers: p.workers.Usage(), Queued: p.queued.Load()}
+}
 
-		prevValue = val
-		prevTime = time.Now()
+// counterDelta returns the increase of a counter of width bits from prev to cur, across one
+// wrap around. Values of other types wrap like a Counter32.
+func counterDelta(prev, cur int64, width uint) int64 {
+	if width == 64 {
+		return int64(uint64(cur) - uint64(prev))
+	}
+	delta := cur - prev
+	if delta < 0 {
+		delta += 1 << 32
 	}
+	return delta
 }
 
 func (p *SNMPPoller) GetMetric(ds config.DataSourceConfig, iface config.InterfaceConfig, metricName string) interface{} {
-	oids, ok := iface.Params["oids"].(map[string]interface{})
+	oid, ok := snmpOID(iface, metricName)
 	if !ok {
 		return nil
 	}
-	oid, ok := oids[metricName].(string)
-	if !ok {
+
+	target, err := datasource.ParseSNMPTarget(ds.Params)
+	if err != nil {
 		return nil
 	}
-
-	host, _ := ds.Params["host"].(string)
-	port, _ := ds.Params["port"].(int)
-	key := fmt.Sprintf("%s:%d:%s", host, port, oid)
-	val, _ := p.GetCache(key)
+	val, _ := p.GetCache(snmpTaskKey(target, oid))
 	return val
 }
 
+func snmpTaskKey(target datasource.SNMPTarget, oid string) string {
+	return fmt.Sprintf("%s:%s", target, oid)
+}
+
 // ZABBIX POLLER
 type ZabbixPoller struct {
+	client *datasource.ZabbixClient
+	logger *slog.Logger
 }
 
-func NewZabbixPoller() *ZabbixPoller {
-	return &ZabbixPoller{}
+func NewZabbixPoller(httpClient *http.Client) *ZabbixPoller {
+	return &ZabbixPoller{client: datasource.NewZabbixClient(httpClient), logger: slog.Default()}
 }
 
 func (p *ZabbixPoller) AddTask(ds config.DataSourceConfig, iface config.InterfaceConfig, metricName string, interval time.Duration) {
 }
 func (p *ZabbixPoller) Start() {}
-func (p *ZabbixPoller) GetMetric(ds config.DataSourceConfig, iface config.InterfaceConfig, metricName string) interface{} {
-	fmt.Println("[ZabbixPoller] Заглушка: всегда возвращает 0")
-	return 0
-}
-
-// PROMETHEUS POLLER
-type PrometheusPoller struct {
-}
-
-func NewPrometheusPoller() *PrometheusPoller {
-	return &PrometheusPoller{}
+func (p *ZabbixPoller) Stop(ctx context.Context) error {
+	return nil
 }
-
-func (p *PrometheusPoller) AddTask(ds config.DataSourceConfig, iface config.InterfaceConfig, metricName string, interval time.Duration) {
+func (p *ZabbixPoller) RemoveTasks(dsName string) {}
+func (p *ZabbixPoller) setLogger(logger *slog.Logger) {
+	p.logger = logger
 }
-func (p *PrometheusPoller) Start() {}
-func (p *PrometheusPoller) GetMetric(ds config.DataSourceConfig, iface config.InterfaceConfig, metricName string) interface{} {
-	fmt.Println("[PrometheusPoller] Заглушка: всегда возвращает 0")
+func (p *ZabbixPoller) GetMetric(ds config.DataSourceConfig, iface config.InterfaceConfig, metricName string) interface{} {
+	p.logger.Debug("zabbix poller is a stub, returning 0", "datasource", ds.Name, "interface", iface.Name, "metric", metricName)
 	return 0
 }
 
@@ -212,50 +458,70 @@ func NewMockPoller() *MockPoller {
 	}
 }
 
+func mockKey(ds config.DataSourceConfig, iface config.InterfaceConfig, metricName string) string {
+	return fmt.Sprintf("%s:%s:%s", ds.Name, iface.Name, metricName)
+}
+
 func (p *MockPoller) AddTask(ds config.DataSourceConfig, iface config.InterfaceConfig, metricName string, interval time.Duration) {
-	key := fmt.Sprintf("%s:%s:%s", ds.Name, iface.Name, metricName)
 	p.EmbeddedPoller.AddTask(dataPollTask{
 		Host:             ds.Name,
 		MetricIdentifier: metricName,
-		Key:              key,
+		Key:              mockKey(ds, iface, metricName),
 		DS:               ds,
 		Interval:         interval,
 	})
 }
 
 func (p *MockPoller) Start() {
-	go func() {
-		ticker := time.NewTicker(1 * time.Second)
-		defer ticker.Stop()
-
-		for range ticker.C {
-			p.mu.RLock()
-			tasks := make([]dataPollTask, len(p.tasks))
-			copy(tasks, p.tasks)
-			p.mu.RUnlock()
-
-			traffic, err := p.client.GetTraffic(context.Background())
-			if err != nil {
-				continue
+	// one loop generates traffic for every task
+	p.startLoops(func(dataPollTask) string { return "" }, p.pollAll)
+}
+
+func (p *MockPoller) pollAll(ctx context.Context, key string) {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for first := true; ; first = false {
+		if !first {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
 			}
+		}
 
-			for _, task := range tasks {
-				var val int64
-				switch task.MetricIdentifier {
-				case "in":
-					val = traffic.InBytes
-				case "out":
-					val = traffic.OutBytes
-				}
-				p.SetCache(task.Key, val)
+		tasks := p.loopTasks(key)
+		if len(tasks) == 0 {
+			return
+		}
+		owned := slices.DeleteFunc(tasks, func(task dataPollTask) bool { return !p.ownsTask(task) })
+		traffic, err := p.client.GetTraffic(ctx)
+		p.recordPolls(owned, err)
+		if err != nil {
+			for _, task := range owned {
+				p.capture(task, nil, false, err)
 			}
+			continue
 		}
-	}()
+
+		for _, task := range owned {
+			var val int64
+			switch task.MetricIdentifier {
+			case "in":
+				val = traffic.InBytes
+			case "out":
+				val = traffic.OutBytes
+			case SpeedMetric:
+				val = mockSpeed
+			}
+			p.SetCache(task.Key, val)
+			p.capture(task, val, true, nil)
+		}
+	}
 }
 
 func (p *MockPoller) GetMetric(ds config.DataSourceConfig, iface config.InterfaceConfig, metricName string) interface{} {
-	key := fmt.Sprintf("%s:%s:%s", ds.Name, iface.Name, metricName)
-	val, _ := p.GetCache(key)
+	val, _ := p.GetCache(mockKey(ds, iface, metricName))
 	return val
 }
 
@@ -267,58 +533,136 @@ type dataPollTask struct {
 	Key              string // host:port:oid // ds:iface:metric
 	DS               config.DataSourceConfig
 	Interval         time.Duration
+	Gauge            bool // SNMP values cached as read, not as the rate of a counter
 }
 
 type DataSourceService struct {
 	datasources map[string]config.DataSourceConfig
 	pollers     map[string]Poller // key: snmp, zabbix, prometheus, mock, ...
+	faults      *faultRegistry
+	lookups     *lookupFailures
+	updates     *updateBroadcaster
+	limits      ResourceLimits
+	cluster     *cluster
+	discovery   *prometheusDiscovery
+	loops       pollLoops // cluster sync, config dir watcher, target discovery
+	logger      *slog.Logger
+
+	mu      sync.RWMutex // guards datasources, pollers, bandwidths and started, Reload replaces them
+	started bool
+	// bandwidths of the links reading an interface in bytes/s, see SetLinkBandwidths
+	bandwidths map[interfaceRef]int64
 }
 
 func NewDataSourceService(datasources []config.DataSourceConfig) *DataSourceService {
-	dsMap := make(map[string]config.DataSourceConfig)
+	return NewDataSourceServiceWithLimits(datasources, DefaultResourceLimits())
+}
+
+func NewDataSourceServiceWithLimits(datasources []config.DataSourceConfig, limits ResourceLimits) *DataSourceService {
+	datasource.GetGlobalSNMPClient().SetMaxSessions(limits.MaxSNMPSessions)
+
+	s := &DataSourceService{
+		datasources: make(map[string]config.DataSourceConfig),
+		pollers:     make(map[string]Poller),
+		faults:      newFaultRegistry(),
+		lookups:     newLookupFailures(),
+		updates:     newUpdateBroadcaster(),
+		limits:      limits,
+		discovery:   newPrometheusDiscovery(datasource.NewPrometheusClient(datasource.NewHTTPClient(limits.HTTP))),
+		logger:      slog.Default(),
+	}
 	for _, ds := range datasources {
-		dsMap[ds.Name] = ds
+		s.datasources[ds.Name] = ds
 	}
-
-	pollers := make(map[string]Poller)
 	for _, ds := range datasources {
-		if pollers[ds.Type] == nil {
-			poller := CreatePoller(ds.Type)
-			if poller == nil {
-				fmt.Printf("[WARN] unknown poller type: %s\n", ds.Type)
-				continue
-			}
-			pollers[ds.Type] = poller
-		}
+		s.addTasksLocked(ds)
 	}
+	return s
+}
 
-	for _, ds := range datasources {
-		poller, ok := pollers[ds.Type]
-		if !ok {
-			continue
-		}
-		for _, iface := range ds.Interfaces {
-			metricNames := getMetricNames(ds, iface)
-			for _, metricName := range metricNames {
-				interval := DefaultPollInterval
-				if ds.PollInterval > 0 {
-					interval = time.Duration(ds.PollInterval) * time.Second
-				}
-				poller.AddTask(ds, iface, metricName, interval)
-			}
+// loggerAware is implemented by pollers logging their polls
+type loggerAware interface {
+	setLogger(logger *slog.Logger)
+}
+
+// SetLogger replaces the logger of the service, its pollers and the SNMP client
+func (s *DataSourceService) SetLogger(logger *slog.Logger) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logger = logger
+	for _, p := range s.pollers {
+		if la, ok := p.(loggerAware); ok {
+			la.setLogger(logger)
 		}
 	}
+	if s.cluster != nil {
+		s.cluster.setLogger(logger)
+	}
+	datasource.GetGlobalSNMPClient().SetLogger(logger)
+}
+
+// pollerLocked returns the poller of a datasource type, creating it on first use
+func (s *DataSourceService) pollerLocked(pollerType string) (Poller, bool) {
+	if poller, ok := s.pollers[pollerType]; ok {
+		return poller, true
+	}
+	poller := CreatePoller(pollerType, s.limits)
+	if poller == nil {
+		s.logger.Warn("unknown poller type", "type", pollerType)
+		return nil, false
+	}
+	if notifier, ok := poller.(updateNotifier); ok {
+		notifier.setUpdateHook(s.updates.notify)
+	}
+	if la, ok := poller.(loggerAware); ok {
+		la.setLogger(s.logger)
+	}
+	if sa, ok := poller.(shardAware); ok && s.cluster != nil {
+		sa.setShardFilter(s.cluster.owns)
+	}
+	if s.started {
+		poller.Start()
+	}
+	s.pollers[pollerType] = poller
+	return poller, true
+}
 
-	return &DataSourceService{
-		datasources: dsMap,
-		pollers:     pollers,
+func (s *DataSourceService) addTasksLocked(ds config.DataSourceConfig) {
+	poller, ok := s.pollerLocked(ds.Type)
+	if !ok {
+		return
+	}
+	for _, iface := range ds.Interfaces {
+		for _, metricName := range getMetricNames(ds, iface) {
+			poller.AddTask(ds, iface, metricName, pollInterval(ds))
+		}
 	}
 }
 
 func (s *DataSourceService) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.started = true
 	for _, p := range s.pollers {
 		p.Start()
 	}
+	if s.cluster != nil {
+		s.loops.run(s.cluster.run)
+	}
+}
+
+// Stop stops all pollers, polls in flight are given until ctx expires to finish
+func (s *DataSourceService) Stop(ctx context.Context) error {
+	errs := []error{s.loops.Stop(ctx)}
+	s.mu.RLock()
+	pollers := maps.Clone(s.pollers)
+	s.mu.RUnlock()
+	for pollerType, p := range pollers {
+		if err := p.Stop(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s poller: %w", pollerType, err))
+		}
+	}
+	return errors.Join(errs...)
 }
 
 func getMetricNames(ds config.DataSourceConfig, iface config.InterfaceConfig) []string {
@@ -328,8 +672,21 @@ func getMetricNames(ds config.DataSourceConfig, iface config.InterfaceConfig) []
 			for name := range oids {
 				names = append(names, name)
 			}
+			if _, ok := oids[SpeedMetric]; !ok {
+				if _, ok := snmpOID(iface, SpeedMetric); ok {
+					names = append(names, SpeedMetric)
+				}
+			}
 			return names
 		}
+	} else if ds.Type == PrometheusPollerType {
+		names := make([]string, 0, 2)
+		for param := range iface.Params {
+			if name, ok := strings.CutPrefix(param, prometheusQueryPrefix); ok && name != "" {
+				names = append(names, name)
+			}
+		}
+		return names
 	} else {
 		if metrics, ok := iface.Params["metrics"].([]interface{}); ok {
 			names := make([]string, 0, len(metrics))
@@ -340,52 +697,96 @@ func getMetricNames(ds config.DataSourceConfig, iface config.InterfaceConfig) []
 			}
 			return names
 		}
+		if ds.Type == RRDPollerType {
+			return slices.Sorted(maps.Keys(rrdDefaultDataSources))
+		}
 	}
 	return nil
 }
 
-func (s *DataSourceService) GetInterfaceMetrics(ctx context.Context, dsName, ifaceName string, metrics []string) (map[string]interface{}, error) {
-	ds, ok := s.datasources[dsName]
-	if !ok {
-		fmt.Printf("[DEBUG] datasource not found: %s\n", dsName)
-		return nil, fmt.Errorf("datasource not found: %s", dsName)
-	}
-	var iface *config.InterfaceConfig
-	for i := range ds.Interfaces {
-		if ds.Interfaces[i].Name == ifaceName {
-			iface = &ds.Interfaces[i]
-			break
-		}
+func (s *DataSourceService) GetInterfaceMetrics(ctx context.Context, dsName, ifaceName string, metrics []string) (result map[string]interface{}, err error) {
+	_, span := tracing.Start(ctx, "DataSourceService.GetInterfaceMetrics", "datasource", dsName, "interface", ifaceName)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	if lookupErr, ok := s.lookups.cached(dsName, ifaceName); ok {
+		return nil, lookupErr
 	}
-	if iface == nil {
-		fmt.Printf("[DEBUG] interface not found: %s\n", ifaceName)
-		return nil, fmt.Errorf("interface not found: %s", ifaceName)
+	ds, iface, poller, lookupErr := s.resolve(dsName, ifaceName)
+	if s.lookups.record(dsName, ifaceName, lookupErr) {
+		s.logger.Warn("link misconfigured", "datasource", dsName, "interface", ifaceName, "error", lookupErr)
 	}
-	result := make(map[string]interface{})
-	pollerType := ds.Type
-	if pollerType == "" {
-		pollerType = SNMPPollerType
+	if lookupErr != nil {
+		return nil, lookupErr
 	}
-	poller, ok := s.pollers[pollerType]
-	if !ok {
-		fmt.Printf("[DEBUG] poller for type %s not found\n", pollerType)
-		return nil, fmt.Errorf("poller for type %s not found", pollerType)
+	if fault, ok := s.faults.find("", "", dsName); ok && fault.State == FaultStateDown {
+		return nil, fmt.Errorf("datasource %s is down (simulated fault %s)", dsName, fault.ID)
+	}
+	pollerType := cmp.Or(ds.Type, SNMPPollerType)
+	span.SetAttributes("poller", pollerType)
+	result = make(map[string]interface{})
+	if s.cluster != nil && !s.cluster.owns(dsName) {
+		span.SetAttributes("remote", true)
+		for _, metric := range metrics {
+			val, _ := s.cluster.remoteMetric(dsName, ifaceName, metric)
+			result[metric] = val
+		}
+		return result, nil
+	}
+	if checker, ok := poller.(availabilityChecker); ok {
+		if err := checker.checkAvailable(ds, iface); err != nil {
+			return nil, fmt.Errorf("datasource %s: %w", dsName, err)
+		}
 	}
-	fmt.Printf("[DEBUG] GetInterfaceMetrics: ds=%s iface=%s metrics=%v pollerType=%s\n", dsName, ifaceName, metrics, pollerType)
 	for _, metric := range metrics {
-		val := poller.GetMetric(ds, *iface, metric)
-		fmt.Printf("[DEBUG] metric=%s val=%v\n", metric, val)
-		result[metric] = val
+		result[metric] = poller.GetMetric(ds, iface, metric)
 	}
+	s.logger.Debug("interface metrics", "datasource", dsName, "interface", ifaceName, "poller", pollerType, "values", result)
 	return result, nil
 }
 
+// resolve finds the datasource, interface and poller a link reads its metrics from
+func (s *DataSourceService) resolve(dsName, ifaceName string) (config.DataSourceConfig, config.InterfaceConfig, Poller, *LookupError) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ds, ok := s.datasources[dsName]
+	if !ok {
+		return ds, config.InterfaceConfig{}, nil, &LookupError{fmt.Sprintf("datasource not found: %s", dsName)}
+	}
+	i := slices.IndexFunc(ds.Interfaces, func(iface config.InterfaceConfig) bool { return iface.Name == ifaceName })
+	if i < 0 {
+		return ds, config.InterfaceConfig{}, nil, &LookupError{fmt.Sprintf("interface not found: %s", ifaceName)}
+	}
+	pollerType := cmp.Or(ds.Type, SNMPPollerType)
+	poller, ok := s.pollers[pollerType]
+	if !ok {
+		return ds, ds.Interfaces[i], nil, &LookupError{fmt.Sprintf("poller for type %s not found", pollerType)}
+	}
+	return ds, ds.Interfaces[i], poller, nil
+}
+
 func LoadAllDataSources(configDir string) ([]config.DataSourceConfig, error) {
 	datasources := []config.DataSourceConfig{}
+	var errs []error
+	err := eachMapFile(configDir, func(name string, m *config.Map) {
+		if verr := ValidateDataSources(m.Datasources); verr != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, verr))
+		}
+		datasources = append(datasources, m.Datasources...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return datasources, errors.Join(errs...)
+}
+
+// eachMapFile calls fn with every map file of configDir which parses, by file name
+func eachMapFile(configDir string, fn func(name string, m *config.Map)) error {
 	parser := config.NewParser()
 	entries, err := os.ReadDir(configDir)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	for _, entry := range entries {
 		if entry.IsDir() || len(entry.Name()) <= 5 || entry.Name()[len(entry.Name())-5:] != ".yaml" {
@@ -396,12 +797,67 @@ func LoadAllDataSources(configDir string) ([]config.DataSourceConfig, error) {
 			continue
 		}
 		m, err := parser.ParseYAML(file)
-		if err := file.Close(); err != nil {
-			fmt.Printf("[WARN] failed to close file %s: %v\n", file.Name(), err)
-		}
+		_ = file.Close()
 		if err == nil && m != nil {
-			datasources = append(datasources, m.Datasources...)
+			fn(entry.Name(), m)
+		}
+	}
+	return nil
+}
+
+// defaultPollInterval of datasources without poll_interval, set once at startup
+var defaultPollInterval = DefaultPollInterval
+
+// PollIntervalFromEnv reads WEATHERMAP_POLL_INTERVAL, the interval of datasources without
+// poll_interval, DefaultPollInterval when unset
+func PollIntervalFromEnv() (time.Duration, error) {
+	value := strings.TrimSpace(os.Getenv("WEATHERMAP_POLL_INTERVAL"))
+	if value == "" {
+		return DefaultPollInterval, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < time.Second {
+		return 0, fmt.Errorf("invalid WEATHERMAP_POLL_INTERVAL: %s, must be at least 1s", value)
+	}
+	return interval, nil
+}
+
+// SetDefaultPollInterval replaces DefaultPollInterval, before datasources are loaded
+func SetDefaultPollInterval(interval time.Duration) {
+	defaultPollInterval = interval
+}
+
+func pollInterval(ds config.DataSourceConfig) time.Duration {
+	if ds.PollInterval > 0 {
+		return time.Duration(ds.PollInterval) * time.Second
+	}
+	return defaultPollInterval
+}
+
+// ValidateDataSources checks connection params which would otherwise only fail when polled
+func ValidateDataSources(datasources []config.DataSourceConfig) error {
+	var errs []error
+	for _, ds := range datasources {
+		if ds.Type == RRDPollerType {
+			for _, iface := range ds.Interfaces {
+				if _, err := rrdPath(ds, iface); err != nil {
+					errs = append(errs, fmt.Errorf("datasource %s: %w", ds.Name, err))
+				}
+			}
+			continue
+		}
+		if ds.Type != SNMPPollerType {
+			continue
+		}
+		target, err := datasource.ParseSNMPTarget(ds.Params)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("datasource %s: %w", ds.Name, err))
+			continue
+		}
+		if interval := pollInterval(ds); target.MaxDuration() > interval {
+			errs = append(errs, fmt.Errorf("datasource %s: timeout %s with %d retries can take %s, longer than poll interval %s",
+				ds.Name, target.Timeout, target.Retries, target.MaxDuration(), interval))
 		}
 	}
-	return datasources, nil
+	return errors.Join(errs...)
 }
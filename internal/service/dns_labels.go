@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go-weathermap/internal/config"
+)
+
+const (
+	DefaultDNSLabelInterval = time.Hour
+	dnsLookupTimeout        = 5 * time.Second
+)
+
+// DNSLabelIntervalFromEnv reads WEATHERMAP_DNS_LABEL_INTERVAL, 0 disables syncing labels
+func DNSLabelIntervalFromEnv() (time.Duration, error) {
+	value := os.Getenv("WEATHERMAP_DNS_LABEL_INTERVAL")
+	if value == "" {
+		return DefaultDNSLabelInterval, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < 0 {
+		return 0, fmt.Errorf("invalid WEATHERMAP_DNS_LABEL_INTERVAL: %s", value)
+	}
+	return interval, nil
+}
+
+// SyncDNSLabels sets the label of nodes with dns_label to the PTR name of their management IP.
+// Maps are only saved when a label changed, failed lookups keep the current label.
+func (s *MapService) SyncDNSLabels(ctx context.Context) (updated int, err error) {
+	mapNames, err := s.ListMaps()
+	if err != nil {
+		return 0, err
+	}
+	for _, mapName := range mapNames {
+		mapConfig, err := s.loadMapConfig(mapName)
+		if err != nil {
+			continue
+		}
+		changed := 0
+		for i, node := range mapConfig.Nodes {
+			if node.DNSLabel == "" {
+				continue
+			}
+			label, err := s.dnsLabel(ctx, node)
+			if err != nil {
+				s.logger.Warn("dns label lookup failed", "map", mapName, "node", node.Name, "error", err)
+				continue
+			}
+			if label != node.Label {
+				mapConfig.Nodes[i].Label = label
+				changed++
+			}
+		}
+		if changed == 0 {
+			continue
+		}
+		if err := s.saveMapAs(ActorDNSLabels, mapName, mapConfig); err != nil {
+			return updated, fmt.Errorf("map %s: %w", mapName, err)
+		}
+		updated += changed
+	}
+	return updated, nil
+}
+
+func (s *MapService) dnsLabel(ctx context.Context, node config.Node) (string, error) {
+	addr, err := config.ParseAddress(node.ManagementIP)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, dnsLookupTimeout)
+	defer cancel()
+	names, err := s.lookupAddr(ctx, addr.String())
+	if err != nil {
+		return "", err
+	}
+	if len(names) == 0 {
+		return "", fmt.Errorf("no PTR record for %s", addr)
+	}
+	name := strings.TrimSuffix(names[0], ".")
+	if node.DNSLabel == config.DNSLabelShort {
+		name, _, _ = strings.Cut(name, ".")
+	}
+	return name, nil
+}
+
+// WatchDNSLabels syncs labels now and then every interval until Stop
+func (s *MapService) WatchDNSLabels(interval time.Duration) {
+	s.loops.run(func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if updated, err := s.SyncDNSLabels(ctx); err != nil {
+				s.logger.Error("dns label sync failed", "error", err)
+			} else if updated > 0 {
+				s.logger.Info("dns labels updated", "nodes", updated)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// Stop ends the background jobs of the service
+func (s *MapService) Stop(ctx context.Context) error {
+	return s.loops.Stop(ctx)
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"go-weathermap/internal/config"
+)
+
+func TestSyncDNSLabels(t *testing.T) {
+	mapService := NewMapService(t.TempDir())
+	ptr := map[string][]string{
+		"10.0.0.1": {"core1.dc1.example.net."},
+		"10.0.0.2": {"edge1.dc1.example.net."},
+	}
+	lookups := 0
+	mapService.lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		lookups++
+		if names, ok := ptr[addr]; ok {
+			return names, nil
+		}
+		return nil, fmt.Errorf("no such host")
+	}
+
+	testMap := &config.Map{
+		Title: "dns", Width: 500, Height: 500,
+		Nodes: []config.Node{
+			{Name: "n1", Label: "old", ManagementIP: "10.0.0.1", DNSLabel: config.DNSLabelFQDN},
+			{Name: "n2", ManagementIP: "10.0.0.2/32", DNSLabel: config.DNSLabelShort},
+			{Name: "n3", Label: "kept", ManagementIP: "10.0.0.3", DNSLabel: config.DNSLabelShort},
+			{Name: "n4", Label: "manual", ManagementIP: "10.0.0.1"},
+		},
+	}
+	if err := mapService.CreateMap(testMap, "dns"); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+
+	updated, err := mapService.SyncDNSLabels(context.Background())
+	if err != nil || updated != 2 {
+		t.Fatalf("Expected 2 labels updated, got %d %v", updated, err)
+	}
+	m, _ := mapService.GetMap("dns")
+	labels := []string{m.Nodes[0].Label, m.Nodes[1].Label, m.Nodes[2].Label, m.Nodes[3].Label}
+	expected := []string{"core1.dc1.example.net", "edge1", "kept", "manual"}
+	for i := range expected {
+		if labels[i] != expected[i] {
+			t.Errorf("Expected labels %v, got %v", expected, labels)
+			break
+		}
+	}
+	if lookups != 3 {
+		t.Errorf("Expected lookups only for nodes with dns_label, got %d", lookups)
+	}
+
+	// the server changed the map, no request records it
+	entries, err := mapService.AuditEntries(AuditFilter{Map: "dns"})
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Expected one audit entry of the sync, got %+v %v", entries, err)
+	}
+	if entry := entries[0]; entry.Actor != ActorDNSLabels || entry.Action != AuditUpdate || len(entry.Changes) != 2 ||
+		entry.Changes[0].Name != "n1" || entry.Changes[1].Name != "n2" {
+		t.Errorf("Expected the label changes of n1 and n2 by %s, got %+v", ActorDNSLabels, entry)
+	}
+
+	if updated, _ := mapService.SyncDNSLabels(context.Background()); updated != 0 {
+		t.Errorf("Expected no change on second sync, got %d", updated)
+	}
+
+	if entries, _ := mapService.AuditEntries(AuditFilter{Map: "dns"}); len(entries) != 1 {
+		t.Errorf("Expected no audit entry without changes, got %d entries", len(entries))
+	}
+
+	testMap.Nodes = []config.Node{{Name: "bad", DNSLabel: config.DNSLabelFQDN}}
+	if err := mapService.CreateMap(testMap, "bad"); err == nil {
+		t.Error("Expected dns_label without management_ip to be rejected")
+	}
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/datasource"
+	"go-weathermap/internal/render"
+)
+
+const ExportFormatWeathermap = "weathermap"
+
+// ExportWeathermapConf serializes a map into PHP Weathermap .conf syntax. Settings without
+// an equivalent there (costs, commit rates, label positions) are left out, links polled by
+// other datasources than SNMP get a comment instead of a TARGET.
+func (s *MapService) ExportWeathermapConf(mapName string) ([]byte, error) {
+	mapConfig, err := s.loadMapConfig(mapName)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	writeWeathermapConf(&buf, mapConfig)
+	return buf.Bytes(), nil
+}
+
+func writeWeathermapConf(w io.Writer, m *config.Map) {
+	fmt.Fprintln(w, "# exported by go-weathermap")
+	fmt.Fprintf(w, "WIDTH %d\nHEIGHT %d\n", m.Width, m.Height)
+	if m.Title != "" {
+		fmt.Fprintf(w, "TITLE %s\n", m.Title)
+	}
+	if m.BGColor != nil {
+		fmt.Fprintf(w, "BGCOLOR %d %d %d\n", m.BGColor.R, m.BGColor.G, m.BGColor.B)
+	}
+
+	for _, name := range sortedKeys(m.Variables) {
+		if config.IsSecret(name) {
+			fmt.Fprintf(w, "# SET %s not exported: secret\n", confName(name))
+			continue
+		}
+		fmt.Fprintf(w, "SET %s %s\n", confName(name), m.Variables[name])
+	}
+
+	for _, name := range sortedKeys(m.Scales) {
+		scaleName := name
+		if name == render.DefaultScaleName {
+			scaleName = "DEFAULT"
+		}
+		fmt.Fprintln(w)
+		for _, band := range m.Scales[name] {
+			fmt.Fprintf(w, "SCALE %s %g %g %d %d %d\n", confName(scaleName), band.Min, band.Max, band.Color.R, band.Color.G, band.Color.B)
+		}
+	}
+
+	for _, node := range m.Nodes {
+		fmt.Fprintf(w, "\nNODE %s\n", confName(node.Name))
+		if node.Label != "" {
+			fmt.Fprintf(w, "\tLABEL %s\n", node.Label)
+		}
+		if node.Icon != "" {
+			fmt.Fprintf(w, "\tICON images/%s\n", node.Icon)
+		}
+		fmt.Fprintf(w, "\tPOSITION %d %d\n", node.Position.X, node.Position.Y)
+	}
+
+	for _, link := range m.Links {
+		fmt.Fprintf(w, "\nLINK %s\n", confName(link.Name))
+		if target, err := weathermapTarget(m, link); err != nil {
+			fmt.Fprintf(w, "\t# TARGET not exported: %v\n", err)
+		} else if target != "" {
+			fmt.Fprintf(w, "\tTARGET %s\n", target)
+		}
+		fmt.Fprintf(w, "\tNODES %s %s\n", confName(link.From), confName(link.To))
+		for _, via := range link.Via {
+			fmt.Fprintf(w, "\tVIA %d %d\n", via.X, via.Y)
+		}
+		if link.Bandwidth == config.BandwidthAuto {
+			fmt.Fprintf(w, "\t# BANDWIDTH not exported: read from the interface speed\n")
+		} else if link.Bandwidth != "" {
+			fmt.Fprintf(w, "\tBANDWIDTH %s\n", link.Bandwidth)
+		}
+		if link.Width > 0 {
+			fmt.Fprintf(w, "\tWIDTH %d\n", link.Width)
+		}
+		if link.Scale != "" {
+			fmt.Fprintf(w, "\tUSESCALE %s\n", confName(link.Scale))
+		}
+	}
+}
+
+// weathermapTarget builds the snmp: target of a link polled over SNMP
+func weathermapTarget(m *config.Map, link config.Link) (string, error) {
+	if link.DataSource == "" || link.Interface == "" {
+		return "", nil
+	}
+	i := slices.IndexFunc(m.Datasources, func(ds config.DataSourceConfig) bool { return ds.Name == link.DataSource })
+	if i < 0 {
+		return "", fmt.Errorf("datasource %s is not defined in this map", link.DataSource)
+	}
+	ds := m.Datasources[i]
+	if ds.Type != SNMPPollerType {
+		return "", fmt.Errorf("datasource %s is of type %s", ds.Name, ds.Type)
+	}
+	j := slices.IndexFunc(ds.Interfaces, func(iface config.InterfaceConfig) bool { return iface.Name == link.Interface })
+	if j < 0 {
+		return "", fmt.Errorf("interface %s not found in datasource %s", link.Interface, ds.Name)
+	}
+	oids, _ := ds.Interfaces[j].Params["oids"].(map[string]interface{})
+	in, _ := oids["in"].(string)
+	out, _ := oids["out"].(string)
+	if in == "" || out == "" {
+		return "", fmt.Errorf("interface %s has no in and out OIDs", link.Interface)
+	}
+
+	target, err := datasource.ParseSNMPTarget(ds.Params)
+	if err != nil {
+		return "", err
+	}
+	if target.Port != datasource.DefaultSNMPPort || strings.Contains(target.Host, ":") {
+		return "", fmt.Errorf("host %s can't be expressed in an snmp: target", target)
+	}
+	community, _ := ds.Params["community"].(string)
+	return fmt.Sprintf("snmp:%s:%s:%s:%s", community, target.Host, in, out), nil
+}
+
+// confName makes a name a single token, the .conf syntax splits on whitespace
+func confName(name string) string {
+	return strings.Join(strings.Fields(name), "_")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
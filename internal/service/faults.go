@@ -0,0 +1,186 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-weathermap/internal/config"
+)
+
+const (
+	FaultStateDown     = "down"
+	FaultStateDegraded = "degraded"
+	MaxFaultDuration   = 24 * time.Hour
+)
+
+// SimulatedFault forces a link or a whole datasource into a fake state until it expires
+type SimulatedFault struct {
+	ID          string    `json:"id"`
+	Map         string    `json:"map,omitempty"`
+	Link        string    `json:"link,omitempty"`
+	DataSource  string    `json:"datasource,omitempty"`
+	State       string    `json:"state"`
+	Utilization *float64  `json:"utilization,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// FaultsEnabledFromEnv reads WEATHERMAP_FAULTS, fault simulation is off unless it is true
+func FaultsEnabledFromEnv() (bool, error) {
+	value := strings.TrimSpace(os.Getenv("WEATHERMAP_FAULTS"))
+	if value == "" {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid WEATHERMAP_FAULTS: %s", value)
+	}
+	return enabled, nil
+}
+
+type faultRegistry struct {
+	mu     sync.Mutex
+	nextID int
+	faults map[string]SimulatedFault
+}
+
+func newFaultRegistry() *faultRegistry {
+	return &faultRegistry{faults: make(map[string]SimulatedFault)}
+}
+
+func (r *faultRegistry) add(fault SimulatedFault, duration time.Duration) (SimulatedFault, error) {
+	if fault.State != FaultStateDown && fault.State != FaultStateDegraded {
+		return SimulatedFault{}, fmt.Errorf("invalid fault state: '%s', must be '%s' or '%s'", fault.State, FaultStateDown, FaultStateDegraded)
+	}
+	if (fault.Link == "") == (fault.DataSource == "") {
+		return SimulatedFault{}, fmt.Errorf("invalid fault target: exactly one of link or datasource is required")
+	}
+	if fault.Link != "" && fault.Map == "" {
+		return SimulatedFault{}, fmt.Errorf("invalid fault target: map is required for link faults")
+	}
+	if duration <= 0 || duration > MaxFaultDuration {
+		return SimulatedFault{}, fmt.Errorf("invalid fault duration: must be between 0 and %s", MaxFaultDuration)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID++
+	fault.ID = fmt.Sprintf("fault-%d", r.nextID)
+	fault.CreatedAt = time.Now()
+	fault.ExpiresAt = fault.CreatedAt.Add(duration)
+	r.faults[fault.ID] = fault
+	return fault, nil
+}
+
+func (r *faultRegistry) list() []SimulatedFault {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pruneLocked()
+	faults := make([]SimulatedFault, 0, len(r.faults))
+	for _, f := range r.faults {
+		faults = append(faults, f)
+	}
+	return faults
+}
+
+func (r *faultRegistry) remove(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if id == "" {
+		r.faults = make(map[string]SimulatedFault)
+		return nil
+	}
+	if _, ok := r.faults[id]; !ok {
+		return fmt.Errorf("fault not found: %s", id)
+	}
+	delete(r.faults, id)
+	return nil
+}
+
+// find returns the link fault if any, otherwise the datasource fault. When several match,
+// the one expiring last wins.
+func (r *faultRegistry) find(mapName, linkName, dsName string) (SimulatedFault, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pruneLocked()
+	var linkFault, dsFault SimulatedFault
+	linkFound, dsFound := false, false
+	for _, f := range r.faults {
+		switch {
+		case f.Link != "" && f.Map == mapName && f.Link == linkName:
+			if !linkFound || laterFault(f, linkFault) {
+				linkFault, linkFound = f, true
+			}
+		case f.Link == "" && dsName != "" && f.DataSource == dsName:
+			if !dsFound || laterFault(f, dsFault) {
+				dsFault, dsFound = f, true
+			}
+		}
+	}
+	if linkFound {
+		return linkFault, true
+	}
+	return dsFault, dsFound
+}
+
+// laterFault reports whether a expires after b, or has the higher id when both expire together
+func laterFault(a, b SimulatedFault) bool {
+	if !a.ExpiresAt.Equal(b.ExpiresAt) {
+		return a.ExpiresAt.After(b.ExpiresAt)
+	}
+	// fault-10 follows fault-9
+	return len(a.ID) > len(b.ID) || len(a.ID) == len(b.ID) && a.ID > b.ID
+}
+
+func (r *faultRegistry) pruneLocked() {
+	now := time.Now()
+	for id, f := range r.faults {
+		if now.After(f.ExpiresAt) {
+			delete(r.faults, id)
+		}
+	}
+}
+
+func (s *DataSourceService) SimulateFault(fault SimulatedFault, duration time.Duration) (SimulatedFault, error) {
+	fault, err := s.faults.add(fault, duration)
+	if err == nil {
+		s.updates.notify()
+	}
+	return fault, err
+}
+
+func (s *DataSourceService) ListFaults() []SimulatedFault {
+	return s.faults.list()
+}
+
+// ClearFault removes a single fault by id, or every fault when id is empty
+func (s *DataSourceService) ClearFault(id string) error {
+	err := s.faults.remove(id)
+	if err == nil {
+		s.updates.notify()
+	}
+	return err
+}
+
+func (s *DataSourceService) applyFault(mapName string, link config.Link, linkData *config.LinkData) {
+	fault, ok := s.faults.find(mapName, link.Name, link.DataSource)
+	if !ok {
+		return
+	}
+	linkData.Status = fault.State
+	if fault.State == FaultStateDown {
+		linkData.Utilization = 0
+		linkData.CommitUtilization = nil
+		linkData.Metrics = nil
+		return
+	}
+	if fault.Utilization != nil {
+		linkData.Utilization = *fault.Utilization
+		rate := *fault.Utilization / 100 * float64(linkBandwidth(link, *linkData))
+		linkData.CommitUtilization = commitUtilization(link, rate)
+	}
+}
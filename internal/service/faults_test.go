@@ -0,0 +1,37 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFaultRegistryFindLatest(t *testing.T) {
+	registry := newFaultRegistry()
+	add := func(fault SimulatedFault, duration time.Duration) SimulatedFault {
+		added, err := registry.add(fault, duration)
+		if err != nil {
+			t.Fatalf("Failed to add fault: %v", err)
+		}
+		return added
+	}
+	add(SimulatedFault{DataSource: "core", State: FaultStateDown}, time.Hour)
+	latest := add(SimulatedFault{DataSource: "core", State: FaultStateDegraded}, 2*time.Hour)
+	add(SimulatedFault{DataSource: "core", State: FaultStateDown}, time.Minute)
+
+	for i := 0; i < 20; i++ {
+		if fault, ok := registry.find("map", "link", "core"); !ok || fault.ID != latest.ID {
+			t.Fatalf("Expected the fault expiring last %s, got %+v", latest.ID, fault)
+		}
+	}
+
+	link := add(SimulatedFault{Map: "map", Link: "link", State: FaultStateDown}, time.Minute)
+	if fault, ok := registry.find("map", "link", "core"); !ok || fault.ID != link.ID {
+		t.Errorf("Expected the link fault to win over datasource faults, got %+v", fault)
+	}
+
+	a := SimulatedFault{ID: "fault-10", ExpiresAt: latest.ExpiresAt}
+	b := SimulatedFault{ID: "fault-9", ExpiresAt: latest.ExpiresAt}
+	if !laterFault(a, b) || laterFault(b, a) {
+		t.Errorf("Expected fault-10 to follow fault-9 when both expire together")
+	}
+}
@@ -0,0 +1,665 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/utils"
+)
+
+const (
+	historyDir = "history"
+	// historyMinInterval throttles recording, pollers refresh their caches more often
+	historyMinInterval     = 10 * time.Second
+	historyCompactInterval = time.Hour
+	historyDayLayout       = "2006-01-02"
+)
+
+// history tiers, each one rolled up from the previous one once a day is complete
+var historyTiers = []struct {
+	name       string
+	resolution time.Duration // 0 keeps the samples as recorded
+}{
+	{"raw", 0},
+	{"5m", 5 * time.Minute},
+	{"1h", time.Hour},
+}
+
+// HistoryRetention is how long each tier of the history is kept
+type HistoryRetention struct {
+	Raw        time.Duration
+	FiveMinute time.Duration
+	Hourly     time.Duration
+}
+
+func DefaultHistoryRetention() HistoryRetention {
+	return HistoryRetention{
+		Raw:        7 * 24 * time.Hour,
+		FiveMinute: 90 * 24 * time.Hour,
+		Hourly:     2 * 365 * 24 * time.Hour,
+	}
+}
+
+func (r HistoryRetention) of(tier string) time.Duration {
+	switch tier {
+	case "raw":
+		return r.Raw
+	case "5m":
+		return r.FiveMinute
+	}
+	return r.Hourly
+}
+
+// Validate requires 2 days in every tier: days are rolled up into the next tier only
+// once complete, so a shorter retention would delete them before.
+func (r HistoryRetention) Validate() error {
+	for _, tier := range historyTiers {
+		if r.of(tier.name) < 48*time.Hour {
+			return fmt.Errorf("retention of %s history must be at least 2d", tier.name)
+		}
+	}
+	if r.FiveMinute < r.Raw || r.Hourly < r.FiveMinute {
+		return fmt.Errorf("retention of rollups can't be shorter than of the history they are made of")
+	}
+	return nil
+}
+
+// HistoryRetentionFromEnv reads WEATHERMAP_HISTORY_RETENTION="raw=7d,5m=90d,1h=2y",
+// tiers left out keep their default retention and "off" disables the history
+func HistoryRetentionFromEnv() (retention HistoryRetention, ok bool, err error) {
+	retention = DefaultHistoryRetention()
+	value := strings.TrimSpace(os.Getenv("WEATHERMAP_HISTORY_RETENTION"))
+	if value == "off" {
+		return retention, false, nil
+	}
+	for _, item := range strings.Split(value, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		tier, period, found := strings.Cut(item, "=")
+		d, parseErr := parseRetention(strings.TrimSpace(period))
+		if !found || parseErr != nil {
+			return retention, false, fmt.Errorf("invalid WEATHERMAP_HISTORY_RETENTION: %s", item)
+		}
+		switch strings.TrimSpace(tier) {
+		case "raw":
+			retention.Raw = d
+		case "5m":
+			retention.FiveMinute = d
+		case "1h":
+			retention.Hourly = d
+		default:
+			return retention, false, fmt.Errorf("invalid WEATHERMAP_HISTORY_RETENTION: unknown tier '%s', must be raw, 5m or 1h", tier)
+		}
+	}
+	if err := retention.Validate(); err != nil {
+		return retention, false, err
+	}
+	return retention, true, nil
+}
+
+// parseRetention accepts Go durations and whole days (7d) or years of 365 days (2y)
+func parseRetention(value string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "y": 365 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(value, suffix); ok {
+			count, err := strconv.Atoi(n)
+			if err != nil || count < 0 {
+				return 0, fmt.Errorf("invalid retention: %s", value)
+			}
+			return time.Duration(count) * unit, nil
+		}
+	}
+	return time.ParseDuration(value)
+}
+
+// ParseHistoryRange reads the period of a graph of history, like 1h, 30m or 7d
+func ParseHistoryRange(value string) (time.Duration, error) {
+	period, err := parseRetention(value)
+	if err != nil || period <= 0 {
+		return 0, fmt.Errorf("invalid range: '%s', must be a positive duration like 1h, 30m or 7d", value)
+	}
+	return period, nil
+}
+
+func formatRetention(d time.Duration) string {
+	day := 24 * time.Hour
+	if d%day == 0 {
+		return fmt.Sprintf("%dd", d/day)
+	}
+	return d.String()
+}
+
+// HistorySample is the traffic of a link at a time, rollups average the samples of their
+// period and keep the highest utilization
+type HistorySample struct {
+	Time           time.Time `json:"time"`
+	Link           string    `json:"link"`
+	In             float64   `json:"in"`  // bytes/s
+	Out            float64   `json:"out"` // bytes/s
+	Utilization    float64   `json:"utilization"`
+	MaxUtilization float64   `json:"max_utilization,omitempty"` // rollups only
+	Samples        int       `json:"samples,omitempty"`         // rollups only: raw samples averaged
+}
+
+// historyStore keeps one JSON lines file per tier, map and day, so retention deletes whole files
+type historyStore struct {
+	dir       string
+	retention HistoryRetention
+
+	mu             sync.Mutex
+	lastCompaction time.Time
+	cache          baselineCache
+}
+
+func (h *historyStore) file(tier, mapName string, day time.Time) string {
+	return filepath.Join(h.dir, tier, mapName, day.UTC().Format(historyDayLayout)+".jsonl")
+}
+
+func (h *historyStore) append(mapName string, samples []HistorySample) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	byDay := make(map[string][]HistorySample)
+	for _, sample := range samples {
+		path := h.file("raw", mapName, sample.Time)
+		byDay[path] = append(byDay[path], sample)
+	}
+	for path, daySamples := range byDay {
+		if err := appendSamples(path, daySamples); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appendSamples(path string, samples []HistorySample) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, sample := range samples {
+		if err := enc.Encode(sample); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readSamples(path string) ([]HistorySample, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var samples []HistorySample
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var sample HistorySample
+		if err := json.Unmarshal(scanner.Bytes(), &sample); err != nil {
+			continue // a line cut by a crash
+		}
+		samples = append(samples, sample)
+	}
+	return samples, scanner.Err()
+}
+
+// days lists the day files of a tier by map
+func (h *historyStore) days(tier string) (map[string][]time.Time, error) {
+	mapDirs, err := os.ReadDir(filepath.Join(h.dir, tier))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string][]time.Time)
+	for _, mapDir := range mapDirs {
+		if !mapDir.IsDir() {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(h.dir, tier, mapDir.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			day, err := time.Parse(historyDayLayout, strings.TrimSuffix(file.Name(), ".jsonl"))
+			if err != nil || !strings.HasSuffix(file.Name(), ".jsonl") {
+				continue
+			}
+			result[mapDir.Name()] = append(result[mapDir.Name()], day)
+		}
+	}
+	return result, nil
+}
+
+// compact rolls the complete days of every tier up into the next one, then deletes the
+// days older than the retention of their tier
+func (h *historyStore) compact(now time.Time) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	today := now.UTC().Truncate(24 * time.Hour)
+	for i := 1; i < len(historyTiers); i++ {
+		source, target := historyTiers[i-1], historyTiers[i]
+		days, err := h.days(source.name)
+		if err != nil {
+			return err
+		}
+		for mapName, mapDays := range days {
+			for _, day := range mapDays {
+				targetFile := h.file(target.name, mapName, day)
+				if !day.Before(today) {
+					continue
+				}
+				if _, err := os.Stat(targetFile); err == nil {
+					continue
+				}
+				samples, err := readSamples(h.file(source.name, mapName, day))
+				if err != nil {
+					return err
+				}
+				if err := writeSamples(targetFile, rollup(samples, target.resolution)); err != nil {
+					return fmt.Errorf("rollup of %s %s: %w", mapName, day.Format(historyDayLayout), err)
+				}
+			}
+		}
+	}
+
+	for _, tier := range historyTiers {
+		cutoff := now.Add(-h.retention.of(tier.name))
+		days, err := h.days(tier.name)
+		if err != nil {
+			return err
+		}
+		for mapName, mapDays := range days {
+			for _, day := range mapDays {
+				if day.Add(24 * time.Hour).After(cutoff) {
+					continue
+				}
+				if err := os.Remove(h.file(tier.name, mapName, day)); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+			}
+			// only removed once empty
+			_ = os.Remove(filepath.Join(h.dir, tier.name, mapName))
+		}
+	}
+	h.lastCompaction = now
+	return nil
+}
+
+func writeSamples(path string, samples []HistorySample) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+	var data []byte
+	for _, sample := range samples {
+		line, err := json.Marshal(sample)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	return utils.WriteFileAtomic(path, data)
+}
+
+// rollup averages the samples of every link over periods of resolution, rollups of
+// rollups are weighted by their sample counts
+func rollup(samples []HistorySample, resolution time.Duration) []HistorySample {
+	type key struct {
+		link   string
+		period time.Time
+	}
+	sums := make(map[key]*HistorySample)
+	for _, sample := range samples {
+		k := key{sample.Link, sample.Time.UTC().Truncate(resolution)}
+		sum, ok := sums[k]
+		if !ok {
+			sum = &HistorySample{Time: k.period, Link: k.link}
+			sums[k] = sum
+		}
+		weight := max(sample.Samples, 1)
+		sum.In += sample.In * float64(weight)
+		sum.Out += sample.Out * float64(weight)
+		sum.Utilization += sample.Utilization * float64(weight)
+		sum.MaxUtilization = max(sum.MaxUtilization, sample.Utilization, sample.MaxUtilization)
+		sum.Samples += weight
+	}
+
+	result := make([]HistorySample, 0, len(sums))
+	for _, sum := range sums {
+		n := float64(sum.Samples)
+		sum.In, sum.Out, sum.Utilization = sum.In/n, sum.Out/n, sum.Utilization/n
+		result = append(result, *sum)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].Time.Equal(result[j].Time) {
+			return result[i].Time.Before(result[j].Time)
+		}
+		return result[i].Link < result[j].Link
+	})
+	return result
+}
+
+// historySamples are the links of the map carrying traffic, links without data are gaps
+func historySamples(data *config.MapWithData) []HistorySample {
+	samples := make([]HistorySample, 0, len(data.LinksData))
+	for _, link := range data.LinksData {
+		in, okIn := link.Metrics["in"].(int64)
+		out, okOut := link.Metrics["out"].(int64)
+		// flagged links carry traffic too, only their bandwidth is in doubt
+		if (link.Status != "up" && link.Status != LinkMisconfigured) || !okIn || !okOut {
+			continue
+		}
+		samples = append(samples, HistorySample{
+			Time:        data.ProcessedAt.UTC(),
+			Link:        link.Name,
+			In:          float64(in),
+			Out:         float64(out),
+			Utilization: link.Utilization,
+		})
+	}
+	return samples
+}
+
+// RecordHistory appends the current traffic of every link of every map to the history, and
+// to the recent history kept in memory
+func (s *MapService) RecordHistory(ctx context.Context, dsService *DataSourceService) error {
+	if s.history == nil && s.recent == nil {
+		return errHistoryDisabled
+	}
+	mapNames, err := s.ListMaps()
+	if err != nil {
+		return err
+	}
+	if s.recent != nil {
+		s.recent.forget(mapNames)
+	}
+	for _, mapName := range mapNames {
+		data, err := s.GetMapWithData(ctx, mapName, dsService)
+		if err != nil {
+			continue
+		}
+		samples := historySamples(data)
+		if s.recent != nil {
+			s.recent.record(mapName, samples)
+		}
+		if s.history == nil {
+			continue
+		}
+		if err := s.history.append(mapName, samples); err != nil {
+			return fmt.Errorf("map %s: %w", mapName, err)
+		}
+	}
+	return nil
+}
+
+// CompactHistory rolls up complete days and applies the retention
+func (s *MapService) CompactHistory(now time.Time) error {
+	if s.history == nil {
+		return errHistoryDisabled
+	}
+	return s.history.compact(now)
+}
+
+var errHistoryDisabled = errors.New("history is not enabled")
+
+// EnableHistory keeps history of the link traffic in the history directory of the config dir
+func (s *MapService) EnableHistory(retention HistoryRetention) {
+	s.history = &historyStore{dir: filepath.Join(s.configDir, historyDir), retention: retention}
+}
+
+// HistoryEnabled reports whether the traffic of the links is recorded
+func (s *MapService) HistoryEnabled() bool {
+	return s.history != nil
+}
+
+// EnableRecentHistory keeps the traffic of the last window of every link in memory
+func (s *MapService) EnableRecentHistory(window time.Duration) {
+	s.recent = newRecentHistory(window)
+}
+
+// WatchRecentHistory records the traffic after polls in memory until Stop, along with the
+// history files when WatchHistory runs too
+func (s *MapService) WatchRecentHistory(dsService *DataSourceService, window time.Duration) {
+	s.EnableRecentHistory(window)
+	s.watchRecording(dsService)
+}
+
+// WatchHistory records the traffic after polls and compacts the history every hour until Stop
+func (s *MapService) WatchHistory(dsService *DataSourceService, retention HistoryRetention) {
+	s.EnableHistory(retention)
+	s.watchRecording(dsService)
+	s.loops.run(func(ctx context.Context) {
+		ticker := time.NewTicker(historyCompactInterval)
+		defer ticker.Stop()
+		for {
+			if err := s.CompactHistory(time.Now()); err != nil {
+				s.logger.Error("history compaction failed", "error", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// watchRecording records the history after polls, once for WatchHistory and
+// WatchRecentHistory
+func (s *MapService) watchRecording(dsService *DataSourceService) {
+	s.recording.Do(func() {
+		s.loops.run(func(ctx context.Context) {
+			updates, cancel := dsService.SubscribeUpdates()
+			defer cancel()
+			var last time.Time
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-updates:
+				}
+				if time.Since(last) < historyMinInterval {
+					continue
+				}
+				last = time.Now()
+				if err := s.RecordHistory(ctx, dsService); err != nil {
+					s.logger.Error("history recording failed", "error", err)
+				}
+			}
+		})
+	})
+}
+
+// LinkTraffic is the traffic of a link over the last period, for a graph
+type LinkTraffic struct {
+	Map        string          `json:"map"`
+	Link       string          `json:"link"`
+	From       time.Time       `json:"from"`
+	To         time.Time       `json:"to"`
+	Resolution string          `json:"resolution"` // raw, or the tier the samples are consolidated in
+	Source     string          `json:"source"`     // memory or disk
+	Samples    []HistorySample `json:"samples"`
+}
+
+// RecentLinkTraffic returns the traffic of a link over the last period, from memory when the
+// recent history covers it, consolidated for periods longer than its window, else from the
+// history files
+func (s *MapService) RecentLinkTraffic(mapName, linkName string, period time.Duration) (*LinkTraffic, error) {
+	to := time.Now().UTC()
+	traffic := &LinkTraffic{Map: mapName, Link: linkName, From: to.Add(-period), To: to, Resolution: "raw"}
+	if s.recent != nil && period <= s.recent.retention() {
+		if err := s.checkLink(mapName, linkName); err != nil {
+			return nil, err
+		}
+		traffic.Source = "memory"
+		traffic.Resolution, traffic.Samples = s.recent.since(mapName, linkName, traffic.From, to)
+		return traffic, nil
+	}
+	if s.history == nil {
+		if s.recent != nil {
+			return nil, fmt.Errorf("invalid range: history is not enabled, only the last %s are kept in memory", formatRetention(s.recent.retention()))
+		}
+		return nil, errHistoryDisabled
+	}
+	tier, samples, err := s.linkHistory(mapName, linkName, traffic.From, to, "")
+	if err != nil {
+		return nil, err
+	}
+	traffic.Resolution, traffic.Source, traffic.Samples = tier, "disk", samples
+	return traffic, nil
+}
+
+func (s *MapService) checkLink(mapName, linkName string) error {
+	mapConfig, err := s.loadMapConfig(mapName)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(mapConfig.Links, func(link config.Link) bool { return link.Name == linkName }) {
+		return fmt.Errorf("link not found: %s", linkName)
+	}
+	return nil
+}
+
+// LinkHistory returns the samples of a link recorded from from until to, oldest first.
+// resolution selects the tier, raw, 5m or 1h, empty picks the finest one still keeping from.
+func (s *MapService) LinkHistory(mapName, linkName string, from, to time.Time, resolution string) ([]HistorySample, error) {
+	_, samples, err := s.linkHistory(mapName, linkName, from, to, resolution)
+	return samples, err
+}
+
+func (s *MapService) linkHistory(mapName, linkName string, from, to time.Time, resolution string) (string, []HistorySample, error) {
+	if s.history == nil {
+		return "", nil, errHistoryDisabled
+	}
+	if err := s.checkLink(mapName, linkName); err != nil {
+		return "", nil, err
+	}
+	tier, err := s.history.tierFor(resolution, from, time.Now())
+	if err != nil {
+		return "", nil, err
+	}
+
+	s.history.mu.Lock()
+	defer s.history.mu.Unlock()
+	samples := []HistorySample{}
+	for day := from.UTC().Truncate(24 * time.Hour); day.Before(to); day = day.Add(24 * time.Hour) {
+		daySamples, err := readSamples(s.history.file(tier, mapName, day))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		for _, sample := range daySamples {
+			if sample.Link == linkName && !sample.Time.Before(from) && sample.Time.Before(to) {
+				samples = append(samples, sample)
+			}
+		}
+	}
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+	return tier, samples, nil
+}
+
+func (h *historyStore) tierFor(resolution string, from, now time.Time) (string, error) {
+	for _, tier := range historyTiers {
+		if resolution == tier.name || (resolution == "" && !from.Before(now.Add(-h.retention.of(tier.name)))) {
+			return tier.name, nil
+		}
+	}
+	if resolution == "" {
+		return historyTiers[len(historyTiers)-1].name, nil
+	}
+	return "", fmt.Errorf("invalid resolution: '%s', must be raw, 5m or 1h", resolution)
+}
+
+type HistoryTierUsage struct {
+	Tier       string `json:"tier"`
+	Resolution string `json:"resolution"`
+	Retention  string `json:"retention"`
+	Files      int    `json:"files"`
+	Bytes      int64  `json:"bytes"`
+	Oldest     string `json:"oldest,omitempty"` // first day kept
+	Newest     string `json:"newest,omitempty"`
+}
+
+// HistoryUsage is the storage used by the history
+type HistoryUsage struct {
+	Tiers          []HistoryTierUsage `json:"tiers"`
+	Bytes          int64              `json:"bytes"`
+	LastCompaction *time.Time         `json:"last_compaction,omitempty"`
+}
+
+func (s *MapService) HistoryUsage() (HistoryUsage, error) {
+	if s.history == nil {
+		return HistoryUsage{}, errHistoryDisabled
+	}
+	h := s.history
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	usage := HistoryUsage{Tiers: make([]HistoryTierUsage, 0, len(historyTiers))}
+	if !h.lastCompaction.IsZero() {
+		last := h.lastCompaction
+		usage.LastCompaction = &last
+	}
+	for _, tier := range historyTiers {
+		tierUsage := HistoryTierUsage{
+			Tier:       tier.name,
+			Resolution: "as polled",
+			Retention:  formatRetention(h.retention.of(tier.name)),
+		}
+		if tier.resolution > 0 {
+			tierUsage.Resolution = tier.resolution.String()
+		}
+		days, err := h.days(tier.name)
+		if err != nil {
+			return usage, err
+		}
+		for mapName, mapDays := range days {
+			for _, day := range mapDays {
+				info, err := os.Stat(h.file(tier.name, mapName, day))
+				if err != nil {
+					continue
+				}
+				tierUsage.Files++
+				tierUsage.Bytes += info.Size()
+				name := day.Format(historyDayLayout)
+				if tierUsage.Oldest == "" || name < tierUsage.Oldest {
+					tierUsage.Oldest = name
+				}
+				if name > tierUsage.Newest {
+					tierUsage.Newest = name
+				}
+			}
+		}
+		usage.Bytes += tierUsage.Bytes
+		usage.Tiers = append(usage.Tiers, tierUsage)
+	}
+	return usage, nil
+}
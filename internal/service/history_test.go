@@ -0,0 +1,186 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-weathermap/internal/config"
+)
+
+func TestHistoryCompaction(t *testing.T) {
+	configDir := t.TempDir()
+	s := NewMapService(configDir)
+	s.EnableHistory(HistoryRetention{Raw: 2 * 24 * time.Hour, FiveMinute: 4 * 24 * time.Hour, Hourly: 10 * 24 * time.Hour})
+
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	var samples []HistorySample
+	for day := 0; day < 6; day++ {
+		start := now.Add(-time.Duration(day) * 24 * time.Hour).Truncate(24 * time.Hour).Add(time.Hour)
+		for i, utilization := range []float64{10, 30, 20} {
+			samples = append(samples, HistorySample{
+				Time: start.Add(time.Duration(i) * time.Minute), Link: "a-b",
+				In: utilization * 1000, Out: 100, Utilization: utilization,
+			})
+		}
+	}
+	if err := s.history.append("core", samples); err != nil {
+		t.Fatalf("Failed to record history: %v", err)
+	}
+	if err := s.CompactHistory(now); err != nil {
+		t.Fatalf("Failed to compact history: %v", err)
+	}
+
+	exists := func(tier, day string) bool {
+		_, err := os.Stat(filepath.Join(configDir, "history", tier, "core", day+".jsonl"))
+		return err == nil
+	}
+	for _, c := range []struct {
+		tier, day string
+		kept      bool
+	}{
+		{"raw", "2025-03-10", true},
+		{"raw", "2025-03-09", true},
+		{"raw", "2025-03-08", true}, // days are kept until their end is past the retention
+		{"raw", "2025-03-07", false},
+		{"5m", "2025-03-10", false}, // today isn't complete
+		{"5m", "2025-03-07", true},
+		{"5m", "2025-03-05", false},
+		{"1h", "2025-03-05", true},
+	} {
+		if exists(c.tier, c.day) != c.kept {
+			t.Errorf("Expected %s history of %s kept=%v", c.tier, c.day, c.kept)
+		}
+	}
+
+	rollups, err := readSamples(filepath.Join(configDir, "history", "1h", "core", "2025-03-05.jsonl"))
+	if err != nil || len(rollups) != 1 {
+		t.Fatalf("Expected one hourly rollup, got %v %v", rollups, err)
+	}
+	if r := rollups[0]; r.Utilization != 20 || r.MaxUtilization != 30 || r.In != 20000 || r.Samples != 3 || !r.Time.Equal(time.Date(2025, 3, 5, 1, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected averaged rollup of 3 samples, got %+v", r)
+	}
+
+	usage, err := s.HistoryUsage()
+	if err != nil || len(usage.Tiers) != 3 || usage.Bytes == 0 || usage.LastCompaction == nil {
+		t.Fatalf("Expected usage of 3 tiers, got %+v %v", usage, err)
+	}
+	if raw := usage.Tiers[0]; raw.Files != 3 || raw.Oldest != "2025-03-08" || raw.Newest != "2025-03-10" || raw.Retention != "2d" {
+		t.Errorf("Expected 3 raw days, got %+v", raw)
+	}
+
+	if maps, _ := s.ListMaps(); len(maps) != 0 {
+		t.Errorf("Expected history not listed as maps, got %v", maps)
+	}
+}
+
+func TestHistoryRetentionFromEnv(t *testing.T) {
+	t.Setenv("WEATHERMAP_HISTORY_RETENTION", "")
+	retention, ok, err := HistoryRetentionFromEnv()
+	if err != nil || !ok || retention != DefaultHistoryRetention() {
+		t.Fatalf("Expected default retention, got %+v %v %v", retention, ok, err)
+	}
+
+	t.Setenv("WEATHERMAP_HISTORY_RETENTION", "raw=3d, 1h=1y")
+	retention, ok, err = HistoryRetentionFromEnv()
+	if err != nil || !ok || retention.Raw != 72*time.Hour || retention.FiveMinute != 90*24*time.Hour || retention.Hourly != 365*24*time.Hour {
+		t.Errorf("Expected raw and hourly overridden, got %+v %v", retention, err)
+	}
+
+	t.Setenv("WEATHERMAP_HISTORY_RETENTION", "off")
+	if _, ok, err := HistoryRetentionFromEnv(); ok || err != nil {
+		t.Errorf("Expected history disabled, got %v %v", ok, err)
+	}
+
+	for _, value := range []string{"raw=12h", "raw=100d", "1m=7d", "raw"} {
+		t.Setenv("WEATHERMAP_HISTORY_RETENTION", value)
+		if _, _, err := HistoryRetentionFromEnv(); err == nil {
+			t.Errorf("Expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestRecentHistory(t *testing.T) {
+	recent := newRecentHistory(time.Minute)
+	start := time.Date(2025, 3, 24, 12, 0, 0, 0, time.UTC)
+	var now time.Time
+	for i := range 3*360 + 1 {
+		now = start.Add(time.Duration(i) * historyMinInterval)
+		utilization := 10.0
+		if i == 100 { // 12:16:40
+			utilization = 90
+		}
+		recent.record("core", []HistorySample{{Time: now, Link: "a-b", In: 1000, Utilization: utilization}})
+	}
+
+	tier, samples := recent.since("core", "a-b", now.Add(-30*time.Second), now)
+	if tier != "raw" || len(samples) != 4 || !samples[3].Time.Equal(now) || samples[3].Samples != 0 {
+		t.Errorf("Expected the last 4 samples as recorded, got %s %+v", tier, samples)
+	}
+	tier, samples = recent.since("core", "a-b", start, now)
+	if tier != "1m" || len(samples) != 180 {
+		t.Fatalf("Expected 180 minutes, got %s with %d samples", tier, len(samples))
+	}
+	if spike := samples[16]; spike.Utilization != 23.3 || spike.MaxUtilization != 90 || spike.Samples != 6 || spike.In != 1000 {
+		t.Errorf("Expected the minute of the spike averaged with its max kept, got %+v", spike)
+	}
+	tier, samples = recent.since("core", "a-b", now.Add(-48*time.Hour), now)
+	if tier != "1h" || len(samples) != 2 {
+		t.Fatalf("Expected the 2 complete hours, got %s %+v", tier, samples)
+	}
+	if hour := samples[0]; !hour.Time.Equal(start) || hour.Utilization != 10.2 || hour.MaxUtilization != 90 || hour.Samples != 360 {
+		t.Errorf("Expected the first hour consolidated from its minutes, got %+v", hour)
+	}
+	if _, samples := recent.since("core", "c-d", start, now); samples == nil || len(samples) != 0 {
+		t.Errorf("Expected no samples of an unknown link, got %v", samples)
+	}
+
+	if hours := recent.links["core"]["a-b"].tiers[1].ring.points; cap(hours) >= 31*24 {
+		t.Errorf("Expected the ring of hours to grow with its points, got capacity %d for %d points", cap(hours), len(hours))
+	}
+
+	recent.forget([]string{"edge"})
+	if _, samples := recent.since("core", "a-b", start, now); len(samples) != 0 {
+		t.Errorf("Expected the samples of a deleted map dropped, got %d", len(samples))
+	}
+}
+
+func TestAnomalyDetection(t *testing.T) {
+	s := NewMapService(t.TempDir())
+	s.EnableHistory(DefaultHistoryRetention())
+
+	now := time.Date(2025, 3, 24, 12, 0, 0, 0, time.UTC)
+	for week, utilizations := range [][]float64{{30, 34}, {28, 32}, {29, 31}} {
+		at := now.Add(-time.Duration(week+1) * 7 * 24 * time.Hour)
+		samples := []HistorySample{
+			{Time: at.Add(-10 * time.Minute), Link: "a-b", Utilization: utilizations[0]},
+			{Time: at.Add(5 * time.Minute), Link: "a-b", Utilization: utilizations[1]},
+			{Time: at.Add(2 * time.Hour), Link: "a-b", Utilization: 95}, // outside the window
+		}
+		if week == 0 {
+			samples = append(samples, HistorySample{Time: at, Link: "c-d", Utilization: 5})
+		}
+		if err := writeSamples(s.history.file("5m", "core", at), samples); err != nil {
+			t.Fatalf("Failed to write history: %v", err)
+		}
+	}
+
+	links := []config.LinkData{
+		{Name: "a-b", Status: "up", Utilization: 90},
+		{Name: "c-d", Status: "up", Utilization: 90},
+	}
+	s.history.flagAnomalies("core", config.AnomalyDetection{}, links, now)
+	if ab := links[0]; !ab.Anomalous || ab.Baseline == nil || *ab.Baseline != 30.7 || ab.AnomalyScore == nil || *ab.AnomalyScore < 3 {
+		t.Errorf("Expected a-b anomalous against a baseline of 30.7, got %+v", ab)
+	}
+	if cd := links[1]; cd.Anomalous || cd.Baseline != nil {
+		t.Errorf("Expected no baseline for c-d with a single week of history, got %+v", cd)
+	}
+
+	links = []config.LinkData{{Name: "a-b", Status: "up", Utilization: 38}}
+	s.history.flagAnomalies("core", config.AnomalyDetection{}, links, now.Add(time.Minute))
+	if ab := links[0]; ab.Anomalous || ab.AnomalyScore == nil || *ab.AnomalyScore < 3 {
+		t.Errorf("Expected a-b within min_deviation not anomalous despite its score, got %+v", ab)
+	}
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// IconMetadataFile of the icons directory gives the display name, category and tags of
+	// its icons and of the embedded ones, by file name
+	IconMetadataFile = "icons.yaml"
+
+	maxIconFile = maxBundleIcon // bytes of an uploaded icon
+)
+
+// IconMetadata is the entry of an icon in IconMetadataFile, empty fields fall back to the
+// defaults of ListIcons
+type IconMetadata struct {
+	DisplayName string   `yaml:"display_name,omitempty"`
+	Category    string   `yaml:"category,omitempty"`
+	Tags        []string `yaml:"tags,omitempty"`
+}
+
+// iconCategories are the categories of icons missing from IconMetadataFile, by their name
+// without extension
+var iconCategories = map[string][]string{
+	"network": {
+		"router", "switch", "firewall", "loadbalancer", "load_balancer", "lb",
+		"gw", "gateway", "core", "access", "distribution", "edge", "border",
+		"ix", "directconnect", "pni",
+	},
+	"servers":   {"server", "database", "storage", "nas", "san", "dns", "dhcp", "ntp"},
+	"cloud":     {"cloud", "aws", "azure", "gcp", "digitalocean"},
+	"endpoints": {"pc", "laptop", "phone", "tablet", "mobile", "desktop", "workstation", "monitor"},
+}
+
+func defaultIconCategory(name string) string {
+	for category, names := range iconCategories {
+		if slices.Contains(names, name) {
+			return category
+		}
+	}
+	return "other"
+}
+
+// iconMetadata reads IconMetadataFile, a missing file describes no icon
+func (s *MapService) iconMetadata() (map[string]IconMetadata, error) {
+	return readIconMetadata(s.iconsDir)
+}
+
+// listedIconMetadata adds the metadata of the server icons below the one of a sandbox
+func (s *MapService) listedIconMetadata() (map[string]IconMetadata, error) {
+	metadata, err := s.iconMetadata()
+	if err != nil || s.baseIconsDir == "" {
+		return metadata, err
+	}
+	base, err := readIconMetadata(s.baseIconsDir)
+	if err != nil {
+		return nil, err
+	}
+	maps.Copy(base, metadata)
+	return base, nil
+}
+
+func readIconMetadata(dir string) (map[string]IconMetadata, error) {
+	data, err := os.ReadFile(filepath.Join(dir, IconMetadataFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]IconMetadata{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", IconMetadataFile, err)
+	}
+	metadata := make(map[string]IconMetadata)
+	if err := yaml.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", IconMetadataFile, err)
+	}
+	return metadata, nil
+}
+
+// UploadIcon adds an icon to the icons directory, replacing an icon of the same name only
+// when replace is set. Non-empty fields of metadata are written to IconMetadataFile.
+func (s *MapService) UploadIcon(name string, data []byte, metadata IconMetadata, replace bool) error {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") || !IsIconFile(name) {
+		return fmt.Errorf("invalid icon name: %q, must be an svg, png, jpg, jpeg or webp file name", name)
+	}
+	if len(data) > maxIconFile {
+		return fmt.Errorf("invalid icon: larger than %d bytes", maxIconFile)
+	}
+	if err := checkIconContent(name, data); err != nil {
+		return err
+	}
+
+	s.iconsMu.Lock()
+	defer s.iconsMu.Unlock()
+	iconPath := filepath.Join(s.iconsDir, name)
+	if s.iconExists(name) && !replace {
+		return fmt.Errorf("icon %s already exists, upload it with replace to overwrite it", name)
+	}
+	all, err := s.iconMetadata()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.iconsDir, 0755); err != nil {
+		return fmt.Errorf("failed to create icons directory: %w", err)
+	}
+	if err := s.quota.write(iconPath, data); err != nil {
+		return fmt.Errorf("failed to write icon %s: %w", name, err)
+	}
+	if metadata.DisplayName == "" && metadata.Category == "" && len(metadata.Tags) == 0 {
+		return nil
+	}
+	all[name] = metadata
+	content, err := yaml.Marshal(all)
+	if err != nil {
+		return err
+	}
+	if err := s.quota.write(filepath.Join(s.iconsDir, IconMetadataFile), content); err != nil {
+		return fmt.Errorf("failed to write %s: %w", IconMetadataFile, err)
+	}
+	return nil
+}
+
+// iconExists reports whether the icons directory has the icon, or the server icons a
+// sandbox reads
+func (s *MapService) iconExists(name string) bool {
+	for _, dir := range []string{s.iconsDir, s.baseIconsDir} {
+		if _, err := os.Stat(filepath.Join(dir, name)); dir != "" && err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIconContent refuses files whose content isn't the image their extension names
+func checkIconContent(name string, data []byte) error {
+	if strings.EqualFold(filepath.Ext(name), ".svg") {
+		if !bytes.Contains(data, []byte("<svg")) {
+			return fmt.Errorf("invalid icon: %s is not an svg image", name)
+		}
+		return nil
+	}
+	switch http.DetectContentType(data) {
+	case "image/png", "image/jpeg", "image/webp":
+		return nil
+	}
+	return fmt.Errorf("invalid icon: %s is not a png, jpeg or webp image", name)
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/utils"
+)
+
+const (
+	ObjectNode = "node"
+	ObjectLink = "link"
+)
+
+// assignIDs gives the map, its nodes and links a new ULID where missing and reports whether any was added
+func assignIDs(m *config.Map) bool {
+	added := false
+	assign := func(id *string) {
+		if *id == "" {
+			*id = utils.NewULID()
+			added = true
+		}
+	}
+	assign(&m.ID)
+	for i := range m.Nodes {
+		assign(&m.Nodes[i].ID)
+	}
+	for i := range m.Links {
+		assign(&m.Links[i].ID)
+	}
+	return added
+}
+
+// keepIDs copies the ids of the previous version of a map to the objects of the same name
+// that come without one, so pushing a map without ids doesn't change them
+func keepIDs(m, previous *config.Map) {
+	if m.ID == "" {
+		m.ID = previous.ID
+	}
+	nodeIDs := make(map[string]string, len(previous.Nodes))
+	for _, node := range previous.Nodes {
+		nodeIDs[node.Name] = node.ID
+	}
+	for i, node := range m.Nodes {
+		if node.ID == "" {
+			m.Nodes[i].ID = nodeIDs[node.Name]
+		}
+	}
+	linkIDs := make(map[string]string, len(previous.Links))
+	for _, link := range previous.Links {
+		linkIDs[link.Name] = link.ID
+	}
+	for i, link := range m.Links {
+		if link.ID == "" {
+			m.Links[i].ID = linkIDs[link.Name]
+		}
+	}
+}
+
+// AssignMissingIDs writes ids to the maps stored without them, for example edited by hand,
+// and returns the maps written. It runs at startup and on reload: reads never write, the ids
+// of such a map are empty until then or until the map is saved. A map which can't be written
+// is skipped with a warning.
+func (s *MapService) AssignMissingIDs() []string {
+	names, err := s.ListMaps()
+	if err != nil {
+		s.logger.Warn("failed to list maps for ids", "error", err)
+		return nil
+	}
+	var written []string
+	for _, name := range names {
+		ok, err := s.assignMissingIDs(name)
+		if err != nil {
+			s.logger.Warn("failed to save generated ids", "map", name, "error", err)
+		}
+		if ok {
+			written = append(written, name)
+		}
+	}
+	return written
+}
+
+// assignMissingIDs reads the file of a map under writeMu, like saveMap, so an edit saved at
+// the same time is neither lost nor given other ids
+func (s *MapService) assignMissingIDs(mapName string) (bool, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	configPath := filepath.Join(s.configDir, mapName+".yaml")
+	file, err := os.Open(configPath)
+	if err != nil {
+		return false, nil // removed since listed
+	}
+	mapConfig, err := s.parser.ParseYAML(file)
+	_ = file.Close()
+	if err != nil || !assignIDs(mapConfig) {
+		return false, nil // invalid maps are reported by the config validation
+	}
+	data, err := s.marshalMap(mapConfig)
+	if err == nil {
+		err = s.quota.write(configPath, data)
+	}
+	if err != nil {
+		return false, err
+	}
+	// the same map, a reload doesn't report it as changed
+	s.files.mu.Lock()
+	if _, ok := s.files.hashes[mapName]; ok {
+		s.files.hashes[mapName] = contentHash(data)
+	}
+	s.files.mu.Unlock()
+	s.changes.notify(mapName)
+	return true, nil
+}
+
+// ResolveMapName returns the name of the map referenced by name or id, unknown
+// references are returned as is
+func (s *MapService) ResolveMapName(ref string) (string, error) {
+	if _, err := os.Stat(filepath.Join(s.configDir, filepath.Base(ref)+".yaml")); err == nil || !utils.IsULID(ref) {
+		return ref, nil
+	}
+	names, err := s.ListMaps()
+	if err != nil {
+		return "", err
+	}
+	for _, name := range names {
+		if m, err := s.loadMapConfig(name); err == nil && m.ID == ref {
+			return name, nil
+		}
+	}
+	return ref, nil
+}
+
+// ResolveObjectName returns the name of the node or link of a map referenced by name or id,
+// unknown references are returned as is
+func (s *MapService) ResolveObjectName(mapName, kind, ref string) (string, error) {
+	mapConfig, err := s.loadMapConfig(mapName)
+	if err != nil {
+		return "", err
+	}
+	switch kind {
+	case ObjectNode:
+		for _, node := range mapConfig.Nodes {
+			if node.Name == ref {
+				return ref, nil
+			}
+		}
+		for _, node := range mapConfig.Nodes {
+			if node.ID == ref {
+				return node.Name, nil
+			}
+		}
+	case ObjectLink:
+		for _, link := range mapConfig.Links {
+			if link.Name == ref {
+				return ref, nil
+			}
+		}
+		for _, link := range mapConfig.Links {
+			if link.ID == ref {
+				return link.Name, nil
+			}
+		}
+	}
+	return ref, nil
+}
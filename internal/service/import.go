@@ -0,0 +1,78 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"go-weathermap/internal/config"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ExportFormatYAML is the map file, as in the maps directory
+const ExportFormatYAML = "yaml"
+
+// ImportResult is what ImportMap did, or would do for a dry run
+type ImportResult struct {
+	Map     string   `json:"map"`
+	Created bool     `json:"created"` // false when an existing map is replaced
+	DryRun  bool     `json:"dry_run"`
+	Nodes   int      `json:"nodes"`
+	Links   int      `json:"links"`
+	Icons   []string `json:"icons_added,omitempty"` // icons of a bundle the server lacked
+}
+
+// ValidMapName refuses names which would write outside the maps directory
+func ValidMapName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid map name: %q", name)
+	}
+	return nil
+}
+
+// ExportYAML writes a map in the YAML of the maps directory, without the values of its
+// defaults. Secret variables, datasource params and receiver credentials are masked like in
+// the other API responses, importing the export over the map keeps them.
+func (s *MapService) ExportYAML(mapName string) ([]byte, error) {
+	m, err := s.loadMapConfig(mapName)
+	if err != nil {
+		return nil, err
+	}
+	exported := *m.WithoutDefaults()
+	if exported.Variables != nil {
+		exported.Variables = config.Variables(exported.Variables.Masked())
+	}
+	exported.Receivers = slices.Clone(exported.Receivers)
+	for i, receiver := range exported.Receivers {
+		exported.Receivers[i] = receiver.Masked()
+	}
+	exported.Datasources = slices.Clone(exported.Datasources)
+	for i, ds := range exported.Datasources {
+		exported.Datasources[i] = ds.Masked()
+	}
+	return yaml.Marshal(&exported)
+}
+
+// ImportMap adds a map from the YAML of a map file, or replaces it when replace is set, with
+// the validation of ReplaceMap. A dry run validates the map the same way and writes nothing.
+func (s *MapService) ImportMap(mapName string, content []byte, replace, dryRun bool) (*ImportResult, error) {
+	if err := ValidMapName(mapName); err != nil {
+		return nil, err
+	}
+	m, err := config.NewParser().ParseYAML(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("invalid YAML: %w", err)
+	}
+	if _, err := os.Stat(filepath.Join(s.configDir, mapName+".yaml")); err == nil && !replace {
+		return nil, fmt.Errorf("map %s already exists, import it with replace to overwrite it", mapName)
+	}
+	created, err := s.replaceMap(mapName, m, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	return &ImportResult{Map: mapName, Created: created, DryRun: dryRun, Nodes: len(m.Nodes), Links: len(m.Links)}, nil
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"go-weathermap/internal/utils"
+)
+
+const (
+	infoURLTimeout     = 5 * time.Second
+	maxInfoURLChecks   = 8 // concurrent requests
+	InfoURLStatusOK    = "ok"
+	InfoURLStatusError = "failed"
+	InfoURLUnchecked   = "unchecked"
+)
+
+// InfoURLCheck is the last check of the info_url of a node or link
+type InfoURLCheck struct {
+	Type       string     `json:"type"` // node or link
+	Name       string     `json:"name"`
+	URL        string     `json:"url"`
+	Status     string     `json:"status"` // ok, failed or unchecked
+	StatusCode int        `json:"status_code,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	CheckedAt  *time.Time `json:"checked_at,omitempty"`
+}
+
+type urlCheckResult struct {
+	statusCode int
+	err        error
+	checkedAt  time.Time
+}
+
+// infoURLChecks keeps the result of the last check of every info_url
+type infoURLChecks struct {
+	mu      sync.Mutex
+	results map[string]urlCheckResult
+	client  *http.Client
+}
+
+// InfoURLCheckIntervalFromEnv reads WEATHERMAP_URL_CHECK_INTERVAL, checks are off unless it is set
+func InfoURLCheckIntervalFromEnv() (time.Duration, error) {
+	value := os.Getenv("WEATHERMAP_URL_CHECK_INTERVAL")
+	if value == "" {
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < 0 {
+		return 0, fmt.Errorf("invalid WEATHERMAP_URL_CHECK_INTERVAL: %s", value)
+	}
+	return interval, nil
+}
+
+// CheckInfoURLs requests every info_url of all maps once, a URL is healthy when its
+// host resolves and it answers with a status below 400
+func (s *MapService) CheckInfoURLs(ctx context.Context) error {
+	if s.offline {
+		return nil // sandbox maps don't make the server request their URLs
+	}
+	mapNames, err := s.ListMaps()
+	if err != nil {
+		return err
+	}
+	urls := make(map[string]bool)
+	for _, mapName := range mapNames {
+		mapConfig, err := s.loadMapConfig(mapName)
+		if err != nil {
+			continue
+		}
+		for _, node := range mapConfig.Nodes {
+			if node.InfoURL != "" {
+				urls[node.InfoURL] = true
+			}
+		}
+		for _, link := range mapConfig.Links {
+			if link.InfoURL != "" {
+				urls[link.InfoURL] = true
+			}
+		}
+	}
+
+	workers := utils.NewSemaphore(maxInfoURLChecks)
+	var wg sync.WaitGroup
+	for url := range urls {
+		if err := workers.Acquire(ctx); err != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer workers.Release()
+			code, err := s.urlChecks.check(ctx, url)
+			s.urlChecks.mu.Lock()
+			s.urlChecks.results[url] = urlCheckResult{statusCode: code, err: err, checkedAt: time.Now()}
+			s.urlChecks.mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+func (c *infoURLChecks) check(ctx context.Context, url string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, infoURLTimeout)
+	defer cancel()
+	code, err := c.request(ctx, http.MethodHead, url)
+	if err == nil && (code == http.StatusMethodNotAllowed || code == http.StatusNotImplemented) {
+		code, err = c.request(ctx, http.MethodGet, url)
+	}
+	if err == nil && code >= http.StatusBadRequest {
+		err = fmt.Errorf("HTTP %d", code)
+	}
+	return code, err
+}
+
+func (c *infoURLChecks) request(ctx context.Context, method, url string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// InfoURLStatus lists the nodes and links of a map with an info_url and their last check
+func (s *MapService) InfoURLStatus(mapName string) ([]InfoURLCheck, error) {
+	mapConfig, err := s.loadMapConfig(mapName)
+	if err != nil {
+		return nil, err
+	}
+	checks := []InfoURLCheck{}
+	s.urlChecks.mu.Lock()
+	defer s.urlChecks.mu.Unlock()
+	add := func(kind, name, url string) {
+		if url == "" {
+			return
+		}
+		check := InfoURLCheck{Type: kind, Name: name, URL: url, Status: InfoURLUnchecked}
+		if result, ok := s.urlChecks.results[url]; ok {
+			check.Status, check.StatusCode, check.CheckedAt = InfoURLStatusOK, result.statusCode, &result.checkedAt
+			if result.err != nil {
+				check.Status, check.Error = InfoURLStatusError, result.err.Error()
+			}
+		}
+		checks = append(checks, check)
+	}
+	for _, node := range mapConfig.Nodes {
+		add("node", node.Name, node.InfoURL)
+	}
+	for _, link := range mapConfig.Links {
+		add("link", link.Name, link.InfoURL)
+	}
+	return checks, nil
+}
+
+// WatchInfoURLs checks info URLs now and then every interval until Stop
+func (s *MapService) WatchInfoURLs(interval time.Duration) {
+	s.loops.run(func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			_ = s.CheckInfoURLs(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
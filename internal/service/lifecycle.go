@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// pollLoops tracks the goroutines of a poller. Loops get a context cancelled by Stop,
+// they must return once it is done, a poll already sent to a device is allowed to finish.
+// A poller can't be restarted after Stop.
+type pollLoops struct {
+	once   sync.Once
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func (l *pollLoops) init() {
+	l.once.Do(func() {
+		l.ctx, l.cancel = context.WithCancel(context.Background())
+	})
+}
+
+func (l *pollLoops) run(loop func(ctx context.Context)) {
+	l.init()
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		loop(l.ctx)
+	}()
+}
+
+// Stop cancels the loops and waits for them to return or ctx to expire
+func (l *pollLoops) Stop(ctx context.Context) error {
+	l.init()
+	l.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		l.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("polls still running: %w", ctx.Err())
+	}
+}
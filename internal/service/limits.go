@@ -0,0 +1,119 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"time"
+
+	"go-weathermap/internal/datasource"
+	"go-weathermap/internal/utils"
+)
+
+// DefaultMaxPollerWorkers caps concurrent polls of a single poller
+const DefaultMaxPollerWorkers = 64
+
+// ResourceLimits bounds sockets and concurrency used by pollers,
+// so large deployments don't exhaust file descriptors
+type ResourceLimits struct {
+	MaxSNMPSessions  int                       `json:"max_snmp_sessions"`
+	MaxPollerWorkers int                       `json:"max_poller_workers"`
+	HTTP             datasource.HTTPPoolConfig `json:"http_pool"`
+}
+
+func DefaultResourceLimits() ResourceLimits {
+	return ResourceLimits{
+		MaxSNMPSessions:  datasource.DefaultMaxSNMPSessions,
+		MaxPollerWorkers: DefaultMaxPollerWorkers,
+		HTTP:             datasource.DefaultHTTPPoolConfig(),
+	}
+}
+
+func (l ResourceLimits) Validate() error {
+	if l.MaxSNMPSessions < 1 {
+		return fmt.Errorf("max_snmp_sessions must be at least 1")
+	}
+	if l.MaxPollerWorkers < 1 {
+		return fmt.Errorf("max_poller_workers must be at least 1")
+	}
+	return l.HTTP.Validate()
+}
+
+// ResourceLimitsFromEnv returns the defaults overridden by WEATHERMAP_* environment variables
+func ResourceLimitsFromEnv() (ResourceLimits, error) {
+	limits := DefaultResourceLimits()
+	ints := map[string]*int{
+		"WEATHERMAP_MAX_SNMP_SESSIONS":            &limits.MaxSNMPSessions,
+		"WEATHERMAP_MAX_POLLER_WORKERS":           &limits.MaxPollerWorkers,
+		"WEATHERMAP_HTTP_MAX_IDLE_CONNS":          &limits.HTTP.MaxIdleConns,
+		"WEATHERMAP_HTTP_MAX_IDLE_CONNS_PER_HOST": &limits.HTTP.MaxIdleConnsPerHost,
+		"WEATHERMAP_HTTP_MAX_CONNS_PER_HOST":      &limits.HTTP.MaxConnsPerHost,
+	}
+	for name, target := range ints {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return limits, fmt.Errorf("invalid %s: %s", name, value)
+		}
+		*target = n
+	}
+	durations := map[string]*time.Duration{
+		"WEATHERMAP_HTTP_IDLE_CONN_TIMEOUT": &limits.HTTP.IdleConnTimeout,
+		"WEATHERMAP_HTTP_TIMEOUT":           &limits.HTTP.Timeout,
+	}
+	for name, target := range durations {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return limits, fmt.Errorf("invalid %s: %s", name, value)
+		}
+		*target = d
+	}
+	return limits, limits.Validate()
+}
+
+type PollerUsage struct {
+	Type    string               `json:"type"`
+	Tasks   int                  `json:"tasks"`
+	Workers utils.SemaphoreUsage `json:"workers"`
+	Queued  int64                `json:"queued"` // polls due and waiting for a worker
+}
+
+// ResourceUsage is the runtime view of ResourceLimits
+type ResourceUsage struct {
+	Limits       ResourceLimits       `json:"limits"`
+	SNMPSessions utils.SemaphoreUsage `json:"snmp_sessions"`
+	Pollers      []PollerUsage        `json:"pollers"`
+	Goroutines   int                  `json:"goroutines"`
+}
+
+// workerReporter is implemented by pollers which bound their concurrent polls
+type workerReporter interface {
+	WorkerUsage() PollerUsage
+}
+
+func (s *DataSourceService) ResourceUsage() ResourceUsage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pollers := make([]PollerUsage, 0, len(s.pollers))
+	for _, p := range s.pollers {
+		if reporter, ok := p.(workerReporter); ok {
+			pollers = append(pollers, reporter.WorkerUsage())
+		}
+	}
+	sort.Slice(pollers, func(i, j int) bool { return pollers[i].Type < pollers[j].Type })
+	return ResourceUsage{
+		Limits:       s.limits,
+		SNMPSessions: datasource.GetGlobalSNMPClient().SessionUsage(),
+		Pollers:      pollers,
+		Goroutines:   runtime.NumGoroutine(),
+	}
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	"go-weathermap/internal/snmpsim"
+)
+
+func TestResourceLimitsFromEnv(t *testing.T) {
+	t.Setenv("WEATHERMAP_MAX_SNMP_SESSIONS", "16")
+	t.Setenv("WEATHERMAP_HTTP_TIMEOUT", "30s")
+
+	limits, err := ResourceLimitsFromEnv()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if limits.MaxSNMPSessions != 16 || limits.HTTP.Timeout != 30*time.Second {
+		t.Errorf("Expected overridden limits, got %+v", limits)
+	}
+	if limits.MaxPollerWorkers != DefaultMaxPollerWorkers {
+		t.Errorf("Expected default poller workers, got %d", limits.MaxPollerWorkers)
+	}
+
+	t.Setenv("WEATHERMAP_MAX_POLLER_WORKERS", "0")
+	if _, err := ResourceLimitsFromEnv(); err == nil {
+		t.Error("Expected error for zero poller workers")
+	}
+}
+
+func TestSNMPPollerWorkerLimit(t *testing.T) {
+	sim := newSimulator(t)
+	ds := simDataSource(sim, "public")
+
+	limits := DefaultResourceLimits()
+	limits.MaxPollerWorkers = 1
+	dsService := NewDataSourceServiceWithLimits(nil, limits)
+	poller := CreatePoller(SNMPPollerType, limits).(*SNMPPoller)
+	poller.AddTask(ds, ds.Interfaces[0], "in", time.Second)
+	dsService.pollers[SNMPPollerType] = poller
+
+	usage := dsService.ResourceUsage()
+	if len(usage.Pollers) != 1 || usage.Pollers[0].Tasks != 1 || usage.Pollers[0].Workers.Max != 1 {
+		t.Errorf("Expected one snmp poller with a single worker, got %+v", usage.Pollers)
+	}
+}
+
+func TestSNMPPollerSchedulerBoundsGoroutines(t *testing.T) {
+	limits := DefaultResourceLimits()
+	limits.MaxPollerWorkers = 2
+	poller := CreatePoller(SNMPPollerType, limits).(*SNMPPoller)
+	defer func() { _ = poller.Stop(t.Context()) }()
+
+	sims := make([]*snmpsim.Server, 20)
+	for i := range sims {
+		sim := newSimulator(t)
+		setOctets(sim, ifInOctets1, 125_000)
+		ds := simDataSource(sim, "public")
+		ds.Name = fmt.Sprintf("router-%d", i)
+		poller.AddTask(ds, ds.Interfaces[0], "in", 100*time.Millisecond)
+		sims[i] = sim
+	}
+	before := runtime.NumGoroutine()
+	poller.Start()
+
+	// the scheduler and at most a goroutine by worker, not one by device
+	peak := 0
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		peak = max(peak, runtime.NumGoroutine()-before)
+		time.Sleep(5 * time.Millisecond)
+	}
+	if peak > 1+limits.MaxPollerWorkers+2 {
+		t.Errorf("Expected goroutines bounded by the workers, got %d more", peak)
+	}
+	for i, sim := range sims {
+		if sim.Requests() < 2 {
+			t.Errorf("Expected device %d polled on schedule, got %d requests", i, sim.Requests())
+		}
+	}
+}
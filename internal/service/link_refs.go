@@ -0,0 +1,107 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"go-weathermap/internal/config"
+)
+
+// Modes of checking that links and nodes reference defined datasources and interfaces when a map is saved
+const (
+	LinkRefsOff     = "off"
+	LinkRefsWarn    = "warn"    // the map is saved, unknown references are logged
+	LinkRefsEnforce = "enforce" // the map is refused, unless it sets external_datasources
+)
+
+// LinkRefsModeFromEnv reads WEATHERMAP_LINK_REF_VALIDATION, warn by default
+func LinkRefsModeFromEnv() (string, error) {
+	value := strings.ToLower(strings.TrimSpace(os.Getenv("WEATHERMAP_LINK_REF_VALIDATION")))
+	switch value {
+	case "":
+		return LinkRefsWarn, nil
+	case LinkRefsOff, LinkRefsWarn, LinkRefsEnforce:
+		return value, nil
+	}
+	return "", fmt.Errorf("invalid WEATHERMAP_LINK_REF_VALIDATION: %s, must be off, warn or enforce", value)
+}
+
+// SetLinkRefsMode replaces the LinkRefsWarn default
+func (s *MapService) SetLinkRefsMode(mode string) {
+	s.linkRefs = mode
+}
+
+// linkRefErrors lists the links and nodes of m whose datasource or interface isn't defined,
+// neither by m nor by another map of the directory
+func linkRefErrors(m *config.Map, shared map[string]config.DataSourceConfig) []error {
+	var errs []error
+	check := func(kind, name, dsName, ifaceName string) {
+		if dsName == "" {
+			return
+		}
+		ds, ok := shared[dsName]
+		if i := slices.IndexFunc(m.Datasources, func(ds config.DataSourceConfig) bool { return ds.Name == dsName }); i >= 0 {
+			ds, ok = m.Datasources[i], true
+		}
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Errorf("%s '%s' references unknown datasource: %s", kind, name, dsName))
+		case ifaceName != "" && !slices.ContainsFunc(ds.Interfaces, func(iface config.InterfaceConfig) bool { return iface.Name == ifaceName }):
+			errs = append(errs, fmt.Errorf("%s '%s' references unknown interface %s of datasource %s", kind, name, ifaceName, dsName))
+		}
+	}
+	for _, link := range m.Links {
+		check("link", link.Name, link.DataSource, link.Interface)
+	}
+	for _, node := range m.Nodes {
+		check("node", node.Name, node.DataSource, node.Interface)
+	}
+	return errs
+}
+
+// checkLinkRefs applies the link reference mode to a map about to be saved
+func (s *MapService) checkLinkRefs(mapName string, m *config.Map) error {
+	if s.linkRefs == LinkRefsOff || len(m.Links) == 0 && len(m.Nodes) == 0 {
+		return nil
+	}
+	errs := linkRefErrors(m, s.sharedDataSources(mapName))
+	if len(errs) == 0 {
+		return nil
+	}
+	if s.linkRefs == LinkRefsEnforce && !m.ExternalDatasources {
+		return errors.Join(errs...)
+	}
+	for _, err := range errs {
+		s.logger.Warn("unresolved link reference", "map", mapName, "error", err)
+	}
+	return nil
+}
+
+// sharedDataSources returns the datasources defined by the other maps of the directory,
+// broken maps are left out, they are reported by the config validation
+func (s *MapService) sharedDataSources(mapName string) map[string]config.DataSourceConfig {
+	shared := make(map[string]config.DataSourceConfig)
+	files, _ := filepath.Glob(filepath.Join(s.configDir, "*.yaml"))
+	for _, path := range files {
+		if filepath.Base(path) == mapName+".yaml" {
+			continue
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		m, err := s.parser.ParseYAML(bytes.NewReader(content))
+		if err != nil {
+			continue
+		}
+		for _, ds := range m.Datasources {
+			shared[ds.Name] = ds
+		}
+	}
+	return shared
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/utils"
+)
+
+// SpeedMetric is the interface metric links with bandwidth auto read, in Mbit/s like ifHighSpeed
+const SpeedMetric = "speed"
+
+// mockSpeed is the speed of the interfaces of mock datasources, in Mbit/s
+const mockSpeed = 1000
+
+const ifHighSpeedOID = "1.3.6.1.2.1.31.1.1.1.15."
+
+// octet counters of IF-MIB, their last arc is the ifIndex of the interface
+var ifOctetsOIDs = []string{
+	"1.3.6.1.2.1.2.2.1.10.",    // ifInOctets
+	"1.3.6.1.2.1.2.2.1.16.",    // ifOutOctets
+	"1.3.6.1.2.1.31.1.1.1.6.",  // ifHCInOctets
+	"1.3.6.1.2.1.31.1.1.1.10.", // ifHCOutOctets
+}
+
+// snmpOID returns the OID of a metric of an interface. Without a speed OID the speed is
+// read from the ifHighSpeed of the interface its octet counters are polled from.
+func snmpOID(iface config.InterfaceConfig, metricName string) (string, bool) {
+	oids, ok := iface.Params["oids"].(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	if oid, ok := oids[metricName].(string); ok {
+		return oid, true
+	}
+	if metricName != SpeedMetric {
+		return "", false
+	}
+	for _, value := range oids {
+		oid, _ := value.(string)
+		for _, prefix := range ifOctetsOIDs {
+			if ifIndex, ok := strings.CutPrefix(strings.TrimPrefix(oid, "."), prefix); ok && ifIndex != "" && !strings.Contains(ifIndex, ".") {
+				return ifHighSpeedOID + ifIndex, true
+			}
+		}
+	}
+	return "", false
+}
+
+// formatSpeed writes a speed in Mbit/s in the bandwidth format of links
+func formatSpeed(mbps int64) string {
+	switch {
+	case mbps%1_000_000 == 0:
+		return fmt.Sprintf("%dT", mbps/1_000_000)
+	case mbps%1_000 == 0:
+		return fmt.Sprintf("%dG", mbps/1_000)
+	}
+	return fmt.Sprintf("%dM", mbps)
+}
+
+// linkBandwidth returns the bandwidth of a link in bytes/s, for links with bandwidth auto the
+// detected one and 0 until it is known
+func linkBandwidth(link config.Link, data config.LinkData) int64 {
+	if link.Bandwidth != config.BandwidthAuto {
+		return utils.ParseBandwidth(link.Bandwidth)
+	}
+	if data.DetectedBandwidth == "" {
+		return 0
+	}
+	return utils.ParseBandwidth(data.DetectedBandwidth)
+}
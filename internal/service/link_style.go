@@ -0,0 +1,121 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/expr"
+	"go-weathermap/internal/render"
+)
+
+var dashArrayRegex = regexp.MustCompile(`^\d+(\.\d+)?([ ,]+\d+(\.\d+)?)*$`)
+
+// applyLinkStyles sets the style computed by the link_style rules of m on every link, rules
+// failing for a link leave its property to the scale and report the error in the style
+func applyLinkStyles(m *config.Map, linksData []config.LinkData) {
+	rules := m.LinkStyle
+	if rules == nil {
+		return
+	}
+	// the map was validated when loaded, a rule not compiling anyway is reported on every link
+	color, colorErr := compileRule(rules.Color)
+	width, widthErr := compileRule(rules.Width)
+	dash, dashErr := compileRule(rules.Dash)
+
+	for i, link := range m.Links {
+		vars := linkStyleVars(link, linksData[i])
+		style := &config.LinkStyle{}
+		errs := []error{colorErr, widthErr, dashErr}
+		if v, err := evalRule(color, vars); err != nil {
+			errs = append(errs, fmt.Errorf("color: %w", err))
+		} else if s, ok := v.(string); ok && s != "" {
+			if _, err := config.ParseHexColor(s); err != nil {
+				errs = append(errs, fmt.Errorf("color: %w", err))
+			} else {
+				style.Color = s
+			}
+		} else if v != nil && !ok {
+			errs = append(errs, fmt.Errorf("color: got a %T, want a string", v))
+		}
+		if v, err := evalRule(width, vars); err != nil {
+			errs = append(errs, fmt.Errorf("width: %w", err))
+		} else if n, ok := v.(float64); ok && n >= 0 {
+			style.Width = int(math.Round(n))
+		} else if v != nil {
+			errs = append(errs, fmt.Errorf("width: got %v, want a number of pixels", v))
+		}
+		if v, err := evalRule(dash, vars); err != nil {
+			errs = append(errs, fmt.Errorf("dash: %w", err))
+		} else if s, ok := v.(string); ok && (s == "" || dashArrayRegex.MatchString(s)) {
+			style.Dash = s
+		} else if n, ok := v.(float64); ok && n >= 0 {
+			if n > 0 {
+				style.Dash = fmt.Sprintf("%g", n)
+			}
+		} else if v != nil {
+			errs = append(errs, fmt.Errorf("dash: got %v, want a dash array like \"6,4\"", v))
+		}
+		if err := errors.Join(errs...); err != nil {
+			style.Error = err.Error()
+		}
+		if *style != (config.LinkStyle{}) {
+			linksData[i].Style = style
+		}
+	}
+}
+
+// applyLinkColors sets the color every link is drawn with, so clients get the one of the
+// renderers instead of implementing the scales again
+func applyLinkColors(m *config.Map, linksData []config.LinkData) {
+	for i, link := range m.Links {
+		linksData[i].Color = render.HexColor(render.LinkColor(m, link, linksData[i]))
+	}
+}
+
+func compileRule(source string) (*expr.Program, error) {
+	if source == "" {
+		return nil, nil
+	}
+	return expr.Compile(source)
+}
+
+func evalRule(program *expr.Program, vars map[string]any) (any, error) {
+	if program == nil {
+		return nil, nil
+	}
+	return program.Eval(vars)
+}
+
+// linkStyleVars are the variables of the link_style rules
+func linkStyleVars(link config.Link, data config.LinkData) map[string]any {
+	metrics := make(map[string]any, len(data.Metrics))
+	for name, value := range data.Metrics {
+		metrics[name] = value
+	}
+	var commit any
+	if data.CommitUtilization != nil {
+		commit = *data.CommitUtilization
+	}
+	return map[string]any{
+		"utilization":        data.Utilization,
+		"commit_utilization": commit,
+		"status":             data.Status,
+		"anomalous":          data.Anomalous,
+		"in":                 metrics["in"],
+		"out":                metrics["out"],
+		"bandwidth":          float64(linkBandwidth(link, data)),
+		"metrics":            metrics,
+		"link": map[string]any{
+			"name":       link.Name,
+			"from":       link.From,
+			"to":         link.To,
+			"datasource": link.DataSource,
+			"interface":  link.Interface,
+			"scale":      link.Scale,
+			"width":      link.Width,
+		},
+	}
+}
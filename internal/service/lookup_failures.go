@@ -0,0 +1,127 @@
+package service
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// lookupFailureTTL is how long a failed datasource or interface lookup is answered from the
+// cache before it is resolved again
+const lookupFailureTTL = 30 * time.Second
+
+// LookupError is returned for links referencing a datasource, interface or poller type that
+// doesn't exist. It is a configuration problem, polling again won't fix it.
+type LookupError struct {
+	msg string
+}
+
+func (e *LookupError) Error() string {
+	return e.msg
+}
+
+// IsLookupError reports whether err comes from a misconfigured link
+func IsLookupError(err error) bool {
+	var lookupErr *LookupError
+	return errors.As(err, &lookupErr)
+}
+
+// Misconfiguration is a datasource and interface pair links asked for and which couldn't be resolved
+type Misconfiguration struct {
+	Datasource string    `json:"datasource"`
+	Interface  string    `json:"interface"`
+	Error      string    `json:"error"`
+	Count      int64     `json:"count"` // lookups failed since first_seen
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen"`
+}
+
+type lookupFailure struct {
+	err      *LookupError
+	report   Misconfiguration
+	resolved time.Time // last time the lookup was resolved rather than answered from the cache
+}
+
+// lookupFailures is the negative cache of GetInterfaceMetrics, so a misconfigured link costs
+// one lookup and one log line per lookupFailureTTL rather than one per request
+type lookupFailures struct {
+	mu       sync.Mutex
+	failures map[[2]string]*lookupFailure // datasource, interface
+	now      func() time.Time
+}
+
+func newLookupFailures() *lookupFailures {
+	return &lookupFailures{failures: make(map[[2]string]*lookupFailure), now: time.Now}
+}
+
+// cached returns the failure of a lookup resolved less than lookupFailureTTL ago
+func (c *lookupFailures) cached(dsName, ifaceName string) (*LookupError, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	failure, ok := c.failures[[2]string{dsName, ifaceName}]
+	now := c.now()
+	if !ok || now.Sub(failure.resolved) >= lookupFailureTTL {
+		return nil, false
+	}
+	failure.report.Count++
+	failure.report.LastSeen = now
+	return failure.err, true
+}
+
+// record keeps the result of a resolved lookup, err is nil when it succeeded. It reports
+// whether the failure is new or different from the last one, and so worth logging.
+func (c *lookupFailures) record(dsName, ifaceName string, err *LookupError) bool {
+	key := [2]string{dsName, ifaceName}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	failure, ok := c.failures[key]
+	if err == nil {
+		delete(c.failures, key)
+		return false
+	}
+	now := c.now()
+	if ok && failure.err.msg == err.msg {
+		failure.err, failure.resolved = err, now
+		failure.report.Count++
+		failure.report.LastSeen = now
+		return false
+	}
+	c.failures[key] = &lookupFailure{
+		err:      err,
+		resolved: now,
+		report: Misconfiguration{
+			Datasource: dsName, Interface: ifaceName, Error: err.msg,
+			Count: 1, FirstSeen: now, LastSeen: now,
+		},
+	}
+	return true
+}
+
+// reset forgets every failure, the datasources they were resolved against have changed
+func (c *lookupFailures) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.failures)
+}
+
+func (c *lookupFailures) list() []Misconfiguration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	list := make([]Misconfiguration, 0, len(c.failures))
+	for _, failure := range c.failures {
+		list = append(list, failure.report)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Datasource != list[j].Datasource {
+			return list[i].Datasource < list[j].Datasource
+		}
+		return list[i].Interface < list[j].Interface
+	})
+	return list
+}
+
+// Misconfigurations lists the datasource and interface lookups of links currently failing
+func (s *DataSourceService) Misconfigurations() []Misconfiguration {
+	return s.lookups.list()
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go-weathermap/internal/config"
+)
+
+func TestLookupFailuresCache(t *testing.T) {
+	dsService := NewDataSourceService([]config.DataSourceConfig{{Name: "lab", Type: "mock", Interfaces: []config.InterfaceConfig{{Name: "eth0"}}}})
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	dsService.lookups.now = func() time.Time { return now }
+
+	for range 3 {
+		_, err := dsService.GetInterfaceMetrics(context.Background(), "lab", "eth9", []string{"in"})
+		if !IsLookupError(err) {
+			t.Fatalf("Expected a lookup error, got %v", err)
+		}
+	}
+	if _, err := dsService.GetInterfaceMetrics(context.Background(), "core", "eth0", []string{"in"}); !IsLookupError(err) {
+		t.Fatalf("Expected a lookup error, got %v", err)
+	}
+	report := dsService.Misconfigurations()
+	if len(report) != 2 || report[0].Datasource != "core" || report[1].Interface != "eth9" || report[1].Count != 3 {
+		t.Fatalf("Unexpected misconfigurations: %+v", report)
+	}
+
+	// the interface added is seen once the failure expires
+	if _, err := dsService.Reload([]config.DataSourceConfig{{Name: "lab", Type: "mock", Interfaces: []config.InterfaceConfig{{Name: "eth0"}, {Name: "eth9"}}}}); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if report := dsService.Misconfigurations(); len(report) != 0 {
+		t.Errorf("Expected reload to clear the failures, got %+v", report)
+	}
+	if _, err := dsService.GetInterfaceMetrics(context.Background(), "core", "eth0", []string{"in"}); !IsLookupError(err) {
+		t.Fatalf("Expected a lookup error, got %v", err)
+	}
+	dsService.lookups.failures[[2]string{"lab", "eth9"}] = &lookupFailure{err: &LookupError{"interface not found: eth9"}, resolved: now}
+	if _, err := dsService.GetInterfaceMetrics(context.Background(), "lab", "eth9", []string{"in"}); !IsLookupError(err) {
+		t.Errorf("Expected the cached failure within the TTL, got %v", err)
+	}
+	now = now.Add(lookupFailureTTL)
+	if _, err := dsService.GetInterfaceMetrics(context.Background(), "lab", "eth9", []string{"in"}); err != nil {
+		t.Errorf("Expected the lookup to be resolved again after the TTL, got %v", err)
+	}
+	if report := dsService.Misconfigurations(); len(report) != 1 || report[0].Datasource != "core" {
+		t.Errorf("Expected only core to be left, got %+v", report)
+	}
+}
@@ -2,35 +2,223 @@ package service
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io/fs"
+	"log/slog"
+	"maps"
 	"math"
+	"mime"
+	"net"
+	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"slices"
 	"strings"
+	"sync"
 	"time"
 
+	"go-weathermap/internal/assets"
 	"go-weathermap/internal/config"
+	"go-weathermap/internal/datasource"
+	"go-weathermap/internal/tracing"
 	"go-weathermap/internal/utils"
 
 	"gopkg.in/yaml.v3"
 )
 
+const (
+	// DefaultMapDeadline bounds reading the link metrics of a map, slower links are left unknown
+	DefaultMapDeadline = 3 * time.Second
+	mapFetchWorkers    = 16
+)
+
+var (
+	// ErrNotFound is wrapped by the errors of maps, nodes and links which don't exist
+	ErrNotFound = errors.New("not found")
+	// ErrExists is wrapped by the errors of nodes and links added under a name already taken
+	ErrExists = errors.New("already exists")
+	// ErrInvalid matches the errors of changes a map can't take, like a node out of its
+	// bounds, and every ValidationError
+	ErrInvalid = errors.New("invalid")
+	// ErrLimit is wrapped by the errors of writes refused by a limit of the server
+	ErrLimit = errors.New("exceed limit")
+)
+
+// ValidationError rejects a map which is invalid after a change, nothing is saved
+type ValidationError struct {
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return "validation failed before saving: " + e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrInvalid
+}
+
+// invalidError is a change the map can't take, it matches ErrInvalid
+type invalidError struct {
+	msg string
+}
+
+func (e *invalidError) Error() string {
+	return e.msg
+}
+
+func (e *invalidError) Is(target error) bool {
+	return target == ErrInvalid
+}
+
+// invalidf returns an error matching ErrInvalid with the formatted message
+func invalidf(format string, args ...any) error {
+	return &invalidError{fmt.Sprintf(format, args...)}
+}
+
 type MapService struct {
-	configDir string
-	iconsDir  string
-	parser    *config.Parser
+	configDir  string
+	iconsDir   string
+	parser     *config.Parser
+	changes    *mapBroadcaster
+	lookupAddr func(ctx context.Context, addr string) ([]string, error)
+	urlChecks  *infoURLChecks
+	loops      pollLoops
+	writeMu    sync.Mutex // serializes writing map files, an edit validates against the version it replaces
+	access     accessCache
+	auditMu    sync.Mutex
+	iconsMu    sync.Mutex     // serializes uploads of icons, which rewrite IconMetadataFile
+	history    *historyStore  // nil until EnableHistory
+	recent     *recentHistory // nil until EnableRecentHistory
+	recording  sync.Once      // of the history, by WatchHistory or WatchRecentHistory
+	calendars  *calendars
+	sandboxes  sandboxes
+	files      mapFiles
+	deadline   time.Duration // of reading the link metrics of a map, 0 waits for all
+	linkRefs   string        // LinkRefsOff, LinkRefsWarn or LinkRefsEnforce
+	nodeStatus *nodeStatus
+	alerts     *alerting
+	overUtil   *overUtilizations        // links seen above 100% utilization
+	cipher     *config.VariableCipher   // of secret variables at rest, nil stores them in plaintext
+	netbox     *datasource.NetBoxClient // nil until EnableNetBox
+	logger     *slog.Logger
+
+	// sandboxes read the icons of baseIconsDir below their own, fetch no calendar or info
+	// URL and write their files through their quota
+	baseIconsDir string
+	offline      bool
+	quota        *sandboxQuota
 }
 
+// NewMapService serves the maps of configDir. Without a directory it only processes the maps
+// given to ProcessMap.
 func NewMapService(configDir string) *MapService {
 	absConfigDir, _ := filepath.Abs(configDir)
 	iconsDir := filepath.Join(filepath.Dir(absConfigDir), "internal", "assets", "icons")
 
+	var hashes map[string]string
+	if configDir != "" {
+		hashes, _ = mapFileHashes(configDir)
+	}
 	return &MapService{
-		configDir: configDir,
-		iconsDir:  iconsDir,
-		parser:    config.NewParser(),
+		configDir:  configDir,
+		iconsDir:   iconsDir,
+		parser:     config.NewParser(),
+		changes:    newMapBroadcaster(),
+		lookupAddr: net.DefaultResolver.LookupAddr,
+		urlChecks: &infoURLChecks{
+			results: make(map[string]urlCheckResult),
+			client:  &http.Client{Timeout: infoURLTimeout},
+		},
+		calendars: &calendars{
+			entries: make(map[string]calendarEntry),
+			client:  &http.Client{Timeout: calendarTimeout},
+		},
+		files:      mapFiles{hashes: hashes},
+		deadline:   DefaultMapDeadline,
+		linkRefs:   LinkRefsWarn,
+		nodeStatus: newNodeStatus(),
+		alerts:     newAlerting(),
+		overUtil:   newOverUtilizations(),
+		logger:     slog.Default(),
+	}
+}
+
+// MapDeadlineFromEnv reads WEATHERMAP_MAP_DEADLINE, 0 waits for every link
+func MapDeadlineFromEnv() (time.Duration, error) {
+	value := strings.TrimSpace(os.Getenv("WEATHERMAP_MAP_DEADLINE"))
+	if value == "" {
+		return DefaultMapDeadline, nil
+	}
+	deadline, err := time.ParseDuration(value)
+	if err != nil || deadline < 0 {
+		return 0, fmt.Errorf("invalid WEATHERMAP_MAP_DEADLINE: %s", value)
+	}
+	return deadline, nil
+}
+
+// SecretPatternsFromEnv reads WEATHERMAP_SECRET_VARIABLES, comma separated glob patterns of
+// the names of map variables masked in API responses, config.DefaultSecretPatterns when unset
+func SecretPatternsFromEnv() ([]string, error) {
+	value := strings.TrimSpace(os.Getenv("WEATHERMAP_SECRET_VARIABLES"))
+	if value == "" {
+		return config.DefaultSecretPatterns, nil
+	}
+	var patterns []string
+	for _, pattern := range strings.Split(value, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return nil, fmt.Errorf("invalid WEATHERMAP_SECRET_VARIABLES: %s", value)
+		}
+		patterns = append(patterns, pattern)
 	}
+	return patterns, nil
+}
+
+// VariablesCipherFromEnv reads WEATHERMAP_VARIABLES_KEY, the base64 of a 32 byte key secret
+// map variables are encrypted with in the map files, nil when unset
+func VariablesCipherFromEnv() (*config.VariableCipher, error) {
+	value := strings.TrimSpace(os.Getenv("WEATHERMAP_VARIABLES_KEY"))
+	if value == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WEATHERMAP_VARIABLES_KEY: not base64")
+	}
+	cipher, err := config.NewVariableCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WEATHERMAP_VARIABLES_KEY: %w", err)
+	}
+	return cipher, nil
+}
+
+// SetVariablesCipher encrypts the secret variables of the maps saved from now on, and
+// decrypts the ones read. Maps with secrets in plaintext are read as they are.
+func (s *MapService) SetVariablesCipher(cipher *config.VariableCipher) {
+	s.cipher = cipher
+}
+
+// SetMapDeadline replaces DefaultMapDeadline
+func (s *MapService) SetMapDeadline(deadline time.Duration) {
+	s.deadline = deadline
+}
+
+// SetIconsDir replaces the directory of node icons, internal/assets/icons next to the config
+// directory by default. Its icons override the ones embedded in the binary.
+func (s *MapService) SetIconsDir(dir string) {
+	s.iconsDir = dir
+}
+
+// SetLogger replaces the logger of the service and its background jobs
+func (s *MapService) SetLogger(logger *slog.Logger) {
+	s.logger = logger
 }
 
 func (s *MapService) ListMaps() ([]string, error) {
@@ -53,76 +241,203 @@ func (s *MapService) GetMap(name string) (*config.Map, error) {
 	return s.loadMapConfig(name)
 }
 
-func (s *MapService) GetMapWithData(name string, dsService *DataSourceService) (*config.MapWithData, error) {
+func (s *MapService) GetMapWithData(ctx context.Context, name string, dsService *DataSourceService) (*config.MapWithData, error) {
+	ctx, span := tracing.Start(ctx, "MapService.GetMapWithData", "map", name)
+	defer span.End()
 	mapConfig, err := s.loadMapConfig(name)
 	if err != nil {
+		span.RecordError(err)
 		return nil, err
 	}
-	linksData := make([]config.LinkData, 0, len(mapConfig.Links))
-	for _, link := range mapConfig.Links {
-		linkData := config.LinkData{
-			Name:   link.Name,
-			Status: "unknown",
-		}
-
-		if dsService != nil && link.DataSource != "" && link.Interface != "" && len(link.Metrics) > 0 {
-			fmt.Printf("[MAP DEBUG] link=%s ds=%s iface=%s metrics=%v\n", link.Name, link.DataSource, link.Interface, link.Metrics)
+	span.SetAttributes("links", len(mapConfig.Links))
+	mapWithData := s.ProcessMap(ctx, name, mapConfig, dsService)
+	if mapWithData.Partial {
+		span.SetAttributes("partial", true, "pending_links", len(mapWithData.PendingLinks))
+	}
+	return mapWithData, nil
+}
 
-			metrics, err := dsService.GetInterfaceMetrics(
-				context.Background(), link.DataSource, link.Interface, link.Metrics)
+// ProcessMap reads the metrics of the links and nodes of a map, which doesn't have to be one
+// of the config directory, and computes their data like GetMapWithData
+func (s *MapService) ProcessMap(ctx context.Context, name string, mapConfig *config.Map, dsService *DataSourceService) *config.MapWithData {
+	linksData, pending := s.gatherLinksData(ctx, name, mapConfig.Links, dsService)
+	if dsService != nil {
+		for i, link := range mapConfig.Links {
+			dsService.applyFault(name, link, &linksData[i])
+		}
+	}
+	mapWithData := &config.MapWithData{
+		Map:          mapConfig,
+		ProcessedAt:  time.Now(),
+		LinksData:    linksData,
+		NodesData:    s.nodesData(ctx, name, mapConfig, dsService),
+		Partial:      len(pending) > 0,
+		PendingLinks: pending,
+	}
+	if mapWithData.Partial {
+		s.logger.Warn("map deadline exceeded, links left unknown", "map", name, "deadline", s.deadline, "pending_links", len(pending))
+	}
+	s.applyUtilizationPolicy(name, mapConfig, linksData)
+	if mapConfig.AnomalyDetection != nil && s.history != nil {
+		s.history.flagAnomalies(name, *mapConfig.AnomalyDetection, linksData, mapWithData.ProcessedAt)
+	}
+	applyLinkStyles(mapConfig, linksData)
+	applyLinkColors(mapConfig, linksData)
+	if len(mapConfig.Demands) > 0 {
+		mapWithData.PlannedData = PlanLoad(mapConfig).Links
+	}
+	return mapWithData
+}
 
-			fmt.Printf("[MAP DEBUG] metrics result: %v err: %v\n", metrics, err)
+// gatherLinksData reads the metrics of the links by a few workers. Links without data by the
+// deadline of the map stay unknown and are returned as pending, their reads are abandoned.
+func (s *MapService) gatherLinksData(ctx context.Context, name string, links []config.Link, dsService *DataSourceService) ([]config.LinkData, []string) {
+	linksData := make([]config.LinkData, len(links))
+	done := make([]bool, len(links))
+	for i, link := range links {
+		linksData[i] = config.LinkData{Name: link.Name, Status: "unknown"}
+		done[i] = dsService == nil || link.DataSource == "" || link.Interface == "" || len(link.Metrics) == 0
+	}
+	var todo []int
+	for i := range links {
+		if !done[i] {
+			todo = append(todo, i)
+		}
+	}
+	if len(todo) == 0 {
+		return linksData, nil
+	}
 
-			if err == nil {
-				linkData.Status = "up"
-				linkData.Metrics = metrics
+	if s.deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.deadline)
+		defer cancel()
+	}
+	type result struct {
+		index int
+		data  config.LinkData
+	}
+	indexes := make(chan int, len(todo))
+	for _, i := range todo {
+		indexes <- i
+	}
+	close(indexes)
+	// buffered for every link, so abandoned workers don't block
+	results := make(chan result, len(todo))
+	for range min(len(todo), mapFetchWorkers) {
+		go func() {
+			for i := range indexes {
+				if ctx.Err() != nil {
+					return
+				}
+				results <- result{i, s.linkData(ctx, name, links[i], dsService)}
+			}
+		}()
+	}
 
-				if inVal, okIn := metrics["in"].(int64); okIn {
-					if outVal, okOut := metrics["out"].(int64); okOut {
-						bw := utils.ParseBandwidth(link.Bandwidth)
-						if bw > 0 {
-							utilization := float64(max(inVal, outVal)) / float64(bw) * 100
-							linkData.Utilization = math.Round(utilization*10) / 10
-						}
-					}
+	for remaining := len(todo); remaining > 0; remaining-- {
+		select {
+		case r := <-results:
+			linksData[r.index], done[r.index] = r.data, true
+		case <-ctx.Done():
+			var pending []string
+			for _, i := range todo {
+				if !done[i] {
+					pending = append(pending, links[i].Name)
 				}
-			} else {
-				linkData.Status = "down"
-				fmt.Printf("[ERROR] Failed to get metrics for link %s: %v\n", link.Name, err)
 			}
+			return linksData, pending
+		}
+	}
+	return linksData, nil
+}
+
+func (s *MapService) linkData(ctx context.Context, name string, link config.Link, dsService *DataSourceService) config.LinkData {
+	linkData := config.LinkData{Name: link.Name, Status: "unknown"}
+	names := link.Metrics
+	if link.Bandwidth == config.BandwidthAuto && !slices.Contains(names, SpeedMetric) {
+		names = append(slices.Clone(names), SpeedMetric)
+	}
+	metrics, err := dsService.GetInterfaceMetrics(ctx, link.DataSource, link.Interface, names)
+	if err != nil {
+		linkData.Status = "down"
+		if IsDegraded(err) {
+			linkData.Status = LinkDegraded
+			return linkData
+		}
+		if IsLookupError(err) {
+			return linkData // logged once by the datasource service, see Misconfigurations
+		}
+		s.logger.Debug("link metrics unavailable", "map", name, "link", link.Name, "datasource", link.DataSource, "interface", link.Interface, "error", err)
+		return linkData
+	}
+	linkData.Status = "up"
+	linkData.Metrics = metrics
+	if speed, ok := metrics[SpeedMetric].(int64); ok && speed > 0 && link.Bandwidth == config.BandwidthAuto {
+		linkData.DetectedBandwidth = formatSpeed(speed)
+	}
+	if inVal, okIn := metrics["in"].(int64); okIn {
+		if outVal, okOut := metrics["out"].(int64); okOut {
+			bw := linkBandwidth(link, linkData)
+			if bw > 0 {
+				utilization := float64(max(inVal, outVal)) / float64(bw) * 100
+				linkData.Utilization = math.Round(utilization*10) / 10
+			}
+			linkData.CommitUtilization = commitUtilization(link, float64(max(inVal, outVal)))
 		}
-		linksData = append(linksData, linkData)
 	}
-	return &config.MapWithData{
-		Map:         mapConfig,
-		ProcessedAt: time.Now(),
-		LinksData:   linksData,
-	}, nil
+	return linkData
+}
+
+// commitUtilization returns the percentage of the link commit rate used by rate (bytes/s)
+func commitUtilization(link config.Link, rate float64) *float64 {
+	if link.CommitRate == "" {
+		return nil
+	}
+	utilization := math.Round(rate/float64(utils.ParseBandwidth(link.CommitRate))*1000) / 10
+	return &utilization
 }
 
 func (s *MapService) CreateMap(newMap *config.Map, mapName string) error {
 	if newMap.Width <= 0 || newMap.Height <= 0 {
-		return fmt.Errorf("width and Height of map must be greater than 0")
+		return invalidf("width and Height of map must be greater than 0")
 	}
 	if newMap.Title == "" {
-		return fmt.Errorf("title for map is required")
+		return invalidf("title for map is required")
 	}
 	return s.saveMap(mapName, newMap)
 }
 
-func (s *MapService) ReplaceMap(mapName string, replaceMap *config.Map) error {
+// ReplaceMap writes the whole map config, created reports whether the map didn't exist before
+func (s *MapService) ReplaceMap(mapName string, replaceMap *config.Map) (created bool, err error) {
+	return s.replaceMap(mapName, replaceMap, false)
+}
+
+func (s *MapService) replaceMap(mapName string, replaceMap *config.Map, dryRun bool) (created bool, err error) {
 	if replaceMap.Width <= 0 || replaceMap.Height <= 0 {
-		return fmt.Errorf("width and Height of map must be greater than 0")
+		return false, invalidf("width and Height of map must be greater than 0")
 	}
 	if replaceMap.Title == "" {
-		return fmt.Errorf("title for map is required")
+		return false, invalidf("title for map is required")
 	}
-	return s.saveMap(mapName, replaceMap)
+	_, err = os.Stat(filepath.Join(s.configDir, mapName+".yaml"))
+	created = os.IsNotExist(err)
+	if dryRun {
+		return created, s.prepareMap(mapName, replaceMap)
+	}
+	return created, s.saveMap(mapName, replaceMap)
 }
 
 func (s *MapService) DeleteMap(mapName string) error {
 	configPath := filepath.Join(s.configDir, mapName+".yaml")
-	return os.Remove(configPath)
+	if err := os.Remove(configPath); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("map %w: %s", ErrNotFound, mapName)
+		}
+		return err
+	}
+	s.changes.notify(mapName)
+	return nil
 }
 
 func (s *MapService) AddNode(mapName string, newNode *config.Node) error {
@@ -133,12 +448,12 @@ func (s *MapService) AddNode(mapName string, newNode *config.Node) error {
 
 	for _, node := range mapConfig.Nodes {
 		if node.Name == newNode.Name {
-			return fmt.Errorf("node with name '%s' already exists", newNode.Name)
+			return fmt.Errorf("node with name '%s' %w", newNode.Name, ErrExists)
 		}
 	}
 
 	if newNode.Position.X > mapConfig.Width || newNode.Position.Y > mapConfig.Height {
-		return fmt.Errorf("node position is out of map bounds")
+		return invalidf("node position is out of map bounds")
 	}
 
 	mapConfig.Nodes = append(mapConfig.Nodes, *newNode)
@@ -162,7 +477,7 @@ func (s *MapService) DeleteNode(mapName, nodeName string) error {
 	}
 
 	if !nodeFound {
-		return fmt.Errorf("node not found")
+		return fmt.Errorf("node %w", ErrNotFound)
 	}
 
 	newLinks := make([]config.Link, 0, len(mapConfig.Links))
@@ -190,16 +505,19 @@ func (s *MapService) EditMap(mapName string, updates map[string]any) error {
 
 	if width, ok := updates["width"].(float64); ok {
 		if width <= 0 {
-			return fmt.Errorf("width must be greater than 0")
+			return invalidf("width must be greater than 0")
 		}
 		mapConfig.Width = int(width)
 	}
 	if height, ok := updates["height"].(float64); ok {
 		if height <= 0 {
-			return fmt.Errorf("height must be greater than 0")
+			return invalidf("height must be greater than 0")
 		}
 		mapConfig.Height = int(height)
 	}
+	if accessible, ok := updates["accessible"].(bool); ok {
+		mapConfig.Accessible = accessible
+	}
 
 	return s.saveMap(mapName, mapConfig)
 }
@@ -210,72 +528,254 @@ func (s *MapService) EditNode(mapName, nodeName string, updates map[string]any)
 		return err
 	}
 
-	nodeFound := false
-	for i, node := range mapConfig.Nodes {
-		if node.Name == nodeName {
-			if label, ok := updates["label"].(string); ok {
-				mapConfig.Nodes[i].Label = label
-			}
-			if icon, ok := updates["icon"].(string); ok {
-				mapConfig.Nodes[i].Icon = icon
-			}
-			if pos, ok := updates["position"].(map[string]any); ok {
-				if x, ok := pos["x"].(float64); ok {
-					mapConfig.Nodes[i].Position.X = int(x)
-				}
-				if y, ok := pos["y"].(float64); ok {
-					mapConfig.Nodes[i].Position.Y = int(y)
-				}
+	i := slices.IndexFunc(mapConfig.Nodes, func(node config.Node) bool { return node.Name == nodeName })
+	if i < 0 {
+		return fmt.Errorf("node %w", ErrNotFound)
+	}
+	if err := renameNode(mapConfig, i, updates); err != nil {
+		return err
+	}
+	applyNodeUpdates(&mapConfig.Nodes[i], updates)
+
+	return s.saveMap(mapName, mapConfig)
+}
+
+// NodeUpdate is the updates of one node in EditNodesBulk, with the fields of EditNode
+type NodeUpdate struct {
+	Name    string         `json:"name"`
+	Updates map[string]any `json:"updates"`
+}
+
+// EditNodesBulk applies the updates of several nodes in order and saves the map once, like
+// the positions of the nodes moved in an editor. Nothing is saved when a node is missing.
+func (s *MapService) EditNodesBulk(mapName string, updates []NodeUpdate) error {
+	if len(updates) == 0 {
+		return invalidf("invalid bulk edit: no nodes to update")
+	}
+	mapConfig, err := s.loadMapConfig(mapName)
+	if err != nil {
+		return err
+	}
+
+	for _, update := range updates {
+		i := slices.IndexFunc(mapConfig.Nodes, func(node config.Node) bool { return node.Name == update.Name })
+		if i < 0 {
+			return fmt.Errorf("node %w: %s", ErrNotFound, update.Name)
+		}
+		if err := renameNode(mapConfig, i, update.Updates); err != nil {
+			return err
+		}
+		node := &mapConfig.Nodes[i]
+		applyNodeUpdates(node, update.Updates)
+		if node.Position.X > mapConfig.Width || node.Position.Y > mapConfig.Height {
+			return invalidf("node '%s' position is out of map bounds", node.Name)
+		}
+	}
+
+	return s.saveMap(mapName, mapConfig)
+}
+
+// renameNode sets the name of the i-th node of a map to updates["name"], when given, and
+// points the links and demands of its old name to the new one so they stay valid
+func renameNode(m *config.Map, i int, updates map[string]any) error {
+	name, ok := updates["name"].(string)
+	if !ok || name == m.Nodes[i].Name {
+		return nil
+	}
+	if name == "" {
+		return invalidf("invalid node name: cannot be empty")
+	}
+	if slices.ContainsFunc(m.Nodes, func(node config.Node) bool { return node.Name == name }) {
+		return fmt.Errorf("node with name '%s' %w", name, ErrExists)
+	}
+
+	oldName := m.Nodes[i].Name
+	m.Nodes[i].Name = name
+	for j := range m.Links {
+		if m.Links[j].From == oldName {
+			m.Links[j].From = name
+		}
+		if m.Links[j].To == oldName {
+			m.Links[j].To = name
+		}
+	}
+	for j := range m.Demands {
+		if m.Demands[j].From == oldName {
+			m.Demands[j].From = name
+		}
+		if m.Demands[j].To == oldName {
+			m.Demands[j].To = name
+		}
+	}
+	return nil
+}
+
+// applyNodeUpdates sets the fields of a node given in updates, decoded from JSON
+func applyNodeUpdates(node *config.Node, updates map[string]any) {
+	if label, ok := updates["label"].(string); ok {
+		node.Label = label
+	}
+	if icon, ok := updates["icon"].(string); ok {
+		node.Icon = icon
+	}
+	if managementIP, ok := updates["management_ip"].(string); ok {
+		node.ManagementIP = managementIP
+	}
+	if loopback, ok := updates["loopback"].(string); ok {
+		node.Loopback = loopback
+	}
+	if dnsLabel, ok := updates["dns_label"].(string); ok {
+		node.DNSLabel = dnsLabel
+	}
+	if subnets, ok := updates["subnets"].([]any); ok {
+		node.Subnets = nil
+		for _, subnet := range subnets {
+			if subnet, ok := subnet.(string); ok {
+				node.Subnets = append(node.Subnets, subnet)
 			}
-			nodeFound = true
-			break
 		}
 	}
+	if pos, ok := updates["position"].(map[string]any); ok {
+		if x, ok := pos["x"].(float64); ok {
+			node.Position.X = int(x)
+		}
+		if y, ok := pos["y"].(float64); ok {
+			node.Position.Y = int(y)
+		}
+	}
+}
 
-	if !nodeFound {
-		return fmt.Errorf("node not found")
+func (s *MapService) EditLink(mapName, linkName string, updates map[string]any) error {
+	mapConfig, err := s.loadMapConfig(mapName)
+	if err != nil {
+		return err
 	}
 
+	i := slices.IndexFunc(mapConfig.Links, func(link config.Link) bool { return link.Name == linkName })
+	if i < 0 {
+		return fmt.Errorf("link %w", ErrNotFound)
+	}
+	applyLinkUpdates(&mapConfig.Links[i], updates)
+
 	return s.saveMap(mapName, mapConfig)
 }
 
-func (s *MapService) EditLink(mapName, linkName string, updates map[string]any) error {
+// LinkUpdate is the updates of one link in EditLinksBulk, with the fields of EditLink
+type LinkUpdate struct {
+	Name    string         `json:"name"`
+	Updates map[string]any `json:"updates"`
+}
+
+// BulkItemError is the problem of one item of a bulk edit, by its position in the request
+type BulkItemError struct {
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+// BulkEditError rejects a whole bulk edit, with every item that failed
+type BulkEditError struct {
+	Items []BulkItemError
+}
+
+func (e *BulkEditError) Error() string {
+	return fmt.Sprintf("validation failed for %d of the items, first %s: %s", len(e.Items), e.Items[0].Name, e.Items[0].Error)
+}
+
+// EditLinksBulk applies the updates of several links in order and saves the map once. Either
+// every link is updated or none: links missing or invalid after their updates are reported
+// together in a *BulkEditError.
+func (s *MapService) EditLinksBulk(mapName string, updates []LinkUpdate) error {
+	if len(updates) == 0 {
+		return invalidf("invalid bulk edit: no links to update")
+	}
 	mapConfig, err := s.loadMapConfig(mapName)
 	if err != nil {
 		return err
 	}
 
-	for i, link := range mapConfig.Links {
-		if link.Name == linkName {
+	var failed []BulkItemError
+	for index, update := range updates {
+		i := slices.IndexFunc(mapConfig.Links, func(link config.Link) bool { return link.Name == update.Name })
+		if i < 0 {
+			failed = append(failed, BulkItemError{Index: index, Name: update.Name, Error: "link not found"})
+			continue
+		}
+		applyLinkUpdates(&mapConfig.Links[i], update.Updates)
+		if err := s.parser.ValidateLink(mapConfig, mapConfig.Links[i]); err != nil {
+			failed = append(failed, BulkItemError{Index: index, Name: update.Name, Error: err.Error()})
+		}
+	}
+	if len(failed) > 0 {
+		return &BulkEditError{Items: failed}
+	}
+
+	return s.saveMap(mapName, mapConfig)
+}
 
-			if bandwidth, ok := updates["bandwidth"].(string); ok {
-				mapConfig.Links[i].Bandwidth = bandwidth
+// applyLinkUpdates sets the fields of a link given in updates, decoded from JSON. New
+// endpoints are checked against the nodes when the map is validated.
+func applyLinkUpdates(link *config.Link, updates map[string]any) {
+	if from, ok := updates["from"].(string); ok {
+		link.From = from
+	}
+	if to, ok := updates["to"].(string); ok {
+		link.To = to
+	}
+	if dataSource, ok := updates["datasource"].(string); ok {
+		link.DataSource = dataSource
+	}
+	if iface, ok := updates["interface"].(string); ok {
+		link.Interface = iface
+	}
+	if metrics, ok := updates["metrics"].([]any); ok {
+		link.Metrics = nil
+		for _, metric := range metrics {
+			if metric, ok := metric.(string); ok {
+				link.Metrics = append(link.Metrics, metric)
 			}
+		}
+	}
+	if width, ok := updates["width"].(float64); ok {
+		link.Width = int(width)
+	}
+	if bandwidth, ok := updates["bandwidth"].(string); ok {
+		link.Bandwidth = bandwidth
+	}
+	if commitRate, ok := updates["commit_rate"].(string); ok {
+		link.CommitRate = commitRate // "" removes it
+	}
+	if commitScale, ok := updates["commit_scale"].(string); ok {
+		link.CommitScale = commitScale
+	}
+	if scale, ok := updates["scale"].(string); ok {
+		link.Scale = scale
+	}
+	if cost, ok := updates["cost"].(float64); ok {
+		link.Cost = int(cost)
+	}
+	if subnet, ok := updates["subnet"].(string); ok {
+		link.Subnet = subnet
+	}
+
+	if viaData, ok := updates["via"].([]any); ok {
 
-			if viaData, ok := updates["via"].([]any); ok {
-
-				if len(viaData) == 0 {
-					mapConfig.Links[i].Via = nil
-				} else {
-					viaPositions := make([]config.Position, 0, len(viaData))
-					for _, item := range viaData {
-						if viaMap, ok := item.(map[string]any); ok {
-							if x, ok := viaMap["x"].(float64); ok {
-								if y, ok := viaMap["y"].(float64); ok {
-									viaPositions = append(viaPositions, config.Position{X: int(x), Y: int(y)})
-								}
-							}
+		if len(viaData) == 0 {
+			link.Via = nil
+		} else {
+			viaPositions := make([]config.Position, 0, len(viaData))
+			for _, item := range viaData {
+				if viaMap, ok := item.(map[string]any); ok {
+					if x, ok := viaMap["x"].(float64); ok {
+						if y, ok := viaMap["y"].(float64); ok {
+							viaPositions = append(viaPositions, config.Position{X: int(x), Y: int(y)})
 						}
 					}
-					mapConfig.Links[i].Via = viaPositions
 				}
 			}
-
-			return s.saveMap(mapName, mapConfig)
+			link.Via = viaPositions
 		}
 	}
-
-	return fmt.Errorf("link not found")
 }
 
 func (s *MapService) AddLink(mapName string, newLink *config.Link) error {
@@ -286,7 +786,7 @@ func (s *MapService) AddLink(mapName string, newLink *config.Link) error {
 
 	for _, link := range mapConfig.Links {
 		if link.Name == newLink.Name {
-			return fmt.Errorf("link with name '%s' already exists", newLink.Name)
+			return fmt.Errorf("link with name '%s' %w", newLink.Name, ErrExists)
 		}
 	}
 	// link bandwidth vilidating at saveMap by parser before save
@@ -311,7 +811,7 @@ func (s *MapService) DeleteLink(mapName, linkName string) error {
 	}
 
 	if !linkFound {
-		return fmt.Errorf("link not found")
+		return fmt.Errorf("link %w", ErrNotFound)
 	}
 
 	mapConfig.Links = newLinks
@@ -331,10 +831,10 @@ func (s *MapService) AddNodesBulk(mapName string, newNodes []config.Node) error
 
 	for _, newNode := range newNodes {
 		if existingNodes[newNode.Name] {
-			return fmt.Errorf("node with name '%s' already exists", newNode.Name)
+			return fmt.Errorf("node with name '%s' %w", newNode.Name, ErrExists)
 		}
 		if newNode.Position.X > mapConfig.Width || newNode.Position.Y > mapConfig.Height {
-			return fmt.Errorf("node '%s' position is out of map bounds", newNode.Name)
+			return invalidf("node '%s' position is out of map bounds", newNode.Name)
 		}
 		existingNodes[newNode.Name] = true
 	}
@@ -365,7 +865,7 @@ func (s *MapService) DeleteNodesBulk(mapName string, nodeNames []string) error {
 	}
 
 	if !nodeFound {
-		return fmt.Errorf("nodes not found")
+		return fmt.Errorf("nodes %w", ErrNotFound)
 	}
 
 	mapConfig.Nodes = newNodes
@@ -385,7 +885,7 @@ func (s *MapService) AddLinksBulk(mapName string, newLinks []config.Link) error
 
 	for _, newLink := range newLinks {
 		if existingLinks[newLink.Name] {
-			return fmt.Errorf("link with name '%s' already exists", newLink.Name)
+			return fmt.Errorf("link with name '%s' %w", newLink.Name, ErrExists)
 		}
 		existingLinks[newLink.Name] = true
 	}
@@ -416,7 +916,7 @@ func (s *MapService) DeleteLinksBulk(mapName string, linkNames []string) error {
 	}
 
 	if !linkFound {
-		return fmt.Errorf("links not found")
+		return fmt.Errorf("links %w", ErrNotFound)
 	}
 
 	mapConfig.Links = newLinks
@@ -427,40 +927,105 @@ func (s *MapService) loadMapConfig(mapName string) (*config.Map, error) {
 	configPath := filepath.Join(s.configDir, mapName+".yaml")
 	file, err := os.Open(configPath)
 	if err != nil {
-		return nil, fmt.Errorf("map not found: %s", mapName)
+		return nil, fmt.Errorf("map %w: %s", ErrNotFound, mapName)
 	}
-	defer func() {
-		if err := file.Close(); err != nil {
-			fmt.Printf("Failed to close file %s: %v\n", configPath, err)
-		}
-	}()
+	defer func() { _ = file.Close() }()
 
-	return s.parser.ParseYAML(file)
+	mapConfig, err := s.parser.ParseYAML(file)
+	if err != nil {
+		return mapConfig, err
+	}
+	s.decryptVariables(mapName, mapConfig)
+	return mapConfig, nil
 }
 
 func (s *MapService) saveMap(mapName string, mapConfig *config.Map) error {
-	if err := s.parser.Validate(mapConfig); err != nil {
-		return fmt.Errorf("validation failed before saving: %w", err)
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.writeMap(mapName, mapConfig)
+}
+
+// writeMap prepares and writes a map, the caller holds s.writeMu
+func (s *MapService) writeMap(mapName string, mapConfig *config.Map) error {
+	if err := s.prepareMap(mapName, mapConfig); err != nil {
+		return err
 	}
 	configPath := filepath.Join(s.configDir, mapName+".yaml")
-	data, err := yaml.Marshal(mapConfig)
+	data, err := s.marshalMap(mapConfig)
 	if err != nil {
 		return fmt.Errorf("failed to marshal config: %w", err)
 	}
-	return os.WriteFile(configPath, data, 0644)
+	if err := s.quota.write(configPath, data); err != nil {
+		return err
+	}
+	s.changes.notify(mapName)
+	return nil
+}
+
+// prepareMap completes a map about to be saved, ids, defaults, kept secrets and times, then
+// validates it. Nothing is written, dry runs stop there.
+func (s *MapService) prepareMap(mapName string, mapConfig *config.Map) error {
+	previous, _ := s.loadMapConfig(mapName)
+	if previous != nil {
+		keepIDs(mapConfig, previous)
+	}
+	assignIDs(mapConfig)
+	mapConfig.ApplyDefaults()
+	if previous != nil {
+		mapConfig.Variables.KeepSecrets(previous.Variables)
+		config.KeepReceiverSecrets(mapConfig.Receivers, previous.Receivers)
+		config.KeepDataSourceSecrets(mapConfig.Datasources, previous.Datasources)
+	}
+	stampTimes(mapConfig, previous, time.Now())
+	if err := s.parser.Validate(mapConfig); err != nil {
+		return &ValidationError{Err: err}
+	}
+	if err := ValidateDataSources(mapConfig.Datasources); err != nil {
+		return &ValidationError{Err: err}
+	}
+	if err := s.checkLinkRefs(mapName, mapConfig); err != nil {
+		return &ValidationError{Err: err}
+	}
+	return nil
 }
 
+// marshalMap encodes a map as it is written to its file, without the values of its defaults
+// and with its secret variables encrypted when the service has a cipher
+func (s *MapService) marshalMap(m *config.Map) ([]byte, error) {
+	stored := *m.WithoutDefaults()
+	if s.cipher != nil {
+		variables, err := stored.Variables.Encrypted(s.cipher)
+		if err != nil {
+			return nil, err
+		}
+		stored.Variables = variables
+	}
+	return yaml.Marshal(&stored)
+}
+
+// decryptVariables decrypts the secret variables of a map read from its file. Values the
+// service can't decrypt are left encrypted, saving the map writes them back as they were.
+func (s *MapService) decryptVariables(mapName string, m *config.Map) {
+	encrypted := slices.ContainsFunc(slices.Collect(maps.Values(m.Variables)), config.IsEncrypted)
+	if !encrypted {
+		return
+	}
+	if s.cipher == nil {
+		s.logger.Warn("map has encrypted variables but WEATHERMAP_VARIABLES_KEY is not set", "map", mapName)
+		return
+	}
+	if err := m.Variables.Decrypt(s.cipher); err != nil {
+		s.logger.Warn("failed to decrypt map variables", "map", mapName, "error", err)
+	}
+}
+
+// GetMapVariables returns the variables of a map, secrets masked
 func (s *MapService) GetMapVariables(mapName string) (map[string]string, error) {
 	mapConfig, err := s.loadMapConfig(mapName)
 	if err != nil {
 		return nil, err
 	}
-
-	if mapConfig.Variables == nil {
-		return make(map[string]string), nil
-	}
-
-	return mapConfig.Variables, nil
+	return mapConfig.Variables.Masked(), nil
 }
 
 func (s *MapService) UpdateMapVariables(mapName string, variables map[string]string) error {
@@ -473,63 +1038,104 @@ func (s *MapService) UpdateMapVariables(mapName string, variables map[string]str
 	return s.saveMap(mapName, mapConfig)
 }
 
+// ListIcons lists the icons of the icons directory and the ones embedded in the binary, a
+// missing directory only has the embedded ones. Their display name, category and tags come
+// from IconMetadataFile, else from their name.
 func (s *MapService) ListIcons() ([]config.IconInfo, error) {
-	if err := os.MkdirAll(s.iconsDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create icons directory: %w", err)
-	}
-
-	files, err := filepath.Glob(filepath.Join(s.iconsDir, "*.svg"))
+	files, err := filepath.Glob(filepath.Join(s.iconsDir, "*"))
 	if err != nil {
 		return nil, fmt.Errorf("failed to read icons directory: %w", err)
 	}
+	if s.baseIconsDir != "" {
+		base, err := filepath.Glob(filepath.Join(s.baseIconsDir, "*"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read icons directory: %w", err)
+		}
+		files = append(files, base...)
+	}
+	files = slices.DeleteFunc(files, func(file string) bool { return !IsIconFile(file) })
+	embedded, err := fs.Glob(assets.Icons, "icons/*.svg")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedded icons: %w", err)
+	}
+	names := make([]string, 0, len(files)+len(embedded))
+	for _, file := range append(files, embedded...) {
+		if name := filepath.Base(file); !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	metadata, err := s.listedIconMetadata()
+	if err != nil {
+		return nil, err
+	}
 
-	icons := make([]config.IconInfo, 0, len(files))
-	for _, file := range files {
-		baseName := filepath.Base(file)
+	icons := make([]config.IconInfo, 0, len(names))
+	for _, baseName := range names {
 		ext := filepath.Ext(baseName)
 		name := baseName[:len(baseName)-len(ext)]
 
-		category := "other"
-		switch {
-		case slices.Contains([]string{
-			"router", "switch", "firewall", "loadbalancer", "load_balancer", "lb",
-			"gw", "gateway", "core", "access", "distribution", "edge", "border",
-			"ix", "directconnect", "pni",
-		}, name):
-			category = "network"
-		case slices.Contains([]string{
-			"server", "database", "storage", "nas", "san", "dns", "dhcp", "ntp",
-		}, name):
-			category = "servers"
-		case slices.Contains([]string{
-			"cloud", "aws", "azure", "gcp", "digitalocean",
-		}, name):
-			category = "cloud"
-		case slices.Contains([]string{
-			"pc", "laptop", "phone", "tablet", "mobile", "desktop", "workstation", "monitor",
-		}, name):
-			category = "endpoints"
-		}
-
-		icons = append(icons, config.IconInfo{
+		info := config.IconInfo{
 			Name:        baseName,
 			DisplayName: formatDisplayName(name),
-			Category:    category,
-		})
+			Category:    defaultIconCategory(name),
+		}
+		if meta, ok := metadata[baseName]; ok {
+			info.Tags = meta.Tags
+			if meta.DisplayName != "" {
+				info.DisplayName = meta.DisplayName
+			}
+			if meta.Category != "" {
+				info.Category = meta.Category
+			}
+		}
+		icons = append(icons, info)
 	}
 
 	return icons, nil
 }
 
+// GetIconFile returns an icon of the icons directory, else of the server ones in a sandbox,
+// else of the binary, with its content type. The type of raster icons is sniffed from their content, a JPEG named .png is served
+// as image/jpeg.
 func (s *MapService) GetIconFile(iconName string) ([]byte, string, error) {
-	iconPath := filepath.Join(s.iconsDir, iconName)
-	if data, err := os.ReadFile(iconPath); err == nil {
-		return data, "image/svg+xml", nil
+	if !IsIconFile(iconName) {
+		return nil, "", fmt.Errorf("icon not found: %s", iconName)
+	}
+	for _, dir := range []string{s.iconsDir, s.baseIconsDir} {
+		if dir == "" {
+			continue
+		}
+		if data, err := os.ReadFile(filepath.Join(dir, iconName)); err == nil {
+			return data, iconContentType(iconName, data), nil
+		}
+	}
+	if data, err := fs.ReadFile(assets.Icons, path.Join("icons", iconName)); err == nil {
+		return data, iconContentType(iconName, data), nil
 	}
 
 	return nil, "", fmt.Errorf("icon not found: %s", iconName)
 }
 
+// iconExtensions are the files of the icons directory served as icons
+var iconExtensions = []string{".svg", ".png", ".jpg", ".jpeg", ".webp"}
+
+// IsIconFile reports whether a file name has the extension of an icon, svg or raster
+func IsIconFile(name string) bool {
+	return slices.Contains(iconExtensions, strings.ToLower(filepath.Ext(name)))
+}
+
+func iconContentType(name string, data []byte) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == ".svg" {
+		return "image/svg+xml"
+	}
+	if contentType := http.DetectContentType(data); strings.HasPrefix(contentType, "image/") {
+		return contentType
+	}
+	return mime.TypeByExtension(ext)
+}
+
 func formatDisplayName(name string) string {
 	words := strings.Split(name, "_")
 	for i, word := range words {
@@ -0,0 +1,1122 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/datasource"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+// stalledPoller never answers for the stuck datasource, like a poller waiting on a hung API
+type stalledPoller struct {
+	release chan struct{}
+}
+
+func (p *stalledPoller) AddTask(config.DataSourceConfig, config.InterfaceConfig, string, time.Duration) {
+}
+func (p *stalledPoller) Start()                         {}
+func (p *stalledPoller) Stop(ctx context.Context) error { return nil }
+func (p *stalledPoller) RemoveTasks(string)             {}
+func (p *stalledPoller) GetMetric(ds config.DataSourceConfig, iface config.InterfaceConfig, metric string) interface{} {
+	if ds.Name == "stuck" {
+		<-p.release
+	}
+	return int64(1000)
+}
+
+func TestMapDeadline(t *testing.T) {
+	poller := &stalledPoller{release: make(chan struct{})}
+	defer close(poller.release)
+	dsService := NewDataSourceService(nil)
+	dsService.pollers["stalled"] = poller
+	for _, name := range []string{"ok", "stuck"} {
+		dsService.datasources[name] = config.DataSourceConfig{Name: name, Type: "stalled", Interfaces: []config.InterfaceConfig{{Name: "eth0"}}}
+	}
+
+	mapService := NewMapService(t.TempDir())
+	mapService.SetMapDeadline(200 * time.Millisecond)
+	testMap := &config.Map{
+		Title: "deadline", Width: 100, Height: 100,
+		Nodes: []config.Node{{Name: "a"}, {Name: "b"}},
+		Links: []config.Link{
+			{Name: "fast", From: "a", To: "b", Bandwidth: "1G", DataSource: "ok", Interface: "eth0", Metrics: []string{"in", "out"}},
+			{Name: "hung", From: "a", To: "b", Bandwidth: "1G", DataSource: "stuck", Interface: "eth0", Metrics: []string{"in", "out"}},
+			{Name: "static", From: "b", To: "a", Bandwidth: "1G"},
+		},
+	}
+	if err := mapService.CreateMap(testMap, "deadline"); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+
+	started := time.Now()
+	data, err := mapService.GetMapWithData(context.Background(), "deadline", dsService)
+	if err != nil {
+		t.Fatalf("GetMapWithData failed: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("Expected the map after its deadline, took %s", elapsed)
+	}
+	if !data.Partial || len(data.PendingLinks) != 1 || data.PendingLinks[0] != "hung" {
+		t.Errorf("Expected a partial map pending on hung, got partial=%v pending=%v", data.Partial, data.PendingLinks)
+	}
+	statuses := map[string]string{}
+	for _, link := range data.LinksData {
+		statuses[link.Name] = link.Status
+	}
+	if statuses["fast"] != "up" || statuses["hung"] != "unknown" || statuses["static"] != "unknown" {
+		t.Errorf("Unexpected link states: %v", statuses)
+	}
+
+	delete(dsService.datasources, "stuck")
+	data, err = mapService.GetMapWithData(context.Background(), "deadline", dsService)
+	if err != nil || data.Partial || data.PendingLinks != nil {
+		t.Errorf("Expected a complete map, got partial=%v %v", data.Partial, err)
+	}
+}
+
+func TestCollectMapData(t *testing.T) {
+	dir := t.TempDir()
+	mapService := NewMapService(dir)
+	// the datasource is defined by another map of the directory
+	shared := &config.Map{
+		Title: "shared", Width: 100, Height: 100,
+		Datasources: []config.DataSourceConfig{{Name: "lab", Type: "mock", PollInterval: 1, Interfaces: []config.InterfaceConfig{{Name: "eth0"}}}},
+	}
+	testMap := &config.Map{
+		Title: "render", Width: 100, Height: 100,
+		Nodes: []config.Node{{Name: "a"}, {Name: "b"}},
+		Links: []config.Link{{Name: "a-b", From: "a", To: "b", Bandwidth: "1G", DataSource: "lab", Interface: "eth0", Metrics: []string{"in", "out"}}},
+	}
+	for name, m := range map[string]*config.Map{"shared": shared, "render": testMap} {
+		if err := mapService.CreateMap(m, name); err != nil {
+			t.Fatalf("Failed to create map %s: %v", name, err)
+		}
+	}
+
+	data, err := mapService.CollectMapData(context.Background(), "render", 1500*time.Millisecond)
+	if err != nil {
+		t.Fatalf("CollectMapData failed: %v", err)
+	}
+	if link := data.LinksData[0]; link.Status != "up" || link.Metrics["in"] == nil {
+		t.Errorf("Expected polled metrics, got %+v", link)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := mapService.CollectMapData(ctx, "render", time.Minute); err == nil {
+		t.Error("Expected a cancelled collection to fail")
+	}
+	if _, err := mapService.CollectMapData(context.Background(), "missing", 0); err == nil {
+		t.Error("Expected an error for a missing map")
+	}
+}
+
+func TestLinkRefsValidation(t *testing.T) {
+	mapService := NewMapService(t.TempDir())
+	shared := &config.Map{
+		Title: "shared", Width: 100, Height: 100,
+		Datasources: []config.DataSourceConfig{{Name: "core", Type: "mock", Interfaces: []config.InterfaceConfig{{Name: "eth0"}}}},
+	}
+	if err := mapService.CreateMap(shared, "shared"); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+	edge := func(iface string) *config.Map {
+		return &config.Map{
+			Title: "edge", Width: 100, Height: 100,
+			Nodes: []config.Node{{Name: "a"}, {Name: "b"}},
+			Links: []config.Link{{Name: "a-b", From: "a", To: "b", Bandwidth: "1G", DataSource: "core", Interface: iface}},
+		}
+	}
+
+	mapService.SetLinkRefsMode(LinkRefsEnforce)
+	if err := mapService.CreateMap(edge("eth0"), "edge"); err != nil {
+		t.Errorf("Expected a datasource of another map to resolve, got %v", err)
+	}
+	err := mapService.CreateMap(edge("eth9"), "edge")
+	if err == nil || !strings.Contains(err.Error(), "unknown interface eth9 of datasource core") {
+		t.Errorf("Expected the unknown interface to be refused, got %v", err)
+	}
+	external := edge("eth9")
+	external.ExternalDatasources = true
+	if err := mapService.CreateMap(external, "edge"); err != nil {
+		t.Errorf("Expected external_datasources to only warn, got %v", err)
+	}
+
+	mapService.SetLinkRefsMode(LinkRefsWarn)
+	if err := mapService.CreateMap(edge("eth9"), "edge"); err != nil {
+		t.Errorf("Expected warn mode to save the map, got %v", err)
+	}
+	report, _ := ValidateConfigDir(mapService.ConfigDir())
+	if len(report.Issues) != 1 || report.Issues[0].Severity != IssueWarning || report.Issues[0].File != "edge.yaml" {
+		t.Errorf("Expected a warning for the unknown interface, got %+v", report.Issues)
+	}
+}
+
+func TestNodeStatus(t *testing.T) {
+	mapService := NewMapService(t.TempDir())
+	testMap := &config.Map{
+		Title: "ping", Width: 100, Height: 100,
+		Nodes: []config.Node{{Name: "a", Address: "10.0.0.1"}, {Name: "b", Address: "core-b.example.net"}, {Name: "c"}},
+	}
+	if err := mapService.CreateMap(testMap, "ping"); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+	var attempts atomic.Int32
+	mapService.nodeStatus.ping = func(ctx context.Context, host string, timeout time.Duration) (time.Duration, error) {
+		if host == "10.0.0.1" {
+			return 1500 * time.Microsecond, nil
+		}
+		attempts.Add(1)
+		return 0, errors.New("no echo reply")
+	}
+
+	data, _ := mapService.GetMapWithData(context.Background(), "ping", nil)
+	if data.NodesData != nil {
+		t.Errorf("Expected no node status while pings are disabled, got %+v", data.NodesData)
+	}
+	mapService.nodeStatus.enabled = true
+	data, _ = mapService.GetMapWithData(context.Background(), "ping", nil)
+	if len(data.NodesData) != 2 || data.NodesData[0].Status != "unknown" {
+		t.Errorf("Expected unknown status before the first check, got %+v", data.NodesData)
+	}
+
+	if err := mapService.PingNodes(context.Background()); err != nil {
+		t.Fatalf("PingNodes failed: %v", err)
+	}
+	data, _ = mapService.GetMapWithData(context.Background(), "ping", nil)
+	up, down := data.NodesData[0], data.NodesData[1]
+	if up.Name != "a" || up.Status != "up" || up.LatencyMs == nil || *up.LatencyMs != 1.5 {
+		t.Errorf("Expected a up with 1.5ms latency, got %+v", up)
+	}
+	if down.Name != "b" || down.Status != "down" || attempts.Load() != pingAttempts {
+		t.Errorf("Expected b down after %d attempts, got %+v after %d", pingAttempts, down, attempts.Load())
+	}
+
+	mapService.nodeStatus.ping = func(ctx context.Context, host string, timeout time.Duration) (time.Duration, error) {
+		return 0, fmt.Errorf("icmp socket: %w", os.ErrPermission)
+	}
+	if err := mapService.PingNodes(context.Background()); err == nil || !strings.Contains(err.Error(), "CAP_NET_RAW") {
+		t.Errorf("Expected a permission error, got %v", err)
+	}
+
+	for _, address := range []string{"10.0.0.300", "core b", "-core"} {
+		testMap.Nodes[2].Address = address
+		if err := mapService.CreateMap(testMap, "ping"); err == nil {
+			t.Errorf("Expected address %q to be rejected", address)
+		}
+	}
+}
+
+func TestZabbixNodeStatus(t *testing.T) {
+	var requests atomic.Int32
+	zabbix := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Path != "/api_jsonrpc.php" || r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("Unexpected request %s with %q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		_, _ = w.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "result": [
+			{"host": "core1", "status": "0", "maintenance_status": "1", "interfaces": [{"available": "2"}]},
+			{"host": "edge1", "status": "0", "maintenance_status": "0", "interfaces": [{"available": "2"}, {"available": "1"}]},
+			{"host": "edge2", "status": "0", "maintenance_status": "0", "interfaces": [{"available": "2"}]}
+		]}`))
+	}))
+	defer zabbix.Close()
+
+	mapService := NewMapService(t.TempDir())
+	testMap := &config.Map{
+		Title: "zabbix", Width: 100, Height: 100,
+		Nodes: []config.Node{
+			{Name: "core1", Address: "10.0.0.1", Zabbix: &config.NodeZabbix{Datasource: "zbx", Host: "core1"}},
+			{Name: "edge1", Zabbix: &config.NodeZabbix{Datasource: "zbx", Host: "edge1"}},
+			{Name: "edge2", Zabbix: &config.NodeZabbix{Datasource: "zbx", Host: "edge2"}},
+			{Name: "edge3", Zabbix: &config.NodeZabbix{Datasource: "zbx", Host: "edge3"}},
+		},
+		Datasources: []config.DataSourceConfig{{Name: "zbx", Type: "zabbix", Params: map[string]interface{}{"url": zabbix.URL, "api_token": "token"}}},
+	}
+	if err := mapService.CreateMap(testMap, "zabbix"); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+	mapService.nodeStatus.enabled = true
+	mapService.nodeStatus.ping = func(ctx context.Context, host string, timeout time.Duration) (time.Duration, error) {
+		return 0, errors.New("no echo reply")
+	}
+	if err := mapService.PingNodes(context.Background()); err != nil {
+		t.Fatalf("PingNodes failed: %v", err)
+	}
+	if err := mapService.CheckZabbixHosts(context.Background()); err != nil {
+		t.Fatalf("CheckZabbixHosts failed: %v", err)
+	}
+	if requests.Load() != 1 {
+		t.Errorf("Expected one host.get for the datasource, got %d", requests.Load())
+	}
+
+	data, _ := mapService.GetMapWithData(context.Background(), "zabbix", nil)
+	statuses := make(map[string]string)
+	for _, node := range data.NodesData {
+		statuses[node.Name] = node.Status
+	}
+	// core1 doesn't answer pings, but is in maintenance
+	expected := map[string]string{"core1": "maintenance", "edge1": "up", "edge2": "down", "edge3": "unknown"}
+	if !maps.Equal(statuses, expected) {
+		t.Errorf("Expected %v, got %v", expected, statuses)
+	}
+}
+
+func TestSNMPNodeStatus(t *testing.T) {
+	r1 := config.DataSourceConfig{
+		Name: "r1", Type: SNMPPollerType, Params: map[string]interface{}{"host": "192.0.2.1"},
+		Interfaces: []config.InterfaceConfig{{Name: "ge-0/0/0", Params: map[string]interface{}{"oids": map[string]interface{}{"in": "1.3.6.1.2.1.2.2.1.10.1"}}}},
+	}
+	dsService := NewDataSourceService([]config.DataSourceConfig{r1})
+	poller := dsService.pollers[SNMPPollerType].(*SNMPPoller)
+	tasks := poller.tasks
+
+	mapService := NewMapService(t.TempDir())
+	testMap := &config.Map{
+		Title: "snmp", Width: 100, Height: 100,
+		Nodes:       []config.Node{{Name: "r1", DataSource: "r1"}, {Name: "r2", DataSource: "r2"}, {Name: "r3"}},
+		Datasources: []config.DataSourceConfig{r1},
+	}
+	if err := mapService.CreateMap(testMap, "snmp"); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+	status := func() map[string]config.NodeData {
+		data, _ := mapService.GetMapWithData(context.Background(), "snmp", dsService)
+		result := make(map[string]config.NodeData)
+		for _, node := range data.NodesData {
+			result[node.Name] = node
+		}
+		return result
+	}
+
+	nodes := status()
+	if len(nodes) != 2 || nodes["r1"].Status != "unknown" || nodes["r2"].Error != "datasource not found: r2" {
+		t.Errorf("Expected r1 unknown before the first poll and r2 unknown, got %+v", nodes)
+	}
+
+	start := time.Now()
+	poller.recordDevice(tasks, map[string]datasource.SNMPValue{sysUpTimeOID: {Value: 360000}}, nil, start)
+	if r1 := status()["r1"]; r1.Status != "up" || r1.UptimeSeconds == nil || *r1.UptimeSeconds != 3600 || r1.RebootedAt != nil {
+		t.Errorf("Expected r1 up for an hour, got %+v", r1)
+	}
+	poller.recordDevice(tasks, nil, errors.New("request timeout"), start.Add(time.Minute))
+	if r1 := status()["r1"]; r1.Status != "down" || r1.Error != "request timeout" {
+		t.Errorf("Expected r1 down after a failed poll, got %+v", r1)
+	}
+
+	// a sysUpTime lower than before is a reboot, unless the counter wrapped around
+	poller.recordDevice(tasks, map[string]datasource.SNMPValue{sysUpTimeOID: {Value: 3000}}, nil, start.Add(2*time.Minute))
+	rebooted := status()["r1"]
+	if rebooted.Status != "up" || rebooted.RebootedAt == nil || !rebooted.RebootedAt.Equal(start.Add(2*time.Minute-30*time.Second)) {
+		t.Errorf("Expected r1 rebooted 30s before the poll, got %+v", rebooted)
+	}
+	poller.recordDevice(tasks, map[string]datasource.SNMPValue{sysUpTimeOID: {Value: 1<<32 - 500}}, nil, start.Add(3*time.Minute))
+	poller.recordDevice(tasks, map[string]datasource.SNMPValue{sysUpTimeOID: {Value: 500}}, nil, start.Add(3*time.Minute+10*time.Second))
+	if again := status()["r1"]; !again.RebootedAt.Equal(*rebooted.RebootedAt) {
+		t.Errorf("Expected no reboot on a sysUpTime wrap, got %v", again.RebootedAt)
+	}
+}
+
+func TestNodeMetrics(t *testing.T) {
+	const (
+		cpuOID = "1.3.6.1.4.1.9.9.109.1.1.1.1.8.1"
+		psuOID = "1.3.6.1.4.1.9.9.13.1.5.1.3.1" // ciscoEnvMonSupplyState
+		fanOID = "1.3.6.1.4.1.9.9.13.1.4.1.3.1" // ciscoEnvMonFanState
+	)
+	sim := newSimulator(t)
+	sim.Set(cpuOID, gosnmp.Gauge32, uint(37))
+	sim.Set(psuOID, gosnmp.Integer, 3)
+	sim.Set(fanOID, gosnmp.Integer, 9)
+	ds := simDataSource(sim, "public")
+	ds.PollInterval = 1
+	ds.Params["timeout"] = "500ms"
+	ds.Interfaces = append(ds.Interfaces, config.InterfaceConfig{
+		Name: "system",
+		Params: map[string]interface{}{
+			"oids":   map[string]interface{}{"cpu": cpuOID, "psu": psuOID, "fan": fanOID},
+			"gauges": []interface{}{"cpu", "psu", "fan"},
+		},
+	})
+	dsService := NewDataSourceService([]config.DataSourceConfig{ds})
+	dsService.Start()
+	defer func() { _ = dsService.Stop(context.Background()) }()
+
+	mapService := NewMapService(t.TempDir())
+	invalid := &config.Map{
+		Title: "invalid", Width: 100, Height: 100,
+		Nodes: []config.Node{{Name: "router", Metrics: []string{"cpu"}, States: config.NodeStates{"psu": {1: "normal"}}}},
+	}
+	if err := mapService.CreateMap(invalid, "invalid"); err == nil || !strings.Contains(err.Error(), "states: metric psu is not in the metrics of the node") {
+		t.Errorf("Expected states of an unread metric rejected, got %v", err)
+	}
+	testMap := &config.Map{
+		Title: "metrics", Width: 100, Height: 100,
+		Nodes: []config.Node{
+			{Name: "router", DataSource: ds.Name, Interface: "system", Metrics: []string{"cpu", "psu", "fan"},
+				States: config.NodeStates{"psu": {1: "normal", 3: "critical"}, "fan": {1: "normal"}}},
+			{Name: "broken", DataSource: ds.Name, Interface: "missing", Metrics: []string{"cpu"}},
+		},
+		Datasources: []config.DataSourceConfig{ds},
+	}
+	if err := mapService.CreateMap(testMap, "metrics"); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, err := mapService.GetMapWithData(context.Background(), "metrics", dsService)
+		if err != nil {
+			t.Fatalf("GetMapWithData failed: %v", err)
+		}
+		router, broken := data.NodesData[0], data.NodesData[1]
+		if router.Status == "up" {
+			if cpu, _ := router.Metrics["cpu"].(int64); cpu != 37 {
+				t.Errorf("Expected the cpu gauge as read, got %v", router.Metrics)
+			}
+			if router.Metrics["psu"] != "critical" || router.Metrics["fan"] != int64(9) {
+				t.Errorf("Expected the psu state labelled and the unlabelled fan state as read, got %v", router.Metrics)
+			}
+			if router.UptimeSeconds == nil || *router.UptimeSeconds < 86400 {
+				t.Errorf("Expected the uptime of the fixture, got %v", router.UptimeSeconds)
+			}
+			if broken.Metrics != nil || broken.Error != "interface not found: missing" {
+				t.Errorf("Expected no metrics for an unknown interface, got %+v", broken)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Node not up after 5s: %+v", data.NodesData)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestLinkStyleRules(t *testing.T) {
+	m := &config.Map{
+		Links: []config.Link{
+			{Name: "hot", Bandwidth: "1G"},
+			{Name: "errors", Bandwidth: "10G"},
+			{Name: "down"},
+		},
+		LinkStyle: &config.LinkStyleRules{
+			Color: `status == "down" ? null : utilization > 80 ? "#ff0000" : metrics.errors > 0 ? rgb(255, 0, 255) : ""`,
+			Width: `bandwidth * 8 >= 10e9 ? 8 : 3`, // bytes per second like in and out
+			Dash:  `metrics.errors * 2`,
+		},
+	}
+	linksData := []config.LinkData{
+		{Name: "hot", Status: "up", Utilization: 92, Metrics: map[string]interface{}{"errors": int64(0)}},
+		{Name: "errors", Status: "up", Utilization: 12, Metrics: map[string]interface{}{"errors": int64(3)}},
+		{Name: "down", Status: "down"},
+	}
+	applyLinkStyles(m, linksData)
+
+	hot, errs, down := linksData[0].Style, linksData[1].Style, linksData[2].Style
+	if hot == nil || hot.Color != "#ff0000" || hot.Width != 3 || hot.Dash != "" || hot.Error != "" {
+		t.Errorf("Expected hot red, 3px wide and solid, got %+v", hot)
+	}
+	if errs == nil || errs.Color != "#ff00ff" || errs.Width != 8 || errs.Dash != "6" {
+		t.Errorf("Expected errors magenta, 8px wide and dashed, got %+v", errs)
+	}
+	if down == nil || down.Color != "" || down.Width != 3 || !strings.Contains(down.Error, "dash: operator * not defined on null") {
+		t.Errorf("Expected no color for the down link and a dash error, got %+v", down)
+	}
+
+	mapService := NewMapService(t.TempDir())
+	broken := &config.Map{Title: "style", Width: 100, Height: 100, LinkStyle: &config.LinkStyleRules{Color: `utilization >`}}
+	if err := mapService.CreateMap(broken, "style"); err == nil || !strings.Contains(err.Error(), "link_style.color") {
+		t.Errorf("Expected the rule refused on save, got %v", err)
+	}
+}
+
+func TestAlertRules(t *testing.T) {
+	var mu sync.Mutex
+	var webhooks []AlertNotification
+	var texts []string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/hook":
+			var n AlertNotification
+			json.NewDecoder(r.Body).Decode(&n)
+			webhooks = append(webhooks, n)
+		case "/botsecret/sendMessage":
+			var msg map[string]string
+			json.NewDecoder(r.Body).Decode(&msg)
+			texts = append(texts, msg["chat_id"]+": "+msg["text"])
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer receiver.Close()
+
+	mapService := NewMapService(t.TempDir())
+	mapService.alerts.telegramURL = receiver.URL
+	data := &config.MapWithData{Map: &config.Map{
+		Title: "core", Width: 100, Height: 100,
+		Nodes: []config.Node{{Name: "a"}, {Name: "b"}},
+		Links: []config.Link{{Name: "a-b", From: "a", To: "b", Bandwidth: "1G"}, {Name: "b-a", From: "b", To: "a", Bandwidth: "1G"}},
+		Alerts: []config.AlertRule{
+			{Name: "hot", Condition: "utilization > 90", For: "5m", Links: []string{"a-b"}, Notify: []string{"hook"}},
+			{Name: "down", Condition: `status == "down"`, Notify: []string{"hook", "noc"}},
+		},
+		Receivers: []config.AlertReceiver{
+			{Name: "hook", Type: config.ReceiverWebhook, URL: receiver.URL + "/hook"},
+			{Name: "noc", Type: config.ReceiverTelegram, BotToken: "secret", ChatID: "-100"},
+		},
+	}}
+	if err := mapService.parser.Validate(data.Map); err != nil {
+		t.Fatalf("Expected valid alert rules: %v", err)
+	}
+
+	start := time.Now()
+	evaluate := func(at time.Duration, utilization float64, status string) {
+		t.Helper()
+		data.LinksData = []config.LinkData{
+			{Name: "a-b", Utilization: utilization, Status: status},
+			{Name: "b-a", Utilization: utilization, Status: "up"},
+		}
+		seen := make(map[alertKey]bool)
+		for _, event := range mapService.evaluateMapAlerts(context.Background(), "core", data, start.Add(at), seen) {
+			for _, r := range event.receivers {
+				if err := mapService.alerts.notify(context.Background(), r, event.notification); err != nil {
+					t.Fatalf("Failed to notify %s: %v", r.Name, err)
+				}
+			}
+		}
+		for key := range mapService.alerts.alerts {
+			if !seen[key] {
+				delete(mapService.alerts.alerts, key)
+			}
+		}
+	}
+
+	evaluate(0, 95, "up")
+	evaluate(time.Minute, 96, "up")
+	if alerts := mapService.alerts.alerts; len(alerts) != 1 || alerts[alertKey{"core", "hot", "a-b"}].State != AlertPending || len(webhooks) != 0 {
+		t.Fatalf("Expected a-b pending for 5m without notification, got %v %v", alerts, webhooks)
+	}
+	evaluate(5*time.Minute, 97, "up")
+	evaluate(6*time.Minute, 97, "up")
+	if len(webhooks) != 1 || webhooks[0].Status != AlertFiring || webhooks[0].Link != "a-b" || webhooks[0].Utilization != 97 {
+		t.Fatalf("Expected one firing notification for a-b, got %+v", webhooks)
+	}
+	evaluate(7*time.Minute, 10, "down")
+	if len(webhooks) != 3 || webhooks[1].Status != AlertResolved || webhooks[1].ResolvedAt == nil || webhooks[2].Rule != "down" {
+		t.Fatalf("Expected hot resolved and down firing, got %+v", webhooks)
+	}
+	if len(texts) != 1 || !strings.HasPrefix(texts[0], "-100: [FIRING] core: down on link a-b") {
+		t.Errorf("Expected the telegram message of the down link, got %q", texts)
+	}
+	evaluate(8*time.Minute, 10, "down")
+	evaluate(9*time.Minute, 10, "up")
+	if len(webhooks) != 4 || webhooks[3].Status != AlertResolved || len(texts) != 2 || !strings.Contains(texts[1], "after 2m0s") {
+		t.Errorf("Expected down resolved once, got %+v %q", webhooks, texts)
+	}
+	if len(mapService.alerts.alerts) != 0 {
+		t.Errorf("Expected no alert left, got %v", mapService.alerts.alerts)
+	}
+
+	data.Alerts[1].Notify = []string{"pager"}
+	if err := mapService.parser.Validate(data.Map); err == nil || !strings.Contains(err.Error(), "alert 'down': unknown receiver: pager") {
+		t.Errorf("Expected the unknown receiver refused, got %v", err)
+	}
+}
+
+func TestWarm(t *testing.T) {
+	mapService := NewMapService(t.TempDir())
+	testMap := &config.Map{
+		Title: "warm", Width: 100, Height: 100,
+		Nodes:       []config.Node{{Name: "a"}, {Name: "b"}},
+		Links:       []config.Link{{Name: "a-b", From: "a", To: "b", Bandwidth: "1G", DataSource: "lab", Interface: "eth0", Metrics: []string{"in", "out"}}},
+		Datasources: []config.DataSourceConfig{{Name: "lab", Type: "mock", Interfaces: []config.InterfaceConfig{{Name: "eth0"}}}},
+	}
+	if err := mapService.CreateMap(testMap, "warm"); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+	dsService := NewDataSourceService(testMap.Datasources)
+	dsService.Start()
+	defer dsService.Stop(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	started := time.Now()
+	if err := mapService.Warm(ctx, dsService); err != nil {
+		t.Fatalf("Warm failed: %v", err)
+	}
+	// the mock poller ticks every second, its first cycle must not wait for it
+	if elapsed := time.Since(started); elapsed >= time.Second {
+		t.Errorf("Expected the first poll at once, waited %s", elapsed)
+	}
+	data, err := mapService.GetMapWithData(context.Background(), "warm", dsService)
+	if err != nil {
+		t.Fatalf("GetMapWithData failed: %v", err)
+	}
+	if link := data.LinksData[0]; link.Status != "up" || link.Metrics["in"] == nil {
+		t.Errorf("Expected polled metrics right after warming up, got %+v", link)
+	}
+
+	expired, cancel := context.WithCancel(context.Background())
+	cancel()
+	stalled := NewDataSourceService(nil)
+	poller := NewMockPoller()
+	poller.AddTask(testMap.Datasources[0], testMap.Datasources[0].Interfaces[0], "in", time.Minute)
+	poller.startLoops(func(dataPollTask) string { return "" }, func(context.Context, string) {}) // never polls
+	stalled.pollers["mock"] = poller
+	if err := stalled.WaitFirstPolls(expired); err == nil || !strings.Contains(err.Error(), "1 poll tasks not polled yet") {
+		t.Errorf("Expected the unpolled task reported, got %v", err)
+	}
+}
+
+func TestLinkColors(t *testing.T) {
+	red, green := config.Color{R: 255}, config.Color{G: 192}
+	m := &config.Map{
+		Scales: map[string][]config.Scale{
+			"default": {{Min: 0, Max: 50, Color: green}, {Min: 50, Max: 100, Color: red}},
+			"transit": {{Min: 0, Max: 100, Color: green}},
+		},
+		Links: []config.Link{{Name: "hot"}, {Name: "transit", Scale: "transit"}, {Name: "down"}, {Name: "styled"}},
+	}
+	linksData := []config.LinkData{
+		{Name: "hot", Status: "up", Utilization: 75},
+		{Name: "transit", Status: "up", Utilization: 75},
+		{Name: "down", Status: "down"},
+		{Name: "styled", Status: "up", Utilization: 75, Style: &config.LinkStyle{Color: "#0000FF"}},
+	}
+	applyLinkColors(m, linksData)
+	for i, want := range []string{"#ff0000", "#00c000", "#404040", "#0000ff"} {
+		if linksData[i].Color != want {
+			t.Errorf("Expected link %s %s, got %s", linksData[i].Name, want, linksData[i].Color)
+		}
+	}
+}
+
+func TestMapDefaults(t *testing.T) {
+	dir := t.TempDir()
+	mapService := NewMapService(dir)
+	monitoring := true
+	m := &config.Map{
+		Title: "defaults", Width: 100, Height: 100,
+		Defaults: &config.Defaults{
+			Node: &config.NodeDefaults{Icon: "router.svg", Monitoring: &monitoring},
+			Link: &config.LinkDefaults{Bandwidth: "10G", Width: 6},
+		},
+		Nodes: []config.Node{{Name: "a"}, {Name: "b", Icon: "server.svg"}},
+		Links: []config.Link{{Name: "a-b", From: "a", To: "b"}, {Name: "b-a", From: "b", To: "a", Bandwidth: "1G"}},
+	}
+	if err := mapService.CreateMap(m, "defaults"); err != nil {
+		t.Fatalf("Expected links without bandwidth accepted with a default one: %v", err)
+	}
+
+	loaded, err := mapService.GetMap("defaults")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, b := loaded.Nodes[0], loaded.Nodes[1]; a.Icon != "router.svg" || b.Icon != "server.svg" || a.Monitoring == nil || !*a.Monitoring {
+		t.Errorf("Expected the default icon and monitoring on a only, got %+v %+v", a, b)
+	}
+	if ab, ba := loaded.Links[0], loaded.Links[1]; ab.Bandwidth != "10G" || ab.Width != 6 || ba.Bandwidth != "1G" || ba.Width != 6 {
+		t.Errorf("Expected the default bandwidth on a-b and width on both, got %+v %+v", ab, ba)
+	}
+
+	// the file keeps the values unset, so changing a default applies to them
+	content, err := os.ReadFile(filepath.Join(dir, "defaults.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(content), "bandwidth: 10G"); n != 1 {
+		t.Errorf("Expected the default bandwidth written once, got %d in\n%s", n, content)
+	}
+	stripped := loaded.WithoutDefaults()
+	stripped.Defaults = &config.Defaults{Node: loaded.Defaults.Node, Link: &config.LinkDefaults{Bandwidth: "100G", Width: 6}}
+	if _, err := mapService.ReplaceMap("defaults", stripped); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, _ = mapService.GetMap("defaults"); loaded.Links[0].Bandwidth != "100G" || loaded.Links[1].Bandwidth != "1G" {
+		t.Errorf("Expected a-b to follow the new default, got %+v", loaded.Links)
+	}
+
+	m.Defaults.Link.Bandwidth = "fast"
+	if err := mapService.CreateMap(m, "broken"); err == nil || !strings.Contains(err.Error(), "defaults.link") {
+		t.Errorf("Expected the default bandwidth validated, got %v", err)
+	}
+}
+
+func TestLinkCapture(t *testing.T) {
+	mapService := NewMapService(t.TempDir())
+	ds := config.DataSourceConfig{Name: "lab", Type: "mock", Interfaces: []config.InterfaceConfig{
+		{Name: "eth0", Params: map[string]interface{}{"metrics": []interface{}{"in", "out"}}},
+	}}
+	testMap := &config.Map{
+		Title: "capture", Width: 100, Height: 100,
+		Nodes:       []config.Node{{Name: "a"}, {Name: "b"}},
+		Links:       []config.Link{{Name: "a-b", From: "a", To: "b", Bandwidth: "1G", DataSource: "lab", Interface: "eth0", Metrics: []string{"in", "out"}}},
+		Datasources: []config.DataSourceConfig{ds},
+	}
+	if err := mapService.CreateMap(testMap, "capture"); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+	dsService := NewDataSourceService(testMap.Datasources)
+
+	if _, err := mapService.GetLinkCapture("capture", "a-b", dsService); err == nil || !strings.Contains(err.Error(), "capture not found") {
+		t.Errorf("Expected no capture before starting one, got %v", err)
+	}
+	if _, err := mapService.StartLinkCapture("capture", "a-b", MaxCaptureSamples+1, dsService); err == nil || !strings.Contains(err.Error(), "invalid samples") {
+		t.Errorf("Expected too many samples rejected, got %v", err)
+	}
+	capture, err := mapService.StartLinkCapture("capture", "a-b", 0, dsService)
+	if err != nil {
+		t.Fatalf("StartLinkCapture failed: %v", err)
+	}
+	if capture.Size != DefaultCaptureSamples || capture.DataSource != "lab" || len(capture.Samples) != 0 {
+		t.Errorf("Expected an empty capture of the default size, got %+v", capture)
+	}
+
+	dsService.Start()
+	defer dsService.Stop(context.Background())
+	deadline := time.Now().Add(3 * time.Second)
+	for len(capture.Samples) < 2 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+		if capture, err = mapService.GetLinkCapture("capture", "a-b", dsService); err != nil {
+			t.Fatalf("GetLinkCapture failed: %v", err)
+		}
+	}
+	if len(capture.Samples) < 2 {
+		t.Fatalf("Expected the first poll captured, got %+v", capture.Samples)
+	}
+	for _, sample := range capture.Samples[:2] {
+		if sample.Raw == nil || sample.Value == nil || sample.Raw.(int64) != *sample.Value {
+			t.Errorf("Expected the raw traffic and its cached value, got %+v", sample)
+		}
+	}
+	if capture.Samples[0].Metric != "in" || capture.Samples[1].Metric != "out" {
+		t.Errorf("Expected samples ordered by time and metric, got %+v", capture.Samples)
+	}
+
+	poller := dsService.pollers["mock"].(*MockPoller)
+	task := dataPollTask{Key: mockKey(ds, ds.Interfaces[0], "in"), MetricIdentifier: "in"}
+	poller.startCapture(task.Key, "in", 2)
+	for i := range 3 {
+		poller.capture(task, int64(i), false, nil)
+	}
+	if samples, size, _ := poller.captured(task.Key); size != 2 || len(samples) != 2 || samples[1].Raw != int64(2) {
+		t.Errorf("Expected the last 2 samples kept, got %+v", samples)
+	}
+
+	if err := mapService.StopLinkCapture("capture", "a-b", dsService); err != nil {
+		t.Fatalf("StopLinkCapture failed: %v", err)
+	}
+	if err := mapService.StopLinkCapture("capture", "a-b", dsService); err == nil || !strings.Contains(err.Error(), "capture not found") {
+		t.Errorf("Expected a stopped capture gone, got %v", err)
+	}
+}
+
+func TestUtilizationPolicy(t *testing.T) {
+	mapService := NewMapService(t.TempDir())
+	m := &config.Map{
+		Title: "over", Width: 100, Height: 100, OverUtilization: "drop",
+		Nodes: []config.Node{{Name: "a"}, {Name: "b"}},
+		Links: []config.Link{{Name: "a-b", From: "a", To: "b", Bandwidth: "1G"}, {Name: "b-a", From: "b", To: "a", Bandwidth: "1G"}},
+	}
+	if err := mapService.CreateMap(m, "over"); err == nil || !strings.Contains(err.Error(), "over_utilization: unknown policy drop") {
+		t.Errorf("Expected an unknown policy rejected, got %v", err)
+	}
+	m.OverUtilization = ""
+	if err := mapService.CreateMap(m, "over"); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+
+	for _, tc := range []struct {
+		policy, status string
+		utilization    float64
+	}{
+		{"", "up", 412.3},
+		{config.OverUtilizationAllow, "up", 412.3},
+		{config.OverUtilizationClamp, "up", 100},
+		{config.OverUtilizationFlag, LinkMisconfigured, 412.3},
+	} {
+		m.OverUtilization = tc.policy
+		linksData := []config.LinkData{{Name: "a-b", Status: "up", Utilization: 412.3}, {Name: "b-a", Status: "up", Utilization: 40}}
+		mapService.applyUtilizationPolicy("over", m, linksData)
+		if linksData[0].Status != tc.status || linksData[0].Utilization != tc.utilization {
+			t.Errorf("policy %q: expected %s at %g%%, got %+v", tc.policy, tc.status, tc.utilization, linksData[0])
+		}
+		if linksData[1].Status != "up" || linksData[1].Utilization != 40 {
+			t.Errorf("policy %q: expected a link below 100%% untouched, got %+v", tc.policy, linksData[1])
+		}
+	}
+
+	report, err := mapService.Lint()
+	if err != nil {
+		t.Fatalf("Lint failed: %v", err)
+	}
+	if len(report.Issues) != 1 {
+		t.Fatalf("Expected one link reported, got %+v", report.Issues)
+	}
+	if issue := report.Issues[0]; issue.File != "over.yaml" || issue.Line == 0 || issue.Severity != IssueWarning ||
+		!strings.Contains(issue.Message, "link 'a-b': utilization above 100% seen 4 times") || !strings.Contains(issue.Message, "peak 412.3%") {
+		t.Errorf("Unexpected issue %+v", issue)
+	}
+
+	mapService.overUtil.now = func() time.Time { return time.Now().Add(overUtilizationTTL) }
+	if report, _ := mapService.Lint(); len(report.Issues) != 0 {
+		t.Errorf("Expected links not seen for a day dropped, got %+v", report.Issues)
+	}
+}
+
+func TestAutoBandwidth(t *testing.T) {
+	for _, tc := range []struct {
+		oids map[string]interface{}
+		want string
+	}{
+		{map[string]interface{}{"in": "1.3.6.1.2.1.31.1.1.1.6.12", "out": "1.3.6.1.2.1.31.1.1.1.10.12"}, "1.3.6.1.2.1.31.1.1.1.15.12"},
+		{map[string]interface{}{"in": ".1.3.6.1.2.1.2.2.1.10.3"}, "1.3.6.1.2.1.31.1.1.1.15.3"},
+		{map[string]interface{}{"in": "1.3.6.1.2.1.31.1.1.1.6.12", "speed": "1.3.6.1.4.1.9.9.1.5"}, "1.3.6.1.4.1.9.9.1.5"},
+		{map[string]interface{}{"in": "1.3.6.1.4.1.2636.3.3.1.1.1.7"}, ""},
+	} {
+		oid, _ := snmpOID(config.InterfaceConfig{Params: map[string]interface{}{"oids": tc.oids}}, SpeedMetric)
+		if oid != tc.want {
+			t.Errorf("Expected speed OID %q for %v, got %q", tc.want, tc.oids, oid)
+		}
+	}
+	for mbps, want := range map[int64]string{100: "100M", 2500: "2500M", 10_000: "10G", 400_000: "400G", 1_600_000: "1600G", 2_000_000: "2T"} {
+		if got := formatSpeed(mbps); got != want {
+			t.Errorf("Expected %d Mbit/s formatted %s, got %s", mbps, want, got)
+		}
+	}
+
+	mapService := NewMapService(t.TempDir())
+	testMap := &config.Map{
+		Title: "auto", Width: 100, Height: 100,
+		Nodes: []config.Node{{Name: "a"}, {Name: "b"}},
+		Links: []config.Link{{Name: "a-b", From: "a", To: "b", Bandwidth: config.BandwidthAuto}},
+		Datasources: []config.DataSourceConfig{{Name: "lab", Type: "mock", Interfaces: []config.InterfaceConfig{
+			{Name: "eth0", Params: map[string]interface{}{"metrics": []interface{}{"in", "out", SpeedMetric}}},
+		}}},
+	}
+	if err := mapService.CreateMap(testMap, "auto"); err == nil || !strings.Contains(err.Error(), "bandwidth auto needs a datasource and an interface") {
+		t.Errorf("Expected auto bandwidth without an interface rejected, got %v", err)
+	}
+	testMap.Links[0].DataSource, testMap.Links[0].Interface, testMap.Links[0].Metrics = "lab", "eth0", []string{"in", "out"}
+	if err := mapService.CreateMap(testMap, "auto"); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+	dsService := NewDataSourceService(testMap.Datasources)
+	dsService.Start()
+	defer dsService.Stop(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := mapService.Warm(ctx, dsService); err != nil {
+		t.Fatalf("Warm failed: %v", err)
+	}
+
+	data, err := mapService.GetMapWithData(context.Background(), "auto", dsService)
+	if err != nil {
+		t.Fatalf("GetMapWithData failed: %v", err)
+	}
+	link := data.LinksData[0]
+	if link.DetectedBandwidth != "1G" {
+		t.Errorf("Expected the speed of the mock interface detected, got %+v", link)
+	}
+	in, out := link.Metrics["in"].(int64), link.Metrics["out"].(int64)
+	if want := math.Round(float64(max(in, out))/125_000_000*1000) / 10; link.Utilization != want {
+		t.Errorf("Expected utilization %g of the detected bandwidth, got %g", want, link.Utilization)
+	}
+	if plan := PlanLoad(data.Map); plan.Links[0].Utilization != 0 {
+		t.Errorf("Expected no planned utilization without a known bandwidth, got %+v", plan.Links[0])
+	}
+}
+
+func TestSecretVariables(t *testing.T) {
+	dir := t.TempDir()
+	mapService := NewMapService(dir)
+	m := &config.Map{
+		Title: "secrets", Width: 100, Height: 100,
+		Variables: config.Variables{"zabbix_password": "hunter2", "Zabbix_API_Token": "abc", "site": "ams1"},
+	}
+	if err := mapService.CreateMap(m, "secrets"); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+
+	variables, err := mapService.GetMapVariables("secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"zabbix_password": config.SecretMask, "Zabbix_API_Token": config.SecretMask, "site": "ams1"}
+	if !maps.Equal(variables, want) {
+		t.Errorf("Expected secrets masked, got %v", variables)
+	}
+	loaded, err := mapService.GetMap("secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(loaded)
+	if strings.Contains(string(body), "hunter2") || strings.Contains(string(body), `"abc"`) {
+		t.Errorf("Expected no secret in the JSON of the map: %s", body)
+	}
+
+	// a map read from the API and written back keeps its secrets
+	var roundTrip config.Map
+	if err := json.Unmarshal(body, &roundTrip); err != nil {
+		t.Fatal(err)
+	}
+	roundTrip.Variables["site"] = "ams2"
+	if _, err := mapService.ReplaceMap("secrets", &roundTrip); err != nil {
+		t.Fatalf("ReplaceMap failed: %v", err)
+	}
+	content, _ := os.ReadFile(filepath.Join(dir, "secrets.yaml"))
+	if !strings.Contains(string(content), "zabbix_password: hunter2") || !strings.Contains(string(content), "site: ams2") {
+		t.Errorf("Expected the stored secrets kept and the other variables changed:\n%s", content)
+	}
+
+	if err := mapService.UpdateMapVariables("secrets", map[string]string{"zabbix_password": "correct-horse"}); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := mapService.loadMapConfig("secrets")
+	if stored.Variables["zabbix_password"] != "correct-horse" {
+		t.Errorf("Expected a new secret value stored, got %v", stored.Variables)
+	}
+
+	config.SetSecretPatterns([]string{"site"})
+	defer config.SetSecretPatterns(config.DefaultSecretPatterns)
+	if variables, _ := mapService.GetMapVariables("secrets"); variables["zabbix_password"] != "correct-horse" {
+		t.Errorf("Expected only the configured patterns masked, got %v", variables)
+	}
+}
+
+func TestEncryptedVariables(t *testing.T) {
+	dir := t.TempDir()
+	plain := "title: plain\nwidth: 100\nheight: 100\nvariables:\n  snmp_community: public\n  site: ams1\n"
+	if err := os.WriteFile(filepath.Join(dir, "plain.yaml"), []byte(plain), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cipher, err := config.NewVariableCipher(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapService := NewMapService(dir)
+	mapService.SetVariablesCipher(cipher)
+
+	// a map with secrets in plaintext is read as it is and encrypted by the next save
+	loaded, err := mapService.loadMapConfig("plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Variables["snmp_community"] != "public" {
+		t.Errorf("Expected the plaintext secret read, got %v", loaded.Variables)
+	}
+	if err := mapService.UpdateMapVariables("plain", map[string]string{"snmp_community": "private", "site": "ams1"}); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := os.ReadFile(filepath.Join(dir, "plain.yaml"))
+	if strings.Contains(string(content), "private") || !strings.Contains(string(content), "snmp_community: enc:v1:") || !strings.Contains(string(content), "site: ams1") {
+		t.Errorf("Expected only the secret encrypted in the file:\n%s", content)
+	}
+	loaded, err = mapService.loadMapConfig("plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Variables["snmp_community"] != "private" {
+		t.Errorf("Expected the secret decrypted, got %v", loaded.Variables)
+	}
+
+	// without the key the value stays encrypted and saving keeps it
+	keyless := NewMapService(dir)
+	loaded, err = keyless.loadMapConfig("plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.IsEncrypted(loaded.Variables["snmp_community"]) {
+		t.Errorf("Expected the secret left encrypted without a key, got %v", loaded.Variables)
+	}
+	if err := keyless.UpdateMapVariables("plain", map[string]string{"snmp_community": config.SecretMask, "site": "ams2"}); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, _ := mapService.loadMapConfig("plain"); loaded.Variables["snmp_community"] != "private" || loaded.Variables["site"] != "ams2" {
+		t.Errorf("Expected the encrypted secret kept by a save without the key, got %v", loaded.Variables)
+	}
+
+	// an encrypted value is bound to its variable
+	sealed, _ := cipher.Encrypt("snmp_community", "private")
+	if _, err := cipher.Decrypt("zabbix_password", sealed); err == nil {
+		t.Error("Expected a value decrypted for another variable to fail")
+	}
+	t.Setenv("WEATHERMAP_VARIABLES_KEY", "c2hvcnQ=")
+	if _, err := VariablesCipherFromEnv(); err == nil {
+		t.Error("Expected a short key rejected")
+	}
+}
+
+func TestMapBundle(t *testing.T) {
+	source := NewMapService(t.TempDir())
+	source.SetIconsDir(t.TempDir())
+	custom := []byte(`<svg xmlns="http://www.w3.org/2000/svg"><rect width="8" height="8"/></svg>`)
+	if err := os.WriteFile(filepath.Join(source.iconsDir, "custom.svg"), custom, 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := &config.Map{
+		Title: "bundle", Width: 100, Height: 100,
+		Nodes: []config.Node{
+			{Name: "a", Icon: "custom.svg", Position: config.Position{X: 10, Y: 10}},
+			{Name: "b", Icon: "router.svg", Position: config.Position{X: 50, Y: 50}},
+			{Name: "c", Icon: "gone.svg", Position: config.Position{X: 90, Y: 90}},
+		},
+		Variables: config.Variables{"snmp_password": "hunter2"},
+	}
+	if err := source.CreateMap(m, "bundle"); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+	content, err := source.ExportBundle("bundle")
+	if err != nil {
+		t.Fatalf("ExportBundle failed: %v", err)
+	}
+	if !IsBundle(content) {
+		t.Fatal("Expected a zip")
+	}
+	bundle, err := ReadBundle(content)
+	if err != nil {
+		t.Fatalf("ReadBundle failed: %v", err)
+	}
+	if len(bundle.Icons) != 2 || !bytes.Equal(bundle.Icons["custom.svg"], custom) || bundle.Icons["router.svg"] == nil {
+		t.Errorf("Expected the custom and the embedded icon, got %v", slices.Collect(maps.Keys(bundle.Icons)))
+	}
+	if secret := bundle.Map.Variables["snmp_password"]; secret == "hunter2" || bytes.Contains(content, []byte("hunter2")) {
+		t.Errorf("Expected secret variables masked in the bundle, got %q", secret)
+	}
+
+	tarGz, err := source.ExportBundleTarGz("bundle")
+	if err != nil || !IsBundle(tarGz) {
+		t.Fatalf("ExportBundleTarGz failed: %v", err)
+	}
+	if fromTar, err := ReadBundle(tarGz); err != nil || len(fromTar.Icons) != 2 || fromTar.Map.ID != bundle.Map.ID {
+		t.Errorf("Expected the tar.gz to hold the files of the zip, got %v", err)
+	}
+
+	target := NewMapService(t.TempDir())
+	target.SetIconsDir(filepath.Join(t.TempDir(), "icons"))
+	created, err := target.ImportBundle("moved", bundle)
+	if err != nil || !created {
+		t.Fatalf("ImportBundle failed: created %v, %v", created, err)
+	}
+	if icon, _, err := target.GetIconFile("custom.svg"); err != nil || !bytes.Equal(icon, custom) {
+		t.Errorf("Expected the custom icon added to the icons directory, got %q, %v", icon, err)
+	}
+	if moved, err := target.GetMap("moved"); err != nil || len(moved.Nodes) != 3 || moved.ID != bundle.Map.ID {
+		t.Errorf("Expected the map imported as it was, got %+v, %v", moved, err)
+	}
+
+	// an icon of the server with other content would change its other maps
+	bundle.Icons["custom.svg"] = []byte("<svg/>")
+	if _, err := target.ImportBundle("moved", bundle); err == nil || !strings.Contains(err.Error(), "icon custom.svg already exists") {
+		t.Errorf("Expected an icon conflict, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("icons/../../escape.svg")
+	_, _ = w.Write(custom)
+	_ = zw.Close()
+	if _, err := ReadBundle(buf.Bytes()); err == nil || !strings.Contains(err.Error(), "invalid bundle") {
+		t.Errorf("Expected an icon outside of icons/ rejected, got %v", err)
+	}
+}
+
+func TestRenameNode(t *testing.T) {
+	mapService := NewMapService(t.TempDir())
+	m := &config.Map{
+		Title: "lab", Width: 100, Height: 100,
+		Nodes: []config.Node{{Name: "a"}, {Name: "b"}, {Name: "c"}},
+		Links: []config.Link{
+			{Name: "a-b", From: "a", To: "b", Bandwidth: "1G"},
+			{Name: "c-a", From: "c", To: "a", Bandwidth: "1G"},
+		},
+		Demands: []config.Demand{{From: "a", To: "c", Rate: "100M"}},
+	}
+	if err := mapService.CreateMap(m, "lab"); err != nil {
+		t.Fatalf("Failed to create map: %v", err)
+	}
+
+	if err := mapService.EditNode("lab", "a", map[string]any{"name": "core", "label": "Core"}); err != nil {
+		t.Fatalf("Expected the node renamed, got %v", err)
+	}
+	renamed, err := mapService.GetMap("lab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renamed.Nodes[0].Name != "core" || renamed.Nodes[0].Label != "Core" {
+		t.Errorf("Expected node a renamed to core, got %+v", renamed.Nodes[0])
+	}
+	if renamed.Links[0].From != "core" || renamed.Links[1].To != "core" || renamed.Demands[0].From != "core" {
+		t.Errorf("Expected the links and demands of a to follow the rename, got %+v %+v", renamed.Links, renamed.Demands)
+	}
+
+	for name, want := range map[string]string{"b": "already exists", "": "invalid node name"} {
+		err := mapService.EditNode("lab", "core", map[string]any{"name": name})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected renaming to %q refused with %q, got %v", name, want, err)
+		}
+	}
+
+	err = mapService.EditNodesBulk("lab", []NodeUpdate{
+		{Name: "core", Updates: map[string]any{"name": "edge"}},
+		{Name: "missing", Updates: map[string]any{}},
+	})
+	if err == nil {
+		t.Fatal("Expected the bulk edit with a missing node to fail")
+	}
+	if unchanged, _ := mapService.GetMap("lab"); unchanged.Nodes[0].Name != "core" || unchanged.Links[0].From != "core" {
+		t.Errorf("Expected nothing renamed when the bulk edit fails, got %+v", unchanged.Links[0])
+	}
+}
+
+func TestLinkScaleNames(t *testing.T) {
+	mapService := NewMapService(t.TempDir())
+	m := &config.Map{
+		Title: "lab", Width: 100, Height: 100,
+		Nodes:  []config.Node{{Name: "a"}, {Name: "b"}},
+		Links:  []config.Link{{Name: "a-b", From: "a", To: "b", Bandwidth: "1G", Scale: "core", CommitScale: "commit"}},
+		Scales: map[string][]config.Scale{"core": {{Min: 0, Max: 100, Color: config.Color{G: 128}}}},
+	}
+	if err := mapService.CreateMap(m, "lab"); err != nil {
+		t.Fatalf("Expected scales of the map and built-in ones to be valid, got %v", err)
+	}
+
+	err := mapService.EditLink("lab", "a-b", map[string]any{"scale": "cor"})
+	if err == nil || !strings.Contains(err.Error(), "unknown scale cor") {
+		t.Errorf("Expected a misspelled scale to be refused, got %v", err)
+	}
+	var bulkErr *BulkEditError
+	err = mapService.EditLinksBulk("lab", []LinkUpdate{{Name: "a-b", Updates: map[string]any{"commit_scale": "comit"}}})
+	if !errors.As(err, &bulkErr) || !strings.Contains(bulkErr.Items[0].Error, "unknown commit_scale comit") {
+		t.Errorf("Expected a misspelled commit_scale to be refused in bulk edits, got %v", err)
+	}
+
+	content := "title: lab\nwidth: 100\nheight: 100\nnodes:\n  - name: a\n  - name: b\nlinks:\n  - name: a-b\n    from: a\n    to: b\n    bandwidth: 1G\n    scale: cor\n"
+	if err := os.WriteFile(filepath.Join(mapService.ConfigDir(), "typo.yaml"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, _ := ValidateConfigDir(mapService.ConfigDir())
+	if len(report.Issues) != 1 || report.Issues[0].File != "typo.yaml" || !strings.Contains(report.Issues[0].Message, "unknown scale cor") {
+		t.Errorf("Expected the misspelled scale reported on load, got %+v", report.Issues)
+	}
+}
@@ -0,0 +1,360 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/datasource"
+)
+
+const netBoxSyncTimeout = time.Minute
+
+// NetBoxConfig is the NetBox instance the maps with a netbox section are synced from
+type NetBoxConfig struct {
+	URL          string
+	Token        string
+	SyncInterval time.Duration // 0 syncs on request only
+}
+
+// NetBoxConfigFromEnv reads WEATHERMAP_NETBOX_* variables, ok is false when no url is set
+func NetBoxConfigFromEnv() (cfg NetBoxConfig, ok bool, err error) {
+	cfg.URL = os.Getenv("WEATHERMAP_NETBOX_URL")
+	if cfg.URL == "" {
+		return cfg, false, nil
+	}
+	if u, err := url.Parse(cfg.URL); err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return cfg, false, fmt.Errorf("invalid WEATHERMAP_NETBOX_URL: %s", cfg.URL)
+	}
+	cfg.Token = os.Getenv("WEATHERMAP_NETBOX_TOKEN")
+	if value := os.Getenv("WEATHERMAP_NETBOX_SYNC_INTERVAL"); value != "" {
+		if cfg.SyncInterval, err = time.ParseDuration(value); err != nil || cfg.SyncInterval < 0 {
+			return cfg, false, fmt.Errorf("invalid WEATHERMAP_NETBOX_SYNC_INTERVAL: %s", value)
+		}
+	}
+	return cfg, true, nil
+}
+
+// EnableNetBox lets maps be synced from the NetBox of cfg
+func (s *MapService) EnableNetBox(cfg NetBoxConfig) {
+	s.netbox = datasource.NewNetBoxClient(datasource.NewHTTPClient(datasource.DefaultHTTPPoolConfig()), cfg.URL, cfg.Token)
+}
+
+// NetBoxSyncResult is what a sync changes in a map, saved only when Applied. Nodes and links
+// are listed by name.
+type NetBoxSyncResult struct {
+	Map          string   `json:"map"`
+	Applied      bool     `json:"applied"`
+	NodesAdded   []string `json:"nodes_added"`
+	NodesUpdated []string `json:"nodes_updated"` // renamed or readdressed in NetBox
+	NodesRemoved []string `json:"nodes_removed"`
+	LinksAdded   []string `json:"links_added"`
+	LinksUpdated []string `json:"links_updated"` // moved to other nodes
+	LinksRemoved []string `json:"links_removed"`
+	Conflicts    []string `json:"conflicts"` // NetBox objects skipped or kept for manual objects
+}
+
+func (r *NetBoxSyncResult) changed() bool {
+	return len(r.NodesAdded)+len(r.NodesUpdated)+len(r.NodesRemoved)+len(r.LinksAdded)+len(r.LinksUpdated)+len(r.LinksRemoved) > 0
+}
+
+// netBoxNode is a device or site a node is synced from
+type netBoxNode struct {
+	id      string // SourceID, like dcim.device:12
+	name    string
+	address string
+}
+
+// netBoxLink is a cable or circuit between two synced nodes, or all of them between two sites
+type netBoxLink struct {
+	id               string
+	from, to         string // SourceID of the nodes
+	fromPort, toPort string
+	speed            int64 // Mbps, 0 when unknown
+}
+
+// SyncNetBox compares a map with the NetBox objects selected by its netbox section: nodes and
+// links synced before are matched by their NetBox id, missing ones are added and the ones gone
+// from NetBox removed. Only names and management addresses follow NetBox, positions and any
+// other edit are kept. Nodes and links without the netbox source are never changed. The map is
+// saved only when apply is set.
+func (s *MapService) SyncNetBox(ctx context.Context, mapName string, dsService *DataSourceService, apply bool) (*NetBoxSyncResult, error) {
+	return s.syncNetBox(ctx, mapName, dsService, apply, "")
+}
+
+// syncNetBox is SyncNetBox, an applied sync is recorded in the audit log as made by actor
+// unless it is empty, for the API recording its own
+func (s *MapService) syncNetBox(ctx context.Context, mapName string, dsService *DataSourceService, apply bool, actor string) (*NetBoxSyncResult, error) {
+	if s.netbox == nil {
+		return nil, errors.New("netbox sync is not enabled, set WEATHERMAP_NETBOX_URL")
+	}
+	mapConfig, err := s.loadMapConfig(mapName)
+	if err != nil {
+		return nil, err
+	}
+	if mapConfig.NetBox == nil {
+		return nil, fmt.Errorf("invalid netbox sync: map %s has no netbox section", mapName)
+	}
+	ctx, cancel := context.WithTimeout(ctx, netBoxSyncTimeout)
+	defer cancel()
+	nodes, links, err := s.readNetBox(ctx, mapConfig.NetBox)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &NetBoxSyncResult{
+		Map:          mapName,
+		NodesAdded:   []string{},
+		NodesUpdated: []string{},
+		NodesRemoved: []string{},
+		LinksAdded:   []string{},
+		LinksUpdated: []string{},
+		LinksRemoved: []string{},
+		Conflicts:    []string{},
+	}
+	lookup := func(string) (config.DataSourceConfig, bool) { return config.DataSourceConfig{}, false }
+	if dsService != nil {
+		lookup = dsService.snmpDataSource
+	}
+	planNetBox(mapConfig, nodes, links, result, lookup)
+
+	if !apply || !result.changed() {
+		return result, s.prepareMap(mapName, mapConfig)
+	}
+	if actor != "" {
+		err = s.saveMapAs(actor, mapName, mapConfig)
+	} else {
+		err = s.saveMap(mapName, mapConfig)
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.Applied = true
+	return result, nil
+}
+
+// readNetBox reads the nodes and links selected by sel: a node by device and a link by cable or
+// circuit between two of them, or a node by site and a link by pair of connected sites
+func (s *MapService) readNetBox(ctx context.Context, sel *config.MapNetBox) ([]netBoxNode, []netBoxLink, error) {
+	devices, err := s.netbox.Devices(ctx, datasource.NetBoxFilter{Sites: sel.Sites, Roles: sel.Roles, Tags: sel.Tags})
+	if err != nil {
+		return nil, nil, err
+	}
+	interfaces, err := s.netbox.CabledInterfaces(ctx, sel.Sites)
+	if err != nil {
+		return nil, nil, err
+	}
+	selected := make(map[int]datasource.NetBoxDevice)
+	var nodes []netBoxNode
+	for _, device := range devices {
+		if device.Name == "" { // unnamed devices can't be told apart on a map
+			continue
+		}
+		selected[device.ID] = device
+		if sel.Nodes != config.NetBoxSites {
+			nodes = append(nodes, netBoxNode{id: "dcim.device:" + strconv.Itoa(device.ID), name: device.Name, address: device.Address})
+		} else if !slices.ContainsFunc(nodes, func(n netBoxNode) bool { return n.id == "dcim.site:"+strconv.Itoa(device.Site.ID) }) {
+			nodes = append(nodes, netBoxNode{id: "dcim.site:" + strconv.Itoa(device.Site.ID), name: device.Site.Name})
+		}
+	}
+
+	// both ends of a connection are listed, it's kept from the interface of lower id
+	var links []netBoxLink
+	for _, iface := range interfaces {
+		from, ok := selected[iface.Device.ID]
+		if !ok || iface.Cable == nil {
+			continue
+		}
+		for _, peer := range iface.Connected {
+			to, ok := selected[peer.Device.ID]
+			if !ok || to.ID == from.ID || peer.ID < iface.ID {
+				continue
+			}
+			link := netBoxLink{
+				id:       "dcim.cable:" + strconv.Itoa(iface.Cable.ID),
+				from:     "dcim.device:" + strconv.Itoa(from.ID),
+				fromPort: iface.Name,
+				to:       "dcim.device:" + strconv.Itoa(to.ID),
+				toPort:   peer.Name,
+				speed:    min(cmp.Or(iface.Speed, peer.Speed), cmp.Or(peer.Speed, iface.Speed)) / 1000, // the slower known one
+			}
+			if iface.Circuit != nil {
+				link.id = "circuits.circuit:" + strconv.Itoa(iface.Circuit.ID)
+			}
+			if sel.Nodes != config.NetBoxSites {
+				links = append(links, link)
+				continue
+			}
+			if from.Site.ID == to.Site.ID {
+				continue
+			}
+			low, high := min(from.Site.ID, to.Site.ID), max(from.Site.ID, to.Site.ID)
+			id := fmt.Sprintf("dcim.site:%d-%d", low, high)
+			if i := slices.IndexFunc(links, func(l netBoxLink) bool { return l.id == id }); i >= 0 {
+				links[i].speed += link.speed
+				continue
+			}
+			links = append(links, netBoxLink{id: id, from: "dcim.site:" + strconv.Itoa(low), to: "dcim.site:" + strconv.Itoa(high), speed: link.speed})
+		}
+	}
+	return nodes, links, nil
+}
+
+// planNetBox applies the NetBox nodes and links to m, recording the changes in result
+func planNetBox(m *config.Map, nodes []netBoxNode, links []netBoxLink, result *NetBoxSyncResult, lookup func(name string) (config.DataSourceConfig, bool)) {
+	synced := func(source, id string) bool { return source == config.SourceNetBox && id != "" }
+
+	names := make(map[string]string) // SourceID -> node name
+	var added []int
+	for _, obj := range nodes {
+		i := slices.IndexFunc(m.Nodes, func(n config.Node) bool { return synced(n.Source, n.SourceID) && n.SourceID == obj.id })
+		if i < 0 {
+			if slices.ContainsFunc(m.Nodes, func(n config.Node) bool { return n.Name == obj.name }) {
+				result.Conflicts = append(result.Conflicts, fmt.Sprintf("%s skipped, node %s is not synced from netbox", obj.id, obj.name))
+				continue
+			}
+			m.Nodes = append(m.Nodes, config.Node{Name: obj.name, ManagementIP: obj.address, Source: config.SourceNetBox, SourceID: obj.id})
+			added = append(added, len(m.Nodes)-1)
+			result.NodesAdded = append(result.NodesAdded, obj.name)
+			names[obj.id] = obj.name
+			continue
+		}
+		updated := false
+		if m.Nodes[i].Name != obj.name {
+			if err := renameNode(m, i, map[string]any{"name": obj.name}); err != nil {
+				result.Conflicts = append(result.Conflicts, fmt.Sprintf("%s not renamed to %s: %v", obj.id, obj.name, err))
+			} else {
+				updated = true
+			}
+		}
+		if obj.address != "" && m.Nodes[i].ManagementIP != obj.address {
+			m.Nodes[i].ManagementIP = obj.address
+			updated = true
+		}
+		if updated {
+			result.NodesUpdated = append(result.NodesUpdated, m.Nodes[i].Name)
+		}
+		names[obj.id] = m.Nodes[i].Name
+	}
+	positions := circlePositions(m, len(added))
+	for j, i := range added {
+		m.Nodes[i].Position = positions[j]
+	}
+
+	taken := make(map[string]bool)
+	for _, link := range m.Links {
+		taken[link.Name] = true
+	}
+	seen := make(map[string]bool)
+	for _, obj := range links {
+		from, okFrom := names[obj.from]
+		to, okTo := names[obj.to]
+		if !okFrom || !okTo || seen[obj.id] {
+			continue
+		}
+		seen[obj.id] = true
+		i := slices.IndexFunc(m.Links, func(l config.Link) bool { return synced(l.Source, l.SourceID) && l.SourceID == obj.id })
+		if i >= 0 {
+			link := &m.Links[i]
+			if !(link.From == from && link.To == to || link.From == to && link.To == from) {
+				link.From, link.To = from, to
+				result.LinksUpdated = append(result.LinksUpdated, link.Name)
+			}
+			continue
+		}
+		edge := linkDataSource(m, DiscoveredLink{From: from, FromPort: obj.fromPort, To: to, ToPort: obj.toPort}, lookup)
+		link := config.Link{
+			Name:       uniqueName(from+"-"+to, taken),
+			From:       edge.From,
+			To:         edge.To,
+			DataSource: edge.DataSource,
+			Interface:  edge.Interface,
+			Source:     config.SourceNetBox,
+			SourceID:   obj.id,
+		}
+		switch {
+		case obj.speed > 0:
+			link.Bandwidth = formatSpeed(obj.speed)
+		case link.Interface != "":
+			link.Bandwidth = config.BandwidthAuto
+		case m.Defaults == nil || m.Defaults.Link == nil || m.Defaults.Link.Bandwidth == "":
+			link.Bandwidth = defaultDiscoveredBandwidth
+		}
+		taken[link.Name] = true
+		m.Links = append(m.Links, link)
+		result.LinksAdded = append(result.LinksAdded, link.Name)
+	}
+
+	remaining := m.Links[:0]
+	for _, link := range m.Links {
+		if synced(link.Source, link.SourceID) && !seen[link.SourceID] {
+			result.LinksRemoved = append(result.LinksRemoved, link.Name)
+			continue
+		}
+		remaining = append(remaining, link)
+	}
+	m.Links = remaining
+
+	// nodes gone from NetBox stay while manual links or demands use them
+	kept := m.Nodes[:0]
+	for _, node := range m.Nodes {
+		_, found := names[node.SourceID]
+		if !synced(node.Source, node.SourceID) || found {
+			kept = append(kept, node)
+			continue
+		}
+		if i := slices.IndexFunc(m.Links, func(l config.Link) bool { return l.From == node.Name || l.To == node.Name }); i >= 0 {
+			result.Conflicts = append(result.Conflicts, fmt.Sprintf("%s gone from netbox, node %s kept for link %s", node.SourceID, node.Name, m.Links[i].Name))
+			kept = append(kept, node)
+			continue
+		}
+		if slices.ContainsFunc(m.Demands, func(d config.Demand) bool { return d.From == node.Name || d.To == node.Name }) {
+			result.Conflicts = append(result.Conflicts, fmt.Sprintf("%s gone from netbox, node %s kept for a demand", node.SourceID, node.Name))
+			kept = append(kept, node)
+			continue
+		}
+		result.NodesRemoved = append(result.NodesRemoved, node.Name)
+	}
+	m.Nodes = kept
+}
+
+// WatchNetBox syncs the maps with a netbox section now and then every interval until Stop
+func (s *MapService) WatchNetBox(dsService *DataSourceService, interval time.Duration) {
+	s.loops.run(func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			mapNames, _ := s.ListMaps()
+			for _, mapName := range mapNames {
+				if m, err := s.loadMapConfig(mapName); err != nil || m.NetBox == nil {
+					continue
+				}
+				result, err := s.syncNetBox(ctx, mapName, dsService, true, ActorNetBox)
+				if err != nil {
+					s.logger.Error("netbox sync failed", "map", mapName, "error", err)
+					continue
+				}
+				if result.Applied {
+					s.logger.Info("netbox sync applied", "map", mapName,
+						"nodes_added", len(result.NodesAdded), "nodes_removed", len(result.NodesRemoved),
+						"links_added", len(result.LinksAdded), "links_removed", len(result.LinksRemoved))
+				}
+				for _, conflict := range result.Conflicts {
+					s.logger.Warn("netbox sync conflict", "map", mapName, "conflict", conflict)
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
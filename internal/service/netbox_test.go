@@ -0,0 +1,277 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go-weathermap/internal/config"
+)
+
+// fakeNetBox serves devices and cabled interfaces, devices on two pages
+type fakeNetBox struct {
+	mu         sync.Mutex
+	devices    []map[string]any
+	interfaces []map[string]any
+	queries    []string
+}
+
+func netBoxDevice(id int, name string, siteID int, site, address string) map[string]any {
+	return map[string]any{
+		"id": id, "name": name,
+		"site":       map[string]any{"id": siteID, "name": strings.ToUpper(site), "slug": site},
+		"primary_ip": map[string]any{"id": id + 100, "address": address + "/32"},
+	}
+}
+
+func netBoxInterface(id int, name string, deviceID, cableID, circuitID int, speed int64, peerID int, peerName string, peerDeviceID int) map[string]any {
+	iface := map[string]any{
+		"id": id, "name": name, "speed": speed,
+		"device":                   map[string]any{"id": deviceID},
+		"cable":                    map[string]any{"id": cableID},
+		"link_peers_type":          "dcim.interface",
+		"link_peers":               []any{map[string]any{"id": peerID}},
+		"connected_endpoints_type": "dcim.interface",
+		"connected_endpoints":      []any{map[string]any{"id": peerID, "name": peerName, "device": map[string]any{"id": peerDeviceID}}},
+	}
+	if circuitID > 0 {
+		iface["link_peers_type"] = "circuits.circuittermination"
+		iface["link_peers"] = []any{map[string]any{"id": circuitID * 10, "circuit": map[string]any{"id": circuitID, "cid": "CID-1"}}}
+	}
+	return iface
+}
+
+func newFakeNetBox(t *testing.T) (*fakeNetBox, string) {
+	t.Helper()
+	fake := &fakeNetBox{
+		devices: []map[string]any{
+			netBoxDevice(1, "core-1", 10, "dc1", "10.0.0.1"),
+			netBoxDevice(2, "access-1", 10, "dc1", "10.0.0.2"),
+			netBoxDevice(3, "edge-1", 20, "dc2", "10.0.1.1"),
+		},
+		interfaces: []map[string]any{
+			netBoxInterface(11, "Gi0/1", 1, 100, 0, 10000000, 21, "Gi1/0/48", 2),
+			netBoxInterface(12, "Gi0/2", 1, 101, 5, 1000000, 31, "ge-0/0/0", 3),
+			netBoxInterface(21, "Gi1/0/48", 2, 100, 0, 10000000, 11, "Gi0/1", 1),
+			netBoxInterface(31, "ge-0/0/0", 3, 102, 5, 1000000, 12, "Gi0/2", 1),
+		},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Token secret" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"detail": "Invalid token"}`))
+			return
+		}
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		fake.queries = append(fake.queries, r.URL.Path+"?"+r.URL.RawQuery)
+		page := map[string]any{"next": nil}
+		switch r.URL.Path {
+		case "/api/dcim/devices/":
+			// the first device on the first page, the others on the second
+			if r.URL.Query().Get("offset") == "" {
+				next := *r.URL
+				next.Scheme, next.Host = "http", r.Host
+				query := next.Query()
+				query.Set("offset", "1")
+				next.RawQuery = query.Encode()
+				page["next"], page["results"] = next.String(), fake.devices[:1]
+			} else {
+				page["results"] = fake.devices[1:]
+			}
+		case "/api/dcim/interfaces/":
+			page["results"] = fake.interfaces
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(page)
+	}))
+	t.Cleanup(server.Close)
+	return fake, server.URL
+}
+
+func TestSyncNetBox(t *testing.T) {
+	fake, url := newFakeNetBox(t)
+	mapService := NewMapService(t.TempDir())
+	mapService.EnableNetBox(NetBoxConfig{URL: url, Token: "secret"})
+	if err := mapService.CreateMap(&config.Map{
+		Title: "dc", Width: 1000, Height: 600,
+		NetBox: &config.MapNetBox{Sites: []string{"dc1", "dc2"}, Roles: []string{"router"}},
+		Nodes:  []config.Node{{Name: "edge-1", Position: config.Position{X: 10, Y: 10}}},
+	}, "dc"); err != nil {
+		t.Fatal(err)
+	}
+
+	preview, err := mapService.SyncNetBox(context.Background(), "dc", nil, false)
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if !slices.Equal(preview.NodesAdded, []string{"core-1", "access-1"}) || !slices.Equal(preview.LinksAdded, []string{"core-1-access-1"}) {
+		t.Fatalf("Expected core-1, access-1 and their cable added, got %+v", preview)
+	}
+	// edge-1 is a manual node, it and its circuit are left alone
+	if len(preview.Conflicts) != 1 || !strings.Contains(preview.Conflicts[0], "dcim.device:3") {
+		t.Errorf("Expected a conflict for edge-1, got %v", preview.Conflicts)
+	}
+	if !strings.Contains(fake.queries[0], "site=dc1&site=dc2") || !strings.Contains(fake.queries[0], "role=router") {
+		t.Errorf("Expected the devices filtered by site and role, got %s", fake.queries[0])
+	}
+	if m, _ := mapService.GetMap("dc"); preview.Applied || len(m.Nodes) != 1 {
+		t.Fatal("Expected the preview not to change the map")
+	}
+
+	if _, err := mapService.SyncNetBox(context.Background(), "dc", nil, true); err != nil {
+		t.Fatal(err)
+	}
+	m, _ := mapService.GetMap("dc")
+	core := m.Nodes[slices.IndexFunc(m.Nodes, func(n config.Node) bool { return n.Name == "core-1" })]
+	if core.Source != config.SourceNetBox || core.SourceID != "dcim.device:1" || core.ManagementIP != "10.0.0.1" {
+		t.Errorf("Expected core-1 tagged with its netbox id, got %+v", core)
+	}
+	if link := m.Links[0]; link.SourceID != "dcim.cable:100" || link.Bandwidth != "10G" {
+		t.Errorf("Expected the cable synced at 10G, got %+v", link)
+	}
+
+	// moved and relabeled on the map, renamed in NetBox, access-1 decommissioned
+	for i := range m.Nodes {
+		if m.Nodes[i].Name == "core-1" {
+			m.Nodes[i].Position = config.Position{X: 500, Y: 50}
+			m.Nodes[i].Label = "Core"
+		}
+	}
+	if _, err := mapService.ReplaceMap("dc", m); err != nil {
+		t.Fatal(err)
+	}
+	fake.mu.Lock()
+	fake.devices[0]["name"] = "core-01"
+	fake.devices = slices.Delete(fake.devices, 1, 2)
+	fake.interfaces = fake.interfaces[1:2]
+	fake.mu.Unlock()
+
+	again, err := mapService.SyncNetBox(context.Background(), "dc", nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(again.NodesUpdated, []string{"core-01"}) || !slices.Equal(again.NodesRemoved, []string{"access-1"}) || !slices.Equal(again.LinksRemoved, []string{"core-1-access-1"}) {
+		t.Errorf("Expected core-1 renamed and access-1 removed, got %+v", again)
+	}
+	m, _ = mapService.GetMap("dc")
+	if len(m.Nodes) != 2 || len(m.Links) != 0 {
+		t.Fatalf("Expected edge-1 and core-01 left, got %+v %+v", m.Nodes, m.Links)
+	}
+	core = m.Nodes[slices.IndexFunc(m.Nodes, func(n config.Node) bool { return n.SourceID == "dcim.device:1" })]
+	if core.Name != "core-01" || core.Position != (config.Position{X: 500, Y: 50}) || core.Label != "Core" {
+		t.Errorf("Expected the position and label kept on rename, got %+v", core)
+	}
+}
+
+func TestWatchNetBoxAudit(t *testing.T) {
+	fake, url := newFakeNetBox(t)
+	mapService := NewMapService(t.TempDir())
+	mapService.EnableNetBox(NetBoxConfig{URL: url, Token: "secret"})
+	if err := mapService.CreateMap(&config.Map{
+		Title: "dc", Width: 1000, Height: 600,
+		NetBox: &config.MapNetBox{Sites: []string{"dc1", "dc2"}, Roles: []string{"router"}},
+	}, "dc"); err != nil {
+		t.Fatal(err)
+	}
+
+	mapService.WatchNetBox(nil, time.Hour)
+	deadline := time.Now().Add(5 * time.Second)
+	var entries []AuditEntry
+	for time.Now().Before(deadline) && len(entries) == 0 {
+		time.Sleep(20 * time.Millisecond)
+		entries, _ = mapService.AuditEntries(AuditFilter{Map: "dc"})
+	}
+	if err := mapService.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Actor != ActorNetBox || entries[0].Action != AuditUpdate {
+		t.Fatalf("Expected one audit entry of the scheduled sync, got %+v", entries)
+	}
+	var added []string
+	for _, c := range entries[0].Changes {
+		if c.Op == "added" {
+			added = append(added, c.Object+" "+c.Name)
+		}
+	}
+	if !slices.Equal(added, []string{"node core-1", "node access-1", "node edge-1", "link core-1-access-1", "link core-1-edge-1"}) {
+		t.Errorf("Expected the synced nodes, cable and circuit in the audit entry, got %+v", entries[0].Changes)
+	}
+
+	// the API records the syncs it applies itself
+	fake.mu.Lock()
+	fake.devices[0]["name"] = "core-01"
+	fake.mu.Unlock()
+	if result, err := mapService.SyncNetBox(context.Background(), "dc", nil, true); err != nil || !result.Applied {
+		t.Fatalf("Expected the rename applied, got %+v %v", result, err)
+	}
+	if entries, _ := mapService.AuditEntries(AuditFilter{Map: "dc"}); len(entries) != 1 {
+		t.Errorf("Expected no audit entry of SyncNetBox, got %d entries", len(entries))
+	}
+}
+
+func TestSyncNetBoxSites(t *testing.T) {
+	_, url := newFakeNetBox(t)
+	mapService := NewMapService(t.TempDir())
+	mapService.EnableNetBox(NetBoxConfig{URL: url, Token: "secret"})
+	if err := mapService.CreateMap(&config.Map{Title: "wan", Width: 1000, Height: 600, NetBox: &config.MapNetBox{Nodes: config.NetBoxSites}}, "wan"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mapService.SyncNetBox(context.Background(), "wan", nil, true); err != nil {
+		t.Fatal(err)
+	}
+	m, _ := mapService.GetMap("wan")
+	if len(m.Nodes) != 2 || m.Nodes[0].Name != "DC1" || m.Nodes[1].SourceID != "dcim.site:20" {
+		t.Fatalf("Expected a node by site, got %+v", m.Nodes)
+	}
+	// the cable inside dc1 isn't a link, the circuit to dc2 is
+	if len(m.Links) != 1 || m.Links[0].SourceID != "dcim.site:10-20" || m.Links[0].Bandwidth != "1G" {
+		t.Errorf("Expected one link between the sites, got %+v", m.Links)
+	}
+}
+
+func TestSyncNetBoxErrors(t *testing.T) {
+	_, url := newFakeNetBox(t)
+	mapService := NewMapService(t.TempDir())
+	if err := mapService.CreateMap(&config.Map{Title: "plain", Width: 100, Height: 100}, "plain"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mapService.CreateMap(&config.Map{Title: "dc", Width: 100, Height: 100, NetBox: &config.MapNetBox{}}, "dc"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mapService.SyncNetBox(context.Background(), "dc", nil, false); err == nil || !strings.Contains(err.Error(), "not enabled") {
+		t.Errorf("Expected not enabled error, got %v", err)
+	}
+
+	mapService.EnableNetBox(NetBoxConfig{URL: url, Token: "wrong"})
+	testCases := []struct {
+		name    string
+		mapName string
+		want    string
+	}{
+		{"UnknownMap", "missing", "not found"},
+		{"NoSection", "plain", "no netbox section"},
+		{"Token", "dc", "Invalid token"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := mapService.SyncNetBox(context.Background(), tc.mapName, nil, false)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("Expected error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+
+	t.Setenv("WEATHERMAP_NETBOX_URL", "netbox.example.com")
+	if _, _, err := NetBoxConfigFromEnv(); err == nil {
+		t.Error("Expected error for a url without scheme")
+	}
+}
@@ -0,0 +1,249 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/datasource"
+	"go-weathermap/internal/utils"
+)
+
+const (
+	DefaultPingInterval = 30 * time.Second
+	pingTimeout         = 2 * time.Second
+	pingAttempts        = 3 // a node is down when every echo request of a round is lost
+	pingWorkers         = 32
+)
+
+// PingIntervalFromEnv reads WEATHERMAP_PING_INTERVAL, 0 disables the node status checks
+func PingIntervalFromEnv() (time.Duration, error) {
+	value := os.Getenv("WEATHERMAP_PING_INTERVAL")
+	if value == "" {
+		return DefaultPingInterval, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < 0 {
+		return 0, fmt.Errorf("invalid WEATHERMAP_PING_INTERVAL: %s", value)
+	}
+	return interval, nil
+}
+
+// nodeStatus keeps the result of the last round of pings by node address, and of the last
+// read of Zabbix hosts
+type nodeStatus struct {
+	mu          sync.RWMutex
+	enabled     bool
+	results     map[string]config.NodeData // Name is left empty, addresses are shared by maps
+	zabbixHosts map[config.NodeZabbix]config.NodeData
+	ping        func(ctx context.Context, host string, timeout time.Duration) (time.Duration, error)
+	zabbix      zabbixHostReader
+}
+
+func newNodeStatus() *nodeStatus {
+	return &nodeStatus{
+		results:     make(map[string]config.NodeData),
+		zabbixHosts: make(map[config.NodeZabbix]config.NodeData),
+		ping:        datasource.Ping,
+		zabbix:      datasource.NewZabbixClient(datasource.NewHTTPClient(datasource.DefaultHTTPPoolConfig())),
+	}
+}
+
+// nodesData returns the status of the nodes of m with an address or a Zabbix host, nil when
+// the checks are disabled. Zabbix maintenance wins over pings, nodes are expected to be down.
+func (p *nodeStatus) nodesData(m *config.Map) []config.NodeData {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if !p.enabled {
+		return nil
+	}
+	var data []config.NodeData
+	for _, node := range m.Nodes {
+		if node.Address == "" && node.Zabbix == nil {
+			continue
+		}
+		result := config.NodeData{Status: "unknown"}
+		if pinged, ok := p.results[node.Address]; ok {
+			result = pinged
+		}
+		if node.Zabbix != nil {
+			if host, ok := p.zabbixHosts[*node.Zabbix]; ok && (node.Address == "" || host.Status == "maintenance") {
+				result = host
+			}
+		}
+		result.Name = node.Name
+		data = append(data, result)
+	}
+	return data
+}
+
+// nodesData adds the nodes with an SNMP datasource to the ones checked by pings and Zabbix,
+// which decide the status of the nodes having both, the device only adds its uptime. Nodes
+// with metrics get their values, or the label of the value for metrics with states.
+func (s *MapService) nodesData(ctx context.Context, mapName string, m *config.Map, dsService *DataSourceService) []config.NodeData {
+	data := s.nodeStatus.nodesData(m)
+	if dsService == nil {
+		return data
+	}
+	for _, node := range m.Nodes {
+		if node.DataSource == "" {
+			continue
+		}
+		i := slices.IndexFunc(data, func(d config.NodeData) bool { return d.Name == node.Name })
+		if device, ok := dsService.SNMPNodeStatus(node.DataSource); ok && i < 0 {
+			device.Name = node.Name
+			data = append(data, device)
+			i = len(data) - 1
+		} else if ok {
+			data[i].UptimeSeconds, data[i].RebootedAt = device.UptimeSeconds, device.RebootedAt
+		}
+		if node.Interface == "" || len(node.Metrics) == 0 {
+			continue
+		}
+		if i < 0 {
+			data = append(data, config.NodeData{Name: node.Name, Status: "unknown"})
+			i = len(data) - 1
+		}
+		metrics, err := dsService.GetInterfaceMetrics(ctx, node.DataSource, node.Interface, node.Metrics)
+		if err != nil {
+			if !IsLookupError(err) {
+				s.logger.Debug("node metrics unavailable", "map", mapName, "node", node.Name, "datasource", node.DataSource, "interface", node.Interface, "error", err)
+			}
+			data[i].Error = cmp.Or(data[i].Error, err.Error())
+			continue
+		}
+		data[i].Metrics = labelStates(node.States, metrics)
+	}
+	return data
+}
+
+// labelStates replaces the values of status metrics by their label
+func labelStates(states config.NodeStates, metrics map[string]interface{}) map[string]interface{} {
+	for metric, labels := range states {
+		value, ok := metrics[metric].(int64)
+		if !ok {
+			continue
+		}
+		if label, ok := labels[value]; ok {
+			metrics[metric] = label
+		}
+	}
+	return metrics
+}
+
+// PingNodes pings the address of every node of every map once and keeps the results,
+// addresses no longer used by a node are forgotten
+func (s *MapService) PingNodes(ctx context.Context) error {
+	mapNames, err := s.ListMaps()
+	if err != nil {
+		return err
+	}
+	addresses := make(map[string]bool)
+	for _, mapName := range mapNames {
+		mapConfig, err := s.loadMapConfig(mapName)
+		if err != nil {
+			continue
+		}
+		for _, node := range mapConfig.Nodes {
+			if node.Address != "" {
+				addresses[node.Address] = true
+			}
+		}
+	}
+
+	results := make(map[string]config.NodeData, len(addresses))
+	var (
+		mu         sync.Mutex
+		wg         sync.WaitGroup
+		permission error
+	)
+	workers := utils.NewSemaphore(pingWorkers)
+	for address := range addresses {
+		if err := workers.Acquire(ctx); err != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer workers.Release()
+			result := s.pingNode(ctx, address)
+			mu.Lock()
+			defer mu.Unlock()
+			results[address] = result
+			if result.Status == "unknown" && permission == nil {
+				permission = errors.New(result.Error)
+			}
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	s.nodeStatus.mu.Lock()
+	s.nodeStatus.results = results
+	s.nodeStatus.mu.Unlock()
+	if permission != nil {
+		return fmt.Errorf("%w, run as root or grant the CAP_NET_RAW capability", permission)
+	}
+	return nil
+}
+
+// pingNode sends up to pingAttempts echo requests, the node is up on the first reply. Nodes
+// which couldn't be pinged at all, like without the permission to open raw sockets, are unknown.
+func (s *MapService) pingNode(ctx context.Context, address string) config.NodeData {
+	var err error
+	for range pingAttempts {
+		var rtt time.Duration
+		rtt, err = s.nodeStatus.ping(ctx, address, pingTimeout)
+		if err == nil {
+			now := time.Now()
+			latency := math.Round(float64(rtt.Microseconds())/10) / 100
+			return config.NodeData{Status: "up", LatencyMs: &latency, CheckedAt: &now}
+		}
+		if errors.Is(err, os.ErrPermission) || ctx.Err() != nil {
+			return config.NodeData{Status: "unknown", Error: err.Error()}
+		}
+	}
+	now := time.Now()
+	return config.NodeData{Status: "down", CheckedAt: &now, Error: err.Error()}
+}
+
+// NodeStatusEnabled reports whether the nodes are pinged and their Zabbix hosts read
+func (s *MapService) NodeStatusEnabled() bool {
+	s.nodeStatus.mu.RLock()
+	defer s.nodeStatus.mu.RUnlock()
+	return s.nodeStatus.enabled
+}
+
+// WatchNodeStatus pings the nodes with an address and reads their Zabbix hosts every interval
+// until Stop, their status is part of the map data from then on
+func (s *MapService) WatchNodeStatus(interval time.Duration) {
+	s.nodeStatus.mu.Lock()
+	s.nodeStatus.enabled = true
+	s.nodeStatus.mu.Unlock()
+	s.loops.run(func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := s.PingNodes(ctx); err != nil && ctx.Err() == nil {
+				s.logger.Error("node status check failed", "error", err)
+			}
+			if err := s.CheckZabbixHosts(ctx); err != nil && ctx.Err() == nil {
+				s.logger.Error("zabbix host check failed", "error", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"go-weathermap/internal/config"
+)
+
+const (
+	// LinkMisconfigured is the status of links above 100% utilization on maps flagging them
+	LinkMisconfigured = "misconfigured"
+	// overUtilizationTTL is how long a link above 100% stays in the lint report after it was last seen
+	overUtilizationTTL = 24 * time.Hour
+)
+
+// OverUtilization counts the times a link was seen above 100% utilization
+type OverUtilization struct {
+	Map       string
+	Link      string
+	Peak      float64
+	Count     int64
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+type overUtilizations struct {
+	mu   sync.Mutex
+	seen map[[2]string]*OverUtilization // map, link
+	now  func() time.Time
+}
+
+func newOverUtilizations() *overUtilizations {
+	return &overUtilizations{seen: make(map[[2]string]*OverUtilization), now: time.Now}
+}
+
+func (o *overUtilizations) record(mapName, link string, utilization float64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := o.now()
+	key := [2]string{mapName, link}
+	seen, ok := o.seen[key]
+	if !ok || now.Sub(seen.LastSeen) >= overUtilizationTTL {
+		seen = &OverUtilization{Map: mapName, Link: link, FirstSeen: now}
+		o.seen[key] = seen
+	}
+	seen.Count++
+	seen.Peak = max(seen.Peak, utilization)
+	seen.LastSeen = now
+}
+
+// list returns the links seen above 100% within overUtilizationTTL by map and link
+func (o *overUtilizations) list() []OverUtilization {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := o.now()
+	list := make([]OverUtilization, 0, len(o.seen))
+	for key, seen := range o.seen {
+		if now.Sub(seen.LastSeen) >= overUtilizationTTL {
+			delete(o.seen, key)
+			continue
+		}
+		list = append(list, *seen)
+	}
+	slices.SortFunc(list, func(a, b OverUtilization) int {
+		return cmp.Or(cmp.Compare(a.Map, b.Map), cmp.Compare(a.Link, b.Link))
+	})
+	return list
+}
+
+// applyUtilizationPolicy records the links of a map above 100% utilization and clamps or
+// flags them as the over_utilization policy of the map says
+func (s *MapService) applyUtilizationPolicy(name string, m *config.Map, linksData []config.LinkData) {
+	for i := range linksData {
+		data := &linksData[i]
+		if data.Status != "up" || data.Utilization <= 100 {
+			continue
+		}
+		s.overUtil.record(name, data.Name, data.Utilization)
+		switch m.OverUtilization {
+		case config.OverUtilizationClamp:
+			data.Utilization = 100
+		case config.OverUtilizationFlag:
+			data.Status = LinkMisconfigured
+		}
+	}
+}
+
+// Lint validates the maps of the config directory like ValidateConfigDir, and warns about
+// the links seen above 100% utilization in the last day
+func (s *MapService) Lint() (ConfigReport, error) {
+	report, err := ValidateConfigDir(s.configDir)
+	if err != nil {
+		return report, err
+	}
+	lines := make(map[string]map[string]int)
+	for _, seen := range s.overUtil.list() {
+		file := seen.Map + ".yaml"
+		if _, ok := lines[file]; !ok {
+			content, err := os.ReadFile(filepath.Join(s.configDir, file))
+			if err != nil {
+				continue // a sandbox map or a map deleted since
+			}
+			lines[file] = objectLines(content)
+		}
+		message := fmt.Sprintf("link '%s': utilization above 100%% seen %d times since %s, peak %.1f%%, check its bandwidth and the interfaces it counts",
+			seen.Link, seen.Count, seen.FirstSeen.UTC().Format(time.RFC3339), seen.Peak)
+		report.Issues = append(report.Issues, ConfigIssue{File: file, Line: issueLine(lines[file], message), Severity: IssueWarning, Message: message})
+	}
+	return report, nil
+}
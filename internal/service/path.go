@@ -0,0 +1,98 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"slices"
+
+	"go-weathermap/internal/config"
+)
+
+// linkCost is the path metric of a link, links without a cost count as one hop
+func linkCost(link config.Link) int {
+	if link.Cost <= 0 {
+		return 1
+	}
+	return link.Cost
+}
+
+// ShortestPath finds the cheapest route between two nodes of the map, links are used in both
+// directions. With avoidDown links reported down are left out. Ties go to the links defined first.
+func ShortestPath(m *config.MapWithData, from, to string, avoidDown bool) (*config.Path, error) {
+	known := make(map[string]bool, len(m.Nodes))
+	for _, node := range m.Nodes {
+		known[node.Name] = true
+	}
+	for _, name := range []string{from, to} {
+		if !known[name] {
+			return nil, fmt.Errorf("node not found: %s", name)
+		}
+	}
+	down := make(map[string]bool)
+	if avoidDown {
+		for _, data := range m.LinksData {
+			if data.Status == "down" {
+				down[data.Name] = true
+			}
+		}
+	}
+	return shortestPath(m.Map, from, to, down)
+}
+
+// shortestPath runs Dijkstra over the links not in skip
+func shortestPath(m *config.Map, from, to string, skip map[string]bool) (*config.Path, error) {
+	// maps are small, a linear scan for the closest node is enough
+	dist := map[string]int{from: 0}
+	via := make(map[string]config.Link) // link used to reach a node
+	done := make(map[string]bool)
+	for {
+		current, best := "", math.MaxInt
+		for _, node := range m.Nodes {
+			if d, ok := dist[node.Name]; ok && !done[node.Name] && d < best {
+				current, best = node.Name, d
+			}
+		}
+		if current == "" || current == to {
+			break
+		}
+		done[current] = true
+		for _, link := range m.Links {
+			if skip[link.Name] {
+				continue
+			}
+			next := ""
+			switch current {
+			case link.From:
+				next = link.To
+			case link.To:
+				next = link.From
+			}
+			if next == "" || done[next] {
+				continue
+			}
+			if d, ok := dist[next]; !ok || best+linkCost(link) < d {
+				dist[next] = best + linkCost(link)
+				via[next] = link
+			}
+		}
+	}
+
+	cost, ok := dist[to]
+	if !ok {
+		return nil, fmt.Errorf("no path from %s to %s", from, to)
+	}
+	path := &config.Path{From: from, To: to, Cost: cost, Nodes: []string{to}, Links: []string{}}
+	for node := to; node != from; {
+		link := via[node]
+		if node == link.To {
+			node = link.From
+		} else {
+			node = link.To
+		}
+		path.Nodes = append(path.Nodes, node)
+		path.Links = append(path.Links, link.Name)
+	}
+	slices.Reverse(path.Nodes)
+	slices.Reverse(path.Links)
+	return path, nil
+}
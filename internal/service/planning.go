@@ -0,0 +1,75 @@
+package service
+
+import (
+	"math"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/utils"
+)
+
+// PlanResult is the projection of a map traffic matrix
+type PlanResult struct {
+	Links    []config.PlannedLinkData `json:"links"`
+	Unrouted []config.Demand          `json:"unrouted"` // demands between disconnected nodes
+}
+
+// PlanLoad routes every demand of the map over the cheapest path by link cost and sums
+// the demands per link. Live link state is ignored, the plan assumes all links up.
+func PlanLoad(m *config.Map) PlanResult {
+	load := make(map[string]int64, len(m.Links))
+	demands := make(map[string]int, len(m.Links))
+	result := PlanResult{Links: make([]config.PlannedLinkData, 0, len(m.Links)), Unrouted: []config.Demand{}}
+	for _, demand := range m.Demands {
+		if demand.From == demand.To {
+			continue
+		}
+		path, err := shortestPath(m, demand.From, demand.To, nil)
+		if err != nil {
+			result.Unrouted = append(result.Unrouted, demand)
+			continue
+		}
+		for _, name := range path.Links {
+			load[name] += utils.ParseBandwidth(demand.Rate)
+			demands[name]++
+		}
+	}
+
+	for _, link := range m.Links {
+		planned := config.PlannedLinkData{Name: link.Name, Demands: demands[link.Name]}
+		// auto links have no bandwidth to plan with until polled
+		if bw := linkBandwidth(link, config.LinkData{}); bw > 0 {
+			planned.Utilization = math.Round(float64(load[link.Name])/float64(bw)*1000) / 10
+		}
+		result.Links = append(result.Links, planned)
+	}
+	return result
+}
+
+func (s *MapService) GetPlannedLoad(mapName string) (PlanResult, error) {
+	mapConfig, err := s.loadMapConfig(mapName)
+	if err != nil {
+		return PlanResult{}, err
+	}
+	return PlanLoad(mapConfig), nil
+}
+
+func (s *MapService) GetDemands(mapName string) ([]config.Demand, error) {
+	mapConfig, err := s.loadMapConfig(mapName)
+	if err != nil {
+		return nil, err
+	}
+	if mapConfig.Demands == nil {
+		return []config.Demand{}, nil
+	}
+	return mapConfig.Demands, nil
+}
+
+// UpdateDemands replaces the traffic matrix of the map, an empty list removes it
+func (s *MapService) UpdateDemands(mapName string, demands []config.Demand) error {
+	mapConfig, err := s.loadMapConfig(mapName)
+	if err != nil {
+		return err
+	}
+	mapConfig.Demands = demands
+	return s.saveMap(mapName, mapConfig)
+}
@@ -0,0 +1,349 @@
+package service
+
+import (
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	slowPollRatio         = 0.5 // poll slower than half of the interval counts as slow
+	slowPollStreak        = 3   // consecutive slow polls before the interval is doubled
+	fastPollStreak        = 10  // consecutive fast polls before the interval is halved back
+	maxIntervalMultiplier = 8
+
+	// failing targets are retried with an interval doubled by failure, up to maxBackoffMultiplier
+	// times the configured one and at most maxBackoff, give or take backoffJitter
+	maxBackoffMultiplier = 32
+	maxBackoff           = 5 * time.Minute
+	backoffJitter        = 0.2
+	breakerFailures      = 5 // consecutive failures opening the circuit breaker of a target
+)
+
+// Poll states of a target and its datasources
+const (
+	PollStateOK       = "ok"
+	PollStateBackoff  = "backoff"  // failing, retried less often
+	PollStateDegraded = "degraded" // circuit breaker open, the links of its datasources are degraded
+)
+
+// LinkDegraded is the status of links read from a target whose circuit breaker is open
+const LinkDegraded = PollStateDegraded
+
+// DegradedError is returned for the metrics of a datasource whose target has an open circuit
+// breaker, the cached values are too old to be shown
+type DegradedError struct {
+	Target    string
+	Failures  int
+	LastError string
+}
+
+func (e *DegradedError) Error() string {
+	return fmt.Sprintf("target %s degraded after %d failed polls: %s", e.Target, e.Failures, e.LastError)
+}
+
+// upper bounds of histogram buckets, +Inf bucket equals the number of polls
+var pollDurationBuckets = []time.Duration{
+	5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+type HistogramBucket struct {
+	UpperBoundMs float64 `json:"le_ms"`
+	Count        int64   `json:"count"`
+}
+
+type TargetPollStats struct {
+	Target          string            `json:"target"`
+	Poller          string            `json:"poller"`
+	Polls           int64             `json:"polls"`
+	Errors          int64             `json:"errors"`
+	LastDurationMs  float64           `json:"last_duration_ms"`
+	MaxDurationMs   float64           `json:"max_duration_ms"`
+	AvgDurationMs   float64           `json:"avg_duration_ms"`
+	SumSeconds      float64           `json:"sum_seconds"`
+	Histogram       []HistogramBucket `json:"histogram"`
+	BaseInterval    float64           `json:"base_interval_seconds"`
+	CurrentInterval float64           `json:"current_interval_seconds"`
+	Slow            bool              `json:"slow"`
+	LastPoll        time.Time         `json:"last_poll"`
+
+	State               string     `json:"state"` // PollStateOK, PollStateBackoff or PollStateDegraded
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	DegradedSince       *time.Time `json:"degraded_since,omitempty"`
+	NextPoll            time.Time  `json:"next_poll"`
+}
+
+type targetStats struct {
+	polls      int64
+	errors     int64
+	last       time.Duration
+	max        time.Duration
+	sum        time.Duration
+	buckets    []int64
+	base       time.Duration
+	multiplier int
+	slowStreak int
+	fastStreak int
+	lastPoll   time.Time
+	failures   int // consecutive
+	lastError  string
+	openedAt   time.Time // of the circuit breaker, zero while closed
+	nextPoll   time.Time
+}
+
+func (t *targetStats) state() string {
+	switch {
+	case t.failures >= breakerFailures:
+		return PollStateDegraded
+	case t.failures > 0:
+		return PollStateBackoff
+	}
+	return PollStateOK
+}
+
+type pollStats struct {
+	poller  string
+	mu      sync.Mutex
+	targets map[string]*targetStats
+}
+
+func newPollStats(poller string) *pollStats {
+	return &pollStats{poller: poller, targets: make(map[string]*targetStats)}
+}
+
+// pollVerdict is what a poll changed for its target
+type pollVerdict struct {
+	next     time.Duration // until the next poll
+	resized  bool          // the slow target interval changed
+	opened   bool          // the circuit breaker opened
+	closed   bool          // the circuit breaker closed, the target answers again
+	failures int           // consecutive failures, 0 after a success
+}
+
+// record stores one poll of target, by a caller polling it every base, and returns the interval
+// the caller should poll it with next: longer for slow targets, and with a growing backoff for
+// failing ones
+func (p *pollStats) record(target string, base, took time.Duration, err error) pollVerdict {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t, ok := p.targets[target]
+	if !ok {
+		t = &targetStats{buckets: make([]int64, len(pollDurationBuckets)), multiplier: 1}
+		p.targets[target] = t
+	}
+	// the base of the latest poll, a reload may have lengthened it
+	t.base = base
+
+	t.polls++
+	t.last = took
+	t.sum += took
+	t.max = max(t.max, took)
+	t.lastPoll = time.Now()
+	var verdict pollVerdict
+	if err != nil {
+		t.errors++
+		t.failures++
+		t.lastError = err.Error()
+		if t.failures == breakerFailures {
+			t.openedAt, verdict.opened = t.lastPoll, true
+		}
+	} else {
+		verdict.closed = !t.openedAt.IsZero()
+		t.failures, t.lastError, t.openedAt = 0, "", time.Time{}
+	}
+	verdict.failures = t.failures
+	for i, bound := range pollDurationBuckets {
+		if took <= bound {
+			t.buckets[i]++
+		}
+	}
+
+	current, multiplier := t.base*time.Duration(t.multiplier), t.multiplier
+	switch {
+	case float64(took) > float64(current)*slowPollRatio:
+		t.fastStreak = 0
+		t.slowStreak++
+		if t.slowStreak >= slowPollStreak && t.multiplier < maxIntervalMultiplier {
+			t.multiplier *= 2
+			t.slowStreak = 0
+		}
+	case float64(took) < float64(current)*slowPollRatio/2:
+		t.slowStreak = 0
+		t.fastStreak++
+		if t.fastStreak >= fastPollStreak && t.multiplier > 1 {
+			t.multiplier /= 2
+			t.fastStreak = 0
+		}
+	default:
+		t.slowStreak, t.fastStreak = 0, 0
+	}
+	verdict.resized = t.multiplier != multiplier
+	verdict.next = t.base * time.Duration(t.multiplier)
+	if t.failures > 0 {
+		verdict.next = max(verdict.next, backoff(t.base, t.failures))
+	}
+	t.nextPoll = t.lastPoll.Add(verdict.next)
+	return verdict
+}
+
+// forget drops the stats of target, once no task is polled from it
+func (p *pollStats) forget(target string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.targets, target)
+}
+
+// removeTasks drops the tasks of dsName like RemoveTasks and returns them
+func (p *EmbeddedPoller) removeTasks(dsName string) []dataPollTask {
+	p.mu.RLock()
+	var removed []dataPollTask
+	for _, task := range p.tasks {
+		if task.DS.Name == dsName {
+			removed = append(removed, task)
+		}
+	}
+	p.mu.RUnlock()
+	p.RemoveTasks(dsName)
+	return removed
+}
+
+// forgetTargets drops from stats the targets of removed tasks no other task is polled from
+func (p *EmbeddedPoller) forgetTargets(stats *pollStats, removed []dataPollTask, targetOf func(dataPollTask) string) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, task := range removed {
+		target := targetOf(task)
+		if !slices.ContainsFunc(p.tasks, func(task dataPollTask) bool { return targetOf(task) == target }) {
+			stats.forget(target)
+		}
+	}
+}
+
+// backoff is the interval after failures consecutive failed polls of a target polled every base
+func backoff(base time.Duration, failures int) time.Duration {
+	limit := max(base, min(base*maxBackoffMultiplier, maxBackoff))
+	interval := limit
+	if failures < 30 {
+		interval = min(base<<failures, limit)
+	}
+	jitter := 1 + backoffJitter*(2*rand.Float64()-1)
+	return time.Duration(float64(interval) * jitter)
+}
+
+// degraded returns a DegradedError when the circuit breaker of target is open
+func (p *pollStats) degraded(target string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t, ok := p.targets[target]
+	if !ok || t.state() != PollStateDegraded {
+		return nil
+	}
+	return &DegradedError{Target: target, Failures: t.failures, LastError: t.lastError}
+}
+
+// logPoll logs the changes of a poll verdict: slow interval changes and the circuit breaker
+// opening or closing
+func logPoll(logger *slog.Logger, poller, target string, interval time.Duration, verdict pollVerdict) {
+	switch {
+	case verdict.opened:
+		logger.Warn("circuit breaker opened, target degraded", "poller", poller, "target", target, "failures", verdict.failures, "retry_in", verdict.next.Round(time.Second))
+	case verdict.closed:
+		logger.Info("circuit breaker closed, target answers again", "poller", poller, "target", target)
+	case verdict.resized && verdict.failures == 0:
+		logger.Warn("poll interval changed", "poller", poller, "target", target, "from", interval, "to", verdict.next)
+	}
+}
+
+// pollErrorLevel logs the first failure of a target as an error, the retries only in debug
+func pollErrorLevel(verdict pollVerdict) slog.Level {
+	if verdict.failures > 1 {
+		return slog.LevelDebug
+	}
+	return slog.LevelError
+}
+
+func (p *pollStats) snapshot() []TargetPollStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	result := make([]TargetPollStats, 0, len(p.targets))
+	for name, t := range p.targets {
+		histogram := make([]HistogramBucket, len(pollDurationBuckets))
+		for i, bound := range pollDurationBuckets {
+			histogram[i] = HistogramBucket{UpperBoundMs: durationMs(bound), Count: t.buckets[i]}
+		}
+		avg := 0.0
+		if t.polls > 0 {
+			avg = durationMs(t.sum) / float64(t.polls)
+		}
+		var degradedSince *time.Time
+		if !t.openedAt.IsZero() {
+			openedAt := t.openedAt
+			degradedSince = &openedAt
+		}
+		result = append(result, TargetPollStats{
+			Target:          name,
+			Poller:          p.poller,
+			Polls:           t.polls,
+			Errors:          t.errors,
+			LastDurationMs:  durationMs(t.last),
+			MaxDurationMs:   durationMs(t.max),
+			AvgDurationMs:   avg,
+			SumSeconds:      t.sum.Seconds(),
+			Histogram:       histogram,
+			BaseInterval:    t.base.Seconds(),
+			CurrentInterval: (t.base * time.Duration(t.multiplier)).Seconds(),
+			Slow:            t.multiplier > 1,
+			LastPoll:        t.lastPoll,
+
+			State:               t.state(),
+			ConsecutiveFailures: t.failures,
+			LastError:           t.lastError,
+			DegradedSince:       degradedSince,
+			NextPoll:            t.nextPoll,
+		})
+	}
+	return result
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// statsReporter is implemented by pollers which track per target poll durations
+type statsReporter interface {
+	PollStats() []TargetPollStats
+}
+
+func (s *DataSourceService) PollStats() []TargetPollStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var stats []TargetPollStats
+	for _, p := range s.pollers {
+		if reporter, ok := p.(statsReporter); ok {
+			stats = append(stats, reporter.PollStats()...)
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Target < stats[j].Target })
+	return stats
+}
+
+// SlowTargets returns targets polled with a lengthened interval, slowest first
+func (s *DataSourceService) SlowTargets() []TargetPollStats {
+	slow := make([]TargetPollStats, 0)
+	for _, st := range s.PollStats() {
+		if st.Slow {
+			slow = append(slow, st)
+		}
+	}
+	sort.Slice(slow, func(i, j int) bool { return slow[i].AvgDurationMs > slow[j].AvgDurationMs })
+	return slow
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/datasource"
+)
+
+// DataSourcePollerStatus is the poll state of a datasource: the worst state of its targets
+type DataSourcePollerStatus struct {
+	Name                string     `json:"name"`
+	Poller              string     `json:"poller"`
+	Targets             []string   `json:"targets"`
+	State               string     `json:"state"` // PollStateOK, PollStateBackoff or PollStateDegraded
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	DegradedSince       *time.Time `json:"degraded_since,omitempty"`
+	NextPoll            *time.Time `json:"next_poll,omitempty"`
+}
+
+// IsDegraded reports whether err comes from a datasource with an open circuit breaker
+func IsDegraded(err error) bool {
+	var degraded *DegradedError
+	return errors.As(err, &degraded)
+}
+
+// checkAvailable fails while the circuit breaker of the device is open
+func (p *SNMPPoller) checkAvailable(ds config.DataSourceConfig, _ config.InterfaceConfig) error {
+	target, err := datasource.ParseSNMPTarget(ds.Params)
+	if err != nil {
+		return nil
+	}
+	return p.stats.degraded(net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+}
+
+// checkAvailable fails while the circuit breaker of the Prometheus server is open
+func (p *PrometheusPoller) checkAvailable(ds config.DataSourceConfig, _ config.InterfaceConfig) error {
+	url, _ := ds.Params["url"].(string)
+	return p.servers.degraded(url)
+}
+
+// checkAvailable fails while the circuit breaker of the file of the interface is open
+func (p *RRDPoller) checkAvailable(ds config.DataSourceConfig, iface config.InterfaceConfig) error {
+	path, err := rrdPath(ds, iface)
+	if err != nil {
+		return nil
+	}
+	return p.stats.degraded(path)
+}
+
+func (p *SNMPPoller) PollerStatus() []DataSourcePollerStatus {
+	return p.pollerStatus(SNMPPollerType, p.stats, snmpTarget)
+}
+
+func (p *PrometheusPoller) PollerStatus() []DataSourcePollerStatus {
+	return p.pollerStatus(PrometheusPollerType, p.servers, prometheusServer)
+}
+
+func (p *RRDPoller) PollerStatus() []DataSourcePollerStatus {
+	return p.pollerStatus(RRDPollerType, p.stats, rrdTarget)
+}
+
+// pollerStatus returns the state of every datasource of the tasks, from the stats of the
+// targets they are polled from. Targets not polled yet count as ok.
+func (p *EmbeddedPoller) pollerStatus(poller string, stats *pollStats, targetOf func(dataPollTask) string) []DataSourcePollerStatus {
+	p.mu.RLock()
+	targets := make(map[string][]string)
+	for _, task := range p.tasks {
+		if target := targetOf(task); !slices.Contains(targets[task.DS.Name], target) {
+			targets[task.DS.Name] = append(targets[task.DS.Name], target)
+		}
+	}
+	p.mu.RUnlock()
+
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	result := make([]DataSourcePollerStatus, 0, len(targets))
+	for dsName, dsTargets := range targets {
+		sort.Strings(dsTargets)
+		status := DataSourcePollerStatus{Name: dsName, Poller: poller, Targets: dsTargets, State: PollStateOK}
+		for _, target := range dsTargets {
+			t, ok := stats.targets[target]
+			if !ok || t.failures <= status.ConsecutiveFailures {
+				continue
+			}
+			status.State, status.ConsecutiveFailures, status.LastError = t.state(), t.failures, t.lastError
+			if !t.openedAt.IsZero() {
+				openedAt := t.openedAt
+				status.DegradedSince = &openedAt
+			}
+			nextPoll := t.nextPoll
+			status.NextPoll = &nextPoll
+		}
+		result = append(result, status)
+	}
+	return result
+}
+
+// pollerStatusReporter is implemented by the pollers with a circuit breaker by target
+type pollerStatusReporter interface {
+	PollerStatus() []DataSourcePollerStatus
+}
+
+// PollerStatus returns the poll state of every datasource with poll tasks, degraded first
+func (s *DataSourceService) PollerStatus() []DataSourcePollerStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := []DataSourcePollerStatus{}
+	for _, p := range s.pollers {
+		if reporter, ok := p.(pollerStatusReporter); ok {
+			result = append(result, reporter.PollerStatus()...)
+		}
+	}
+	rank := map[string]int{PollStateDegraded: 0, PollStateBackoff: 1, PollStateOK: 2}
+	sort.Slice(result, func(i, j int) bool {
+		if rank[result[i].State] != rank[result[j].State] {
+			return rank[result[i].State] < rank[result[j].State]
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
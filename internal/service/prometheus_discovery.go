@@ -0,0 +1,221 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/datasource"
+)
+
+const (
+	DefaultDiscoveryInterval = 5 * time.Minute
+	discoverJobsParam        = "discover_jobs" // jobs of a prometheus datasource whose targets get a datasource
+	discoveredByParam        = "discovered_by" // set on the generated datasources
+	discoveryRateWindow      = "5m"
+)
+
+// series of the exporters whose targets are discovered, with the labels naming an interface
+var discoveredCounters = []struct {
+	in, out string
+	labels  []string // the first one set names the interface, the last one identifies it
+}{
+	{"ifHCInOctets", "ifHCOutOctets", []string{"ifName", "ifDescr", "ifIndex"}},                             // snmp_exporter, if_mib module
+	{"node_network_receive_bytes_total", "node_network_transmit_bytes_total", []string{"device", "device"}}, // node_exporter
+}
+
+// DiscoveryIntervalFromEnv reads WEATHERMAP_DISCOVERY_INTERVAL, 0 disables the discovery of
+// Prometheus targets
+func DiscoveryIntervalFromEnv() (time.Duration, error) {
+	value := os.Getenv("WEATHERMAP_DISCOVERY_INTERVAL")
+	if value == "" {
+		return DefaultDiscoveryInterval, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < 0 {
+		return 0, fmt.Errorf("invalid WEATHERMAP_DISCOVERY_INTERVAL: %s", value)
+	}
+	return interval, nil
+}
+
+// prometheusDiscovery keeps the datasources generated from the targets of every prometheus
+// datasource with discover_jobs
+type prometheusDiscovery struct {
+	mu          sync.Mutex
+	client      *datasource.PrometheusClient
+	datasources map[string][]config.DataSourceConfig // by the datasource they were discovered by
+}
+
+func newPrometheusDiscovery(client *datasource.PrometheusClient) *prometheusDiscovery {
+	return &prometheusDiscovery{client: client, datasources: make(map[string][]config.DataSourceConfig)}
+}
+
+// merge adds the discovered datasources to the ones of the maps, which win on the same name
+func (d *prometheusDiscovery) merge(datasources []config.DataSourceConfig) []config.DataSourceConfig {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	defined := make(map[string]bool, len(datasources))
+	for _, ds := range datasources {
+		defined[ds.Name] = true
+	}
+	for _, source := range slices.Sorted(maps.Keys(d.datasources)) {
+		for _, ds := range d.datasources[source] {
+			if !defined[ds.Name] {
+				defined[ds.Name] = true
+				datasources = append(datasources, ds)
+			}
+		}
+	}
+	return datasources
+}
+
+func discoverJobs(ds config.DataSourceConfig) []string {
+	var jobs []string
+	switch value := ds.Params[discoverJobsParam].(type) {
+	case string:
+		jobs = strings.Split(value, ",")
+	case []interface{}:
+		for _, job := range value {
+			if job, ok := job.(string); ok {
+				jobs = append(jobs, job)
+			}
+		}
+	}
+	for i := range jobs {
+		jobs[i] = strings.TrimSpace(jobs[i])
+	}
+	return slices.DeleteFunc(jobs, func(job string) bool { return job == "" })
+}
+
+// DiscoverPrometheusTargets generates a prometheus datasource for every active target of the
+// discover_jobs of the prometheus datasources, named after its instance label, with an interface
+// for every network interface exported by snmp_exporter or node_exporter. The datasources of a
+// Prometheus server which can't be read are kept. It reports whether they changed.
+func (s *DataSourceService) DiscoverPrometheusTargets(ctx context.Context) (bool, error) {
+	s.mu.RLock()
+	var sources []config.DataSourceConfig
+	for _, ds := range s.datasources {
+		if ds.Type == PrometheusPollerType && len(discoverJobs(ds)) > 0 {
+			sources = append(sources, ds)
+		}
+	}
+	s.mu.RUnlock()
+
+	discovered := make(map[string][]config.DataSourceConfig, len(sources))
+	var errs []error
+	for _, source := range sources {
+		datasources, err := s.discoverTargets(ctx, source)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("datasource %s: %w", source.Name, err))
+			s.discovery.mu.Lock()
+			datasources = s.discovery.datasources[source.Name]
+			s.discovery.mu.Unlock()
+		}
+		discovered[source.Name] = datasources
+	}
+
+	s.discovery.mu.Lock()
+	defer s.discovery.mu.Unlock()
+	changed := !reflect.DeepEqual(discovered, s.discovery.datasources)
+	s.discovery.datasources = discovered
+	return changed, errors.Join(errs...)
+}
+
+func (s *DataSourceService) discoverTargets(ctx context.Context, source config.DataSourceConfig) ([]config.DataSourceConfig, error) {
+	targets, err := s.discovery.client.Targets(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	var datasources []config.DataSourceConfig
+	names := make(map[string]bool)
+	for _, job := range discoverJobs(source) {
+		selectors := make([]string, 0, len(discoveredCounters))
+		for _, counter := range discoveredCounters {
+			selectors = append(selectors, fmt.Sprintf("%s{job=%s}", counter.in, strconv.Quote(job)))
+		}
+		series, err := s.discovery.client.Series(ctx, source, selectors...)
+		if err != nil {
+			return nil, err
+		}
+		for _, target := range targets {
+			instance := target.Labels["instance"]
+			if target.Labels["job"] != job || instance == "" {
+				continue
+			}
+			if names[instance] {
+				s.logger.Warn("discovered target skipped, instance already discovered", "datasource", source.Name, "job", job, "instance", instance)
+				continue
+			}
+			names[instance] = true
+			datasources = append(datasources, discoveredDataSource(source, job, instance, series))
+		}
+	}
+	return datasources, nil
+}
+
+// discoveredDataSource is the datasource of a target, with the params of the datasource it was
+// discovered by, and an interface by counter series of the target
+func discoveredDataSource(source config.DataSourceConfig, job, instance string, series []map[string]string) config.DataSourceConfig {
+	params := maps.Clone(source.Params)
+	delete(params, discoverJobsParam)
+	params[discoveredByParam] = source.Name
+	ds := config.DataSourceConfig{Name: instance, Type: PrometheusPollerType, PollInterval: source.PollInterval, Params: params}
+
+	for _, counter := range discoveredCounters {
+		for _, labels := range series {
+			if labels["__name__"] != counter.in || labels["job"] != job || labels["instance"] != instance {
+				continue
+			}
+			key := counter.labels[len(counter.labels)-1]
+			name := ""
+			for _, label := range counter.labels {
+				if name = labels[label]; name != "" {
+					break
+				}
+			}
+			if name == "" || slices.ContainsFunc(ds.Interfaces, func(iface config.InterfaceConfig) bool { return iface.Name == name }) {
+				continue
+			}
+			selector := fmt.Sprintf("{job=%s,instance=%s,%s=%s}", strconv.Quote(job), strconv.Quote(instance), key, strconv.Quote(labels[key]))
+			ds.Interfaces = append(ds.Interfaces, config.InterfaceConfig{Name: name, Params: map[string]interface{}{
+				prometheusQueryPrefix + "in":  fmt.Sprintf("rate(%s%s[%s])", counter.in, selector, discoveryRateWindow),
+				prometheusQueryPrefix + "out": fmt.Sprintf("rate(%s%s[%s])", counter.out, selector, discoveryRateWindow),
+			}})
+		}
+	}
+	slices.SortFunc(ds.Interfaces, func(a, b config.InterfaceConfig) int { return cmp.Compare(a.Name, b.Name) })
+	return ds
+}
+
+// WatchPrometheusTargets discovers the targets of the prometheus datasources every interval
+// until Stop, the datasources of configDir are reloaded with them whenever they change
+func (s *DataSourceService) WatchPrometheusTargets(configDir string, interval time.Duration) {
+	s.loops.run(func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			changed, err := s.DiscoverPrometheusTargets(ctx)
+			if err != nil && ctx.Err() == nil {
+				s.logger.Error("prometheus target discovery failed", "error", err)
+			}
+			if changed {
+				s.reloadFromDir(configDir)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/datasource"
+	"go-weathermap/internal/utils"
+)
+
+const (
+	PrometheusPollerType  = "prometheus"
+	prometheusQueryPrefix = "query_" // interface params query_in, query_out, ...
+)
+
+// PrometheusPoller runs the PromQL query of every interface metric on the datasource interval.
+// Queries are expected to return bytes per second, e.g. rate(ifHCInOctets{ifName="Gi0/0/0"}[5m]).
+type PrometheusPoller struct {
+	EmbeddedPoller
+	client  *datasource.PrometheusClient
+	stats   *pollStats // by query
+	servers *pollStats // by server, only for the circuit breaker
+	workers *utils.Semaphore
+}
+
+func NewPrometheusPoller(httpClient *http.Client) *PrometheusPoller {
+	return &PrometheusPoller{
+		EmbeddedPoller: EmbeddedPoller{cache: make(map[string]int64)},
+		client:         datasource.NewPrometheusClient(httpClient),
+		stats:          newPollStats(PrometheusPollerType),
+		servers:        newPollStats(PrometheusPollerType),
+		workers:        utils.NewSemaphore(DefaultMaxPollerWorkers),
+	}
+}
+
+func prometheusKey(ds config.DataSourceConfig, iface config.InterfaceConfig, metricName string) string {
+	return fmt.Sprintf("%s:%s:%s", ds.Name, iface.Name, metricName)
+}
+
+func (p *PrometheusPoller) AddTask(ds config.DataSourceConfig, iface config.InterfaceConfig, metricName string, interval time.Duration) {
+	query, ok := iface.Params[prometheusQueryPrefix+metricName].(string)
+	if !ok || query == "" {
+		return
+	}
+	url, _ := ds.Params["url"].(string)
+	p.EmbeddedPoller.AddTask(dataPollTask{
+		Host:             url,
+		MetricIdentifier: query,
+		Key:              prometheusKey(ds, iface, metricName),
+		DS:               ds,
+		Interval:         interval,
+	})
+}
+
+// prometheusTarget is a query of a server, failing queries are retried with a backoff of their own
+func prometheusTarget(task dataPollTask) string {
+	return task.Host + " " + task.MetricIdentifier
+}
+
+// prometheusServer is the server a task queries, the circuit breaker is by server
+func prometheusServer(task dataPollTask) string {
+	return task.Host
+}
+
+// RemoveTasks also forgets the poll stats of queries and servers no other datasource polls
+func (p *PrometheusPoller) RemoveTasks(dsName string) {
+	removed := p.removeTasks(dsName)
+	p.forgetTargets(p.stats, removed, prometheusTarget)
+	p.forgetTargets(p.servers, removed, prometheusServer)
+}
+
+// recordServer counts a query toward the circuit breaker of its server. Queries the server
+// answered count as successes, a query without data doesn't degrade the links of the others.
+func (p *PrometheusPoller) recordServer(task dataPollTask, took time.Duration, err error) {
+	var queryErr *datasource.PrometheusQueryError
+	if errors.As(err, &queryErr) {
+		err = nil
+	}
+	verdict := p.servers.record(task.Host, task.Interval, took, err)
+	if verdict.opened || verdict.closed {
+		logPoll(p.log(), PrometheusPollerType, task.Host, task.Interval, verdict)
+	}
+}
+
+func (p *PrometheusPoller) Start() {
+	p.startLoops(func(task dataPollTask) string { return task.Key }, p.pollTask)
+}
+
+func (p *PrometheusPoller) pollTask(ctx context.Context, key string) {
+	tasks := p.loopTasks(key)
+	if len(tasks) == 0 {
+		return
+	}
+	task := tasks[0]
+	interval := task.Interval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for first := true; ; first = false {
+		if !first {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+
+		// a reload may have changed the query or the interval
+		tasks = p.loopTasks(key)
+		if len(tasks) == 0 {
+			return
+		}
+		if tasks[0].Interval != task.Interval {
+			interval = tasks[0].Interval
+			ticker.Reset(interval)
+		}
+		task = tasks[0]
+
+		if !p.ownsTask(task) {
+			continue
+		}
+		if err := p.workers.Acquire(ctx); err != nil {
+			continue
+		}
+		started := time.Now()
+		// a query already sent finishes even if the poller is being stopped
+		queryCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), interval)
+		val, err := p.client.Query(queryCtx, task.DS, task.MetricIdentifier)
+		cancel()
+		p.workers.Release()
+		p.recordPolls(tasks[:1], err)
+
+		took := time.Since(started)
+		p.recordServer(task, took, err)
+		verdict := p.stats.record(prometheusTarget(task), task.Interval, took, err)
+		logPoll(p.log(), PrometheusPollerType, prometheusTarget(task), interval, verdict)
+		if verdict.next != interval {
+			interval = verdict.next
+			ticker.Reset(interval)
+		}
+		if err != nil {
+			p.log().Log(ctx, pollErrorLevel(verdict), "prometheus query failed", "datasource", task.DS.Name, "query", task.MetricIdentifier, "failures", verdict.failures, "error", err)
+			p.capture(task, nil, false, err)
+			continue
+		}
+		p.SetCache(task.Key, int64(math.Round(val)))
+		p.capture(task, val, true, nil)
+	}
+}
+
+func (p *PrometheusPoller) GetMetric(ds config.DataSourceConfig, iface config.InterfaceConfig, metricName string) interface{} {
+	val, _ := p.GetCache(prometheusKey(ds, iface, metricName))
+	return val
+}
+
+func (p *PrometheusPoller) PollStats() []TargetPollStats {
+	return p.stats.snapshot()
+}
+
+func (p *PrometheusPoller) WorkerUsage() PollerUsage {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return PollerUsage{Type: PrometheusPollerType, Tasks: len(p.tasks), Workers: p.workers.Usage()}
+}
@@ -0,0 +1,235 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/datasource"
+)
+
+func newPrometheusServer(t *testing.T, token string, results map[string]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"status":"error","errorType":"unauthorized","error":"bad token"}`))
+			return
+		}
+		result, ok := results[r.URL.Query().Get("query")]
+		if !ok {
+			result = `[]`
+		}
+		_, _ = fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":%s}}`, result)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func prometheusDataSource(url, token string) config.DataSourceConfig {
+	return config.DataSourceConfig{
+		Name: "prom",
+		Type: PrometheusPollerType,
+		Interfaces: []config.InterfaceConfig{{
+			Name: "core-uplink",
+			Params: map[string]interface{}{
+				"query_in":  `rate(ifHCInOctets{ifName="Gi0/0/0"}[5m])`,
+				"query_out": `rate(ifHCOutOctets{ifName="Gi0/0/0"}[5m])`,
+			},
+		}},
+		Params: map[string]interface{}{"url": url, "bearer_token": token},
+	}
+}
+
+func TestPrometheusPoller(t *testing.T) {
+	server := newPrometheusServer(t, "secret", map[string]string{
+		`rate(ifHCInOctets{ifName="Gi0/0/0"}[5m])`:  `[{"metric":{"ifName":"Gi0/0/0"},"value":[1700000000,"125000.4"]}]`,
+		`rate(ifHCOutOctets{ifName="Gi0/0/0"}[5m])`: `[{"metric":{"ifName":"Gi0/0/0"},"value":[1700000000,"500000"]}]`,
+	})
+	ds := prometheusDataSource(server.URL, "secret")
+
+	if names := getMetricNames(ds, ds.Interfaces[0]); len(names) != 2 {
+		t.Fatalf("Expected metrics in and out from query params, got %v", names)
+	}
+
+	poller := NewPrometheusPoller(http.DefaultClient)
+	poller.AddTask(ds, ds.Interfaces[0], "in", 200*time.Millisecond)
+	poller.AddTask(ds, ds.Interfaces[0], "out", 200*time.Millisecond)
+	poller.Start()
+
+	if in := waitForMetric(t, poller, ds, "in"); in != 125000 {
+		t.Errorf("Expected in 125000, got %d", in)
+	}
+	if out := waitForMetric(t, poller, ds, "out"); out != 500000 {
+		t.Errorf("Expected out 500000, got %d", out)
+	}
+}
+
+func TestPrometheusClientErrors(t *testing.T) {
+	server := newPrometheusServer(t, "secret", map[string]string{
+		"up": `[{"metric":{"job":"a"},"value":[1700000000,"1"]},{"metric":{"job":"b"},"value":[1700000000,"1"]}]`,
+	})
+	client := datasource.NewPrometheusClient(http.DefaultClient)
+
+	testCases := []struct {
+		name     string
+		token    string
+		query    string
+		queryErr bool // the server works, the query has no usable value
+	}{
+		{"Unauthorized", "wrong", "up", false},
+		{"MultipleSeries", "secret", "up", true},
+		{"NoData", "secret", "absent_metric", true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := client.Query(context.Background(), prometheusDataSource(server.URL, tc.token), tc.query)
+			if err == nil {
+				t.Fatal("Expected query error")
+			}
+			var queryErr *datasource.PrometheusQueryError
+			if errors.As(err, &queryErr) != tc.queryErr {
+				t.Errorf("Expected a query error %v, got %T %v", tc.queryErr, err, err)
+			}
+		})
+	}
+}
+
+func TestPrometheusPollerBreakerByServer(t *testing.T) {
+	ds := prometheusDataSource("http://prometheus:9090", "secret")
+	poller := NewPrometheusPoller(http.DefaultClient)
+	poller.AddTask(ds, ds.Interfaces[0], "in", time.Second)
+	poller.AddTask(ds, ds.Interfaces[0], "out", time.Second)
+	in, out := poller.tasks[0], poller.tasks[1]
+
+	// a query without data backs off on its own, the other one answering keeps it at its interval
+	noData := &datasource.PrometheusQueryError{Err: "no prometheus data"}
+	var verdict pollVerdict
+	for range breakerFailures + 1 {
+		poller.recordServer(in, time.Millisecond, noData)
+		verdict = poller.stats.record(prometheusTarget(in), in.Interval, time.Millisecond, noData)
+		poller.recordServer(out, time.Millisecond, nil)
+		poller.stats.record(prometheusTarget(out), out.Interval, time.Millisecond, nil)
+	}
+	if verdict.failures != breakerFailures+1 || verdict.next <= in.Interval {
+		t.Errorf("Expected the failing query backed off, got %+v", verdict)
+	}
+	if err := poller.checkAvailable(ds, ds.Interfaces[0]); err != nil {
+		t.Errorf("Expected the server available with a query without data, got %v", err)
+	}
+
+	// the server failing opens the breaker for every link
+	for range breakerFailures {
+		poller.recordServer(out, time.Millisecond, errors.New("prometheus query error: connection refused"))
+	}
+	if err := poller.checkAvailable(ds, ds.Interfaces[0]); !IsDegraded(err) {
+		t.Errorf("Expected the server degraded, got %v", err)
+	}
+}
+
+func TestPrometheusDiscovery(t *testing.T) {
+	var fail atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v1/targets":
+			_, _ = w.Write([]byte(`{"status":"success","data":{"activeTargets":[
+				{"labels":{"job":"snmp","instance":"core1"},"health":"up"},
+				{"labels":{"job":"node","instance":"srv1:9100"},"health":"up"},
+				{"labels":{"job":"blackbox","instance":"https://example.com"},"health":"up"}
+			]}}`))
+		case "/api/v1/series":
+			if matchers := r.URL.Query()["match[]"]; len(matchers) != 2 {
+				t.Errorf("Expected a matcher by exporter, got %v", matchers)
+			}
+			job := r.URL.Query()["match[]"][0]
+			switch {
+			case strings.Contains(job, `job="snmp"`):
+				_, _ = w.Write([]byte(`{"status":"success","data":[
+					{"__name__":"ifHCInOctets","job":"snmp","instance":"core1","ifIndex":"1","ifName":"Gi0/0/0"},
+					{"__name__":"ifHCInOctets","job":"snmp","instance":"core1","ifIndex":"2"}
+				]}`))
+			default:
+				_, _ = w.Write([]byte(`{"status":"success","data":[
+					{"__name__":"node_network_receive_bytes_total","job":"node","instance":"srv1:9100","device":"eth0"}
+				]}`))
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	mapYAML := fmt.Sprintf(`title: discovery
+width: 100
+height: 100
+datasources:
+  - name: prom
+    type: prometheus
+    url: %s
+    discover_jobs: [snmp, node]
+    interfaces: []
+`, server.URL)
+	if err := os.WriteFile(filepath.Join(dir, "discovery.yaml"), []byte(mapYAML), 0644); err != nil {
+		t.Fatal(err)
+	}
+	datasources, err := LoadAllDataSources(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dsService := NewDataSourceService(datasources)
+
+	changed, err := dsService.DiscoverPrometheusTargets(context.Background())
+	if err != nil || !changed {
+		t.Fatalf("Expected discovered datasources, got %v, %v", changed, err)
+	}
+	result, err := dsService.ReloadDir(dir)
+	if err != nil {
+		t.Fatalf("ReloadDir failed: %v", err)
+	}
+	if !slices.Equal(result.Added, []string{"core1", "srv1:9100"}) {
+		t.Errorf("Expected core1 and srv1:9100 added, got %+v", result)
+	}
+	core1, err := dsService.GetDataSource("core1")
+	if err != nil || core1.DiscoveredBy != "prom" || !slices.Equal(core1.Interfaces, []string{"2", "Gi0/0/0"}) {
+		t.Errorf("Expected core1 discovered by prom with interfaces 2 and Gi0/0/0, got %+v, %v", core1, err)
+	}
+	ds, iface, _, _ := dsService.resolve("core1", "Gi0/0/0")
+	if query := iface.Params["query_in"]; query != `rate(ifHCInOctets{job="snmp",instance="core1",ifIndex="1"}[5m])` {
+		t.Errorf("Unexpected query %v", query)
+	}
+	if ds.Params["url"] != server.URL || ds.Params[discoverJobsParam] != nil {
+		t.Errorf("Expected the params of prom without discover_jobs, got %v", ds.Params)
+	}
+	_, iface, _, _ = dsService.resolve("srv1:9100", "eth0")
+	if query := iface.Params["query_out"]; query != `rate(node_network_transmit_bytes_total{job="node",instance="srv1:9100",device="eth0"}[5m])` {
+		t.Errorf("Unexpected query %v", query)
+	}
+
+	// targets are kept while Prometheus can't be read
+	fail.Store(true)
+	if changed, err := dsService.DiscoverPrometheusTargets(context.Background()); err == nil || changed {
+		t.Errorf("Expected an error and no change, got %v, %v", changed, err)
+	}
+	if result, _ := dsService.ReloadDir(dir); !result.Empty() {
+		t.Errorf("Expected discovered datasources kept, got %+v", result)
+	}
+}
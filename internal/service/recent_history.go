@@ -0,0 +1,246 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRecentHistoryWindow is how much of the traffic of every link is kept in memory as
+	// recorded, older traffic is kept consolidated by recentTiers
+	DefaultRecentHistoryWindow = time.Hour
+	maxRecentHistoryWindow     = 24 * time.Hour
+)
+
+// recentTiers consolidate the traffic kept in memory like RRD archives: each one averages the
+// periods of the previous one, and keeps their highest utilization
+var recentTiers = []struct {
+	name       string
+	resolution time.Duration
+	retention  time.Duration
+}{
+	{"1m", time.Minute, 24 * time.Hour},
+	{"1h", time.Hour, 31 * 24 * time.Hour},
+}
+
+// RecentHistoryWindowFromEnv reads WEATHERMAP_RECENT_HISTORY_WINDOW, DefaultRecentHistoryWindow
+// when unset, 0 keeps no traffic in memory
+func RecentHistoryWindowFromEnv() (time.Duration, error) {
+	value := strings.TrimSpace(os.Getenv("WEATHERMAP_RECENT_HISTORY_WINDOW"))
+	if value == "" {
+		return DefaultRecentHistoryWindow, nil
+	}
+	window, err := time.ParseDuration(value)
+	if err != nil || window < 0 || window > maxRecentHistoryWindow {
+		return 0, fmt.Errorf("invalid WEATHERMAP_RECENT_HISTORY_WINDOW: %s, must be between 0 and %s", value, maxRecentHistoryWindow)
+	}
+	return window, nil
+}
+
+// recentHistory keeps the traffic of every link in rings of bounded size, the last window as
+// recorded and older periods consolidated, so graphs don't read the history files and the
+// memory used by a link stops growing however long the server runs
+type recentHistory struct {
+	window time.Duration
+
+	mu    sync.Mutex
+	links map[string]map[string]*linkRecent // by map, then link
+}
+
+func newRecentHistory(window time.Duration) *recentHistory {
+	return &recentHistory{window: window, links: make(map[string]map[string]*linkRecent)}
+}
+
+// retention is the longest period served from memory, the one of the last tier
+func (h *recentHistory) retention() time.Duration {
+	return recentTiers[len(recentTiers)-1].retention
+}
+
+// record adds the samples of a map, links of the map without a sample keep their rings
+func (h *recentHistory) record(mapName string, samples []HistorySample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	links := h.links[mapName]
+	if links == nil {
+		links = make(map[string]*linkRecent)
+		h.links[mapName] = links
+	}
+	for _, sample := range samples {
+		link := links[sample.Link]
+		if link == nil {
+			link = newLinkRecent(h.window)
+			links[sample.Link] = link
+		}
+		link.add(sample)
+	}
+}
+
+// forget drops the rings of the maps not in mapNames, like deleted maps
+func (h *recentHistory) forget(mapNames []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for mapName := range h.links {
+		if !slices.Contains(mapNames, mapName) {
+			delete(h.links, mapName)
+		}
+	}
+}
+
+// since returns the samples of a link from from on, oldest first, of the finest tier still
+// keeping from: raw while within the window, else the tier of recentTiers
+func (h *recentHistory) since(mapName, linkName string, from, now time.Time) (string, []HistorySample) {
+	tier := "raw"
+	if from.Before(now.Add(-h.window)) {
+		tier = recentTiers[len(recentTiers)-1].name
+		for _, t := range recentTiers {
+			if !from.Before(now.Add(-t.retention)) {
+				tier = t.name
+				break
+			}
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	samples := []HistorySample{}
+	link := h.links[mapName][linkName]
+	if link == nil {
+		return tier, samples
+	}
+	ring := &link.raw
+	for i, t := range recentTiers {
+		if t.name == tier {
+			ring = &link.tiers[i].ring
+		}
+	}
+	for _, point := range ring.ordered() {
+		if !point.time().Before(from) {
+			samples = append(samples, point.sample(linkName, tier != "raw"))
+		}
+	}
+	return tier, samples
+}
+
+// recentPoint is a sample in 32 bytes, rates don't need more than float32 for a graph
+type recentPoint struct {
+	at             int64 // unix seconds
+	in, out        float32
+	utilization    float32
+	maxUtilization float32
+	samples        int32
+}
+
+func (p recentPoint) time() time.Time {
+	return time.Unix(p.at, 0).UTC()
+}
+
+func (p recentPoint) sample(linkName string, consolidated bool) HistorySample {
+	sample := HistorySample{
+		Time:        p.time(),
+		Link:        linkName,
+		In:          float64(p.in),
+		Out:         float64(p.out),
+		Utilization: roundUtilization(float64(p.utilization)),
+	}
+	if consolidated {
+		sample.MaxUtilization = roundUtilization(float64(p.maxUtilization))
+		sample.Samples = int(p.samples)
+	}
+	return sample
+}
+
+func roundUtilization(utilization float64) float64 {
+	return math.Round(utilization*10) / 10
+}
+
+// linkRecent is the traffic of a link: recorded samples and one consolidation by tier
+type linkRecent struct {
+	raw   pointRing
+	tiers []consolidation
+}
+
+func newLinkRecent(window time.Duration) *linkRecent {
+	link := &linkRecent{raw: newPointRing(int(window/historyMinInterval) + 1)}
+	for _, tier := range recentTiers {
+		link.tiers = append(link.tiers, consolidation{
+			resolution: int64(tier.resolution / time.Second),
+			ring:       newPointRing(int(tier.retention / tier.resolution)),
+		})
+	}
+	return link
+}
+
+func (l *linkRecent) add(sample HistorySample) {
+	point := recentPoint{
+		at:             sample.Time.Unix(),
+		in:             float32(sample.In),
+		out:            float32(sample.Out),
+		utilization:    float32(sample.Utilization),
+		maxUtilization: float32(sample.Utilization),
+		samples:        1,
+	}
+	l.raw.push(point)
+	l.consolidate(0, point)
+}
+
+// consolidate adds a point to the period of a tier, the period is pushed to the ring of the
+// tier and consolidated into the next tier once a point of a later period comes
+func (l *linkRecent) consolidate(tier int, point recentPoint) {
+	c := &l.tiers[tier]
+	period := point.at - point.at%c.resolution
+	if c.current.samples > 0 && period != c.current.at {
+		done := c.current
+		done.in /= float32(done.samples)
+		done.out /= float32(done.samples)
+		done.utilization /= float32(done.samples)
+		c.ring.push(done)
+		c.current = recentPoint{}
+		if tier+1 < len(l.tiers) {
+			l.consolidate(tier+1, done)
+		}
+	}
+	weight := float32(point.samples)
+	c.current.at = period
+	c.current.in += point.in * weight
+	c.current.out += point.out * weight
+	c.current.utilization += point.utilization * weight
+	c.current.maxUtilization = max(c.current.maxUtilization, point.maxUtilization)
+	c.current.samples += point.samples
+}
+
+// consolidation of a tier: the ring of its complete periods and the sums of the current one
+type consolidation struct {
+	resolution int64 // seconds
+	ring       pointRing
+	current    recentPoint
+}
+
+// pointRing grows up to its capacity and overwrites its oldest point once full, links
+// recorded for a short time only don't hold the memory of a full ring
+type pointRing struct {
+	points   []recentPoint
+	capacity int
+	next     int // index of the oldest point once full
+}
+
+func newPointRing(capacity int) pointRing {
+	return pointRing{capacity: capacity}
+}
+
+func (r *pointRing) push(point recentPoint) {
+	if len(r.points) < r.capacity {
+		r.points = append(r.points, point)
+		return
+	}
+	r.points[r.next] = point
+	r.next = (r.next + 1) % len(r.points)
+}
+
+func (r *pointRing) ordered() []recentPoint {
+	return append(slices.Clone(r.points[r.next:]), r.points[:r.next]...)
+}
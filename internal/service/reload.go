@@ -0,0 +1,299 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go-weathermap/internal/config"
+)
+
+const DefaultReloadInterval = 10 * time.Second
+
+// ReloadResult lists datasource names by what a reload did with them
+type ReloadResult struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []string `json:"changed"`
+}
+
+func (r ReloadResult) Empty() bool {
+	return len(r.Added) == 0 && len(r.Removed) == 0 && len(r.Changed) == 0
+}
+
+// Reload replaces the datasource definitions. Tasks of removed and changed datasources are
+// dropped, new and changed ones are polled from now on, the others keep their state.
+// Nothing changes when the new definitions are invalid.
+func (s *DataSourceService) Reload(datasources []config.DataSourceConfig) (ReloadResult, error) {
+	var result ReloadResult
+	if err := ValidateDataSources(datasources); err != nil {
+		return result, err
+	}
+	next := make(map[string]config.DataSourceConfig, len(datasources))
+	for _, ds := range datasources {
+		next[ds.Name] = ds
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, old := range s.datasources {
+		ds, ok := next[name]
+		switch {
+		case !ok:
+			result.Removed = append(result.Removed, name)
+		case !reflect.DeepEqual(old, ds):
+			result.Changed = append(result.Changed, name)
+		default:
+			continue
+		}
+		if poller, ok := s.pollers[old.Type]; ok {
+			poller.RemoveTasks(name)
+		}
+	}
+	for name := range next {
+		if _, ok := s.datasources[name]; !ok {
+			result.Added = append(result.Added, name)
+		}
+	}
+	// tasks are added for every datasource: pollers skip the ones already polled, and
+	// tasks shared by several datasources are restored when one of them was removed
+	for _, ds := range next {
+		s.addTasksLocked(ds)
+	}
+	s.datasources = next
+	s.applyLineRatesLocked()
+	s.lookups.reset()
+
+	sort.Strings(result.Added)
+	sort.Strings(result.Removed)
+	sort.Strings(result.Changed)
+	return result, nil
+}
+
+// ReloadIntervalFromEnv reads WEATHERMAP_RELOAD_INTERVAL, 0 disables watching the maps
+func ReloadIntervalFromEnv() (time.Duration, error) {
+	value := os.Getenv("WEATHERMAP_RELOAD_INTERVAL")
+	if value == "" {
+		return DefaultReloadInterval, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < 0 {
+		return 0, fmt.Errorf("invalid WEATHERMAP_RELOAD_INTERVAL: %s", value)
+	}
+	return interval, nil
+}
+
+// WatchDataSources rescans configDir every interval and reloads datasources when a map
+// file was added, removed or modified. It runs until Stop.
+func (s *DataSourceService) WatchDataSources(configDir string, interval time.Duration) {
+	s.loops.run(func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var last string // the first scan always reloads, maps may have changed since they were loaded
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			fingerprint, err := mapFilesFingerprint(configDir)
+			if err != nil {
+				s.logger.Warn("datasource reload", "error", err)
+				continue
+			}
+			if fingerprint == last {
+				continue
+			}
+			last = fingerprint
+			s.reloadFromDir(configDir)
+		}
+	})
+}
+
+func (s *DataSourceService) reloadFromDir(configDir string) {
+	result, err := s.ReloadDir(configDir)
+	if err != nil {
+		s.logger.Error("datasource reload failed", "error", err)
+		return
+	}
+	if !result.Empty() {
+		s.logger.Info("datasources reloaded", "added", result.Added, "removed", result.Removed, "changed", result.Changed)
+	}
+}
+
+// ReloadDir reloads the datasources of every map of configDir, see LoadDir, and the
+// bandwidths of their links
+func (s *DataSourceService) ReloadDir(configDir string) (ReloadResult, error) {
+	datasources, err := s.LoadDir(configDir)
+	if err != nil {
+		return ReloadResult{}, err
+	}
+	result, err := s.Reload(datasources)
+	if err != nil {
+		return result, err
+	}
+	s.LoadLinkBandwidths(configDir)
+	return result, nil
+}
+
+// LoadDir reads the datasources of every map of configDir, along with the ones discovered
+// from Prometheus, without polling them. Broken datasources keep their current definition,
+// see keepValid. Reload doesn't fail with the datasources it returns.
+func (s *DataSourceService) LoadDir(configDir string) ([]config.DataSourceConfig, error) {
+	datasources, err := LoadAllDataSources(configDir)
+	if err != nil {
+		s.logger.Warn("datasource reload", "error", err)
+		datasources = s.keepValid(datasources)
+	}
+	datasources = s.discovery.merge(datasources)
+	if err := ValidateDataSources(datasources); err != nil {
+		return nil, err
+	}
+	return datasources, nil
+}
+
+// keepValid replaces datasources failing validation with their current definition,
+// or drops them when they are new, so one broken map doesn't block reloading the others
+func (s *DataSourceService) keepValid(datasources []config.DataSourceConfig) []config.DataSourceConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	valid := make([]config.DataSourceConfig, 0, len(datasources))
+	for _, ds := range datasources {
+		if ValidateDataSources([]config.DataSourceConfig{ds}) == nil {
+			valid = append(valid, ds)
+		} else if current, ok := s.datasources[ds.Name]; ok {
+			valid = append(valid, current)
+		}
+	}
+	return valid
+}
+
+// mapFilesFingerprint changes whenever a .yaml file of the directory is added, removed or written
+func mapFilesFingerprint(configDir string) (string, error) {
+	entries, err := os.ReadDir(configDir)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".yaml" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // removed while scanning, the next scan sees it
+		}
+		fmt.Fprintf(&b, "%s:%d:%d;", entry.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	return b.String(), nil
+}
+
+// mapFiles remembers the content of every map file at the last reload, to tell what changed
+type mapFiles struct {
+	mu     sync.Mutex
+	hashes map[string]string // map name -> sha256 of the file
+}
+
+// ConfigDir is the directory of the map files
+func (s *MapService) ConfigDir() string {
+	return s.configDir
+}
+
+// MapsReload is the state of the map files read by LoadMaps, applied by ApplyMaps
+type MapsReload struct {
+	hashes  map[string]string
+	policy  *AccessPolicy
+	modTime time.Time
+}
+
+// ReloadMaps reads the access policy again and lists the maps added, removed or changed since
+// the service started or the last reload. Maps are read from their files on every request,
+// so their changes already apply.
+func (s *MapService) ReloadMaps() (ReloadResult, error) {
+	reload, err := s.LoadMaps()
+	if err != nil {
+		return ReloadResult{}, err
+	}
+	return s.ApplyMaps(reload), nil
+}
+
+// LoadMaps reads the map files and the access policy without applying them, it fails when
+// either can't be read
+func (s *MapService) LoadMaps() (MapsReload, error) {
+	hashes, err := mapFileHashes(s.configDir)
+	if err != nil {
+		return MapsReload{}, err
+	}
+	policy, modTime, err := readAccessPolicy(s.accessFile())
+	if err != nil {
+		return MapsReload{}, err
+	}
+	return MapsReload{hashes: hashes, policy: policy, modTime: modTime}, nil
+}
+
+// ApplyMaps serves the access policy of reload and lists the maps changed since the last
+// reload, then writes ids to the maps stored without them
+func (s *MapService) ApplyMaps(reload MapsReload) ReloadResult {
+	result := s.applyMapFiles(reload)
+	s.AssignMissingIDs()
+	return result
+}
+
+func (s *MapService) applyMapFiles(reload MapsReload) ReloadResult {
+	var result ReloadResult
+	s.access.mu.Lock()
+	s.access.policy, s.access.modTime = reload.policy, reload.modTime
+	s.access.mu.Unlock()
+
+	hashes := reload.hashes
+	s.files.mu.Lock()
+	defer s.files.mu.Unlock()
+	for name, hash := range s.files.hashes {
+		switch next, ok := hashes[name]; {
+		case !ok:
+			result.Removed = append(result.Removed, name)
+		case next != hash:
+			result.Changed = append(result.Changed, name)
+		}
+	}
+	for name := range hashes {
+		if _, ok := s.files.hashes[name]; !ok {
+			result.Added = append(result.Added, name)
+		}
+	}
+	s.files.hashes = hashes
+
+	sort.Strings(result.Added)
+	sort.Strings(result.Removed)
+	sort.Strings(result.Changed)
+	return result
+}
+
+func mapFileHashes(configDir string) (map[string]string, error) {
+	files, err := filepath.Glob(filepath.Join(configDir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	hashes := make(map[string]string, len(files))
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			continue // removed while reading
+		}
+		hashes[strings.TrimSuffix(filepath.Base(file), ".yaml")] = contentHash(content)
+	}
+	return hashes, nil
+}
+
+func contentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-weathermap/internal/config"
+)
+
+func waitForServiceMetric(t *testing.T, s *DataSourceService, dsName, ifaceName string) int64 {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		metrics, err := s.GetInterfaceMetrics(context.Background(), dsName, ifaceName, []string{"in"})
+		if err == nil {
+			if in, _ := metrics["in"].(int64); in > 0 {
+				return in
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("No value for %s/%s after 5s", dsName, ifaceName)
+	return 0
+}
+
+func TestDataSourceServiceReload(t *testing.T) {
+	sim := newSimulator(t)
+	setOctets(sim, ifInOctets1, 125_000)
+	setOctets(sim, ifInOctets2, 250_000)
+	ds := simDataSource(sim, "public")
+	ds.PollInterval = 1
+
+	dsService := NewDataSourceService([]config.DataSourceConfig{ds})
+	dsService.Start()
+	defer func() { _ = dsService.Stop(context.Background()) }()
+	waitForServiceMetric(t, dsService, ds.Name, "Gi0/0/0")
+
+	core := simDataSource(sim, "public")
+	core.Name = "lab-core"
+	core.PollInterval = 1
+	core.Params["timeout"] = "500ms"
+	core.Interfaces = []config.InterfaceConfig{{
+		Name:   "Gi0/0/1",
+		Params: map[string]interface{}{"oids": map[string]interface{}{"in": ifInOctets2}},
+	}}
+	result, err := dsService.Reload([]config.DataSourceConfig{core})
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if len(result.Added) != 1 || result.Added[0] != "lab-core" || len(result.Removed) != 1 || result.Removed[0] != ds.Name {
+		t.Errorf("Unexpected reload result: %+v", result)
+	}
+	if _, err := dsService.GetInterfaceMetrics(context.Background(), ds.Name, "Gi0/0/0", []string{"in"}); err == nil {
+		t.Error("Expected removed datasource to be unknown")
+	}
+	assertRate(t, "in after reload", waitForServiceMetric(t, dsService, "lab-core", "Gi0/0/1"), 250_000)
+
+	invalid := core
+	invalid.Params = map[string]interface{}{"host": "bad host!"}
+	if _, err := dsService.Reload([]config.DataSourceConfig{invalid}); err == nil {
+		t.Error("Expected invalid datasources to be rejected")
+	}
+	if infos := dsService.ListDataSources(); len(infos) != 1 || infos[0].Name != "lab-core" {
+		t.Errorf("Expected rejected reload to keep datasources, got %+v", infos)
+	}
+}
+
+func TestWatchDataSources(t *testing.T) {
+	sim := newSimulator(t)
+	setOctets(sim, ifInOctets1, 125_000)
+	dir := t.TempDir()
+
+	dsService := NewDataSourceService(nil)
+	dsService.Start()
+	dsService.WatchDataSources(dir, 50*time.Millisecond)
+	defer func() { _ = dsService.Stop(context.Background()) }()
+
+	mapYAML := fmt.Sprintf(`title: reload
+width: 100
+height: 100
+datasources:
+  - name: lab-router
+    type: snmp
+    poll_interval: 1
+    host: %s
+    port: %d
+    community: public
+    timeout: 500ms
+    interfaces:
+      - name: Gi0/0/0
+        oids:
+          in: "%s"
+`, sim.Host(), sim.Port(), ifInOctets1)
+	if err := os.WriteFile(filepath.Join(dir, "reload.yaml"), []byte(mapYAML), 0644); err != nil {
+		t.Fatalf("Failed to write map: %v", err)
+	}
+	assertRate(t, "in", waitForServiceMetric(t, dsService, "lab-router", "Gi0/0/0"), 125_000)
+
+	if err := os.Remove(filepath.Join(dir, "reload.yaml")); err != nil {
+		t.Fatalf("Failed to remove map: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(dsService.ListDataSources()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Datasource of the removed map is still polled")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
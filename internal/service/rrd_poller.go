@@ -0,0 +1,265 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/datasource"
+)
+
+const (
+	RRDPollerType = "rrd"
+	rrdDSPrefix   = "ds_" // params ds_in, ds_out, ...: the data source of a metric in the file
+	rrdStaleSteps = 3     // a file not updated for that many steps is stale
+)
+
+// rrdDefaultDataSources are the data sources of the interface traffic graphs of Cacti
+var rrdDefaultDataSources = map[string]string{"in": "traffic_in", "out": "traffic_out"}
+
+var rrdPathPlaceholder = regexp.MustCompile(`\{(\w+)\}`)
+
+// rrdRoot is the only directory rrd files are read from, rrd datasources fail without it
+var rrdRoot string
+
+// RRDRootFromEnv reads WEATHERMAP_RRD_DIR, the directory of the rrd files, "" when unset
+func RRDRootFromEnv() (string, error) {
+	value := strings.TrimSpace(os.Getenv("WEATHERMAP_RRD_DIR"))
+	if value == "" {
+		return "", nil
+	}
+	root, err := filepath.Abs(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid WEATHERMAP_RRD_DIR: %s", value)
+	}
+	return root, nil
+}
+
+// SetRRDRoot sets the directory of the rrd files, before datasources are loaded. The paths of
+// rrd datasources are relative to it and can't leave it, so editing a map can't read any file
+// of the server.
+func SetRRDRoot(dir string) {
+	rrdRoot = dir
+}
+
+// RRDPoller reads interface metrics from the RRD files of rrdtool, as kept up to date by Cacti
+// or classic weathermap setups. Every file is read once per interval for all the metrics in it.
+type RRDPoller struct {
+	EmbeddedPoller
+	stats *pollStats
+}
+
+func NewRRDPoller() *RRDPoller {
+	return &RRDPoller{
+		EmbeddedPoller: EmbeddedPoller{cache: make(map[string]int64)},
+		stats:          newPollStats(RRDPollerType),
+	}
+}
+
+func rrdKey(ds config.DataSourceConfig, iface config.InterfaceConfig, metricName string) string {
+	return fmt.Sprintf("%s:%s:%s", ds.Name, iface.Name, metricName)
+}
+
+// rrdPath expands the path of an interface: its own path param or the one of the datasource,
+// where {datasource}, {interface} and {<param>} are replaced by the datasource name, the
+// interface name and the interface params. The path is relative to rrdRoot and must stay in it.
+func rrdPath(ds config.DataSourceConfig, iface config.InterfaceConfig) (string, error) {
+	template, _ := iface.Params["path"].(string)
+	if template == "" {
+		template, _ = ds.Params["path"].(string)
+	}
+	if template == "" {
+		return "", fmt.Errorf("interface %s: no rrd file path, set path on the datasource or the interface", iface.Name)
+	}
+	if rrdRoot == "" {
+		return "", fmt.Errorf("interface %s: rrd files are not enabled, set WEATHERMAP_RRD_DIR", iface.Name)
+	}
+	if filepath.IsAbs(template) {
+		return "", fmt.Errorf("interface %s: path %s must be relative to WEATHERMAP_RRD_DIR", iface.Name, template)
+	}
+	var missing string
+	path := rrdPathPlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+		switch name {
+		case "datasource":
+			return ds.Name
+		case "interface":
+			return iface.Name
+		}
+		if value, ok := iface.Params[name]; ok && value != nil {
+			return fmt.Sprint(value)
+		}
+		missing = name
+		return placeholder
+	})
+	if missing != "" {
+		return "", fmt.Errorf("interface %s: path %s uses {%s}, which is not an interface param", iface.Name, template, missing)
+	}
+	if filepath.IsAbs(path) || !inDir(rrdRoot, filepath.Join(rrdRoot, path)) {
+		return "", fmt.Errorf("interface %s: path %s is outside of WEATHERMAP_RRD_DIR", iface.Name, path)
+	}
+	return filepath.Join(rrdRoot, path), nil
+}
+
+// inDir reports whether the clean path is dir or below it
+func inDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// readRRD reads a file of rrdPath, unless a symlink leads it out of rrdRoot
+func readRRD(path string) (*datasource.RRDFile, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, err
+	}
+	root, err := filepath.EvalSymlinks(rrdRoot)
+	if err != nil {
+		return nil, err
+	}
+	if !inDir(root, resolved) {
+		return nil, fmt.Errorf("rrd file %s is outside of WEATHERMAP_RRD_DIR", path)
+	}
+	return datasource.ReadRRD(resolved)
+}
+
+// rrdDataSource is the data source of a metric in the file: the ds_<metric> param of the
+// interface or the datasource, else the Cacti name of in and out, else the metric name
+func rrdDataSource(ds config.DataSourceConfig, iface config.InterfaceConfig, metricName string) string {
+	for _, params := range []map[string]interface{}{iface.Params, ds.Params} {
+		if name, ok := params[rrdDSPrefix+metricName].(string); ok && name != "" {
+			return name
+		}
+	}
+	if name, ok := rrdDefaultDataSources[metricName]; ok {
+		return name
+	}
+	return metricName
+}
+
+// rrdScale multiplies the values read, 0.125 for files holding bits per second
+func rrdScale(ds config.DataSourceConfig) float64 {
+	switch scale := ds.Params["scale"].(type) {
+	case int:
+		return float64(scale)
+	case float64:
+		return scale
+	}
+	return 1
+}
+
+func (p *RRDPoller) AddTask(ds config.DataSourceConfig, iface config.InterfaceConfig, metricName string, interval time.Duration) {
+	path, err := rrdPath(ds, iface)
+	if err != nil {
+		return
+	}
+	p.EmbeddedPoller.AddTask(dataPollTask{
+		Host:             path,
+		MetricIdentifier: rrdDataSource(ds, iface, metricName),
+		Key:              rrdKey(ds, iface, metricName),
+		DS:               ds,
+		Interval:         interval,
+	})
+}
+
+// rrdTarget is the file a task is read from
+func rrdTarget(task dataPollTask) string {
+	return task.Host
+}
+
+// RemoveTasks also forgets the poll stats of files no other datasource reads
+func (p *RRDPoller) RemoveTasks(dsName string) {
+	p.forgetTargets(p.stats, p.removeTasks(dsName), rrdTarget)
+}
+
+func (p *RRDPoller) Start() {
+	p.startLoops(func(task dataPollTask) string { return task.Host }, p.pollFile)
+}
+
+func (p *RRDPoller) pollFile(ctx context.Context, path string) {
+	tasks := p.loopTasks(path)
+	if len(tasks) == 0 {
+		return
+	}
+	baseInterval := minInterval(tasks)
+	interval := baseInterval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for first := true; ; first = false {
+		if !first {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+
+		// tasks are read every cycle, a reload may have changed them
+		if tasks = p.loopTasks(path); len(tasks) == 0 {
+			return
+		}
+		if base := minInterval(tasks); base != baseInterval {
+			baseInterval, interval = base, base
+			ticker.Reset(interval)
+		}
+		var owned []dataPollTask
+		for _, task := range tasks {
+			if p.ownsTask(task) {
+				owned = append(owned, task)
+			}
+		}
+		if len(owned) == 0 {
+			continue
+		}
+
+		started := time.Now()
+		file, err := readRRD(path)
+		if err == nil && time.Since(file.LastUpdate) > rrdStaleSteps*max(file.Step, baseInterval) {
+			err = fmt.Errorf("rrd file %s not updated since %s", path, file.LastUpdate.Format(time.RFC3339))
+		}
+		p.recordPolls(owned, err)
+		verdict := p.stats.record(path, baseInterval, time.Since(started), err)
+		logPoll(p.log(), RRDPollerType, path, interval, verdict)
+		if verdict.next != interval {
+			interval = verdict.next
+			ticker.Reset(interval)
+		}
+		if err != nil {
+			p.log().Log(ctx, pollErrorLevel(verdict), "rrd read failed", "path", path, "failures", verdict.failures, "error", err)
+			for _, task := range owned {
+				p.capture(task, nil, false, err)
+			}
+			continue
+		}
+		for _, task := range owned {
+			cf, _ := task.DS.Params["cf"].(string)
+			if cf == "" {
+				cf = "AVERAGE"
+			}
+			val, _, err := file.Last(task.MetricIdentifier, cf)
+			if err != nil {
+				p.log().Error("rrd read failed", "datasource", task.DS.Name, "path", path, "error", err)
+				p.capture(task, nil, false, err)
+				continue
+			}
+			p.SetCache(task.Key, int64(math.Round(val*rrdScale(task.DS))))
+			p.capture(task, val, true, nil)
+		}
+	}
+}
+
+func (p *RRDPoller) GetMetric(ds config.DataSourceConfig, iface config.InterfaceConfig, metricName string) interface{} {
+	val, _ := p.GetCache(rrdKey(ds, iface, metricName))
+	return val
+}
+
+func (p *RRDPoller) PollStats() []TargetPollStats {
+	return p.stats.snapshot()
+}
@@ -0,0 +1,255 @@
+package service
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/datasource"
+)
+
+type testRRA struct {
+	cf       string
+	pdpCount int
+	curRow   int
+	rows     [][]float64 // a value by data source
+}
+
+// writeRRD writes an RRD file in the layout of rrdtool on 64-bit hosts
+func writeRRD(t *testing.T, path string, order binary.AppendByteOrder, step int, lastUpdate time.Time, dsNames []string, rras []testRRA) {
+	t.Helper()
+	var data []byte
+	word := func(v uint64) { data = order.AppendUint64(data, v) }
+	name := func(s string, size int) {
+		data = append(data, []byte(s)...)
+		data = append(data, make([]byte, size-len(s))...)
+	}
+
+	name("RRD", 4)
+	name("0003", 12)
+	word(math.Float64bits(8.642135e130))
+	word(uint64(len(dsNames)))
+	word(uint64(len(rras)))
+	word(uint64(step))
+	data = append(data, make([]byte, 80)...)
+	for _, ds := range dsNames {
+		name(ds, 20)
+		name("COUNTER", 20)
+		data = append(data, make([]byte, 80)...)
+	}
+	for _, rra := range rras {
+		name(rra.cf, 24)
+		word(uint64(len(rra.rows)))
+		word(uint64(rra.pdpCount))
+		data = append(data, make([]byte, 80)...)
+	}
+	word(uint64(lastUpdate.Unix()))
+	word(0)
+	data = append(data, make([]byte, 112*len(dsNames)+80*len(dsNames)*len(rras))...)
+	for _, rra := range rras {
+		word(uint64(rra.curRow))
+	}
+	for _, rra := range rras {
+		for _, row := range rra.rows {
+			for _, value := range row {
+				word(math.Float64bits(value))
+			}
+		}
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func cactiRRAs() []testRRA {
+	nan := math.NaN()
+	return []testRRA{
+		{cf: "AVERAGE", pdpCount: 6, curRow: 0, rows: [][]float64{{1, 1}, {2, 2}}},
+		{cf: "AVERAGE", pdpCount: 1, curRow: 1, rows: [][]float64{{100, 500000}, {125000.4, nan}, {nan, nan}}},
+		{cf: "MAX", pdpCount: 1, curRow: 1, rows: [][]float64{{200, 600000}, {250000, 700000}, {nan, nan}}},
+	}
+}
+
+// setRRDRoot sets the directory of the rrd files for the test
+func setRRDRoot(t *testing.T, dir string) {
+	previous := rrdRoot
+	SetRRDRoot(dir)
+	t.Cleanup(func() { SetRRDRoot(previous) })
+}
+
+func TestRRDPoller(t *testing.T) {
+	dir := t.TempDir()
+	setRRDRoot(t, dir)
+	writeRRD(t, filepath.Join(dir, "core1_traffic_in_42.rrd"), binary.LittleEndian, 300, time.Now(), []string{"traffic_in", "traffic_out"}, cactiRRAs())
+	ds := config.DataSourceConfig{
+		Name: "cacti",
+		Type: RRDPollerType,
+		Interfaces: []config.InterfaceConfig{{
+			Name:   "core-uplink",
+			Params: map[string]interface{}{"host": "core1", "id": 42},
+		}},
+		Params: map[string]interface{}{"path": "{host}_traffic_in_{id}.rrd"},
+	}
+	if err := ValidateDataSources([]config.DataSourceConfig{ds}); err != nil {
+		t.Fatalf("Expected valid datasource, got %v", err)
+	}
+	if names := getMetricNames(ds, ds.Interfaces[0]); strings.Join(names, ",") != "in,out" {
+		t.Fatalf("Expected default metrics in and out, got %v", names)
+	}
+
+	poller := CreatePoller(RRDPollerType, ResourceLimits{})
+	poller.AddTask(ds, ds.Interfaces[0], "in", 200*time.Millisecond)
+	poller.AddTask(ds, ds.Interfaces[0], "out", 200*time.Millisecond)
+	poller.Start()
+
+	if in := waitForMetric(t, poller, ds, "in"); in != 125000 {
+		t.Errorf("Expected in 125000 from the finest AVERAGE archive, got %d", in)
+	}
+	// the last row isn't filled for out yet, the row before it is read
+	if out := waitForMetric(t, poller, ds, "out"); out != 500000 {
+		t.Errorf("Expected out 500000, got %d", out)
+	}
+}
+
+func TestParseRRD(t *testing.T) {
+	dir := t.TempDir()
+	setRRDRoot(t, dir)
+	lastUpdate := time.Unix(1700000100, 0).UTC()
+	path := filepath.Join(dir, "big.rrd")
+	writeRRD(t, path, binary.BigEndian, 300, lastUpdate, []string{"traffic_in", "traffic_out"}, cactiRRAs())
+
+	file, err := datasource.ReadRRD(path)
+	if err != nil {
+		t.Fatalf("Failed to read big endian rrd file: %v", err)
+	}
+	if file.Step != 5*time.Minute || !file.LastUpdate.Equal(lastUpdate) {
+		t.Errorf("Expected step 5m and last update %s, got %s and %s", lastUpdate, file.Step, file.LastUpdate)
+	}
+	value, at, err := file.Last("traffic_in", "max")
+	if err != nil || value != 250000 {
+		t.Errorf("Expected MAX 250000, got %v (%v)", value, err)
+	}
+	if want := time.Unix(1700000100-1700000100%300, 0).UTC(); !at.Equal(want) {
+		t.Errorf("Expected row time %s, got %s", want, at)
+	}
+	if _, at, _ := file.Last("traffic_out", "AVERAGE"); !at.Equal(time.Unix(1700000100-1700000100%300-300, 0).UTC()) {
+		t.Errorf("Expected the row before the last for traffic_out, got %s", at)
+	}
+
+	if _, _, err := file.Last("errors_in", "AVERAGE"); err == nil || !strings.Contains(err.Error(), "traffic_in, traffic_out") {
+		t.Errorf("Expected unknown data source error listing the data sources, got %v", err)
+	}
+	if _, _, err := file.Last("traffic_in", "MIN"); err == nil {
+		t.Error("Expected error for a missing MIN archive")
+	}
+
+	content, _ := os.ReadFile(path)
+	for name, data := range map[string][]byte{
+		"NotRRD":    []byte(strings.Repeat("x", 200)),
+		"Truncated": content[:len(content)-8],
+		"Version":   append([]byte("RRD\x000009"), content[9:]...),
+	} {
+		if _, err := datasource.ParseRRD(data); err == nil {
+			t.Errorf("%s: expected parse error", name)
+		}
+	}
+
+	ds := config.DataSourceConfig{
+		Name:       "cacti",
+		Type:       RRDPollerType,
+		Interfaces: []config.InterfaceConfig{{Name: "core-uplink"}},
+		Params:     map[string]interface{}{"path": "{host}_traffic_in_{id}.rrd"},
+	}
+	if err := ValidateDataSources([]config.DataSourceConfig{ds}); err == nil || !strings.Contains(err.Error(), "{host}") {
+		t.Errorf("Expected error for a path param missing on the interface, got %v", err)
+	}
+}
+
+func TestRRDPathConfined(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	writeRRD(t, filepath.Join(root, "core1.rrd"), binary.LittleEndian, 300, time.Now(), []string{"traffic_in"}, cactiRRAs())
+	writeRRD(t, filepath.Join(outside, "secret.rrd"), binary.LittleEndian, 300, time.Now(), []string{"traffic_in"}, cactiRRAs())
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	pathOf := func(template string, params map[string]interface{}) (string, error) {
+		ds := config.DataSourceConfig{Name: "cacti", Type: RRDPollerType, Params: map[string]interface{}{"path": template}}
+		return rrdPath(ds, config.InterfaceConfig{Name: "core-uplink", Params: params})
+	}
+
+	if _, err := pathOf("core1.rrd", nil); err == nil || !strings.Contains(err.Error(), "WEATHERMAP_RRD_DIR") {
+		t.Errorf("Expected rrd files refused without WEATHERMAP_RRD_DIR, got %v", err)
+	}
+	setRRDRoot(t, root)
+	path, err := pathOf("sub/../core1.rrd", nil)
+	if err != nil || path != filepath.Join(root, "core1.rrd") {
+		t.Fatalf("Expected path below the root, got %s (%v)", path, err)
+	}
+	if _, err := readRRD(path); err != nil {
+		t.Errorf("Expected a file of the root to be read, got %v", err)
+	}
+
+	for name, check := range map[string]struct {
+		template string
+		params   map[string]interface{}
+	}{
+		"parent":          {"../" + filepath.Base(outside) + "/secret.rrd", nil},
+		"parent in param": {"{host}.rrd", map[string]interface{}{"host": "../../etc/passwd"}},
+		"absolute":        {filepath.Join(outside, "secret.rrd"), nil},
+		"absolute param":  {"{file}", map[string]interface{}{"file": "/etc/passwd"}},
+	} {
+		if path, err := pathOf(check.template, check.params); err == nil {
+			t.Errorf("%s: expected path to be rejected, got %s", name, path)
+		}
+	}
+
+	path, err = pathOf("link/secret.rrd", nil)
+	if err != nil {
+		t.Fatalf("Expected the symlink path to expand, got %v", err)
+	}
+	if _, err := readRRD(path); err == nil || !strings.Contains(err.Error(), "outside of WEATHERMAP_RRD_DIR") {
+		t.Errorf("Expected a symlink out of the root to be refused, got %v", err)
+	}
+}
+
+func TestRRDPollerForgetsRemovedFiles(t *testing.T) {
+	poller := NewRRDPoller()
+	for _, name := range []string{"a", "b"} {
+		poller.EmbeddedPoller.AddTask(dataPollTask{Host: "/var/lib/cacti/rra/" + name + ".rrd", Key: name, DS: config.DataSourceConfig{Name: name}, Interval: time.Minute})
+		poller.stats.record("/var/lib/cacti/rra/"+name+".rrd", time.Minute, time.Millisecond, nil)
+	}
+	poller.RemoveTasks("a")
+	if stats := poller.PollStats(); len(stats) != 1 || stats[0].Target != "/var/lib/cacti/rra/b.rrd" {
+		t.Errorf("Expected only the stats of b.rrd left, got %+v", stats)
+	}
+}
+
+func TestRRDPollerDegraded(t *testing.T) {
+	ds := config.DataSourceConfig{
+		Name: "cacti",
+		Type: RRDPollerType,
+		Interfaces: []config.InterfaceConfig{
+			{Name: "core-uplink", Params: map[string]interface{}{"id": 42}},
+			{Name: "edge-uplink", Params: map[string]interface{}{"id": 43}},
+		},
+		Params: map[string]interface{}{"path": "traffic_{id}.rrd"},
+	}
+	setRRDRoot(t, "/var/lib/cacti/rra")
+	poller := NewRRDPoller()
+	for range breakerFailures {
+		poller.stats.record("/var/lib/cacti/rra/traffic_42.rrd", time.Minute, time.Millisecond, errors.New("truncated rrd file"))
+	}
+	if err := poller.checkAvailable(ds, ds.Interfaces[0]); !IsDegraded(err) {
+		t.Errorf("Expected the interface of the failing file degraded, got %v", err)
+	}
+	if err := poller.checkAvailable(ds, ds.Interfaces[1]); err != nil {
+		t.Errorf("Expected the interface of another file available, got %v", err)
+	}
+}
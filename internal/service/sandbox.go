@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-weathermap/internal/utils"
+)
+
+const (
+	// SandboxTTL is how long a sandbox map lives after it was created
+	SandboxTTL = 24 * time.Hour
+	// MaxSandboxes bounds the namespaces, callers without authentication choose their own keys
+	MaxSandboxes = 1000
+	// MaxSandboxMaps and MaxSandboxBytes bound the maps of a namespace and its files, maps
+	// and icons together
+	MaxSandboxMaps  = 50
+	MaxSandboxBytes = 16 << 20
+	// sandbox namespaces live next to the maps in their own directory, so they aren't listed as maps
+	sandboxDirName        = "sandbox"
+	sandboxIconsDir       = "icons"
+	sandboxExpiryInterval = 10 * time.Minute
+)
+
+// sandboxes keeps the map service of every sandbox namespace
+type sandboxes struct {
+	mu       sync.Mutex
+	services map[string]*MapService // directory -> service
+	expired  func(sandbox *MapService)
+}
+
+// SandboxEnabledFromEnv reads WEATHERMAP_SANDBOX, the sandbox is off unless it is true
+func SandboxEnabledFromEnv() (bool, error) {
+	value := strings.TrimSpace(os.Getenv("WEATHERMAP_SANDBOX"))
+	if value == "" {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid WEATHERMAP_SANDBOX: %s", value)
+	}
+	return enabled, nil
+}
+
+// Sandbox returns the map service of the sandbox namespace of key, an API client or caller
+// chosen string. Its maps are kept apart from the real ones and deleted by ExpireSandboxes.
+func (s *MapService) Sandbox(key string) (*MapService, error) {
+	sum := sha256.Sum256([]byte(key))
+	dir := filepath.Join(s.configDir, sandboxDirName, hex.EncodeToString(sum[:16]))
+
+	s.sandboxes.mu.Lock()
+	defer s.sandboxes.mu.Unlock()
+	if sandbox, ok := s.sandboxes.services[dir]; ok {
+		return sandbox, nil
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		namespaces, _ := os.ReadDir(filepath.Join(s.configDir, sandboxDirName))
+		if len(namespaces) >= MaxSandboxes {
+			return nil, fmt.Errorf("sandbox namespaces %w of %d", ErrLimit, MaxSandboxes)
+		}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create sandbox: %w", err)
+	}
+	sandbox := NewMapService(dir)
+	// icons uploaded with a bundle stay in the namespace, the server ones are only read
+	sandbox.iconsDir = filepath.Join(dir, sandboxIconsDir)
+	sandbox.baseIconsDir = s.iconsDir
+	sandbox.offline = true
+	sandbox.quota = &sandboxQuota{dir: dir}
+	sandbox.cipher = s.cipher
+	sandbox.logger = s.logger.With("sandbox", filepath.Base(dir))
+	if s.sandboxes.services == nil {
+		s.sandboxes.services = make(map[string]*MapService)
+	}
+	s.sandboxes.services[dir] = sandbox
+	return sandbox, nil
+}
+
+// OnSandboxExpired calls expired with the map service of every namespace ExpireSandboxes
+// deletes, so whatever was kept for it can be dropped too
+func (s *MapService) OnSandboxExpired(expired func(sandbox *MapService)) {
+	s.sandboxes.mu.Lock()
+	defer s.sandboxes.mu.Unlock()
+	s.sandboxes.expired = expired
+}
+
+// ExpireSandboxes deletes the sandbox maps created more than SandboxTTL before now, and the
+// namespaces left without maps
+func (s *MapService) ExpireSandboxes(now time.Time) (deleted int, err error) {
+	namespaces, err := filepath.Glob(filepath.Join(s.configDir, sandboxDirName, "*"))
+	if err != nil {
+		return 0, err
+	}
+	s.sandboxes.mu.Lock()
+	defer s.sandboxes.mu.Unlock()
+	for _, dir := range namespaces {
+		sandbox, ok := s.sandboxes.services[dir]
+		if !ok {
+			sandbox = NewMapService(dir)
+		}
+		names, err := sandbox.ListMaps()
+		if err != nil {
+			return deleted, err
+		}
+		remaining := len(names)
+		for _, name := range names {
+			if !sandboxMapExpired(sandbox, name, now) {
+				continue
+			}
+			if err := sandbox.DeleteMap(name); err != nil {
+				return deleted, fmt.Errorf("sandbox %s: %w", filepath.Base(dir), err)
+			}
+			deleted++
+			remaining--
+		}
+		if remaining == 0 {
+			if err := os.RemoveAll(dir); err != nil {
+				return deleted, err
+			}
+			delete(s.sandboxes.services, dir)
+			if ok && s.sandboxes.expired != nil {
+				s.sandboxes.expired(sandbox)
+			}
+		}
+	}
+	return deleted, nil
+}
+
+// sandboxQuota serializes the writes of a sandbox namespace, checked against MaxSandboxMaps
+// and MaxSandboxBytes
+type sandboxQuota struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// write replaces a file of the namespace like utils.WriteFileAtomic, unless the namespace would
+// exceed its bounds. Without a quota the file is written as it is.
+func (q *sandboxQuota) write(path string, data []byte) error {
+	if q == nil {
+		return utils.WriteFileAtomic(path, data)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var previous int64
+	if info, err := os.Stat(path); err == nil {
+		previous = info.Size()
+	} else if filepath.Dir(path) == q.dir && filepath.Ext(path) == ".yaml" {
+		maps, _ := filepath.Glob(filepath.Join(q.dir, "*.yaml"))
+		if len(maps) >= MaxSandboxMaps {
+			return fmt.Errorf("sandbox maps %w of %d", ErrLimit, MaxSandboxMaps)
+		}
+	}
+	var size int64
+	err := filepath.WalkDir(q.dir, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		info, err := entry.Info()
+		if err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read sandbox: %w", err)
+	}
+	if size-previous+int64(len(data)) > MaxSandboxBytes {
+		return fmt.Errorf("sandbox files %w of %d bytes", ErrLimit, MaxSandboxBytes)
+	}
+	return utils.WriteFileAtomic(path, data)
+}
+
+// sandboxMapExpired uses the creation time of the map, or its file for maps which don't parse
+func sandboxMapExpired(sandbox *MapService, name string, now time.Time) bool {
+	created := time.Time{}
+	if m, err := sandbox.loadMapConfig(name); err == nil && m.CreatedAt != nil {
+		created = *m.CreatedAt
+	} else if info, err := os.Stat(filepath.Join(sandbox.configDir, name+".yaml")); err == nil {
+		created = info.ModTime()
+	}
+	return !now.Before(created.Add(SandboxTTL))
+}
+
+// WatchSandboxes deletes expired sandbox maps every few minutes until Stop
+func (s *MapService) WatchSandboxes() {
+	s.loops.run(func(ctx context.Context) {
+		ticker := time.NewTicker(sandboxExpiryInterval)
+		defer ticker.Stop()
+		for {
+			if deleted, err := s.ExpireSandboxes(time.Now()); err != nil {
+				s.logger.Error("sandbox expiry failed", "error", err)
+			} else if deleted > 0 {
+				s.logger.Info("expired sandbox maps deleted", "maps", deleted)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
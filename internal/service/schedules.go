@@ -0,0 +1,249 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go-weathermap/internal/config"
+)
+
+const (
+	// calendars are fetched again after calendarRefresh, also after a failed fetch
+	calendarRefresh = 15 * time.Minute
+	calendarTimeout = 10 * time.Second
+	maxCalendarSize = 4 << 20
+)
+
+// ScheduleState tells whether a schedule of a map is active now
+type ScheduleState struct {
+	Name   string `json:"name"`
+	Active bool   `json:"active"`
+	Error  string `json:"error,omitempty"` // the calendar couldn't be fetched, its last events are used
+}
+
+type calendarEvent struct {
+	start, end time.Time
+}
+
+type calendarEntry struct {
+	events  []calendarEvent
+	fetched time.Time
+	err     error
+}
+
+// calendars keeps the events of the iCal calendars of schedules by URL
+type calendars struct {
+	mu      sync.Mutex
+	entries map[string]calendarEntry
+	client  *http.Client
+}
+
+// ScheduleStatus lists the schedules of a map and whether they are active now
+func (s *MapService) ScheduleStatus(ctx context.Context, mapName string) ([]ScheduleState, error) {
+	mapConfig, err := s.loadMapConfig(mapName)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	states := make([]ScheduleState, 0, len(mapConfig.Schedules))
+	for _, schedule := range mapConfig.Schedules {
+		state := ScheduleState{Name: schedule.Name}
+		state.Active, err = s.ScheduleActive(ctx, schedule, now)
+		if err != nil {
+			state.Error = err.Error()
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// ScheduleActive reports whether at is in a window or a calendar event of the schedule.
+// When the calendar can't be fetched the error is returned with the result of its last events,
+// sandboxes never fetch it.
+func (s *MapService) ScheduleActive(ctx context.Context, schedule config.Schedule, at time.Time) (bool, error) {
+	loc, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		return false, fmt.Errorf("invalid timezone: %s", schedule.Timezone)
+	}
+	if windowsActive(schedule.Windows, at.In(loc)) {
+		return true, nil
+	}
+	if schedule.ICalURL == "" {
+		return false, nil
+	}
+	if s.offline {
+		return false, fmt.Errorf("calendar %s: not fetched in a sandbox", schedule.ICalURL)
+	}
+	events, err := s.calendars.events(ctx, schedule.ICalURL, loc)
+	active := slices.ContainsFunc(events, func(e calendarEvent) bool {
+		return !at.Before(e.start) && at.Before(e.end)
+	})
+	return active, err
+}
+
+func windowsActive(windows []config.ScheduleWindow, at time.Time) bool {
+	clock := time.Duration(at.Hour())*time.Hour + time.Duration(at.Minute())*time.Minute + time.Duration(at.Second())*time.Second
+	today, yesterday := at.Weekday(), (at.Weekday()+6)%7
+	for _, window := range windows {
+		start, errStart := config.ParseClock(window.Start)
+		end, errEnd := config.ParseClock(window.End)
+		if errStart != nil || errEnd != nil {
+			continue
+		}
+		if start < end {
+			if onDay(window, today) && clock >= start && clock < end {
+				return true
+			}
+			continue
+		}
+		// crosses midnight, the early part belongs to the window of the day before
+		if (onDay(window, today) && clock >= start) || (onDay(window, yesterday) && clock < end) {
+			return true
+		}
+	}
+	return false
+}
+
+func onDay(window config.ScheduleWindow, day time.Weekday) bool {
+	if len(window.Days) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(window.Days, func(d string) bool {
+		return strings.EqualFold(d, config.ScheduleDays[day])
+	})
+}
+
+// events returns the events of the calendar, fetched again once older than calendarRefresh
+func (c *calendars) events(ctx context.Context, url string, loc *time.Location) ([]calendarEvent, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[url]
+	c.mu.Unlock()
+	if ok && time.Since(entry.fetched) < calendarRefresh {
+		return entry.events, entry.err
+	}
+
+	events, err := c.fetch(ctx, url, loc)
+	if err != nil {
+		events = entry.events
+		err = fmt.Errorf("calendar %s: %w", url, err)
+	}
+	c.mu.Lock()
+	c.entries[url] = calendarEntry{events: events, fetched: time.Now(), err: err}
+	c.mu.Unlock()
+	return events, err
+}
+
+func (c *calendars) fetch(ctx context.Context, url string, loc *time.Location) ([]calendarEvent, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return parseICal(io.LimitReader(resp.Body, maxCalendarSize), loc)
+}
+
+// parseICal reads the VEVENTs of an iCalendar (RFC 5545) document. Recurrence rules aren't
+// expanded, paging services export every shift as an event. Times without a zone are in loc.
+func parseICal(r io.Reader, loc *time.Location) ([]calendarEvent, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxCalendarSize)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		// folded lines continue with a space or a tab
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 || !strings.EqualFold(lines[0], "BEGIN:VCALENDAR") {
+		return nil, fmt.Errorf("not an iCalendar document")
+	}
+
+	var events []calendarEvent
+	var event *calendarEvent
+	var cancelled, allDay bool
+	for _, line := range lines {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		name, params, _ := strings.Cut(name, ";")
+		switch strings.ToUpper(name) {
+		case "BEGIN":
+			if strings.EqualFold(value, "VEVENT") {
+				event, cancelled, allDay = &calendarEvent{}, false, false
+			}
+		case "END":
+			if !strings.EqualFold(value, "VEVENT") || event == nil {
+				continue
+			}
+			if event.end.IsZero() && allDay {
+				event.end = event.start.AddDate(0, 0, 1)
+			}
+			if !cancelled && !event.start.IsZero() && event.end.After(event.start) {
+				events = append(events, *event)
+			}
+			event = nil
+		case "DTSTART", "DTEND":
+			if event == nil {
+				continue
+			}
+			t, date, err := parseICalTime(value, params, loc)
+			if err != nil {
+				return nil, err
+			}
+			if strings.EqualFold(name, "DTSTART") {
+				event.start, allDay = t, date
+			} else {
+				event.end = t
+			}
+		case "STATUS":
+			cancelled = strings.EqualFold(value, "CANCELLED")
+		}
+	}
+	return events, nil
+}
+
+// parseICalTime parses a DATE-TIME in UTC (Z suffix), in the TZID of params or local to loc,
+// or a DATE, which is reported as date
+func parseICalTime(value, params string, loc *time.Location) (t time.Time, date bool, err error) {
+	for _, param := range strings.Split(params, ";") {
+		if key, tzid, ok := strings.Cut(param, "="); ok && strings.EqualFold(key, "TZID") {
+			if tz, err := time.LoadLocation(strings.Trim(tzid, `"`)); err == nil {
+				loc = tz
+			}
+		}
+	}
+	switch {
+	case strings.HasSuffix(value, "Z"):
+		t, err = time.Parse("20060102T150405Z", value)
+	case len(value) == len("20060102"):
+		t, err = time.ParseInLocation("20060102", value, loc)
+		date = true
+	default:
+		t, err = time.ParseInLocation("20060102T150405", value, loc)
+	}
+	if err != nil {
+		return t, date, fmt.Errorf("invalid iCalendar time: %s", value)
+	}
+	return t, date, nil
+}
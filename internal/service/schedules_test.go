@@ -0,0 +1,77 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go-weathermap/internal/config"
+)
+
+func TestWindowsActive(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("No time zone data: %v", err)
+	}
+	businessHours := []config.ScheduleWindow{{Days: []string{"mon", "tue", "wed", "thu", "Fri"}, Start: "08:00", End: "18:00"}}
+	night := []config.ScheduleWindow{{Days: []string{"fri"}, Start: "22:00", End: "06:00"}}
+	for _, c := range []struct {
+		windows []config.ScheduleWindow
+		at      time.Time
+		active  bool
+	}{
+		{businessHours, time.Date(2025, 3, 7, 8, 0, 0, 0, berlin), true}, // friday
+		{businessHours, time.Date(2025, 3, 7, 18, 0, 0, 0, berlin), false},
+		{businessHours, time.Date(2025, 3, 8, 12, 0, 0, 0, berlin), false}, // saturday
+		{night, time.Date(2025, 3, 7, 23, 0, 0, 0, berlin), true},
+		{night, time.Date(2025, 3, 8, 5, 59, 0, 0, berlin), true}, // friday night, saturday morning
+		{night, time.Date(2025, 3, 7, 5, 0, 0, 0, berlin), false}, // thursday night
+		{[]config.ScheduleWindow{{Start: "00:00", End: "24:00"}}, time.Date(2025, 3, 9, 23, 59, 0, 0, berlin), true},
+	} {
+		if got := windowsActive(c.windows, c.at); got != c.active {
+			t.Errorf("Expected %v active=%v at %s, got %v", c.windows, c.active, c.at, got)
+		}
+	}
+}
+
+func TestParseICal(t *testing.T) {
+	calendar := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"BEGIN:VEVENT",
+		"SUMMARY:NOC on-call",
+		"DTSTART:20250307T170000Z",
+		"DTEND:20250308T070000Z",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"DTSTART;TZID=Europe/Berlin:20250308T",
+		" 090000",
+		"DTEND;TZID=Europe/Berlin:20250308T100000",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"DTSTART;VALUE=DATE:20250310",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"STATUS:CANCELLED",
+		"DTSTART:20250311T000000Z",
+		"DTEND:20250312T000000Z",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n")
+	events, err := parseICal(strings.NewReader(calendar), time.UTC)
+	if err != nil {
+		t.Fatalf("Failed to parse calendar: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events without the cancelled one, got %v", events)
+	}
+	if !events[1].start.Equal(time.Date(2025, 3, 8, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected folded TZID start at 08:00 UTC, got %s", events[1].start)
+	}
+	if !events[2].end.Equal(time.Date(2025, 3, 11, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected all-day event to last a day, got %s", events[2].end)
+	}
+
+	if _, err := parseICal(strings.NewReader("<html></html>"), time.UTC); err == nil {
+		t.Errorf("Expected non calendar to be rejected")
+	}
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"net/netip"
+
+	"go-weathermap/internal/config"
+)
+
+// AddressMatch is a map object owning an address, Field tells which of its settings matched
+type AddressMatch struct {
+	Map   string `json:"map"`
+	Type  string `json:"type"` // node or link
+	Name  string `json:"name"`
+	Field string `json:"field"`
+	Value string `json:"value"`
+}
+
+// SearchAddress finds nodes and links of all maps owning addr: management and loopback
+// addresses equal to it, node and link subnets containing it
+func (s *MapService) SearchAddress(addr netip.Addr) ([]AddressMatch, error) {
+	mapNames, err := s.ListMaps()
+	if err != nil {
+		return nil, err
+	}
+	matches := []AddressMatch{}
+	for _, mapName := range mapNames {
+		mapConfig, err := s.loadMapConfig(mapName)
+		if err != nil {
+			continue // broken maps are reported when they are opened
+		}
+		for _, node := range mapConfig.Nodes {
+			match := func(field, value string) {
+				matches = append(matches, AddressMatch{Map: mapName, Type: "node", Name: node.Name, Field: field, Value: value})
+			}
+			if sameAddress(node.ManagementIP, addr) {
+				match("management_ip", node.ManagementIP)
+			}
+			if sameAddress(node.Loopback, addr) {
+				match("loopback", node.Loopback)
+			}
+			for _, subnet := range node.Subnets {
+				if inSubnet(subnet, addr) {
+					match("subnets", subnet)
+				}
+			}
+		}
+		for _, link := range mapConfig.Links {
+			if inSubnet(link.Subnet, addr) {
+				matches = append(matches, AddressMatch{Map: mapName, Type: "link", Name: link.Name, Field: "subnet", Value: link.Subnet})
+			}
+		}
+	}
+	return matches, nil
+}
+
+func sameAddress(value string, addr netip.Addr) bool {
+	if value == "" {
+		return false
+	}
+	parsed, err := config.ParseAddress(value)
+	return err == nil && parsed.Unmap() == addr.Unmap()
+}
+
+func inSubnet(value string, addr netip.Addr) bool {
+	if value == "" {
+		return false
+	}
+	prefix, err := netip.ParsePrefix(value)
+	return err == nil && prefix.Contains(addr.Unmap())
+}
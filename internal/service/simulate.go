@@ -0,0 +1,128 @@
+package service
+
+import (
+	"fmt"
+	"math"
+
+	"go-weathermap/internal/config"
+)
+
+const DefaultOverloadThreshold = 100.0
+
+// FailureScenario lists the links and nodes assumed failed by Simulate
+type FailureScenario struct {
+	Links     []string `json:"links"`
+	Nodes     []string `json:"nodes"`
+	Threshold float64  `json:"threshold,omitempty"` // projected utilization reported as overloaded, 100 by default
+}
+
+type SimulatedLink struct {
+	Name                 string  `json:"name"`
+	Status               string  `json:"status"` // failed for links of the scenario
+	Utilization          float64 `json:"utilization"`
+	ProjectedUtilization float64 `json:"projected_utilization"`
+	Overloaded           bool    `json:"overloaded"`
+}
+
+type SimulationResult struct {
+	Failed     []string        `json:"failed"`     // scenario links and links of failed nodes
+	Unrouted   []string        `json:"unrouted"`   // failed links whose traffic found no other path
+	Overloaded []string        `json:"overloaded"` // links above the threshold after rerouting
+	Links      []SimulatedLink `json:"links"`
+}
+
+// Simulate estimates link utilization after a failure from the current traffic. The traffic
+// of a failed link moves to the cheapest remaining path between its two nodes, traffic of
+// links attached to a failed node is dropped with the node. Links already down stay unused.
+func Simulate(m *config.MapWithData, scenario FailureScenario) (*SimulationResult, error) {
+	links := make(map[string]config.Link, len(m.Links))
+	for _, link := range m.Links {
+		links[link.Name] = link
+	}
+	nodes := make(map[string]bool, len(m.Nodes))
+	for _, node := range m.Nodes {
+		nodes[node.Name] = true
+	}
+	failedNodes := make(map[string]bool, len(scenario.Nodes))
+	for _, name := range scenario.Nodes {
+		if !nodes[name] {
+			return nil, fmt.Errorf("node not found: %s", name)
+		}
+		failedNodes[name] = true
+	}
+	failed := make(map[string]bool, len(scenario.Links))
+	for _, name := range scenario.Links {
+		if _, ok := links[name]; !ok {
+			return nil, fmt.Errorf("link not found: %s", name)
+		}
+		failed[name] = true
+	}
+	for _, link := range m.Links {
+		if failedNodes[link.From] || failedNodes[link.To] {
+			failed[link.Name] = true
+		}
+	}
+	threshold := scenario.Threshold
+	if threshold <= 0 {
+		threshold = DefaultOverloadThreshold
+	}
+
+	data := make(map[string]config.LinkData, len(m.LinksData))
+	unusable := make(map[string]bool, len(failed))
+	for _, linkData := range m.LinksData {
+		data[linkData.Name] = linkData
+		if linkData.Status == "down" {
+			unusable[linkData.Name] = true
+		}
+	}
+	for name := range failed {
+		unusable[name] = true
+	}
+
+	// load in bandwidth units, so links of different capacity can be added up
+	load := make(map[string]float64, len(m.Links))
+	for _, link := range m.Links {
+		load[link.Name] = data[link.Name].Utilization / 100 * float64(linkBandwidth(link, data[link.Name]))
+	}
+
+	result := &SimulationResult{Failed: []string{}, Unrouted: []string{}, Overloaded: []string{}}
+	for _, link := range m.Links {
+		if !failed[link.Name] {
+			continue
+		}
+		result.Failed = append(result.Failed, link.Name)
+		if failedNodes[link.From] || failedNodes[link.To] || load[link.Name] == 0 {
+			continue
+		}
+		path, err := shortestPath(m.Map, link.From, link.To, unusable)
+		if err != nil {
+			result.Unrouted = append(result.Unrouted, link.Name)
+			continue
+		}
+		for _, name := range path.Links {
+			load[name] += load[link.Name]
+		}
+	}
+
+	for _, link := range m.Links {
+		simulated := SimulatedLink{
+			Name:        link.Name,
+			Status:      data[link.Name].Status,
+			Utilization: data[link.Name].Utilization,
+		}
+		if simulated.Status == "" {
+			simulated.Status = "unknown"
+		}
+		if failed[link.Name] {
+			simulated.Status = "failed"
+		} else if bw := linkBandwidth(link, data[link.Name]); bw > 0 {
+			simulated.ProjectedUtilization = math.Round(load[link.Name]/float64(bw)*1000) / 10
+			simulated.Overloaded = simulated.ProjectedUtilization > threshold
+		}
+		if simulated.Overloaded {
+			result.Overloaded = append(result.Overloaded, link.Name)
+		}
+		result.Links = append(result.Links, simulated)
+	}
+	return result, nil
+}
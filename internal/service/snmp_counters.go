@@ -0,0 +1,207 @@
+package service
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/datasource"
+	"go-weathermap/internal/utils"
+)
+
+// ifHCOctetsOIDs maps the 32-bit octet counters of IF-MIB to their 64-bit high capacity (HC)
+// counterparts, the ifIndex follows both
+var ifHCOctetsOIDs = map[string]string{
+	"1.3.6.1.2.1.2.2.1.10.": "1.3.6.1.2.1.31.1.1.1.6.",  // ifInOctets -> ifHCInOctets
+	"1.3.6.1.2.1.2.2.1.16.": "1.3.6.1.2.1.31.1.1.1.10.", // ifOutOctets -> ifHCOutOctets
+}
+
+// maxLineRateRatio is how much faster than its interface an octet counter may count before its
+// delta is taken for a reset, room for devices updating their counters every few seconds
+const maxLineRateRatio = 2
+
+// octetCounterOIDs returns the HC and the 32-bit OID of the IF-MIB octet counter oid is one of,
+// ok is false for other OIDs
+func octetCounterOIDs(oid string) (hc, low string, ok bool) {
+	oid = strings.TrimPrefix(oid, ".")
+	for lowPrefix, hcPrefix := range ifHCOctetsOIDs {
+		for _, prefix := range []string{lowPrefix, hcPrefix} {
+			if ifIndex, ok := strings.CutPrefix(oid, prefix); ok && ifIndex != "" && !strings.Contains(ifIndex, ".") {
+				return "." + hcPrefix + ifIndex, "." + lowPrefix + ifIndex, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// pollOIDs returns the OIDs to get for task. An octet counter is read from both widths until
+// counterValue selected one.
+func (g *snmpGroup) pollOIDs(task dataPollTask) []string {
+	if oid, ok := g.counters[task.MetricIdentifier]; ok {
+		return []string{oid}
+	}
+	if hc, low, ok := octetCounterOIDs(task.MetricIdentifier); ok && !task.Gauge {
+		return []string{hc, low}
+	}
+	return []string{task.MetricIdentifier}
+}
+
+// counterValue returns the value of task in values and the OID it was read from. Octet
+// counters, configured with either width, are read from ifHCInOctets/ifHCOutOctets when the
+// device has them, a 32-bit counter wraps in 34s at 1 Gbit/s. Devices answering
+// noSuchObject or noSuchInstance for the HC counter, which GetMany leaves out of values,
+// fall back to ifInOctets/ifOutOctets. A selected counter the device stops answering is
+// selected again on the next poll.
+func (g *snmpGroup) counterValue(logger *slog.Logger, task dataPollTask, values map[string]datasource.SNMPValue) (datasource.SNMPValue, string, bool) {
+	hc, low, ok := octetCounterOIDs(task.MetricIdentifier)
+	if !ok || task.Gauge {
+		val, ok := values[task.MetricIdentifier]
+		return val, task.MetricIdentifier, ok
+	}
+	if oid, ok := g.counters[task.MetricIdentifier]; ok {
+		val, ok := values[oid]
+		if !ok {
+			delete(g.counters, task.MetricIdentifier)
+		}
+		return val, oid, ok
+	}
+	for _, oid := range []string{hc, low} {
+		if val, ok := values[oid]; ok {
+			g.counters[task.MetricIdentifier] = oid
+			logger.Debug("snmp counter selected", "target", g.device, "oid", task.MetricIdentifier, "counter", oid, "hc", oid == hc)
+			return val, oid, true
+		}
+	}
+	return datasource.SNMPValue{}, task.MetricIdentifier, false
+}
+
+// lineRateSetter is a poller checking counter rates against the bandwidth of the links
+// reading them
+type lineRateSetter interface {
+	setLineRates(rates []interfaceRate)
+}
+
+// interfaceRate is the bandwidth in bytes/s of the links reading an interface
+type interfaceRate struct {
+	ds    config.DataSourceConfig
+	iface config.InterfaceConfig
+	rate  int64
+}
+
+// interfaceRef names an interface of a datasource
+type interfaceRef struct {
+	ds, iface string
+}
+
+// SetLinkBandwidths keeps the bandwidth of the links of the maps as the line rate of the
+// counters they read, for the ones without ifHighSpeed. It replaces the bandwidths of the
+// previous call, interfaces no longer read by a link lose theirs.
+func (s *DataSourceService) SetLinkBandwidths(maps []*config.Map) {
+	bandwidths := make(map[interfaceRef]int64)
+	for _, m := range maps {
+		for _, link := range m.Links {
+			if link.DataSource == "" || link.Interface == "" || link.Bandwidth == config.BandwidthAuto {
+				continue
+			}
+			ref := interfaceRef{link.DataSource, link.Interface}
+			bandwidths[ref] = max(bandwidths[ref], utils.ParseBandwidth(link.Bandwidth))
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bandwidths = bandwidths
+	s.applyLineRatesLocked()
+}
+
+// LoadLinkBandwidths sets the link bandwidths of the maps of configDir, see SetLinkBandwidths.
+// Maps which don't parse are left out.
+func (s *DataSourceService) LoadLinkBandwidths(configDir string) {
+	var maps []*config.Map
+	_ = eachMapFile(configDir, func(_ string, m *config.Map) { maps = append(maps, m) })
+	s.SetLinkBandwidths(maps)
+}
+
+// applyLineRatesLocked passes the link bandwidths to the pollers of their datasources, after
+// they changed or the datasources were reloaded
+func (s *DataSourceService) applyLineRatesLocked() {
+	rates := make(map[string][]interfaceRate)
+	for ref, rate := range s.bandwidths {
+		ds, ok := s.datasources[ref.ds]
+		if !ok || rate <= 0 {
+			continue
+		}
+		if i := slices.IndexFunc(ds.Interfaces, func(iface config.InterfaceConfig) bool { return iface.Name == ref.iface }); i >= 0 {
+			pollerType := cmp.Or(ds.Type, SNMPPollerType)
+			rates[pollerType] = append(rates[pollerType], interfaceRate{ds, ds.Interfaces[i], rate})
+		}
+	}
+	for pollerType, poller := range s.pollers {
+		if setter, ok := poller.(lineRateSetter); ok {
+			setter.setLineRates(rates[pollerType])
+		}
+	}
+}
+
+// setLineRates replaces the bandwidths in bytes/s of the links reading the counters of the
+// interfaces, the line rate of counters without ifHighSpeed read in the same poll
+func (p *SNMPPoller) setLineRates(rates []interfaceRate) {
+	lineRates := make(map[string]int64)
+	for _, r := range rates {
+		target, err := datasource.ParseSNMPTarget(r.ds.Params)
+		if err != nil {
+			continue
+		}
+		for _, metricName := range []string{"in", "out"} {
+			if oid, ok := snmpOID(r.iface, metricName); ok {
+				lineRates[snmpTaskKey(target, oid)] = r.rate
+			}
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lineRates = lineRates
+}
+
+// lineRate returns the line rate in bytes/s of the interface of the octet counter of task:
+// its ifHighSpeed when read in the same poll, as it is for links with bandwidth auto, else
+// the bandwidth of the link reading it, 0 when neither is known
+func (p *SNMPPoller) lineRate(task dataPollTask, oid string, values map[string]datasource.SNMPValue) int64 {
+	if rate := ifLineRate(oid, values); rate > 0 {
+		return rate
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.lineRates[task.Key]
+}
+
+// ifLineRate returns the line rate in bytes/s of the interface of an octet counter from its
+// ifHighSpeed, 0 unless it was read in the same poll
+func ifLineRate(oid string, values map[string]datasource.SNMPValue) int64 {
+	_, low, ok := octetCounterOIDs(oid)
+	if !ok {
+		return 0
+	}
+	speed, ok := values[ifHighSpeedOID+low[strings.LastIndex(low, ".")+1:]]
+	if !ok {
+		return 0
+	}
+	return speed.Value * 1_000_000 / 8
+}
+
+// counterRate returns the rate of a counter between last and val, read elapsed seconds apart.
+// It fails on a counter reset the wrap correction can't tell from a wrap by its value: a
+// Counter64 going backwards, or a rate above maxLineRateRatio times the line rate.
+func counterRate(last counterSample, val datasource.SNMPValue, elapsed float64, lineRate int64) (int64, error) {
+	delta := counterDelta(last.value, val.Value, val.Width)
+	if delta < 0 {
+		return 0, fmt.Errorf("counter went back from %d to %d", uint64(last.value), uint64(val.Value))
+	}
+	rate := int64(float64(delta) / elapsed)
+	if lineRate > 0 && rate > maxLineRateRatio*lineRate {
+		return 0, fmt.Errorf("rate %d B/s above the line rate of %d B/s", rate, lineRate)
+	}
+	return rate, nil
+}
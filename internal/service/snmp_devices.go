@@ -0,0 +1,118 @@
+package service
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/datasource"
+)
+
+// sysUpTimeOID is read with the interface counters of every SNMP poll, in hundredths of a second
+const sysUpTimeOID = "1.3.6.1.2.1.1.3.0"
+
+// snmpDevice is what the polls of an SNMP datasource tell about its device
+type snmpDevice struct {
+	lastPoll   time.Time
+	err        string // of the last poll, the device is down while set
+	uptime     int64  // sysUpTime of the last successful poll, -1 when the device doesn't answer it
+	rebootedAt time.Time
+}
+
+// recordDevice keeps the outcome of a poll for every datasource of tasks and reports whether
+// the device rebooted since the previous one. A sysUpTime lower than the one of the previous
+// poll is a reboot, unless it wrapped around 2^32 since then.
+func (p *SNMPPoller) recordDevice(tasks []dataPollTask, values map[string]datasource.SNMPValue, err error, now time.Time) (rebooted bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.devices == nil {
+		p.devices = make(map[string]*snmpDevice)
+	}
+	logger := cmp.Or(p.logger, slog.Default())
+	seen := make(map[string]bool, 1)
+	for _, task := range tasks {
+		if seen[task.DS.Name] {
+			continue
+		}
+		seen[task.DS.Name] = true
+		device, ok := p.devices[task.DS.Name]
+		if !ok {
+			device = &snmpDevice{uptime: -1}
+			p.devices[task.DS.Name] = device
+		}
+		elapsed := now.Sub(device.lastPoll)
+		device.lastPoll = now
+		if err != nil {
+			device.err = err.Error()
+			continue
+		}
+		device.err = ""
+		value, ok := values[sysUpTimeOID]
+		uptime := value.Value
+		if !ok {
+			device.uptime = -1
+			continue
+		}
+		if device.uptime >= 0 && uptimeReset(device.uptime, uptime, elapsed) {
+			device.rebootedAt = now.Add(-time.Duration(uptime) * 10 * time.Millisecond)
+			rebooted = true
+			logger.Warn("snmp device rebooted", "datasource", task.DS.Name, "uptime", time.Duration(uptime)*10*time.Millisecond)
+		}
+		device.uptime = uptime
+	}
+	return rebooted
+}
+
+// uptimeReset reports whether sysUpTime went from prev to cur, read elapsed apart, because the
+// agent restarted rather than by a wrap around 2^32 after 497 days
+func uptimeReset(prev, cur int64, elapsed time.Duration) bool {
+	return cur < prev && cur+(1<<32)-prev > 2*elapsed.Milliseconds()/10
+}
+
+// deviceStatus is the node status of the device of an SNMP datasource: up after a successful
+// poll, down after a failed one
+func (p *SNMPPoller) deviceStatus(dsName string) config.NodeData {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	device, ok := p.devices[dsName]
+	if !ok {
+		return config.NodeData{Status: "unknown", Error: "not polled yet"}
+	}
+	checkedAt := device.lastPoll
+	if device.err != "" {
+		return config.NodeData{Status: "down", CheckedAt: &checkedAt, Error: device.err}
+	}
+	data := config.NodeData{Status: "up", CheckedAt: &checkedAt}
+	if device.uptime >= 0 {
+		uptime := device.uptime / 100
+		data.UptimeSeconds = &uptime
+	}
+	if !device.rebootedAt.IsZero() {
+		rebootedAt := device.rebootedAt
+		data.RebootedAt = &rebootedAt
+	}
+	return data
+}
+
+// SNMPNodeStatus returns the status of the device polled by an SNMP datasource, ok is false
+// for datasources of other types
+func (s *DataSourceService) SNMPNodeStatus(dsName string) (data config.NodeData, ok bool) {
+	s.mu.RLock()
+	ds, found := s.datasources[dsName]
+	poller, _ := s.pollers[SNMPPollerType].(*SNMPPoller)
+	cluster := s.cluster
+	s.mu.RUnlock()
+	switch {
+	case !found:
+		return config.NodeData{Status: "unknown", Error: fmt.Sprintf("datasource not found: %s", dsName)}, true
+	case cmp.Or(ds.Type, SNMPPollerType) != SNMPPollerType:
+		return config.NodeData{}, false
+	case cluster != nil && !cluster.owns(dsName):
+		return config.NodeData{Status: "unknown", Error: "polled by another instance"}, true
+	case poller == nil:
+		return config.NodeData{Status: "unknown", Error: "not polled yet"}, true
+	}
+	return poller.deviceStatus(dsName), true
+}
@@ -0,0 +1,609 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/datasource"
+	"go-weathermap/internal/snmpsim"
+	"go-weathermap/internal/utils"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+const (
+	ifInOctets1  = ".1.3.6.1.2.1.2.2.1.10.1"
+	ifOutOctets1 = ".1.3.6.1.2.1.2.2.1.16.1"
+	ifInOctets2  = ".1.3.6.1.2.1.2.2.1.10.2"
+
+	ifHCInOctets1 = ".1.3.6.1.2.1.31.1.1.1.6.1"
+)
+
+func newSimulator(t *testing.T) *snmpsim.Server {
+	t.Helper()
+	sim, err := snmpsim.NewServer("public")
+	if err != nil {
+		t.Fatalf("Failed to start SNMP simulator: %v", err)
+	}
+	t.Cleanup(func() { _ = sim.Close() })
+	if err := sim.LoadFile("testdata/lab-router.snmprec"); err != nil {
+		t.Fatalf("Failed to load fixture: %v", err)
+	}
+	return sim
+}
+
+// setOctets makes an octet counter and its HC counterpart count rate bytes per second
+func setOctets(sim *snmpsim.Server, oid string, rate float64) {
+	hc, low, _ := octetCounterOIDs(oid)
+	sim.SetCounter(low, gosnmp.Counter32, 0, rate)
+	sim.SetCounter(hc, gosnmp.Counter64, 0, rate)
+}
+
+func simDataSource(sim *snmpsim.Server, community string) config.DataSourceConfig {
+	return config.DataSourceConfig{
+		Name: "lab-router",
+		Type: SNMPPollerType,
+		Interfaces: []config.InterfaceConfig{{
+			Name: "Gi0/0/0",
+			Params: map[string]interface{}{
+				"oids": map[string]interface{}{"in": ifInOctets1, "out": ifOutOctets1},
+			},
+		}},
+		Params: map[string]interface{}{
+			"host":      sim.Host(),
+			"port":      sim.Port(),
+			"community": community,
+		},
+	}
+}
+
+func waitForMetric(t *testing.T, poller Poller, ds config.DataSourceConfig, metric string) int64 {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if val, _ := poller.GetMetric(ds, ds.Interfaces[0], metric).(int64); val > 0 {
+			return val
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("No value for metric %s after 5s", metric)
+	return 0
+}
+
+func assertRate(t *testing.T, name string, got int64, want float64) {
+	t.Helper()
+	if math.Abs(float64(got)-want)/want > 0.15 {
+		t.Errorf("%s: expected rate ~%.0f B/s, got %d", name, want, got)
+	}
+}
+
+func TestSNMPClientAgainstSimulator(t *testing.T) {
+	sim := newSimulator(t)
+	ds := simDataSource(sim, "public")
+
+	val, err := datasource.GetGlobalSNMPClient().Get(context.Background(), ds, ifInOctets2)
+	if err != nil {
+		t.Fatalf("SNMP Get failed: %v", err)
+	}
+	if val.(int64) != 1234567 {
+		t.Errorf("Expected fixture value 1234567, got %v", val)
+	}
+
+	if _, err := datasource.GetGlobalSNMPClient().Get(context.Background(), ds, ".1.3.6.1.2.1.2.2.1.10.99"); err == nil {
+		t.Error("Expected error for OID missing on the device")
+	}
+}
+
+func TestSNMPClientDNSTarget(t *testing.T) {
+	sim := newSimulator(t)
+	ds := simDataSource(sim, "public")
+	ds.Params["host"] = "localhost"
+
+	if err := ValidateDataSources([]config.DataSourceConfig{ds}); err != nil {
+		t.Fatalf("Unexpected validation error: %v", err)
+	}
+	if _, err := datasource.GetGlobalSNMPClient().Get(context.Background(), ds, ifInOctets2); err != nil {
+		t.Errorf("SNMP Get by DNS name failed: %v", err)
+	}
+
+	ds.Params["host"] = "bad host"
+	if err := ValidateDataSources([]config.DataSourceConfig{ds}); err == nil {
+		t.Error("Expected validation error for malformed host")
+	}
+}
+
+func TestValidateSNMPTimeouts(t *testing.T) {
+	ds := config.DataSourceConfig{
+		Name:         "satellite-site",
+		Type:         SNMPPollerType,
+		PollInterval: 30,
+		Params:       map[string]interface{}{"host": "10.0.0.1", "timeout": "8s", "retries": 2},
+	}
+	if err := ValidateDataSources([]config.DataSourceConfig{ds}); err != nil {
+		t.Errorf("Unexpected error for 24s worst case within 30s interval: %v", err)
+	}
+	ds.PollInterval = 10
+	if err := ValidateDataSources([]config.DataSourceConfig{ds}); err == nil {
+		t.Error("Expected error when retries can outlast the poll interval")
+	}
+}
+
+func TestSNMPGroupKeyTimeouts(t *testing.T) {
+	task := func(params map[string]interface{}) dataPollTask {
+		return dataPollTask{Host: "10.0.0.1", Port: 161, Community: "public", DS: config.DataSourceConfig{Params: params}}
+	}
+	fast := task(map[string]interface{}{"host": "10.0.0.1"})
+	slow := task(map[string]interface{}{"host": "10.0.0.1", "timeout": "8s", "retries": 2})
+	if snmpGroupKey(fast) == snmpGroupKey(slow) {
+		t.Error("Expected datasources with their own timeout and retries polled in their own Get")
+	}
+	if snmpGroupKey(slow) != snmpGroupKey(task(map[string]interface{}{"host": "10.0.0.1", "timeout": "8s", "retries": 2})) {
+		t.Error("Expected datasources with the same settings batched")
+	}
+}
+
+func TestSNMPPollerRateCalculation(t *testing.T) {
+	sim := newSimulator(t)
+	setOctets(sim, ifInOctets1, 125_000)  // 1 Mbit/s
+	setOctets(sim, ifOutOctets1, 500_000) // 4 Mbit/s
+	ds := simDataSource(sim, "public")
+
+	poller := NewSNMPPoller()
+	poller.AddTask(ds, ds.Interfaces[0], "in", 300*time.Millisecond)
+	poller.AddTask(ds, ds.Interfaces[0], "out", 300*time.Millisecond)
+	poller.Start()
+
+	assertRate(t, "in", waitForMetric(t, poller, ds, "in"), 125_000)
+	assertRate(t, "out", waitForMetric(t, poller, ds, "out"), 500_000)
+
+	stats := poller.PollStats()
+	if len(stats) != 1 || stats[0].Polls < 2 || stats[0].Slow {
+		t.Errorf("Expected one fast target with polls recorded, got %+v", stats)
+	}
+}
+
+func TestSNMPPollerBatchesHostOIDs(t *testing.T) {
+	sim := newSimulator(t)
+	setOctets(sim, ifInOctets1, 125_000)
+	setOctets(sim, ifOutOctets1, 500_000)
+	setOctets(sim, ifInOctets2, 250_000)
+	ds := simDataSource(sim, "public")
+	ds.Interfaces = append(ds.Interfaces, config.InterfaceConfig{
+		Name:   "Gi0/0/1",
+		Params: map[string]interface{}{"oids": map[string]interface{}{"in": ifInOctets2}},
+	})
+
+	poller := NewSNMPPoller()
+	poller.AddTask(ds, ds.Interfaces[0], "in", 200*time.Millisecond)
+	poller.AddTask(ds, ds.Interfaces[0], "out", 200*time.Millisecond)
+	poller.AddTask(ds, ds.Interfaces[1], "in", 200*time.Millisecond)
+	poller.Start()
+
+	waitForMetric(t, poller, ds, "in")
+	time.Sleep(time.Second)
+	if val, _ := poller.GetMetric(ds, ds.Interfaces[1], "in").(int64); val == 0 {
+		t.Error("Expected a rate for the second interface")
+	}
+	// ~6 ticks, three tasks each would be ~18 requests unbatched
+	if requests := sim.Requests(); requests > 9 {
+		t.Errorf("Expected one request per poll cycle, simulator got %d", requests)
+	}
+}
+
+func TestSNMPPollerStop(t *testing.T) {
+	sim := newSimulator(t)
+	setOctets(sim, ifInOctets1, 125_000)
+	ds := simDataSource(sim, "public")
+
+	poller := NewSNMPPoller()
+	poller.AddTask(ds, ds.Interfaces[0], "in", 100*time.Millisecond)
+	poller.Start()
+	waitForMetric(t, poller, ds, "in")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := poller.Stop(ctx); err != nil {
+		t.Fatalf("Failed to stop poller: %v", err)
+	}
+	requests := sim.Requests()
+	time.Sleep(300 * time.Millisecond)
+	if sim.Requests() != requests {
+		t.Errorf("Expected no polls after Stop, got %d more", sim.Requests()-requests)
+	}
+}
+
+func TestSNMPPollerCounterWrap(t *testing.T) {
+	sim := newSimulator(t)
+	// a device without HC counters, wraps roughly 0.5s after start
+	sim.Delete(ifHCInOctets1)
+	sim.SetCounter(ifInOctets1, gosnmp.Counter32, math.MaxUint32-1_000_000, 2_000_000)
+	ds := simDataSource(sim, "public")
+
+	poller := NewSNMPPoller()
+	poller.AddTask(ds, ds.Interfaces[0], "in", 300*time.Millisecond)
+	poller.Start()
+
+	deadline := time.Now().Add(1500 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		val := waitForMetric(t, poller, ds, "in")
+		assertRate(t, "in across wrap", val, 2_000_000)
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestSNMPPollerHCCounters(t *testing.T) {
+	sim := newSimulator(t)
+	// the HC counter wraps roughly 0.5s after start, the 32-bit one is stuck
+	sim.SetCounter(ifHCInOctets1, gosnmp.Counter64, math.MaxUint64-1_000_000, 2_000_000)
+	ds := simDataSource(sim, "public")
+
+	poller := NewSNMPPoller()
+	poller.AddTask(ds, ds.Interfaces[0], "in", 300*time.Millisecond)
+	poller.Start()
+
+	deadline := time.Now().Add(1500 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		val := waitForMetric(t, poller, ds, "in")
+		assertRate(t, "in from ifHCInOctets", val, 2_000_000)
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestSNMPPollerHCFallback(t *testing.T) {
+	sim := newSimulator(t)
+	sim.SetCounter(ifInOctets1, gosnmp.Counter32, 0, 125_000)
+	sim.SetCounter(ifHCInOctets1, gosnmp.Counter64, 0, 250_000)
+	ds := simDataSource(sim, "public")
+	// configured with the HC counter
+	ds.Interfaces[0].Params["oids"] = map[string]interface{}{"in": ifHCInOctets1}
+
+	poller := NewSNMPPoller()
+	poller.AddTask(ds, ds.Interfaces[0], "in", 200*time.Millisecond)
+	poller.Start()
+	assertRate(t, "in from ifHCInOctets", waitForMetric(t, poller, ds, "in"), 250_000)
+
+	// the device stopped answering the HC counter, e.g. after a firmware change
+	sim.Delete(ifHCInOctets1)
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if val, _ := poller.GetMetric(ds, ds.Interfaces[0], "in").(int64); val > 100_000 && val < 150_000 {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Errorf("Expected the rate of ifInOctets after HC counters went away, got %v", poller.GetMetric(ds, ds.Interfaces[0], "in"))
+}
+
+func TestSNMPPollerNoHCCounters(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		deleted []string
+		want    gosnmp.Asn1BER
+	}{
+		// ifHCInOctets.2 is still there
+		{"noSuchInstance", []string{ifHCInOctets1}, gosnmp.NoSuchInstance},
+		{"noSuchObject", []string{ifHCInOctets1, ".1.3.6.1.2.1.31.1.1.1.6.2"}, gosnmp.NoSuchObject},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sim := newSimulator(t)
+			for _, oid := range tc.deleted {
+				sim.Delete(oid)
+			}
+			sim.SetCounter(ifInOctets1, gosnmp.Counter32, 0, 125_000)
+			ds := simDataSource(sim, "public")
+
+			g := &gosnmp.GoSNMP{Target: sim.Host(), Port: uint16(sim.Port()), Community: "public", Version: gosnmp.Version2c, Timeout: time.Second}
+			if err := g.Connect(); err != nil {
+				t.Fatalf("Failed to connect to the simulator: %v", err)
+			}
+			defer g.Conn.Close()
+			result, err := g.Get([]string{ifHCInOctets1})
+			if err != nil || len(result.Variables) != 1 || result.Variables[0].Type != tc.want {
+				t.Fatalf("Expected the device to answer %s for ifHCInOctets, got %v %v", tc.want, result, err)
+			}
+
+			poller := NewSNMPPoller()
+			poller.AddTask(ds, ds.Interfaces[0], "in", 200*time.Millisecond)
+			poller.Start()
+			assertRate(t, "in from ifInOctets", waitForMetric(t, poller, ds, "in"), 125_000)
+		})
+	}
+}
+
+// assertNoSpike checks that the rate of in stays ~want for d, after a counter reset
+func assertNoSpike(t *testing.T, poller Poller, ds config.DataSourceConfig, want float64, d time.Duration) {
+	t.Helper()
+	deadline := time.Now().Add(d)
+	for time.Now().Before(deadline) {
+		if val, _ := poller.GetMetric(ds, ds.Interfaces[0], "in").(int64); float64(val) > 2*want {
+			t.Fatalf("Expected the reset sample discarded, got a rate of %d B/s", val)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	assertRate(t, "in after the reset", waitForMetric(t, poller, ds, "in"), want)
+}
+
+func TestSNMPPollerDeviceReboot(t *testing.T) {
+	sim := newSimulator(t)
+	sim.Delete(ifHCInOctets1)
+	sim.SetCounter(ifInOctets1, gosnmp.Counter32, 3_000_000_000, 125_000)
+	ds := simDataSource(sim, "public")
+
+	poller := NewSNMPPoller()
+	poller.AddTask(ds, ds.Interfaces[0], "in", 200*time.Millisecond)
+	poller.Start()
+	assertRate(t, "in", waitForMetric(t, poller, ds, "in"), 125_000)
+
+	// sysUpTime and the counters start over, wrap corrected that would be ~6 GB/s
+	sim.Set(sysUpTimeOID, gosnmp.TimeTicks, uint32(100))
+	sim.SetCounter(ifInOctets1, gosnmp.Counter32, 0, 125_000)
+	assertNoSpike(t, poller, ds, 125_000, time.Second)
+}
+
+func TestSNMPPollerCounterCleared(t *testing.T) {
+	sim := newSimulator(t)
+	sim.Delete(ifHCInOctets1)
+	sim.SetCounter(ifInOctets1, gosnmp.Counter32, 3_000_000_000, 125_000)
+	ds := simDataSource(sim, "public")
+
+	// ifHighSpeed is polled with the counters, the interface is 1G
+	poller := NewSNMPPoller()
+	poller.AddTask(ds, ds.Interfaces[0], "in", 200*time.Millisecond)
+	poller.AddTask(ds, ds.Interfaces[0], SpeedMetric, 200*time.Millisecond)
+	poller.Start()
+	assertRate(t, "in", waitForMetric(t, poller, ds, "in"), 125_000)
+
+	// "clear counters" on the device, sysUpTime goes on
+	sim.SetCounter(ifInOctets1, gosnmp.Counter32, 0, 125_000)
+	assertNoSpike(t, poller, ds, 125_000, time.Second)
+}
+
+func TestSNMPPollerCounterClearedLinkBandwidth(t *testing.T) {
+	sim := newSimulator(t)
+	sim.Delete(ifHCInOctets1)
+	sim.SetCounter(ifInOctets1, gosnmp.Counter32, 3_000_000_000, 125_000)
+	ds := simDataSource(sim, "public")
+
+	// without ifHighSpeed the line rate is the bandwidth of the link, 1G
+	poller := NewSNMPPoller()
+	poller.AddTask(ds, ds.Interfaces[0], "in", 200*time.Millisecond)
+	poller.setLineRates([]interfaceRate{{ds, ds.Interfaces[0], 125_000_000}})
+	poller.Start()
+	assertRate(t, "in", waitForMetric(t, poller, ds, "in"), 125_000)
+
+	sim.SetCounter(ifInOctets1, gosnmp.Counter32, 0, 125_000)
+	assertNoSpike(t, poller, ds, 125_000, time.Second)
+}
+
+func TestLinkBandwidthsLineRates(t *testing.T) {
+	ds := config.DataSourceConfig{
+		Name: "lab-router", Type: SNMPPollerType,
+		Params:     map[string]interface{}{"host": "192.0.2.1", "community": "public"},
+		Interfaces: []config.InterfaceConfig{{Name: "Gi0/0/0", Params: map[string]interface{}{"oids": map[string]interface{}{"in": ifInOctets1, "out": ifOutOctets1}}}},
+	}
+	dsService := NewDataSourceService([]config.DataSourceConfig{ds})
+	lineRates := func() map[string]int64 {
+		poller := dsService.pollers[SNMPPollerType].(*SNMPPoller)
+		poller.mu.RLock()
+		defer poller.mu.RUnlock()
+		return maps.Clone(poller.lineRates)
+	}
+	links := func(bandwidths ...string) *config.Map {
+		m := &config.Map{}
+		for _, bandwidth := range bandwidths {
+			m.Links = append(m.Links, config.Link{DataSource: ds.Name, Interface: "Gi0/0/0", Bandwidth: bandwidth})
+		}
+		return m
+	}
+
+	dsService.SetLinkBandwidths([]*config.Map{links("1G"), links("10G", config.BandwidthAuto)})
+	rates := lineRates()
+	if len(rates) != 2 || slices.ContainsFunc(slices.Collect(maps.Values(rates)), func(rate int64) bool { return rate != utils.ParseBandwidth("10G") }) {
+		t.Errorf("Expected the largest bandwidth as line rate of both counters, got %v", rates)
+	}
+	if _, err := dsService.Reload([]config.DataSourceConfig{ds}); err != nil || len(lineRates()) != 2 {
+		t.Errorf("Expected the line rates kept by a reload, got %v, %v", lineRates(), err)
+	}
+	dsService.SetLinkBandwidths([]*config.Map{links(config.BandwidthAuto)})
+	if rates := lineRates(); len(rates) != 0 {
+		t.Errorf("Expected the line rates of removed links dropped, got %v", rates)
+	}
+}
+
+func TestCounterRate(t *testing.T) {
+	last := counterSample{value: 1_000_000}
+	testCases := []struct {
+		name     string
+		val      datasource.SNMPValue
+		lineRate int64
+		wantErr  bool
+	}{
+		{"Increase", datasource.SNMPValue{Value: 2_000_000, Width: 64}, 0, false},
+		{"Wrap32", datasource.SNMPValue{Value: 500, Width: 32}, 0, false},
+		{"Back64", datasource.SNMPValue{Value: 500, Width: 64}, 0, true},
+		{"BelowLineRate", datasource.SNMPValue{Value: 2_000_000, Width: 64}, 1_000_000, false},
+		{"AboveLineRate", datasource.SNMPValue{Value: 500, Width: 32}, 125_000_000, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := counterRate(last, tc.val, 1, tc.lineRate); (err != nil) != tc.wantErr {
+				t.Errorf("Expected error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestSNMPPollerWrongCommunity(t *testing.T) {
+	sim := newSimulator(t)
+	setOctets(sim, ifInOctets1, 125_000)
+	ds := simDataSource(sim, "private")
+
+	poller := NewSNMPPoller()
+	poller.AddTask(ds, ds.Interfaces[0], "in", 200*time.Millisecond)
+	poller.Start()
+
+	time.Sleep(time.Second)
+	if val, _ := poller.GetMetric(ds, ds.Interfaces[0], "in").(int64); val != 0 {
+		t.Errorf("Expected no data with wrong community, got %d", val)
+	}
+}
+
+func TestDataSourceServiceWithSimulator(t *testing.T) {
+	sim := newSimulator(t)
+	setOctets(sim, ifInOctets1, 125_000)
+	setOctets(sim, ifOutOctets1, 125_000)
+	ds := simDataSource(sim, "public")
+	ds.PollInterval = 1
+
+	dsService := NewDataSourceService([]config.DataSourceConfig{ds})
+	dsService.Start()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		metrics, err := dsService.GetInterfaceMetrics(context.Background(), ds.Name, "Gi0/0/0", []string{"in", "out"})
+		if err != nil {
+			t.Fatalf("GetInterfaceMetrics failed: %v", err)
+		}
+		if in, _ := metrics["in"].(int64); in > 0 {
+			assertRate(t, "in", in, 125_000)
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatal("DataSourceService returned no metrics after 5s")
+}
+
+func TestPollStatsSlowTarget(t *testing.T) {
+	stats := newPollStats(SNMPPollerType)
+	base := time.Second
+
+	interval := base
+	for i := 0; i < slowPollStreak; i++ {
+		interval = stats.record("slow-router:161", base, 700*time.Millisecond, nil).next
+	}
+	if interval != 2*base {
+		t.Fatalf("Expected interval to double after %d slow polls, got %s", slowPollStreak, interval)
+	}
+	if slow := stats.snapshot(); len(slow) != 1 || !slow[0].Slow || slow[0].Histogram[7].Count != 3 {
+		t.Errorf("Expected slow target with 3 polls in <=1s bucket, got %+v", slow)
+	}
+
+	for i := 0; i < fastPollStreak; i++ {
+		interval = stats.record("slow-router:161", base, 10*time.Millisecond, nil).next
+	}
+	if interval != base {
+		t.Errorf("Expected interval to go back to %s after fast polls, got %s", base, interval)
+	}
+}
+
+func TestPollStatsCallerBase(t *testing.T) {
+	stats := newPollStats(PrometheusPollerType)
+	// two tasks on one server, and a reload lengthening the interval of the first
+	stats.record("http://prometheus:9090", 10*time.Second, 10*time.Millisecond, nil)
+	if next := stats.record("http://prometheus:9090", time.Minute, 10*time.Millisecond, nil).next; next != time.Minute {
+		t.Errorf("Expected the interval of the caller, got %s", next)
+	}
+	stats.forget("http://prometheus:9090")
+	if snapshot := stats.snapshot(); len(snapshot) != 0 {
+		t.Errorf("Expected the target forgotten, got %+v", snapshot)
+	}
+}
+
+func TestPollStatsBackoff(t *testing.T) {
+	stats := newPollStats(SNMPPollerType)
+	base := time.Second
+	failed := errors.New("request timeout")
+
+	var verdict pollVerdict
+	for i := 1; i <= breakerFailures; i++ {
+		verdict = stats.record("dead-router:161", base, 10*time.Millisecond, failed)
+		want := min(base<<i, maxBackoffMultiplier*base)
+		if verdict.next < time.Duration(float64(want)*(1-backoffJitter)) || verdict.next > time.Duration(float64(want)*(1+backoffJitter)) {
+			t.Errorf("Failure %d: expected a backoff around %s, got %s", i, want, verdict.next)
+		}
+		if verdict.opened != (i == breakerFailures) || pollErrorLevel(verdict) == slog.LevelError != (i == 1) {
+			t.Errorf("Failure %d: expected only the first failure logged and the breaker open at %d, got %+v", i, breakerFailures, verdict)
+		}
+	}
+	if err := stats.degraded("dead-router:161"); !IsDegraded(err) || !strings.Contains(err.Error(), "request timeout") {
+		t.Fatalf("Expected the target degraded, got %v", err)
+	}
+	if st := stats.snapshot()[0]; st.State != PollStateDegraded || st.ConsecutiveFailures != breakerFailures || st.DegradedSince == nil {
+		t.Errorf("Expected degraded stats, got %+v", st)
+	}
+	if limit := backoff(time.Minute, 20); limit > time.Duration(float64(maxBackoff)*(1+backoffJitter)) {
+		t.Errorf("Expected the backoff capped at %s, got %s", maxBackoff, limit)
+	}
+
+	if verdict = stats.record("dead-router:161", base, 10*time.Millisecond, nil); !verdict.closed || verdict.next != base {
+		t.Errorf("Expected the breaker closed and the interval reset by a success, got %+v", verdict)
+	}
+	if err := stats.degraded("dead-router:161"); err != nil {
+		t.Errorf("Expected the target available again, got %v", err)
+	}
+}
+
+func TestSNMPPollerDegraded(t *testing.T) {
+	sim := newSimulator(t)
+	ds := simDataSource(sim, "public")
+	dsService := NewDataSourceService([]config.DataSourceConfig{ds})
+	dsService.Start()
+	defer func() { _ = dsService.Stop(context.Background()) }()
+
+	// the breaker of the device opens as if it stopped answering
+	target := fmt.Sprintf("%s:%d", sim.Host(), sim.Port())
+	dsService.mu.RLock()
+	poller := dsService.pollers[SNMPPollerType].(*SNMPPoller)
+	dsService.mu.RUnlock()
+	for deadline := time.Now().Add(5 * time.Second); len(poller.PollStats()) == 0 && time.Now().Before(deadline); {
+		time.Sleep(20 * time.Millisecond) // the first poll, the next one is an interval away
+	}
+	for range breakerFailures {
+		poller.stats.record(target, time.Second, time.Millisecond, errors.New("request timeout"))
+	}
+
+	if _, err := dsService.GetInterfaceMetrics(context.Background(), ds.Name, "Gi0/0/0", []string{"in"}); !IsDegraded(err) {
+		t.Fatalf("Expected degraded error, got %v", err)
+	}
+	m := &config.Map{Links: []config.Link{{Name: "uplink", DataSource: ds.Name, Interface: "Gi0/0/0", Metrics: []string{"in", "out"}, Bandwidth: "1G"}}}
+	if data := NewMapService("").ProcessMap(context.Background(), "lab", m, dsService); data.LinksData[0].Status != LinkDegraded {
+		t.Errorf("Expected link degraded, got %s", data.LinksData[0].Status)
+	}
+	status := dsService.PollerStatus()
+	if len(status) != 1 || status[0].State != PollStateDegraded || status[0].Targets[0] != target || status[0].DegradedSince == nil {
+		t.Errorf("Expected the datasource degraded in the poller status, got %+v", status)
+	}
+}
+
+func TestCounterDelta(t *testing.T) {
+	testCases := []struct {
+		name  string
+		prev  int64
+		cur   int64
+		width uint
+		want  int64
+	}{
+		{"Increase", 100, 600, 32, 500},
+		{"NoChange", 42, 42, 32, 0},
+		{"Wrap32", math.MaxUint32 - 99, 100, 32, 200},
+		{"Gauge", math.MaxUint32 - 99, 100, 0, 200},
+		{"Increase64", math.MaxUint32 - 99, math.MaxUint32 + 101, 64, 200},
+		{"Wrap64", -100, 100, 64, 200}, // 2^64-100 as read from a Counter64
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := counterDelta(tc.prev, tc.cur, tc.width); got != tc.want {
+				t.Errorf("Expected delta %d, got %d", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// snmpGroup is the poll state of the tasks of a snmpGroupKey
+type snmpGroup struct {
+	key      string
+	device   string // host:port, polled by one group at a time
+	prev     map[string]counterSample
+	counters map[string]string // configured octet counter OID -> the one polled, see counterValue
+	base     time.Duration     // shortest interval of the tasks
+	interval time.Duration     // current one, longer for slow or failing devices
+	next     time.Time
+	polling  bool // owned by a poll goroutine until it is sent back on done
+}
+
+// schedule polls the groups of tasks as they are due, by at most MaxPollerWorkers polls at once and
+// one poll at a time per device. Thousands of monitored interfaces need as many goroutines as
+// polls in flight, not one each, and a device never answers two requests of the poller at once.
+func (p *SNMPPoller) schedule(ctx context.Context, key string) {
+	groups := make(map[string]*snmpGroup)
+	busy := make(map[string]bool) // devices being polled
+	workers := p.workers.Usage().Max
+	inFlight := 0
+	// never blocks, there are at most workers polls in flight
+	done := make(chan *snmpGroup, workers)
+	var polls sync.WaitGroup
+	defer polls.Wait()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		// tasks are read every cycle, a reload may have changed them
+		tasks := p.loopTasks(key)
+		if len(tasks) == 0 {
+			p.queued.Store(0)
+			return
+		}
+		byGroup := make(map[string][]dataPollTask)
+		for _, task := range tasks {
+			byGroup[snmpGroupKey(task)] = append(byGroup[snmpGroupKey(task)], task)
+		}
+		now := time.Now()
+		for groupKey, groupTasks := range byGroup {
+			if groups[groupKey] == nil {
+				groups[groupKey] = &snmpGroup{key: groupKey, device: snmpTarget(groupTasks[0]), prev: make(map[string]counterSample), counters: make(map[string]string), next: now}
+			}
+		}
+
+		var due []*snmpGroup
+		wait := time.Hour
+		for groupKey, g := range groups {
+			switch {
+			case g.polling:
+			case byGroup[groupKey] == nil:
+				delete(groups, groupKey)
+			case g.next.After(now):
+				wait = min(wait, g.next.Sub(now))
+			default:
+				due = append(due, g)
+			}
+		}
+		// the most overdue first
+		slices.SortFunc(due, func(a, b *snmpGroup) int { return a.next.Compare(b.next) })
+		queued := len(due)
+		for _, g := range due {
+			if inFlight == workers {
+				break
+			}
+			if busy[g.device] {
+				continue
+			}
+			// the semaphore is only for the usage report, inFlight already bounds the polls
+			if err := p.workers.Acquire(ctx); err != nil {
+				return
+			}
+			queued--
+			inFlight++
+			busy[g.device], g.polling = true, true
+			polls.Add(1)
+			go func(g *snmpGroup, tasks []dataPollTask) {
+				defer polls.Done()
+				started := time.Now()
+				g.next = started.Add(p.pollGroup(ctx, g, tasks))
+				p.workers.Release()
+				done <- g
+			}(g, byGroup[g.key])
+		}
+		p.queued.Store(int64(queued))
+
+		timer.Reset(wait)
+		select {
+		case <-ctx.Done():
+			return
+		case g := <-done:
+			busy[g.device], g.polling = false, false
+			inFlight--
+		case <-timer.C:
+		case <-p.wake:
+		}
+	}
+}
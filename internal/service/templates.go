@@ -0,0 +1,141 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"go-weathermap/internal/config"
+
+	"gopkg.in/yaml.v3"
+)
+
+const templatesDirName = "templates"
+
+// MapTemplate is a map with {{ .param }} placeholders, stored in the templates folder of
+// the maps directory. Its file holds the parameters and the map under `map:`.
+type MapTemplate struct {
+	Name        string              `yaml:"-" json:"name"`
+	Description string              `yaml:"description,omitempty" json:"description,omitempty"`
+	Parameters  []TemplateParameter `yaml:"parameters" json:"parameters"`
+}
+
+type TemplateParameter struct {
+	Name        string `yaml:"name" json:"name"`
+	Default     string `yaml:"default,omitempty" json:"default,omitempty"` // no default: the parameter is required
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+}
+
+func (s *MapService) templatesDir() string {
+	return filepath.Join(s.configDir, templatesDirName)
+}
+
+func (s *MapService) ListTemplates() ([]MapTemplate, error) {
+	files, err := filepath.Glob(filepath.Join(s.templatesDir(), "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	templates := make([]MapTemplate, 0, len(files))
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".yaml")
+		tmpl, _, err := s.loadTemplate(name)
+		if err != nil {
+			s.logger.Warn("skipping template", "template", name, "error", err)
+			continue
+		}
+		templates = append(templates, *tmpl)
+	}
+	return templates, nil
+}
+
+func (s *MapService) GetTemplate(name string) (*MapTemplate, error) {
+	tmpl, _, err := s.loadTemplate(name)
+	return tmpl, err
+}
+
+func (s *MapService) loadTemplate(name string) (*MapTemplate, []byte, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return nil, nil, fmt.Errorf("template not found: %s", name)
+	}
+	content, err := os.ReadFile(filepath.Join(s.templatesDir(), name+".yaml"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("template not found: %s", name)
+	}
+	var tmpl MapTemplate
+	if err := yaml.Unmarshal(content, &tmpl); err != nil {
+		return nil, nil, fmt.Errorf("invalid template %s (values starting with {{ must be quoted): %w", name, err)
+	}
+	tmpl.Name = name
+	return &tmpl, content, nil
+}
+
+// RenderTemplate fills the template with params, missing ones take their default
+func (s *MapService) RenderTemplate(name string, params map[string]string) (*config.Map, error) {
+	tmpl, content, err := s.loadTemplate(name)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(tmpl.Parameters))
+	var missing []string
+	for _, param := range tmpl.Parameters {
+		if value, ok := params[param.Name]; ok {
+			values[param.Name] = value
+		} else if param.Default != "" {
+			values[param.Name] = param.Default
+		} else {
+			missing = append(missing, param.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("invalid parameters: missing %s", strings.Join(missing, ", "))
+	}
+	var unknown []string
+	for key := range params {
+		if _, ok := values[key]; !ok {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("invalid parameters: unknown %s", strings.Join(unknown, ", "))
+	}
+
+	t, err := template.New(name).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("invalid template %s: %w", name, err)
+	}
+	var rendered bytes.Buffer
+	if err := t.Execute(&rendered, values); err != nil {
+		return nil, fmt.Errorf("invalid template %s: %w", name, err)
+	}
+	var doc struct {
+		Map config.Map `yaml:"map"`
+	}
+	if err := yaml.Unmarshal(rendered.Bytes(), &doc); err != nil {
+		return nil, fmt.Errorf("invalid template %s: %w", name, err)
+	}
+	return &doc.Map, nil
+}
+
+// CreateMapFromTemplate instantiates a template as a new map, named after its title when name is empty
+func (s *MapService) CreateMapFromTemplate(templateName, mapName string, params map[string]string) (string, error) {
+	newMap, err := s.RenderTemplate(templateName, params)
+	if err != nil {
+		return "", err
+	}
+	if mapName == "" {
+		mapName = MapNameFromTitle(newMap.Title)
+	}
+	if mapName == "" || strings.ContainsAny(mapName, `/\`) {
+		return "", fmt.Errorf("invalid parameters: map name is required")
+	}
+	if _, err := os.Stat(filepath.Join(s.configDir, mapName+".yaml")); err == nil {
+		return "", fmt.Errorf("map %s already exists", mapName)
+	}
+	return mapName, s.CreateMap(newMap, mapName)
+}
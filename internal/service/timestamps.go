@@ -0,0 +1,92 @@
+package service
+
+import (
+	"bytes"
+	"time"
+
+	"go-weathermap/internal/config"
+
+	"gopkg.in/yaml.v3"
+)
+
+// stampTimes sets created_at and updated_at of a map about to be saved from its previous
+// version, matched by id. Timestamps sent by clients are ignored: new objects are created
+// now, changed ones updated now and unchanged ones keep their times. previous is nil for
+// a new map.
+func stampTimes(m, previous *config.Map, now time.Time) {
+	now = now.UTC().Truncate(time.Second)
+	if previous == nil {
+		previous = &config.Map{}
+	}
+
+	oldNodes := make(map[string]config.Node, len(previous.Nodes))
+	for _, node := range previous.Nodes {
+		oldNodes[node.ID] = node
+	}
+	for i := range m.Nodes {
+		node := &m.Nodes[i]
+		old, ok := oldNodes[node.ID]
+		node.CreatedAt, node.UpdatedAt = stamp(ok, old.CreatedAt, old.UpdatedAt, ok && sameNode(*node, old), now)
+	}
+
+	oldLinks := make(map[string]config.Link, len(previous.Links))
+	for _, link := range previous.Links {
+		oldLinks[link.ID] = link
+	}
+	for i := range m.Links {
+		link := &m.Links[i]
+		old, ok := oldLinks[link.ID]
+		link.CreatedAt, link.UpdatedAt = stamp(ok, old.CreatedAt, old.UpdatedAt, ok && sameLink(*link, old), now)
+	}
+
+	existed := previous.ID != ""
+	m.CreatedAt, m.UpdatedAt = stamp(existed, previous.CreatedAt, previous.UpdatedAt, existed && sameMap(m, previous), now)
+}
+
+func stamp(existed bool, createdAt, updatedAt *time.Time, unchanged bool, now time.Time) (*time.Time, *time.Time) {
+	switch {
+	case !existed:
+		return &now, &now
+	case unchanged:
+		return createdAt, updatedAt
+	}
+	return createdAt, &now
+}
+
+func sameNode(a, b config.Node) bool {
+	a.CreatedAt, a.UpdatedAt, b.CreatedAt, b.UpdatedAt = nil, nil, nil, nil
+	return sameYAML(a, b)
+}
+
+func sameLink(a, b config.Link) bool {
+	a.CreatedAt, a.UpdatedAt, b.CreatedAt, b.UpdatedAt = nil, nil, nil, nil
+	return sameYAML(a, b)
+}
+
+// sameMap compares two versions of a map without their timestamps, a change of any node
+// or link changes the map
+func sameMap(a, b *config.Map) bool {
+	strip := func(m *config.Map) config.Map {
+		c := *m
+		c.CreatedAt, c.UpdatedAt = nil, nil
+		c.Nodes = make([]config.Node, len(m.Nodes))
+		for i, node := range m.Nodes {
+			node.CreatedAt, node.UpdatedAt = nil, nil
+			c.Nodes[i] = node
+		}
+		c.Links = make([]config.Link, len(m.Links))
+		for i, link := range m.Links {
+			link.CreatedAt, link.UpdatedAt = nil, nil
+			c.Links[i] = link
+		}
+		return c
+	}
+	return sameYAML(strip(a), strip(b))
+}
+
+// sameYAML compares the stored form, so nil and empty lists of JSON bodies are equal
+func sameYAML(a, b any) bool {
+	dataA, errA := yaml.Marshal(a)
+	dataB, errB := yaml.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(dataA, dataB)
+}
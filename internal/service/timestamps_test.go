@@ -0,0 +1,59 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"go-weathermap/internal/config"
+)
+
+func TestStampTimes(t *testing.T) {
+	created := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	edited := created.Add(time.Hour)
+	forged := created.Add(-24 * time.Hour)
+
+	m := &config.Map{
+		Title: "times", Width: 100, Height: 100,
+		Nodes: []config.Node{{Name: "a"}, {Name: "b"}},
+		Links: []config.Link{{Name: "a-b", From: "a", To: "b", Bandwidth: "1G"}},
+	}
+	assignIDs(m)
+	stampTimes(m, nil, created)
+	if !m.CreatedAt.Equal(created) || !m.UpdatedAt.Equal(created) || !m.Nodes[0].CreatedAt.Equal(created) || !m.Links[0].UpdatedAt.Equal(created) {
+		t.Fatalf("Expected every object of a new map created now, got %+v", m)
+	}
+
+	previous := *m
+	previous.Nodes = append([]config.Node(nil), m.Nodes...)
+	previous.Links = append([]config.Link(nil), m.Links...)
+	next := previous
+	next.Nodes = append([]config.Node(nil), m.Nodes...)
+	next.Links = append([]config.Link(nil), m.Links...)
+	next.Nodes[1].Label = "B"
+	next.Nodes[0].CreatedAt = &forged // timestamps of clients are ignored
+	next.Nodes = append(next.Nodes, config.Node{Name: "c"})
+	assignIDs(&next)
+	stampTimes(&next, &previous, edited)
+
+	if !next.Nodes[0].CreatedAt.Equal(created) || !next.Nodes[0].UpdatedAt.Equal(created) {
+		t.Errorf("Expected unchanged node to keep its times, got %v %v", next.Nodes[0].CreatedAt, next.Nodes[0].UpdatedAt)
+	}
+	if !next.Nodes[1].CreatedAt.Equal(created) || !next.Nodes[1].UpdatedAt.Equal(edited) {
+		t.Errorf("Expected changed node to be updated, got %v %v", next.Nodes[1].CreatedAt, next.Nodes[1].UpdatedAt)
+	}
+	if !next.Nodes[2].CreatedAt.Equal(edited) {
+		t.Errorf("Expected added node to be created now, got %v", next.Nodes[2].CreatedAt)
+	}
+	if !next.Links[0].UpdatedAt.Equal(created) {
+		t.Errorf("Expected unchanged link to keep its times, got %v", next.Links[0].UpdatedAt)
+	}
+	if !next.CreatedAt.Equal(created) || !next.UpdatedAt.Equal(edited) {
+		t.Errorf("Expected map updated with its nodes, got %v %v", next.CreatedAt, next.UpdatedAt)
+	}
+
+	again := next
+	stampTimes(&again, &next, edited.Add(time.Hour))
+	if !again.UpdatedAt.Equal(edited) {
+		t.Errorf("Expected saving an unchanged map to keep updated_at, got %v", again.UpdatedAt)
+	}
+}
@@ -0,0 +1,573 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/datasource"
+)
+
+// Tables walked on every device, all keyed by their OID relative to the table
+const (
+	sysNameOID        = "1.3.6.1.2.1.1.5"
+	ifNameOID         = "1.3.6.1.2.1.31.1.1.1.1"
+	ifDescrOID        = "1.3.6.1.2.1.2.2.1.2"
+	lldpLocPortOID    = "1.0.8802.1.1.2.1.3.7.1"   // lldpLocPortTable: 3 id, 4 description
+	lldpRemOID        = "1.0.8802.1.1.2.1.4.1.1"   // lldpRemTable: 5 chassis id, 7 port id, 8 port description, 9 sysName
+	lldpRemManAddrOID = "1.0.8802.1.1.2.1.4.2.1.3" // lldpRemManAddrIfSubtype, the address is in the index
+	cdpCacheOID       = "1.3.6.1.4.1.9.9.23.1.2.1.1"
+
+	maxDiscoveryDepth   = 3
+	maxDiscoveryDevices = 200
+
+	defaultDiscoveredBandwidth = "1G" // of links of unknown speed, in maps without a default
+)
+
+// DiscoveryRequest starts a topology discovery from seed devices
+type DiscoveryRequest struct {
+	Seeds     []string `json:"seeds"`               // snmp datasources, or hosts polled with Community
+	Community string   `json:"community,omitempty"` // of the seeds which aren't datasources
+	Depth     int      `json:"depth,omitempty"`     // hops walked past the seeds, 0 only walks the seeds
+	Prune     bool     `json:"prune,omitempty"`     // removes links between walked devices which weren't seen
+}
+
+// DiscoveredDevice is a device walked by a discovery
+type DiscoveredDevice struct {
+	Name       string `json:"name"` // sysName, the seed until the device answered
+	Address    string `json:"address"`
+	DataSource string `json:"datasource,omitempty"`
+	Neighbors  int    `json:"neighbors"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Adjacency is a neighbor seen by LLDP or CDP on a port of a walked device
+type Adjacency struct {
+	Device       string `json:"device"`
+	Port         string `json:"port"`
+	Neighbor     string `json:"neighbor"`
+	NeighborPort string `json:"neighbor_port"`
+	Address      string `json:"address,omitempty"`    // management address of the neighbor
+	Speed        int64  `json:"speed_mbps,omitempty"` // ifHighSpeed of Port
+	Protocol     string `json:"protocol"`             // lldp or cdp
+}
+
+type DiscoveredNode struct {
+	Name         string          `json:"name"`
+	ManagementIP string          `json:"management_ip,omitempty"`
+	DataSource   string          `json:"datasource,omitempty"`
+	Position     config.Position `json:"position"`
+}
+
+type DiscoveredLink struct {
+	Name       string `json:"name"`
+	From       string `json:"from"`
+	FromPort   string `json:"from_port"`
+	To         string `json:"to"`
+	ToPort     string `json:"to_port"`
+	Bandwidth  string `json:"bandwidth"`
+	DataSource string `json:"datasource,omitempty"`
+	Interface  string `json:"interface,omitempty"`
+}
+
+// DiscoveryResult is what a discovery changes in a map, saved only when Applied
+type DiscoveryResult struct {
+	Map            string             `json:"map"`
+	Applied        bool               `json:"applied"`
+	Devices        []DiscoveredDevice `json:"devices"`
+	Adjacencies    []Adjacency        `json:"adjacencies"`
+	NodesAdded     []DiscoveredNode   `json:"nodes_added"`
+	LinksAdded     []DiscoveredLink   `json:"links_added"`
+	LinksRemoved   []string           `json:"links_removed"`
+	LinksUnchanged []string           `json:"links_unchanged"`
+}
+
+// DiscoverTopology walks the LLDP and CDP neighbors of the seeds and compares them with a map:
+// devices and links missing from the map are added, with Prune the links between walked devices
+// which weren't seen are removed. The map is saved only when apply is set.
+func (s *MapService) DiscoverTopology(ctx context.Context, mapName string, req DiscoveryRequest, dsService *DataSourceService, apply bool) (*DiscoveryResult, error) {
+	mapConfig, err := s.loadMapConfig(mapName)
+	if err != nil {
+		return nil, err
+	}
+	devices, adjacencies, err := dsService.DiscoverNeighbors(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	result := &DiscoveryResult{
+		Map:            mapName,
+		Devices:        devices,
+		Adjacencies:    adjacencies,
+		NodesAdded:     []DiscoveredNode{},
+		LinksAdded:     []DiscoveredLink{},
+		LinksRemoved:   []string{},
+		LinksUnchanged: []string{},
+	}
+	planTopology(mapConfig, result, req.Prune, dsService.snmpDataSource)
+
+	changed := len(result.NodesAdded) > 0 || len(result.LinksAdded) > 0 || len(result.LinksRemoved) > 0
+	if !apply || !changed {
+		return result, s.prepareMap(mapName, mapConfig)
+	}
+	if err := s.saveMap(mapName, mapConfig); err != nil {
+		return nil, err
+	}
+	result.Applied = true
+	return result, nil
+}
+
+// planTopology adds the nodes and links of a discovery missing from m, and removes the ones
+// no longer seen with prune, recording the changes in result
+func planTopology(m *config.Map, result *DiscoveryResult, prune bool, lookup func(name string) (config.DataSourceConfig, bool)) {
+	addresses := make(map[string]string) // device -> management address
+	dataSources := make(map[string]string)
+	for _, adj := range result.Adjacencies {
+		if adj.Address != "" {
+			addresses[strings.ToLower(adj.Neighbor)] = adj.Address
+		}
+	}
+	for _, device := range result.Devices {
+		addresses[strings.ToLower(device.Name)] = device.Address
+		dataSources[strings.ToLower(device.Name)] = device.DataSource
+	}
+
+	// devices are the nodes of the same name, address or datasource
+	nodes := make(map[string]string)
+	nodeOf := func(device string) string {
+		key := strings.ToLower(device)
+		if name, ok := nodes[key]; ok {
+			return name
+		}
+		address, dsName := addresses[key], dataSources[key]
+		for _, node := range m.Nodes {
+			if strings.EqualFold(node.Name, device) ||
+				address != "" && (node.ManagementIP == address || node.Address == address) ||
+				dsName != "" && node.DataSource == dsName {
+				nodes[key] = node.Name
+				return node.Name
+			}
+		}
+		node := config.Node{Name: device, DataSource: dsName}
+		if net.ParseIP(address) != nil {
+			node.ManagementIP = address
+		}
+		m.Nodes = append(m.Nodes, node)
+		result.NodesAdded = append(result.NodesAdded, DiscoveredNode{Name: node.Name, ManagementIP: node.ManagementIP, DataSource: dsName})
+		nodes[key] = device
+		return device
+	}
+	walked := make(map[string]bool)
+	for _, device := range result.Devices {
+		if device.Error == "" {
+			walked[nodeOf(device.Name)] = true
+		}
+	}
+
+	// both ends of a link usually see it, once by LLDP and once by CDP with long port names
+	var edges []DiscoveredLink
+	for _, adj := range result.Adjacencies {
+		from, to := nodeOf(adj.Device), nodeOf(adj.Neighbor)
+		if from == to {
+			continue
+		}
+		i := slices.IndexFunc(edges, func(e DiscoveredLink) bool {
+			return e.From == from && e.To == to && samePort(e.FromPort, adj.Port) ||
+				e.From == to && e.To == from && samePort(e.ToPort, adj.Port)
+		})
+		var bandwidth string
+		if adj.Speed > 0 {
+			bandwidth = formatSpeed(adj.Speed)
+		}
+		if i < 0 {
+			edges = append(edges, DiscoveredLink{From: from, FromPort: adj.Port, To: to, ToPort: adj.NeighborPort, Bandwidth: bandwidth})
+		} else if edges[i].Bandwidth == "" {
+			edges[i].Bandwidth = bandwidth
+		}
+	}
+
+	matched := make(map[int]bool)
+	names := make(map[string]bool)
+	for _, link := range m.Links {
+		names[link.Name] = true
+	}
+	for _, edge := range edges {
+		// a link on the interface of the port, else one without interface
+		i := -1
+		for _, onPort := range []bool{true, false} {
+			for j, link := range m.Links {
+				ends := link.From == edge.From && link.To == edge.To || link.From == edge.To && link.To == edge.From
+				if i >= 0 || !ends || matched[j] {
+					continue
+				}
+				if onPort && (samePort(link.Interface, edge.FromPort) || samePort(link.Interface, edge.ToPort)) || !onPort && link.Interface == "" {
+					i = j
+				}
+			}
+		}
+		if i >= 0 {
+			matched[i] = true
+			result.LinksUnchanged = append(result.LinksUnchanged, m.Links[i].Name)
+			continue
+		}
+		edge = linkDataSource(m, edge, lookup)
+		edge.Name = uniqueName(edge.From+"-"+edge.To, names)
+		names[edge.Name] = true
+		// without a known speed, auto reads it from the datasource once polled
+		if edge.Bandwidth == "" && edge.Interface != "" {
+			edge.Bandwidth = config.BandwidthAuto
+		} else if edge.Bandwidth == "" && (m.Defaults == nil || m.Defaults.Link == nil || m.Defaults.Link.Bandwidth == "") {
+			edge.Bandwidth = defaultDiscoveredBandwidth
+		}
+		m.Links = append(m.Links, config.Link{Name: edge.Name, From: edge.From, To: edge.To, Bandwidth: edge.Bandwidth, DataSource: edge.DataSource, Interface: edge.Interface})
+		result.LinksAdded = append(result.LinksAdded, edge)
+	}
+
+	if prune {
+		links := m.Links[:0]
+		for i, link := range m.Links {
+			if i < len(m.Links)-len(result.LinksAdded) && !matched[i] && walked[link.From] && walked[link.To] {
+				result.LinksRemoved = append(result.LinksRemoved, link.Name)
+				continue
+			}
+			links = append(links, link)
+		}
+		m.Links = links
+	}
+	placeNodes(m, result)
+}
+
+// linkDataSource points a new link at the interface of its port on the datasource of one of its
+// nodes, that node becomes the from end
+func linkDataSource(m *config.Map, edge DiscoveredLink, lookup func(name string) (config.DataSourceConfig, bool)) DiscoveredLink {
+	for _, end := range []DiscoveredLink{edge, {From: edge.To, FromPort: edge.ToPort, To: edge.From, ToPort: edge.FromPort}} {
+		i := slices.IndexFunc(m.Nodes, func(node config.Node) bool { return node.Name == end.From })
+		if i < 0 || m.Nodes[i].DataSource == "" {
+			continue
+		}
+		ds, ok := lookup(m.Nodes[i].DataSource)
+		if !ok {
+			continue
+		}
+		for _, iface := range ds.Interfaces {
+			if samePort(iface.Name, end.FromPort) {
+				end.DataSource, end.Interface = ds.Name, iface.Name
+				return end
+			}
+		}
+	}
+	return edge
+}
+
+// placeNodes spreads the added nodes on a circle around the center of the map
+func placeNodes(m *config.Map, result *DiscoveryResult) {
+	positions := circlePositions(m, len(result.NodesAdded))
+	for i := range result.NodesAdded {
+		result.NodesAdded[i].Position = positions[i]
+		for j := range m.Nodes {
+			if m.Nodes[j].Name == result.NodesAdded[i].Name {
+				m.Nodes[j].Position = positions[i]
+			}
+		}
+	}
+}
+
+// circlePositions returns count positions evenly spread on a circle around the center of m
+func circlePositions(m *config.Map, count int) []config.Position {
+	radius := float64(min(m.Width, m.Height)) * 0.4
+	positions := make([]config.Position, count)
+	for i := range positions {
+		angle := 2 * math.Pi * float64(i) / float64(count)
+		positions[i] = config.Position{
+			X: m.Width/2 + int(math.Round(radius*math.Cos(angle))),
+			Y: m.Height/2 + int(math.Round(radius*math.Sin(angle))),
+		}
+	}
+	return positions
+}
+
+func uniqueName(name string, taken map[string]bool) string {
+	unique := name
+	for i := 2; taken[unique]; i++ {
+		unique = fmt.Sprintf("%s-%d", name, i)
+	}
+	return unique
+}
+
+// samePort compares interface names, abbreviated or not: Gi0/1 is GigabitEthernet0/1
+func samePort(a, b string) bool {
+	if strings.EqualFold(a, b) {
+		return true
+	}
+	split := func(name string) (string, string) {
+		name = strings.ToLower(strings.TrimSpace(name))
+		i := strings.IndexFunc(name, func(r rune) bool { return !unicode.IsLetter(r) })
+		if i < 0 {
+			return name, ""
+		}
+		return name[:i], strings.TrimSpace(name[i:])
+	}
+	typeA, numberA := split(a)
+	typeB, numberB := split(b)
+	if numberA == "" || numberA != numberB || typeA == "" || typeB == "" {
+		return false
+	}
+	return strings.HasPrefix(typeA, typeB) || strings.HasPrefix(typeB, typeA)
+}
+
+// snmpDataSource returns a loaded snmp datasource
+func (s *DataSourceService) snmpDataSource(name string) (config.DataSourceConfig, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ds, ok := s.datasources[name]
+	return ds, ok && cmp.Or(ds.Type, SNMPPollerType) == SNMPPollerType
+}
+
+type discoveryTarget struct {
+	ds         config.DataSourceConfig
+	dataSource string // of seeds given as a datasource
+	depth      int
+}
+
+// DiscoverNeighbors walks the seeds of req and, up to req.Depth hops away, the neighbors they
+// report with a management address. Neighbors are walked with the SNMP settings of the device
+// reporting them. Devices which can't be walked are returned with their error.
+func (s *DataSourceService) DiscoverNeighbors(ctx context.Context, req DiscoveryRequest) ([]DiscoveredDevice, []Adjacency, error) {
+	if len(req.Seeds) == 0 {
+		return nil, nil, errors.New("invalid discovery: seeds are required")
+	}
+	if req.Depth < 0 || req.Depth > maxDiscoveryDepth {
+		return nil, nil, fmt.Errorf("invalid discovery: depth must be between 0 and %d", maxDiscoveryDepth)
+	}
+	var queue []discoveryTarget
+	for _, seed := range req.Seeds {
+		if ds, ok := s.snmpDataSource(seed); ok {
+			queue = append(queue, discoveryTarget{ds: ds, dataSource: ds.Name})
+			continue
+		}
+		if req.Community == "" {
+			return nil, nil, fmt.Errorf("invalid discovery: seed %s is not an snmp datasource, community is required", seed)
+		}
+		ds := config.DataSourceConfig{Name: seed, Type: SNMPPollerType, Params: map[string]interface{}{"host": seed, "community": req.Community}}
+		if _, err := datasource.ParseSNMPTarget(ds.Params); err != nil {
+			return nil, nil, fmt.Errorf("invalid discovery: seed %s: %w", seed, err)
+		}
+		queue = append(queue, discoveryTarget{ds: ds})
+	}
+
+	client := datasource.GetGlobalSNMPClient()
+	devices := []DiscoveredDevice{}
+	adjacencies := []Adjacency{}
+	walked := make(map[string]bool) // hosts and device names
+	for ; len(queue) > 0 && len(devices) < maxDiscoveryDevices; queue = queue[1:] {
+		target := queue[0]
+		host, _ := target.ds.Params["host"].(string)
+		if walked[host] || walked[strings.ToLower(target.ds.Name)] {
+			continue
+		}
+		walked[host] = true
+		device := DiscoveredDevice{Name: target.ds.Name, Address: host, DataSource: target.dataSource}
+		name, found, err := walkNeighbors(ctx, client, target.ds)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, ctx.Err()
+			}
+			device.Error = err.Error()
+			devices = append(devices, device)
+			continue
+		}
+		if walked[strings.ToLower(name)] { // reached before under another address
+			continue
+		}
+		walked[strings.ToLower(name)] = true
+		device.Name, device.Neighbors = name, len(found)
+		devices = append(devices, device)
+		adjacencies = append(adjacencies, found...)
+		if target.depth >= req.Depth {
+			continue
+		}
+		for _, adj := range found {
+			if adj.Address == "" {
+				continue
+			}
+			params := maps.Clone(target.ds.Params)
+			params["host"] = adj.Address
+			queue = append(queue, discoveryTarget{
+				ds:    config.DataSourceConfig{Name: adj.Neighbor, Type: SNMPPollerType, Params: params},
+				depth: target.depth + 1,
+			})
+		}
+	}
+	return devices, adjacencies, nil
+}
+
+// walkNeighbors reads the sysName of a device and the neighbors in its LLDP and CDP tables,
+// devices without one of the MIBs have empty tables
+func walkNeighbors(ctx context.Context, client *datasource.SNMPClient, ds config.DataSourceConfig) (string, []Adjacency, error) {
+	tables := make(map[string]map[string]interface{})
+	for _, oid := range []string{sysNameOID, ifNameOID, ifDescrOID, strings.TrimSuffix(ifHighSpeedOID, "."), lldpLocPortOID, lldpRemOID, lldpRemManAddrOID, cdpCacheOID} {
+		values, err := client.Walk(ctx, ds, oid)
+		if err != nil {
+			return "", nil, err
+		}
+		tables[oid] = values
+	}
+	name := deviceName(snmpText(tables[sysNameOID]["0"]))
+	if name == "" {
+		name = ds.Name
+	}
+
+	// ports are named by LLDP or ifName, their speed is found by name
+	speed := func(port string) int64 {
+		for ifIndex, value := range tables[ifNameOID] {
+			if samePort(snmpText(value), port) || samePort(snmpText(tables[ifDescrOID][ifIndex]), port) {
+				mbps, _ := strconv.ParseInt(snmpText(tables[strings.TrimSuffix(ifHighSpeedOID, ".")][ifIndex]), 10, 64)
+				return mbps
+			}
+		}
+		return 0
+	}
+
+	var found []Adjacency
+	rows, addresses := lldpRows(tables[lldpRemOID], tables[lldpRemManAddrOID])
+	for _, index := range slices.Sorted(maps.Keys(rows)) {
+		row := rows[index]
+		localPort := strings.Split(index, ".")[1]
+		neighbor := deviceName(snmpText(row["9"]))
+		if neighbor == "" {
+			neighbor = chassisID(row["5"])
+		}
+		if neighbor == "" {
+			continue
+		}
+		port := portName(tables[lldpLocPortOID]["3."+localPort], tables[lldpLocPortOID]["4."+localPort])
+		found = append(found, Adjacency{
+			Device:       name,
+			Port:         port,
+			Speed:        speed(port),
+			Neighbor:     neighbor,
+			NeighborPort: portName(row["7"], row["8"]),
+			Address:      addresses[index],
+			Protocol:     "lldp",
+		})
+	}
+
+	cdp := tableRows(tables[cdpCacheOID])
+	for _, index := range slices.Sorted(maps.Keys(cdp)) {
+		row := cdp[index]
+		neighbor := deviceName(snmpText(row["6"]))
+		if neighbor == "" {
+			continue
+		}
+		ifIndex := strings.Split(index, ".")[0]
+		adj := Adjacency{
+			Device:       name,
+			Port:         cmp.Or(snmpText(tables[ifNameOID][ifIndex]), snmpText(tables[ifDescrOID][ifIndex])),
+			Neighbor:     neighbor,
+			NeighborPort: snmpText(row["7"]),
+			Protocol:     "cdp",
+		}
+		if address, ok := row["4"].([]byte); ok && len(address) == net.IPv4len {
+			adj.Address = net.IP(address).String()
+		}
+		adj.Speed = speed(adj.Port)
+		found = append(found, adj)
+	}
+	return name, found, nil
+}
+
+// tableRows groups the columns of a walked table by the index of their row
+func tableRows(table map[string]interface{}) map[string]map[string]interface{} {
+	rows := make(map[string]map[string]interface{})
+	for oid, value := range table {
+		column, index, ok := strings.Cut(oid, ".")
+		if !ok {
+			continue
+		}
+		if rows[index] == nil {
+			rows[index] = make(map[string]interface{})
+		}
+		rows[index][column] = value
+	}
+	return rows
+}
+
+// lldpRows returns the rows of lldpRemTable, indexed by time mark, local port and remote index,
+// and the IPv4 management addresses of their neighbors
+func lldpRows(remote, manAddr map[string]interface{}) (map[string]map[string]interface{}, map[string]string) {
+	rows := tableRows(remote)
+	for index := range rows {
+		if strings.Count(index, ".") != 2 {
+			delete(rows, index)
+		}
+	}
+	addresses := make(map[string]string)
+	for oid := range manAddr {
+		parts := strings.Split(oid, ".")
+		// time mark, local port, remote index, address subtype 1 (ipv4), length 4, address
+		if len(parts) != 9 || parts[3] != "1" || parts[4] != "4" {
+			continue
+		}
+		if ip := net.ParseIP(strings.Join(parts[5:], ".")); ip != nil {
+			addresses[strings.Join(parts[:3], ".")] = ip.String()
+		}
+	}
+	return rows, addresses
+}
+
+// deviceName shortens a sysName or CDP device id to the name of its node: without the domain
+// and the serial number CDP may add in parentheses
+func deviceName(name string) string {
+	name = strings.TrimSpace(name)
+	if i := strings.IndexByte(name, '('); i > 0 {
+		name = strings.TrimSpace(name[:i])
+	}
+	if net.ParseIP(name) == nil {
+		name, _, _ = strings.Cut(name, ".")
+	}
+	return name
+}
+
+// portName picks the readable one of a port id and description, ids are often the short
+// interface name but may be a MAC address or an ifIndex
+func portName(id, description interface{}) string {
+	if text := snmpText(id); text != "" && strings.Trim(text, "0123456789") != "" {
+		return text
+	}
+	return cmp.Or(snmpText(description), snmpText(id))
+}
+
+func chassisID(value interface{}) string {
+	if text := snmpText(value); text != "" {
+		return text
+	}
+	if mac, ok := value.([]byte); ok && len(mac) == 6 {
+		return net.HardwareAddr(mac).String()
+	}
+	return ""
+}
+
+// snmpText returns a walked value as text, empty for binary octet strings
+func snmpText(value interface{}) string {
+	raw, ok := value.([]byte)
+	if !ok {
+		if value == nil {
+			return ""
+		}
+		return fmt.Sprint(value)
+	}
+	text := strings.TrimRight(string(raw), "\x00")
+	if !utf8.ValidString(text) || strings.IndexFunc(text, func(r rune) bool { return !unicode.IsPrint(r) }) >= 0 {
+		return ""
+	}
+	return strings.TrimSpace(text)
+}
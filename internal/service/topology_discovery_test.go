@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/snmpsim"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+// newLLDPSimulators returns lab-router, seeing lab-switch by LLDP on Gi0/0/0, and lab-switch,
+// seeing lab-router by CDP on Gi1/0/48
+func newLLDPSimulators(t *testing.T) (*snmpsim.Server, *snmpsim.Server) {
+	t.Helper()
+	router := newSimulator(t)
+	router.Set(lldpLocPortOID+".3.1", gosnmp.OctetString, []byte("Gi0/0/0"))
+	router.Set(lldpLocPortOID+".4.1", gosnmp.OctetString, []byte("GigabitEthernet0/0/0"))
+	router.Set(lldpRemOID+".5.0.1.1", gosnmp.OctetString, []byte{0x00, 0x1b, 0x54, 0xaa, 0xbb, 0xcc})
+	router.Set(lldpRemOID+".7.0.1.1", gosnmp.OctetString, []byte("Gi1/0/48"))
+	router.Set(lldpRemOID+".9.0.1.1", gosnmp.OctetString, []byte("lab-switch.example.com"))
+	router.Set(lldpRemManAddrOID+".0.1.1.1.4.192.0.2.10", gosnmp.Integer, 2)
+
+	sw, err := snmpsim.NewServer("public")
+	if err != nil {
+		t.Fatalf("Failed to start SNMP simulator: %v", err)
+	}
+	t.Cleanup(func() { _ = sw.Close() })
+	sw.Set(sysNameOID+".0", gosnmp.OctetString, []byte("lab-switch"))
+	sw.Set(ifNameOID+".48", gosnmp.OctetString, []byte("Gi1/0/48"))
+	sw.Set(cdpCacheOID+".4.48.1", gosnmp.OctetString, []byte{192, 0, 2, 1})
+	sw.Set(cdpCacheOID+".6.48.1", gosnmp.OctetString, []byte("lab-router(FOC1234X0AB)"))
+	sw.Set(cdpCacheOID+".7.48.1", gosnmp.OctetString, []byte("GigabitEthernet0/0/0"))
+	return router, sw
+}
+
+func TestDiscoverTopology(t *testing.T) {
+	router, sw := newLLDPSimulators(t)
+	routerDS := simDataSource(router, "public")
+	dsService := NewDataSourceService([]config.DataSourceConfig{routerDS})
+	mapService := NewMapService(t.TempDir())
+	if err := mapService.CreateMap(&config.Map{
+		Title: "lab", Width: 1000, Height: 600,
+		Nodes:       []config.Node{{Name: "lab-router", DataSource: routerDS.Name, Position: config.Position{X: 100, Y: 100}}},
+		Datasources: []config.DataSourceConfig{routerDS},
+	}, "lab"); err != nil {
+		t.Fatal(err)
+	}
+	req := DiscoveryRequest{Seeds: []string{routerDS.Name, fmt.Sprintf("%s:%d", sw.Host(), sw.Port())}, Community: "public"}
+
+	preview, err := mapService.DiscoverTopology(context.Background(), "lab", req, dsService, false)
+	if err != nil {
+		t.Fatalf("Discovery failed: %v", err)
+	}
+	if len(preview.Devices) != 2 || preview.Devices[0].Name != "lab-router" || preview.Devices[1].Name != "lab-switch" {
+		t.Fatalf("Expected lab-router and lab-switch walked, got %+v", preview.Devices)
+	}
+	if len(preview.Adjacencies) != 2 {
+		t.Fatalf("Expected an LLDP and a CDP adjacency, got %+v", preview.Adjacencies)
+	}
+	if lldp := preview.Adjacencies[0]; lldp.Port != "Gi0/0/0" || lldp.NeighborPort != "Gi1/0/48" || lldp.Address != "192.0.2.10" {
+		t.Errorf("Unexpected LLDP adjacency %+v", lldp)
+	}
+	if len(preview.NodesAdded) != 1 || preview.NodesAdded[0].Name != "lab-switch" {
+		t.Fatalf("Expected lab-switch added, got %+v", preview.NodesAdded)
+	}
+	// seen from both ends, added once, on the interface of the router datasource
+	want := DiscoveredLink{Name: "lab-router-lab-switch", From: "lab-router", FromPort: "Gi0/0/0", To: "lab-switch", ToPort: "Gi1/0/48", Bandwidth: "1G", DataSource: routerDS.Name, Interface: "Gi0/0/0"}
+	if len(preview.LinksAdded) != 1 || preview.LinksAdded[0] != want {
+		t.Fatalf("Expected link %+v, got %+v", want, preview.LinksAdded)
+	}
+	if m, _ := mapService.GetMap("lab"); preview.Applied || len(m.Nodes) != 1 || len(m.Links) != 0 {
+		t.Fatal("Expected the preview not to change the map")
+	}
+
+	applied, err := mapService.DiscoverTopology(context.Background(), "lab", req, dsService, true)
+	if err != nil || !applied.Applied {
+		t.Fatalf("Expected discovery applied, got %v", err)
+	}
+	m, _ := mapService.GetMap("lab")
+	if len(m.Nodes) != 2 || len(m.Links) != 1 || m.Links[0].Interface != "Gi0/0/0" {
+		t.Fatalf("Expected lab-switch and its link saved, got %+v %+v", m.Nodes, m.Links)
+	}
+	if i := slices.IndexFunc(m.Nodes, func(n config.Node) bool { return n.Name == "lab-switch" }); m.Nodes[i].Position.X > m.Width || m.Nodes[i].Position.Y > m.Height {
+		t.Errorf("Expected lab-switch placed on the map, got %+v", m.Nodes[i].Position)
+	}
+
+	// a second run finds the map up to date, prune removes a link no device reports
+	m.Links = append(m.Links, config.Link{Name: "stale", From: "lab-switch", To: "lab-router", Bandwidth: "1G"})
+	if _, err := mapService.ReplaceMap("lab", m); err != nil {
+		t.Fatal(err)
+	}
+	req.Prune = true
+	again, err := mapService.DiscoverTopology(context.Background(), "lab", req, dsService, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again.NodesAdded)+len(again.LinksAdded) != 0 || !slices.Equal(again.LinksUnchanged, []string{"lab-router-lab-switch"}) || !slices.Equal(again.LinksRemoved, []string{"stale"}) {
+		t.Errorf("Expected only the stale link removed, got %+v", again)
+	}
+	if m, _ := mapService.GetMap("lab"); len(m.Links) != 1 {
+		t.Errorf("Expected the stale link pruned, got %+v", m.Links)
+	}
+}
+
+func TestDiscoverTopologyErrors(t *testing.T) {
+	dsService := NewDataSourceService(nil)
+	mapService := NewMapService(t.TempDir())
+	if err := mapService.CreateMap(&config.Map{Title: "lab", Width: 100, Height: 100}, "lab"); err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		name    string
+		mapName string
+		req     DiscoveryRequest
+		want    string
+	}{
+		{"UnknownMap", "missing", DiscoveryRequest{Seeds: []string{"core-1"}, Community: "public"}, "not found"},
+		{"NoSeeds", "lab", DiscoveryRequest{}, "seeds are required"},
+		{"NoCommunity", "lab", DiscoveryRequest{Seeds: []string{"core-1"}}, "community is required"},
+		{"Depth", "lab", DiscoveryRequest{Seeds: []string{"core-1"}, Community: "public", Depth: 9}, "depth"},
+		{"BadHost", "lab", DiscoveryRequest{Seeds: []string{"core_1!"}, Community: "public"}, "invalid discovery"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := mapService.DiscoverTopology(context.Background(), tc.mapName, tc.req, dsService, false)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("Expected error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestSamePort(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		same bool
+	}{
+		{"Gi0/1", "GigabitEthernet0/1", true},
+		{"te1/1/1", "TenGigabitEthernet1/1/1", true},
+		{"ge-0/0/0", "ge-0/0/0", true},
+		{"Gi0/1", "Gi0/10", false},
+		{"Gi0/1", "Te0/1", false},
+		{"Gi0/1", "0/1", false},
+	} {
+		if got := samePort(tc.a, tc.b); got != tc.same {
+			t.Errorf("samePort(%s, %s) = %v, want %v", tc.a, tc.b, got, tc.same)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package service
+
+import "sync"
+
+// updateBroadcaster wakes up subscribers when pollers refresh their caches.
+// Signals are coalesced: a slow subscriber gets one pending wake up, not a backlog.
+type updateBroadcaster struct {
+	mu   sync.Mutex
+	subs map[chan struct{}]struct{}
+}
+
+func newUpdateBroadcaster() *updateBroadcaster {
+	return &updateBroadcaster{subs: make(map[chan struct{}]struct{})}
+}
+
+func (b *updateBroadcaster) subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs, ch)
+		b.mu.Unlock()
+	}
+}
+
+func (b *updateBroadcaster) notify() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// updateNotifier is implemented by pollers able to report cache refreshes
+type updateNotifier interface {
+	setUpdateHook(fn func())
+}
+
+// SubscribeUpdates returns a channel signalled after pollers refresh metrics
+// and a function to cancel the subscription.
+func (s *DataSourceService) SubscribeUpdates() (<-chan struct{}, func()) {
+	return s.updates.subscribe()
+}
+
+// mapBroadcaster keeps one updateBroadcaster per map name
+type mapBroadcaster struct {
+	mu     sync.Mutex
+	topics map[string]*updateBroadcaster
+}
+
+func newMapBroadcaster() *mapBroadcaster {
+	return &mapBroadcaster{topics: make(map[string]*updateBroadcaster)}
+}
+
+func (b *mapBroadcaster) topic(mapName string) *updateBroadcaster {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, ok := b.topics[mapName]
+	if !ok {
+		t = newUpdateBroadcaster()
+		b.topics[mapName] = t
+	}
+	return t
+}
+
+func (b *mapBroadcaster) subscribe(mapName string) (<-chan struct{}, func()) {
+	return b.topic(mapName).subscribe()
+}
+
+func (b *mapBroadcaster) notify(mapName string) {
+	b.topic(mapName).notify()
+}
+
+// SubscribeChanges returns a channel signalled after the map config is saved or deleted
+// and a function to cancel the subscription.
+func (s *MapService) SubscribeChanges(mapName string) (<-chan struct{}, func()) {
+	return s.changes.subscribe(mapName)
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const DefaultWarmupTimeout = 30 * time.Second
+
+// WarmupTimeoutFromEnv reads WEATHERMAP_WARMUP_TIMEOUT, 0 marks the server ready without
+// waiting for the first polls
+func WarmupTimeoutFromEnv() (time.Duration, error) {
+	value := os.Getenv("WEATHERMAP_WARMUP_TIMEOUT")
+	if value == "" {
+		return DefaultWarmupTimeout, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid WEATHERMAP_WARMUP_TIMEOUT: %s", value)
+	}
+	return timeout, nil
+}
+
+// Warm waits for the first poll of every datasource, then reads every map with its data once,
+// so calendars, anomaly baselines and interface lookups are loaded before the first request.
+// Maps failing to load are reported in the error, the others are warmed anyway.
+func (s *MapService) Warm(ctx context.Context, dsService *DataSourceService) error {
+	names, err := s.ListMaps()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	if err := dsService.WaitFirstPolls(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	// maps are read even when the polls took too long, the map deadline bounds each read
+	readCtx := context.WithoutCancel(ctx)
+	for _, name := range names {
+		if _, err := s.GetMapWithData(readCtx, name, dsService); err != nil {
+			errs = append(errs, fmt.Errorf("map %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
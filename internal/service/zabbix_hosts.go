@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"go-weathermap/internal/config"
+	"go-weathermap/internal/datasource"
+)
+
+type zabbixHostReader interface {
+	Hosts(ctx context.Context, ds config.DataSourceConfig, names []string) (map[string]datasource.ZabbixHost, error)
+}
+
+// CheckZabbixHosts reads the maintenance and availability of the Zabbix hosts of every node
+// with a zabbix reference, with one request by datasource
+func (s *MapService) CheckZabbixHosts(ctx context.Context) error {
+	mapNames, err := s.ListMaps()
+	if err != nil {
+		return err
+	}
+	byDataSource := make(map[string]map[string]bool)
+	for _, mapName := range mapNames {
+		mapConfig, err := s.loadMapConfig(mapName)
+		if err != nil {
+			continue
+		}
+		for _, node := range mapConfig.Nodes {
+			if node.Zabbix == nil {
+				continue
+			}
+			if byDataSource[node.Zabbix.Datasource] == nil {
+				byDataSource[node.Zabbix.Datasource] = make(map[string]bool)
+			}
+			byDataSource[node.Zabbix.Datasource][node.Zabbix.Host] = true
+		}
+	}
+	if len(byDataSource) == 0 {
+		s.nodeStatus.mu.Lock()
+		clear(s.nodeStatus.zabbixHosts)
+		s.nodeStatus.mu.Unlock()
+		return nil
+	}
+	all, err := LoadAllDataSources(s.configDir)
+	if err != nil {
+		all = ValidDataSources(all)
+	}
+
+	results := make(map[config.NodeZabbix]config.NodeData)
+	var errs []error
+	for dsName, hostSet := range byDataSource {
+		names := slices.Sorted(maps.Keys(hostSet))
+		unknown := func(format string, args ...any) {
+			for _, name := range names {
+				results[config.NodeZabbix{Datasource: dsName, Host: name}] = config.NodeData{Status: "unknown", Error: fmt.Sprintf(format, args...)}
+			}
+		}
+		i := slices.IndexFunc(all, func(ds config.DataSourceConfig) bool { return ds.Name == dsName })
+		if i < 0 || all[i].Type != "zabbix" {
+			unknown("zabbix datasource not found: %s", dsName)
+			continue
+		}
+		hosts, err := s.nodeStatus.zabbix.Hosts(ctx, all[i], names)
+		if err != nil {
+			unknown("%v", err)
+			errs = append(errs, fmt.Errorf("datasource %s: %w", dsName, err))
+			continue
+		}
+		now := time.Now()
+		for _, name := range names {
+			result := zabbixNodeData(hosts, name)
+			result.CheckedAt = &now
+			results[config.NodeZabbix{Datasource: dsName, Host: name}] = result
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	s.nodeStatus.mu.Lock()
+	s.nodeStatus.zabbixHosts = results
+	s.nodeStatus.mu.Unlock()
+	return errors.Join(errs...)
+}
+
+// zabbixNodeData maps the state of a host to a node status like Zabbix shows it: hosts in
+// maintenance first, disabled or not yet checked hosts as unknown
+func zabbixNodeData(hosts map[string]datasource.ZabbixHost, name string) config.NodeData {
+	host, ok := hosts[name]
+	switch {
+	case !ok:
+		return config.NodeData{Status: "unknown", Error: "host not found in zabbix: " + name}
+	case host.Maintenance:
+		return config.NodeData{Status: "maintenance"}
+	case !host.Monitored:
+		return config.NodeData{Status: "unknown", Error: "host is disabled in zabbix"}
+	case host.Availability == datasource.ZabbixAvailable:
+		return config.NodeData{Status: "up"}
+	case host.Availability == datasource.ZabbixUnavailable:
+		return config.NodeData{Status: "down", Error: "host is unavailable in zabbix"}
+	}
+	return config.NodeData{Status: "unknown"}
+}
@@ -0,0 +1,283 @@
+// Package snmpsim is a tiny SNMPv2c agent for tests. It serves values loaded from
+// snmpsim-style .snmprec fixtures ("oid|type|value") and can advance counters at
+// a fixed rate, so pollers can be exercised end to end without lab routers.
+package snmpsim
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+type value struct {
+	Type  gosnmp.Asn1BER
+	Value interface{}
+
+	// counters with a rate grow by Rate units per second since Since
+	Rate  float64
+	Since time.Time
+}
+
+type Server struct {
+	community string
+	conn      net.PacketConn
+	decoder   *gosnmp.GoSNMP
+
+	mu       sync.RWMutex
+	values   map[string]value
+	requests int
+	done     chan struct{}
+}
+
+func NewServer(community string) (*Server, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("snmpsim listen error: %w", err)
+	}
+	s := &Server{
+		community: community,
+		conn:      conn,
+		decoder:   &gosnmp.GoSNMP{Version: gosnmp.Version2c, Community: community, Logger: gosnmp.NewLogger(nil)},
+		values:    make(map[string]value),
+		done:      make(chan struct{}),
+	}
+	go s.serve()
+	return s, nil
+}
+
+func (s *Server) Host() string {
+	return s.conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
+
+func (s *Server) Port() int {
+	return s.conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func (s *Server) Close() error {
+	err := s.conn.Close()
+	<-s.done
+	return err
+}
+
+// Requests returns the number of SNMP packets answered so far
+func (s *Server) Requests() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.requests
+}
+
+func (s *Server) Set(oid string, typ gosnmp.Asn1BER, val interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[normalizeOID(oid)] = value{Type: typ, Value: val}
+}
+
+// SetCounter sets a Counter32/Counter64 that grows by rate units per second,
+// wrapping around like a real device does.
+func (s *Server) SetCounter(oid string, typ gosnmp.Asn1BER, start uint64, rate float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[normalizeOID(oid)] = value{Type: typ, Value: start, Rate: rate, Since: time.Now()}
+}
+
+func (s *Server) Delete(oid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, normalizeOID(oid))
+}
+
+func (s *Server) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.Load(f)
+}
+
+// Load reads .snmprec records: "1.3.6.1.2.1.1.3.0|67|123456". Lines starting with # are ignored.
+func (s *Server) Load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		parts := strings.SplitN(text, "|", 3)
+		if len(parts) != 3 {
+			return fmt.Errorf("snmprec line %d: expected oid|type|value", line)
+		}
+		tag, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return fmt.Errorf("snmprec line %d: invalid type %q", line, parts[1])
+		}
+		val, err := parseValue(gosnmp.Asn1BER(tag), parts[2])
+		if err != nil {
+			return fmt.Errorf("snmprec line %d: %w", line, err)
+		}
+		s.Set(parts[0], gosnmp.Asn1BER(tag), val)
+	}
+	return scanner.Err()
+}
+
+func parseValue(typ gosnmp.Asn1BER, raw string) (interface{}, error) {
+	switch typ {
+	case gosnmp.Integer:
+		return strconv.Atoi(raw)
+	case gosnmp.OctetString:
+		return []byte(raw), nil
+	case gosnmp.ObjectIdentifier, gosnmp.IPAddress:
+		return raw, nil
+	case gosnmp.Counter32, gosnmp.Gauge32, gosnmp.TimeTicks:
+		v, err := strconv.ParseUint(raw, 10, 32)
+		return uint32(v), err
+	case gosnmp.Counter64:
+		return strconv.ParseUint(raw, 10, 64)
+	}
+	return nil, fmt.Errorf("unsupported type %d", typ)
+}
+
+func (s *Server) serve() {
+	defer close(s.done)
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		request, err := s.decoder.SnmpDecodePacket(buf[:n])
+		if err != nil || request.Community != s.community {
+			continue // real agents silently drop bad communities
+		}
+		response := s.handle(request)
+		out, err := response.MarshalMsg()
+		if err != nil {
+			continue
+		}
+		_, _ = s.conn.WriteTo(out, addr)
+	}
+}
+
+func (s *Server) handle(request *gosnmp.SnmpPacket) *gosnmp.SnmpPacket {
+	s.mu.Lock()
+	s.requests++
+	s.mu.Unlock()
+
+	response := &gosnmp.SnmpPacket{
+		Version:   request.Version,
+		Community: request.Community,
+		PDUType:   gosnmp.GetResponse,
+		RequestID: request.RequestID,
+		Logger:    request.Logger,
+	}
+
+	switch request.PDUType {
+	case gosnmp.GetRequest:
+		for _, v := range request.Variables {
+			response.Variables = append(response.Variables, s.get(v.Name))
+		}
+	case gosnmp.GetNextRequest:
+		for _, v := range request.Variables {
+			response.Variables = append(response.Variables, s.next(v.Name))
+		}
+	case gosnmp.GetBulkRequest:
+		nonRepeaters := min(int(request.NonRepeaters), len(request.Variables))
+		for _, v := range request.Variables[:nonRepeaters] {
+			response.Variables = append(response.Variables, s.next(v.Name))
+		}
+		for _, v := range request.Variables[nonRepeaters:] {
+			oid := v.Name
+			for i := 0; i < int(request.MaxRepetitions); i++ {
+				pdu := s.next(oid)
+				response.Variables = append(response.Variables, pdu)
+				if pdu.Type == gosnmp.EndOfMibView {
+					break
+				}
+				oid = pdu.Name
+			}
+		}
+	default:
+		response.Error = gosnmp.GenErr
+	}
+	return response
+}
+
+func (s *Server) get(oid string) gosnmp.SnmpPDU {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	name := normalizeOID(oid)
+	v, ok := s.values[name]
+	if !ok {
+		// like an agent, noSuchInstance when the object has other instances
+		column := name[:strings.LastIndex(name, ".")+1]
+		for o := range s.values {
+			if strings.HasPrefix(o, column) {
+				return gosnmp.SnmpPDU{Name: name, Type: gosnmp.NoSuchInstance}
+			}
+		}
+		return gosnmp.SnmpPDU{Name: name, Type: gosnmp.NoSuchObject}
+	}
+	return v.pdu(name)
+}
+
+func (s *Server) next(oid string) gosnmp.SnmpPDU {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	name := normalizeOID(oid)
+	oids := make([]string, 0, len(s.values))
+	for o := range s.values {
+		oids = append(oids, o)
+	}
+	sort.Slice(oids, func(i, j int) bool { return compareOID(oids[i], oids[j]) < 0 })
+	for _, o := range oids {
+		if compareOID(o, name) > 0 {
+			return s.values[o].pdu(o)
+		}
+	}
+	return gosnmp.SnmpPDU{Name: name, Type: gosnmp.EndOfMibView}
+}
+
+func (v value) pdu(name string) gosnmp.SnmpPDU {
+	val := v.Value
+	if v.Rate != 0 {
+		current := v.Value.(uint64) + uint64(v.Rate*time.Since(v.Since).Seconds())
+		if v.Type == gosnmp.Counter32 {
+			val = uint32(current % (math.MaxUint32 + 1))
+		} else {
+			val = current
+		}
+	}
+	return gosnmp.SnmpPDU{Name: name, Type: v.Type, Value: val}
+}
+
+func normalizeOID(oid string) string {
+	return "." + strings.TrimPrefix(oid, ".")
+}
+
+func compareOID(a, b string) int {
+	pa := strings.Split(strings.TrimPrefix(a, "."), ".")
+	pb := strings.Split(strings.TrimPrefix(b, "."), ".")
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		na, _ := strconv.ParseUint(pa[i], 10, 64)
+		nb, _ := strconv.ParseUint(pb[i], 10, 64)
+		if na != nb {
+			if na < nb {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(pa) - len(pb)
+}
@@ -0,0 +1,243 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// spans are posted in batches every exportInterval or once maxBatch are queued
+	exportInterval = 5 * time.Second
+	maxBatch       = 512
+	// maxQueue spans wait for export, later ones are dropped while the collector is unreachable
+	maxQueue = 4096
+)
+
+// Tracer batches ended spans and posts them to the collector as OTLP JSON
+type Tracer struct {
+	cfg    Config
+	client *http.Client
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	queue   []*Span
+	dropped int
+
+	flush chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// New starts a tracer exporting to cfg.Endpoint, stop it with Shutdown
+func New(cfg Config) *Tracer {
+	t := &Tracer{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+		logger: slog.Default(),
+		flush:  make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+func (t *Tracer) SetLogger(logger *slog.Logger) {
+	t.logger = logger
+}
+
+func (t *Tracer) enqueue(span *Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.queue) >= maxQueue {
+		t.dropped++
+		return
+	}
+	t.queue = append(t.queue, span)
+	if len(t.queue) >= maxBatch {
+		select {
+		case t.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.flush:
+		case <-t.stop:
+			return
+		}
+		t.export(context.Background())
+	}
+}
+
+// Shutdown stops the export loop and sends the spans still queued
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	close(t.stop)
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	for {
+		t.mu.Lock()
+		pending := len(t.queue)
+		t.mu.Unlock()
+		if pending == 0 {
+			return nil
+		}
+		if err := t.export(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+func (t *Tracer) export(ctx context.Context) error {
+	t.mu.Lock()
+	batch := t.queue[:min(len(t.queue), maxBatch)]
+	t.queue = t.queue[len(batch):]
+	dropped := t.dropped
+	t.dropped = 0
+	t.mu.Unlock()
+	if dropped > 0 {
+		t.logger.Warn("trace spans dropped", "spans", dropped, "endpoint", t.cfg.Endpoint)
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(t.request(batch))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.cfg.Endpoint+"/v1/traces", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.client.Do(req)
+	if err == nil {
+		_ = resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			err = fmt.Errorf("collector responded %s", resp.Status)
+		}
+	}
+	if err != nil {
+		t.logger.Warn("trace export failed", "endpoint", t.cfg.Endpoint, "spans", len(batch), "error", err)
+	}
+	return err
+}
+
+// OTLP JSON encoding of ExportTraceServiceRequest, ids are hex and 64 bit integers strings
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"` // 2 is error
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+func (t *Tracer) request(batch []*Span) otlpRequest {
+	scope := otlpScopeSpans{Spans: make([]otlpSpan, 0, len(batch))}
+	scope.Scope.Name = "go-weathermap"
+	for _, s := range batch {
+		s.mu.Lock()
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(s.context.traceID[:]),
+			SpanID:            hex.EncodeToString(s.context.spanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        attributes(s.attributes),
+		}
+		if s.parentSpanID != [8]byte{} {
+			span.ParentSpanID = hex.EncodeToString(s.parentSpanID[:])
+		}
+		if s.err != "" {
+			span.Status = &otlpStatus{Code: 2, Message: s.err}
+		}
+		s.mu.Unlock()
+		scope.Spans = append(scope.Spans, span)
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: attributes([]any{"service.name", t.cfg.ServiceName})},
+		ScopeSpans: []otlpScopeSpans{scope},
+	}}}
+}
+
+// attributes converts key/value pairs, a key without a value is dropped
+func attributes(kv []any) []otlpAttribute {
+	var attrs []otlpAttribute
+	for i := 0; i+1 < len(kv); i += 2 {
+		key, ok := kv[i].(string)
+		if !ok {
+			continue
+		}
+		var value map[string]any
+		switch v := kv[i+1].(type) {
+		case string:
+			value = map[string]any{"stringValue": v}
+		case bool:
+			value = map[string]any{"boolValue": v}
+		case int:
+			value = map[string]any{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]any{"doubleValue": v}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		attrs = append(attrs, otlpAttribute{Key: key, Value: value})
+	}
+	return attrs
+}
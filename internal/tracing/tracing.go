@@ -0,0 +1,200 @@
+// Package tracing records OpenTelemetry spans, propagates them with the W3C traceparent
+// header and exports them to an OTLP/HTTP collector. Without a tracer set with SetDefault
+// spans are nil and every method on them is a no-op.
+package tracing
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// span kinds of the OTLP protocol
+const (
+	kindInternal = 1
+	kindServer   = 2
+	kindClient   = 3
+)
+
+// Config of the tracer
+type Config struct {
+	Endpoint    string  // base URL of the OTLP/HTTP collector, spans are posted to {Endpoint}/v1/traces
+	ServiceName string  // service.name resource attribute
+	SampleRatio float64 // share of the traces started here which are recorded
+}
+
+// ConfigFromEnv reads WEATHERMAP_OTLP_ENDPOINT, e.g. http://otel-collector:4318, and
+// WEATHERMAP_TRACE_SAMPLE_RATIO (0 to 1, default 1). Tracing is enabled when the endpoint is set.
+func ConfigFromEnv(serviceName string) (Config, bool, error) {
+	cfg := Config{
+		Endpoint:    strings.TrimRight(strings.TrimSpace(os.Getenv("WEATHERMAP_OTLP_ENDPOINT")), "/"),
+		ServiceName: serviceName,
+		SampleRatio: 1,
+	}
+	if value := strings.TrimSpace(os.Getenv("WEATHERMAP_TRACE_SAMPLE_RATIO")); value != "" {
+		ratio, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return cfg, false, fmt.Errorf("invalid WEATHERMAP_TRACE_SAMPLE_RATIO: %s", value)
+		}
+		cfg.SampleRatio = ratio
+	}
+	if cfg.Endpoint == "" {
+		return cfg, false, nil
+	}
+	return cfg, true, cfg.Validate()
+}
+
+func (c Config) Validate() error {
+	if !strings.HasPrefix(c.Endpoint, "http://") && !strings.HasPrefix(c.Endpoint, "https://") {
+		return fmt.Errorf("invalid OTLP endpoint: '%s', must be an http or https URL", c.Endpoint)
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return fmt.Errorf("invalid trace sample ratio: %v, must be between 0 and 1", c.SampleRatio)
+	}
+	return nil
+}
+
+var defaultTracer atomic.Pointer[Tracer]
+
+// SetDefault makes spans started by this package go to t, nil disables tracing
+func SetDefault(t *Tracer) {
+	defaultTracer.Store(t)
+}
+
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+}
+
+type contextKey struct{}
+
+func fromContext(ctx context.Context) (spanContext, bool) {
+	sc, ok := ctx.Value(contextKey{}).(spanContext)
+	return sc, ok
+}
+
+// Span is an operation of a trace, ended with End. A nil span records nothing.
+type Span struct {
+	tracer       *Tracer
+	name         string
+	kind         int
+	context      spanContext
+	parentSpanID [8]byte
+	start, end   time.Time
+
+	mu         sync.Mutex
+	attributes []any
+	err        string
+}
+
+// Start begins a span in the trace of ctx, or a new trace, with slog style key/value attributes
+func Start(ctx context.Context, name string, attrs ...any) (context.Context, *Span) {
+	return start(ctx, name, kindInternal, attrs)
+}
+
+// StartClient begins a span for a call to another system, pass the context to Inject
+func StartClient(ctx context.Context, name string, attrs ...any) (context.Context, *Span) {
+	return start(ctx, name, kindClient, attrs)
+}
+
+// StartServer begins the span of an incoming request, continuing the trace of its traceparent header
+func StartServer(r *http.Request, name string, attrs ...any) (context.Context, *Span) {
+	ctx := r.Context()
+	if sc, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+		ctx = context.WithValue(ctx, contextKey{}, sc)
+	}
+	return start(ctx, name, kindServer, attrs)
+}
+
+func start(ctx context.Context, name string, kind int, attrs []any) (context.Context, *Span) {
+	t := defaultTracer.Load()
+	if t == nil {
+		return ctx, nil
+	}
+	span := &Span{tracer: t, name: name, kind: kind, start: time.Now(), attributes: attrs}
+	if parent, ok := fromContext(ctx); ok {
+		span.context = parent
+		span.parentSpanID = parent.spanID
+	} else {
+		binary.BigEndian.PutUint64(span.context.traceID[:8], rand.Uint64())
+		binary.BigEndian.PutUint64(span.context.traceID[8:], rand.Uint64())
+		span.context.sampled = rand.Float64() < t.cfg.SampleRatio
+	}
+	binary.BigEndian.PutUint64(span.context.spanID[:], rand.Uint64()|1)
+	return context.WithValue(ctx, contextKey{}, span.context), span
+}
+
+// SetAttributes adds slog style key/value attributes to the span
+func (s *Span) SetAttributes(attrs ...any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attributes = append(s.attributes, attrs...)
+	s.mu.Unlock()
+}
+
+// RecordError marks the span as failed, nil errors are ignored
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.err = err.Error()
+	s.mu.Unlock()
+}
+
+// End finishes the span and queues it for export when its trace is sampled
+func (s *Span) End() {
+	if s == nil || !s.context.sampled {
+		return
+	}
+	s.end = time.Now()
+	s.tracer.enqueue(s)
+}
+
+// Inject sets the traceparent header of an outgoing request to the span of ctx
+func Inject(ctx context.Context, header http.Header) {
+	if sc, ok := fromContext(ctx); ok {
+		header.Set("traceparent", formatTraceparent(sc))
+	}
+}
+
+// traceparent is version-traceid-spanid-flags, e.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func formatTraceparent(sc spanContext) string {
+	flags := "00"
+	if sc.sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.traceID[:]) + "-" + hex.EncodeToString(sc.spanID[:]) + "-" + flags
+}
+
+func parseTraceparent(value string) (spanContext, bool) {
+	var sc spanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || parts[0] == "ff" || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.traceID[:], []byte(parts[1])); err != nil || sc.traceID == [16]byte{} {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.spanID[:], []byte(parts[2])); err != nil || sc.spanID == [8]byte{} {
+		return sc, false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return sc, false
+	}
+	sc.sampled = flags&1 == 1
+	return sc, true
+}
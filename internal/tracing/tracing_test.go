@@ -0,0 +1,131 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("WEATHERMAP_OTLP_ENDPOINT", "")
+	t.Setenv("WEATHERMAP_TRACE_SAMPLE_RATIO", "")
+	if _, enabled, err := ConfigFromEnv("weathermap"); enabled || err != nil {
+		t.Fatalf("Expected tracing disabled without endpoint, got %v %v", enabled, err)
+	}
+
+	t.Setenv("WEATHERMAP_OTLP_ENDPOINT", "http://collector:4318/")
+	t.Setenv("WEATHERMAP_TRACE_SAMPLE_RATIO", "0.25")
+	cfg, enabled, err := ConfigFromEnv("weathermap")
+	if !enabled || err != nil || cfg.Endpoint != "http://collector:4318" || cfg.SampleRatio != 0.25 {
+		t.Errorf("Expected tracing to the collector, got %+v %v %v", cfg, enabled, err)
+	}
+
+	t.Setenv("WEATHERMAP_TRACE_SAMPLE_RATIO", "2")
+	if _, _, err := ConfigFromEnv("weathermap"); err == nil {
+		t.Errorf("Expected sample ratio above 1 to be rejected")
+	}
+	t.Setenv("WEATHERMAP_TRACE_SAMPLE_RATIO", "")
+	t.Setenv("WEATHERMAP_OTLP_ENDPOINT", "collector:4318")
+	if _, _, err := ConfigFromEnv("weathermap"); err == nil {
+		t.Errorf("Expected endpoint without scheme to be rejected")
+	}
+}
+
+func TestSpansExportedToCollector(t *testing.T) {
+	var mu sync.Mutex
+	var spans []map[string]any
+	var service string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Expected OTLP JSON on /v1/traces, got %s %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		var req struct {
+			ResourceSpans []struct {
+				Resource struct {
+					Attributes []otlpAttribute `json:"attributes"`
+				} `json:"resource"`
+				ScopeSpans []struct {
+					Spans []map[string]any `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode export: %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range req.ResourceSpans {
+			service, _ = rs.Resource.Attributes[0].Value["stringValue"].(string)
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}))
+	defer collector.Close()
+
+	tracer := New(Config{Endpoint: collector.URL, ServiceName: "weathermap", SampleRatio: 0})
+	SetDefault(tracer)
+	defer SetDefault(nil)
+
+	// the caller sampled the trace, the ratio only applies to traces started here
+	incoming := httptest.NewRequest(http.MethodGet, "/maps/core", nil)
+	incoming.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, server := StartServer(incoming, "GET /maps/{name}")
+	ctx, child := Start(ctx, "MapService.GetMapWithData", "map", "core", "links", 3)
+	_, client := StartClient(ctx, "prometheus query")
+	header := http.Header{}
+	Inject(ctx, header)
+	client.RecordError(errors.New("connection refused"))
+	client.End()
+	child.End()
+	server.End()
+
+	if got := header.Get("traceparent"); !strings.HasPrefix(got, "00-4bf92f3577b34da6a3ce929d0e0e4736-") || !strings.HasSuffix(got, "-01") {
+		t.Errorf("Expected injected traceparent in the incoming trace, got %q", got)
+	}
+	_, unsampled := Start(context.Background(), "not sampled")
+	unsampled.End()
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Failed to flush spans: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(spans) != 3 || service != "weathermap" {
+		t.Fatalf("Expected 3 spans of weathermap, got %d of %q", len(spans), service)
+	}
+	byName := make(map[string]map[string]any)
+	for _, span := range spans {
+		if span["traceId"] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("Expected span %v in the incoming trace, got %v", span["name"], span["traceId"])
+		}
+		byName[span["name"].(string)] = span
+	}
+	if byName["GET /maps/{name}"]["parentSpanId"] != "00f067aa0ba902b7" || byName["GET /maps/{name}"]["kind"] != float64(kindServer) {
+		t.Errorf("Expected server span under the caller span, got %v", byName["GET /maps/{name}"])
+	}
+	if byName["MapService.GetMapWithData"]["parentSpanId"] != byName["GET /maps/{name}"]["spanId"] {
+		t.Errorf("Expected map span under the server span")
+	}
+	if status, _ := byName["prometheus query"]["status"].(map[string]any); status["code"] != float64(2) || status["message"] != "connection refused" {
+		t.Errorf("Expected error status on the client span, got %v", byName["prometheus query"])
+	}
+}
+
+func TestNilSpan(t *testing.T) {
+	SetDefault(nil)
+	ctx, span := Start(context.Background(), "disabled")
+	span.SetAttributes("key", "value")
+	span.RecordError(errors.New("ignored"))
+	span.End()
+	header := http.Header{}
+	Inject(ctx, header)
+	if span != nil || header.Get("traceparent") != "" {
+		t.Errorf("Expected no span nor traceparent without a tracer")
+	}
+}
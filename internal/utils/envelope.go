@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Envelope wraps every /api/v1 JSON response, exactly one of Data and Error is set
+type Envelope struct {
+	Data  any          `json:"data"`
+	Error *APIError    `json:"error"`
+	Meta  EnvelopeMeta `json:"meta"`
+}
+
+type APIError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type EnvelopeMeta struct {
+	RequestID  string      `json:"request_id"`
+	Pagination *Pagination `json:"pagination,omitempty"`
+}
+
+type Pagination struct {
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+	Total  int `json:"total"`
+}
+
+// EnvelopeWriter makes RespondWithJSON and RespondWithError wrap their payload in an Envelope.
+// Plain text errors written by http.Error or http.NotFound are converted on Finish.
+type EnvelopeWriter struct {
+	http.ResponseWriter
+	requestID  string
+	pagination *Pagination
+	plainCode  int
+	plainBody  bytes.Buffer
+}
+
+func NewEnvelopeWriter(w http.ResponseWriter, requestID string) *EnvelopeWriter {
+	return &EnvelopeWriter{ResponseWriter: w, requestID: requestID}
+}
+
+func (w *EnvelopeWriter) RequestID() string {
+	return w.requestID
+}
+
+// SetPagination is reported in the meta of the next response
+func (w *EnvelopeWriter) SetPagination(p Pagination) {
+	w.pagination = &p
+}
+
+func (w *EnvelopeWriter) meta() EnvelopeMeta {
+	return EnvelopeMeta{RequestID: w.requestID, Pagination: w.pagination}
+}
+
+func (w *EnvelopeWriter) WriteHeader(code int) {
+	if code >= http.StatusBadRequest && strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		w.plainCode = code
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *EnvelopeWriter) Write(b []byte) (int, error) {
+	if w.plainCode != 0 {
+		return w.plainBody.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Finish writes the envelope of a plain text error, call it after the handler returns
+func (w *EnvelopeWriter) Finish() {
+	if w.plainCode == 0 {
+		return
+	}
+	code := w.plainCode
+	w.plainCode = 0
+	RespondWithError(w, code, strings.TrimSpace(w.plainBody.String()))
+}
+
+func (w *EnvelopeWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *EnvelopeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection can't be hijacked")
+	}
+	return hijacker.Hijack()
+}
+
+func (w *EnvelopeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
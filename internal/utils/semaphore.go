@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Semaphore bounds the number of concurrent holders and tracks how many are waiting
+type Semaphore struct {
+	slots   chan struct{}
+	waiting atomic.Int64
+}
+
+func NewSemaphore(size int) *Semaphore {
+	if size < 1 {
+		size = 1
+	}
+	return &Semaphore{slots: make(chan struct{}, size)}
+}
+
+func (s *Semaphore) Acquire(ctx context.Context) error {
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	s.waiting.Add(1)
+	defer s.waiting.Add(-1)
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Semaphore) Release() {
+	<-s.slots
+}
+
+type SemaphoreUsage struct {
+	Max     int   `json:"max"`
+	InUse   int   `json:"in_use"`
+	Waiting int64 `json:"waiting"`
+}
+
+func (s *Semaphore) Usage() SemaphoreUsage {
+	return SemaphoreUsage{Max: cap(s.slots), InUse: len(s.slots), Waiting: s.waiting.Load()}
+}
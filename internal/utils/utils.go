@@ -8,10 +8,21 @@ import (
 )
 
 func RespondWithError(w http.ResponseWriter, code int, message string) {
+	if ew, ok := w.(*EnvelopeWriter); ok {
+		writeJSON(w, code, Envelope{Error: &APIError{Code: code, Message: message}, Meta: ew.meta()})
+		return
+	}
 	RespondWithJSON(w, code, map[string]string{"error": message})
 }
 
 func RespondWithJSON(w http.ResponseWriter, code int, payload any) {
+	if ew, ok := w.(*EnvelopeWriter); ok {
+		payload = Envelope{Data: payload, Meta: ew.meta()}
+	}
+	writeJSON(w, code, payload)
+}
+
+func writeJSON(w http.ResponseWriter, code int, payload any) {
 	response, _ := json.Marshal(payload)
 
 	w.Header().Set("Content-Type", "application/json")
@@ -25,7 +36,10 @@ func ParseBandwidth(bw string) int64 {
 	}
 	bw = strings.ToUpper(strings.TrimSpace(bw))
 	mult := int64(1_000_000)
-	if strings.HasSuffix(bw, "G") {
+	if strings.HasSuffix(bw, "T") {
+		mult = 1_000_000_000_000
+		bw = strings.TrimSuffix(bw, "T")
+	} else if strings.HasSuffix(bw, "G") {
 		mult = 1_000_000_000
 		bw = strings.TrimSuffix(bw, "G")
 	} else if strings.HasSuffix(bw, "M") {
@@ -0,0 +1,52 @@
+// Package version reports the build of the binary
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Version and Commit are set at build time with
+// -ldflags "-X go-weathermap/internal/version.Version=v1.2.0 -X go-weathermap/internal/version.Commit=abc123",
+// Commit defaults to the VCS revision recorded by the Go toolchain
+var (
+	Version = "dev"
+	Commit  = ""
+)
+
+// Info is the build of the running binary
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"build_time,omitempty"` // of the commit, RFC 3339
+	Modified  bool   `json:"modified,omitempty"`   // built from a tree with uncommitted changes
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+// Get returns the build info of the binary
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = setting.Value
+			}
+		case "vcs.time":
+			info.BuildTime = setting.Value
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+	return info
+}
@@ -0,0 +1,58 @@
+package weathermap
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"go-weathermap/internal/service"
+)
+
+// ProcessorOptions configure a Processor, the zero value works
+type ProcessorOptions struct {
+	// Deadline bounds reading the metrics of a map, slower links are left unknown. 0 uses the
+	// deadline of the server, 3s, a negative one waits for every link.
+	Deadline time.Duration
+	Logger   *slog.Logger // slog.Default() when nil
+}
+
+// Processor polls datasources in the background and computes the data of maps from the
+// values polled, like the server does for GET /maps/{name}
+type Processor struct {
+	maps        *service.MapService
+	datasources *service.DataSourceService
+}
+
+// NewProcessor creates a processor for the datasources, Start begins polling them
+func NewProcessor(datasources []DataSourceConfig, opts ProcessorOptions) *Processor {
+	maps := service.NewMapService("")
+	dsService := service.NewDataSourceService(datasources)
+	if opts.Logger != nil {
+		maps.SetLogger(opts.Logger)
+		dsService.SetLogger(opts.Logger)
+	}
+	switch {
+	case opts.Deadline < 0:
+		maps.SetMapDeadline(0)
+	case opts.Deadline > 0:
+		maps.SetMapDeadline(opts.Deadline)
+	}
+	return &Processor{maps: maps, datasources: dsService}
+}
+
+// Start polls the datasources until Stop
+func (p *Processor) Start() {
+	p.datasources.Start()
+}
+
+// Stop stops polling, waiting for the polls in flight until ctx is done
+func (p *Processor) Stop(ctx context.Context) error {
+	return p.datasources.Stop(ctx)
+}
+
+// Process computes the data of the links and nodes of a map from the last values polled.
+// Links of datasources the processor doesn't know are reported like the server does, with an
+// unknown status. The name identifies the map in logs and utilization reports.
+func (p *Processor) Process(ctx context.Context, name string, m *Map) *MapWithData {
+	return p.maps.ProcessMap(ctx, name, m, p.datasources)
+}
@@ -0,0 +1,54 @@
+package weathermap
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"go-weathermap/internal/assets"
+	"go-weathermap/internal/render"
+)
+
+// IconLoader returns the content of a node icon and its content type
+type IconLoader = render.IconLoader
+
+// PDF page layouts, see PDFOptions
+type PDFOptions = render.PDFOptions
+
+// Icons loads the icons of dir, then the ones shipped with the server like the server does.
+// An empty dir only loads the shipped ones.
+func Icons(dir string) IconLoader {
+	return func(name string) ([]byte, string, error) {
+		if name != path.Base(name) {
+			return nil, "", fmt.Errorf("icon not found: %s", name)
+		}
+		if dir != "" {
+			if data, err := os.ReadFile(filepath.Join(dir, name)); err == nil {
+				return data, "image/svg+xml", nil
+			}
+		}
+		if data, err := fs.ReadFile(assets.Icons, path.Join("icons", name)); err == nil {
+			return data, "image/svg+xml", nil
+		}
+		return nil, "", fmt.Errorf("icon not found: %s", name)
+	}
+}
+
+// RenderSVG draws a processed map as SVG
+func RenderSVG(w io.Writer, m *MapWithData, icons IconLoader) error {
+	return render.NewSVGRenderer(icons).Render(w, m)
+}
+
+// RenderPNG draws a processed map as PNG scaled to width x height, a zero width or height
+// keeps the aspect ratio of the map
+func RenderPNG(w io.Writer, m *MapWithData, icons IconLoader, width, height int) error {
+	return render.NewPNGRenderer(icons).Render(w, m, width, height)
+}
+
+// RenderPDF lays out a processed map on a printable page followed by a legend page
+func RenderPDF(w io.Writer, m *MapWithData, icons IconLoader, opts PDFOptions) error {
+	return render.NewPDFRenderer(icons).Render(w, m, opts)
+}
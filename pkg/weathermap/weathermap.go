@@ -0,0 +1,60 @@
+// Package weathermap embeds map processing and rendering in other Go programs, without the
+// HTTP server. Maps are parsed from the YAML of the maps directory, a Processor polls their
+// datasources and computes the data of links and nodes, renderers draw the result.
+//
+// The types are the ones of the server, so maps behave the same in both. The functions and
+// types of this package are the stable API, the packages under internal/ may change.
+package weathermap
+
+import (
+	"fmt"
+	"io"
+
+	"go-weathermap/internal/config"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The map model, as written in the YAML of a map
+type (
+	Map              = config.Map
+	Node             = config.Node
+	Link             = config.Link
+	Position         = config.Position
+	Scale            = config.Scale
+	Color            = config.Color
+	DataSourceConfig = config.DataSourceConfig
+	InterfaceConfig  = config.InterfaceConfig
+)
+
+// The data of a processed map, as returned by GET /maps/{name}
+type (
+	MapWithData = config.MapWithData
+	LinkData    = config.LinkData
+	NodeData    = config.NodeData
+)
+
+// ParseMap reads a map in the YAML of the maps directory and validates it
+func ParseMap(r io.Reader) (*Map, error) {
+	parser := config.NewParser()
+	m, err := parser.ParseYAML(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid YAML: %w", err)
+	}
+	if err := parser.Validate(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ValidateMap checks a map like the server does before saving it, the error joins every
+// problem found
+func ValidateMap(m *Map) error {
+	return config.NewParser().Validate(m)
+}
+
+// MarshalMap writes a map in the YAML of the maps directory, leaving out the values equal to
+// its defaults
+func MarshalMap(m *Map) ([]byte, error) {
+	return yaml.Marshal(m.WithoutDefaults())
+}
@@ -0,0 +1,87 @@
+package weathermap_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"go-weathermap/pkg/weathermap"
+)
+
+const labMap = `
+title: Lab
+width: 200
+height: 100
+nodes:
+  - name: a
+    icon: router.svg
+    position: {x: 40, y: 50}
+  - name: b
+    position: {x: 160, y: 50}
+links:
+  - name: a-b
+    from: a
+    to: b
+    bandwidth: 1G
+    datasource: lab
+    interface: eth0
+    metrics: [in, out]
+datasources:
+  - name: lab
+    type: mock
+    poll_interval: 1
+    interfaces:
+      - name: eth0
+`
+
+func TestProcessAndRender(t *testing.T) {
+	m, err := weathermap.ParseMap(strings.NewReader(labMap))
+	if err != nil {
+		t.Fatalf("ParseMap failed: %v", err)
+	}
+	processor := weathermap.NewProcessor(m.Datasources, weathermap.ProcessorOptions{})
+	processor.Start()
+	defer func() { _ = processor.Stop(context.Background()) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	data := processor.Process(context.Background(), "lab", m)
+	for data.LinksData[0].Status != "up" {
+		if time.Now().After(deadline) {
+			t.Fatalf("Link not up after 5s: %+v", data.LinksData[0])
+		}
+		time.Sleep(100 * time.Millisecond)
+		data = processor.Process(context.Background(), "lab", m)
+	}
+
+	var svg bytes.Buffer
+	if err := weathermap.RenderSVG(&svg, data, weathermap.Icons("")); err != nil {
+		t.Fatalf("RenderSVG failed: %v", err)
+	}
+	if !strings.HasPrefix(svg.String(), "<svg") || !strings.Contains(svg.String(), `<path id="link-`) || !strings.Contains(svg.String(), "data:image/svg+xml") {
+		t.Errorf("Expected the link and the embedded icon drawn, got %.200s", svg.String())
+	}
+	var png bytes.Buffer
+	if err := weathermap.RenderPNG(&png, data, weathermap.Icons(""), 0, 0); err != nil || !bytes.HasPrefix(png.Bytes(), []byte("\x89PNG")) {
+		t.Errorf("Expected a PNG, got %v", err)
+	}
+
+	content, err := weathermap.MarshalMap(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := weathermap.ParseMap(bytes.NewReader(content)); err != nil {
+		t.Errorf("Expected the marshalled map parsed again, got %v", err)
+	}
+}
+
+func TestParseMapValidates(t *testing.T) {
+	_, err := weathermap.ParseMap(strings.NewReader(strings.Replace(labMap, "to: b", "to: c", 1)))
+	if err == nil || !strings.Contains(err.Error(), "unknown node: c") {
+		t.Errorf("Expected a link to an unknown node rejected, got %v", err)
+	}
+	if _, err := weathermap.ParseMap(strings.NewReader("title: [")); err == nil {
+		t.Error("Expected invalid YAML rejected")
+	}
+}